@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/maintenance"
+	"live-collab-api/internal/websocket"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Passwords are held to the same length limits as at sign-up.
+const (
+	minPasswordLength = 6
+	maxPasswordLength = 72
+)
+
+// newFlagSet returns the flag set of a command taking the positional
+// arguments described by arguments.
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: admin %s [flags] %s\n\n%s\n", name, arguments, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args and checks there are n positional arguments.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// readPassword returns the first line of stdin when fromStdin is set, and
+// otherwise a new random password, which is then printed since nobody
+// else knows it.
+func readPassword(fromStdin bool) (password string, generated bool, err error) {
+	if !fromStdin {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return "", false, fmt.Errorf("failed to generate password: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b), true, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", false, fmt.Errorf("failed to read password: %v", err)
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if n := len(password); n < minPasswordLength || n > maxPasswordLength || !utf8.ValidString(password) {
+		return "", false, fmt.Errorf("password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	}
+	return password, false, nil
+}
+
+func userByEmail(ctx context.Context, database *sql.DB, email string) (int, error) {
+	var id int
+	err := database.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find user: %v", err)
+	}
+	return id, nil
+}
+
+func createAdmin(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("create-admin", "EMAIL", "Create a user with the admin role, or give an existing user the admin role and keep their password.")
+	passwordStdin := fs.Bool("password-stdin", false, "read the new user's password from stdin instead of generating one")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	email := fs.Arg(0)
+	e.connect(ctx)
+
+	result, err := e.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE email = $2", auth.RoleAdmin, email)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if n > 0 {
+		fmt.Printf("%s is now an admin\n", email)
+		return nil
+	}
+
+	password, generated, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	var id int
+	err = e.db.QueryRowContext(ctx, "INSERT INTO users (email, password, role) VALUES ($1, $2, $3) RETURNING id", email, hash, auth.RoleAdmin).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %v", err)
+	}
+
+	fmt.Printf("created admin %s with id %d\n", email, id)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+	return nil
+}
+
+func resetPassword(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("reset-password", "EMAIL", "Set a new password for a user. Tokens issued before stay valid until they expire.")
+	passwordStdin := fs.Bool("password-stdin", false, "read the new password from stdin instead of generating one")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	email := fs.Arg(0)
+
+	password, generated, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	e.connect(ctx)
+	result, err := e.db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE email = $2", hash, email)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if n == 0 {
+		return fmt.Errorf("no user with email %s", email)
+	}
+
+	fmt.Printf("reset the password of %s\n", email)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+	return nil
+}
+
+func transferOwnership(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("transfer-ownership", "DOCUMENT EMAIL", "Make another user the owner of a document. The previous owner loses access unless added back as a collaborator.")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	documentId, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid document id %q", fs.Arg(0))
+	}
+
+	e.connect(ctx)
+	userId, err := userByEmail(ctx, e.db, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := e.documents.TransferOwnership(ctx, documentId, userId); err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			return fmt.Errorf("no document with id %d", documentId)
+		}
+		return err
+	}
+
+	fmt.Printf("%s now owns document %d\n", fs.Arg(1), documentId)
+	return nil
+}
+
+func rebuild(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("rebuild", "DOCUMENT", `Replay a document's edit history from the content it was created with and report
+whether the result matches the stored content. Documents edited before their initial
+content was recorded are replayed from empty content, and can't be rewritten.
+-write needs maintenance mode on, since a server holding the document in memory
+would otherwise go on editing its own copy.`)
+	write := fs.Bool("write", false, "replace the stored content with the rebuilt content")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	documentId, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid document id %q", fs.Arg(0))
+	}
+
+	if *write {
+		if err := requireMaintenance(ctx, e.cfg); err != nil {
+			return err
+		}
+	}
+
+	e.connect(ctx)
+	encrypted, err := e.documents.IsEncrypted(ctx, documentId)
+	if err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			return fmt.Errorf("no document with id %d", documentId)
+		}
+		return err
+	}
+	if encrypted {
+		return fmt.Errorf("document %d is end-to-end encrypted; its edits can't be read", documentId)
+	}
+
+	var stored string
+	if err := e.db.QueryRowContext(ctx, "SELECT COALESCE(content, '') FROM documents WHERE id = $1", documentId).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to get document content: %v", err)
+	}
+	content, version, seeded, err := websocket.RebuildContent(ctx, e.db, documentId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("document %d: rebuilt %d characters from %d versions; stored content has %d characters\n",
+		documentId, utf8.RuneCountInString(content), version, utf8.RuneCountInString(stored))
+	if !seeded {
+		fmt.Println("the content the document was created with isn't known, so it was rebuilt from empty content")
+	}
+	if content == stored {
+		fmt.Println("stored content matches its history")
+		return nil
+	}
+	if !*write {
+		fmt.Println("stored content differs from its history; run again with -write during maintenance to replace it")
+		return nil
+	}
+	if !seeded {
+		return fmt.Errorf("not replacing the content of document %d without its initial content", documentId)
+	}
+
+	// content_version tells servers loading the document that no stored
+	// edit is missing from the content
+	_, err = e.db.ExecContext(ctx, "UPDATE documents SET content = $1, content_version = $2, updated_at = NOW() WHERE id = $3", content, version, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to update document: %v", err)
+	}
+	e.documents.Cache.InvalidateDocument(ctx, documentId)
+	fmt.Println("replaced stored content with the rebuilt content")
+	return nil
+}
+
+// requireMaintenance returns an error unless maintenance mode is on for
+// every server, so none of them changes documents meanwhile. That can only
+// be checked through the Redis the servers share it over.
+func requireMaintenance(ctx context.Context, cfg *config.Config) error {
+	client, err := connectRedis(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("can't check maintenance mode without Redis: %v", err)
+	}
+	defer client.Close()
+
+	state, err := maintenance.Load(ctx, client)
+	if err != nil {
+		return err
+	}
+	if !state.Enabled {
+		return errors.New("-write needs maintenance mode on; enable it with PUT /api/admin/maintenance first")
+	}
+	return nil
+}
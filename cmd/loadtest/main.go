@@ -0,0 +1,317 @@
+// Command loadtest measures how the WebSocket edit pipeline holds up under
+// load. It opens many clients across a few documents on a running server,
+// has each of them send edits at a steady rate, and reports how long edits
+// took to be acknowledged and delivered to the other clients, and how many
+// were rejected or never arrived.
+//
+//	go run ./cmd/loadtest -url http://localhost:8080 -clients 200 -documents 10 -duration 1m
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type options struct {
+	url       string
+	token     string
+	password  string
+	clients   int
+	documents int
+	rate      float64
+	duration  time.Duration
+	drain     time.Duration
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.url, "url", "http://localhost:8080", "base URL of the server")
+	flag.StringVar(&opts.token, "token", "", "JWT to connect with; by default a new user is registered")
+	flag.StringVar(&opts.password, "password", "loadtest123", "password of the registered user")
+	flag.IntVar(&opts.clients, "clients", 50, "number of WebSocket clients")
+	flag.IntVar(&opts.documents, "documents", 5, "number of documents the clients are spread across")
+	flag.Float64Var(&opts.rate, "rate", 2, "edits per second sent by each client")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to send edits for")
+	flag.DurationVar(&opts.drain, "drain", 3*time.Second, "how long to wait for outstanding edits after sending stops")
+	flag.Parse()
+
+	if opts.clients <= 0 || opts.documents <= 0 || opts.rate <= 0 || opts.duration <= 0 {
+		fmt.Fprintln(os.Stderr, "clients, documents, rate, and duration must be positive")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts options) error {
+	api := &apiClient{baseURL: strings.TrimSuffix(opts.url, "/")}
+
+	token := opts.token
+	if token == "" {
+		email := fmt.Sprintf("loadtest-%d@example.com", time.Now().UnixNano())
+		var err error
+		if token, err = api.signUp(email, opts.password); err != nil {
+			return err
+		}
+		fmt.Printf("registered %s\n", email)
+	}
+
+	documentIds := make([]int, opts.documents)
+	for i := range documentIds {
+		id, err := api.createDocument(token, fmt.Sprintf("Load test %d", i+1))
+		if err != nil {
+			return err
+		}
+		documentIds[i] = id
+	}
+
+	stats := newStats()
+	clients := make([]*client, 0, opts.clients)
+	for i := 0; i < opts.clients; i++ {
+		c := &client{id: i, documentId: documentIds[i%len(documentIds)], stats: stats}
+		if err := c.connect(api.websocketURL(c.documentId), token); err != nil {
+			stats.connectFailed(err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if len(clients) == 0 {
+		return errors.New("no client could connect")
+	}
+	fmt.Printf("connected %d of %d clients to %d documents, sending for %s\n", len(clients), opts.clients, len(documentIds), opts.duration)
+
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			c.read()
+		}()
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	interval := time.Duration(float64(time.Second) / opts.rate)
+
+	var writers sync.WaitGroup
+	for _, c := range clients {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			c.send(sendCtx, interval)
+		}()
+	}
+	writers.Wait()
+
+	// let edits still in flight arrive before counting them as dropped
+	select {
+	case <-time.After(opts.drain):
+	case <-ctx.Done():
+	}
+	for _, c := range clients {
+		c.close()
+	}
+	readers.Wait()
+
+	stats.report(os.Stdout)
+	return nil
+}
+
+// apiClient makes the REST calls that set up a run.
+type apiClient struct {
+	baseURL string
+}
+
+func (a *apiClient) websocketURL(documentId int) string {
+	return fmt.Sprintf("ws%s/api/documents/%d/ws", strings.TrimPrefix(a.baseURL, "http"), documentId)
+}
+
+// signUp registers a user and returns a token for them.
+func (a *apiClient) signUp(email, password string) (string, error) {
+	credentials := map[string]string{"email": email, "password": password}
+	if err := a.post("/register", "", credentials, http.StatusCreated, nil); err != nil {
+		return "", err
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := a.post("/login", "", credentials, http.StatusOK, &login); err != nil {
+		return "", err
+	}
+	return login.Token, nil
+}
+
+func (a *apiClient) createDocument(token, title string) (int, error) {
+	var document struct {
+		ID int `json:"id"`
+	}
+	if err := a.post("/api/documents", token, map[string]string{"title": title}, http.StatusCreated, &document); err != nil {
+		return 0, err
+	}
+	return document.ID, nil
+}
+
+// post sends body as JSON and decodes the response into out unless it is
+// nil. Any status other than expected is an error.
+func (a *apiClient) post(path, token string, body interface{}, expected int, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", a.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("POST %s returned %d: %s", path, resp.StatusCode, failure.Error)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// client is one simulated collaborator. It alternates between inserting
+// two characters at the start of the document and deleting them again, so
+// documents don't grow over a long run.
+type client struct {
+	id         int
+	documentId int
+	stats      *stats
+
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	closing   atomic.Bool
+}
+
+func (c *client) connect(url, token string) error {
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%v (status %d)", err, resp.StatusCode)
+		}
+		return err
+	}
+	c.conn = conn
+	c.stats.joined(c.documentId)
+	return nil
+}
+
+func (c *client) send(ctx context.Context, interval time.Duration) {
+	// stagger clients so their edits don't arrive in bursts
+	select {
+	case <-time.After(interval * time.Duration(c.id%64) / 64):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for seq := 0; ; seq++ {
+		payload := map[string]interface{}{"operation": "insert", "position": 0, "content": "ab"}
+		if seq%2 == 1 {
+			payload = map[string]interface{}{"operation": "delete", "position": 0, "length": 2}
+		}
+		messageId := fmt.Sprintf("loadtest-%d-%d", c.id, seq)
+
+		c.stats.sent(messageId)
+		c.writeLock.Lock()
+		c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		err := c.conn.WriteJSON(map[string]interface{}{"type": "edit", "message_id": messageId, "payload": payload})
+		c.writeLock.Unlock()
+		if err != nil {
+			c.stats.sendFailed(messageId)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read records every edit and error that reaches the client until the
+// connection closes. The server may batch several messages into one
+// frame, one per line.
+func (c *client) read() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.stats.left(c.documentId, c.closing.Load())
+			return
+		}
+
+		received := time.Now()
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var message struct {
+				Type      string `json:"type"`
+				MessageId string `json:"message_id"`
+				Payload   struct {
+					Code string `json:"code"`
+				} `json:"payload"`
+			}
+			if json.Unmarshal(line, &message) != nil || !strings.HasPrefix(message.MessageId, "loadtest-") {
+				continue
+			}
+
+			switch message.Type {
+			case "edit":
+				c.stats.delivered(message.MessageId, c.documentId, c.ownMessage(message.MessageId), received)
+			case "error":
+				c.stats.rejected(message.MessageId, message.Payload.Code)
+			}
+		}
+	}
+}
+
+func (c *client) ownMessage(messageId string) bool {
+	return strings.HasPrefix(messageId, fmt.Sprintf("loadtest-%d-", c.id))
+}
+
+func (c *client) close() {
+	c.closing.Store(true)
+	c.writeLock.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+	c.conn.Close()
+}
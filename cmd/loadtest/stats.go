@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// stats collects what the clients of a run observed.
+type stats struct {
+	mutex sync.Mutex
+
+	sentAt    map[string]time.Time
+	connected map[int]int
+
+	connectFailures []error
+	sentCount       int
+	sendFailures    int
+	acknowledged    int
+	rejections      map[string]int
+	expected        int
+	deliveries      int
+	disconnects     int
+
+	ackLatency      []time.Duration
+	deliveryLatency []time.Duration
+}
+
+func newStats() *stats {
+	return &stats{
+		sentAt:     make(map[string]time.Time),
+		connected:  make(map[int]int),
+		rejections: make(map[string]int),
+	}
+}
+
+func (s *stats) connectFailed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connectFailures = append(s.connectFailures, err)
+}
+
+func (s *stats) joined(documentId int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected[documentId]++
+}
+
+// left records a closed connection. Connections the server closed before
+// the run ended count as disconnects.
+func (s *stats) left(documentId int, closedByUs bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected[documentId]--
+	if !closedByUs {
+		s.disconnects++
+	}
+}
+
+func (s *stats) sent(messageId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sentAt[messageId] = time.Now()
+	s.sentCount++
+}
+
+func (s *stats) sendFailed(messageId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sentAt, messageId)
+	s.sendFailures++
+}
+
+// delivered records an edit broadcast reaching a client. The server echoes
+// edits to their sender once applied, so that copy is the acknowledgement,
+// and from then on every client connected to the document is expected to
+// receive it.
+func (s *stats) delivered(messageId string, documentId int, own bool, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sentAt, ok := s.sentAt[messageId]
+	if !ok {
+		return
+	}
+	s.deliveries++
+	if own {
+		s.acknowledged++
+		s.expected += s.connected[documentId]
+		s.ackLatency = append(s.ackLatency, at.Sub(sentAt))
+	} else {
+		s.deliveryLatency = append(s.deliveryLatency, at.Sub(sentAt))
+	}
+}
+
+func (s *stats) rejected(messageId, code string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.sentAt[messageId]; ok {
+		s.rejections[code]++
+	}
+}
+
+func (s *stats) report(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rejected := 0
+	for _, count := range s.rejections {
+		rejected += count
+	}
+
+	fmt.Fprintln(w)
+	if len(s.connectFailures) > 0 {
+		fmt.Fprintf(w, "connect failures:  %d (first: %v)\n", len(s.connectFailures), s.connectFailures[0])
+	}
+	fmt.Fprintf(w, "disconnects:       %d\n", s.disconnects)
+	fmt.Fprintf(w, "edits sent:        %d (%d failed to send)\n", s.sentCount, s.sendFailures)
+	fmt.Fprintf(w, "acknowledged:      %d\n", s.acknowledged)
+	fmt.Fprintf(w, "rejected:          %d", rejected)
+	for _, code := range slices.Sorted(maps.Keys(s.rejections)) {
+		fmt.Fprintf(w, " %s=%d", code, s.rejections[code])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "unanswered:        %d\n", max(s.sentCount-s.sendFailures-s.acknowledged-rejected, 0))
+	fmt.Fprintf(w, "deliveries:        %d of %d (%d dropped)\n", s.deliveries, s.expected, max(s.expected-s.deliveries, 0))
+	fmt.Fprintf(w, "ack latency:       %s\n", percentiles(s.ackLatency))
+	fmt.Fprintf(w, "delivery latency:  %s\n", percentiles(s.deliveryLatency))
+}
+
+// percentiles summarizes latencies as p50, p90, p99, and max.
+func percentiles(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "n/a"
+	}
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", at(0.5), at(0.9), at(0.99), sorted[len(sorted)-1].Round(10*time.Microsecond))
+}
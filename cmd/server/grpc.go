@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/grpcapi"
+	"live-collab-api/internal/maintenance"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// startGRPC serves the internal gRPC API on cfg.GRPCAddr with the same TLS
+// setup as httpServer, which must already be configured. It returns a
+// function that stops the server, or nil when the API is disabled.
+func startGRPC(cfg *config.Config, httpServer *http.Server, maintenanceMode *maintenance.Mode, authenticator *grpcapi.Authenticator, documentServer *grpcapi.DocumentServer, eventServer *grpcapi.EventServer) (func(context.Context), error) {
+	if cfg.GRPCAddr == "" {
+		return nil, nil
+	}
+
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(grpcapi.MaintenanceInterceptor(maintenanceMode))}
+	switch {
+	case cfg.AutocertDomains != "":
+		opts = append(opts, grpc.Creds(credentials.NewTLS(httpServer.TLSConfig.Clone())))
+	case cfg.TLSCertFile != "":
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	listener, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", cfg.GRPCAddr, err)
+	}
+
+	server := grpcapi.NewServer(authenticator, documentServer, eventServer, opts...)
+	go func() {
+		slog.Info("gRPC server running", "addr", cfg.GRPCAddr, "tls", cfg.TLSEnabled())
+		if err := server.Serve(listener); err != nil {
+			slog.Error("gRPC server failed", "error", err)
+		}
+	}()
+
+	// Event streams only end when their clients leave, so they are cut off
+	// once ctx is done
+	return func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			server.Stop()
+		}
+	}, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"live-collab-api/internal/archiving"
+	"live-collab-api/internal/digests"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/exports"
+	"live-collab-api/internal/jobs"
+	"live-collab-api/internal/reminders"
+	"live-collab-api/internal/storage"
+	"live-collab-api/internal/usage"
+	"live-collab-api/internal/webhooks"
+	"log/slog"
+	"time"
+)
+
+// webhookDeliveryRetention is how long finished webhook deliveries stay in
+// the delivery log.
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
+// eventPartitionsAhead is how many months of event partitions are kept
+// created ahead of the current one.
+const eventPartitionsAhead = 3
+
+// digestCheckInterval is how often users due an activity digest are looked
+// up and queued.
+const digestCheckInterval = time.Hour
+
+// exportExpiryInterval is how often expired export archives are deleted.
+const exportExpiryInterval = time.Hour
+
+// reminderCheckInterval is how often reminders that came due are looked up
+// and sent.
+const reminderCheckInterval = time.Minute
+
+// publishScheduleInterval is how often due publish schedules are applied,
+// and so how late a scheduled change can take effect.
+const publishScheduleInterval = time.Minute
+
+// archiveCheckInterval is how often inactive documents are looked up to
+// warn their owners and archive them.
+const archiveCheckInterval = time.Hour
+
+// digestJob is the payload of a digests.send job.
+type digestJob struct {
+	UserId int `json:"user_id"`
+}
+
+// registerJobs sets up the background jobs this instance runs. store is nil
+// when object storage isn't configured, which also disables exports.
+// Digests are only sent when digestService has a mailer, and inactive
+// documents are only archived when archiveService has a period set.
+func registerJobs(runner *jobs.Runner, webhookService *webhooks.WebhookService, eventService *events.EventService, store *storage.Store, orphanGrace time.Duration, digestService *digests.DigestService, exportService *exports.ExportService, documentService *documents.DocumentService, notifier documents.AccessNotifier, dispatcher *webhooks.Dispatcher, reminderService *reminders.ReminderService, archiveService *archiving.ArchiveService, usageService *usage.UsageService) {
+	runner.RegisterPeriodic("webhooks.prune_deliveries", 24*time.Hour, func(ctx context.Context, _ json.RawMessage) error {
+		deleted, err := webhookService.PruneDeliveries(ctx, webhookDeliveryRetention)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Pruned webhook deliveries", "deleted", deleted)
+		return nil
+	}, jobs.RetryPolicy{MaxAttempts: 3})
+
+	runner.RegisterPeriodic("usage.prune_api_usage", 24*time.Hour, func(ctx context.Context, _ json.RawMessage) error {
+		deleted, err := usageService.PruneAPIUsage(ctx, usage.APIUsageRetention)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Pruned API usage", "deleted", deleted)
+		return nil
+	}, jobs.RetryPolicy{MaxAttempts: 3})
+
+	runner.RegisterPeriodic("events.create_partitions", 24*time.Hour, func(ctx context.Context, _ json.RawMessage) error {
+		created, err := eventService.CreatePartitions(ctx, eventPartitionsAhead)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Created event partitions", "created", created)
+		return nil
+	}, jobs.RetryPolicy{MaxAttempts: 3})
+
+	runner.RegisterPeriodic("documents.publish_schedule", publishScheduleInterval, func(ctx context.Context, _ json.RawMessage) error {
+		changes, err := documentService.RunPublishSchedule(ctx)
+		// changes already made are announced even if the rest failed
+		for _, change := range changes {
+			if !change.Published {
+				notifier.DocumentUnpublished(change.DocumentId)
+			}
+			dispatcher.Dispatch(ctx, change.OwnerId, webhooks.EventDocumentPublished, map[string]interface{}{
+				"document_id": change.DocumentId,
+				"published":   change.Published,
+				"user_id":     change.OwnerId,
+				"scheduled":   true,
+			})
+		}
+		if len(changes) > 0 {
+			slog.InfoContext(ctx, "Applied publish schedules", "documents", len(changes))
+		}
+		return err
+	}, jobs.RetryPolicy{MaxAttempts: 3})
+
+	// reminders reach open connections right away, and email is queued as
+	// a job per reminder so a failing address is retried on its own
+	runner.RegisterPeriodic("reminders.schedule", reminderCheckInterval, func(ctx context.Context, _ json.RawMessage) error {
+		due, err := reminderService.Claim(ctx)
+		if err != nil {
+			return err
+		}
+		for _, reminder := range due {
+			reminderService.Notify(reminder)
+			if reminderService.Mailer == nil {
+				continue
+			}
+			job := jobs.Job{
+				Type:      reminders.JobSend,
+				Payload:   reminder,
+				UniqueKey: fmt.Sprintf("%s:%d:%d:%d", reminders.JobSend, reminder.DocumentId, reminder.UserId, reminder.DueDate.Unix()),
+			}
+			if err := runner.Enqueue(ctx, job); err != nil {
+				return err
+			}
+		}
+		if len(due) > 0 {
+			slog.InfoContext(ctx, "Sent reminders", "reminders", len(due))
+		}
+		return nil
+	}, jobs.RetryPolicy{MaxAttempts: 3})
+
+	if reminderService.Mailer != nil {
+		runner.Register(reminders.JobSend, func(ctx context.Context, payload json.RawMessage) error {
+			var reminder reminders.Reminder
+			if err := json.Unmarshal(payload, &reminder); err != nil {
+				return jobs.Permanent(err)
+			}
+			return reminderService.Send(ctx, reminder)
+		}, jobs.RetryPolicy{BaseDelay: time.Minute})
+	}
+
+	if archiveService.After > 0 {
+		// warnings are emailed as a job per owner, so one failing address
+		// doesn't hold up the others
+		runner.RegisterPeriodic("archiving.run", archiveCheckInterval, func(ctx context.Context, _ json.RawMessage) error {
+			warnings, err := archiveService.Warn(ctx)
+			if err != nil {
+				return err
+			}
+			if archiveService.Mailer != nil {
+				for _, warning := range warnings {
+					job := jobs.Job{
+						Type:      archiving.JobWarn,
+						Payload:   warning,
+						UniqueKey: fmt.Sprintf("%s:%d:%d", archiving.JobWarn, warning.OwnerId, warning.ArchiveAt.Unix()),
+					}
+					if err := runner.Enqueue(ctx, job); err != nil {
+						return err
+					}
+				}
+			}
+			if len(warnings) > 0 {
+				slog.InfoContext(ctx, "Warned owners of inactive documents", "owners", len(warnings))
+			}
+
+			archived, err := archiveService.Archive(ctx)
+			if err != nil {
+				return err
+			}
+			if archived > 0 {
+				slog.InfoContext(ctx, "Archived inactive documents", "archived", archived)
+			}
+			return nil
+		}, jobs.RetryPolicy{MaxAttempts: 3})
+
+		if archiveService.Mailer != nil {
+			runner.Register(archiving.JobWarn, func(ctx context.Context, payload json.RawMessage) error {
+				var warning archiving.Warning
+				if err := json.Unmarshal(payload, &warning); err != nil {
+					return jobs.Permanent(err)
+				}
+				return archiveService.SendWarning(ctx, warning)
+			}, jobs.RetryPolicy{BaseDelay: time.Minute})
+		}
+	}
+
+	if store != nil {
+		runner.RegisterPeriodic("storage.cleanup_orphans", 24*time.Hour, func(ctx context.Context, _ json.RawMessage) error {
+			deleted, err := store.CleanupOrphans(ctx, orphanGrace)
+			if err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "Deleted orphaned objects", "deleted", deleted)
+			return nil
+		}, jobs.RetryPolicy{MaxAttempts: 3})
+
+		runner.Register(exports.JobBuild, func(ctx context.Context, payload json.RawMessage) error {
+			var job exports.BuildJob
+			if err := json.Unmarshal(payload, &job); err != nil {
+				return jobs.Permanent(err)
+			}
+			return exportService.Build(ctx, job.ExportId)
+		}, jobs.RetryPolicy{MaxAttempts: 3})
+
+		runner.RegisterPeriodic("exports.expire", exportExpiryInterval, func(ctx context.Context, _ json.RawMessage) error {
+			expired, err := exportService.ExpireExports(ctx)
+			if err != nil {
+				return err
+			}
+			if expired > 0 {
+				slog.InfoContext(ctx, "Deleted expired exports", "expired", expired)
+			}
+			return nil
+		}, jobs.RetryPolicy{MaxAttempts: 3})
+	}
+
+	if digestService.Mailer != nil {
+		// each user's digest is its own job, so one failing address doesn't
+		// hold up or resend the others
+		runner.RegisterPeriodic("digests.schedule", digestCheckInterval, func(ctx context.Context, _ json.RawMessage) error {
+			userIds, err := digestService.DueUsers(ctx)
+			if err != nil {
+				return err
+			}
+			for _, userId := range userIds {
+				job := jobs.Job{
+					Type:      "digests.send",
+					Payload:   digestJob{UserId: userId},
+					UniqueKey: fmt.Sprintf("digests.send:%d", userId),
+				}
+				if err := runner.Enqueue(ctx, job); err != nil {
+					return err
+				}
+			}
+			if len(userIds) > 0 {
+				slog.InfoContext(ctx, "Queued activity digests", "users", len(userIds))
+			}
+			return nil
+		}, jobs.RetryPolicy{MaxAttempts: 3})
+
+		runner.Register("digests.send", func(ctx context.Context, payload json.RawMessage) error {
+			var job digestJob
+			if err := json.Unmarshal(payload, &job); err != nil {
+				return jobs.Permanent(err)
+			}
+			_, err := digestService.Send(ctx, job.UserId)
+			return err
+		}, jobs.RetryPolicy{BaseDelay: time.Minute})
+	}
+}
@@ -1,23 +1,58 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/admin"
+	"live-collab-api/internal/archiving"
+	"live-collab-api/internal/audit"
 	"live-collab-api/internal/auth"
+	"live-collab-api/internal/avatars"
+	"live-collab-api/internal/buildinfo"
 	"live-collab-api/internal/config"
 	"live-collab-api/internal/db"
+	"live-collab-api/internal/digests"
 	"live-collab-api/internal/documents"
+	"live-collab-api/internal/encryption"
 	"live-collab-api/internal/events"
+	"live-collab-api/internal/exports"
+	"live-collab-api/internal/grpcapi"
+	"live-collab-api/internal/health"
+	"live-collab-api/internal/idempotency"
+	"live-collab-api/internal/importer"
+	"live-collab-api/internal/ipfilter"
+	"live-collab-api/internal/jobs"
+	"live-collab-api/internal/logging"
+	"live-collab-api/internal/mailer"
+	"live-collab-api/internal/maintenance"
+	"live-collab-api/internal/notifications"
+	"live-collab-api/internal/organizations"
+	"live-collab-api/internal/reminders"
+	"live-collab-api/internal/settings"
+	"live-collab-api/internal/storage"
+	"live-collab-api/internal/summaries"
+	"live-collab-api/internal/tasks"
+	"live-collab-api/internal/telemetry"
+	"live-collab-api/internal/timeout"
+	"live-collab-api/internal/usage"
+	"live-collab-api/internal/validation"
+	"live-collab-api/internal/webhooks"
 	"live-collab-api/internal/websocket"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "live-collab-api/docs"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // @title Live Collaboration API
@@ -40,100 +75,556 @@ import (
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
 func main() {
-	err := godotenv.Load()
+	cfg := config.LoadConfig()
+	logging.Setup(cfg.LogLevel, cfg.LogFormat)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(cfg, os.Args[2:]))
+		case "seed":
+			os.Exit(runSeed(cfg))
+		case "print-config", "-print-config", "--print-config":
+			os.Exit(printConfig(cfg))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid configuration", "environment", cfg.Environment, "problems", strings.Split(err.Error(), "\n"))
+		os.Exit(1)
+	}
+	gin.SetMode(cfg.GinMode)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.ServiceName, cfg.OTLPEndpoint, cfg.TraceSampleRatio)
 	if err != nil {
-		log.Println("No .env file found in current directory. Checking parent directories...")
-		// Load from root if running from cmd/server
-		if err := godotenv.Load("../../.env"); err != nil {
-			log.Printf("Still cound not load .env. Current dir: %s", os.Getenv("PWD"))
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	pool, database := db.Connect(cfg.DBUrl, dbTimeouts(cfg))
+	if cfg.AutoMigrate {
+		if err := db.Migrate(database); err != nil {
+			slog.Error("Failed to run migrations", "error", err)
+			os.Exit(1)
 		}
+		slog.Info("Migrations applied successfully")
+	} else if err := db.CheckMigrations(database); err != nil {
+		slog.Warn("Database schema is not up to date, run `server migrate up`", "error", err)
 	}
-	cfg := config.LoadConfig()
-	database := db.Connect(cfg.DBUrl)
 	jwtSecret := cfg.JWTSecret
 
 	authService := &auth.AuthService{
-		DB:        database,
-		JWTSecret: jwtSecret,
+		DB:                 database,
+		JWTSecret:          jwtSecret,
+		PreviousJWTSecrets: cfg.PreviousJWTSecrets(),
+		TokenTTL:           cfg.JWTTTL,
+		Issuer:             cfg.JWTIssuer,
+		Audience:           cfg.JWTAudience,
+	}
+	if cfg.JWTPrivateKey != "" {
+		authService.SigningKey, err = auth.ParsePrivateKey([]byte(cfg.JWTPrivateKey))
+		if err != nil {
+			slog.Error("Invalid JWT_PRIVATE_KEY", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.JWTPreviousPublicKeys != "" {
+		authService.PreviousKeys, err = auth.ParsePublicKeys([]byte(cfg.JWTPreviousPublicKeys))
+		if err != nil {
+			slog.Error("Invalid JWT_PREVIOUS_PUBLIC_KEYS", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Logging in with Google and GitHub is enabled per provider by setting
+	// its client ID
+	oauthHandler := &auth.OAuthHandler{
+		AuthService:   authService,
+		Providers:     map[string]*auth.OAuthProvider{},
+		FrontendUrl:   cfg.FrontendUrl,
+		SecureCookies: strings.HasPrefix(cfg.PublicUrl, "https://"),
+	}
+	callbackURL := func(provider string) string {
+		return strings.TrimRight(cfg.PublicUrl, "/") + "/auth/" + provider + "/callback"
+	}
+	if cfg.GoogleClientID != "" {
+		oauthHandler.Providers[auth.ProviderGoogle] = auth.GoogleProvider(cfg.GoogleClientID, cfg.GoogleClientSecret, callbackURL(auth.ProviderGoogle))
+	}
+	if cfg.GitHubClientID != "" {
+		oauthHandler.Providers[auth.ProviderGitHub] = auth.GitHubProvider(cfg.GitHubClientID, cfg.GitHubClientSecret, callbackURL(auth.ProviderGitHub))
 	}
 
 	documentService := &documents.DocumentService{
-		DB: database,
+		DB:             database,
+		SearchLanguage: cfg.SearchLanguage,
+	}
+
+	hub := websocket.NewHub()
+	hub.SlowClientPolicy = websocket.SlowClientPolicy(cfg.WSSlowClientPolicy)
+	hub.StaleTimeout = cfg.WSStaleClientTimeout
+	go hub.Run()
+
+	authService.Notifier = hub
+
+	maintenanceMode := maintenance.NewMode()
+	maintenanceMode.Notifier = hub
+
+	// While the database is unreachable the API stays up read-only, serving
+	// documents and access checks from the cache, and open WebSocket
+	// connections stay open with edits rejected
+	var dbMonitor *db.Monitor
+	if cfg.DBCheckInterval > 0 {
+		dbMonitor = &db.Monitor{
+			Ping:     database.PingContext,
+			Interval: cfg.DBCheckInterval,
+			OnChange: maintenanceMode.SetReadOnly,
+		}
+		go dbMonitor.Run(context.Background())
 	}
+	maintenanceHandler := &maintenance.MaintenanceHandler{Mode: maintenanceMode}
+	logLevelHandler := &logging.LevelHandler{}
+
+	dispatcher := webhooks.NewDispatcher(database, cfg.WebhookWorkers)
+
+	webhookService := &webhooks.WebhookService{DB: database}
+
+	webhooksHandler := &webhooks.WebhookHandler{
+		WebhookService: webhookService,
+		Dispatcher:     dispatcher,
+	}
+
+	// Object storage is optional: without it nothing is stored in a bucket
+	var store *storage.Store
+	if cfg.S3Bucket != "" {
+		client, err := storage.NewClient(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKeyID, cfg.S3SecretAccessKey, cfg.S3PathStyle)
+		if err != nil {
+			slog.Error("Failed to set up object storage", "error", err)
+			os.Exit(1)
+		}
+		store = &storage.Store{Client: client, DB: database}
+	}
+
+	// without a store avatars can still be set by URL, but not uploaded
+	avatarService := &avatars.AvatarService{DB: database, BaseURL: cfg.PublicUrl}
+	if store != nil {
+		avatarService.Store = store
+	}
+	avatarHandler := &avatars.AvatarHandler{AvatarService: avatarService}
+
+	eventService, err := events.NewEventService(context.Background(), database)
+	if err != nil {
+		slog.Error("Failed to set up event service", "error", err)
+		os.Exit(1)
+	}
+
+	eventService.Pool = pool
+
+	meter := usage.NewMeter(database, cfg.UsageFlushInterval)
+	eventService.Meter = meter
+	usageService := &usage.UsageService{DB: database}
+
+	// Email is optional: without an SMTP server preferences can still be
+	// set, but no digests or login links are sent
+	digestService := &digests.DigestService{DB: database, FrontendUrl: cfg.FrontendUrl}
+	if cfg.SMTPAddr != "" {
+		digestService.Mailer = &mailer.SMTPMailer{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	}
+	preferencesHandler := &digests.PreferencesHandler{DigestService: digestService}
+	magicLinkHandler := &auth.MagicLinkHandler{
+		AuthService: authService,
+		Mailer:      digestService.Mailer,
+		FrontendUrl: cfg.FrontendUrl,
+	}
+	settingsHandler := &settings.SettingsHandler{SettingsService: &settings.SettingsService{DB: database}}
+	notificationsHandler := &notifications.PreferenceHandler{
+		PreferenceService: &notifications.PreferenceService{DB: database},
+	}
+
+	reminderService := &reminders.ReminderService{
+		DB:          database,
+		Mailer:      digestService.Mailer,
+		Notifier:    hub,
+		FrontendUrl: cfg.FrontendUrl,
+	}
+	reminderHandler := &reminders.ReminderHandler{ReminderService: reminderService}
+
+	archiveService := &archiving.ArchiveService{
+		DB:          database,
+		Documents:   documentService,
+		Mailer:      digestService.Mailer,
+		FrontendUrl: cfg.FrontendUrl,
+		After:       cfg.ArchiveInactiveAfter,
+		Warning:     cfg.ArchiveWarning,
+	}
+	archiveHandler := &archiving.ArchiveHandler{ArchiveService: archiveService}
+
+	taskHandler := &tasks.TaskHandler{
+		TaskService: &tasks.TaskService{DB: database, Documents: documentService},
+		Notifier:    hub,
+	}
+
+	keyHandler := &encryption.KeyHandler{
+		KeyService: &encryption.KeyService{DocumentService: documentService},
+	}
+
+	jobRunner := jobs.NewRunner(database)
+
+	adminService := &admin.AdminService{DB: database}
+	exportService := &exports.ExportService{
+		DB:       database,
+		Store:    store,
+		Archives: adminService,
+		Jobs:     jobRunner,
+		TTL:      cfg.ExportTTL,
+	}
+	exportHandler := &exports.ExportHandler{ExportService: exportService}
+
+	registerJobs(jobRunner, webhookService, eventService, store, cfg.StorageOrphanGrace, digestService, exportService, documentService, hub, dispatcher, reminderService, archiveService, usageService)
+	jobRunner.Start(context.Background(), cfg.JobWorkers)
+
+	jobsHandler := &jobs.JobHandler{Runner: jobRunner}
+
+	auditService := &audit.AuditService{DB: database}
+	auditHandler := &audit.AuditHandler{AuditService: auditService}
 
 	documentsHandler := &documents.DocumentHandler{
 		DocumentService: documentService,
 		AuthService:     authService,
+		Notifier:        hub,
+		Webhooks:        dispatcher,
 	}
 
 	eventsHandler := &events.EventHandler{
-		DB:          database,
-		AuthService: authService,
+		EventService:    eventService,
+		DocumentService: documentService,
+		AuthService:     authService,
+		Webhooks:        dispatcher,
 	}
 
-	hub := websocket.NewHub()
-	go hub.Run()
+	organizationService := &organizations.OrganizationService{
+		DB: database,
+	}
+
+	organizationsHandler := &organizations.OrganizationHandler{
+		OrganizationService: organizationService,
+		DocumentService:     documentService,
+		AuthService:         authService,
+		Notifier:            hub,
+	}
+
+	// Summaries are optional: without an endpoint they are reported as
+	// disabled
+	summaryService := &summaries.SummaryService{
+		DB:              database,
+		DocumentService: documentService,
+		Timeout:         cfg.SummaryTimeout,
+	}
+	if cfg.SummaryAPIURL != "" {
+		summaryService.Client = &summaries.Client{
+			URL:    cfg.SummaryAPIURL,
+			APIKey: cfg.SummaryAPIKey,
+			Model:  cfg.SummaryModel,
+			HTTP:   &http.Client{Timeout: cfg.SummaryTimeout},
+		}
+	}
+	summaryHandler := &summaries.SummaryHandler{SummaryService: summaryService}
+
+	importHandler := &importer.ImportHandler{
+		DocumentService: documentService,
+		Fetcher:         importer.NewFetcher(cfg.ImportTimeout, int64(cfg.ImportMaxSize)),
+		Webhooks:        dispatcher,
+	}
+
+	usageHandler := &usage.UsageHandler{
+		UsageService:    usageService,
+		DocumentService: documentService,
+	}
+
+	adminHandler := &admin.AdminHandler{
+		AdminService:    adminService,
+		DocumentService: documentService,
+		AuthService:     authService,
+		Hub:             hub,
+	}
+
+	// the same allowlist governs CORS and WebSocket upgrades
+	origins := &websocket.OriginPolicy{
+		AllowedOrigins: websocket.ParseOrigins(cfg.AllowedOrigins),
+		AllowAll:       cfg.AllowAllOrigins,
+	}
+
+	documentStore := websocket.NewDocumentStore(database, cfg.ContentFlushInterval, cfg.ContentIdleTimeout, cfg.DocumentEvictTimeout)
+	hub.Store = documentStore
 
 	wsService := &websocket.WebSocketHandler{
 		Hub:         hub,
 		DB:          database,
 		AuthService: authService,
+		Store:       documentStore,
+		Meter:       meter,
+		Maintenance: maintenanceMode,
+		Pool:        pool,
+
+		SendBufferSize: cfg.WSSendBufferSize,
+		Origins:        origins,
+		Limiter:        websocket.NewConnectionLimiter(cfg.WSMaxConnections, cfg.WSMaxConnectionsPerIP),
+		MaxEditors:     cfg.WSMaxEditorsPerDocument,
 	}
 
-	router := gin.Default()
+	// Broadcasts are relayed between instances over Postgres with
+	// WS_RELAY=postgres, and otherwise over Redis; without Redis they only
+	// reach clients on this instance
+	var notifyRelay *websocket.NotifyRelay
+	if cfg.WSRelay == "postgres" {
+		notifyRelay = websocket.NewNotifyRelay(pool, hub)
+		hub.Relay = notifyRelay
+		go notifyRelay.StartListening()
+	}
+	redisService, err := websocket.NewRedisService(cfg.RedisUrl, hub)
+	if err != nil {
+		if notifyRelay == nil {
+			slog.Warn("Redis unavailable, running without cross-instance relay", "error", err)
+		} else {
+			slog.Warn("Redis unavailable", "error", err)
+		}
+	} else {
+		if notifyRelay == nil {
+			hub.Relay = redisService
+			go redisService.StartSubscription()
+		}
+		maintenanceMode.UseRedis(context.Background(), redisService.Client())
+
+		if cfg.DocumentCacheTTL > 0 {
+			cache := &documents.Cache{Client: redisService.Client(), TTL: cfg.DocumentCacheTTL}
+			documentService.Cache = cache
+			organizationService.Cache = cache
+			documentStore.Cache = cache
+		}
+	}
+
+	if cfg.AllowAllOrigins {
+		slog.Warn("ALLOW_ALL_ORIGINS is set: accepting API requests and WebSocket connections from any origin")
+	}
 
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{cfg.FrontendUrl, "http://localhost:8080"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
+	router := gin.New()
+
+	// X-Forwarded-For is only believed from trusted proxies when finding
+	// the client IP for rate limits, IP rules, and logs
+	if cfg.TrustedProxies != "*" {
+		var proxies []string
+		for _, proxy := range strings.Split(cfg.TrustedProxies, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				proxies = append(proxies, proxy)
+			}
+		}
+		if err := router.SetTrustedProxies(proxies); err != nil {
+			slog.Error("Invalid trusted proxies", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// The lists were checked by cfg.Validate
+	ipRules, _ := ipfilter.NewRules(cfg.IPAllowlist, cfg.IPDenylist)
+	adminIPRules, _ := ipfilter.NewRules(cfg.AdminIPAllowlist, "")
+
+	router.Use(otelgin.Middleware(cfg.ServiceName))
+	router.Use(logging.Middleware())
+	router.Use(gin.Recovery())
+	router.Use(auditService.Middleware())
+	router.Use(ipRules.Middleware(), adminIPRules.PathMiddleware("/api/admin"))
+
+	router.Use(corsMiddleware(origins))
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	// Health check endpoint
-	// @Summary Health check
-	// @Description Check if the API is running
-	// @Tags health
-	// @Produce json
-	// @Success 200 {object} object{status=string}
-	// @Router /health [get]
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	healthHandler := &health.Handler{}
+	if dbMonitor != nil {
+		healthHandler.AddSoftCheck("database", database.PingContext)
+	} else {
+		healthHandler.AddCheck("database", database.PingContext)
+	}
+	healthHandler.AddCheck("migrations", func(context.Context) error {
+		// the schema can't be checked while read-only, and the database
+		// check already reports why
+		if dbMonitor.Down() {
+			return nil
+		}
+		return db.CheckMigrations(database)
 	})
+	if redisService != nil {
+		healthHandler.AddCheck("redis", redisService.Ping)
+	} else {
+		healthHandler.AddCheck("redis", nil)
+	}
+	if store != nil {
+		healthHandler.AddCheck("storage", store.Client.Ping)
+	} else {
+		healthHandler.AddCheck("storage", nil)
+	}
+
+	// /health is kept for existing monitors
+	router.GET("/health", healthHandler.Liveness)
+	router.GET("/healthz", healthHandler.Liveness)
+	router.GET("/readyz", healthHandler.Readiness)
+	router.GET("/version", (&buildinfo.Handler{
+		DatabaseVersion: func(ctx context.Context) (int64, error) { return db.SchemaVersion(ctx, database) },
+		LatestMigration: db.LatestMigration,
+	}).GetVersion)
 
-	router.POST("/register", authService.Register)
-	router.POST("/login", authService.Login)
+	router.GET("/metrics/websocket", wsService.GetStats)
+	router.GET("/metrics/database", (&db.PoolHandler{Pool: pool}).GetPoolStats)
 
-	protected := router.Group("/api")
-	protected.Use(authService.AuthMiddleware())
-	{
-		protected.GET("/me", authService.Me)
+	// Probes, version, docs, and metrics above aren't rate limited
+	limits := newRateLimits(cfg, redisService)
+	ipLimit, authLimit, userLimit := limits.ip.Middleware(), limits.auth.Middleware(), limits.user.Middleware()
+
+	// creation endpoints replay their first response to retried requests
+	var idempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+	if redisService != nil {
+		idempotencyStore = &idempotency.RedisStore{Client: redisService.Client()}
+	}
+	idempotent := (&idempotency.Guard{Store: idempotencyStore, TTL: cfg.IdempotencyTTL}).Middleware()
+	registerRoutes(router, &routeHandlers{
+		auth:            authService,
+		oauth:           oauthHandler,
+		magicLinks:      magicLinkHandler,
+		documentService: documentService,
+		maintenanceMode: maintenanceMode,
+		ipLimit:         ipLimit,
+		authLimit:       authLimit,
+		userLimit:       userLimit,
+		idempotent:      idempotent,
+		timeout:         timeout.Middleware(cfg.RequestTimeout),
+		maxBodySize:     validation.MaxBodySize(int64(cfg.MaxRequestBodySize)),
+		countUsage:      meter.Middleware(),
+		pprof:           cfg.PprofEnabled,
 
-		protected.POST("/documents", documentsHandler.CreateDocument)
-		protected.GET("/documents", documentsHandler.GetUserDocuments)
+		documents:     documentsHandler,
+		events:        eventsHandler,
+		imports:       importHandler,
+		ws:            wsService,
+		keys:          keyHandler,
+		summaries:     summaryHandler,
+		preferences:   preferencesHandler,
+		notifications: notificationsHandler,
+		settings:      settingsHandler,
+		usage:         usageHandler,
+		exports:       exportHandler,
+		reminders:     reminderHandler,
+		archiving:     archiveHandler,
+		tasks:         taskHandler,
+		avatars:       avatarHandler,
+		webhooks:      webhooksHandler,
+		organizations: organizationsHandler,
+		admin:         adminHandler,
+		audit:         auditHandler,
+		jobs:          jobsHandler,
+		logLevel:      logLevelHandler,
+		maintenance:   maintenanceHandler,
+	})
 
-		docAccess := protected.Group("")
-		docAccess.Use(documents.DocumentAccessMiddleware(authService, documentService))
-		{
-			docAccess.GET("/documents/:id", documentsHandler.GetDocument)
-			docAccess.PATCH("/documents/:id", documentsHandler.UpdateDocument)
-			docAccess.DELETE("/documents/:id", documentsHandler.DeleteDocument)
+	server := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: router,
+	}
+	serve, challengeServer := configureTLS(server, cfg)
 
-			docAccess.POST("/documents/:id/events", eventsHandler.CreateDocumentEvent)
-			docAccess.GET("/documents/:id/events", eventsHandler.GetDocumentEvents)
+	stopGRPC, err := startGRPC(cfg, server, maintenanceMode,
+		&grpcapi.Authenticator{AuthService: authService, APIKeys: grpcapi.ParseAPIKeys(cfg.GRPCAPIKeys)},
+		&grpcapi.DocumentServer{DocumentService: documentService, Webhooks: dispatcher},
+		&grpcapi.EventServer{EventService: eventService, DocumentService: documentService, Webhooks: dispatcher},
+	)
+	if err != nil {
+		slog.Error("Failed to start gRPC server", "error", err)
+		os.Exit(1)
+	}
 
-			docAccess.GET("/documents/:id/collaborators", documentsHandler.GetCollaborators)
-			docAccess.POST("/documents/:id/collaborators", documentsHandler.AddCollaborator)
-			docAccess.DELETE("/documents/:id/collaborators/:user_id", documentsHandler.RemoveCollaborator)
+	go func() {
+		slog.Info("Server running", "addr", server.Addr, "commit", buildinfo.Get().Commit, "tls", cfg.TLSEnabled(), "environment", cfg.Environment, "gin_mode", gin.Mode())
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed to start", "error", err)
+			os.Exit(1)
 		}
+	}()
+
+	reloadOnSIGHUP(origins, limits)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	// shutdownRetryAfter is how long clients are told to wait before
+	// retrying writes rejected while the server shuts down.
+	const shutdownRetryAfter = 30 * time.Second
+
+	// Take the instance out of rotation, refuse new WebSocket connections,
+	// reject writes, and warn connected clients so they can save their work
+	// before they are moved to another instance
+	slog.Info("Shutting down server", "notice", cfg.ShutdownNotice)
+	healthHandler.Drain()
+	hub.StartDrain()
+	maintenanceMode.EnableLocal("The server is restarting", shutdownRetryAfter)
+	time.Sleep(cfg.ShutdownNotice)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	drained := wsService.Drain(drainCtx)
+	cancelDrain()
+	slog.Info("WebSocket clients drained", "clients", drained)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Server shutdown failed", "error", err)
+	}
+	if challengeServer != nil {
+		challengeServer.Shutdown(ctx)
+	}
+	if stopGRPC != nil {
+		stopGRPC(ctx)
 	}
 
-	router.GET("/ws/:document_id", wsService.HandleWebSocket)
+	// Persist any edits still held in memory
+	documentStore.Close()
+	eventService.Close()
+	meter.Close()
+	dispatcher.Close()
+	jobRunner.Close()
+	if redisService != nil {
+		redisService.Close()
+	}
+	if notifyRelay != nil {
+		notifyRelay.Close()
+	}
+	database.Close()
+	pool.Close()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Tracing shutdown failed", "error", err)
+	}
+	slog.Info("Server stopped")
+}
 
-	log.Println("Server running on :8080")
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		log.Fatal("Server failed to start:", err)
+// dbTimeouts are the session timeouts set on every database connection.
+func dbTimeouts(cfg *config.Config) db.Timeouts {
+	return db.Timeouts{
+		Statement:         cfg.DBStatementTimeout,
+		Lock:              cfg.DBLockTimeout,
+		IdleInTransaction: cfg.DBIdleInTransactionTimeout,
+	}
+}
+
+// printConfig writes the resolved configuration to stdout with secrets
+// redacted, followed by any problems with it, and returns the exit code.
+func printConfig(cfg *config.Config) int {
+	if err := cfg.Print(os.Stdout); err != nil {
+		slog.Error("Failed to print configuration", "error", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%v\n", err)
+		return 1
 	}
+	return 0
 }
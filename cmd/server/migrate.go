@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/db"
+	"log/slog"
+	"os"
+
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down          roll back the latest migration
+  status        list migrations and whether they are applied
+  create NAME   add an empty SQL migration to ` + db.MigrationsDir
+
+// runMigrate runs `server migrate` with the arguments after "migrate" and
+// returns the exit code.
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err := goose.SetDialect("postgres"); err != nil {
+		slog.Error("Failed to set migration dialect", "error", err)
+		return 1
+	}
+
+	// Creating a migration only writes a file
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		goose.SetSequential(true)
+		if err := goose.Create(nil, db.MigrationsDir, args[1], "sql"); err != nil {
+			slog.Error("Failed to create migration", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	// migrations may run longer than any query serving a request
+	pool, database := db.Connect(cfg.DBUrl, db.Timeouts{})
+	defer pool.Close()
+	defer database.Close()
+
+	var err error
+	switch args[0] {
+	case "up":
+		err = db.Migrate(database)
+	case "down":
+		err = goose.Down(database, db.MigrationsDir)
+	case "status":
+		err = goose.Status(database, db.MigrationsDir)
+	}
+	if err != nil {
+		slog.Error("Migration failed", "command", args[0], "error", err)
+		return 1
+	}
+	return 0
+}
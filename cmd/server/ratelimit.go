@@ -0,0 +1,43 @@
+package main
+
+import (
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/ratelimit"
+	"live-collab-api/internal/websocket"
+)
+
+// rateLimits holds the limiters for requests per client IP, requests to
+// the auth endpoints per IP, and requests per user. Buckets are kept in
+// Redis when it is available so limits hold across instances.
+type rateLimits struct {
+	ip, auth, user *ratelimit.Limiter
+}
+
+func newRateLimits(cfg *config.Config, redisService *websocket.RedisService) *rateLimits {
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if redisService != nil {
+		store = &ratelimit.RedisStore{Client: redisService.Client()}
+	}
+
+	limits := &rateLimits{
+		ip:   &ratelimit.Limiter{Store: store, Name: "ip", Key: ratelimit.ByIP},
+		auth: &ratelimit.Limiter{Store: store, Name: "auth", Key: ratelimit.ByIP},
+		user: &ratelimit.Limiter{Store: store, Name: "user", Key: ratelimit.ByUser},
+	}
+	limits.apply(cfg)
+	return limits
+}
+
+// apply sets the limits from cfg. With rate limiting disabled every
+// request is let through.
+func (l *rateLimits) apply(cfg *config.Config) {
+	var limit, authLimit ratelimit.Limit
+	if cfg.RateLimitEnabled {
+		limit = ratelimit.Limit{Requests: cfg.RateLimitRequests, Per: cfg.RateLimitWindow}
+		authLimit = ratelimit.Limit{Requests: cfg.RateLimitAuthRequests, Per: cfg.RateLimitWindow}
+	}
+
+	l.ip.SetLimit(limit)
+	l.auth.SetLimit(authLimit)
+	l.user.SetLimit(limit)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/logging"
+	"live-collab-api/internal/websocket"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives
+// SIGHUP and applies the settings that can change without restarting: the
+// allowed origins, rate limits, and log level. Other settings keep their
+// values until the next restart, and an invalid configuration is ignored.
+func reloadOnSIGHUP(origins *websocket.OriginPolicy, limits *rateLimits) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := config.ReloadEnvFile(); err != nil {
+				slog.Error("Failed to reload .env, keeping current configuration", "error", err)
+				continue
+			}
+
+			cfg := config.LoadConfig()
+			if err := cfg.Validate(); err != nil {
+				slog.Error("Invalid configuration, keeping current configuration", "problems", strings.Split(err.Error(), "\n"))
+				continue
+			}
+
+			logging.SetLevel(cfg.LogLevel)
+			origins.Update(websocket.ParseOrigins(cfg.AllowedOrigins), cfg.AllowAllOrigins)
+			limits.apply(cfg)
+			slog.Info("Configuration reloaded",
+				"log_level", cfg.LogLevel,
+				"allowed_origins", cfg.AllowedOrigins,
+				"rate_limit_enabled", cfg.RateLimitEnabled,
+			)
+		}
+	}()
+}
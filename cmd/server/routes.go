@@ -0,0 +1,248 @@
+package main
+
+import (
+	"live-collab-api/internal/admin"
+	"live-collab-api/internal/archiving"
+	"live-collab-api/internal/audit"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/avatars"
+	"live-collab-api/internal/digests"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/encryption"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/exports"
+	"live-collab-api/internal/idempotency"
+	"live-collab-api/internal/importer"
+	"live-collab-api/internal/jobs"
+	"live-collab-api/internal/logging"
+	"live-collab-api/internal/maintenance"
+	"live-collab-api/internal/notifications"
+	"live-collab-api/internal/organizations"
+	"live-collab-api/internal/reminders"
+	"live-collab-api/internal/settings"
+	"live-collab-api/internal/summaries"
+	"live-collab-api/internal/tasks"
+	"live-collab-api/internal/usage"
+	"live-collab-api/internal/webhooks"
+	"live-collab-api/internal/websocket"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// routeHandlers are the handlers and middleware the API is served by.
+type routeHandlers struct {
+	auth            *auth.AuthService
+	oauth           *auth.OAuthHandler
+	magicLinks      *auth.MagicLinkHandler
+	documentService *documents.DocumentService
+	maintenanceMode *maintenance.Mode
+
+	// ipLimit, timeout, and maxBodySize apply to every route, authLimit
+	// to registering and logging in, with a password, a magic link, or
+	// through OAuth, and userLimit to authenticated routes. idempotent
+	// replays the first response to retried creation requests. countUsage
+	// counts authenticated requests toward users' API usage.
+	ipLimit, authLimit, userLimit, idempotent, timeout, maxBodySize, countUsage gin.HandlerFunc
+	// pprof serves profiles under /api/admin/debug/pprof.
+	pprof bool
+
+	documents     *documents.DocumentHandler
+	events        *events.EventHandler
+	imports       *importer.ImportHandler
+	ws            *websocket.WebSocketHandler
+	keys          *encryption.KeyHandler
+	summaries     *summaries.SummaryHandler
+	preferences   *digests.PreferencesHandler
+	notifications *notifications.PreferenceHandler
+	settings      *settings.SettingsHandler
+	usage         *usage.UsageHandler
+	exports       *exports.ExportHandler
+	reminders     *reminders.ReminderHandler
+	archiving     *archiving.ArchiveHandler
+	tasks         *tasks.TaskHandler
+	avatars       *avatars.AvatarHandler
+	webhooks      *webhooks.WebhookHandler
+	organizations *organizations.OrganizationHandler
+	admin         *admin.AdminHandler
+	audit         *audit.AuditHandler
+	jobs          *jobs.JobHandler
+	logLevel      *logging.LevelHandler
+	maintenance   *maintenance.MaintenanceHandler
+}
+
+// corsMiddleware lets browsers on the allowed origins call every route of
+// the API.
+func corsMiddleware(origins *websocket.OriginPolicy) gin.HandlerFunc {
+	return cors.New(cors.Config{
+		AllowOriginFunc:  origins.Allowed,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", logging.RequestIDHeader, idempotency.Header},
+		ExposeHeaders:    []string{"Content-Length", logging.RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", idempotency.ReplayedHeader},
+		AllowCredentials: true,
+	})
+}
+
+// registerRoutes builds the API route table. A document's routes all live
+// under /api/documents/:id behind the document access check, except its
+// WebSocket, which authenticates itself so spectators can watch published
+// documents.
+func registerRoutes(router *gin.Engine, h *routeHandlers) {
+	limited := router.Group("")
+	limited.Use(h.ipLimit, h.timeout, h.maxBodySize)
+
+	limited.POST("/register", h.authLimit, h.maintenanceMode.Middleware(), h.auth.Register)
+	limited.POST("/login", h.authLimit, h.auth.Login)
+	limited.POST("/login/magic", h.authLimit, h.maintenanceMode.Middleware(), h.magicLinks.Request)
+	limited.POST("/login/magic/verify", h.authLimit, h.maintenanceMode.Middleware(), h.magicLinks.Verify)
+	limited.GET("/.well-known/jwks.json", h.auth.GetJWKS)
+	limited.GET("/auth/:provider", h.authLimit, h.oauth.Login)
+	limited.GET("/auth/:provider/callback", h.authLimit, h.maintenanceMode.Middleware(), h.oauth.Callback)
+
+	protected := limited.Group("/api")
+	protected.Use(h.auth.AuthMiddleware(), h.countUsage, h.userLimit, h.maintenanceMode.Middleware())
+	{
+		protected.GET("/me", h.auth.Me)
+		protected.PATCH("/me", h.auth.UpdateMe)
+		protected.GET("/me/sessions", h.auth.GetSessions)
+		protected.DELETE("/me/sessions/:id", h.auth.DeleteSession)
+		protected.GET("/me/preferences", h.preferences.GetPreferences)
+		protected.PUT("/me/preferences", h.preferences.SetPreferences)
+		protected.GET("/me/notifications", h.notifications.GetPreferences)
+		protected.PATCH("/me/notifications", h.notifications.UpdatePreferences)
+		protected.GET("/me/settings", h.settings.GetSettings)
+		protected.PATCH("/me/settings", h.settings.UpdateSettings)
+		protected.GET("/me/api-usage", h.usage.GetAPIUsage)
+		protected.PUT("/me/public-key", h.keys.SetPublicKey)
+		protected.POST("/me/avatar", h.avatars.UploadAvatar)
+		protected.DELETE("/me/avatar", h.avatars.DeleteAvatar)
+		protected.GET("/users/search", h.auth.SearchUsers)
+		protected.GET("/users/:id/public-key", h.keys.GetPublicKey)
+
+		protected.POST("/documents", h.idempotent, h.documents.CreateDocument)
+		protected.GET("/documents", h.documents.GetUserDocuments)
+		protected.GET("/documents/search", h.documents.SearchDocuments)
+		protected.POST("/documents/import-url", h.idempotent, h.imports.ImportURL)
+
+		document := protected.Group("/documents/:id")
+		document.Use(documents.DocumentAccessMiddleware(h.auth, h.documentService))
+		{
+			document.GET("", h.documents.GetDocument)
+			document.PATCH("", h.documents.UpdateDocument)
+			document.DELETE("", h.documents.DeleteDocument)
+			document.PUT("/publish", h.documents.SetPublished)
+			document.POST("/unarchive", h.documents.Unarchive)
+			document.GET("/publish-schedule", h.documents.GetPublishSchedule)
+			document.PUT("/publish-schedule", h.documents.SetPublishSchedule)
+			document.GET("/unfurl", h.documents.GetUnfurl)
+			document.GET("/editor-limit", h.documents.GetEditorLimit)
+			document.PUT("/editor-limit", h.documents.SetEditorLimit)
+			document.GET("/due-date", h.documents.GetDueDate)
+			document.PUT("/due-date", h.documents.SetDueDate)
+			document.GET("/reminder", h.reminders.GetReminder)
+			document.PUT("/reminder", h.reminders.SetReminder)
+			document.DELETE("/reminder", h.reminders.ResetReminder)
+			document.PUT("/organization", h.documents.SetOrganization)
+
+			document.POST("/events", h.idempotent, h.events.CreateDocumentEvent)
+			document.GET("/events", h.events.GetDocumentEvents)
+			document.GET("/sessions", h.events.GetSessions)
+
+			document.GET("/tasks", h.tasks.ListTasks)
+			document.POST("/tasks", h.idempotent, h.tasks.CreateTask)
+			document.PUT("/tasks/:task_id", h.tasks.UpdateTask)
+			document.DELETE("/tasks/:task_id", h.tasks.DeleteTask)
+
+			document.GET("/collaborators", h.documents.GetCollaborators)
+			document.POST("/collaborators", h.idempotent, h.documents.AddCollaborator)
+			document.DELETE("/collaborators/:user_id", h.documents.RemoveCollaborator)
+
+			document.GET("/presence", h.ws.GetPresence)
+			document.GET("/reads", h.documents.GetReadReceipts)
+			document.GET("/changes-since-last-visit", h.documents.GetChangesSinceLastVisit)
+			document.GET("/summary", h.summaries.GetSummary)
+			document.POST("/sync", h.ws.SyncOfflineEdits)
+
+			document.GET("/keys", h.keys.GetKeys)
+			document.POST("/keys", h.keys.ShareKeys)
+			document.GET("/snapshot", h.keys.GetSnapshot)
+			document.PUT("/snapshot", h.keys.SaveSnapshot)
+
+			document.GET("/webhooks", h.webhooks.GetDocumentWebhooks)
+			document.POST("/webhooks", h.webhooks.CreateDocumentWebhook)
+			document.DELETE("/webhooks/:webhook_id", h.webhooks.DeleteDocumentWebhook)
+			document.POST("/webhooks/:webhook_id/test", h.webhooks.TestDocumentWebhook)
+		}
+
+		protected.GET("/usage", h.usage.GetUsage)
+
+		protected.POST("/exports", h.idempotent, h.exports.RequestExport)
+		protected.GET("/exports", h.exports.GetExports)
+		protected.GET("/exports/:id", h.exports.GetExport)
+
+		protected.POST("/webhooks", h.webhooks.CreateWebhook)
+		protected.GET("/webhooks", h.webhooks.GetUserWebhooks)
+		protected.DELETE("/webhooks/:id", h.webhooks.DeleteWebhook)
+		protected.GET("/webhooks/:id/deliveries", h.webhooks.GetDeliveries)
+		protected.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", h.webhooks.Redeliver)
+
+		protected.POST("/organizations", h.organizations.CreateOrganization)
+		protected.GET("/organizations", h.organizations.GetUserOrganizations)
+
+		orgMembers := protected.Group("/organizations/:id")
+		orgMembers.Use(h.organizations.MembershipMiddleware())
+		{
+			orgMembers.GET("/members", h.organizations.GetMembers)
+			orgMembers.POST("/members", h.organizations.SetMember)
+			orgMembers.DELETE("/members/:user_id", h.organizations.RemoveMember)
+			orgMembers.PUT("/permissions", h.organizations.SetPermissions)
+		}
+
+		adminRoutes := protected.Group("/admin")
+		adminRoutes.Use(h.auth.AdminMiddleware())
+		{
+			adminRoutes.GET("/users", h.admin.ListUsers)
+			adminRoutes.GET("/documents", h.admin.ListDocuments)
+			adminRoutes.GET("/documents/inactive", h.archiving.ListInactive)
+			adminRoutes.GET("/stats", h.admin.GetStats)
+			adminRoutes.DELETE("/documents/:id", h.admin.DeleteDocument)
+			adminRoutes.PUT("/documents/:id/owner", h.admin.ReassignDocument)
+			adminRoutes.GET("/documents/:id/export", h.admin.ExportDocument)
+			adminRoutes.POST("/documents/import", h.admin.ImportDocument)
+			adminRoutes.GET("/audit", h.audit.ListAuditLog)
+			adminRoutes.GET("/events/search", h.events.SearchEvents)
+			adminRoutes.GET("/jobs", h.jobs.ListJobs)
+			adminRoutes.GET("/jobs/dead", h.jobs.ListDeadJobs)
+			adminRoutes.POST("/jobs/dead/:id/requeue", h.jobs.RequeueDeadJob)
+			adminRoutes.GET("/log-level", h.logLevel.GetLogLevel)
+			adminRoutes.PUT("/log-level", h.logLevel.SetLogLevel)
+		}
+	}
+
+	// Maintenance mode is switched outside the protected group so it can
+	// be turned off while writes are rejected
+	maintenanceRoutes := limited.Group("/api/admin/maintenance")
+	maintenanceRoutes.Use(h.auth.AuthMiddleware(), h.auth.AdminMiddleware())
+	{
+		maintenanceRoutes.GET("", h.maintenance.GetMaintenance)
+		maintenanceRoutes.PUT("", h.maintenance.SetMaintenance)
+	}
+
+	// Profiles are served outside the limited group, as a CPU profile or
+	// trace runs longer than the request timeout
+	if h.pprof {
+		pprofRoutes := router.Group("/api/admin/debug/pprof")
+		pprofRoutes.Use(h.auth.AuthMiddleware(), h.auth.AdminMiddleware())
+		registerPprof(pprofRoutes)
+	}
+
+	// Link previews of published documents are served without a token
+	limited.GET("/api/public/documents/:id/unfurl", h.documents.GetPublicUnfurl)
+	// Avatars are shown wherever users are, including to spectators
+	limited.GET("/api/public/avatars/:name", h.avatars.GetAvatar)
+
+	// WebSocket connections check the token and document access
+	// themselves. /ws/:document_id is kept for existing clients.
+	limited.GET("/api/documents/:id/ws", h.ws.HandleWebSocket)
+	limited.GET("/ws/:document_id", h.ws.HandleWebSocket)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"live-collab-api/internal/admin"
+	"live-collab-api/internal/archiving"
+	"live-collab-api/internal/audit"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/avatars"
+	"live-collab-api/internal/digests"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/encryption"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/exports"
+	"live-collab-api/internal/importer"
+	"live-collab-api/internal/jobs"
+	"live-collab-api/internal/logging"
+	"live-collab-api/internal/maintenance"
+	"live-collab-api/internal/notifications"
+	"live-collab-api/internal/organizations"
+	"live-collab-api/internal/reminders"
+	"live-collab-api/internal/settings"
+	"live-collab-api/internal/summaries"
+	"live-collab-api/internal/tasks"
+	"live-collab-api/internal/usage"
+	"live-collab-api/internal/webhooks"
+	"live-collab-api/internal/websocket"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// setupRouter registers the route table with handlers that are never
+// reached by these tests, which stop at the middleware.
+func setupRouter(t *testing.T) (*gin.Engine, *auth.AuthService, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	authService := &auth.AuthService{DB: db, JWTSecret: "test-secret"}
+	pass := func(c *gin.Context) { c.Next() }
+
+	router := gin.New()
+	registerRoutes(router, &routeHandlers{
+		auth:            authService,
+		oauth:           &auth.OAuthHandler{AuthService: authService},
+		magicLinks:      &auth.MagicLinkHandler{AuthService: authService},
+		documentService: &documents.DocumentService{DB: db},
+		maintenanceMode: maintenance.NewMode(),
+		ipLimit:         pass,
+		authLimit:       pass,
+		userLimit:       pass,
+		idempotent:      pass,
+		timeout:         pass,
+		maxBodySize:     pass,
+		countUsage:      pass,
+		pprof:           true,
+
+		documents:     &documents.DocumentHandler{},
+		events:        &events.EventHandler{},
+		imports:       &importer.ImportHandler{},
+		ws:            &websocket.WebSocketHandler{Hub: websocket.NewHub()},
+		keys:          &encryption.KeyHandler{},
+		summaries:     &summaries.SummaryHandler{},
+		preferences:   &digests.PreferencesHandler{},
+		notifications: &notifications.PreferenceHandler{},
+		settings:      &settings.SettingsHandler{},
+		usage:         &usage.UsageHandler{},
+		exports:       &exports.ExportHandler{},
+		reminders:     &reminders.ReminderHandler{},
+		archiving:     &archiving.ArchiveHandler{},
+		tasks:         &tasks.TaskHandler{},
+		avatars:       &avatars.AvatarHandler{},
+		webhooks:      &webhooks.WebhookHandler{},
+		organizations: &organizations.OrganizationHandler{},
+		admin:         &admin.AdminHandler{},
+		audit:         &audit.AuditHandler{},
+		jobs:          &jobs.JobHandler{},
+		logLevel:      &logging.LevelHandler{},
+		maintenance:   &maintenance.MaintenanceHandler{},
+	})
+	return router, authService, mock
+}
+
+var pathParam = regexp.MustCompile(`:[a-z_]+`)
+
+func serve(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, pathParam.ReplaceAllString(path, "1"), nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func isWebSocket(path string) bool {
+	return strings.HasPrefix(path, "/ws/") || strings.HasSuffix(path, "/ws")
+}
+
+func TestRegisterRoutes_Table(t *testing.T) {
+	router, _, _ := setupRouter(t)
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		key := route.Method + " " + route.Path
+		if registered[key] {
+			t.Errorf("Route %s is registered twice", key)
+		}
+		registered[key] = true
+	}
+
+	for _, key := range []string{
+		"GET /api/documents",
+		"POST /api/documents",
+		"GET /api/documents/search",
+		"POST /api/documents/import-url",
+		"GET /api/documents/:id",
+		"PATCH /api/documents/:id",
+		"DELETE /api/documents/:id",
+		"GET /api/documents/:id/events",
+		"POST /api/documents/:id/events",
+		"GET /api/documents/:id/collaborators",
+		"POST /api/documents/:id/collaborators",
+		"DELETE /api/documents/:id/collaborators/:user_id",
+		"GET /api/documents/:id/ws",
+		"GET /ws/:document_id",
+	} {
+		if !registered[key] {
+			t.Errorf("Expected route %s to be registered", key)
+		}
+	}
+}
+
+func TestRegisterRoutes_RequireAuthentication(t *testing.T) {
+	router, _, _ := setupRouter(t)
+
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") || strings.HasPrefix(route.Path, "/api/public/") || isWebSocket(route.Path) {
+			continue
+		}
+		if w := serve(router, route.Method, route.Path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status %d without a token, got %d", route.Method, route.Path, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
+
+func TestRegisterRoutes_DocumentRoutesCheckAccess(t *testing.T) {
+	router, authService, mock := setupRouter(t)
+	token, _ := auth.GenerateJWT(2, authService.JWTSecret)
+
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/documents/:id") || isWebSocket(route.Path) {
+			continue
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+			WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		if w := serve(router, route.Method, route.Path, token); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status %d for a non-member, got %d", route.Method, route.Path, http.StatusForbidden, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCORS_AllowsPatchPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(corsMiddleware(&websocket.OriginPolicy{AllowedOrigins: websocket.ParseOrigins("https://app.example.com")}))
+	router.PATCH("/api/me", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest(http.MethodOptions, "/api/me", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 for the preflight, got %d", w.Code)
+	}
+	if methods := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, http.MethodPatch) {
+		t.Errorf("Expected PATCH to be allowed, got %q", methods)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Expected the origin to be allowed, got %q", origin)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/config"
+	"live-collab-api/internal/db"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/events"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// seedPassword is the password of every seeded user.
+const seedPassword = "password123"
+
+var seedUsers = []string{"alice@example.com", "bob@example.com", "carol@example.com"}
+
+// seedDocuments are created for the first seeded user. Each paragraph is
+// typed as one edit, so the documents come with an event history.
+var seedDocuments = []struct {
+	title         string
+	paragraphs    []string
+	collaborators map[string]string
+}{
+	{
+		title: "Project Plan",
+		paragraphs: []string{
+			"Goals: ship real-time editing to all users.\n",
+			"Milestones: presence, cursors, offline sync.\n",
+			"Owners: Alice (backend), Bob (frontend).\n",
+		},
+		collaborators: map[string]string{"bob@example.com": "edit", "carol@example.com": "view"},
+	},
+	{
+		title: "Meeting Notes",
+		paragraphs: []string{
+			"Attendees: Alice, Bob, Carol.\n",
+			"Decisions: use Redis for cross-instance relay.\n",
+		},
+		collaborators: map[string]string{"carol@example.com": "edit"},
+	},
+	{
+		title:      "Scratchpad",
+		paragraphs: []string{"Private notes.\n"},
+	},
+}
+
+// runSeed fills the database with sample users, documents, collaborators,
+// and edit histories for development and demos. It refuses to run outside
+// development, and does nothing if the sample documents already exist.
+func runSeed(cfg *config.Config) int {
+	if !cfg.IsDevelopment() {
+		slog.Error("Seeding is only allowed in development", "environment", cfg.Environment)
+		return 1
+	}
+
+	pool, database := db.Connect(cfg.DBUrl, dbTimeouts(cfg))
+	defer pool.Close()
+	defer database.Close()
+
+	if err := db.Migrate(database); err != nil {
+		slog.Error("Failed to run migrations", "error", err)
+		return 1
+	}
+	if err := seed(context.Background(), pool, database); err != nil {
+		slog.Error("Seeding failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
+func seed(ctx context.Context, pool *pgxpool.Pool, database *sql.DB) error {
+	hash, err := auth.HashPassword(seedPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	userIds := make(map[string]int)
+	for _, email := range seedUsers {
+		var id int
+		err := database.QueryRowContext(ctx, `
+			INSERT INTO users (email, password) VALUES ($1, $2)
+			ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+			RETURNING id
+		`, email, hash).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to create user %s: %v", email, err)
+		}
+		userIds[email] = id
+	}
+
+	// The first user administers the instance
+	_, err = database.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", auth.RoleAdmin, userIds[seedUsers[0]])
+	if err != nil {
+		return fmt.Errorf("failed to make %s an admin: %v", seedUsers[0], err)
+	}
+
+	ownerId := userIds[seedUsers[0]]
+	documentService := &documents.DocumentService{DB: database}
+	existing, err := documentService.GetUserDocuments(ctx, ownerId)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		slog.Info("Sample documents already exist, skipping", "owner", seedUsers[0])
+		return nil
+	}
+
+	eventService, err := events.NewEventService(ctx, database)
+	if err != nil {
+		return err
+	}
+	defer eventService.Close()
+	eventService.Pool = pool
+
+	// the edit histories are copied in at the end, in one go
+	var history []events.NewEvent
+	for _, sample := range seedDocuments {
+		doc, err := documentService.CreateDocument(ctx, sample.title, ownerId, strings.Join(sample.paragraphs, ""), nil)
+		if err != nil {
+			return err
+		}
+
+		for email, permission := range sample.collaborators {
+			if err := documentService.AddCollaborator(ctx, doc.ID, userIds[email], permission); err != nil {
+				return err
+			}
+		}
+
+		// Replay the content as edits, in the format WebSocket clients
+		// persist them
+		position := 0
+		for i, paragraph := range sample.paragraphs {
+			payload, err := json.Marshal(map[string]interface{}{
+				"type":      "edit",
+				"version":   i + 1,
+				"timestamp": time.Now().UnixMilli(),
+				"payload": map[string]interface{}{
+					"operation": "insert",
+					"position":  position,
+					"content":   paragraph,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			history = append(history, events.NewEvent{DocumentId: doc.ID, UserId: ownerId, EventType: "edit", Payload: string(payload), Version: i + 1})
+			position += utf8.RuneCountInString(paragraph)
+		}
+	}
+
+	if _, err := eventService.CopyEvents(ctx, history); err != nil {
+		return err
+	}
+
+	slog.Info("Seeded sample data", "users", len(seedUsers), "documents", len(seedDocuments), "password", seedPassword)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"live-collab-api/internal/config"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS prepares server to terminate TLS as configured, which also
+// serves WebSocket connections over wss://. It returns the function that
+// runs the server and, when certificates come from Let's Encrypt, the
+// server answering ACME challenges on plain HTTP, which is nil otherwise.
+func configureTLS(server *http.Server, cfg *config.Config) (func() error, *http.Server) {
+	switch {
+	case cfg.AutocertDomains != "":
+		var domains []string
+		for _, domain := range strings.Split(cfg.AutocertDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+
+		// Requests other than challenges are redirected to HTTPS
+		challengeServer := &http.Server{
+			Addr:              cfg.AutocertHTTPAddr,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("Answering ACME challenges", "addr", challengeServer.Addr, "domains", domains)
+			if err := challengeServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("ACME challenge server failed", "error", err)
+			}
+		}()
+
+		return func() error { return server.ListenAndServeTLS("", "") }, challengeServer
+
+	case cfg.TLSCertFile != "":
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return func() error { return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile) }, nil
+
+	default:
+		return server.ListenAndServe, nil
+	}
+}
@@ -0,0 +1,319 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupAdminTest(t *testing.T) (sqlmock.Sqlmock, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	authService := &auth.AuthService{DB: db, JWTSecret: "test-secret"}
+	handler := &AdminHandler{
+		AdminService:    &AdminService{DB: db},
+		DocumentService: &documents.DocumentService{DB: db},
+		AuthService:     authService,
+	}
+
+	r := gin.New()
+	adminRoutes := r.Group("/api/admin")
+	adminRoutes.Use(authService.AuthMiddleware(), authService.AdminMiddleware())
+	adminRoutes.GET("/users", handler.ListUsers)
+	adminRoutes.GET("/stats", handler.GetStats)
+	adminRoutes.PUT("/documents/:id/owner", handler.ReassignDocument)
+	adminRoutes.GET("/documents/:id/export", handler.ExportDocument)
+	adminRoutes.POST("/documents/import", handler.ImportDocument)
+	return mock, r
+}
+
+func expectRole(mock sqlmock.Sqlmock, userId int, role string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM users WHERE id = $1")).
+		WithArgs(userId).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(role))
+}
+
+func TestAdminMiddleware_RejectsUsers(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(2, "test-secret")
+	expectRole(mock, 2, auth.RoleUser)
+
+	req, _ := http.NewRequest("GET", "/api/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestGetStats_Success(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	mock.ExpectQuery(regexp.QuoteMeta("(SELECT COUNT(*) FROM users)")).
+		WillReturnRows(sqlmock.NewRows([]string{"users", "documents", "events"}).AddRow(3, 5, 42))
+
+	req, _ := http.NewRequest("GET", "/api/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response StatsResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Users != 3 || response.Documents != 5 || response.EventsLastDay != 42 {
+		t.Errorf("Expected 3 users, 5 documents, and 42 events, got %+v", response)
+	}
+}
+
+func TestListUsers_Inactive(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	lastLogin := time.Now().Add(-45 * 24 * time.Hour).UTC().Truncate(time.Second)
+	mock.ExpectQuery(regexp.QuoteMeta("u.last_login_at IS NULL OR u.last_login_at < $2")).
+		WithArgs("", sqlmock.AnyArg(), 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role", "count", "created_at", "last_login_at", "last_login_ip"}).
+			AddRow(4, "stale@example.com", "user", 2, "2024-01-15T10:30:00Z", lastLogin, "203.0.113.7").
+			AddRow(6, "never@example.com", "user", 0, "2024-02-01T08:00:00Z", nil, ""))
+
+	req, _ := http.NewRequest("GET", "/api/admin/users?inactive_days=30", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response UserListResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Users) != 2 || response.Users[0].LastLoginAt == nil || !response.Users[0].LastLoginAt.Equal(lastLogin) || response.Users[1].LastLoginAt != nil {
+		t.Errorf("Unexpected users: %+v", response.Users)
+	}
+
+	expectRole(mock, 1, auth.RoleAdmin)
+	req, _ = http.NewRequest("GET", "/api/admin/users?inactive_days=soon", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid inactive_days, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	expectRole(mock, 1, auth.RoleAdmin)
+	req, _ = http.NewRequest("GET", "/api/admin/users?limit=5000", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an out of range limit, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestReassignDocument_Success(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET owner_id = $1 WHERE id = $2")).
+		WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM document_collaborators WHERE document_id = $1 AND user_id = $2")).
+		WithArgs(7, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest("PUT", "/api/admin/documents/7/owner", bytes.NewBufferString(`{"user_id": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReassignDocument_NotFound(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET owner_id = $1 WHERE id = $2")).
+		WithArgs(3, 99).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	req, _ := http.NewRequest("PUT", "/api/admin/documents/99/owner", bytes.NewBufferString(`{"user_id": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestExportDocument_Success(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	created := time.Date(2025, 1, 4, 10, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT d.title, COALESCE(d.content, '')")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "content", "content_type", "email", "published", "search_language", "encrypted", "snapshot_version", "initial_content", "created_at"}).
+			AddRow("Notes", "Hello", "text/plain", "owner@example.com", false, "english", false, 0, "", created))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM document_collaborators dc")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "permission", "created_at"}).
+			AddRow("editor@example.com", "edit", created))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events e")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "event_type", "payload", "version", "created_at"}).
+			AddRow("editor@example.com", "edit", []byte(`{"version":1}`), 1, created).
+			AddRow(nil, "cursor_move", []byte(`{}`), nil, created))
+	mock.ExpectRollback()
+
+	req, _ := http.NewRequest("GET", "/api/admin/documents/7/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var archive Archive
+	json.Unmarshal(w.Body.Bytes(), &archive)
+	if archive.FormatVersion != ArchiveFormatVersion || archive.Document.OwnerEmail != "owner@example.com" || len(archive.Collaborators) != 1 {
+		t.Errorf("Expected the document with its collaborator, got %+v", archive)
+	}
+	if archive.Document.InitialContent == nil || *archive.Document.InitialContent != "" {
+		t.Errorf("Expected the empty initial content, got %v", archive.Document.InitialContent)
+	}
+	if len(archive.Events) != 2 || archive.Events[0].Version == nil || *archive.Events[0].Version != 1 || archive.Events[1].UserEmail != "" {
+		t.Errorf("Expected an edit at version 1 and an event without a user, got %+v", archive.Events)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestImportDocument_Success(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	created := time.Date(2025, 1, 4, 10, 0, 0, 0, time.UTC)
+	version := 1
+	archive := Archive{
+		FormatVersion: ArchiveFormatVersion,
+		Document:      ArchivedDocument{Title: "Notes", Content: "Hello", OwnerEmail: "owner@example.com", CreatedAt: created},
+		Collaborators: []ArchivedCollaborator{
+			{Email: "editor@example.com", Permission: "edit", CreatedAt: created},
+			{Email: "gone@example.com", Permission: "view", CreatedAt: created},
+		},
+		Events: []ArchivedEvent{
+			{UserEmail: "editor@example.com", EventType: "edit", Payload: json.RawMessage(`{"version":1}`), Version: &version, CreatedAt: created},
+		},
+	}
+
+	expectUser := func(email string, id int) {
+		rows := sqlmock.NewRows([]string{"id"})
+		if id != 0 {
+			rows.AddRow(id)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = $1")).WithArgs(email).WillReturnRows(rows)
+	}
+
+	mock.ExpectBegin()
+	expectUser("owner@example.com", 4)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO documents (title, owner_id, content, content_type, published, search_language, encrypted, snapshot_version, content_version, edit_version, initial_content, created_at)")).
+		WithArgs("Notes", 4, "Hello", "text/plain", false, "english", false, 0, 1, nil, created).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	expectUser("editor@example.com", 5)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO document_collaborators (document_id, user_id, permission, created_at)")).
+		WithArgs(12, 5, "edit", created).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectUser("gone@example.com", 0)
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload, version, created_at)")).
+		ExpectExec().
+		WithArgs(12, 5, "edit", `{"version":1}`, 1, created).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(archive)
+	req, _ := http.NewRequest("POST", "/api/admin/documents/import", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var result ImportResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.DocumentId != 12 || result.Collaborators != 1 || result.Events != 1 || len(result.SkippedCollaborators) != 1 {
+		t.Errorf("Expected document 12 with one collaborator and event and one skipped, got %+v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestImportDocument_RejectsOtherFormatVersions(t *testing.T) {
+	mock, r := setupAdminTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectRole(mock, 1, auth.RoleAdmin)
+
+	req, _ := http.NewRequest("POST", "/api/admin/documents/import", bytes.NewBufferString(`{"format_version": 99, "document": {"title": "Notes"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,325 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/sanitize"
+	"time"
+)
+
+// ArchiveFormatVersion is the version of the archive layout written by
+// ExportDocument. ImportDocument rejects archives of other versions.
+const ArchiveFormatVersion = 1
+
+// ErrUnknownOwner is returned by ImportDocument when the archived owner has
+// no account in this deployment and no other owner was given.
+var ErrUnknownOwner = errors.New("the document owner has no account here")
+
+// Archive is a portable copy of a document with its collaborators and
+// event history. Users are identified by email rather than ID so an
+// archive can be imported into another deployment.
+type Archive struct {
+	FormatVersion int                    `json:"format_version"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Document      ArchivedDocument       `json:"document"`
+	Collaborators []ArchivedCollaborator `json:"collaborators"`
+	Events        []ArchivedEvent        `json:"events"`
+}
+
+type ArchivedDocument struct {
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+	OwnerEmail  string `json:"owner_email"`
+	Published   bool   `json:"published"`
+	// SearchLanguage is the text search configuration the document is
+	// indexed with.
+	SearchLanguage string `json:"search_language"`
+	// Encrypted documents are archived as ciphertext, as of
+	// SnapshotVersion. Members' wrapped keys aren't archived, so a member
+	// holding the key has to share it again after an import.
+	Encrypted       bool `json:"encrypted,omitempty"`
+	SnapshotVersion int  `json:"snapshot_version,omitempty"`
+	// InitialContent is the content the document was created with, which
+	// its edits replay from. It is absent when that isn't known.
+	InitialContent *string   `json:"initial_content,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ArchivedCollaborator struct {
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ArchivedEvent struct {
+	// UserEmail is empty for events whose user no longer exists.
+	UserEmail string          `json:"user_email,omitempty"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload" swaggertype:"object"`
+	Version   *int            `json:"version,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ImportResult describes a document created by ImportDocument.
+type ImportResult struct {
+	DocumentId    int `json:"document_id"`
+	Collaborators int `json:"collaborators"`
+	Events        int `json:"events"`
+	// SkippedCollaborators lists the emails of archived collaborators
+	// without an account here, who weren't added.
+	SkippedCollaborators []string `json:"skipped_collaborators"`
+}
+
+// ExportDocument reads a document, its collaborators, and its events as of
+// one point in time. Edits still buffered in memory by the WebSocket
+// document store aren't included until they are flushed.
+func (s *AdminService) ExportDocument(ctx context.Context, documentId int) (*Archive, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	archive := &Archive{
+		FormatVersion: ArchiveFormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Collaborators: []ArchivedCollaborator{},
+		Events:        []ArchivedEvent{},
+	}
+
+	doc := &archive.Document
+	err = tx.QueryRowContext(ctx, `
+		SELECT d.title, COALESCE(d.content, ''), COALESCE(d.content_type, 'text/plain'), u.email,
+			d.published, d.search_language::text, d.encrypted, d.snapshot_version, d.initial_content, d.created_at
+		FROM documents d
+		JOIN users u ON d.owner_id = u.id
+		WHERE d.id = $1
+	`, documentId).Scan(&doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerEmail, &doc.Published, &doc.SearchLanguage, &doc.Encrypted, &doc.SnapshotVersion, &doc.InitialContent, &doc.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, documents.ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to export document: %v", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT u.email, dc.permission, dc.created_at
+		FROM document_collaborators dc
+		JOIN users u ON dc.user_id = u.id
+		WHERE dc.document_id = $1
+		ORDER BY dc.id
+	`, documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export collaborators: %v", err)
+	}
+	for rows.Next() {
+		var collaborator ArchivedCollaborator
+		if err := rows.Scan(&collaborator.Email, &collaborator.Permission, &collaborator.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan collaborator: %v", err)
+		}
+		archive.Collaborators = append(archive.Collaborators, collaborator)
+	}
+	rows.Close()
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT u.email, e.event_type, e.payload, e.version, e.created_at
+		FROM events e
+		LEFT JOIN users u ON e.user_id = u.id
+		WHERE e.document_id = $1
+		ORDER BY e.created_at, e.id
+	`, documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export events: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event ArchivedEvent
+		var email sql.NullString
+		var version sql.NullInt64
+		var payload []byte
+		if err := rows.Scan(&email, &event.EventType, &payload, &version, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %v", err)
+		}
+		event.UserEmail = email.String
+		event.Payload = payload
+		if version.Valid {
+			v := int(version.Int64)
+			event.Version = &v
+		}
+		archive.Events = append(archive.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to export events: %v", err)
+	}
+
+	return archive, nil
+}
+
+// Validate reports the first problem that would keep an archive from being
+// imported.
+func (a *Archive) Validate() error {
+	if a.FormatVersion != ArchiveFormatVersion {
+		return fmt.Errorf("unsupported archive format version %d, expected %d", a.FormatVersion, ArchiveFormatVersion)
+	}
+	if a.Document.Title == "" {
+		return errors.New("the archived document has no title")
+	}
+	if a.Document.Encrypted && a.Document.Published {
+		return errors.New("the archived document is both encrypted and published")
+	}
+	for _, collaborator := range a.Collaborators {
+		if collaborator.Permission != "view" && collaborator.Permission != "edit" {
+			return fmt.Errorf("collaborator %s has invalid permission %q", collaborator.Email, collaborator.Permission)
+		}
+	}
+	for i, event := range a.Events {
+		if event.EventType == "" || len(event.Payload) == 0 {
+			return fmt.Errorf("event %d has no type or payload", i)
+		}
+	}
+	return nil
+}
+
+// ImportDocument creates a new document from an archive, keeping its
+// creation time and event history. The document is owned by the archived
+// owner's account with the same email, or by ownerId when it isn't zero.
+// Archived users are matched by email; collaborators without an account are
+// skipped and events by unknown users are kept without a user. Nothing is
+// written when the archive is invalid or any step fails.
+func (s *AdminService) ImportDocument(ctx context.Context, archive *Archive, ownerId int) (*ImportResult, error) {
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// userIds caches email lookups, with 0 for emails without an account
+	userIds := map[string]int{}
+	lookup := func(email string) (int, error) {
+		if id, ok := userIds[email]; ok || email == "" {
+			return id, nil
+		}
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", email).Scan(&id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("failed to look up user: %v", err)
+		}
+		userIds[email] = id
+		return id, nil
+	}
+
+	if ownerId == 0 {
+		if ownerId, err = lookup(archive.Document.OwnerEmail); err != nil {
+			return nil, err
+		}
+		if ownerId == 0 {
+			return nil, ErrUnknownOwner
+		}
+	}
+
+	doc := archive.Document
+	if doc.ContentType == "" {
+		doc.ContentType = "text/plain"
+	}
+	// archives may come from deployments that didn't sanitize
+	if sanitize.IsHTML(doc.ContentType) && !doc.Encrypted {
+		doc.Content = sanitize.HTML(doc.Content)
+	}
+	if doc.SearchLanguage == "" {
+		doc.SearchLanguage = "english"
+	}
+	if doc.CreatedAt.IsZero() {
+		doc.CreatedAt = time.Now()
+	}
+
+	// the archived content already includes every archived edit
+	contentVersion := 0
+	for _, event := range archive.Events {
+		if event.EventType == "edit" && event.Version != nil && *event.Version > contentVersion {
+			contentVersion = *event.Version
+		}
+	}
+
+	// without edits, the content is what they would start from
+	initialContent := doc.InitialContent
+	if initialContent == nil && contentVersion == 0 && !doc.Encrypted {
+		initialContent = &doc.Content
+	}
+
+	result := &ImportResult{SkippedCollaborators: []string{}}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO documents (title, owner_id, content, content_type, published, search_language, encrypted, snapshot_version, content_version, edit_version, initial_content, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10, $11)
+		RETURNING id
+	`, doc.Title, ownerId, doc.Content, doc.ContentType, doc.Published, doc.SearchLanguage, doc.Encrypted, doc.SnapshotVersion, contentVersion, initialContent, doc.CreatedAt).Scan(&result.DocumentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import document: %v", err)
+	}
+
+	for _, collaborator := range archive.Collaborators {
+		userId, err := lookup(collaborator.Email)
+		if err != nil {
+			return nil, err
+		}
+		if userId == 0 || userId == ownerId {
+			result.SkippedCollaborators = append(result.SkippedCollaborators, collaborator.Email)
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO document_collaborators (document_id, user_id, permission, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (document_id, user_id) DO NOTHING
+		`, result.DocumentId, userId, collaborator.Permission, collaborator.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import collaborator: %v", err)
+		}
+		result.Collaborators++
+	}
+
+	insertEvent, err := tx.PrepareContext(ctx, `
+		INSERT INTO events (document_id, user_id, event_type, payload, version, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare event import: %v", err)
+	}
+	defer insertEvent.Close()
+
+	for _, event := range archive.Events {
+		userId, err := lookup(event.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		var user, version any
+		if userId != 0 {
+			user = userId
+		}
+		if event.Version != nil {
+			version = *event.Version
+		}
+		if event.CreatedAt.IsZero() {
+			event.CreatedAt = doc.CreatedAt
+		}
+
+		if _, err := insertEvent.ExecContext(ctx, result.DocumentId, user, event.EventType, string(event.Payload), version, event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to import event: %v", err)
+		}
+		result.Events++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,322 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"live-collab-api/internal/websocket"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	AdminService    *AdminService
+	DocumentService *documents.DocumentService
+	AuthService     *auth.AuthService
+	// Hub, when set, provides the live connection counts in GetStats.
+	Hub *websocket.Hub
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description List all users with their role, number of owned documents, and last login. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param search query string false "Only users whose email contains this text"
+// @Param inactive_days query int false "Only users who haven't logged in for this many days, or at all"
+// @Param limit query int false "Number of users to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of users to skip (default 0)" default(0)
+// @Success 200 {object} UserListResponse "List of users"
+// @Failure 400 {object} ErrorResponse "Invalid inactive_days, limit, or offset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/users [get]
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	var page validation.Page
+	if !validation.BindQuery(c, &page) {
+		return
+	}
+
+	var inactiveFor time.Duration
+	if value := c.Query("inactive_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "inactive_days must be a positive number of days"})
+			return
+		}
+		inactiveFor = time.Duration(days) * 24 * time.Hour
+	}
+
+	users, err := h.AdminService.ListUsers(c.Request.Context(), c.Query("search"), inactiveFor, page.Limit, page.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users, "limit": page.Limit, "offset": page.Offset})
+}
+
+// ListDocuments godoc
+// @Summary List all documents
+// @Description List every document with its owner and content size. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Number of documents to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of documents to skip (default 0)" default(0)
+// @Success 200 {object} DocumentListResponse "List of documents"
+// @Failure 400 {object} ErrorResponse "Invalid limit or offset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents [get]
+func (h *AdminHandler) ListDocuments(c *gin.Context) {
+	var page validation.Page
+	if !validation.BindQuery(c, &page) {
+		return
+	}
+
+	documents, err := h.AdminService.ListDocuments(c.Request.Context(), page.Limit, page.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": documents, "limit": page.Limit, "offset": page.Offset})
+}
+
+// GetStats godoc
+// @Summary System statistics
+// @Description Get user, document, and event counts along with live WebSocket connections on this instance. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} StatsResponse "System statistics"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/stats [get]
+func (h *AdminHandler) GetStats(c *gin.Context) {
+	stats, err := h.AdminService.GetStats(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	response := StatsResponse{Stats: *stats}
+	if h.Hub != nil {
+		response.ActiveConnections, response.ActiveDocuments = h.Hub.GetClientCount()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteDocument godoc
+// @Summary Force-delete a document
+// @Description Delete any document with its events and collaborators, regardless of owner. Requires the admin role. This action cannot be undone.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} MessageResponse "Document deleted successfully"
+// @Failure 400 {object} ErrorResponse "Invalid document ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents/{id} [delete]
+func (h *AdminHandler) DeleteDocument(c *gin.Context) {
+	documentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
+		return
+	}
+
+	if err := h.DocumentService.DeleteDocument(c.Request.Context(), documentId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// ReassignDocument godoc
+// @Summary Reassign a document
+// @Description Make another user the owner of a document. The previous owner loses access unless added back as a collaborator. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body ReassignDocumentRequest true "New owner"
+// @Success 200 {object} MessageResponse "Document reassigned successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 404 {object} ErrorResponse "Document or user not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents/{id}/owner [put]
+func (h *AdminHandler) ReassignDocument(c *gin.Context) {
+	documentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
+		return
+	}
+
+	var req ReassignDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userExists, err := h.AuthService.UserExists(c.Request.Context(), req.UserID)
+	if err != nil || !userExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := h.DocumentService.TransferOwnership(c.Request.Context(), documentId, req.UserID); err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reassign document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document reassigned successfully"})
+}
+
+// ExportDocument godoc
+// @Summary Export a document
+// @Description Download a document with its collaborators and full event history as a portable JSON archive, for moving it to another deployment or restoring it later with the import endpoint. Users are identified by email. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Archive "Document archive"
+// @Failure 400 {object} ErrorResponse "Invalid document ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents/{id}/export [get]
+func (h *AdminHandler) ExportDocument(c *gin.Context) {
+	documentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
+		return
+	}
+
+	archive, err := h.AdminService.ExportDocument(c.Request.Context(), documentId)
+	if err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export document"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="document-%d.json"`, documentId))
+	c.JSON(http.StatusOK, archive)
+}
+
+// ImportDocument godoc
+// @Summary Import a document
+// @Description Create a new document from an archive made by the export endpoint, keeping its creation time and event history. The document is owned by the user with the archived owner's email unless owner_id is given. Collaborators without an account here are skipped, and events by unknown users are kept without a user. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param owner_id query int false "Owner of the imported document, instead of the archived owner"
+// @Param request body Archive true "Document archive"
+// @Success 201 {object} ImportResult "Document imported"
+// @Failure 400 {object} ErrorResponse "Invalid archive, or the archived owner has no account here"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 404 {object} ErrorResponse "Owner not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents/import [post]
+func (h *AdminHandler) ImportDocument(c *gin.Context) {
+	ownerId := 0
+	if value := c.Query("owner_id"); value != "" {
+		var err error
+		if ownerId, err = strconv.Atoi(value); err != nil || ownerId <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid owner_id"})
+			return
+		}
+		exists, err := h.AuthService.UserExists(c.Request.Context(), ownerId)
+		if err != nil || !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Owner not found"})
+			return
+		}
+	}
+
+	var archive Archive
+	if err := c.ShouldBindJSON(&archive); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := archive.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.AdminService.ImportDocument(c.Request.Context(), &archive, ownerId)
+	if err != nil {
+		if errors.Is(err, ErrUnknownOwner) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The archived owner has no account here, pass owner_id to choose one"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import document"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// swagger models for admin
+
+type ReassignDocumentRequest struct {
+	UserID int `json:"user_id" binding:"required" example:"2"`
+}
+
+type StatsResponse struct {
+	Stats
+	ActiveConnections int `json:"active_connections" example:"12"`
+	ActiveDocuments   int `json:"active_documents" example:"4"`
+}
+
+type UserListResponse struct {
+	Users  []User `json:"users"`
+	Limit  int    `json:"limit" example:"50"`
+	Offset int    `json:"offset" example:"0"`
+}
+
+type DocumentListResponse struct {
+	Documents []Document `json:"documents"`
+	Limit     int        `json:"limit" example:"50"`
+	Offset    int        `json:"offset" example:"0"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Document deleted successfully"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Admin access required"`
+}
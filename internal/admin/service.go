@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type AdminService struct {
+	DB *sql.DB
+}
+
+type User struct {
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	DocumentCount int    `json:"document_count"`
+	CreatedAt     string `json:"created_at"`
+	// LastLoginAt is null for users who haven't logged in since logins
+	// were tracked.
+	LastLoginAt *time.Time `json:"last_login_at"`
+	LastLoginIP string     `json:"last_login_ip,omitempty"`
+}
+
+type Document struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	OwnerId    int    `json:"owner_id"`
+	OwnerEmail string `json:"owner_email"`
+	// SizeBytes is the length of the content in bytes.
+	SizeBytes int    `json:"size_bytes"`
+	Published bool   `json:"published"`
+	CreatedAt string `json:"created_at"`
+}
+
+type Stats struct {
+	Users     int `json:"users"`
+	Documents int `json:"documents"`
+	// EventsLastDay counts events stored in the past 24 hours.
+	EventsLastDay int `json:"events_last_day"`
+}
+
+// ListUsers returns users whose email contains search (all users when it
+// is empty), oldest first. With inactiveFor set only users who haven't
+// logged in for that long, or at all, are listed.
+func (s *AdminService) ListUsers(ctx context.Context, search string, inactiveFor time.Duration, limit, offset int) ([]User, error) {
+	var loggedInBefore sql.NullTime
+	if inactiveFor > 0 {
+		loggedInBefore = sql.NullTime{Time: time.Now().Add(-inactiveFor), Valid: true}
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT u.id, u.email, u.role, COUNT(d.id), u.created_at, u.last_login_at, COALESCE(u.last_login_ip, '')
+		FROM users u
+		LEFT JOIN documents d ON d.owner_id = u.id
+		WHERE u.email ILIKE '%' || $1 || '%'
+			AND ($2::timestamptz IS NULL OR u.last_login_at IS NULL OR u.last_login_at < $2)
+		GROUP BY u.id
+		ORDER BY u.id
+		LIMIT $3 OFFSET $4
+	`, search, loggedInBefore, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Email, &user.Role, &user.DocumentCount, &user.CreatedAt, &user.LastLoginAt, &user.LastLoginIP); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ListDocuments returns all documents with their owners, newest first.
+func (s *AdminService) ListDocuments(ctx context.Context, limit, offset int) ([]Document, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT d.id, d.title, d.owner_id, u.email, OCTET_LENGTH(COALESCE(d.content, '')), d.published, d.created_at
+		FROM documents d
+		JOIN users u ON d.owner_id = u.id
+		ORDER BY d.created_at DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %v", err)
+	}
+	defer rows.Close()
+
+	documents := []Document{}
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerId, &doc.OwnerEmail, &doc.SizeBytes, &doc.Published, &doc.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		documents = append(documents, doc)
+	}
+	return documents, nil
+}
+
+func (s *AdminService) GetStats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM documents),
+			(SELECT COUNT(*) FROM events WHERE created_at > NOW() - INTERVAL '1 day')
+	`).Scan(&stats.Users, &stats.Documents, &stats.EventsLastDay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %v", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,152 @@
+package archiving
+
+import (
+	"context"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func setupArchiveTest(t *testing.T) (*ArchiveService, *fakeMailer, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mail := &fakeMailer{}
+	return &ArchiveService{
+		DB:          db,
+		Documents:   &documents.DocumentService{DB: db},
+		Mailer:      mail,
+		FrontendUrl: "https://collab.example.com",
+		After:       90 * 24 * time.Hour,
+		Warning:     7 * 24 * time.Hour,
+	}, mail, mock
+}
+
+func TestWarn_GroupsByOwner(t *testing.T) {
+	service, _, mock := setupArchiveTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE documents d SET archive_warned_at = now()")).
+		WithArgs(int((83 * 24 * time.Hour).Seconds()), maxDocuments).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "title"}).
+			AddRow(3, 1, "Old plans").
+			AddRow(4, 2, "Notes").
+			AddRow(5, 1, "Older plans"))
+
+	warnings, err := service.Warn(context.Background())
+	if err != nil {
+		t.Fatalf("Warn failed: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Expected warnings for 2 owners, got %+v", warnings)
+	}
+	if warnings[0].OwnerId != 1 || len(warnings[0].Documents) != 2 || warnings[0].Documents[1].Title != "Older plans" {
+		t.Errorf("Unexpected warning: %+v", warnings[0])
+	}
+	if until := time.Until(warnings[0].ArchiveAt); until < 6*24*time.Hour || until > 7*24*time.Hour {
+		t.Errorf("Expected documents to be archived in a week, got %v", warnings[0].ArchiveAt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	service, _, mock := setupArchiveTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE documents d SET archived_at = now()")).
+		WithArgs(int((7 * 24 * time.Hour).Seconds()), maxDocuments).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(5))
+
+	archived, err := service.Archive(context.Background())
+	if err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+	if archived != 2 {
+		t.Errorf("Expected 2 documents archived, got %d", archived)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSendWarning(t *testing.T) {
+	service, mail, mock := setupArchiveTest(t)
+	warning := Warning{
+		OwnerId:   1,
+		ArchiveAt: time.Date(2025, 10, 1, 17, 0, 0, 0, time.UTC),
+		Documents: []WarnedDocument{{DocumentId: 3, Title: "Old plans"}, {DocumentId: 5, Title: "Older plans"}},
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT email FROM users WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("alice@example.com"))
+
+	if err := service.SendWarning(context.Background(), warning); err != nil {
+		t.Fatalf("SendWarning failed: %v", err)
+	}
+	if len(mail.sent) != 1 {
+		t.Fatalf("Expected one email, got %d", len(mail.sent))
+	}
+	msg := mail.sent[0]
+	if msg.To != "alice@example.com" || msg.Subject != "2 of your documents will be archived on Wed, 1 Oct" {
+		t.Errorf("Unexpected email: %+v", msg)
+	}
+	if !strings.Contains(msg.Body, "- Old plans\n- Older plans\n") {
+		t.Errorf("Expected the documents to be listed, got %q", msg.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListInactive_DefaultsToArchivePeriod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, _, mock := setupArchiveTest(t)
+	handler := &ArchiveHandler{ArchiveService: service}
+
+	router := gin.New()
+	router.GET("/api/admin/documents/inactive", handler.ListInactive)
+
+	lastActivity := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM documents d JOIN users u ON d.owner_id = u.id")).
+		WithArgs(int(service.After.Seconds()), 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "owner_id", "email", "last_activity", "warned_at", "archived_at"}).
+			AddRow(3, "Old plans", 1, "alice@example.com", lastActivity, nil, nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/documents/inactive", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"owner_email":"alice@example.com"`) {
+		t.Errorf("Unexpected response: %s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,75 @@
+package archiving
+
+import (
+	"live-collab-api/internal/validation"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultInactiveDays is the report's inactivity period when archiving is
+// disabled and none is given.
+const defaultInactiveDays = 90
+
+type ArchiveHandler struct {
+	ArchiveService *ArchiveService
+}
+
+// ListInactive godoc
+// @Summary List inactive documents
+// @Description List documents nobody has edited or read for a while, least recently used first, with whether their owner was warned and whether they were archived. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param days query int false "Minimum days without activity (1-3650, defaults to the archiving period, or 90 when archiving is disabled)"
+// @Param limit query int false "Number of documents to return (1-1000)" default(50)
+// @Param offset query int false "Number of documents to skip (default 0)" default(0)
+// @Success 200 {object} InactiveListResponse "Inactive documents"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/documents/inactive [get]
+func (h *ArchiveHandler) ListInactive(c *gin.Context) {
+	var req InactiveQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+
+	inactiveFor := time.Duration(req.Days) * 24 * time.Hour
+	if req.Days == 0 {
+		inactiveFor = h.ArchiveService.After
+		if inactiveFor == 0 {
+			inactiveFor = defaultInactiveDays * 24 * time.Hour
+		}
+	}
+
+	documents, err := h.ArchiveService.ListInactive(c.Request.Context(), inactiveFor, req.Limit, req.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list inactive documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": documents, "limit": req.Limit, "offset": req.Offset})
+}
+
+// swagger models for archiving
+
+type InactiveQuery struct {
+	Days   int `form:"days" binding:"omitempty,min=1,max=3650"`
+	Limit  int `form:"limit,default=50" binding:"min=1,max=1000"`
+	Offset int `form:"offset,default=0" binding:"min=0"`
+}
+
+type InactiveListResponse struct {
+	Documents []InactiveDocument `json:"documents"`
+	Limit     int                `json:"limit" example:"50"`
+	Offset    int                `json:"offset" example:"0"`
+}
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,232 @@
+package archiving
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/mailer"
+	"strings"
+	"time"
+)
+
+// JobWarn is the job type that emails an owner about documents about to be
+// archived.
+const JobWarn = "archiving.warn"
+
+// maxDocuments caps how many documents one Warn or Archive handles, so a
+// backlog is worked through over several runs.
+const maxDocuments = 500
+
+// lastActivity is when a document d was last created, edited, read, or
+// unarchived.
+const lastActivity = `GREATEST(d.created_at, d.updated_at, d.unarchived_at,
+	(SELECT MAX(dr.updated_at) FROM document_reads dr WHERE dr.document_id = d.id))`
+
+// WarnedDocument is a document its owner was warned about.
+type WarnedDocument struct {
+	DocumentId int    `json:"document_id"`
+	Title      string `json:"title"`
+}
+
+// Warning tells an owner which of their documents are archived at
+// ArchiveAt unless they are used before then. It is the payload of an
+// archiving.warn job.
+type Warning struct {
+	OwnerId   int              `json:"owner_id"`
+	ArchiveAt time.Time        `json:"archive_at"`
+	Documents []WarnedDocument `json:"documents"`
+}
+
+// InactiveDocument is a document nobody has used for a while.
+type InactiveDocument struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"title"`
+	OwnerId      int       `json:"owner_id"`
+	OwnerEmail   string    `json:"owner_email"`
+	LastActivity time.Time `json:"last_activity"`
+	// WarnedAt is when the owner was warned the document would be
+	// archived, if they were since it was last used.
+	WarnedAt   *time.Time `json:"warned_at,omitempty"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// ArchiveService archives documents nobody has edited or read for a while,
+// warning their owners first. Archived documents stay accessible but are
+// left out of document lists.
+type ArchiveService struct {
+	DB *sql.DB
+	// Documents' cache entries are dropped as documents are archived.
+	Documents *documents.DocumentService
+	// Mailer emails warnings. When nil documents are archived without
+	// warning their owners.
+	Mailer mailer.Mailer
+	// FrontendUrl is linked from warning emails.
+	FrontendUrl string
+	// After is how long a document goes unused before it is archived, and
+	// Warning how long before then its owner is warned. After is zero
+	// when archiving is disabled.
+	After   time.Duration
+	Warning time.Duration
+}
+
+// Warn marks the documents due to be archived within the warning period
+// and returns them grouped by owner. Documents used after a warning are
+// warned about again once they fall inactive again.
+func (s *ArchiveService) Warn(ctx context.Context) ([]Warning, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH stale AS (
+			SELECT d.id FROM documents d
+			WHERE d.archived_at IS NULL
+				AND `+lastActivity+` < now() - $1 * interval '1 second'
+				AND (d.archive_warned_at IS NULL OR d.archive_warned_at < `+lastActivity+`)
+			ORDER BY d.id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE documents d SET archive_warned_at = now()
+		FROM stale WHERE d.id = stale.id
+		RETURNING d.id, d.owner_id, d.title
+	`, int((s.After - s.Warning).Seconds()), maxDocuments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to warn about inactive documents: %v", err)
+	}
+	defer rows.Close()
+
+	archiveAt := time.Now().Add(s.Warning).UTC()
+	var warnings []Warning
+	byOwner := map[int]int{}
+	for rows.Next() {
+		var ownerId int
+		var doc WarnedDocument
+		if err := rows.Scan(&doc.DocumentId, &ownerId, &doc.Title); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		i, ok := byOwner[ownerId]
+		if !ok {
+			i = len(warnings)
+			byOwner[ownerId] = i
+			warnings = append(warnings, Warning{OwnerId: ownerId, ArchiveAt: archiveAt})
+		}
+		warnings[i].Documents = append(warnings[i].Documents, doc)
+	}
+	return warnings, rows.Err()
+}
+
+// Archive archives the documents whose owners were warned at least the
+// warning period ago and that nobody has used since, and returns how many
+// it archived.
+func (s *ArchiveService) Archive(ctx context.Context) (int, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH stale AS (
+			SELECT d.id FROM documents d
+			WHERE d.archived_at IS NULL
+				AND d.archive_warned_at <= now() - $1 * interval '1 second'
+				AND `+lastActivity+` < d.archive_warned_at
+			ORDER BY d.id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE documents d SET archived_at = now()
+		FROM stale WHERE d.id = stale.id
+		RETURNING d.id
+	`, int(s.Warning.Seconds()), maxDocuments)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive inactive documents: %v", err)
+	}
+	defer rows.Close()
+
+	var archived []int
+	for rows.Next() {
+		var documentId int
+		if err := rows.Scan(&documentId); err != nil {
+			return 0, fmt.Errorf("failed to scan document: %v", err)
+		}
+		archived = append(archived, documentId)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to archive inactive documents: %v", err)
+	}
+
+	for _, documentId := range archived {
+		s.Documents.Cache.InvalidateDocument(ctx, documentId)
+	}
+	return len(archived), nil
+}
+
+// SendWarning emails a warning to the owner, unless they were deleted
+// since it was queued.
+func (s *ArchiveService) SendWarning(ctx context.Context, w Warning) error {
+	if s.Mailer == nil {
+		return errors.New("email is not configured")
+	}
+
+	var email string
+	err := s.DB.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", w.OwnerId).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %v", err)
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: subject(w),
+		Body:    render(w, s.FrontendUrl),
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send archiving warning: %v", err)
+	}
+	return nil
+}
+
+// ListInactive returns the documents nobody has used for at least
+// inactiveFor, archived or not, least recently used first.
+func (s *ArchiveService) ListInactive(ctx context.Context, inactiveFor time.Duration, limit, offset int) ([]InactiveDocument, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, title, owner_id, email, last_activity,
+			CASE WHEN archive_warned_at >= last_activity THEN archive_warned_at END, archived_at
+		FROM (
+			SELECT d.id, d.title, d.owner_id, u.email, d.archive_warned_at, d.archived_at,
+				`+lastActivity+` AS last_activity
+			FROM documents d
+			JOIN users u ON d.owner_id = u.id
+		) d
+		WHERE last_activity < now() - $1 * interval '1 second'
+		ORDER BY last_activity, id
+		LIMIT $2 OFFSET $3
+	`, int(inactiveFor.Seconds()), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inactive documents: %v", err)
+	}
+	defer rows.Close()
+
+	inactive := []InactiveDocument{}
+	for rows.Next() {
+		var doc InactiveDocument
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.OwnerId, &doc.OwnerEmail, &doc.LastActivity, &doc.WarnedAt, &doc.ArchivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		inactive = append(inactive, doc)
+	}
+	return inactive, rows.Err()
+}
+
+func subject(w Warning) string {
+	if len(w.Documents) == 1 {
+		return fmt.Sprintf("%s will be archived on %s", w.Documents[0].Title, w.ArchiveAt.Format("Mon, 2 Jan"))
+	}
+	return fmt.Sprintf("%d of your documents will be archived on %s", len(w.Documents), w.ArchiveAt.Format("Mon, 2 Jan"))
+}
+
+func render(w Warning, frontendUrl string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Nobody has edited or opened these documents in a while, so they will be archived on %s:\n\n", w.ArchiveAt.Format("Mon, 2 Jan 2006"))
+	for _, doc := range w.Documents {
+		fmt.Fprintf(&b, "- %s\n", doc.Title)
+	}
+	fmt.Fprintf(&b, "\nArchived documents are left out of your document list but aren't deleted. To keep one, open it at %s or unarchive it.\n", frontendUrl)
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupAuditTest(t *testing.T) (*AuditService, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &AuditService{DB: db}, mock
+}
+
+func TestMiddleware_RecordsWrites(t *testing.T) {
+	service, mock := setupAuditTest(t)
+
+	r := gin.New()
+	r.Use(service.Middleware())
+	authenticated := func(c *gin.Context) {
+		c.Set("userId", 3)
+		c.Next()
+	}
+	r.PATCH("/api/documents/:id", authenticated, func(c *gin.Context) {
+		c.Status(http.StatusForbidden)
+	})
+	r.GET("/api/documents/:id", authenticated, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_log")).
+		WithArgs(3, 5, "PATCH", "/api/documents/5", "/api/documents/:id", http.StatusForbidden, sqlmock.AnyArg(), sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	for _, method := range []string{"PATCH", "GET"} {
+		req, _ := http.NewRequest(method, "/api/documents/5", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestList_Filters(t *testing.T) {
+	service, mock := setupAuditTest(t)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := since.Add(time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM audit_log WHERE user_id = $1 AND method = $2 AND created_at >= $3 ORDER BY id DESC LIMIT $4 OFFSET $5")).
+		WithArgs(3, "DELETE", since, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "document_id", "method", "path", "route", "status", "duration_ms", "client_ip", "request_id", "created_at"}).
+			AddRow(7, 3, nil, "DELETE", "/api/documents/5", "/api/documents/:id", 204, 4, "203.0.113.7", "req-1", createdAt))
+
+	entries, err := service.List(t.Context(), Filter{UserId: 3, Method: "DELETE", Since: since}, 50, 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].UserId == nil || *entries[0].UserId != 3 || entries[0].DocumentId != nil {
+		t.Errorf("Unexpected entry %+v", entries[0])
+	}
+}
+
+func TestListAuditLog_InvalidFilter(t *testing.T) {
+	service, _ := setupAuditTest(t)
+	handler := &AuditHandler{AuditService: service}
+
+	r := gin.New()
+	r.GET("/api/admin/audit", handler.ListAuditLog)
+
+	for _, query := range []string{"user_id=me", "since=yesterday", "limit=0", "offset=-1"} {
+		req, _ := http.NewRequest("GET", "/api/admin/audit?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
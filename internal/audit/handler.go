@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"live-collab-api/internal/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	AuditService *AuditService
+}
+
+// ListAuditLog godoc
+// @Summary List audit log entries
+// @Description List recorded write requests with who made them, the document they touched, their outcome, and duration, most recent first. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param user_id query int false "Only requests made by this user"
+// @Param document_id query int false "Only requests on this document"
+// @Param method query string false "Only requests with this HTTP method"
+// @Param since query string false "Only requests at or after this time (RFC 3339)"
+// @Param until query string false "Only requests before this time (RFC 3339)"
+// @Param limit query int false "Number of entries to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of entries to skip (default 0)" default(0)
+// @Success 200 {object} AuditLogResponse "Audit log entries"
+// @Failure 400 {object} ErrorResponse "Invalid filter, limit, or offset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/audit [get]
+func (h *AuditHandler) ListAuditLog(c *gin.Context) {
+	var filter Filter
+	var err error
+
+	if value := c.Query("user_id"); value != "" {
+		if filter.UserId, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+	}
+	if value := c.Query("document_id"); value != "" {
+		if filter.DocumentId, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document_id"})
+			return
+		}
+	}
+	if value := c.Query("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+			return
+		}
+	}
+	if value := c.Query("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be an RFC 3339 time"})
+			return
+		}
+	}
+	filter.Method = strings.ToUpper(c.Query("method"))
+
+	var page validation.Page
+	if !validation.BindQuery(c, &page) {
+		return
+	}
+	entries, err := h.AuditService.List(c.Request.Context(), filter, page.Limit, page.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "limit": page.Limit, "offset": page.Offset})
+}
+
+// swagger models for audit
+
+type AuditLogResponse struct {
+	Entries []Entry `json:"entries"`
+	Limit   int     `json:"limit" example:"50"`
+	Offset  int     `json:"offset" example:"0"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Admin access required"`
+}
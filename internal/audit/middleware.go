@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware records every request other than GET, HEAD, and OPTIONS in the
+// audit log once it has been handled, including rejected ones. The user and
+// document are taken from what later middleware set on the context, so it
+// must run before authentication.
+func (s *AuditService) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		entry := Entry{
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Route:      c.FullPath(),
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+			ClientIP:   c.ClientIP(),
+			RequestId:  c.GetString("requestId"),
+		}
+		if userId := c.GetInt("userId"); userId != 0 {
+			entry.UserId = &userId
+		}
+		if documentId, ok := documentID(c); ok {
+			entry.DocumentId = &documentId
+		}
+
+		// record the entry even if the client has gone away
+		ctx := context.WithoutCancel(c.Request.Context())
+		if err := s.Record(ctx, entry); err != nil {
+			slog.ErrorContext(ctx, "Failed to write audit log", "error", err)
+		}
+	}
+}
+
+// documentID returns the document a request acted on: the one checked by
+// the document access middleware, or the :id of a document route it didn't
+// reach.
+func documentID(c *gin.Context) (int, bool) {
+	if documentId := c.GetInt("documentId"); documentId != 0 {
+		return documentId, true
+	}
+	if !strings.Contains(c.FullPath(), "/documents/:id") {
+		return 0, false
+	}
+	documentId, err := strconv.Atoi(c.Param("id"))
+	return documentId, err == nil
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Entry records one write request.
+type Entry struct {
+	Id         int64     `json:"id" example:"1"`
+	UserId     *int      `json:"user_id,omitempty" example:"1"`
+	DocumentId *int      `json:"document_id,omitempty" example:"5"`
+	Method     string    `json:"method" example:"PATCH"`
+	Path       string    `json:"path" example:"/api/documents/5"`
+	Route      string    `json:"route,omitempty" example:"/api/documents/:id"`
+	Status     int       `json:"status" example:"200"`
+	DurationMs int64     `json:"duration_ms" example:"12"`
+	ClientIP   string    `json:"client_ip,omitempty" example:"203.0.113.7"`
+	RequestId  string    `json:"request_id,omitempty" example:"6f1c0d3e-5b5a-4c1e-9d7e-2a4b3c5d6e7f"`
+	CreatedAt  time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// Filter narrows the entries listed. Zero values match everything.
+type Filter struct {
+	UserId     int
+	DocumentId int
+	Method     string
+	Since      time.Time
+	Until      time.Time
+}
+
+type AuditService struct {
+	DB *sql.DB
+}
+
+// Record appends entry to the audit log.
+func (s *AuditService) Record(ctx context.Context, entry Entry) error {
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO audit_log (user_id, document_id, method, path, route, status, duration_ms, client_ip, request_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), NULLIF($9, ''))`,
+		entry.UserId, entry.DocumentId, entry.Method, entry.Path, entry.Route,
+		entry.Status, entry.DurationMs, entry.ClientIP, entry.RequestId)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %v", err)
+	}
+	return nil
+}
+
+// List returns the entries matching filter, most recent first.
+func (s *AuditService) List(ctx context.Context, filter Filter, limit, offset int) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserId != 0 {
+		add("user_id = $%d", filter.UserId)
+	}
+	if filter.DocumentId != 0 {
+		add("document_id = $%d", filter.DocumentId)
+	}
+	if filter.Method != "" {
+		add("method = $%d", filter.Method)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+
+	query := `SELECT id, user_id, document_id, method, path, COALESCE(route, ''), status, duration_ms,
+		COALESCE(client_ip, ''), COALESCE(request_id, ''), created_at
+		FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var userId, documentId sql.NullInt64
+		if err := rows.Scan(&entry.Id, &userId, &documentId, &entry.Method, &entry.Path, &entry.Route,
+			&entry.Status, &entry.DurationMs, &entry.ClientIP, &entry.RequestId, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %v", err)
+		}
+		if userId.Valid {
+			id := int(userId.Int64)
+			entry.UserId = &id
+		}
+		if documentId.Valid {
+			id := int(documentId.Int64)
+			entry.DocumentId = &id
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %v", err)
+	}
+	return entries, nil
+}
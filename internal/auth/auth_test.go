@@ -2,17 +2,31 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"live-collab-api/internal/mailer"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
 )
 
 func setupTest(t *testing.T) (*AuthService, sqlmock.Sqlmock, *gin.Engine) {
@@ -32,6 +46,28 @@ func setupTest(t *testing.T) (*AuthService, sqlmock.Sqlmock, *gin.Engine) {
 	return authService, mock, r
 }
 
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// expectSession expects a login of userId to start session sessionId.
+func expectSession(mock sqlmock.Sqlmock, userId, sessionId int) {
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE user_id = $1 AND expires_at < NOW()")).
+		WithArgs(userId).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO sessions (user_id, user_agent, ip, expires_at)")).
+		WithArgs(userId, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(sessionId))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET last_login_at = NOW(), last_login_ip = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), userId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func TestHashAndCheckPassword(t *testing.T) {
 	password := "secret123"
 
@@ -65,7 +101,7 @@ func TestJWTToken(t *testing.T) {
 		t.Fatal("Generated token is empty")
 	}
 
-	parsedID, err := authService.GetUserIDFromToken(token)
+	parsedID, err := authService.GetUserIDFromToken(context.Background(), token)
 	if err != nil {
 		t.Errorf("Error getting user id from token: %v", err)
 	}
@@ -74,12 +110,130 @@ func TestJWTToken(t *testing.T) {
 	}
 }
 
+func TestJWTToken_Rotation(t *testing.T) {
+	oldToken, _ := GenerateJWT(1, "old-secret")
+	newToken, _ := GenerateJWT(2, "new-secret")
+
+	rotated := &AuthService{JWTSecret: "new-secret", PreviousJWTSecrets: []string{"old-secret"}}
+	for token, want := range map[string]int{oldToken: 1, newToken: 2} {
+		if userID, err := rotated.GetUserIDFromToken(context.Background(), token); err != nil || userID != want {
+			t.Errorf("Expected user %d after rotation, got %d, %v", want, userID, err)
+		}
+	}
+
+	retired := &AuthService{JWTSecret: "new-secret"}
+	if _, err := retired.GetUserIDFromToken(context.Background(), oldToken); err == nil {
+		t.Error("Expected a token signed with a retired secret to be rejected")
+	}
+
+	// tokens from before kids were added are tried against every secret
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 3, "exp": time.Now().Add(time.Hour).Unix()})
+	legacyToken, _ := legacy.SignedString([]byte("old-secret"))
+	if userID, err := rotated.GetUserIDFromToken(context.Background(), legacyToken); err != nil || userID != 3 {
+		t.Errorf("Expected a token without kid to be verified with the previous secret, got %d, %v", userID, err)
+	}
+}
+
+func TestJWTToken_IssuerAndAudience(t *testing.T) {
+	authService := &AuthService{JWTSecret: "test-secret", TokenTTL: time.Hour, Issuer: "https://api.example.com", Audience: "live-collab"}
+	token, err := authService.signToken(newClaims(1, 0, time.Now().Add(authService.tokenTTL())))
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	userID, expiresAt, err := authService.GetUserIDAndExpiryFromToken(context.Background(), token)
+	if err != nil || userID != 1 {
+		t.Fatalf("Expected user 1, got %d, %v", userID, err)
+	}
+	if remaining := time.Until(expiresAt); remaining < 59*time.Minute || remaining > time.Hour {
+		t.Errorf("Expected token to expire in about 1h, got %v", remaining)
+	}
+
+	// tokens from elsewhere, or from before the claims were configured,
+	// are rejected
+	unscoped, _ := GenerateJWT(1, "test-secret")
+	other, _ := (&AuthService{JWTSecret: "test-secret", Issuer: "https://api.example.com", Audience: "billing"}).signToken(newClaims(1, 0, time.Now().Add(time.Hour)))
+	for _, token := range []string{unscoped, other} {
+		if _, err := authService.GetUserIDFromToken(context.Background(), token); err == nil {
+			t.Errorf("Expected token %s to be rejected", token)
+		}
+	}
+}
+
+func TestJWTToken_KeyPairs(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	rsaPair, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	if err != nil {
+		t.Fatalf("Error parsing RSA key: %v", err)
+	}
+	ecPair, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))
+	if err != nil {
+		t.Fatalf("Error parsing ECDSA key: %v", err)
+	}
+	if rsaPair.Method.Alg() != "RS256" || ecPair.Method.Alg() != "ES256" {
+		t.Fatalf("Expected RS256 and ES256, got %s and %s", rsaPair.Method.Alg(), ecPair.Method.Alg())
+	}
+
+	authService := &AuthService{JWTSecret: "test-secret", SigningKey: rsaPair}
+	rsaToken, err := authService.signToken(newClaims(1, 0, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	hmacToken, _ := GenerateJWT(2, "test-secret")
+
+	// the RSA key is replaced, and only its public key kept
+	rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	previous, err := ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER}))
+	if err != nil || len(previous) != 1 || previous[0].ID != rsaPair.ID {
+		t.Fatalf("Expected the previous public key to keep its ID, got %v, %v", previous, err)
+	}
+	authService = &AuthService{JWTSecret: "test-secret", SigningKey: ecPair, PreviousKeys: previous}
+	ecToken, _ := authService.signToken(newClaims(3, 0, time.Now().Add(time.Hour)))
+
+	for token, want := range map[string]int{rsaToken: 1, hmacToken: 2, ecToken: 3} {
+		if userID, err := authService.GetUserIDFromToken(context.Background(), token); err != nil || userID != want {
+			t.Errorf("Expected user %d, got %d, %v", want, userID, err)
+		}
+	}
+	if _, err := (&AuthService{JWTSecret: "test-secret"}).GetUserIDFromToken(context.Background(), ecToken); err == nil {
+		t.Error("Expected a token signed with an unknown key to be rejected")
+	}
+
+	// other services verify tokens with the published keys
+	_, _, r := setupTest(t)
+	r.GET("/.well-known/jwks.json", authService.GetJWKS)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	var jwks JWKSResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 2 {
+		t.Fatalf("Expected two keys, got %s", w.Body.String())
+	}
+	jwk := jwks.Keys[1]
+	if jwk.Kty != "RSA" || jwk.Kid != rsaPair.ID || jwk.Alg != "RS256" {
+		t.Fatalf("Unexpected RSA key %+v", jwk)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	if _, err := jwt.Parse(rsaToken, func(*jwt.Token) (interface{}, error) { return published, nil }); err != nil {
+		t.Errorf("Expected the token to verify with the published key: %v", err)
+	}
+}
+
 func TestRegister_Success(t *testing.T) {
 	authService, mock, r := setupTest(t)
 	defer authService.DB.Close()
 
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (email, password) VALUES ($1, $2)")).
-		WithArgs("test@example.com", sqlmock.AnyArg()).
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (email, password, username) VALUES ($1, $2, NULLIF($3, ''))")).
+		WithArgs("test@example.com", sqlmock.AnyArg(), "").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	r.POST("/register", authService.Register)
@@ -133,6 +287,7 @@ func TestLogin_Success(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, password FROM users WHERE email = $1")).
 		WithArgs("user@example.com").
 		WillReturnRows(rows)
+	expectSession(mock, userID, 3)
 
 	r.POST("/login", authService.Login)
 
@@ -180,6 +335,57 @@ func TestLogin_InvalidCredentials(t *testing.T) {
 	}
 }
 
+func TestLogin_Username(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	hashedPassword, _ := HashPassword("password123")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, password FROM users WHERE username = $1")).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password"}).AddRow(1, hashedPassword))
+	expectSession(mock, 1, 3)
+
+	r.POST("/login", authService.Login)
+
+	for payload, want := range map[string]int{
+		`{"username": " Alice", "password": "password123"}`:                              http.StatusOK,
+		`{"password": "password123"}`:                                                    http.StatusBadRequest,
+		`{"email": "alice@example.com", "username": "alice", "password": "password123"}`: http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d. Body: %s", payload, want, w.Code, w.Body.String())
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRegister_UsernameTaken(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users")).
+		WithArgs("new@example.com", sqlmock.AnyArg(), "alice").
+		WillReturnError(errors.New(`duplicate key value violates unique constraint "idx_users_username"`))
+
+	r.POST("/register", authService.Register)
+
+	payload := []byte(`{"email": "new@example.com", "password": "password123", "username": "Alice"}`)
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "Username already taken") {
+		t.Errorf("Expected 409 for a taken username, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestMe_Success(t *testing.T) {
 	authService, mock, r := setupTest(t)
 	defer authService.DB.Close()
@@ -190,9 +396,11 @@ func TestMe_Success(t *testing.T) {
 
 	token, _ := GenerateJWT(userID, authService.JWTSecret)
 
-	rows := sqlmock.NewRows([]string{"email", "created_at"}).AddRow(email, createdAt)
+	lastLogin := time.Date(2025, 9, 19, 10, 30, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"email", "username", "display_name", "avatar_url", "locale", "created_at", "last_login_at", "last_login_ip"}).
+		AddRow(email, "", "", "", "", createdAt, lastLogin, "203.0.113.7")
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT email, created_at FROM users WHERE id = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT email, COALESCE(username, ''), COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(locale, ''), created_at,")).
 		WithArgs(userID).
 		WillReturnRows(rows)
 
@@ -208,4 +416,517 @@ func TestMe_Success(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
 
 	}
+	var profile UserProfileResponse
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.LastLoginAt == nil || !profile.LastLoginAt.Equal(lastLogin) || profile.LastLoginIP != "203.0.113.7" {
+		t.Errorf("Expected the last login in the profile, got %+v", profile)
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT email, COALESCE(username, '')")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "username", "display_name", "avatar_url", "locale", "created_at", "last_login_at", "last_login_ip"}).
+			AddRow("user@example.com", "", "Old Name", "https://example.com/old.png", "de-DE", "2024-01-15T10:30:00Z", nil, ""))
+	// fields missing from the request keep their values, "" clears, and
+	// usernames are lowercased
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET display_name = NULLIF($1, ''), avatar_url = NULLIF($2, ''), locale = NULLIF($3, ''), username = NULLIF($4, '')")).
+		WithArgs("Alice Smith", "", "de-DE", "alice_s", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.PATCH("/me", func(c *gin.Context) { c.Set("userId", 1) }, authService.UpdateMe)
+
+	payload := []byte(`{"display_name": "  Alice Smith ", "avatar_url": "", "username": "Alice_S"}`)
+	req, _ := http.NewRequest("PATCH", "/me", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var profile UserProfileResponse
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	if profile.Username != "alice_s" || profile.DisplayName != "Alice Smith" || profile.AvatarURL != "" || profile.Locale != "de-DE" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateMe_Invalid(t *testing.T) {
+	for _, payload := range []string{
+		`{"avatar_url": "javascript:alert(1)"}`,
+		`{"avatar_url": "not a url"}`,
+		`{"locale": "English please"}`,
+		`{"username": "al"}`,
+		`{"username": "alice@example.com"}`,
+	} {
+		authService, mock, r := setupTest(t)
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT email, COALESCE(username, '')")).
+			WillReturnRows(sqlmock.NewRows([]string{"email", "username", "display_name", "avatar_url", "locale", "created_at", "last_login_at", "last_login_ip"}).
+				AddRow("user@example.com", "", "", "", "", "2024-01-15T10:30:00Z", nil, ""))
+
+		r.PATCH("/me", func(c *gin.Context) { c.Set("userId", 1) }, authService.UpdateMe)
+
+		req, _ := http.NewRequest("PATCH", "/me", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", payload, w.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %s", payload, err)
+		}
+		authService.DB.Close()
+	}
+}
+
+func TestGetUserIDAndExpiryFromToken(t *testing.T) {
+	authService := &AuthService{JWTSecret: "test-secret"}
+
+	token, err := GenerateJWT(7, authService.JWTSecret)
+	if err != nil {
+		t.Fatalf("Error generating JWT token: %v", err)
+	}
+
+	userID, expiresAt, err := authService.GetUserIDAndExpiryFromToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if userID != 7 {
+		t.Errorf("Wrong user id. Expected 7, got %d", userID)
+	}
+	if remaining := time.Until(expiresAt); remaining < 23*time.Hour || remaining > 24*time.Hour {
+		t.Errorf("Expected token to expire in about 24h, got %v", remaining)
+	}
+}
+
+// setupOAuth registers a provider named "test" whose token endpoint is a
+// test server and whose account is identity.
+func setupOAuth(t *testing.T, identity *OAuthIdentity) (*AuthService, sqlmock.Sqlmock, *gin.Engine) {
+	authService, mock, r := setupTest(t)
+	t.Cleanup(func() { authService.DB.Close() })
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"provider-token","token_type":"Bearer"}`))
+	}))
+	t.Cleanup(tokens.Close)
+
+	handler := &OAuthHandler{
+		AuthService: authService,
+		Providers: map[string]*OAuthProvider{"test": {
+			Config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: tokens.URL + "/authorize", TokenURL: tokens.URL + "/token"},
+			},
+			Identify: func(ctx context.Context, client *http.Client) (*OAuthIdentity, error) {
+				return identity, nil
+			},
+		}},
+		FrontendUrl: "https://app.example.com",
+	}
+	r.GET("/auth/:provider", handler.Login)
+	r.GET("/auth/:provider/callback", handler.Callback)
+	return authService, mock, r
+}
+
+// startOAuth starts logging in and returns the state sent to the provider
+// and the state cookie.
+func startOAuth(t *testing.T, r *gin.Engine) (string, *http.Cookie) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/test", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status 302, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect: %v", err)
+	}
+	if location.Query().Get("code_challenge") == "" {
+		t.Error("Expected a PKCE challenge in the redirect to the provider")
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected one HttpOnly state cookie, got %v", cookies)
+	}
+	return location.Query().Get("state"), cookies[0]
+}
+
+func finishOAuth(r *gin.Engine, query string, cookie *http.Cookie) url.Values {
+	req := httptest.NewRequest("GET", "/auth/test/callback?"+query, nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	return fragment
+}
+
+func TestOAuthCallback_CreatesUser(t *testing.T) {
+	authService, mock, r := setupOAuth(t, &OAuthIdentity{Subject: "42", Email: "new@example.com", EmailVerified: true})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2")).
+		WithArgs("test", "42").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = $1")).
+		WithArgs("new@example.com").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (email, password) VALUES ($1, '') RETURNING id")).
+		WithArgs("new@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities (provider, subject, user_id, email)")).
+		WithArgs("test", "42", 7, "new@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectSession(mock, 7, 3)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at = NOW() WHERE id = $1 AND user_id = $2")).
+		WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	state, cookie := startOAuth(t, r)
+	fragment := finishOAuth(r, "code=good-code&state="+url.QueryEscape(state), cookie)
+
+	if fragment.Get("error") != "" {
+		t.Fatalf("Expected login to succeed, got error %q", fragment.Get("error"))
+	}
+	if fragment.Get("user_id") != "7" {
+		t.Errorf("Expected user_id 7, got %q", fragment.Get("user_id"))
+	}
+	if userID, err := authService.GetUserIDFromToken(context.Background(), fragment.Get("token")); err != nil || userID != 7 {
+		t.Errorf("Expected a token for user 7, got %d, %v", userID, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOAuthCallback_RejectsUnverifiedEmail(t *testing.T) {
+	_, mock, r := setupOAuth(t, &OAuthIdentity{Subject: "42", Email: "victim@example.com"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	state, cookie := startOAuth(t, r)
+	fragment := finishOAuth(r, "code=good-code&state="+url.QueryEscape(state), cookie)
+
+	if fragment.Get("error") != "email_unverified" || fragment.Get("token") != "" {
+		t.Errorf("Expected email_unverified without a token, got %v", fragment)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOAuthCallback_InvalidState(t *testing.T) {
+	_, mock, r := setupOAuth(t, &OAuthIdentity{Subject: "42", Email: "new@example.com", EmailVerified: true})
+
+	_, cookie := startOAuth(t, r)
+	fragment := finishOAuth(r, "code=good-code&state=forged", cookie)
+
+	if fragment.Get("error") != "invalid_state" {
+		t.Errorf("Expected invalid_state, got %v", fragment)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unexpected queries: %s", err)
+	}
+}
+
+func TestOAuthLogin_UnknownProvider(t *testing.T) {
+	_, _, r := setupOAuth(t, nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/myspace", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestSessions_RevokedTokenRejected(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	expectSession(mock, 1, 5)
+	token, err := authService.IssueToken(context.Background(), 1, "test-agent", "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	r.GET("/protected", authService.AuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"session_id": c.GetInt("sessionId")})
+	})
+	request := func() int {
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// the session is checked once, then trusted for a while
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at = NOW() WHERE id = $1 AND user_id = $2")).
+		WithArgs(5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for i := 0; i < 2; i++ {
+		if code := request(); code != http.StatusOK {
+			t.Fatalf("Expected status 200 while the session is active, got %d", code)
+		}
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE id = $1 AND user_id = $2")).
+		WithArgs(5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := authService.RevokeSession(context.Background(), 1, 5); err != nil {
+		t.Fatalf("Error revoking session: %v", err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at = NOW()")).
+		WithArgs(5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if code := request(); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 after revoking the session, got %d", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+// recordingNotifier records the sessions it is told were revoked.
+type recordingNotifier struct {
+	revoked []int
+}
+
+func (n *recordingNotifier) SessionRevoked(sessionId int) {
+	n.revoked = append(n.revoked, sessionId)
+}
+
+func TestDeleteSession_ClosesConnections(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	notifier := &recordingNotifier{}
+	authService.Notifier = notifier
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE id = $1 AND user_id = $2")).
+		WithArgs(5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.DELETE("/me/sessions/:id", func(c *gin.Context) { c.Set("userId", 1) }, authService.DeleteSession)
+
+	req, _ := http.NewRequest("DELETE", "/me/sessions/5", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(notifier.revoked) != 1 || notifier.revoked[0] != 5 {
+		t.Errorf("Expected the connections of session 5 to be closed, got %v", notifier.revoked)
+	}
+}
+
+func TestSessions_UncheckedWhileDatabaseDown(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	expectSession(mock, 1, 5)
+	token, err := authService.IssueToken(context.Background(), 1, "test-agent", "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Error issuing token: %v", err)
+	}
+
+	r.GET("/protected", authService.AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at = NOW()")).
+		WithArgs(5, 1).
+		WillReturnError(errors.New("connection refused"))
+
+	req, _ := http.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// the session may have been revoked, so the token isn't trusted
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while the session can't be checked, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteSession_NotFound(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE id = $1 AND user_id = $2")).
+		WithArgs(9, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	r.DELETE("/me/sessions/:id", func(c *gin.Context) { c.Set("userId", 1) }, authService.DeleteSession)
+
+	req, _ := http.NewRequest("DELETE", "/me/sessions/9", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another user's session, got %d", w.Code)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	// wildcards in the query match literally
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE lower(email) LIKE $1 OR username LIKE $1 OR lower(display_name) LIKE $1")).
+		WithArgs(`ali\_ce%`, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "username", "display_name"}).
+			AddRow(7, "ali_ce@example.com", "alice", "Alice Smith").
+			AddRow(9, "ali_ce.b@example.com", "", ""))
+
+	r.GET("/users/search", authService.SearchUsers)
+
+	req, _ := http.NewRequest("GET", "/users/search?q=Ali_ce", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response UserSearchResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Users) != 2 || response.Users[0].ID != 7 || response.Users[0].DisplayName != "Alice Smith" {
+		t.Errorf("Unexpected users: %+v", response.Users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	for _, query := range []string{"", "?q=al", "?q=alice&limit=51"} {
+		req, _ := http.NewRequest("GET", "/users/search"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	mail := &fakeMailer{}
+	h := &MagicLinkHandler{AuthService: authService, Mailer: mail, FrontendUrl: "https://collab.example.com/"}
+	r.POST("/login/magic", h.Request)
+	r.POST("/login/magic/verify", h.Verify)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM magic_links WHERE email = $1 AND expires_at < NOW()")).
+		WithArgs("reviewer@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO magic_links (token_hash, email, expires_at)")).
+		WithArgs(sqlmock.AnyArg(), "reviewer@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req, _ := http.NewRequest("POST", "/login/magic", bytes.NewBufferString(`{"email": "reviewer@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d. Body: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != "reviewer@example.com" {
+		t.Fatalf("Expected one email to the reviewer, got %+v", mail.sent)
+	}
+	_, fragment, ok := strings.Cut(mail.sent[0].Body, "https://collab.example.com/auth/magic#token=")
+	if !ok {
+		t.Fatalf("Expected a link to the frontend in %q", mail.sent[0].Body)
+	}
+	token, _, _ := strings.Cut(fragment, "\n")
+	tokenHash := hashMagicToken(token)
+
+	// the link logs in a new user once, and is rejected after
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE magic_links SET used_at = NOW()")).
+		WithArgs(tokenHash).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("reviewer@example.com"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = $1")).
+		WithArgs("reviewer@example.com").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (email, password) VALUES ($1, '') RETURNING id")).
+		WithArgs("reviewer@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectCommit()
+	expectSession(mock, 5, 8)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE magic_links SET used_at = NOW()")).
+		WithArgs(tokenHash).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	for _, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req, _ := http.NewRequest("POST", "/login/magic/verify", bytes.NewBufferString(`{"token": "`+token+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Fatalf("Expected status code %d, got %d. Body: %s", want, w.Code, w.Body.String())
+		}
+		if want == http.StatusOK {
+			var response LoginResponse
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if response.UserID != 5 || response.Token == "" {
+				t.Errorf("Unexpected login response: %+v", response)
+			}
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMagicLink_Throttled(t *testing.T) {
+	authService, mock, r := setupTest(t)
+	defer authService.DB.Close()
+
+	mail := &fakeMailer{}
+	h := &MagicLinkHandler{AuthService: authService, Mailer: mail}
+	r.POST("/login/magic", h.Request)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM magic_links")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO magic_links")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req, _ := http.NewRequest("POST", "/login/magic", bytes.NewBufferString(`{"email": "reviewer@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// the response doesn't tell a throttled request from a sent link
+	if w.Code != http.StatusAccepted || len(mail.sent) != 0 {
+		t.Errorf("Expected 202 without an email, got %d and %d emails", w.Code, len(mail.sent))
+	}
 }
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for signing tokens.
+const minRSAKeyBits = 2048
+
+// KeyPair is an RSA or ECDSA key tokens are signed or verified with.
+// Tokens signed with it name it by ID in their kid header.
+type KeyPair struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+	// Private is nil for keys that only verify tokens.
+	Private crypto.Signer
+}
+
+// ParsePrivateKey parses a PEM-encoded RSA or ECDSA private key in PKCS #1,
+// SEC 1, or PKCS #8 form. Tokens are signed with RS256 for RSA keys and
+// with ES256, ES384, or ES512 for ECDSA keys, depending on the curve.
+func ParsePrivateKey(data []byte) (*KeyPair, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	pair, err := newKeyPair(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	pair.Private = signer
+	return pair, nil
+}
+
+// ParsePublicKeys parses every PEM-encoded PKIX public key in data.
+func ParsePublicKeys(data []byte) ([]*KeyPair, error) {
+	var pairs []*KeyPair
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return pairs, nil
+		}
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := newKeyPair(key)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+}
+
+func newKeyPair(public crypto.PublicKey) (*KeyPair, error) {
+	pair := &KeyPair{Public: public}
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits, got %d", minRSAKeyBits, key.N.BitLen())
+		}
+		pair.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			pair.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			pair.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			pair.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	pair.ID = base64.RawURLEncoding.EncodeToString(sum[:16])
+	return pair, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty" example:"RSA"`
+	Kid string `json:"kid" example:"q2vQxN5bC1z8m0h4d6Yf9A"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"RS256"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty" example:"AQAB"`
+	// Crv, X, and Y are set for ECDSA keys.
+	Crv string `json:"crv,omitempty" example:"P-256"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWK returns the public half of the key.
+func (k *KeyPair) JWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch key := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	}
+	return jwk
+}
+
+// publicKeys returns the keys tokens can be verified with besides the
+// HMAC secrets: the signing key and the previous ones.
+func (s *AuthService) publicKeys() []*KeyPair {
+	if s.SigningKey == nil {
+		return s.PreviousKeys
+	}
+	return append([]*KeyPair{s.SigningKey}, s.PreviousKeys...)
+}
+
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
+
+// GetJWKS godoc
+// @Summary Get token verification keys
+// @Description Get the public keys tokens are signed with, as a JSON Web Key Set, so other services can verify tokens without the API's secrets. Match a token's kid header to a key. Empty unless JWT_PRIVATE_KEY is set. Services verifying tokens themselves can't tell whether their session was revoked.
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} JWKSResponse "Key set"
+// @Router /.well-known/jwks.json [get]
+func (s *AuthService) GetJWKS(c *gin.Context) {
+	keys := []JWK{}
+	for _, key := range s.publicKeys() {
+		keys = append(keys, key.JWK())
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/mailer"
+	"live-collab-api/internal/validation"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// magicLinkTTL is how long a login link works.
+const magicLinkTTL = 15 * time.Minute
+
+// magicLinkInterval is how often a link can be sent to the same email, so
+// the endpoint can't be used to flood an inbox.
+const magicLinkInterval = time.Minute
+
+var (
+	// ErrMagicLinkThrottled is returned when a link was sent to the email
+	// less than magicLinkInterval ago.
+	ErrMagicLinkThrottled = errors.New("login link sent recently")
+	// ErrMagicLinkInvalid is returned for links that don't exist, have
+	// expired, or were already used.
+	ErrMagicLinkInvalid = errors.New("invalid or expired login link")
+)
+
+// CreateMagicLink returns a new one-time login token for email. Only a hash
+// of it is stored, so the tokens can't be read out of the database.
+func (s *AuthService) CreateMagicLink(ctx context.Context, email string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate login token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	// links are only ever redeemed once, so the old ones are cleaned up
+	// when the email asks for another
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM magic_links WHERE email = $1 AND expires_at < NOW()", email); err != nil {
+		return "", fmt.Errorf("failed to delete expired login links: %v", err)
+	}
+
+	result, err := s.DB.ExecContext(ctx, `
+		INSERT INTO magic_links (token_hash, email, expires_at)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM magic_links WHERE email = $2 AND created_at > $4)
+	`, hashMagicToken(token), email, time.Now().Add(magicLinkTTL), time.Now().Add(-magicLinkInterval))
+	if err != nil {
+		return "", fmt.Errorf("failed to create login link: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return "", fmt.Errorf("failed to get rows affected: %v", err)
+	} else if n == 0 {
+		return "", ErrMagicLinkThrottled
+	}
+	return token, nil
+}
+
+// RedeemMagicLink uses up a login token and returns the user it logs in:
+// the user with its email, or a new user without a password if there is
+// none, since receiving the link proves the email is theirs.
+func (s *AuthService) RedeemMagicLink(ctx context.Context, token string) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// marking the link used in the same statement that checks it means
+	// concurrent requests can't both redeem it
+	var email string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE magic_links SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING email
+	`, hashMagicToken(token)).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to redeem login link: %v", err)
+	}
+
+	userId, err := userForEmail(ctx, tx, email)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return userId, nil
+}
+
+func hashMagicToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email,max=254" example:"reviewer@example.com"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token" binding:"required,max=100" example:"q2vQxN5bC1z8m0h4d6Yf9Aq2vQxN5bC1z8m0h4d6Yf9A"`
+}
+
+// MagicLinkHandler logs users in with links emailed to them. The link opens
+// the frontend's /auth/magic page with the token in the URL fragment, and
+// the page exchanges it for a JWT with a POST, so mail scanners that open
+// links can't use it up.
+type MagicLinkHandler struct {
+	AuthService *AuthService
+	// Mailer sends the links. When nil magic links are disabled.
+	Mailer      mailer.Mailer
+	FrontendUrl string
+}
+
+// Request godoc
+// @Summary Email a login link
+// @Description Email a link that logs in without a password. It works once, for 15 minutes. Emails without an account get one when the link is used, so reviewers can be invited by email. The response is the same whether or not the email has an account, and at most one link a minute is sent to an email.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkRequest true "Email to send the link to"
+// @Success 202 {object} MessageResponse "Link sent"
+// @Failure 400 {object} ErrorResponse "Invalid email"
+// @Failure 404 {object} ErrorResponse "Magic links not enabled"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /login/magic [post]
+func (h *MagicLinkHandler) Request(c *gin.Context) {
+	if h.Mailer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Magic link login is not enabled"})
+		return
+	}
+
+	var req MagicLinkRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, err := h.AuthService.CreateMagicLink(ctx, req.Email)
+	if errors.Is(err, ErrMagicLinkThrottled) {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Login link sent"})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login link"})
+		return
+	}
+
+	link := strings.TrimRight(h.FrontendUrl, "/") + "/auth/magic#" + url.Values{"token": {token}}.Encode()
+	msg := mailer.Message{
+		To:      req.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Open this link to log in:\n\n%s\n\nIt works once and expires in %d minutes. If you didn't ask to log in, you can ignore this email.\n",
+			link, int(magicLinkTTL.Minutes())),
+	}
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		c.Error(fmt.Errorf("failed to send login link: %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send login link"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Login link sent"})
+}
+
+// Verify godoc
+// @Summary Log in with an emailed link
+// @Description Exchange the token from a login link for a JWT. Each link logs in once.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkVerifyRequest true "Token from the link"
+// @Success 200 {object} LoginResponse "Login successful with JWT token"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Invalid, expired, or used link"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /login/magic/verify [post]
+func (h *MagicLinkHandler) Verify(c *gin.Context) {
+	var req MagicLinkVerifyRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	userId, err := h.AuthService.RedeemMagicLink(ctx, req.Token)
+	if errors.Is(err, ErrMagicLinkInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login link"})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	token, err := h.AuthService.IssueToken(ctx, userId, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":   token,
+		"user_id": userId,
+	})
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"live-collab-api/internal/logging"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -8,14 +10,48 @@ import (
 
 func (s *AuthService) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userId, err := s.GetUserIDFromGinContext(c)
+		var claims *tokenClaims
+		tokenString, err := TokenFromAuthHeader(c.GetHeader("Authorization"))
+		if err == nil {
+			claims, err = s.authenticate(c.Request.Context(), tokenString)
+		}
+		if errors.Is(err, ErrSessionUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session could not be checked, please retry"})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized", "detail": "Invalid or missing authentication token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("userId", userId)
+		c.Set("userId", claims.userId)
+		// sessionId is 0 for tokens not tied to a session
+		c.Set("sessionId", claims.sessionId)
+		logging.AddToRequest(c, "user_id", claims.userId)
+		c.Next()
+	}
+}
+
+// AdminMiddleware rejects users without the admin role. It must run after
+// AuthMiddleware.
+func (s *AuthService) AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, err := s.GetUserRole(c.Request.Context(), c.GetInt("userId"))
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check user role"})
+			c.Abort()
+			return
+		}
+
+		if role != RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
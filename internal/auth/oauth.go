@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// Login providers users can log in with besides a password.
+const (
+	ProviderGoogle = "google"
+	ProviderGitHub = "github"
+)
+
+const (
+	googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+	githubAPIURL      = "https://api.github.com"
+)
+
+// oauthStateMaxAge is how long a user has to log in at the provider, in
+// seconds.
+const oauthStateMaxAge = 10 * 60
+
+// ErrEmailUnverified is returned for provider accounts without a verified
+// email, which can't be matched to a user.
+var ErrEmailUnverified = errors.New("email not verified")
+
+// OAuthIdentity is the account a user logged in with at a provider.
+type OAuthIdentity struct {
+	// Subject is the provider's stable ID for the account.
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthProvider is a provider users can log in with through the OAuth 2.0
+// authorization code flow.
+type OAuthProvider struct {
+	Config *oauth2.Config
+	// Identify returns the account client is authorized for.
+	Identify func(ctx context.Context, client *http.Client) (*OAuthIdentity, error)
+}
+
+// GoogleProvider returns the provider for logging in with a Google
+// account. redirectURL is the URL of its callback endpoint.
+func GoogleProvider(clientID, clientSecret, redirectURL string) *OAuthProvider {
+	return &OAuthProvider{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoints.Google,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"openid", "email"},
+		},
+		Identify: googleIdentity,
+	}
+}
+
+// GitHubProvider returns the provider for logging in with a GitHub
+// account. redirectURL is the URL of its callback endpoint.
+func GitHubProvider(clientID, clientSecret, redirectURL string) *OAuthProvider {
+	return &OAuthProvider{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     endpoints.GitHub,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+		},
+		Identify: githubIdentity,
+	}
+}
+
+func googleIdentity(ctx context.Context, client *http.Client) (*OAuthIdentity, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := getJSON(ctx, client, googleUserInfoURL, &info); err != nil {
+		return nil, err
+	}
+	if info.Sub == "" {
+		return nil, fmt.Errorf("userinfo response has no subject")
+	}
+	return &OAuthIdentity{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified}, nil
+}
+
+// githubIdentity returns the GitHub account with its primary email, which
+// only counts as verified if GitHub verified it.
+func githubIdentity(ctx context.Context, client *http.Client) (*OAuthIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(ctx, client, githubAPIURL+"/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("user response has no id")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, githubAPIURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	identity := &OAuthIdentity{Subject: strconv.FormatInt(user.ID, 10), Email: user.Email}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email, identity.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", url, err)
+	}
+	return nil
+}
+
+// LoginWithIdentity returns the user an account at provider belongs to.
+// An account seen for the first time is linked to the user with its
+// email, or to a new user without a password if there is none. Accounts
+// without a verified email are only accepted once linked, so nobody can
+// take over a user by claiming their email at a provider.
+func (s *AuthService) LoginWithIdentity(ctx context.Context, provider string, identity *OAuthIdentity) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userId int
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", provider, identity.Subject).Scan(&userId)
+	if err == nil {
+		return userId, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to find identity: %v", err)
+	}
+	if !identity.EmailVerified || identity.Email == "" {
+		return 0, ErrEmailUnverified
+	}
+
+	userId, err = userForEmail(ctx, tx, identity.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)",
+		provider, identity.Subject, userId, identity.Email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to link identity: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return userId, nil
+}
+
+// userForEmail returns the user with email, creating one without a
+// password if there is none. The caller must have verified the email.
+func userForEmail(ctx context.Context, tx *sql.Tx, email string) (int, error) {
+	var userId int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", email).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, "INSERT INTO users (email, password) VALUES ($1, '') RETURNING id", email).Scan(&userId)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find or create user: %v", err)
+	}
+	return userId, nil
+}
+
+// OAuthHandler logs users in through Google and GitHub. Both ends of the
+// flow are browser redirects: users are sent to the provider, and once
+// back, on to the frontend's /auth/callback with the token, or an error
+// code, in the URL fragment, which browsers don't send to servers.
+type OAuthHandler struct {
+	AuthService *AuthService
+	// Providers are the configured providers by name.
+	Providers   map[string]*OAuthProvider
+	FrontendUrl string
+	// SecureCookies marks the state cookie as HTTPS-only.
+	SecureCookies bool
+}
+
+func (h *OAuthHandler) provider(c *gin.Context) (string, *OAuthProvider, bool) {
+	name := c.Param("provider")
+	provider, ok := h.Providers[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Login provider not found"})
+	}
+	return name, provider, ok
+}
+
+func oauthStateCookie(provider string) string {
+	return "oauth_state_" + provider
+}
+
+// Login godoc
+// @Summary Log in with a provider
+// @Description Redirect to Google or GitHub to log in. The provider redirects back to the callback endpoint.
+// @Tags authentication
+// @Param provider path string true "Login provider" Enums(google, github)
+// @Success 302 "Redirect to the provider"
+// @Failure 404 {object} ErrorResponse "Provider not configured"
+// @Router /auth/{provider} [get]
+func (h *OAuthHandler) Login(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	verifier := oauth2.GenerateVerifier()
+
+	// The cookie comes back with the top-level redirect from the provider,
+	// which Lax allows
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie(name), state+"."+verifier, oauthStateMaxAge, "/auth/"+name, "", h.SecureCookies, true)
+	c.Redirect(http.StatusFound, provider.Config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)))
+}
+
+// Callback godoc
+// @Summary Finish logging in with a provider
+// @Description Called by the provider after the user logged in there. Finds or creates the user and redirects to FRONTEND_URL/auth/callback with token and user_id in the URL fragment, or error: denied, invalid_state, email_unverified, or server_error.
+// @Tags authentication
+// @Param provider path string true "Login provider" Enums(google, github)
+// @Param code query string false "Authorization code"
+// @Param state query string false "State sent to the provider"
+// @Success 302 "Redirect to the frontend"
+// @Failure 404 {object} ErrorResponse "Provider not configured"
+// @Router /auth/{provider}/callback [get]
+func (h *OAuthHandler) Callback(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	cookie, _ := c.Cookie(oauthStateCookie(name))
+	c.SetCookie(oauthStateCookie(name), "", -1, "/auth/"+name, "", h.SecureCookies, true)
+	if c.Query("error") != "" {
+		h.redirect(c, url.Values{"error": {"denied"}})
+		return
+	}
+	state, verifier, _ := strings.Cut(cookie, ".")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		h.redirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, err := provider.Config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		c.Error(fmt.Errorf("failed to exchange %s code: %v", name, err))
+		h.redirect(c, url.Values{"error": {"server_error"}})
+		return
+	}
+	identity, err := provider.Identify(ctx, provider.Config.Client(ctx, token))
+	if err != nil {
+		c.Error(fmt.Errorf("failed to identify %s account: %v", name, err))
+		h.redirect(c, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	userId, err := h.AuthService.LoginWithIdentity(ctx, name, identity)
+	if errors.Is(err, ErrEmailUnverified) {
+		h.redirect(c, url.Values{"error": {"email_unverified"}})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		h.redirect(c, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	jwtToken, err := h.AuthService.IssueToken(ctx, userId, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		h.redirect(c, url.Values{"error": {"server_error"}})
+		return
+	}
+	h.redirect(c, url.Values{"token": {jwtToken}, "user_id": {strconv.Itoa(userId)}})
+}
+
+// redirect sends the browser to the frontend's callback page with params
+// in the fragment.
+func (h *OAuthHandler) redirect(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, strings.TrimRight(h.FrontendUrl, "/")+"/auth/callback#"+params.Encode())
+}
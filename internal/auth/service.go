@@ -1,10 +1,15 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,9 +17,40 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Roles a user can have. Admins can use the /api/admin routes.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type AuthService struct {
-	DB        *sql.DB
-	JWTSecret string
+	DB *sql.DB
+	// JWTSecret signs new tokens. PreviousJWTSecrets are secrets it
+	// replaced, which still verify the tokens they signed, so rotating
+	// the secret doesn't log everyone out.
+	JWTSecret          string
+	PreviousJWTSecrets []string
+	// SigningKey, when set, signs new tokens in place of JWTSecret, so
+	// other services can verify them with its public key. PreviousKeys
+	// are public keys it replaced, which still verify their tokens. Both
+	// are published by GetJWKS.
+	SigningKey   *KeyPair
+	PreviousKeys []*KeyPair
+	// TokenTTL is how long issued tokens are valid, 24 hours when zero.
+	TokenTTL time.Duration
+	// Issuer and Audience, when set, are put in the iss and aud claims of
+	// issued tokens and required of tokens presented.
+	Issuer   string
+	Audience string
+
+	// Notifier, when set, is told about revoked sessions so WebSocket
+	// connections opened with their tokens are closed.
+	Notifier SessionNotifier
+
+	// sessionsMu guards sessionsChecked, when each session was last
+	// found to be active, see checkSession.
+	sessionsMu      sync.Mutex
+	sessionsChecked map[int]time.Time
 }
 
 func HashPassword(password string) (string, error) {
@@ -26,63 +62,297 @@ func CheckPasswordHash(password, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
+// defaultTokenTTL is how long tokens are valid unless TokenTTL says
+// otherwise.
+const defaultTokenTTL = 24 * time.Hour
+
+func (s *AuthService) tokenTTL() time.Duration {
+	if s.TokenTTL > 0 {
+		return s.TokenTTL
+	}
+	return defaultTokenTTL
+}
+
+// GenerateJWT returns a token for userId signed with secret that isn't
+// tied to a session, so it can't be revoked before it expires. Logins
+// issue tokens with IssueToken instead.
 func GenerateJWT(userId int, secret string) (string, error) {
+	return signHMAC(newClaims(userId, 0, time.Now().Add(defaultTokenTTL)), secret)
+}
+
+// newClaims returns the claims of a token for userId expiring at
+// expiresAt, tied to a session unless sessionId is 0.
+func newClaims(userId, sessionId int, expiresAt time.Time) jwt.MapClaims {
 	claims := jwt.MapClaims{
 		"user_id": userId,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"exp":     expiresAt.Unix(),
 	}
+	if sessionId != 0 {
+		claims["sid"] = sessionId
+	}
+	return claims
+}
+
+// signToken adds the issuer and audience to claims and signs them with the
+// signing key if there is one, and with JWTSecret otherwise.
+func (s *AuthService) signToken(claims jwt.MapClaims) (string, error) {
+	if s.Issuer != "" {
+		claims["iss"] = s.Issuer
+	}
+	if s.Audience != "" {
+		claims["aud"] = s.Audience
+	}
+	if s.SigningKey == nil {
+		return signHMAC(claims, s.JWTSecret)
+	}
+	token := jwt.NewWithClaims(s.SigningKey.Method, claims)
+	token.Header["kid"] = s.SigningKey.ID
+	return token.SignedString(s.SigningKey.Private)
+}
+
+func signHMAC(claims jwt.MapClaims, secret string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = keyID(secret)
 	return token.SignedString([]byte(secret))
 }
 
-func (s *AuthService) GetUserIDFromToken(tokenString string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid signing method")
+// keyID returns the kid header of tokens signed with secret, which tells
+// which secret to verify them with. It is derived from the secret, so
+// secrets don't need IDs configured, and hashed, so it doesn't reveal it.
+func keyID(secret string) string {
+	sum := sha256.Sum256([]byte("live-collab-api jwt kid:" + secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// verificationKeys returns the keys that may have signed token. Tokens
+// signed with a public key pair must name it in their kid header. Tokens
+// signed with a secret are verified with the one their kid names, or for
+// tokens signed before kids were added, with all of them.
+func (s *AuthService) verificationKeys(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		for _, key := range s.publicKeys() {
+			if key.ID == kid && key.Method.Alg() == token.Method.Alg() {
+				return key.Public, nil
+			}
 		}
-		return []byte(s.JWTSecret), nil
-	})
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	default:
+		return nil, fmt.Errorf("invalid signing method")
+	}
+
+	var keys jwt.VerificationKeySet
+	for _, secret := range append([]string{s.JWTSecret}, s.PreviousJWTSecrets...) {
+		if secret != "" && (kid == "" || keyID(secret) == kid) {
+			keys.Keys = append(keys.Keys, []byte(secret))
+		}
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return keys, nil
+}
+
+func (s *AuthService) UserExists(ctx context.Context, userId int) (bool, error) {
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userId).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user: %v", err)
+	}
+	return exists, nil
+}
+
+// GetUserRole returns the role of a user. Roles are read on every call
+// rather than stored in the token, so revoking admin takes effect at once.
+func (s *AuthService) GetUserRole(ctx context.Context, userId int) (string, error) {
+	var role string
+	err := s.DB.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1", userId).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get user role: %v", err)
+	}
+	return role, nil
+}
+
+// GetProfile returns a user's profile, with fields never set left empty.
+func (s *AuthService) GetProfile(ctx context.Context, userId int) (*UserProfileResponse, error) {
+	profile := &UserProfileResponse{UserID: userId}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT email, COALESCE(username, ''), COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(locale, ''), created_at,
+			last_login_at, COALESCE(last_login_ip, '')
+		FROM users WHERE id = $1
+	`, userId).Scan(&profile.Email, &profile.Username, &profile.DisplayName, &profile.AvatarURL, &profile.Locale, &profile.CreatedAt,
+		&profile.LastLoginAt, &profile.LastLoginIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %v", err)
+	}
+	return profile, nil
+}
 
+// UpdateProfile sets a user's profile fields. Empty fields are cleared.
+// It returns ErrUsernameTaken if another user has the username.
+func (s *AuthService) UpdateProfile(ctx context.Context, userId int, req *UpdateProfileRequest) error {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE users SET display_name = NULLIF($1, ''), avatar_url = NULLIF($2, ''), locale = NULLIF($3, ''), username = NULLIF($4, '')
+		WHERE id = $5
+	`, req.DisplayName, req.AvatarURL, req.Locale, req.Username, userId)
+	if usernameTaken(err) {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %v", err)
+	}
+	return nil
+}
+
+// likeEscaper escapes the LIKE wildcards in search text.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// FindUsers returns a page of the users whose email, username, or display
+// name starts with prefix, ignoring case, ordered by email.
+func (s *AuthService) FindUsers(ctx context.Context, prefix string, limit, offset int) ([]UserSummary, error) {
+	pattern := likeEscaper.Replace(strings.ToLower(prefix)) + "%"
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, email, COALESCE(username, ''), COALESCE(display_name, '')
+		FROM users
+		WHERE lower(email) LIKE $1 OR username LIKE $1 OR lower(display_name) LIKE $1
+		ORDER BY email, id
+		LIMIT $2 OFFSET $3
+	`, pattern, limit, offset)
 	if err != nil {
-		return 0, fmt.Errorf("invalid token: %v", err)
+		return nil, fmt.Errorf("failed to search users: %v", err)
 	}
+	defer rows.Close()
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userIdValue, exists := claims["user_id"]
-		if !exists {
-			return 0, fmt.Errorf("user_id not found in token")
+	users := []UserSummary{}
+	for rows.Next() {
+		var user UserSummary
+		if err := rows.Scan(&user.ID, &user.Email, &user.Username, &user.DisplayName); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
 		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search users: %v", err)
+	}
+	return users, nil
+}
+
+func (s *AuthService) GetUserIDFromToken(ctx context.Context, tokenString string) (int, error) {
+	userId, _, err := s.GetUserIDAndExpiryFromToken(ctx, tokenString)
+	return userId, err
+}
+
+// GetUserIDAndExpiryFromToken validates the token and returns the user it
+// was issued to along with the time it expires.
+func (s *AuthService) GetUserIDAndExpiryFromToken(ctx context.Context, tokenString string) (int, time.Time, error) {
+	userId, _, expiresAt, err := s.GetSessionFromToken(ctx, tokenString)
+	return userId, expiresAt, err
+}
+
+// GetSessionFromToken validates the token and returns the user it was
+// issued to, its session, which is 0 for tokens not tied to one, and the
+// time it expires.
+func (s *AuthService) GetSessionFromToken(ctx context.Context, tokenString string) (int, int, time.Time, error) {
+	claims, err := s.authenticate(ctx, tokenString)
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	return claims.userId, claims.sessionId, claims.expiresAt, nil
+}
 
-		// Convert to int (handle the float64 JSON unmarshalling issue)
-		switch v := userIdValue.(type) {
-		case float64:
-			return int(v), nil
-		case int:
-			return v, nil
-		case string:
-			return strconv.Atoi(v)
-		default:
-			return 0, fmt.Errorf("invalid user_id type in token: %T", v)
+// tokenClaims are the claims of a valid token. sessionId is 0 for tokens
+// not tied to a session.
+type tokenClaims struct {
+	userId, sessionId int
+	expiresAt         time.Time
+}
+
+// authenticate validates the token, including its issuer and audience when
+// they are configured, and, if it is tied to a session, that the session
+// hasn't been revoked.
+func (s *AuthService) authenticate(ctx context.Context, tokenString string) (*tokenClaims, error) {
+	var options []jwt.ParserOption
+	if s.Issuer != "" {
+		options = append(options, jwt.WithIssuer(s.Issuer))
+	}
+	if s.Audience != "" {
+		options = append(options, jwt.WithAudience(s.Audience))
+	}
+	token, err := jwt.Parse(tokenString, s.verificationKeys, options...)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	userId, err := userIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	result := &tokenClaims{userId: userId}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.expiresAt = exp.Time
+	}
+
+	if sid, ok := claims["sid"].(float64); ok {
+		result.sessionId = int(sid)
+		if err := s.checkSession(ctx, userId, result.sessionId); err != nil {
+			return nil, err
 		}
 	}
+	return result, nil
+}
 
-	return 0, fmt.Errorf("invalid token claims")
+func userIDFromClaims(claims jwt.MapClaims) (int, error) {
+	userIdValue, exists := claims["user_id"]
+	if !exists {
+		return 0, fmt.Errorf("user_id not found in token")
+	}
+
+	// Convert to int (handle the float64 JSON unmarshalling issue)
+	switch v := userIdValue.(type) {
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("invalid user_id type in token: %T", v)
+	}
+}
+
+func (s *AuthService) GetUserIDFromAuthHeader(ctx context.Context, authHeader string) (int, error) {
+	tokenString, err := TokenFromAuthHeader(authHeader)
+	if err != nil {
+		return 0, err
+	}
+	return s.GetUserIDFromToken(ctx, tokenString)
 }
 
-func (s *AuthService) GetUserIDFromAuthHeader(authHeader string) (int, error) {
+// TokenFromAuthHeader extracts the bearer token from an Authorization header.
+func TokenFromAuthHeader(authHeader string) (string, error) {
 	if authHeader == "" {
-		return 0, fmt.Errorf("authorization header missing")
+		return "", fmt.Errorf("authorization header missing")
 	}
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return 0, fmt.Errorf("invalid authorization header format")
+		return "", fmt.Errorf("invalid authorization header format")
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	return s.GetUserIDFromToken(tokenString)
+	return strings.TrimPrefix(authHeader, "Bearer "), nil
 }
 
 func (s *AuthService) GetUserIDFromGinContext(c *gin.Context) (int, error) {
 	authHeader := c.GetHeader("Authorization")
-	return s.GetUserIDFromAuthHeader(authHeader)
+	return s.GetUserIDFromAuthHeader(c.Request.Context(), authHeader)
 }
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// sessionCheckInterval is how long a session found to be active is
+// trusted without checking again, which bounds both how often its last
+// seen time is written and how long a session revoked on another instance
+// keeps working here.
+const sessionCheckInterval = 30 * time.Second
+
+// maxUserAgentLength caps the user agents stored with sessions.
+const maxUserAgentLength = 512
+
+var (
+	// ErrSessionRevoked is returned for tokens whose session was revoked.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrSessionNotFound is returned when revoking a session the user
+	// doesn't have.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionUnavailable is returned for tokens whose session can't be
+	// checked because the database can't be reached.
+	ErrSessionUnavailable = errors.New("session can't be checked")
+)
+
+// SessionNotifier closes the live connections of revoked sessions.
+type SessionNotifier interface {
+	SessionRevoked(sessionId int)
+}
+
+// Session is a login: the token issued by it works until the session
+// expires or is revoked.
+type Session struct {
+	ID int `json:"id" example:"12"`
+	// UserAgent identifies the device the user logged in from.
+	UserAgent  string    `json:"user_agent" example:"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) Firefox/128.0"`
+	IP         string    `json:"ip" example:"203.0.113.7"`
+	CreatedAt  time.Time `json:"created_at" example:"2025-09-19T10:30:00Z"`
+	LastSeenAt time.Time `json:"last_seen_at" example:"2025-09-19T12:05:00Z"`
+	ExpiresAt  time.Time `json:"expires_at" example:"2025-09-20T10:30:00Z"`
+	// Current marks the session of the token the request was made with.
+	Current bool `json:"current" example:"true"`
+}
+
+// IssueToken starts a session for userId on the device identified by
+// userAgent and ip, records the login as the user's last, and returns a
+// token tied to it.
+func (s *AuthService) IssueToken(ctx context.Context, userId int, userAgent, ip string) (string, error) {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	expiresAt := time.Now().Add(s.tokenTTL())
+
+	// expired sessions are only ever listed by their user, so they are
+	// cleaned up when the user logs in again
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1 AND expires_at < NOW()", userId); err != nil {
+		return "", fmt.Errorf("failed to delete expired sessions: %v", err)
+	}
+
+	var sessionId int
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO sessions (user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, userId, userAgent, ip, expiresAt).Scan(&sessionId)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	if _, err := s.DB.ExecContext(ctx, "UPDATE users SET last_login_at = NOW(), last_login_ip = $1 WHERE id = $2", ip, userId); err != nil {
+		return "", fmt.Errorf("failed to record login: %v", err)
+	}
+
+	return s.signToken(newClaims(userId, sessionId, expiresAt))
+}
+
+// checkSession returns ErrSessionRevoked if the session is gone, and
+// records that it was seen otherwise. While the database can't be reached,
+// only sessions checked within sessionCheckInterval are accepted; others
+// get ErrSessionUnavailable, since they may have been revoked.
+func (s *AuthService) checkSession(ctx context.Context, userId, sessionId int) error {
+	s.sessionsMu.Lock()
+	checked, ok := s.sessionsChecked[sessionId]
+	s.sessionsMu.Unlock()
+	if ok && time.Since(checked) < sessionCheckInterval {
+		return nil
+	}
+
+	result, err := s.DB.ExecContext(ctx, "UPDATE sessions SET last_seen_at = NOW() WHERE id = $1 AND user_id = $2", sessionId, userId)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check session", "session_id", sessionId, "error", err)
+		return ErrSessionUnavailable
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if n == 0 {
+		s.forgetSession(sessionId)
+		return ErrSessionRevoked
+	}
+
+	s.sessionsMu.Lock()
+	if s.sessionsChecked == nil {
+		s.sessionsChecked = make(map[int]time.Time)
+	}
+	now := time.Now()
+	for id, checked := range s.sessionsChecked {
+		if now.Sub(checked) >= sessionCheckInterval {
+			delete(s.sessionsChecked, id)
+		}
+	}
+	s.sessionsChecked[sessionId] = now
+	s.sessionsMu.Unlock()
+	return nil
+}
+
+func (s *AuthService) forgetSession(sessionId int) {
+	s.sessionsMu.Lock()
+	delete(s.sessionsChecked, sessionId)
+	s.sessionsMu.Unlock()
+}
+
+// ListSessions returns the unexpired sessions of a user, most recently
+// seen first. currentSessionId is marked as current.
+func (s *AuthService) ListSessions(ctx context.Context, userId, currentSessionId int) ([]Session, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %v", err)
+		}
+		session.Current = session.ID == currentSessionId
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of a user, so its token stops working and
+// the Notifier closes its connections.
+func (s *AuthService) RevokeSession(ctx context.Context, userId, sessionId int) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1 AND user_id = $2", sessionId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	} else if n == 0 {
+		return ErrSessionNotFound
+	}
+	s.forgetSession(sessionId)
+	if s.Notifier != nil {
+		s.Notifier.SessionRevoked(sessionId)
+	}
+	return nil
+}
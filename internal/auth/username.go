@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// usernamePattern is 3 to 30 lowercase letters, digits, dots, dashes, and
+// underscores, starting with a letter or digit. Usernames can't contain
+// "@", so they are never mistaken for emails.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,29}$`)
+
+// usernameIndex is the unique index on users.username, named in the
+// errors of inserts and updates that would duplicate a username.
+const usernameIndex = "idx_users_username"
+
+var (
+	// ErrUsernameTaken is returned when setting a username another user
+	// has.
+	ErrUsernameTaken = errors.New("username taken")
+
+	errInvalidUsername = errors.New("username must be 3 to 30 letters, digits, dots, dashes, or underscores, starting with a letter or digit")
+)
+
+// NormalizeUsername returns username as stored: trimmed and lowercased, so
+// "Alice" and "alice" are the same user.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// ValidUsername reports whether a normalized username is allowed.
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// usernameTaken reports whether err is an insert or update failing
+// because the username is taken.
+func usernameTaken(err error) bool {
+	return err != nil && strings.Contains(err.Error(), usernameIndex)
+}
+
+// GetIdentity returns what identifies a user to their collaborators: their
+// username and avatar URL, each "" if they haven't set one.
+func (s *AuthService) GetIdentity(ctx context.Context, userId int) (username, avatarURL string, err error) {
+	err = s.DB.QueryRowContext(ctx, "SELECT COALESCE(username, ''), COALESCE(avatar_url, '') FROM users WHERE id = $1", userId).Scan(&username, &avatarURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get username: %v", err)
+	}
+	return username, avatarURL, nil
+}
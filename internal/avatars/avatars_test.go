@@ -0,0 +1,166 @@
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"live-collab-api/internal/storage"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore keeps objects in a map.
+type memoryStore struct {
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Put(ctx context.Context, userId int, key string, body io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	if _, ok := s.objects[key]; !ok {
+		return storage.ErrObjectNotFound
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func encodePNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("Error encoding image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func setupAvatarTest(t *testing.T, store Store) (*gin.Engine, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	handler := &AvatarHandler{AvatarService: &AvatarService{DB: db, Store: store, BaseURL: "https://api.example.com/"}}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userId", 7) })
+	router.POST("/api/me/avatar", handler.UploadAvatar)
+	router.DELETE("/api/me/avatar", handler.DeleteAvatar)
+	router.GET("/api/public/avatars/:name", handler.GetAvatar)
+	return router, mock
+}
+
+func uploadRequest(t *testing.T, data []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("avatar", "me.png")
+	if err != nil {
+		t.Fatalf("Error creating form: %v", err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/me/avatar", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadAvatar(t *testing.T) {
+	previousKey := storage.PrefixAvatars + "0b9e3f52-7c1d-4a8e-b6f2-3d5c9e1a7f40.png"
+	store := &memoryStore{objects: map[string][]byte{previousKey: []byte("old")}}
+	router, mock := setupAvatarTest(t, store)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(avatar_url, '') FROM users WHERE id = $1")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"avatar_url"}).
+			AddRow("https://api.example.com/api/public/avatars/0b9e3f52-7c1d-4a8e-b6f2-3d5c9e1a7f40.png"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET avatar_url = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	data := encodePNG(t)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, uploadRequest(t, data))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp AvatarResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	name, ok := strings.CutPrefix(resp.AvatarURL, "https://api.example.com/api/public/avatars/")
+	if !ok || !strings.HasSuffix(name, ".png") {
+		t.Fatalf("Unexpected avatar URL %q", resp.AvatarURL)
+	}
+	if _, ok := store.objects[previousKey]; ok {
+		t.Error("Expected the replaced avatar to be deleted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	// the URL serves the image
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/public/avatars/"+name, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Expected image/png, got %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), data) {
+		t.Error("Expected the uploaded image to be served")
+	}
+}
+
+func TestUploadAvatar_Rejected(t *testing.T) {
+	router, _ := setupAvatarTest(t, &memoryStore{objects: map[string][]byte{}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, uploadRequest(t, []byte("<svg onload=\"alert(1)\"></svg>")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a non-image, got %d", w.Code)
+	}
+
+	router, _ = setupAvatarTest(t, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, uploadRequest(t, encodePNG(t)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without storage, got %d", w.Code)
+	}
+}
+
+func TestGetAvatar_NotFound(t *testing.T) {
+	router, _ := setupAvatarTest(t, &memoryStore{objects: map[string][]byte{}})
+	for _, name := range []string{"0b9e3f52-7c1d-4a8e-b6f2-3d5c9e1a7f40.png", "..%2Fexports%2Fx.zip"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/public/avatars/"+name, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", name, w.Code)
+		}
+	}
+}
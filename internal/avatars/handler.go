@@ -0,0 +1,145 @@
+package avatars
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AvatarHandler struct {
+	AvatarService *AvatarService
+}
+
+type AvatarResponse struct {
+	AvatarURL string `json:"avatar_url" example:"https://api.example.com/api/public/avatars/5f0c6a8e-2d7b-4c3e-9a41-8b7f2e1d0c9a.png"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Avatar removed"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Error message"`
+}
+
+// UploadAvatar godoc
+// @Summary Upload my avatar
+// @Description Upload a PNG, JPEG, or GIF image of at most 2 MB and 4096 pixels a side as the avatar field of a multipart form. It becomes the profile's avatar_url, a stable URL shown in collaborator lists and presence, and the avatar uploaded before it is deleted.
+// @Tags user
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param avatar formData file true "Image"
+// @Success 200 {object} AvatarResponse "Avatar uploaded"
+// @Failure 400 {object} ErrorResponse "Missing file or unsupported image"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 413 {object} ErrorResponse "Image too large"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Failure 503 {object} ErrorResponse "Object storage is not configured"
+// @Router /api/me/avatar [post]
+func (h *AvatarHandler) UploadAvatar(c *gin.Context) {
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An image is required in the avatar field"})
+		return
+	}
+	if header.Size > MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Avatar must be at most %d bytes", MaxSize)})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, MaxSize))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return
+	}
+
+	url, err := h.AvatarService.Upload(c.Request.Context(), c.GetInt("userId"), data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrStorageDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Avatar uploads are not available"})
+		case errors.Is(err, ErrUnsupportedImage):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar must be a PNG, JPEG, or GIF image of at most 4096 pixels a side"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload avatar"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"avatar_url": url})
+}
+
+// DeleteAvatar godoc
+// @Summary Remove my avatar
+// @Description Clear the profile's avatar_url, deleting the image if it was uploaded.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} MessageResponse "Avatar removed"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "No avatar set"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/avatar [delete]
+func (h *AvatarHandler) DeleteAvatar(c *gin.Context) {
+	if err := h.AvatarService.Remove(c.Request.Context(), c.GetInt("userId")); err != nil {
+		if errors.Is(err, ErrAvatarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No avatar set"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Avatar removed"})
+}
+
+// GetAvatar godoc
+// @Summary Get an avatar
+// @Description Get an uploaded avatar image. The URL never changes content, so it can be cached indefinitely; uploading a new avatar gives it a new URL.
+// @Tags user
+// @Produce png
+// @Produce jpeg
+// @Produce gif
+// @Param name path string true "Avatar name from avatar_url"
+// @Success 200 {file} binary "Image"
+// @Failure 404 {object} ErrorResponse "Avatar not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/public/avatars/{name} [get]
+func (h *AvatarHandler) GetAvatar(c *gin.Context) {
+	body, contentType, err := h.AvatarService.Open(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		if errors.Is(err, ErrAvatarNotFound) || errors.Is(err, ErrStorageDisabled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+		return
+	}
+	defer body.Close()
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	// the image is only ever shown, never run, whatever it holds
+	c.Header("Content-Security-Policy", "default-src 'none'")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to send avatar", "error", err)
+	}
+}
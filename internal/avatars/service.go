@@ -0,0 +1,172 @@
+// Package avatars stores the pictures users upload for their profiles and
+// serves them at stable URLs.
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"live-collab-api/internal/storage"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// MaxSize caps uploaded avatars, in bytes.
+const MaxSize = 2 << 20
+
+// maxDimension caps the width and height of uploaded avatars, in pixels.
+const maxDimension = 4096
+
+// contentTypes are the image formats avatars can be in, by the name
+// image.DecodeConfig reports them under. SVG isn't accepted, as it can
+// carry script.
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+}
+
+// namePattern matches the names avatars are stored under: storage.NewKey's
+// UUID and extension, without the prefix.
+var namePattern = regexp.MustCompile(`^[0-9a-f-]{36}\.(png|jpeg|gif)$`)
+
+var (
+	// ErrStorageDisabled is returned when no store is configured.
+	ErrStorageDisabled = errors.New("object storage is not configured")
+	// ErrUnsupportedImage is returned for uploads that aren't a PNG, JPEG,
+	// or GIF image of at most maxDimension pixels a side.
+	ErrUnsupportedImage = errors.New("avatar must be a PNG, JPEG, or GIF image")
+	ErrAvatarNotFound   = errors.New("avatar not found")
+)
+
+// Store keeps avatar images. *storage.Store keeps them in S3-compatible
+// object storage; any other backend with the same methods can be used in
+// its place.
+type Store interface {
+	Put(ctx context.Context, userId int, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type AvatarService struct {
+	DB *sql.DB
+	// Store keeps the images. When nil avatars can't be uploaded.
+	Store Store
+	// BaseURL is the URL the API is reached at, which avatar URLs start
+	// with.
+	BaseURL string
+}
+
+// URL returns the stable URL of the avatar stored under name.
+func (s *AvatarService) URL(name string) string {
+	return s.urlPrefix() + name
+}
+
+func (s *AvatarService) urlPrefix() string {
+	return strings.TrimRight(s.BaseURL, "/") + "/api/public/avatars/"
+}
+
+// Upload stores the image in data as the user's avatar, sets their
+// avatar_url to it, and deletes the avatar it replaces, if that was
+// uploaded too. It returns the new URL.
+func (s *AvatarService) Upload(ctx context.Context, userId int, data []byte) (string, error) {
+	if s.Store == nil {
+		return "", ErrStorageDisabled
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	contentType, ok := contentTypes[format]
+	if err != nil || !ok || config.Width > maxDimension || config.Height > maxDimension {
+		return "", ErrUnsupportedImage
+	}
+
+	var previous string
+	err = s.DB.QueryRowContext(ctx, "SELECT COALESCE(avatar_url, '') FROM users WHERE id = $1", userId).Scan(&previous)
+	if err != nil {
+		return "", fmt.Errorf("failed to get avatar: %v", err)
+	}
+
+	key := storage.NewKey(storage.PrefixAvatars, "avatar."+format)
+	if err := s.Store.Put(ctx, userId, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return "", fmt.Errorf("failed to store avatar: %v", err)
+	}
+
+	url := s.URL(strings.TrimPrefix(key, storage.PrefixAvatars))
+	if _, err := s.DB.ExecContext(ctx, "UPDATE users SET avatar_url = $1 WHERE id = $2", url, userId); err != nil {
+		s.deleteObject(ctx, key)
+		return "", fmt.Errorf("failed to set avatar: %v", err)
+	}
+
+	if key, ok := s.keyFromURL(previous); ok {
+		s.deleteObject(ctx, key)
+	}
+	return url, nil
+}
+
+// Remove clears the user's avatar, deleting the image if it was uploaded.
+// It returns ErrAvatarNotFound if the user has no avatar.
+func (s *AvatarService) Remove(ctx context.Context, userId int) error {
+	var previous string
+	err := s.DB.QueryRowContext(ctx, "SELECT COALESCE(avatar_url, '') FROM users WHERE id = $1", userId).Scan(&previous)
+	if err != nil {
+		return fmt.Errorf("failed to get avatar: %v", err)
+	}
+	if previous == "" {
+		return ErrAvatarNotFound
+	}
+
+	if _, err := s.DB.ExecContext(ctx, "UPDATE users SET avatar_url = NULL WHERE id = $1", userId); err != nil {
+		return fmt.Errorf("failed to remove avatar: %v", err)
+	}
+
+	if key, ok := s.keyFromURL(previous); ok && s.Store != nil {
+		s.deleteObject(ctx, key)
+	}
+	return nil
+}
+
+// Open returns the avatar stored under name and its content type. The
+// caller must close it.
+func (s *AvatarService) Open(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	if s.Store == nil {
+		return nil, "", ErrStorageDisabled
+	}
+	match := namePattern.FindStringSubmatch(name)
+	if match == nil {
+		return nil, "", ErrAvatarNotFound
+	}
+
+	body, err := s.Store.Get(ctx, storage.PrefixAvatars+name)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, "", ErrAvatarNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get avatar: %v", err)
+	}
+	return body, contentTypes[match[1]], nil
+}
+
+// keyFromURL returns the key of an avatar URL pointing at an uploaded
+// avatar, as opposed to an image hosted elsewhere.
+func (s *AvatarService) keyFromURL(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, s.urlPrefix())
+	if !ok || !namePattern.MatchString(name) {
+		return "", false
+	}
+	return storage.PrefixAvatars + name, true
+}
+
+// deleteObject deletes a replaced avatar. Failures are only logged: the
+// image stays recorded in storage, and nothing links to it anymore.
+func (s *AvatarService) deleteObject(ctx context.Context, key string) {
+	if err := s.Store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		slog.WarnContext(ctx, "Failed to delete avatar", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package buildinfo
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with -ldflags, e.g.
+//
+//	go build -ldflags "-X live-collab-api/internal/buildinfo.Commit=$(git rev-parse HEAD)
+//	  -X live-collab-api/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+//	  -X live-collab-api/internal/buildinfo.SchemaVersion=$(ls internal/db/migrations | tail -n 1 | cut -c 1-5)" ./cmd/server
+//
+// Without them the commit and time recorded by the Go toolchain are used,
+// and the schema version is read from the migrations on disk.
+var (
+	Commit    string
+	BuildTime string
+	// SchemaVersion is the latest migration the build was made with.
+	SchemaVersion string
+)
+
+type Info struct {
+	Commit    string `json:"commit" example:"4d5f21a9c3e0b7f1a2d4c6e8b0f2a4c6e8d0b2f4"`
+	BuildTime string `json:"build_time" example:"2026-10-16T12:00:00Z"`
+	GoVersion string `json:"go_version" example:"go1.25.1"`
+	// SchemaVersion is the migration this build expects the database to
+	// be at, and DatabaseVersion the one it is at. Either is omitted when
+	// it can't be determined.
+	SchemaVersion   *int64 `json:"schema_version,omitempty" example:"21"`
+	DatabaseVersion *int64 `json:"database_version,omitempty" example:"21"`
+}
+
+// Get returns what is known about the build without asking the database.
+func Get() Info {
+	info := Info{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && Commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+
+	if version, err := strconv.ParseInt(SchemaVersion, 10, 64); err == nil {
+		info.SchemaVersion = &version
+	}
+	return info
+}
+
+// Handler serves the build information along with the schema versions.
+type Handler struct {
+	// DatabaseVersion reads the migration the database is at.
+	DatabaseVersion func(ctx context.Context) (int64, error)
+	// LatestMigration finds the expected schema version when it wasn't
+	// set at build time.
+	LatestMigration func() (int64, error)
+}
+
+// GetVersion godoc
+// @Summary Build information
+// @Description Reports the git commit and time the server was built from, the Go version, and the migration the build expects next to the one the database is at, to confirm what exactly is deployed.
+// @Tags health
+// @Produce json
+// @Success 200 {object} Info
+// @Router /version [get]
+func (h *Handler) GetVersion(c *gin.Context) {
+	info := Get()
+
+	if info.SchemaVersion == nil && h.LatestMigration != nil {
+		if version, err := h.LatestMigration(); err == nil {
+			info.SchemaVersion = &version
+		} else {
+			slog.WarnContext(c.Request.Context(), "Failed to find latest migration", "error", err)
+		}
+	}
+	if h.DatabaseVersion != nil {
+		if version, err := h.DatabaseVersion(c.Request.Context()); err == nil {
+			info.DatabaseVersion = &version
+		} else {
+			slog.WarnContext(c.Request.Context(), "Failed to get database schema version", "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, info)
+}
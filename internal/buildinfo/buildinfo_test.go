@@ -0,0 +1,72 @@
+package buildinfo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getVersion(t *testing.T, h *Handler) Info {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/version", h.GetVersion)
+
+	req, _ := http.NewRequest("GET", "/version", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var info Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	return info
+}
+
+func TestGetVersion_UsesBuildFlags(t *testing.T) {
+	Commit, BuildTime, SchemaVersion = "abc123", "2026-10-16T12:00:00Z", "00021"
+	t.Cleanup(func() { Commit, BuildTime, SchemaVersion = "", "", "" })
+
+	info := getVersion(t, &Handler{
+		DatabaseVersion: func(context.Context) (int64, error) { return 20, nil },
+		LatestMigration: func() (int64, error) { return 0, errors.New("should not be read") },
+	})
+
+	if info.Commit != "abc123" || info.BuildTime != "2026-10-16T12:00:00Z" {
+		t.Errorf("Expected the commit and build time from the build flags, got %q and %q", info.Commit, info.BuildTime)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("Expected Go version %q, got %q", runtime.Version(), info.GoVersion)
+	}
+	if info.SchemaVersion == nil || *info.SchemaVersion != 21 {
+		t.Errorf("Expected schema version 21, got %v", info.SchemaVersion)
+	}
+	if info.DatabaseVersion == nil || *info.DatabaseVersion != 20 {
+		t.Errorf("Expected database version 20, got %v", info.DatabaseVersion)
+	}
+}
+
+func TestGetVersion_FallsBack(t *testing.T) {
+	info := getVersion(t, &Handler{
+		DatabaseVersion: func(context.Context) (int64, error) { return 0, errors.New("connection refused") },
+		LatestMigration: func() (int64, error) { return 21, nil },
+	})
+
+	if info.Commit == "" || info.BuildTime == "" {
+		t.Errorf("Expected a commit and build time even without build flags, got %q and %q", info.Commit, info.BuildTime)
+	}
+	if info.SchemaVersion == nil || *info.SchemaVersion != 21 {
+		t.Errorf("Expected schema version 21 from the migrations, got %v", info.SchemaVersion)
+	}
+	if info.DatabaseVersion != nil {
+		t.Errorf("Expected no database version when it can't be read, got %d", *info.DatabaseVersion)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// envFile is the .env file settings are loaded from, and inherited lists
+// the variables set in the process environment, which take precedence over
+// it.
+type envFile struct {
+	path      string
+	inherited map[string]bool
+}
+
+var (
+	loadEnvFileOnce sync.Once
+	loadedEnvFile   *envFile
+	envFileErr      error
+)
+
+// loadEnvFile loads a .env file without overriding variables already set.
+// ENV_FILE names the file, and an empty ENV_FILE turns loading off. Without
+// it, .env is looked for in the working directory and, when running from
+// cmd/server, the project root, but only in development so a stray file is
+// never picked up in production. It returns nil when no file was loaded.
+func loadEnvFile() (*envFile, error) {
+	file := &envFile{inherited: make(map[string]bool)}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		file.inherited[key] = true
+	}
+
+	if path, explicit := os.LookupEnv("ENV_FILE"); explicit {
+		if path == "" {
+			return nil, nil
+		}
+		if err := godotenv.Load(path); err != nil {
+			return nil, fmt.Errorf("ENV_FILE can't be read: %v", err)
+		}
+		file.path = path
+		return file, nil
+	}
+
+	if !developmentEnvironments[strings.ToLower(getEnv("APP_ENV", "development"))] {
+		return nil, nil
+	}
+	for _, path := range []string{".env", "../../.env"} {
+		if err := godotenv.Load(path); err == nil {
+			file.path = path
+			return file, nil
+		}
+	}
+	slog.Warn("Could not load .env", "dir", os.Getenv("PWD"))
+	return nil, nil
+}
+
+// ReloadEnvFile reads the .env file loaded by LoadConfig again, so the next
+// LoadConfig picks up changed settings. Variables set in the process
+// environment still take precedence.
+func ReloadEnvFile() error {
+	if loadedEnvFile == nil {
+		return nil
+	}
+
+	values, err := godotenv.Read(loadedEnvFile.path)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		if !loadedEnvFile.inherited[key] {
+			os.Setenv(key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// redacted replaces secret values when the configuration is printed.
+const redacted = "[redacted]"
+
+// dsnPasswordPattern matches the password in a keyword/value connection
+// string such as "host=db password=secret".
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of the configuration with secrets hidden. URLs
+// keep everything but their password.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.DBUrl = redactURL(c.DBUrl)
+	r.RedisUrl = redactURL(c.RedisUrl)
+	r.JWTSecret = redact(c.JWTSecret)
+	r.JWTPreviousSecrets = redact(c.JWTPreviousSecrets)
+	r.JWTPrivateKey = redact(c.JWTPrivateKey)
+	r.S3SecretAccessKey = redact(c.S3SecretAccessKey)
+	r.GRPCAPIKeys = redact(c.GRPCAPIKeys)
+	r.SummaryAPIKey = redact(c.SummaryAPIKey)
+	r.SMTPPassword = redact(c.SMTPPassword)
+	r.GoogleClientSecret = redact(c.GoogleClientSecret)
+	r.GitHubClientSecret = redact(c.GitHubClientSecret)
+	r.invalid = nil
+	return &r
+}
+
+// Print writes the configuration with secrets redacted, one setting per
+// line, so operators can check what the process runs with.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	v := reflect.ValueOf(c.Redacted()).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%v\n", field.Name, v.Field(i).Interface())
+	}
+	return tw.Flush()
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	if strings.Contains(strings.ToLower(raw), "password") {
+		return dsnPasswordPattern.ReplaceAllString(raw, "${1}"+redacted)
+	}
+	return raw
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vaultTimeout bounds the request for secrets at startup.
+const vaultTimeout = 10 * time.Second
+
+// secret reads a secret setting. In order of precedence it comes from the
+// environment variable itself, from the file named by key_FILE (as mounted
+// by Docker and Kubernetes secrets), or from Vault.
+func (p *envParser) secret(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	path, fromFile := os.LookupEnv(key + "_FILE")
+	if ok {
+		if fromFile {
+			p.errs = append(p.errs, fmt.Errorf("%s and %s_FILE can't both be set", key, key))
+		}
+		return value
+	}
+
+	if fromFile {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s_FILE can't be read: %v", key, err))
+			return fallback
+		}
+		return strings.TrimRight(string(data), "\r\n")
+	}
+
+	if value, ok := p.vault[key]; ok {
+		return value
+	}
+	return fallback
+}
+
+// loadVault reads the secrets stored at path in Vault's key/value engine
+// (version 1 or 2). Keys are named after the environment variables they
+// stand in for, such as JWT_SECRET.
+func (p *envParser) loadVault(addr, token, path string) {
+	if token == "" {
+		p.errs = append(p.errs, errors.New("VAULT_TOKEN must be set when VAULT_ADDR is"))
+		return
+	}
+
+	secrets, err := readVault(addr, token, path)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("failed to read secrets from Vault: %v", err))
+		return
+	}
+	p.vault = secrets
+}
+
+func readVault(addr, token, path string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := &http.Client{Timeout: vaultTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	// version 2 nests the secret under data.data next to data.metadata
+	data := body.Data
+	if nested, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("invalid response: %v", err)
+			}
+		}
+	}
+
+	secrets := make(map[string]string, len(data))
+	for key, raw := range data {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%s is not a string", key)
+		}
+		secrets[key] = value
+	}
+	return secrets, nil
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// developmentEnvironments are the environments where insecure defaults are
+// tolerated with a warning.
+var developmentEnvironments = map[string]bool{
+	"development": true,
+	"dev":         true,
+	"local":       true,
+	"test":        true,
+}
+
+// searchLanguagePattern matches the name of a text search configuration,
+// optionally schema-qualified.
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// IsDevelopment reports whether the server runs in a development or test
+// environment.
+func (c *Config) IsDevelopment() bool {
+	return developmentEnvironments[c.Environment]
+}
+
+// Validate checks the whole configuration and returns every problem found,
+// joined into one error. Insecure settings are errors outside development
+// and are logged as warnings in development.
+func (c *Config) Validate() error {
+	problems := append([]error(nil), c.invalid...)
+	insecure := func(format string, args ...interface{}) {
+		err := fmt.Errorf(format, args...)
+		if c.IsDevelopment() {
+			slog.Warn("Insecure configuration allowed in development", "problem", err.Error())
+			return
+		}
+		problems = append(problems, err)
+	}
+
+	switch {
+	case c.JWTSecret == "":
+		problems = append(problems, errors.New("JWT_SECRET must be set"))
+	case c.JWTSecret == defaultJWTSecret:
+		insecure("JWT_SECRET must be set; the built-in default is public")
+	case len(c.JWTSecret) < minJWTSecretLength:
+		insecure("JWT_SECRET must be at least %d bytes, got %d", minJWTSecretLength, len(c.JWTSecret))
+	}
+	// WebSocket clients are asked for a fresh token 5 minutes before theirs
+	// expires, which needs tokens to last a while longer
+	if c.JWTTTL < 10*time.Minute {
+		problems = append(problems, fmt.Errorf("JWT_TTL must be at least 10m, got %v", c.JWTTTL))
+	}
+	for _, secret := range c.PreviousJWTSecrets() {
+		if len(secret) < minJWTSecretLength {
+			insecure("JWT_PREVIOUS_SECRETS must be at least %d bytes each", minJWTSecretLength)
+			break
+		}
+	}
+
+	if err := checkURL(c.DBUrl, "postgres", "postgresql"); err != nil {
+		problems = append(problems, fmt.Errorf("DATABASE_URL %v", err))
+	} else if c.DBUrl == defaultDBUrl {
+		insecure("DATABASE_URL must be set; the built-in default uses a public password")
+	}
+
+	if err := checkURL(c.RedisUrl, "redis", "rediss", "unix"); err != nil {
+		problems = append(problems, fmt.Errorf("REDIS_URL %v", err))
+	}
+
+	if err := checkURL(c.FrontendUrl, "http", "https"); err != nil {
+		problems = append(problems, fmt.Errorf("FRONTEND_URL %v", err))
+	}
+
+	if err := checkURL(c.PublicUrl, "http", "https"); err != nil {
+		problems = append(problems, fmt.Errorf("PUBLIC_URL %v", err))
+	}
+	if c.GoogleClientID != "" && c.GoogleClientSecret == "" {
+		problems = append(problems, errors.New("GOOGLE_CLIENT_SECRET must be set when GOOGLE_CLIENT_ID is"))
+	}
+	if c.GitHubClientID != "" && c.GitHubClientSecret == "" {
+		problems = append(problems, errors.New("GITHUB_CLIENT_SECRET must be set when GITHUB_CLIENT_ID is"))
+	}
+
+	for _, origin := range strings.Split(c.AllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if err := checkOrigin(origin); err != nil {
+			problems = append(problems, fmt.Errorf("ALLOWED_ORIGINS entry %q %v", origin, err))
+		}
+	}
+	if c.AllowAllOrigins {
+		insecure("ALLOW_ALL_ORIGINS accepts API requests and WebSocket connections from any site")
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Errorf("PORT must be a number between 1 and 65535, got %q", c.Port))
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		problems = append(problems, fmt.Errorf("ADDR must be host:port or :port, got %q", c.Addr))
+	}
+
+	for _, setting := range []struct{ name, value string }{
+		{"IP_ALLOWLIST", c.IPAllowlist},
+		{"IP_DENYLIST", c.IPDenylist},
+		{"ADMIN_IP_ALLOWLIST", c.AdminIPAllowlist},
+	} {
+		if err := checkCIDRs(setting.value); err != nil {
+			problems = append(problems, fmt.Errorf("%s %v", setting.name, err))
+		}
+	}
+	if c.TrustedProxies == "*" {
+		insecure("TRUSTED_PROXIES must list the proxies; trusting every proxy lets clients choose their IP with X-Forwarded-For, getting around rate limits and IP rules")
+	} else if err := checkCIDRs(c.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Errorf("TRUSTED_PROXIES %v", err))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if c.TLSCertFile != "" && c.AutocertDomains != "" {
+		problems = append(problems, errors.New("TLS_CERT_FILE and AUTOCERT_DOMAINS can't both be set"))
+	}
+
+	for _, key := range strings.Split(c.GRPCAPIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" && len(key) < minAPIKeyLength {
+			insecure("GRPC_API_KEYS must be at least %d bytes each", minAPIKeyLength)
+			break
+		}
+	}
+
+	if !searchLanguagePattern.MatchString(c.SearchLanguage) {
+		problems = append(problems, fmt.Errorf("SEARCH_LANGUAGE must name a text search configuration such as english, got %q", c.SearchLanguage))
+	}
+	if c.UsageFlushInterval <= 0 {
+		problems = append(problems, fmt.Errorf("USAGE_FLUSH_INTERVAL must be positive, got %v", c.UsageFlushInterval))
+	}
+	if c.IdempotencyTTL <= 0 {
+		problems = append(problems, fmt.Errorf("IDEMPOTENCY_TTL must be positive, got %v", c.IdempotencyTTL))
+	}
+	if c.MaxRequestBodySize < 1 {
+		problems = append(problems, fmt.Errorf("MAX_REQUEST_BODY_SIZE must be at least 1, got %d", c.MaxRequestBodySize))
+	}
+	if c.WebhookWorkers < 1 {
+		problems = append(problems, fmt.Errorf("WEBHOOK_WORKERS must be at least 1, got %d", c.WebhookWorkers))
+	}
+	if c.JobWorkers < 1 {
+		problems = append(problems, fmt.Errorf("JOB_WORKERS must be at least 1, got %d", c.JobWorkers))
+	}
+	if c.ImportMaxSize < 1 {
+		problems = append(problems, fmt.Errorf("IMPORT_MAX_SIZE must be at least 1, got %d", c.ImportMaxSize))
+	}
+	if c.ImportTimeout <= 0 {
+		problems = append(problems, fmt.Errorf("IMPORT_TIMEOUT must be positive, got %v", c.ImportTimeout))
+	}
+	if c.ArchiveInactiveAfter > 0 && c.ArchiveWarning >= c.ArchiveInactiveAfter {
+		problems = append(problems, fmt.Errorf("ARCHIVE_WARNING must be shorter than ARCHIVE_INACTIVE_AFTER, got %v", c.ArchiveWarning))
+	}
+
+	if c.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Errorf("SMTP_ADDR must be host:port, got %q", c.SMTPAddr))
+		}
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			problems = append(problems, fmt.Errorf("MAIL_FROM must be an email address, got %q", c.MailFrom))
+		}
+	}
+
+	if c.S3Bucket != "" {
+		if c.S3AccessKeyID == "" || c.S3SecretAccessKey == "" {
+			problems = append(problems, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set when S3_BUCKET is"))
+		}
+		if c.S3Endpoint != "" {
+			if err := checkURL(c.S3Endpoint, "http", "https"); err != nil {
+				problems = append(problems, fmt.Errorf("S3_ENDPOINT %v", err))
+			}
+		}
+		// signed S3 URLs are valid for at most a week
+		if c.ExportTTL < time.Minute || c.ExportTTL > 7*24*time.Hour {
+			problems = append(problems, fmt.Errorf("EXPORT_TTL must be between 1m and 168h, got %v", c.ExportTTL))
+		}
+	}
+
+	if c.OTLPEndpoint != "" {
+		if err := checkURL(c.OTLPEndpoint, "http", "https"); err != nil {
+			problems = append(problems, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT %v", err))
+		}
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		problems = append(problems, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1, got %g", c.TraceSampleRatio))
+	}
+
+	return errors.Join(problems...)
+}
+
+// checkURL returns an error describing why raw isn't an absolute URL with
+// one of the given schemes.
+func checkURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("is not a valid URL")
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme != scheme {
+			continue
+		}
+		if u.Host == "" && scheme != "unix" {
+			return errors.New("is missing a host")
+		}
+		return nil
+	}
+	return fmt.Errorf("must use the %s scheme", strings.Join(schemes, " or "))
+}
+
+// checkOrigin returns an error describing why origin isn't a browser origin
+// such as "https://app.example.com" or a wildcard subdomain pattern such as
+// "https://*.example.com". The scheme may be left out to match both.
+func checkOrigin(origin string) error {
+	host := strings.TrimSuffix(strings.ToLower(origin), "/")
+	if scheme, rest, found := strings.Cut(host, "://"); found {
+		if scheme != "http" && scheme != "https" {
+			return errors.New("must use the http or https scheme")
+		}
+		host = rest
+	}
+
+	if host == "*" {
+		return errors.New("can't allow every origin; use ALLOW_ALL_ORIGINS instead")
+	}
+	if suffix, found := strings.CutPrefix(host, "*."); found {
+		if !strings.Contains(suffix, ".") {
+			return errors.New("must not match every subdomain of a top-level domain")
+		}
+		host = suffix
+	}
+
+	if host == "" || strings.ContainsAny(host, "*/?#@ ") {
+		return errors.New("must be a scheme and host with an optional port and no path")
+	}
+	if u, err := url.Parse("http://" + host); err != nil || u.Hostname() == "" {
+		return errors.New("is not a valid origin")
+	}
+	return nil
+}
+
+// checkCIDRs returns an error naming the first entry of a comma-separated
+// list that isn't an IP address or CIDR range.
+func checkCIDRs(list string) error {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(entry); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(entry); err != nil {
+			return fmt.Errorf("entry %q is not an IP address or CIDR range", entry)
+		}
+	}
+	return nil
+}
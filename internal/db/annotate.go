@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+
+	"live-collab-api/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// annotate appends the ID of the request ctx belongs to as a SQL comment,
+// so slow queries in pg_stat_activity and the Postgres logs can be traced
+// back to the request that issued them.
+func annotate(ctx context.Context, query string) (string, bool) {
+	requestId := logging.RequestID(ctx)
+	if requestId == "" {
+		return query, false
+	}
+	return query + " /*request_id='" + url.QueryEscape(requestId) + "'*/", true
+}
+
+// annotatedArgs prepends the exec mode for annotated queries. Every request
+// produces a distinct query text, so caching its prepared statement would
+// only evict statements that are reused.
+func annotatedArgs(args []driver.NamedValue) []driver.NamedValue {
+	return append([]driver.NamedValue{{Value: pgx.QueryExecModeExec}}, args...)
+}
+
+// annotatingConnector hands out pgx connections that annotate queries with
+// the request ID. Queries made on the pool directly aren't annotated.
+type annotatingConnector struct {
+	driver.Connector
+}
+
+func (c annotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &annotatingConn{conn.(*stdlib.Conn)}, nil
+}
+
+type annotatingConn struct {
+	*stdlib.Conn
+}
+
+func (c *annotatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if annotated, ok := annotate(ctx, query); ok {
+		return c.Conn.ExecContext(ctx, annotated, annotatedArgs(args))
+	}
+	return c.Conn.ExecContext(ctx, query, args)
+}
+
+func (c *annotatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if annotated, ok := annotate(ctx, query); ok {
+		return c.Conn.QueryContext(ctx, annotated, annotatedArgs(args))
+	}
+	return c.Conn.QueryContext(ctx, query, args)
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"live-collab-api/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAnnotate(t *testing.T) {
+	query := "SELECT id FROM documents WHERE id = $1"
+
+	if got, ok := annotate(context.Background(), query); ok || got != query {
+		t.Errorf("Expected query outside a request to be unchanged, got %q", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(logging.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		got, ok := annotate(c.Request.Context(), query)
+		expected := query + " /*request_id='req-789'*/"
+		if !ok || got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.RequestIDHeader, "req-789")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
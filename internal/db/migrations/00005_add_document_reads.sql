@@ -0,0 +1,15 @@
+-- +goose Up
+-- 00005_add_document_reads.sql
+CREATE TABLE IF NOT EXISTS document_reads(
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY(document_id, user_id)
+);
+
+CREATE INDEX idx_document_reads_user ON document_reads(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_document_reads_user;
+DROP TABLE IF EXISTS document_reads;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- 00006_add_published_to_documents.sql
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS published BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE documents DROP COLUMN IF EXISTS published;
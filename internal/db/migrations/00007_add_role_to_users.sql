@@ -0,0 +1,6 @@
+-- +goose Up
+-- 00007_add_role_to_users.sql
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- 00008_add_organizations.sql
+CREATE TABLE IF NOT EXISTS organizations(
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members(
+    organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY(organization_id, user_id)
+);
+
+CREATE INDEX idx_organization_members_user ON organization_members(user_id);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS organization_id INT REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX idx_documents_organization ON documents(organization_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_documents_organization;
+ALTER TABLE documents DROP COLUMN IF EXISTS organization_id;
+DROP INDEX IF EXISTS idx_organization_members_user;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
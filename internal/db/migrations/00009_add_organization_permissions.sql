@@ -0,0 +1,18 @@
+-- +goose Up
+-- 00009_add_organization_permissions.sql
+ALTER TABLE organization_members DROP CONSTRAINT IF EXISTS organization_members_role_check;
+ALTER TABLE organization_members ADD CONSTRAINT organization_members_role_check
+    CHECK (role IN ('owner', 'admin', 'member', 'guest'));
+
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS member_permission VARCHAR(10) NOT NULL DEFAULT 'edit'
+    CHECK (member_permission IN ('view', 'edit'));
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS guest_permission VARCHAR(10) NOT NULL DEFAULT 'view'
+    CHECK (guest_permission IN ('view', 'edit'));
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN IF EXISTS guest_permission;
+ALTER TABLE organizations DROP COLUMN IF EXISTS member_permission;
+DELETE FROM organization_members WHERE role = 'guest';
+ALTER TABLE organization_members DROP CONSTRAINT IF EXISTS organization_members_role_check;
+ALTER TABLE organization_members ADD CONSTRAINT organization_members_role_check
+    CHECK (role IN ('owner', 'admin', 'member'));
@@ -0,0 +1,13 @@
+-- +goose Up
+-- 00010_add_usage_counters.sql
+CREATE TABLE IF NOT EXISTS usage_counters(
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('user', 'organization')),
+    scope_id INT NOT NULL,
+    period DATE NOT NULL,
+    metric VARCHAR(40) NOT NULL,
+    value BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY(scope, scope_id, period, metric)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS usage_counters;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- 00013_add_storage_objects.sql
+CREATE TABLE IF NOT EXISTS storage_objects(
+    key TEXT PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    content_type VARCHAR(255),
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX idx_storage_objects_user_id ON storage_objects(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS storage_objects;
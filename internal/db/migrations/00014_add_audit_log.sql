@@ -0,0 +1,43 @@
+-- +goose Up
+-- 00014_add_audit_log.sql
+-- user_id and document_id are deliberately not foreign keys so entries
+-- outlive the users and documents they refer to.
+CREATE TABLE IF NOT EXISTS audit_log(
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT,
+    document_id INT,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    route TEXT,
+    status INT NOT NULL,
+    duration_ms INT NOT NULL,
+    client_ip TEXT,
+    request_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX idx_audit_log_user_id ON audit_log(user_id, created_at);
+CREATE INDEX idx_audit_log_document_id ON audit_log(document_id, created_at);
+
+-- The log is append-only: rows can't be changed or removed through the
+-- application's connection.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_log_no_update
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
@@ -0,0 +1,96 @@
+-- +goose Up
+-- 00015_partition_events.sql
+-- events is partitioned by month of created_at, so inserts and queries for
+-- recent history only touch small, recent partitions and old months can be
+-- detached or dropped on their own. The existing table is attached as the
+-- partition for everything up to the end of the current month instead of
+-- copying its rows; monthly partitions follow from next month on.
+
+-- create_events_partitions creates the missing monthly partitions from the
+-- current month through months_ahead months later, in UTC, and returns how
+-- many it created. Months already covered by another partition are skipped.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION create_events_partitions(months_ahead INT) RETURNS INT AS $$
+DECLARE
+    month_start TIMESTAMPTZ := date_trunc('month', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+    partition_name TEXT;
+    created INT := 0;
+BEGIN
+    FOR i IN 0..months_ahead LOOP
+        partition_name := 'events_' || to_char(month_start AT TIME ZONE 'UTC', 'YYYY_MM');
+        IF to_regclass(partition_name) IS NULL THEN
+            BEGIN
+                EXECUTE format('CREATE TABLE %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+                    partition_name, month_start, month_start + INTERVAL '1 month');
+                created := created + 1;
+            EXCEPTION WHEN invalid_object_definition THEN
+                -- the month overlaps an existing partition
+                NULL;
+            END;
+        END IF;
+        month_start := month_start + INTERVAL '1 month';
+    END LOOP;
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    legacy_end TIMESTAMPTZ := (date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '1 month') AT TIME ZONE 'UTC';
+BEGIN
+    ALTER TABLE events RENAME TO events_legacy;
+    ALTER TABLE events_legacy RENAME CONSTRAINT events_pkey TO events_legacy_pkey;
+
+    -- the partition key can't be NULL, and must be part of the primary key
+    UPDATE events_legacy SET created_at = COALESCE(updated_at, now()) WHERE created_at IS NULL;
+    ALTER TABLE events_legacy ALTER COLUMN created_at SET NOT NULL;
+    ALTER TABLE events_legacy DROP CONSTRAINT events_legacy_pkey;
+    ALTER TABLE events_legacy ADD CONSTRAINT events_legacy_pkey PRIMARY KEY (id, created_at);
+    -- lets ATTACH skip scanning the table to validate the range
+    EXECUTE format('ALTER TABLE events_legacy ADD CONSTRAINT events_legacy_range CHECK (created_at < %L)', legacy_end);
+
+    CREATE TABLE events (
+        id INT NOT NULL DEFAULT nextval('events_id_seq'),
+        document_id INT REFERENCES documents(id),
+        user_id INT REFERENCES users(id),
+        event_type TEXT NOT NULL,
+        payload JSONB NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        updated_at TIMESTAMPTZ DEFAULT now(),
+        PRIMARY KEY (id, created_at)
+    ) PARTITION BY RANGE (created_at);
+
+    EXECUTE format('ALTER TABLE events ATTACH PARTITION events_legacy FOR VALUES FROM (MINVALUE) TO (%L)', legacy_end);
+    ALTER TABLE events_legacy DROP CONSTRAINT events_legacy_range;
+    ALTER SEQUENCE events_id_seq OWNED BY events.id;
+END $$;
+-- +goose StatementEnd
+
+SELECT create_events_partitions(3);
+
+CREATE INDEX idx_events_document_created ON events(document_id, created_at);
+
+-- +goose Down
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+
+CREATE TABLE events_unpartitioned (
+    id INT PRIMARY KEY DEFAULT nextval('events_id_seq'),
+    document_id INT REFERENCES documents(id),
+    user_id INT REFERENCES users(id),
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
+
+INSERT INTO events_unpartitioned (id, document_id, user_id, event_type, payload, created_at, updated_at)
+SELECT id, document_id, user_id, event_type, payload, created_at, updated_at FROM events;
+
+DROP TABLE events;
+ALTER TABLE events_unpartitioned RENAME TO events;
+ALTER INDEX events_unpartitioned_pkey RENAME TO events_pkey;
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+DROP FUNCTION IF EXISTS create_events_partitions(INT);
@@ -0,0 +1,21 @@
+-- +goose Up
+-- 00016_add_event_version.sql
+-- The document version an edit produced, copied out of the payload so the
+-- current version and the edits after a version can be read from an index
+-- instead of casting payload->>'version' on every row. It is NULL for
+-- events other than edits. Listings by time use idx_events_document_created
+-- from 00015.
+ALTER TABLE events
+    ADD COLUMN version INT;
+
+UPDATE events SET version = CAST(payload->>'version' AS INTEGER)
+WHERE event_type = 'edit' AND payload ? 'version';
+
+CREATE INDEX idx_events_document_version ON events(document_id, version)
+WHERE event_type = 'edit';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_events_document_version;
+
+ALTER TABLE events
+    DROP COLUMN IF EXISTS version;
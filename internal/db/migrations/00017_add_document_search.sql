@@ -0,0 +1,40 @@
+-- +goose Up
+-- 00017_add_document_search.sql
+-- search_vector holds the title and content of a document as a tsvector
+-- for full-text search, kept up to date by a trigger and parsed with the
+-- text search configuration in search_language. Changing search_language
+-- rebuilds it. Only the first 250000 characters of the content are indexed
+-- so the tsvector stays well below its 1MB limit.
+ALTER TABLE documents
+    ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'english',
+    ADD COLUMN search_vector TSVECTOR;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION documents_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_language, COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_language, left(COALESCE(NEW.content, ''), 250000)), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER documents_search_vector_update
+    BEFORE INSERT OR UPDATE OF title, content, search_language ON documents
+    FOR EACH ROW EXECUTE FUNCTION documents_search_vector_update();
+
+UPDATE documents SET search_vector =
+    setweight(to_tsvector(search_language, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector(search_language, left(COALESCE(content, ''), 250000)), 'B');
+
+CREATE INDEX idx_documents_search_vector ON documents USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_documents_search_vector;
+DROP TRIGGER IF EXISTS documents_search_vector_update ON documents;
+DROP FUNCTION IF EXISTS documents_search_vector_update();
+
+ALTER TABLE documents
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS search_language;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- 00018_add_webhook_targets.sql
+-- Webhooks can post chat messages to Slack or Discord instead of the JSON
+-- event, and can follow a single document or all of an organization's
+-- documents instead of those their user owns.
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS format VARCHAR(10) NOT NULL DEFAULT 'json'
+    CHECK (format IN ('json', 'slack', 'discord'));
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS document_id INT REFERENCES documents(id) ON DELETE CASCADE;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS organization_id INT REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE webhooks ADD CONSTRAINT webhooks_single_scope CHECK (document_id IS NULL OR organization_id IS NULL);
+
+CREATE INDEX idx_webhooks_document ON webhooks(document_id) WHERE document_id IS NOT NULL;
+CREATE INDEX idx_webhooks_organization ON webhooks(organization_id) WHERE organization_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_webhooks_organization;
+DROP INDEX IF EXISTS idx_webhooks_document;
+ALTER TABLE webhooks DROP CONSTRAINT IF EXISTS webhooks_single_scope;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS document_id;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS format;
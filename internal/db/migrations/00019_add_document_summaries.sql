@@ -0,0 +1,17 @@
+-- +goose Up
+-- 00019_add_document_summaries.sql
+-- document_summaries caches generated summaries per document version.
+-- since_version is 0 for a summary of the whole document, and otherwise the
+-- version a "what changed" digest starts from.
+CREATE TABLE IF NOT EXISTS document_summaries(
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    since_version INT NOT NULL DEFAULT 0,
+    summary TEXT NOT NULL,
+    model TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(document_id, version, since_version)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS document_summaries;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- 00020_add_user_preferences.sql
+-- user_preferences holds per-user settings. Users without a row get the
+-- defaults. digest_sent_at is when the user's last activity digest was sent,
+-- and the start of the activity the next one covers.
+CREATE TABLE IF NOT EXISTS user_preferences(
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    digest VARCHAR(10) NOT NULL DEFAULT 'weekly' CHECK (digest IN ('daily', 'weekly', 'off')),
+    digest_sent_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_preferences;
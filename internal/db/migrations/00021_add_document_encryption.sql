@@ -0,0 +1,61 @@
+-- +goose Up
+-- 00021_add_document_encryption.sql
+-- Encrypted documents are end-to-end encrypted by their clients: content
+-- holds a ciphertext snapshot of the document as of snapshot_version, and
+-- edit events carry ciphertext the server relays without applying.
+-- user_public_keys holds each user's public key, and document_keys the
+-- document key wrapped (encrypted) for each member with that key, per key
+-- version so the key can be rotated when someone loses access. The server
+-- never sees a plaintext key.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS snapshot_version INT NOT NULL DEFAULT 0;
+-- spectators couldn't read an encrypted document
+ALTER TABLE documents ADD CONSTRAINT documents_encrypted_unpublished CHECK (NOT (encrypted AND published));
+
+CREATE TABLE IF NOT EXISTS user_public_keys(
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    public_key TEXT NOT NULL,
+    algorithm VARCHAR(50) NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS document_keys(
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_version INT NOT NULL CHECK (key_version > 0),
+    wrapped_key TEXT NOT NULL,
+    wrapped_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(document_id, user_id, key_version)
+);
+
+-- the ciphertext of encrypted documents isn't indexed, only their title
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION documents_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_language, COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_language,
+            CASE WHEN NEW.encrypted THEN '' ELSE left(COALESCE(NEW.content, ''), 250000) END), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION documents_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_language, COALESCE(NEW.title, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_language, left(COALESCE(NEW.content, ''), 250000)), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TABLE IF EXISTS document_keys;
+DROP TABLE IF EXISTS user_public_keys;
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_encrypted_unpublished;
+ALTER TABLE documents DROP COLUMN IF EXISTS snapshot_version;
+ALTER TABLE documents DROP COLUMN IF EXISTS encrypted;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- 00022_add_exports.sql
+-- exports are ZIP archives of all documents a user owns, built by a
+-- background job. format is the default for each document and formats maps
+-- document IDs to overrides. Completed archives are kept in object storage
+-- under object_key until expires_at, then deleted and marked expired.
+CREATE TABLE IF NOT EXISTS exports(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'failed', 'expired')),
+    format VARCHAR(10) NOT NULL,
+    formats JSONB NOT NULL DEFAULT '{}',
+    object_key TEXT,
+    size BIGINT,
+    document_count INT,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_exports_user ON exports(user_id, created_at DESC);
+CREATE INDEX idx_exports_expires ON exports(expires_at) WHERE status = 'completed';
+
+-- +goose Down
+DROP TABLE IF EXISTS exports;
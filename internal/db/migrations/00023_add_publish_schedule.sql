@@ -0,0 +1,17 @@
+-- +goose Up
+-- 00023_add_publish_schedule.sql
+-- publish_at and unpublish_at schedule a change to a document's published
+-- state. A periodic job applies schedules that are due and clears them.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS publish_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS unpublish_at TIMESTAMPTZ;
+ALTER TABLE documents ADD CONSTRAINT documents_encrypted_unscheduled CHECK (NOT (encrypted AND publish_at IS NOT NULL));
+
+CREATE INDEX IF NOT EXISTS idx_documents_publish_at ON documents(publish_at) WHERE publish_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_unpublish_at ON documents(unpublish_at) WHERE unpublish_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_documents_unpublish_at;
+DROP INDEX IF EXISTS idx_documents_publish_at;
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_encrypted_unscheduled;
+ALTER TABLE documents DROP COLUMN IF EXISTS unpublish_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS publish_at;
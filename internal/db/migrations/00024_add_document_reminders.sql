@@ -0,0 +1,35 @@
+-- +goose Up
+-- 00024_add_document_reminders.sql
+-- due_date is when a document is due, and remind_before how many minutes
+-- before then everyone with access to it is reminded. A user's row in
+-- document_reminders replaces that for them; a NULL remind_before turns
+-- their reminders for the document off. reminder_deliveries records the
+-- reminders sent for each due date, so moving the due date reminds everyone
+-- again.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS due_date TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS remind_before INT CHECK (remind_before > 0);
+
+CREATE INDEX IF NOT EXISTS idx_documents_due_date ON documents(due_date) WHERE due_date IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS document_reminders(
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    remind_before INT CHECK (remind_before > 0),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(document_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS reminder_deliveries(
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    due_date TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(document_id, user_id, due_date)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS reminder_deliveries;
+DROP TABLE IF EXISTS document_reminders;
+DROP INDEX IF EXISTS idx_documents_due_date;
+ALTER TABLE documents DROP COLUMN IF EXISTS remind_before;
+ALTER TABLE documents DROP COLUMN IF EXISTS due_date;
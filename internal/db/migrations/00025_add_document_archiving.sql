@@ -0,0 +1,17 @@
+-- +goose Up
+-- 00025_add_document_archiving.sql
+-- Documents untouched for a while are archived: archive_warned_at is when
+-- the owner was told the document would be archived, and archived_at when
+-- it was. unarchived_at counts as activity, so a document the owner brought
+-- back isn't archived again right away.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS archive_warned_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS unarchived_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_documents_archived_at ON documents(archived_at) WHERE archived_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_documents_archived_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS unarchived_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS archive_warned_at;
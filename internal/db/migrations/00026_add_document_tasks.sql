@@ -0,0 +1,26 @@
+-- +goose Up
+-- 00026_add_document_tasks.sql
+-- Task items on a document. anchor is the character offset in the
+-- document's content the task is attached to, or NULL for tasks listed
+-- after the anchored ones; clients move it as the content around it is
+-- edited.
+CREATE TABLE IF NOT EXISTS document_tasks(
+    id SERIAL PRIMARY KEY,
+    document_id INT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    assignee_id INT REFERENCES users(id) ON DELETE SET NULL,
+    done BOOLEAN NOT NULL DEFAULT false,
+    done_at TIMESTAMPTZ,
+    anchor INT CHECK (anchor >= 0),
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_tasks_document ON document_tasks(document_id, anchor);
+CREATE INDEX IF NOT EXISTS idx_document_tasks_assignee ON document_tasks(assignee_id) WHERE assignee_id IS NOT NULL AND NOT done;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_document_tasks_assignee;
+DROP INDEX IF EXISTS idx_document_tasks_document;
+DROP TABLE IF EXISTS document_tasks;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- 00027_add_notification_preferences.sql
+-- notification_preferences records the notifications a user turned on or
+-- off, by class (mentions, comments, shares, digests) and channel (email,
+-- in_app, webhook). Notifications without a row are on.
+CREATE TABLE IF NOT EXISTS notification_preferences(
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    class TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(user_id, class, channel)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS notification_preferences;
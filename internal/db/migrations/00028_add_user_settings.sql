@@ -0,0 +1,8 @@
+-- +goose Up
+-- 00028_add_user_settings.sql
+-- settings holds the client settings users sync across devices, such as
+-- their timezone and editor settings. The API validates them.
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE user_preferences DROP COLUMN IF EXISTS settings;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- 00029_add_api_usage_daily.sql
+-- api_usage_daily counts each user's API requests, WebSocket messages, and
+-- rate-limited requests per UTC day, for GET /api/me/api-usage.
+CREATE TABLE IF NOT EXISTS api_usage_daily(
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    metric VARCHAR(40) NOT NULL,
+    value BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY(user_id, day, metric)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_usage_daily_day ON api_usage_daily(day);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_api_usage_daily_day;
+DROP TABLE IF EXISTS api_usage_daily;
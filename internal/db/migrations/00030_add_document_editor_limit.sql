@@ -0,0 +1,8 @@
+-- +goose Up
+-- 00030_add_document_editor_limit.sql
+-- max_editors caps how many users can edit a document over WebSocket at
+-- once; NULL leaves it to the deployment's WS_MAX_EDITORS_PER_DOCUMENT.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS max_editors INT CHECK (max_editors > 0);
+
+-- +goose Down
+ALTER TABLE documents DROP COLUMN IF EXISTS max_editors;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- 00031_add_event_payload_search.sql
+-- Indexes for admins searching event payloads: idx_events_payload answers
+-- JSONB containment (payload @> '{"text": "..."}'), and
+-- idx_events_payload_text answers substring matches on the payload's JSON
+-- text through trigrams. idx_events_user_created scopes searches to a
+-- user's events the way idx_events_document_created does for a document.
+-- Indexes on the partitioned table are created on every partition,
+-- including those create_events_partitions adds later.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_events_payload ON events USING GIN (payload jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_events_payload_text ON events USING GIN ((payload::text) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_events_user_created ON events(user_id, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_events_user_created;
+DROP INDEX IF EXISTS idx_events_payload_text;
+DROP INDEX IF EXISTS idx_events_payload;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- 00032_add_user_identities.sql
+-- user_identities links users to the accounts they log in with at Google
+-- and GitHub, by the provider's stable account ID rather than the email,
+-- which users can change there. Users created by such a login have an
+-- empty password, which never matches a bcrypt hash, so they can only log
+-- in through their providers.
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_identities;
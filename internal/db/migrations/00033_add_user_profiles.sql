@@ -0,0 +1,12 @@
+-- +goose Up
+-- 00033_add_user_profiles.sql
+-- Profile fields users set through PATCH /api/me, shown to collaborators
+-- alongside their email. NULL means not set.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale TEXT;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- 00034_add_sessions.sql
+-- sessions are logins: tokens carry their session's id, and stop working
+-- once the row is deleted. Rows are deleted when users revoke them, and
+-- expired ones when their user logs in again.
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- 00035_add_user_search_indexes.sql
+-- GET /api/users/search matches the start of lowercased emails and display
+-- names; text_pattern_ops lets LIKE 'prefix%' use the indexes whatever the
+-- database collation.
+CREATE INDEX IF NOT EXISTS idx_users_email_lower_prefix ON users (lower(email) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_display_name_lower_prefix ON users (lower(display_name) text_pattern_ops);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_display_name_lower_prefix;
+DROP INDEX IF EXISTS idx_users_email_lower_prefix;
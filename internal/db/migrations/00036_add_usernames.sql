@@ -0,0 +1,14 @@
+-- +goose Up
+-- 00036_add_usernames.sql
+-- Usernames users can log in with and are shown to collaborators by.
+-- They are stored lowercased, so the unique index makes them unique
+-- ignoring case. NULL means not set. text_pattern_ops lets the prefix
+-- matches of GET /api/users/search use an index.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE INDEX IF NOT EXISTS idx_users_username_prefix ON users (username text_pattern_ops);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_username_prefix;
+DROP INDEX IF EXISTS idx_users_username;
+ALTER TABLE users DROP COLUMN IF EXISTS username;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- 00037_add_magic_links.sql
+-- magic_links are emailed one-time login links, stored by the SHA-256 of
+-- their token. used_at is set when a link is redeemed, after which it
+-- can't be redeemed again. Expired rows are deleted when their email asks
+-- for another link.
+CREATE TABLE IF NOT EXISTS magic_links (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_links_email ON magic_links(email, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS magic_links;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- 00038_add_last_login.sql
+-- When and from where each user last logged in, with a password, OAuth, or
+-- a magic link, so admins can find stale accounts. NULL means the user
+-- hasn't logged in since this was tracked.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_ip TEXT;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_ip;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
@@ -0,0 +1,47 @@
+-- +goose Up
+-- 00039_add_document_content_version.sql
+-- content_version is the edit version documents.content includes. Instances
+-- holding a document in memory only write back content newer than what is
+-- stored, and replay the edits after it when loading, so edits persisted
+-- by another instance but not yet flushed aren't lost.
+--
+-- edit_version is the latest version handed out to an edit of the
+-- document. events is partitioned by created_at, so a unique index on
+-- (document_id, version) can't span it; instead every edit claims its
+-- version through claim_edit_version in the same statement that inserts
+-- it, so two instances can't both store the same one. The loser reloads
+-- the document.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS content_version INT NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS edit_version INT NOT NULL DEFAULT 0;
+
+UPDATE documents d SET content_version = e.version, edit_version = e.version
+FROM (
+    SELECT document_id, MAX(version) AS version FROM events
+    WHERE event_type = 'edit' GROUP BY document_id
+) e
+WHERE e.document_id = d.id AND e.version IS NOT NULL;
+
+-- claim_edit_version advances the document's edit_version to claimed and
+-- returns it, or fails with 'edit version taken' when claimed doesn't
+-- directly follow it. The documents row stays locked until the claiming
+-- transaction ends, so concurrent claims of the same version are decided
+-- one after the other.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION claim_edit_version(doc_id INT, claimed INT) RETURNS INT AS $$
+BEGIN
+    UPDATE documents SET edit_version = claimed
+    WHERE id = doc_id AND edit_version = claimed - 1;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'edit version taken' USING ERRCODE = 'unique_violation';
+    END IF;
+    RETURN claimed;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION IF EXISTS claim_edit_version(INT, INT);
+
+ALTER TABLE documents DROP COLUMN IF EXISTS edit_version;
+
+ALTER TABLE documents DROP COLUMN IF EXISTS content_version;
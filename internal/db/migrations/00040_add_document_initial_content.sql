@@ -0,0 +1,15 @@
+-- +goose Up
+-- 00040_add_document_initial_content.sql
+-- initial_content is the content a document was created or imported with,
+-- before its first edit, so its history can be replayed to rebuild the
+-- content. NULL means it isn't known, for documents edited before this was
+-- recorded, and for encrypted documents, whose edits can't be replayed.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS initial_content TEXT;
+
+UPDATE documents d SET initial_content = COALESCE(d.content, '')
+WHERE NOT d.encrypted AND NOT EXISTS (
+    SELECT 1 FROM events e WHERE e.document_id = d.id AND e.event_type = 'edit'
+);
+
+-- +goose Down
+ALTER TABLE documents DROP COLUMN IF EXISTS initial_content;
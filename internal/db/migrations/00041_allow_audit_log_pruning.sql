@@ -0,0 +1,26 @@
+-- +goose Up
+-- 00041_allow_audit_log_pruning.sql
+-- Old audit_log entries can be pruned by the audit_log_maintainer role, so
+-- the log doesn't grow without bound. The application's connection still
+-- can't change or remove rows, and nobody can update them. The role isn't
+-- created here: operators create it, grant it DELETE and TRUNCATE on
+-- audit_log, and SET ROLE to it to prune.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('DELETE', 'TRUNCATE') AND current_user = 'audit_log_maintainer' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
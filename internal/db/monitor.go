@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// monitorFailures is how many pings in a row must fail before the database
+// counts as down, so a single slow ping doesn't flip the API to read-only.
+const monitorFailures = 3
+
+// Monitor pings the primary database every Interval and calls OnChange
+// when it stops answering and again when it is back.
+type Monitor struct {
+	Ping     func(ctx context.Context) error
+	Interval time.Duration
+	OnChange func(down bool)
+
+	failures int
+	down     atomic.Bool
+}
+
+// Down reports whether the database is considered unreachable. A nil
+// *Monitor never is.
+func (m *Monitor) Down() bool {
+	return m != nil && m.down.Load()
+}
+
+// Run pings the database until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.Interval)
+	defer cancel()
+
+	if err := m.Ping(ctx); err != nil {
+		m.failures++
+		if m.failures == monitorFailures {
+			slog.ErrorContext(ctx, "Database is unreachable", "error", err)
+			m.setDown(true)
+		}
+		return
+	}
+
+	m.failures = 0
+	if m.down.Load() {
+		slog.InfoContext(ctx, "Database is reachable again")
+		m.setDown(false)
+	}
+}
+
+func (m *Monitor) setDown(down bool) {
+	m.down.Store(down)
+	if m.OnChange != nil {
+		m.OnChange(down)
+	}
+}
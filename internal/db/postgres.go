@@ -1,33 +1,137 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
-func Connect(dsn string) *sql.DB {
-	db, err := sql.Open("pgx", dsn)
+// MigrationsDir holds the goose migrations, relative to the working
+// directory the server is started from.
+const MigrationsDir = "internal/db/migrations"
+
+// Timeouts are Postgres session timeouts set on every connection. Zero
+// leaves the server's default, and a timeout the DSN sets itself wins.
+type Timeouts struct {
+	// Statement cancels statements running longer than this.
+	Statement time.Duration
+	// Lock cancels statements waiting longer than this for a lock, so
+	// requests queued behind a long transaction fail instead of piling up.
+	Lock time.Duration
+	// IdleInTransaction closes sessions that leave a transaction open
+	// without running anything for longer than this, releasing its locks.
+	IdleInTransaction time.Duration
+}
+
+// Connect opens a pgx connection pool and checks that the database is
+// reachable. It returns the pool, for queries that need pgx itself such as
+// batches and COPY, and a database/sql handle that draws its connections
+// from the same pool. The pool is sized with the pool_max_conns and related
+// DSN parameters, and timeouts apply to every connection. Migrations are
+// applied separately with Migrate.
+//
+// Closing the database/sql handle doesn't close the pool.
+func Connect(dsn string, timeouts Timeouts) (*pgxpool.Pool, *sql.DB) {
+	config, err := newPoolConfig(dsn, timeouts)
 	if err != nil {
-		log.Fatal("failed to connect to db:", err)
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+
+	db := sql.OpenDB(annotatingConnector{stdlib.GetPoolConnector(pool)})
+	// idle connections are kept by the pool, where pgx users can reach them
+	db.SetMaxIdleConns(0)
 	if err := db.Ping(); err != nil {
-		log.Fatal("failed to ping db:", err)
+		slog.Error("Failed to ping database", "error", err)
+		os.Exit(1)
+	}
+
+	return pool, db
+}
+
+// newPoolConfig parses dsn and applies each of timeouts unless the DSN
+// already sets it.
+func newPoolConfig(dsn string, timeouts Timeouts) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeParams := config.ConnConfig.RuntimeParams
+	for param, timeout := range map[string]time.Duration{
+		"statement_timeout":                   timeouts.Statement,
+		"lock_timeout":                        timeouts.Lock,
+		"idle_in_transaction_session_timeout": timeouts.IdleInTransaction,
+	} {
+		if _, set := runtimeParams[param]; !set && timeout > 0 {
+			runtimeParams[param] = strconv.FormatInt(timeout.Milliseconds(), 10)
+		}
+	}
+	return config, nil
+}
+
+// Migrate applies all pending migrations in MigrationsDir.
+func Migrate(db *sql.DB) error {
+	if err := goose.SetDialect("postgres"); err != nil {
+		return err
 	}
+	if err := goose.Up(db, MigrationsDir); err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
 
-	err = goose.SetDialect("postgres")
+// CheckMigrations returns an error unless the database schema is at the
+// latest migration in MigrationsDir.
+func CheckMigrations(db *sql.DB) error {
+	current, err := SchemaVersion(context.Background(), db)
 	if err != nil {
-		return nil
+		return err
+	}
+	latest, err := LatestMigration()
+	if err != nil {
+		return err
 	}
 
-	// run migrations
-	if err = goose.Up(db, "internal/db/migrations"); err != nil {
-		log.Fatal("failed to run migrations", err)
+	if current < latest {
+		return fmt.Errorf("schema is at version %d, latest migration is %d", current, latest)
 	}
+	return nil
+}
 
-	log.Println("Migrations applied successfully")
+// SchemaVersion returns the version of the last migration applied to the
+// database.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	version, err := goose.GetDBVersionContext(ctx, db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+	return version, nil
+}
 
-	return db
+// LatestMigration returns the version of the newest migration in
+// MigrationsDir.
+func LatestMigration() (int64, error) {
+	migrations, err := goose.CollectMigrations(MigrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return 0, fmt.Errorf("failed to collect migrations: %v", err)
+	}
+	latest, err := migrations.Last()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find latest migration: %v", err)
+	}
+	return latest.Version, nil
 }
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewPoolConfig_StatementTimeout(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		timeout  time.Duration
+		expected string
+	}{
+		{"postgres://localhost/collab", 20 * time.Second, "20000"},
+		{"postgres://localhost/collab", 0, ""},
+		{"postgres://localhost/collab?statement_timeout=5000", 20 * time.Second, "5000"},
+	}
+
+	for _, tt := range tests {
+		config, err := newPoolConfig(tt.dsn, Timeouts{Statement: tt.timeout})
+		if err != nil {
+			t.Fatalf("%s: newPoolConfig failed: %v", tt.dsn, err)
+		}
+		if got := config.ConnConfig.RuntimeParams["statement_timeout"]; got != tt.expected {
+			t.Errorf("%s with %s: expected statement_timeout %q, got %q", tt.dsn, tt.timeout, tt.expected, got)
+		}
+	}
+
+	config, _ := newPoolConfig("postgres://localhost/collab?pool_max_conns=7", Timeouts{})
+	if config.MaxConns != 7 {
+		t.Errorf("Expected pool_max_conns to size the pool to 7, got %d", config.MaxConns)
+	}
+}
+
+func TestNewPoolConfig_LockAndIdleTimeouts(t *testing.T) {
+	timeouts := Timeouts{Lock: 5 * time.Second, IdleInTransaction: time.Minute}
+	config, err := newPoolConfig("postgres://localhost/collab?lock_timeout=1000", timeouts)
+	if err != nil {
+		t.Fatalf("newPoolConfig failed: %v", err)
+	}
+
+	params := config.ConnConfig.RuntimeParams
+	if params["lock_timeout"] != "1000" {
+		t.Errorf("Expected the DSN's lock_timeout to win, got %q", params["lock_timeout"])
+	}
+	if params["idle_in_transaction_session_timeout"] != "60000" {
+		t.Errorf("Expected idle_in_transaction_session_timeout %q, got %q", "60000", params["idle_in_transaction_session_timeout"])
+	}
+	if _, set := params["statement_timeout"]; set {
+		t.Errorf("Expected no statement_timeout, got %q", params["statement_timeout"])
+	}
+}
+
+func TestMonitor_ReadOnlyAfterRepeatedFailures(t *testing.T) {
+	var pingErr error
+	var changes []bool
+	m := &Monitor{
+		Ping:     func(context.Context) error { return pingErr },
+		Interval: time.Second,
+		OnChange: func(down bool) { changes = append(changes, down) },
+	}
+
+	pingErr = errors.New("connection refused")
+	for i := 0; i < monitorFailures-1; i++ {
+		m.check(context.Background())
+	}
+	if m.Down() {
+		t.Fatal("Expected the database to count as up before enough failures")
+	}
+
+	m.check(context.Background())
+	m.check(context.Background())
+	if !m.Down() {
+		t.Fatal("Expected the database to count as down")
+	}
+
+	pingErr = nil
+	m.check(context.Background())
+	if m.Down() {
+		t.Fatal("Expected the database to recover")
+	}
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("Expected down then up, got %v", changes)
+	}
+}
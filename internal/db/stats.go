@@ -0,0 +1,62 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PoolHandler struct {
+	Pool *pgxpool.Pool
+}
+
+// PoolStatsResponse reports the state of the database connection pool.
+// Counters are totals since the server started.
+type PoolStatsResponse struct {
+	MaxConns      int32 `json:"max_conns" example:"16"`
+	TotalConns    int32 `json:"total_conns" example:"9"`
+	IdleConns     int32 `json:"idle_conns" example:"6"`
+	AcquiredConns int32 `json:"acquired_conns" example:"3"`
+	// ConstructingConns are connections being established.
+	ConstructingConns int32 `json:"constructing_conns" example:"0"`
+
+	AcquireCount int64 `json:"acquire_count" example:"48210"`
+	// EmptyAcquireCount counts acquires that had to wait for a connection
+	// because none was idle. A growing share means the pool is too small.
+	EmptyAcquireCount    int64   `json:"empty_acquire_count" example:"37"`
+	CanceledAcquireCount int64   `json:"canceled_acquire_count" example:"0"`
+	AcquireDurationMs    float64 `json:"acquire_duration_ms" example:"412.5"`
+
+	NewConns                int64 `json:"new_conns" example:"14"`
+	MaxLifetimeDestroyCount int64 `json:"max_lifetime_destroy_count" example:"5"`
+	MaxIdleDestroyCount     int64 `json:"max_idle_destroy_count" example:"0"`
+}
+
+// GetPoolStats godoc
+// @Summary Database pool metrics
+// @Description Connection counts of the database pool, and how often and how long requests waited to acquire a connection.
+// @Tags health
+// @Produce json
+// @Success 200 {object} PoolStatsResponse
+// @Router /metrics/database [get]
+func (h *PoolHandler) GetPoolStats(c *gin.Context) {
+	stats := h.Pool.Stat()
+
+	c.JSON(http.StatusOK, PoolStatsResponse{
+		MaxConns:          stats.MaxConns(),
+		TotalConns:        stats.TotalConns(),
+		IdleConns:         stats.IdleConns(),
+		AcquiredConns:     stats.AcquiredConns(),
+		ConstructingConns: stats.ConstructingConns(),
+
+		AcquireCount:         stats.AcquireCount(),
+		EmptyAcquireCount:    stats.EmptyAcquireCount(),
+		CanceledAcquireCount: stats.CanceledAcquireCount(),
+		AcquireDurationMs:    float64(stats.AcquireDuration().Microseconds()) / 1000,
+
+		NewConns:                stats.NewConnsCount(),
+		MaxLifetimeDestroyCount: stats.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stats.MaxIdleDestroyCount(),
+	})
+}
@@ -0,0 +1,143 @@
+package digests
+
+import (
+	"context"
+	"live-collab-api/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func setupDigestTest(t *testing.T) (*DigestService, *fakeMailer, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mail := &fakeMailer{}
+	return &DigestService{DB: db, Mailer: mail, FrontendUrl: "https://collab.example.com"}, mail, mock
+}
+
+func TestSend_MailsActivitySinceLastDigest(t *testing.T) {
+	service, mail, mock := setupDigestTest(t)
+	lastSent := time.Now().Add(-24 * time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN user_preferences p ON p.user_id = u.id WHERE u.id = $1")).
+		WithArgs(1, DefaultFrequency).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "digest", "digest_sent_at"}).AddRow("alice@example.com", "daily", lastSent))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT d.id, d.title, COUNT(*), COUNT(DISTINCT e.user_id)")).
+		WithArgs(1, lastSent, maxDocuments).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "edits", "editors"}).AddRow(3, "Q3 plan", 12, 2))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM document_collaborators dc")).
+		WithArgs(1, lastSent, maxDocuments).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "permission"}).AddRow(7, "Roadmap", "view"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_preferences (user_id, digest_sent_at)")).
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sent, err := service.Send(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !sent || len(mail.sent) != 1 {
+		t.Fatalf("Expected one digest to be sent, got %d", len(mail.sent))
+	}
+
+	msg := mail.sent[0]
+	if msg.To != "alice@example.com" || msg.Subject != "Your daily digest: activity on 2 documents" {
+		t.Errorf("Unexpected recipient or subject: %q, %q", msg.To, msg.Subject)
+	}
+	for _, expected := range []string{"Q3 plan: 12 edits by 2 collaborators", "Roadmap (view)", "https://collab.example.com"} {
+		if !strings.Contains(msg.Body, expected) {
+			t.Errorf("Expected the digest to contain %q, got %q", expected, msg.Body)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSend_SkipsOptedOutAndQuietUsers(t *testing.T) {
+	service, mail, mock := setupDigestTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN user_preferences p ON p.user_id = u.id WHERE u.id = $1")).
+		WithArgs(1, DefaultFrequency).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "digest", "digest_sent_at"}).AddRow("alice@example.com", "off", nil))
+
+	if sent, err := service.Send(context.Background(), 1); err != nil || sent {
+		t.Errorf("Expected no digest for a user who opted out, got sent=%v err=%v", sent, err)
+	}
+
+	// without activity the period is still marked as covered
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN user_preferences p ON p.user_id = u.id WHERE u.id = $1")).
+		WithArgs(2, DefaultFrequency).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "digest", "digest_sent_at"}).AddRow("bob@example.com", "weekly", nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT d.id, d.title, COUNT(*), COUNT(DISTINCT e.user_id)")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "edits", "editors"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM document_collaborators dc")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "permission"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_preferences (user_id, digest_sent_at)")).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if sent, err := service.Send(context.Background(), 2); err != nil || sent {
+		t.Errorf("Expected no digest without activity, got sent=%v err=%v", sent, err)
+	}
+	if len(mail.sent) != 0 {
+		t.Errorf("Expected no email, got %d", len(mail.sent))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPreferences(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, _, mock := setupDigestTest(t)
+
+	r := gin.New()
+	r.PUT("/me/preferences", func(c *gin.Context) {
+		c.Set("userId", 1)
+	}, (&PreferencesHandler{DigestService: service}).SetPreferences)
+
+	put := func(body string) int {
+		req, _ := http.NewRequest("PUT", "/me/preferences", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_preferences (user_id, digest)")).
+		WithArgs(1, "off").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if code := put(`{"digest": "off"}`); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if code := put(`{"digest": "hourly"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown frequency, got %d", http.StatusBadRequest, code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
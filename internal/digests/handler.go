@@ -0,0 +1,72 @@
+package digests
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreferencesHandler struct {
+	DigestService *DigestService
+}
+
+// GetPreferences godoc
+// @Summary Get my preferences
+// @Description Get the authenticated user's preferences, including how often the activity digest is emailed.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} Preferences
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/preferences [get]
+func (h *PreferencesHandler) GetPreferences(c *gin.Context) {
+	prefs, err := h.DigestService.GetPreferences(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// SetPreferences godoc
+// @Summary Update my preferences
+// @Description Set how often the activity digest of edits and newly shared documents is emailed: "daily", "weekly" (the default), or "off".
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body Preferences true "Preferences"
+// @Success 200 {object} Preferences
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/preferences [put]
+func (h *PreferencesHandler) SetPreferences(c *gin.Context) {
+	var req Preferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ValidFrequency(req.Digest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Digest must be daily, weekly, or off"})
+		return
+	}
+
+	if err := h.DigestService.SetPreferences(c.Request.Context(), c.GetInt("userId"), req); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// swagger models for preferences
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Digest must be daily, weekly, or off"`
+}
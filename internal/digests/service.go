@@ -0,0 +1,294 @@
+package digests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/mailer"
+	"strings"
+	"time"
+)
+
+const (
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+	FrequencyOff    = "off"
+
+	// DefaultFrequency applies to users who haven't set a preference.
+	DefaultFrequency = FrequencyWeekly
+)
+
+// maxDocuments caps how many documents a digest lists in each section.
+const maxDocuments = 20
+
+// ErrNotConfigured is returned when digests are sent without a mailer.
+var ErrNotConfigured = errors.New("email is not configured")
+
+// ValidFrequency reports whether frequency is a digest setting.
+func ValidFrequency(frequency string) bool {
+	switch frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyOff:
+		return true
+	}
+	return false
+}
+
+type Preferences struct {
+	// Digest is how often the activity digest is emailed: "daily",
+	// "weekly", or "off".
+	Digest string `json:"digest" example:"weekly"`
+}
+
+// ChangedDocument is a document edited by other users during a digest's
+// period.
+type ChangedDocument struct {
+	DocumentId int
+	Title      string
+	Edits      int
+	Editors    int
+}
+
+// SharedDocument is a document the user was added to as a collaborator
+// during a digest's period.
+type SharedDocument struct {
+	DocumentId int
+	Title      string
+	Permission string
+}
+
+// Digest is the activity on a user's documents since Since.
+type Digest struct {
+	Since   time.Time
+	Changed []ChangedDocument
+	Shared  []SharedDocument
+}
+
+func (d *Digest) Empty() bool {
+	return len(d.Changed) == 0 && len(d.Shared) == 0
+}
+
+// DigestService keeps users' digest preferences and emails them summaries
+// of the activity on their documents.
+type DigestService struct {
+	DB *sql.DB
+	// Mailer sends digests. When nil digests aren't sent.
+	Mailer mailer.Mailer
+	// FrontendUrl is linked from digests for changing preferences.
+	FrontendUrl string
+}
+
+func (s *DigestService) GetPreferences(ctx context.Context, userId int) (*Preferences, error) {
+	prefs := &Preferences{Digest: DefaultFrequency}
+	err := s.DB.QueryRowContext(ctx, "SELECT digest FROM user_preferences WHERE user_id = $1", userId).Scan(&prefs.Digest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get preferences: %v", err)
+	}
+	return prefs, nil
+}
+
+func (s *DigestService) SetPreferences(ctx context.Context, userId int, prefs Preferences) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, digest)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET digest = EXCLUDED.digest, updated_at = now()
+	`, userId, prefs.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to set preferences: %v", err)
+	}
+	return nil
+}
+
+// DueUsers returns the users whose digest should be sent now: daily
+// digests once per UTC day and weekly ones once per week starting Monday,
+// for users who haven't received one yet in the current period.
+func (s *DigestService) DueUsers(ctx context.Context) ([]int, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT u.id
+		FROM users u
+		LEFT JOIN user_preferences p ON p.user_id = u.id
+		WHERE COALESCE(p.digest, $1) <> 'off'
+			AND NOT EXISTS (
+				SELECT 1 FROM notification_preferences np
+				WHERE np.user_id = u.id AND np.class = 'digests' AND np.channel = 'email' AND NOT np.enabled
+			)
+			AND (p.digest_sent_at IS NULL OR p.digest_sent_at < date_trunc(
+				CASE COALESCE(p.digest, $1) WHEN 'daily' THEN 'day' ELSE 'week' END,
+				now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC')
+		ORDER BY u.id
+	`, DefaultFrequency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users due a digest: %v", err)
+	}
+	defer rows.Close()
+
+	var userIds []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds, rows.Err()
+}
+
+// Send compiles a user's digest of the activity since their last one and
+// emails it, unless they opted out or nothing happened. It reports whether
+// an email was sent. Either way the period is marked as covered.
+func (s *DigestService) Send(ctx context.Context, userId int) (bool, error) {
+	if s.Mailer == nil {
+		return false, ErrNotConfigured
+	}
+
+	var email, frequency string
+	var sentAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT u.email,
+			CASE WHEN EXISTS (
+				SELECT 1 FROM notification_preferences np
+				WHERE np.user_id = u.id AND np.class = 'digests' AND np.channel = 'email' AND NOT np.enabled
+			) THEN 'off' ELSE COALESCE(p.digest, $2) END,
+			p.digest_sent_at
+		FROM users u
+		LEFT JOIN user_preferences p ON p.user_id = u.id
+		WHERE u.id = $1
+	`, userId, DefaultFrequency).Scan(&email, &frequency, &sentAt)
+	if errors.Is(err, sql.ErrNoRows) || frequency == FrequencyOff {
+		// the user was deleted or opted out after the digest was queued
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	now := time.Now().UTC()
+	since := sentAt.Time
+	if !sentAt.Valid {
+		since = now.Add(-period(frequency))
+	}
+
+	digest, err := s.Compile(ctx, userId, since)
+	if err != nil {
+		return false, err
+	}
+
+	sent := false
+	if !digest.Empty() {
+		msg := mailer.Message{
+			To:      email,
+			Subject: subject(frequency, digest),
+			Body:    render(frequency, digest, s.FrontendUrl),
+		}
+		if err := s.Mailer.Send(ctx, msg); err != nil {
+			return false, fmt.Errorf("failed to send digest: %v", err)
+		}
+		sent = true
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, digest_sent_at)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET digest_sent_at = EXCLUDED.digest_sent_at
+	`, userId, now)
+	if err != nil {
+		return sent, fmt.Errorf("failed to record digest: %v", err)
+	}
+	return sent, nil
+}
+
+// Compile collects the activity since a time on the documents a user can
+// access: edits made by other users, and documents newly shared with them.
+func (s *DigestService) Compile(ctx context.Context, userId int, since time.Time) (*Digest, error) {
+	digest := &Digest{Since: since}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT d.id, d.title, COUNT(*), COUNT(DISTINCT e.user_id)
+		FROM events e
+		JOIN documents d ON d.id = e.document_id
+		WHERE e.event_type = 'edit' AND e.created_at >= $2 AND e.user_id IS DISTINCT FROM $1
+			AND (d.owner_id = $1
+				OR EXISTS (SELECT 1 FROM document_collaborators dc WHERE dc.document_id = d.id AND dc.user_id = $1)
+				OR EXISTS (SELECT 1 FROM organization_members om WHERE om.organization_id = d.organization_id AND om.user_id = $1))
+		GROUP BY d.id, d.title
+		ORDER BY COUNT(*) DESC, d.id
+		LIMIT $3
+	`, userId, since, maxDocuments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed documents: %v", err)
+	}
+	for rows.Next() {
+		var doc ChangedDocument
+		if err := rows.Scan(&doc.DocumentId, &doc.Title, &doc.Edits, &doc.Editors); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan changed document: %v", err)
+		}
+		digest.Changed = append(digest.Changed, doc)
+	}
+	rows.Close()
+
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT d.id, d.title, dc.permission
+		FROM document_collaborators dc
+		JOIN documents d ON d.id = dc.document_id
+		WHERE dc.user_id = $1 AND dc.created_at >= $2
+		ORDER BY dc.created_at DESC
+		LIMIT $3
+	`, userId, since, maxDocuments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared documents: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc SharedDocument
+		if err := rows.Scan(&doc.DocumentId, &doc.Title, &doc.Permission); err != nil {
+			return nil, fmt.Errorf("failed to scan shared document: %v", err)
+		}
+		digest.Shared = append(digest.Shared, doc)
+	}
+	return digest, rows.Err()
+}
+
+func period(frequency string) time.Duration {
+	if frequency == FrequencyDaily {
+		return 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+func subject(frequency string, digest *Digest) string {
+	documents := plural(len(digest.Changed)+len(digest.Shared), "document")
+	if frequency == FrequencyDaily {
+		return "Your daily digest: activity on " + documents
+	}
+	return "Your weekly digest: activity on " + documents
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func render(frequency string, digest *Digest, frontendUrl string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Here is what happened on your documents since %s.\n", digest.Since.Format("Mon, 2 Jan 2006 15:04 MST"))
+
+	if len(digest.Changed) > 0 {
+		b.WriteString("\nDocuments changed:\n")
+		for _, doc := range digest.Changed {
+			fmt.Fprintf(&b, "- %s: %s by %s\n", doc.Title, plural(doc.Edits, "edit"), plural(doc.Editors, "collaborator"))
+		}
+	}
+
+	if len(digest.Shared) > 0 {
+		b.WriteString("\nShared with you:\n")
+		for _, doc := range digest.Shared {
+			fmt.Fprintf(&b, "- %s (%s)\n", doc.Title, doc.Permission)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nYou get this digest %s. To change how often or turn it off, update your preferences at %s.\n", frequency, frontendUrl)
+	return b.String()
+}
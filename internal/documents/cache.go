@@ -0,0 +1,132 @@
+package documents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache keeps documents and access-check results in Redis in front of
+// Postgres. Writes go to Postgres first and then invalidate the affected
+// entries, and entries expire after TTL in case an invalidation is lost.
+// Redis errors are logged and treated as misses. A nil Cache caches
+// nothing.
+type Cache struct {
+	Client *redis.Client
+	TTL    time.Duration
+}
+
+func documentKey(documentId int) string {
+	return fmt.Sprintf("cache:document:%d", documentId)
+}
+
+// accessKey holds a hash of user ID to access for a document, so every
+// user's entry can be dropped at once when collaborators change.
+func accessKey(documentId int) string {
+	return fmt.Sprintf("cache:access:%d", documentId)
+}
+
+// GetDocument returns the cached document, if any.
+func (c *Cache) GetDocument(ctx context.Context, documentId int) (*Document, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	data, err := c.Client.Get(ctx, documentKey(documentId)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "Failed to read cached document", "document_id", documentId, "error", err)
+		}
+		return nil, false
+	}
+
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		slog.WarnContext(ctx, "Failed to decode cached document", "document_id", documentId, "error", err)
+		return nil, false
+	}
+	return &doc, true
+}
+
+func (c *Cache) SetDocument(ctx context.Context, doc *Document) {
+	if c == nil {
+		return
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	if err := c.Client.Set(ctx, documentKey(doc.ID), data, c.TTL).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to cache document", "document_id", doc.ID, "error", err)
+	}
+}
+
+// SetContent updates the content of a cached document after an edit has
+// been written, so the next edit doesn't have to read it back from
+// Postgres. Documents that aren't cached are left alone.
+func (c *Cache) SetContent(ctx context.Context, documentId int, content string) {
+	doc, ok := c.GetDocument(ctx, documentId)
+	if !ok {
+		return
+	}
+	doc.Content = content
+	c.SetDocument(ctx, doc)
+}
+
+func (c *Cache) InvalidateDocument(ctx context.Context, documentId int) {
+	if c == nil {
+		return
+	}
+	if err := c.Client.Del(ctx, documentKey(documentId)).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to invalidate cached document", "document_id", documentId, "error", err)
+	}
+}
+
+// GetAccess returns the cached result of HasDocumentAccess, if any.
+func (c *Cache) GetAccess(ctx context.Context, userId, documentId int) (hasAccess bool, ok bool) {
+	if c == nil {
+		return false, false
+	}
+
+	value, err := c.Client.HGet(ctx, accessKey(documentId), strconv.Itoa(userId)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "Failed to read cached access", "document_id", documentId, "user_id", userId, "error", err)
+		}
+		return false, false
+	}
+
+	hasAccess, err = strconv.ParseBool(value)
+	return hasAccess, err == nil
+}
+
+func (c *Cache) SetAccess(ctx context.Context, userId, documentId int, hasAccess bool) {
+	if c == nil {
+		return
+	}
+
+	key := accessKey(documentId)
+	pipe := c.Client.TxPipeline()
+	pipe.HSet(ctx, key, strconv.Itoa(userId), strconv.FormatBool(hasAccess))
+	pipe.Expire(ctx, key, c.TTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to cache access", "document_id", documentId, "user_id", userId, "error", err)
+	}
+}
+
+// InvalidateAccess drops the cached access of every user to a document.
+func (c *Cache) InvalidateAccess(ctx context.Context, documentId int) {
+	if c == nil {
+		return
+	}
+	if err := c.Client.Del(ctx, accessKey(documentId)).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to invalidate cached access", "document_id", documentId, "error", err)
+	}
+}
@@ -2,6 +2,7 @@ package documents
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"live-collab-api/internal/auth"
@@ -9,9 +10,11 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
 func setupDocumentTest(t *testing.T) (*DocumentHandler, sqlmock.Sqlmock, *gin.Engine, *auth.AuthService) {
@@ -47,10 +50,10 @@ func TestCreateDocument_Success(t *testing.T) {
 	userID := 1
 	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
 
-	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO documents (title, owner_id, content, content_type, created_at)")).
-		WithArgs("My Test Document", userID, "").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-			AddRow(1, "My Test Document", "", "text/plain", userID, "2025-01-04T10:00:00Z"))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO documents (title, owner_id, content, content_type, organization_id, search_language, encrypted, initial_content, created_at)")).
+		WithArgs("My Test Document", userID, "", "text/plain", nil, "english", false, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(1, "My Test Document", "", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, nil))
 
 	r.POST("/documents", handler.CreateDocument)
 
@@ -136,10 +139,10 @@ func TestCreateDocument_WithContent(t *testing.T) {
 	createdAt := "2025-01-04T10:00:00Z"
 	expectedContent := "Initial content here"
 
-	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO documents (title, owner_id, content, content_type, created_at)")).
-		WithArgs("Document with Content", userID, expectedContent).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-			AddRow(1, "Document with Content", expectedContent, "text/plain", userID, createdAt))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO documents (title, owner_id, content, content_type, organization_id, search_language, encrypted, initial_content, created_at)")).
+		WithArgs("Document with Content", userID, expectedContent, "text/plain", nil, "english", false, expectedContent).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(1, "Document with Content", expectedContent, "text/plain", userID, createdAt, nil, false, nil))
 
 	r.POST("/documents", handler.CreateDocument)
 
@@ -172,10 +175,10 @@ func TestGetDocument_Success(t *testing.T) {
 		WithArgs(documentID, userID).
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, content_type, owner_id, created_at FROM documents WHERE id = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, content_type, owner_id, created_at, organization_id, encrypted, due_date FROM documents WHERE id = $1")).
 		WithArgs(documentID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-			AddRow(documentID, "Test Document", "Content here", "text/plain", userID, "2025-01-04T10:00:00Z"))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(documentID, "Test Document", "Content here", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, nil))
 
 	r.GET("/documents/:id", DocumentAccessMiddleware(authService, handler.DocumentService), handler.GetDocument)
 
@@ -289,10 +292,10 @@ func TestGetDocument_AsCollaborator(t *testing.T) {
 		WithArgs(documentID, userID).
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, content_type, owner_id, created_at FROM documents WHERE id = $1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, content_type, owner_id, created_at, organization_id, encrypted, due_date FROM documents WHERE id = $1")).
 		WithArgs(documentID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-			AddRow(documentID, "Shared Document", "Content", "text/plain", ownerID, "2025-01-04T10:00:00Z"))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(documentID, "Shared Document", "Content", "text/plain", ownerID, "2025-01-04T10:00:00Z", nil, false, nil))
 
 	r.GET("/documents/:id", DocumentAccessMiddleware(authService, handler.DocumentService), handler.GetDocument)
 
@@ -318,11 +321,11 @@ func TestGetUserDocuments_Success(t *testing.T) {
 	userID := 1
 	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-		AddRow(1, "Document 1", "Content 1", "text/plain", userID, "2025-01-04T10:00:00Z").
-		AddRow(2, "Document 2", "Content 2", "text/plain", userID, "2025-01-04T11:00:00Z")
+	rows := sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+		AddRow(1, "Document 1", "Content 1", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, nil).
+		AddRow(2, "Document 2", "Content 2", "text/plain", userID, "2025-01-04T11:00:00Z", nil, false, nil)
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at FROM documents d")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at, d.organization_id, d.encrypted, d.due_date FROM documents d")).
 		WithArgs(userID).
 		WillReturnRows(rows)
 
@@ -359,11 +362,11 @@ func TestGetUserDocuments_OwnedAndShared(t *testing.T) {
 	otherUserID := 2
 	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at"}).
-		AddRow(1, "My Document", "Content", "text/plain", userID, "2025-01-04T10:00:00Z").
-		AddRow(2, "Shared Document", "Content", "text/plain", otherUserID, "2025-01-04T11:00:00Z")
+	rows := sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+		AddRow(1, "My Document", "Content", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, nil).
+		AddRow(2, "Shared Document", "Content", "text/plain", otherUserID, "2025-01-04T11:00:00Z", nil, false, nil)
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at FROM documents d")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at, d.organization_id, d.encrypted, d.due_date FROM documents d")).
 		WithArgs(userID).
 		WillReturnRows(rows)
 
@@ -392,6 +395,102 @@ func TestGetUserDocuments_OwnedAndShared(t *testing.T) {
 	}
 }
 
+func TestGetUserDocuments_DueSoon(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+	dueDate := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE d.due_date BETWEEN now() AND now() + $2 * interval '1 second'")).
+		WithArgs(userID, 7*24*60*60).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(3, "Release notes", "", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, dueDate))
+
+	r.GET("/documents", handler.GetUserDocuments)
+
+	for query, expected := range map[string]int{"?due_within_days=7": http.StatusOK, "?due_within_days=-1": http.StatusBadRequest, "?due_within_days=366": http.StatusBadRequest} {
+		req, _ := http.NewRequest("GET", "/documents"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", query, expected, w.Code)
+		}
+		if expected != http.StatusOK {
+			continue
+		}
+
+		var response DocumentListResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Documents) != 1 || response.Documents[0].DueDate == nil || !response.Documents[0].DueDate.Equal(dueDate) {
+			t.Errorf("Expected one document due %v, got %+v", dueDate, response.Documents)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchDocuments_Success(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	rows := sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date", "ts_headline", "rank"}).
+		AddRow(2, "Release notes", "The release notes", "text/plain", userID, "2025-01-04T10:00:00Z", nil, false, nil, "The <b>release</b> notes", 0.6)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM documents d, websearch_to_tsquery($1::regconfig, $2) q")).
+		WithArgs("english", "release", userID, 5, 0).
+		WillReturnRows(rows)
+
+	r.GET("/documents/search", handler.SearchDocuments)
+
+	req, _ := http.NewRequest("GET", "/documents/search?q=release&limit=5", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Documents []SearchResult `json:"documents"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Documents) != 1 || response.Documents[0].ID != 2 || response.Documents[0].Snippet != "The <b>release</b> notes" {
+		t.Errorf("Expected document 2 with its snippet, got %+v", response.Documents)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchDocuments_MissingQuery(t *testing.T) {
+	handler, _, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	token, _ := auth.GenerateJWT(1, authService.JWTSecret)
+	r.GET("/documents/search", handler.SearchDocuments)
+
+	req, _ := http.NewRequest("GET", "/documents/search?q=%20", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
 func TestUpdateDocument_Success(t *testing.T) {
 	handler, mock, r, authService := setupDocumentTest(t)
 	defer handler.DocumentService.DB.Close()
@@ -650,3 +749,581 @@ func TestDeleteDocument_CascadeEvents(t *testing.T) {
 		t.Errorf("Unfulfilled expectations: %s", err)
 	}
 }
+
+type recordingNotifier struct {
+	added       map[int]string
+	changed     map[int]string
+	revoked     []int
+	unpublished []int
+}
+
+func (n *recordingNotifier) CollaboratorAdded(documentId, userId int, permission string) {
+	if n.added == nil {
+		n.added = make(map[int]string)
+	}
+	n.added[userId] = permission
+}
+
+func (n *recordingNotifier) PermissionChanged(documentId, userId int, permission string) {
+	if n.changed == nil {
+		n.changed = make(map[int]string)
+	}
+	n.changed[userId] = permission
+}
+
+func (n *recordingNotifier) CollaboratorRemoved(documentId, userId int) {
+	n.revoked = append(n.revoked, userId)
+}
+
+func (n *recordingNotifier) DocumentUnpublished(documentId int) {
+	n.unpublished = append(n.unpublished, documentId)
+}
+
+func TestRemoveCollaborator_RevokesLiveAccess(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+
+	userID := 1
+	collaboratorID := 2
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM document_collaborators")).
+		WithArgs(documentID, collaboratorID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.DELETE("/documents/:id/collaborators/:user_id", DocumentAccessMiddleware(authService, handler.DocumentService), handler.RemoveCollaborator)
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/documents/%d/collaborators/%d", documentID, collaboratorID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if len(notifier.revoked) != 1 || notifier.revoked[0] != collaboratorID {
+		t.Errorf("Expected access revoked for user %d, got %v", collaboratorID, notifier.revoked)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAddCollaborator_NotifiesPermissionChange(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+
+	userID := 1
+	collaboratorID := 2
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)")).
+		WithArgs(collaboratorID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WithArgs(documentID, collaboratorID).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("edit"))
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO document_collaborators (document_id, user_id, permission)")).
+		WithArgs(documentID, collaboratorID, "view").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	r.POST("/documents/:id/collaborators", DocumentAccessMiddleware(authService, handler.DocumentService), handler.AddCollaborator)
+
+	payload := []byte(`{"user_id": 2, "permission": "view"}`)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/documents/%d/collaborators", documentID), bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	if notifier.changed[collaboratorID] != "view" {
+		t.Errorf("Expected permission change to 'view' for user %d, got %v", collaboratorID, notifier.changed)
+	}
+
+	if len(notifier.added) != 0 {
+		t.Errorf("Expected no collaborator_added notification, got %v", notifier.added)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetReadReceipts_Success(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(10))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT dr.user_id, u.email, dr.version, dr.updated_at")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "version", "updated_at"}).
+			AddRow(2, "collaborator@example.com", 10, "2025-01-04T10:05:00Z").
+			AddRow(userID, "owner@example.com", 7, "2025-01-04T10:00:00Z"))
+
+	r.GET("/documents/:id/reads", DocumentAccessMiddleware(authService, handler.DocumentService), handler.GetReadReceipts)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/documents/%d/reads", documentID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response ReadReceiptListResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	if response.UnreadVersions != 3 || len(response.Receipts) != 2 {
+		t.Errorf("Expected 3 unread versions and 2 receipts, got %+v", response)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetChangesSinceLastVisit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	lastVisit := time.Date(2025, 1, 4, 10, 0, 0, 0, time.UTC)
+	lastEdit := lastVisit.Add(time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, updated_at FROM document_reads")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(42, lastVisit))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(47))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT e.user_id, u.email, COUNT(*), MAX(e.created_at)")).
+		WithArgs(1, 42, 1, maxChangeEditors).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "count", "max"}).
+			AddRow(2, "bob@example.com", 4, lastEdit).
+			AddRow(3, "carol@example.com", 1, lastVisit.Add(time.Minute)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT event_type, COUNT(*)")).
+		WithArgs(1, lastVisit, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"event_type", "count"}).AddRow("document_save", 2))
+
+	service := &DocumentService{DB: db}
+	changes, err := service.GetChangesSinceLastVisit(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatalf("Error getting changes: %v", err)
+	}
+
+	if changes.VersionsAdvanced != 5 || changes.LastVisit == nil || !changes.LastVisit.Equal(lastVisit) {
+		t.Errorf("Expected 5 versions since %v, got %+v", lastVisit, changes)
+	}
+	if len(changes.Editors) != 2 || changes.Editors[0].Email != "bob@example.com" || changes.Editors[0].Edits != 4 {
+		t.Errorf("Unexpected editors: %+v", changes.Editors)
+	}
+	if changes.Events["document_save"] != 2 {
+		t.Errorf("Expected 2 saves, got %v", changes.Events)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetChangesSinceLastVisit_NeverVisited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, updated_at FROM document_reads")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT e.user_id, u.email, COUNT(*), MAX(e.created_at)")).
+		WithArgs(1, 0, 1, maxChangeEditors).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "count", "max"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT event_type, COUNT(*)")).
+		WithArgs(1, nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"event_type", "count"}))
+
+	service := &DocumentService{DB: db}
+	changes, err := service.GetChangesSinceLastVisit(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatalf("Error getting changes: %v", err)
+	}
+
+	body, _ := json.Marshal(changes)
+	expected := `{"last_visit":null,"viewed_version":0,"current_version":3,"versions_advanced":3,"editors":[],"events":{}}`
+	if string(body) != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPublished_Success(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET published = $1 WHERE id = $2")).
+		WithArgs(true, documentID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.PUT("/documents/:id/publish", DocumentAccessMiddleware(authService, handler.DocumentService), handler.SetPublished)
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/documents/%d/publish", documentID), bytes.NewBufferString(`{"published": true}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPublished_UnpublishDisconnectsSpectators(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	notifier := &recordingNotifier{}
+	handler.Notifier = notifier
+
+	userID := 1
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET published = $1 WHERE id = $2")).
+		WithArgs(false, documentID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.PUT("/documents/:id/publish", DocumentAccessMiddleware(authService, handler.DocumentService), handler.SetPublished)
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/documents/%d/publish", documentID), bytes.NewBufferString(`{"published": false}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if len(notifier.unpublished) != 1 || notifier.unpublished[0] != documentID {
+		t.Errorf("Expected spectators of document %d disconnected, got %v", documentID, notifier.unpublished)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetEditorLimit(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET max_editors = $2 WHERE id = $1")).
+		WithArgs(documentID, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.PUT("/documents/:id/editor-limit", DocumentAccessMiddleware(authService, handler.DocumentService), handler.SetEditorLimit)
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/documents/%d/editor-limit", documentID), bytes.NewBufferString(`{"max_editors": 3}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		content, contentType, want string
+	}{
+		{"<h1>Plan</h1><script>track()</script><p>Ship &amp; tell</p>", "text/html", "Plan Ship & tell"},
+		{"# Plan\n\n**Ship** the [release](https://example.com) `today`", "text/markdown", "Plan Ship the release today"},
+		{"  several\n\nlines  ", "text/plain", "several lines"},
+	}
+	for _, tt := range tests {
+		if got := snippet(tt.content, tt.contentType); got != tt.want {
+			t.Errorf("snippet(%q, %q) = %q, want %q", tt.content, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestGetPublicUnfurl_Unpublished(t *testing.T) {
+	handler, mock, r, _ := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT d.id, d.title")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "encrypted", "published", "updated_at", "owner_id", "email"}).
+			AddRow(1, "Draft", "secret plans", "text/plain", false, false, time.Now(), 2, "owner@example.com"))
+
+	r.GET("/public/documents/:id/unfurl", handler.GetPublicUnfurl)
+
+	req, _ := http.NewRequest("GET", "/public/documents/1/unfurl", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUnarchive_NotOwner(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 2
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(documentID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+
+	r.POST("/documents/:id/unarchive", DocumentAccessMiddleware(authService, handler.DocumentService), handler.Unarchive)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/documents/%d/unarchive", documentID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPublishSchedule(t *testing.T) {
+	handler, mock, r, authService := setupDocumentTest(t)
+	defer handler.DocumentService.DB.Close()
+
+	userID := 1
+	documentID := 1
+	token, _ := auth.GenerateJWT(userID, authService.JWTSecret)
+	r.PUT("/documents/:id/publish-schedule", DocumentAccessMiddleware(authService, handler.DocumentService), handler.SetPublishSchedule)
+
+	publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	unpublishAt := publishAt.Add(24 * time.Hour)
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"past", fmt.Sprintf(`{"publish_at": %q}`, time.Now().Add(-time.Minute).Format(time.RFC3339)), http.StatusBadRequest},
+		{"unpublish first", fmt.Sprintf(`{"publish_at": %q, "unpublish_at": %q}`, unpublishAt.Format(time.RFC3339), publishAt.Format(time.RFC3339)), http.StatusBadRequest},
+		{"valid", fmt.Sprintf(`{"publish_at": %q, "unpublish_at": %q}`, publishAt.Format(time.RFC3339), unpublishAt.Format(time.RFC3339)), http.StatusOK},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+			WithArgs(documentID, userID).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+			WithArgs(documentID).
+			WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+		if tt.expected == http.StatusOK {
+			mock.ExpectQuery(regexp.QuoteMeta("UPDATE documents SET publish_at = $2, unpublish_at = $3")).
+				WithArgs(documentID, publishAt, unpublishAt).
+				WillReturnRows(sqlmock.NewRows([]string{"published", "publish_at", "unpublish_at"}).AddRow(false, publishAt, unpublishAt))
+		}
+
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/documents/%d/publish-schedule", documentID), bytes.NewBufferString(tt.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d. Body: %s", tt.name, tt.expected, w.Code, w.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunPublishSchedule_ReportsChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// document 2 was already published by hand
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE publish_at <= now()")).
+		WithArgs(true, maxScheduledChanges).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "published", "published"}).
+			AddRow(1, 5, true, false).
+			AddRow(2, 5, true, true))
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE unpublish_at <= now()")).
+		WithArgs(false, maxScheduledChanges).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "published", "published"}).
+			AddRow(3, 6, false, true))
+
+	service := &DocumentService{DB: db}
+	changes, err := service.RunPublishSchedule(context.Background())
+	if err != nil {
+		t.Fatalf("Error running publish schedule: %v", err)
+	}
+
+	expected := []ScheduledChange{{DocumentId: 1, OwnerId: 5, Published: true}, {DocumentId: 3, OwnerId: 6, Published: false}}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDocumentService_CacheUnavailable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// Nothing listens on port 1, so every cache call fails
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer client.Close()
+
+	service := &DocumentService{
+		DB:    db,
+		Cache: &Cache{Client: client, TTL: time.Minute},
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, content_type, owner_id, created_at")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(1, "Cached Document", "Hello", "text/plain", 1, "2025-01-04T10:00:00Z", nil, false, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	doc, err := service.GetDocument(context.Background(), 1)
+	if err != nil || doc.Title != "Cached Document" {
+		t.Errorf("Expected document to be read from the database, got %v, %v", doc, err)
+	}
+
+	hasAccess, err := service.HasDocumentAccess(context.Background(), 2, 1)
+	if err != nil || !hasAccess {
+		t.Errorf("Expected access to be read from the database, got %v, %v", hasAccess, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -1,9 +1,15 @@
 package documents
 
 import (
+	"context"
+	"errors"
 	"live-collab-api/internal/auth"
+	"live-collab-api/internal/validation"
+	"live-collab-api/internal/webhooks"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,19 +17,41 @@ import (
 type DocumentHandler struct {
 	DocumentService *DocumentService
 	AuthService     *auth.AuthService
+	// Notifier, when set, is told about collaborator changes and
+	// unpublishing so open WebSocket connections pick them up immediately.
+	Notifier AccessNotifier
+	// Webhooks, when set, delivers document and collaborator changes to
+	// the document owner's webhooks.
+	Webhooks *webhooks.Dispatcher
+}
+
+// AccessNotifier publishes collaborator changes to live connections.
+type AccessNotifier interface {
+	CollaboratorAdded(documentId, userId int, permission string)
+	// PermissionChanged also applies the new permission to the user's
+	// open connections.
+	PermissionChanged(documentId, userId int, permission string)
+	// CollaboratorRemoved also disconnects the user from the document.
+	CollaboratorRemoved(documentId, userId int)
+	// DocumentUnpublished disconnects the document's spectators.
+	DocumentUnpublished(documentId int)
 }
 
 // CreateDocument godoc
 // @Summary Create a new document
-// @Description Create a new document for collaborative editing. Optionally include initial content that will be tracked as the first edit event.
+// @Description Create a new document for collaborative editing. Optionally include initial content that will be tracked as the first edit event. With encrypted set the document is end-to-end encrypted: content is an opaque ciphertext snapshot and the server only relays encrypted edits. HTML content (content_type text/html) is sanitized: scripts, event handlers, and elements and attributes outside an allowlist are removed.
 // @Tags documents
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body CreateDocumentRequest true "Document creation data"
+// @Param Idempotency-Key header string false "Key identifying retries of this request; a retry gets the first response back"
 // @Success 201 {object} DocumentResponse "Document created successfully"
 // @Failure 400 {object} ErrorResponse "Invalid input data"
 // @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not a member of the organization"
+// @Failure 409 {object} ErrorResponse "A request with the same Idempotency-Key is still being processed"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key was already used for a different request"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/documents [post]
 func (dh *DocumentHandler) CreateDocument(c *gin.Context) {
@@ -35,17 +63,49 @@ func (dh *DocumentHandler) CreateDocument(c *gin.Context) {
 
 	var req CreateDocumentRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if req.OrganizationID != nil {
+		isMember, err := dh.DocumentService.IsOrganizationMember(c.Request.Context(), *req.OrganizationID, userID)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify organization membership"})
+			return
+		}
+		if !isMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+			return
+		}
+	}
+
+	if req.ContentType == "" {
+		req.ContentType = "text/plain"
+	}
+	if req.Encrypted && req.ContentType != "text/plain" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted documents can't have a content type, since the server can't sanitize them"})
 		return
 	}
 
-	document, err := dh.DocumentService.CreateDocument(req.Title, userID, req.Content)
+	var document *Document
+	if req.Encrypted {
+		document, err = dh.DocumentService.CreateEncryptedDocument(c.Request.Context(), req.Title, userID, req.Content, req.OrganizationID)
+	} else {
+		document, err = dh.DocumentService.CreateDocumentWithType(c.Request.Context(), req.Title, userID, req.Content, req.ContentType, req.OrganizationID)
+	}
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create document"})
 		return
 	}
 
+	dh.Webhooks.Dispatch(c.Request.Context(), userID, webhooks.EventDocumentCreated, gin.H{
+		"document_id": document.ID,
+		"title":       document.Title,
+		"user_id":     userID,
+	})
+
 	c.JSON(http.StatusCreated, document)
 }
 
@@ -66,7 +126,7 @@ func (dh *DocumentHandler) CreateDocument(c *gin.Context) {
 func (dh *DocumentHandler) GetDocument(c *gin.Context) {
 	documentId, _ := GetDocumentID(c)
 
-	document, err := dh.DocumentService.GetDocument(documentId)
+	document, err := dh.DocumentService.GetDocument(c.Request.Context(), documentId)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
@@ -77,11 +137,14 @@ func (dh *DocumentHandler) GetDocument(c *gin.Context) {
 
 // GetUserDocuments godoc
 // @Summary Get all user documents
-// @Description Retrieve all documents owned by the authenticated user, ordered by creation date (newest first)
+// @Description Retrieve all documents owned by the authenticated user, ordered by creation date (newest first). With due_within_days only documents due within that many days are listed, soonest first.
 // @Tags documents
 // @Produce json
 // @Security BearerAuth
+// @Param due_within_days query int false "Only list documents due within this many days (1-365)"
+// @Param archived query bool false "List archived documents instead"
 // @Success 200 {object} DocumentListResponse "List of user documents"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
 // @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/documents [get]
@@ -92,8 +155,21 @@ func (dh *DocumentHandler) GetUserDocuments(c *gin.Context) {
 		return
 	}
 
-	documents, err := dh.DocumentService.GetUserDocuments(userId)
+	var req DocumentListQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+
+	var documents []Document
+	if req.Archived {
+		documents, err = dh.DocumentService.GetArchivedDocuments(c.Request.Context(), userId)
+	} else if req.DueWithinDays > 0 {
+		documents, err = dh.DocumentService.GetDueDocuments(c.Request.Context(), userId, time.Duration(req.DueWithinDays)*24*time.Hour)
+	} else {
+		documents, err = dh.DocumentService.GetUserDocuments(c.Request.Context(), userId)
+	}
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get documents"})
 		return
 	}
@@ -101,6 +177,47 @@ func (dh *DocumentHandler) GetUserDocuments(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"documents": documents})
 }
 
+// SearchDocuments godoc
+// @Summary Search documents
+// @Description Full-text search over the titles and content of the documents the authenticated user can access, best matches first. The query supports quoted phrases, "or", and "-" to exclude words.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Search query" example("release notes")
+// @Param limit query int false "Number of results to return (1-100)" default(20)
+// @Param offset query int false "Number of results to skip (default 0)" default(0)
+// @Success 200 {object} SearchResponse "Matching documents"
+// @Failure 400 {object} ErrorResponse "Missing search query or invalid parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/search [get]
+func (dh *DocumentHandler) SearchDocuments(c *gin.Context) {
+	userId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req SearchQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+	query := strings.TrimSpace(req.Q)
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "q", Message: "is required"}}})
+		return
+	}
+
+	results, err := dh.DocumentService.SearchDocuments(c.Request.Context(), userId, query, req.Limit, req.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": results, "limit": req.Limit, "offset": req.Offset})
+}
+
 // UpdateDocument godoc
 // @Summary Update document title
 // @Description Update a document's title. User can only update documents they own. Content updates should be done via WebSocket for real-time collaboration.
@@ -121,16 +238,22 @@ func (dh *DocumentHandler) UpdateDocument(c *gin.Context) {
 	documentId, _ := GetDocumentID(c)
 	var req UpdateDocumentRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !validation.BindJSON(c, &req) {
 		return
 	}
 
-	if err := dh.DocumentService.UpdateDocumentTitle(documentId, req.Title); err != nil {
+	if err := dh.DocumentService.UpdateDocumentTitle(c.Request.Context(), documentId, req.Title); err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
 		return
 	}
 
+	dh.dispatchToOwner(c.Request.Context(), documentId, webhooks.EventDocumentUpdated, gin.H{
+		"document_id": documentId,
+		"title":       req.Title,
+		"user_id":     c.GetInt("userId"),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Document updated successfully"})
 }
 
@@ -151,14 +274,45 @@ func (dh *DocumentHandler) UpdateDocument(c *gin.Context) {
 func (dh *DocumentHandler) DeleteDocument(c *gin.Context) {
 	documentId, _ := GetDocumentID(c)
 
-	if err := dh.DocumentService.DeleteDocument(documentId); err != nil {
+	// The owner can't be looked up once the document is gone
+	ownerId := dh.webhookOwner(c.Request.Context(), documentId)
+
+	if err := dh.DocumentService.DeleteDocument(c.Request.Context(), documentId); err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
 		return
 	}
 
+	if ownerId != 0 {
+		dh.Webhooks.Dispatch(c.Request.Context(), ownerId, webhooks.EventDocumentDeleted, gin.H{
+			"document_id": documentId,
+			"user_id":     c.GetInt("userId"),
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
 }
 
+// webhookOwner returns the owner of the document, whose webhooks receive
+// its events, or 0 when webhooks are off or the owner can't be found.
+func (dh *DocumentHandler) webhookOwner(ctx context.Context, documentId int) int {
+	if dh.Webhooks == nil {
+		return 0
+	}
+	document, err := dh.DocumentService.GetDocument(ctx, documentId)
+	if err != nil {
+		return 0
+	}
+	return document.OwnerId
+}
+
+// dispatchToOwner delivers an event to the document owner's webhooks.
+func (dh *DocumentHandler) dispatchToOwner(ctx context.Context, documentId int, event string, data interface{}) {
+	if ownerId := dh.webhookOwner(ctx, documentId); ownerId != 0 {
+		dh.Webhooks.Dispatch(ctx, ownerId, event, data)
+	}
+}
+
 // GetDocumentEvents godoc
 // @Summary Get document edit events
 // @Description Retrieve edit events for a specific document with optional pagination. User can only access events for documents they own. Events are ordered by creation date (newest first).
@@ -177,14 +331,14 @@ func (dh *DocumentHandler) DeleteDocument(c *gin.Context) {
 func (dh *DocumentHandler) GetDocumentEvents(c *gin.Context) {
 	documentId, _ := GetDocumentID(c)
 
-	limitStr := c.DefaultQuery("limit", "100")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		limit = 100
+	var req EventsQuery
+	if !validation.BindQuery(c, &req) {
+		return
 	}
 
-	events, err := dh.DocumentService.GetDocumentEvents(documentId, limit)
+	events, err := dh.DocumentService.GetDocumentEvents(c.Request.Context(), documentId, req.Limit)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document events"})
 		return
 	}
@@ -201,11 +355,14 @@ func (dh *DocumentHandler) GetDocumentEvents(c *gin.Context) {
 // @Security BearerAuth
 // @Param id path int true "Document ID"
 // @Param request body AddCollaboratorRequest true "Collaborator data"
+// @Param Idempotency-Key header string false "Key identifying retries of this request; a retry gets the first response back"
 // @Success 201 {object} MessageResponse "Collaborator added successfully"
 // @Failure 400 {object} ErrorResponse "Invalid input data"
 // @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
 // @Failure 403 {object} ErrorResponse "Access denied - only owner can add collaborators"
 // @Failure 404 {object} ErrorResponse "Document or user not found"
+// @Failure 409 {object} ErrorResponse "A request with the same Idempotency-Key is still being processed"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key was already used for a different request"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/documents/{id}/collaborators [post]
 func (dh *DocumentHandler) AddCollaborator(c *gin.Context) {
@@ -217,8 +374,9 @@ func (dh *DocumentHandler) AddCollaborator(c *gin.Context) {
 
 	documentId, _ := GetDocumentID(c)
 
-	isOwner, err := dh.DocumentService.IsDocumentOwner(currentUserId, documentId)
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
 		return
 	}
@@ -229,14 +387,12 @@ func (dh *DocumentHandler) AddCollaborator(c *gin.Context) {
 	}
 
 	var req AddCollaboratorRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !validation.BindJSON(c, &req) {
 		return
 	}
 
 	// Check if user exists
-	var userExists bool
-	err = dh.DocumentService.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", req.UserID).Scan(&userExists)
+	userExists, err := dh.AuthService.UserExists(c.Request.Context(), req.UserID)
 	if err != nil || !userExists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -248,11 +404,36 @@ func (dh *DocumentHandler) AddCollaborator(c *gin.Context) {
 		return
 	}
 
-	if err := dh.DocumentService.AddCollaborator(documentId, req.UserID, req.Permission); err != nil {
+	previousPermission, err := dh.DocumentService.GetCollaboratorPermission(c.Request.Context(), documentId, req.UserID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add collaborator"})
+		return
+	}
+
+	if err := dh.DocumentService.AddCollaborator(c.Request.Context(), documentId, req.UserID, req.Permission); err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add collaborator"})
 		return
 	}
 
+	// Adding an existing collaborator changes their permission in place
+	if dh.Notifier != nil {
+		if previousPermission == "" {
+			dh.Notifier.CollaboratorAdded(documentId, req.UserID, req.Permission)
+		} else if previousPermission != req.Permission {
+			dh.Notifier.PermissionChanged(documentId, req.UserID, req.Permission)
+		}
+	}
+
+	if previousPermission != req.Permission {
+		dh.Webhooks.Dispatch(c.Request.Context(), currentUserId, webhooks.EventCollaboratorAdded, gin.H{
+			"document_id": documentId,
+			"user_id":     req.UserID,
+			"permission":  req.Permission,
+		})
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Collaborator added successfully"})
 }
 
@@ -280,8 +461,9 @@ func (dh *DocumentHandler) RemoveCollaborator(c *gin.Context) {
 
 	documentId, _ := GetDocumentID(c)
 
-	isOwner, err := dh.DocumentService.IsDocumentOwner(currentUserId, documentId)
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
 		return
 	}
@@ -298,11 +480,20 @@ func (dh *DocumentHandler) RemoveCollaborator(c *gin.Context) {
 		return
 	}
 
-	if err := dh.DocumentService.RemoveCollaborator(documentId, userId); err != nil {
+	if err := dh.DocumentService.RemoveCollaborator(c.Request.Context(), documentId, userId); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Collaborator not found"})
 		return
 	}
 
+	if dh.Notifier != nil {
+		dh.Notifier.CollaboratorRemoved(documentId, userId)
+	}
+
+	dh.Webhooks.Dispatch(c.Request.Context(), currentUserId, webhooks.EventCollaboratorRemoved, gin.H{
+		"document_id": documentId,
+		"user_id":     userId,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Collaborator removed successfully"})
 }
 
@@ -322,8 +513,9 @@ func (dh *DocumentHandler) RemoveCollaborator(c *gin.Context) {
 func (dh *DocumentHandler) GetCollaborators(c *gin.Context) {
 	documentId, _ := GetDocumentID(c)
 
-	collaborators, err := dh.DocumentService.GetCollaborators(documentId)
+	collaborators, err := dh.DocumentService.GetCollaborators(c.Request.Context(), documentId)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collaborators"})
 		return
 	}
@@ -335,25 +527,689 @@ func (dh *DocumentHandler) GetCollaborators(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"collaborators": collaborators})
 }
 
+// GetReadReceipts godoc
+// @Summary Get document read receipts
+// @Description Get the latest version each user has viewed, along with the document's current version and how many versions the caller has not seen yet.
+// @Tags collaboration
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} ReadReceiptListResponse "Read receipts"
+// @Failure 400 {object} ErrorResponse "Invalid document ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/reads [get]
+func (dh *DocumentHandler) GetReadReceipts(c *gin.Context) {
+	userId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	currentVersion, err := dh.DocumentService.GetCurrentVersion(c.Request.Context(), documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get read receipts"})
+		return
+	}
+
+	receipts, err := dh.DocumentService.GetReadReceipts(c.Request.Context(), documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get read receipts"})
+		return
+	}
+
+	if receipts == nil {
+		receipts = []ReadReceipt{}
+	}
+
+	viewedVersion := 0
+	for _, receipt := range receipts {
+		if receipt.UserID == userId {
+			viewedVersion = receipt.Version
+		}
+	}
+
+	unread := currentVersion - viewedVersion
+	if unread < 0 {
+		unread = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current_version": currentVersion,
+		"viewed_version":  viewedVersion,
+		"unread_versions": unread,
+		"receipts":        receipts,
+	})
+}
+
+// GetChangesSinceLastVisit godoc
+// @Summary What changed since my last visit
+// @Description Summarize what happened to a document since the version you last viewed: how many versions it advanced, which other users edited it, and how many other events they created by type. Views are recorded by WebSocket clients acknowledging versions, so last_visit is null for documents never opened.
+// @Tags collaboration
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Changes "Changes since the last visit"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/changes-since-last-visit [get]
+func (dh *DocumentHandler) GetChangesSinceLastVisit(c *gin.Context) {
+	userId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	changes, err := dh.DocumentService.GetChangesSinceLastVisit(c.Request.Context(), documentId, userId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get changes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// SetPublished godoc
+// @Summary Publish or unpublish a document
+// @Description Published documents can be watched read-only over WebSocket by anyone, without an account. Only the owner can change this, and encrypted documents can't be published.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body PublishDocumentRequest true "Published state"
+// @Success 200 {object} PublishDocumentRequest "Published state updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - only owner can publish"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/publish [put]
+func (dh *DocumentHandler) SetPublished(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
+		return
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can publish the document"})
+		return
+	}
+
+	var req PublishDocumentRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if err := dh.DocumentService.SetPublished(c.Request.Context(), documentId, *req.Published); err != nil {
+		if errors.Is(err, ErrEncrypted) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted documents can't be published"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+
+	if !*req.Published && dh.Notifier != nil {
+		dh.Notifier.DocumentUnpublished(documentId)
+	}
+
+	dh.Webhooks.Dispatch(c.Request.Context(), currentUserId, webhooks.EventDocumentPublished, gin.H{
+		"document_id": documentId,
+		"published":   *req.Published,
+		"user_id":     currentUserId,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"published": *req.Published})
+}
+
+// Unarchive godoc
+// @Summary Unarchive a document
+// @Description Bring an archived document back into document lists, or keep a document you were warned about from being archived. Documents are archived after a period without edits or reads. Only the owner can do this.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} MessageResponse "Document unarchived"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - only owner can unarchive"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/unarchive [post]
+func (dh *DocumentHandler) Unarchive(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
+		return
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can unarchive the document"})
+		return
+	}
+
+	if err := dh.DocumentService.Unarchive(c.Request.Context(), documentId); err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unarchive document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document unarchived"})
+}
+
+// GetUnfurl godoc
+// @Summary Get a document's link preview
+// @Description Get what a link preview of a document shows: its title, the start of its content as plain text, its owner, and when it was last updated. Encrypted documents have no description.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Unfurl "Link preview"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/unfurl [get]
+func (dh *DocumentHandler) GetUnfurl(c *gin.Context) {
+	documentId, _ := GetDocumentID(c)
+
+	unfurl, err := dh.DocumentService.GetUnfurl(c.Request.Context(), documentId)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get link preview"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=60")
+	c.JSON(http.StatusOK, unfurl)
+}
+
+// GetPublicUnfurl godoc
+// @Summary Get a published document's link preview
+// @Description Get the link preview of a published document without authenticating, for chat tools unfurling shared links. The owner is left out. Documents that aren't published are reported as not found.
+// @Tags documents
+// @Produce json
+// @Param id path int true "Document ID"
+// @Success 200 {object} Unfurl "Link preview"
+// @Failure 400 {object} ErrorResponse "Invalid document ID"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/public/documents/{id}/unfurl [get]
+func (dh *DocumentHandler) GetPublicUnfurl(c *gin.Context) {
+	documentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	unfurl, err := dh.DocumentService.GetUnfurl(c.Request.Context(), documentId)
+	if err != nil && !errors.Is(err, ErrDocumentNotFound) {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get link preview"})
+		return
+	}
+	// unpublished documents look the same as missing ones
+	if err != nil || !unfurl.Published {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	unfurl.Owner = nil
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, unfurl)
+}
+
+// GetEditorLimit godoc
+// @Summary Get a document's editor limit
+// @Description Get how many users can edit a document over WebSocket at once. Null means the deployment's limit applies, if it has one.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} EditorLimit "Editor limit"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/editor-limit [get]
+func (dh *DocumentHandler) GetEditorLimit(c *gin.Context) {
+	documentId, _ := GetDocumentID(c)
+
+	limit, err := dh.DocumentService.GetEditorLimit(c.Request.Context(), documentId)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get editor limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, limit)
+}
+
+// SetEditorLimit godoc
+// @Summary Set a document's editor limit
+// @Description Cap how many users can edit a document over WebSocket at once. Users who connect once the cap is reached join as viewers and are told why. The deployment's limit, if lower, still applies. Null clears the cap. Only the owner can do this, and owners can always edit.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body EditorLimit true "Editor limit"
+// @Success 200 {object} EditorLimit "Editor limit updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - only owner can set the editor limit"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/editor-limit [put]
+func (dh *DocumentHandler) SetEditorLimit(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
+		return
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can set the editor limit"})
+		return
+	}
+
+	var req EditorLimit
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if err := dh.DocumentService.SetEditorLimit(c.Request.Context(), documentId, req); err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update editor limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// GetDueDate godoc
+// @Summary Get a document's due date
+// @Description Get when a document is due and how many minutes before then everyone with access is reminded.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} DueDate "Due date"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/due-date [get]
+func (dh *DocumentHandler) GetDueDate(c *gin.Context) {
+	documentId, _ := GetDocumentID(c)
+
+	due, err := dh.DocumentService.GetDueDate(c.Request.Context(), documentId)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get due date"})
+		return
+	}
+
+	c.JSON(http.StatusOK, due)
+}
+
+// SetDueDate godoc
+// @Summary Set a document's due date
+// @Description Set when a document is due, and optionally remind everyone with access remind_before_minutes before then by email and over WebSocket. Users can replace the reminder for themselves with PUT /api/documents/{id}/reminder. Null clears either setting. Requires edit permission.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body DueDate true "Due date"
+// @Success 200 {object} DueDate "Due date updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/due-date [put]
+func (dh *DocumentHandler) SetDueDate(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	permission, err := dh.DocumentService.GetPermission(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify permission"})
+		return
+	}
+
+	if permission != "owner" && permission != "edit" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You need edit permission to set the due date"})
+		return
+	}
+
+	var req SetDueDateRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if req.RemindBeforeMinutes != nil && req.DueDate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reminder needs a due date"})
+		return
+	}
+
+	due := DueDate{DueDate: req.DueDate, RemindBeforeMinutes: req.RemindBeforeMinutes}
+	if err := dh.DocumentService.SetDueDate(c.Request.Context(), documentId, due); err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update due date"})
+		return
+	}
+
+	c.JSON(http.StatusOK, due)
+}
+
+// GetPublishSchedule godoc
+// @Summary Get a document's publish schedule
+// @Description Get whether a document is published and when it is next published or unpublished.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} PublishSchedule "Publish schedule"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/publish-schedule [get]
+func (dh *DocumentHandler) GetPublishSchedule(c *gin.Context) {
+	documentId, _ := GetDocumentID(c)
+
+	schedule, err := dh.DocumentService.GetPublishSchedule(c.Request.Context(), documentId)
+	if err != nil {
+		if errors.Is(err, ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get publish schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// SetPublishSchedule godoc
+// @Summary Schedule publishing a document
+// @Description Publish a document at publish_at and unpublish it at unpublish_at, for example to release notes or an announcement at a set time. Both are optional and replace the current schedule; null clears them. Webhooks following the document receive document.published when a scheduled change takes effect. Only the owner can change this, and encrypted documents can't be scheduled for publishing.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body PublishScheduleRequest true "Publish schedule"
+// @Success 200 {object} PublishSchedule "Publish schedule updated"
+// @Failure 400 {object} ErrorResponse "Times in the past, unpublish_at before publish_at, or an encrypted document"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - only owner can schedule publishing"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/publish-schedule [put]
+func (dh *DocumentHandler) SetPublishSchedule(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
+		return
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can schedule publishing"})
+		return
+	}
+
+	var req PublishScheduleRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	now := time.Now()
+	if (req.PublishAt != nil && !req.PublishAt.After(now)) || (req.UnpublishAt != nil && !req.UnpublishAt.After(now)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled times must be in the future"})
+		return
+	}
+	if req.PublishAt != nil && req.UnpublishAt != nil && !req.UnpublishAt.After(*req.PublishAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unpublish_at must be after publish_at"})
+		return
+	}
+
+	schedule, err := dh.DocumentService.SetPublishSchedule(c.Request.Context(), documentId, req.PublishAt, req.UnpublishAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEncrypted):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted documents can't be published"})
+		case errors.Is(err, ErrDocumentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update publish schedule"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// SetOrganization godoc
+// @Summary Move a document into an organization
+// @Description Share a document with every member of an organization, or pass a null organization_id to make it personal again. Only the owner can change this, and only into organizations they belong to.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body SetOrganizationRequest true "Organization"
+// @Success 200 {object} SetOrganizationRequest "Organization updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - only owner can move the document, and only into their organizations"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/organization [put]
+func (dh *DocumentHandler) SetOrganization(c *gin.Context) {
+	currentUserId, err := dh.AuthService.GetUserIDFromGinContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	documentId, _ := GetDocumentID(c)
+
+	isOwner, err := dh.DocumentService.IsDocumentOwner(c.Request.Context(), currentUserId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ownership"})
+		return
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can move the document"})
+		return
+	}
+
+	var req SetOrganizationRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if req.OrganizationID != nil {
+		isMember, err := dh.DocumentService.IsOrganizationMember(c.Request.Context(), *req.OrganizationID, currentUserId)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify organization membership"})
+			return
+		}
+		if !isMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+			return
+		}
+	}
+
+	if err := dh.DocumentService.SetOrganization(c.Request.Context(), documentId, req.OrganizationID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organization_id": req.OrganizationID})
+}
+
 // CreateDocumentRequest represents the request body for creating a document
 type CreateDocumentRequest struct {
-	Title   string `json:"title" binding:"required" example:"My Collaborative Document"`
-	Content string `json:"content" example:"Initial content for the document"`
+	Title   string `json:"title" binding:"required,max=200" example:"My Collaborative Document"`
+	Content string `json:"content" binding:"max=1000000" example:"Initial content for the document"`
+	// OrganizationID, when set, makes the document editable by every member
+	// of that organization. The creator must be a member.
+	OrganizationID *int `json:"organization_id" example:"1"`
+	// Encrypted creates an end-to-end encrypted document, with Content
+	// holding the initial ciphertext snapshot.
+	Encrypted bool `json:"encrypted" example:"false"`
+	// ContentType defaults to text/plain. HTML content is sanitized, and
+	// encrypted documents can only be text/plain.
+	ContentType string `json:"content_type" binding:"omitempty,oneof=text/plain text/markdown text/html" example:"text/markdown"`
+}
+
+// SetOrganizationRequest represents the request body for moving a document
+// into or out of an organization
+type SetOrganizationRequest struct {
+	OrganizationID *int `json:"organization_id" example:"1"`
+}
+
+// SearchQuery represents the query string of a document search
+type SearchQuery struct {
+	Q      string `form:"q" binding:"required,max=500"`
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"`
+	Offset int    `form:"offset,default=0" binding:"min=0"`
+}
+
+// EventsQuery represents the query string for listing document events
+type EventsQuery struct {
+	Limit int `form:"limit,default=100" binding:"min=1,max=1000"`
 }
 
 // UpdateDocumentRequest represents the request body for updating a document
 type UpdateDocumentRequest struct {
-	Title string `json:"title" binding:"required" example:"Updated Document Title"`
+	Title string `json:"title" binding:"required,max=200" example:"Updated Document Title"`
+}
+
+// PublishDocumentRequest represents the request body for publishing a document
+type PublishDocumentRequest struct {
+	Published *bool `json:"published" binding:"required" example:"true"`
+}
+
+// DocumentListQuery represents the query string for listing documents
+type DocumentListQuery struct {
+	DueWithinDays int  `form:"due_within_days" binding:"omitempty,min=1,max=365"`
+	Archived      bool `form:"archived"`
+}
+
+// SetDueDateRequest represents the request body for setting a due date
+type SetDueDateRequest struct {
+	DueDate             *time.Time `json:"due_date" example:"2025-10-01T17:00:00Z"`
+	RemindBeforeMinutes *int       `json:"remind_before_minutes" binding:"omitempty,min=1,max=43200" example:"1440"`
+}
+
+// PublishScheduleRequest represents the request body for scheduling
+// publishing a document
+type PublishScheduleRequest struct {
+	PublishAt   *time.Time `json:"publish_at" example:"2025-10-01T09:00:00Z"`
+	UnpublishAt *time.Time `json:"unpublish_at" example:"2025-10-08T09:00:00Z"`
 }
 
 // DocumentResponse represents a document in API responses
 type DocumentResponse struct {
-	ID          int    `json:"id" example:"1"`
-	Title       string `json:"title" example:"My Collaborative Document"`
-	Content     string `json:"content" example:"Document content here"`
-	ContentType string `json:"content_type" example:"text/plain"`
-	OwnerID     int    `json:"owner_id" example:"1"`
-	CreatedAt   string `json:"created_at" example:"2025-09-19T10:30:00Z"`
+	ID             int        `json:"id" example:"1"`
+	Title          string     `json:"title" example:"My Collaborative Document"`
+	Content        string     `json:"content" example:"Document content here"`
+	ContentType    string     `json:"content_type" example:"text/plain"`
+	OwnerID        int        `json:"owner_id" example:"1"`
+	CreatedAt      string     `json:"created_at" example:"2025-09-19T10:30:00Z"`
+	OrganizationID *int       `json:"organization_id,omitempty" example:"1"`
+	Encrypted      bool       `json:"encrypted,omitempty" example:"false"`
+	DueDate        *time.Time `json:"due_date,omitempty" example:"2025-10-01T17:00:00Z"`
 }
 
 // DocumentListResponse represents a list of documents
@@ -361,6 +1217,20 @@ type DocumentListResponse struct {
 	Documents []DocumentResponse `json:"documents"`
 }
 
+// SearchResultResponse represents a document matching a search
+type SearchResultResponse struct {
+	DocumentResponse
+	Snippet string  `json:"snippet" example:"the <b>release</b> <b>notes</b> for version 2"`
+	Rank    float64 `json:"rank" example:"0.6"`
+}
+
+// SearchResponse represents a page of search results
+type SearchResponse struct {
+	Documents []SearchResultResponse `json:"documents"`
+	Limit     int                    `json:"limit" example:"20"`
+	Offset    int                    `json:"offset" example:"0"`
+}
+
 // EventResponse represents a document event in API responses
 type EventResponse struct {
 	ID         int                    `json:"id" example:"1"`
@@ -386,6 +1256,8 @@ type MessageResponse struct {
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error string `json:"error" example:"Error message describing what went wrong"`
+	// Fields lists the invalid fields of a rejected request.
+	Fields []validation.FieldError `json:"fields,omitempty"`
 }
 
 type AddCollaboratorRequest struct {
@@ -394,14 +1266,31 @@ type AddCollaboratorRequest struct {
 }
 
 type CollaboratorResponse struct {
-	ID         int    `json:"id" example:"1"`
-	DocumentID int    `json:"document_id" example:"1"`
-	UserID     int    `json:"user_id" example:"2"`
-	Email      string `json:"email" example:"collaborator@example.com"`
-	Permission string `json:"permission" example:"edit"`
-	CreatedAt  string `json:"created_at" example:"2025-09-19T10:30:00Z"`
+	ID          int    `json:"id" example:"1"`
+	DocumentID  int    `json:"document_id" example:"1"`
+	UserID      int    `json:"user_id" example:"2"`
+	Email       string `json:"email" example:"collaborator@example.com"`
+	Username    string `json:"username,omitempty" example:"bob"`
+	DisplayName string `json:"display_name,omitempty" example:"Bob Jones"`
+	AvatarURL   string `json:"avatar_url,omitempty" example:"https://example.com/avatars/bob.png"`
+	Permission  string `json:"permission" example:"edit"`
+	CreatedAt   string `json:"created_at" example:"2025-09-19T10:30:00Z"`
 }
 
 type CollaboratorListResponse struct {
 	Collaborators []CollaboratorResponse `json:"collaborators"`
 }
+
+type ReadReceiptResponse struct {
+	UserID    int    `json:"user_id" example:"2"`
+	Email     string `json:"email" example:"collaborator@example.com"`
+	Version   int    `json:"version" example:"42"`
+	UpdatedAt string `json:"updated_at" example:"2025-09-19T10:30:00Z"`
+}
+
+type ReadReceiptListResponse struct {
+	CurrentVersion int                   `json:"current_version" example:"45"`
+	ViewedVersion  int                   `json:"viewed_version" example:"42"`
+	UnreadVersions int                   `json:"unread_versions" example:"3"`
+	Receipts       []ReadReceiptResponse `json:"receipts"`
+}
@@ -2,6 +2,7 @@ package documents
 
 import (
 	"live-collab-api/internal/auth"
+	"live-collab-api/internal/logging"
 	"net/http"
 	"strconv"
 
@@ -29,8 +30,9 @@ func DocumentAccessMiddleware(authService *auth.AuthService, docService *Documen
 			return
 		}
 
-		hasAccess, err := docService.HasDocumentAccess(userId, documentId)
+		hasAccess, err := docService.HasDocumentAccess(c.Request.Context(), userId, documentId)
 		if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check document access"})
 			c.Abort()
 			return
@@ -44,6 +46,7 @@ func DocumentAccessMiddleware(authService *auth.AuthService, docService *Documen
 
 		c.Set("userId", userId)
 		c.Set("documentId", documentId)
+		logging.AddToRequest(c, "document_id", documentId)
 
 		c.Next()
 	}
@@ -1,14 +1,38 @@
 package documents
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"live-collab-api/internal/sanitize"
+	"time"
 )
 
+// ErrDocumentNotFound is returned when a document doesn't exist.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrEncrypted is returned for operations that need to read the content of
+// an end-to-end encrypted document.
+var ErrEncrypted = errors.New("the document is end-to-end encrypted")
+
+// documentColumns are the columns scanned by scanDocument.
+const documentColumns = "id, title, content, content_type, owner_id, created_at, organization_id, encrypted, due_date"
+
+// defaultSearchLanguage is the text search configuration used when
+// DocumentService.SearchLanguage isn't set.
+const defaultSearchLanguage = "english"
+
 type DocumentService struct {
 	DB *sql.DB
+	// Cache serves GetDocument and HasDocumentAccess from Redis. When nil
+	// every call reads from Postgres.
+	Cache *Cache
+	// SearchLanguage is the Postgres text search configuration, such as
+	// "english", new documents are indexed with and search queries are
+	// parsed with. Defaults to defaultSearchLanguage.
+	SearchLanguage string
 }
 
 type Document struct {
@@ -18,6 +42,15 @@ type Document struct {
 	ContentType string `json:"content_type"`
 	OwnerId     int    `json:"owner_id"`
 	CreatedAt   string `json:"created_at"`
+	// OrganizationId is set for documents owned by an organization, whose
+	// members can all edit them.
+	OrganizationId *int `json:"organization_id,omitempty"`
+	// Encrypted documents are end-to-end encrypted: Content is a
+	// ciphertext snapshot the server can't read, and edits carry
+	// ciphertext too.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// DueDate is when the document is due, if it has a due date.
+	DueDate *time.Time `json:"due_date,omitempty"`
 }
 
 type Event struct {
@@ -29,48 +62,106 @@ type Event struct {
 	CreatedAt  string                 `json:"created_at"`
 }
 
-type Collaborator struct {
-	ID         int    `json:"id"`
-	DocumentID int    `json:"document_id"`
-	UserID     int    `json:"user_id"`
-	Email      string `json:"email"`
-	Permission string `json:"permission"`
-	CreatedAt  string `json:"created_at"`
+// SearchResult is a document matching a search, with an excerpt of its
+// content highlighting the matches.
+type SearchResult struct {
+	Document
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
 }
 
-func (ds *DocumentService) CreateDocument(title string, ownerId int, content string) (*Document, error) {
+type ReadReceipt struct {
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Version   int    `json:"version"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// scanDocument reads a row selected with documentColumns.
+func scanDocument(row interface{ Scan(dest ...any) error }) (*Document, error) {
 	var doc Document
-	err := ds.DB.QueryRow(`
-		INSERT INTO documents (title, owner_id, content, content_type, created_at)
-		VALUES ($1, $2, $3, 'text/plain', now())
-		RETURNING id, title, content, content_type, owner_id, created_at
-	`, title, ownerId, content).Scan(&doc.ID, &doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerId, &doc.CreatedAt)
+	if err := row.Scan(&doc.ID, &doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerId, &doc.CreatedAt, &doc.OrganizationId, &doc.Encrypted, &doc.DueDate); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+type Collaborator struct {
+	ID          int    `json:"id"`
+	DocumentID  int    `json:"document_id"`
+	UserID      int    `json:"user_id"`
+	Email       string `json:"email"`
+	Username    string `json:"username,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Permission  string `json:"permission"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// CreateDocument creates a document owned by ownerId and, when
+// organizationId isn't nil, by that organization.
+func (ds *DocumentService) CreateDocument(ctx context.Context, title string, ownerId int, content string, organizationId *int) (*Document, error) {
+	return ds.createDocument(ctx, title, ownerId, content, "text/plain", organizationId, false)
+}
+
+// CreateDocumentWithType is like CreateDocument for content of another
+// type, such as text/markdown or text/html. HTML content is sanitized.
+func (ds *DocumentService) CreateDocumentWithType(ctx context.Context, title string, ownerId int, content, contentType string, organizationId *int) (*Document, error) {
+	if sanitize.IsHTML(contentType) {
+		content = sanitize.HTML(content)
+	}
+	return ds.createDocument(ctx, title, ownerId, content, contentType, organizationId, false)
+}
+
+// CreateEncryptedDocument creates an end-to-end encrypted document whose
+// content is a ciphertext snapshot. A document can't be switched between
+// encrypted and plaintext later.
+func (ds *DocumentService) CreateEncryptedDocument(ctx context.Context, title string, ownerId int, ciphertext string, organizationId *int) (*Document, error) {
+	return ds.createDocument(ctx, title, ownerId, ciphertext, "text/plain", organizationId, true)
+}
+
+func (ds *DocumentService) createDocument(ctx context.Context, title string, ownerId int, content, contentType string, organizationId *int, encrypted bool) (*Document, error) {
+	// the content edits start from is kept, so the history can be replayed
+	var initialContent *string
+	if !encrypted {
+		initialContent = &content
+	}
+
+	doc, err := scanDocument(ds.DB.QueryRowContext(ctx, `
+		INSERT INTO documents (title, owner_id, content, content_type, organization_id, search_language, encrypted, initial_content, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+		RETURNING `+documentColumns, title, ownerId, content, contentType, organizationId, ds.searchLanguage(), encrypted, initialContent))
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating document: %v", err)
 	}
-	return &doc, nil
+
+	// Drop any denial cached while the ID didn't exist yet
+	ds.Cache.InvalidateAccess(ctx, doc.ID)
+	return doc, nil
 }
 
-func (ds *DocumentService) GetDocument(documentId int) (*Document, error) {
-	var doc Document
-	err := ds.DB.QueryRow(`
-		SELECT id, title, content, content_type, owner_id, created_at
-		FROM documents WHERE id = $1`, documentId).Scan(&doc.ID, &doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerId, &doc.CreatedAt)
+func (ds *DocumentService) GetDocument(ctx context.Context, documentId int) (*Document, error) {
+	if doc, ok := ds.Cache.GetDocument(ctx, documentId); ok {
+		return doc, nil
+	}
 
+	doc, err := scanDocument(ds.DB.QueryRowContext(ctx, "SELECT "+documentColumns+" FROM documents WHERE id = $1", documentId))
 	if err != nil {
 		return nil, fmt.Errorf("error getting document: %v", err)
 	}
 
-	return &doc, nil
+	ds.Cache.SetDocument(ctx, doc)
+	return doc, nil
 }
 
-func (ds *DocumentService) GetUserDocuments(userId int) ([]Document, error) {
-	rows, err := ds.DB.Query(`
-		SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at
+func (ds *DocumentService) GetUserDocuments(ctx context.Context, userId int) ([]Document, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT DISTINCT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at, d.organization_id, d.encrypted, d.due_date
 		FROM documents d
 		LEFT JOIN document_collaborators dc ON d.id = dc.document_id
-		WHERE d.owner_id = $1 OR dc.user_id = $1
+		LEFT JOIN organization_members om ON d.organization_id = om.organization_id
+		WHERE (d.owner_id = $1 OR dc.user_id = $1 OR om.user_id = $1) AND d.archived_at IS NULL
 		ORDER BY d.created_at DESC`, userId)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user documents: %v", err)
@@ -79,17 +170,108 @@ func (ds *DocumentService) GetUserDocuments(userId int) ([]Document, error) {
 
 	var documents []Document
 	for rows.Next() {
-		var doc Document
-		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerId, &doc.CreatedAt); err != nil {
+		doc, err := scanDocument(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan document: %v", err)
 		}
-		documents = append(documents, doc)
+		documents = append(documents, *doc)
 	}
 	return documents, nil
 }
 
-func (ds *DocumentService) UpdateDocumentTitle(documentId int, title string) error {
-	result, err := ds.DB.Exec("UPDATE documents SET title = $1 WHERE id = $2", title, documentId)
+// GetDueDocuments returns the documents userId can access that are due
+// between now and within from now, soonest first.
+func (ds *DocumentService) GetDueDocuments(ctx context.Context, userId int, within time.Duration) ([]Document, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT `+documentColumns+` FROM documents d
+		WHERE d.due_date BETWEEN now() AND now() + $2 * interval '1 second' AND d.archived_at IS NULL
+			AND (d.owner_id = $1
+				OR EXISTS (SELECT 1 FROM document_collaborators dc WHERE dc.document_id = d.id AND dc.user_id = $1)
+				OR EXISTS (SELECT 1 FROM organization_members om WHERE om.organization_id = d.organization_id AND om.user_id = $1))
+		ORDER BY d.due_date, d.id`, userId, int(within.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("error getting due documents: %v", err)
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		documents = append(documents, *doc)
+	}
+	return documents, rows.Err()
+}
+
+// GetArchivedDocuments returns the archived documents userId can access,
+// most recently archived first.
+func (ds *DocumentService) GetArchivedDocuments(ctx context.Context, userId int) ([]Document, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT `+documentColumns+` FROM documents d
+		WHERE d.archived_at IS NOT NULL
+			AND (d.owner_id = $1
+				OR EXISTS (SELECT 1 FROM document_collaborators dc WHERE dc.document_id = d.id AND dc.user_id = $1)
+				OR EXISTS (SELECT 1 FROM organization_members om WHERE om.organization_id = d.organization_id AND om.user_id = $1))
+		ORDER BY d.archived_at DESC, d.id`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting archived documents: %v", err)
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		documents = append(documents, *doc)
+	}
+	return documents, rows.Err()
+}
+
+// SearchDocuments returns the documents userId can access whose title or
+// content match query, best matches first. query takes the syntax of web
+// search engines: quoted phrases, "or", and "-" to exclude words.
+func (ds *DocumentService) SearchDocuments(ctx context.Context, userId int, query string, limit, offset int) ([]SearchResult, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT d.id, d.title, d.content, d.content_type, d.owner_id, d.created_at, d.organization_id, d.encrypted, d.due_date,
+			CASE WHEN d.encrypted THEN '' ELSE ts_headline(d.search_language, d.content, q, 'MaxFragments=2, MaxWords=20, MinWords=5') END,
+			ts_rank(d.search_vector, q) AS rank
+		FROM documents d, websearch_to_tsquery($1::regconfig, $2) q
+		WHERE d.search_vector @@ q
+			AND (d.owner_id = $3
+				OR EXISTS (SELECT 1 FROM document_collaborators dc WHERE dc.document_id = d.id AND dc.user_id = $3)
+				OR EXISTS (SELECT 1 FROM organization_members om WHERE om.organization_id = d.organization_id AND om.user_id = $3))
+		ORDER BY rank DESC, d.id DESC
+		LIMIT $4 OFFSET $5`, ds.searchLanguage(), query, userId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error searching documents: %v", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		doc := &result.Document
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Content, &doc.ContentType, &doc.OwnerId, &doc.CreatedAt, &doc.OrganizationId, &doc.Encrypted, &doc.DueDate, &result.Snippet, &result.Rank); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %v", err)
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (ds *DocumentService) searchLanguage() string {
+	if ds.SearchLanguage == "" {
+		return defaultSearchLanguage
+	}
+	return ds.SearchLanguage
+}
+
+func (ds *DocumentService) UpdateDocumentTitle(ctx context.Context, documentId int, title string) error {
+	result, err := ds.DB.ExecContext(ctx, "UPDATE documents SET title = $1 WHERE id = $2", title, documentId)
 	if err != nil {
 		return fmt.Errorf("error updating document: %v", err)
 	}
@@ -103,27 +285,28 @@ func (ds *DocumentService) UpdateDocumentTitle(documentId int, title string) err
 		return fmt.Errorf("no document with id %v has been updated", documentId)
 	}
 
+	ds.Cache.InvalidateDocument(ctx, documentId)
 	return nil
 }
 
-func (ds *DocumentService) DeleteDocument(documentId int) error {
-	tx, err := ds.DB.Begin()
+func (ds *DocumentService) DeleteDocument(ctx context.Context, documentId int) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error beginning transaction: %v", err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE FROM events WHERE document_id = $1", documentId)
+	_, err = tx.ExecContext(ctx, "DELETE FROM events WHERE document_id = $1", documentId)
 	if err != nil {
 		return fmt.Errorf("failed to delete events from document: %v", err)
 	}
 
-	_, err = tx.Exec("DELETE FROM document_collaborators WHERE document_id = $1", documentId)
+	_, err = tx.ExecContext(ctx, "DELETE FROM document_collaborators WHERE document_id = $1", documentId)
 	if err != nil {
 		return fmt.Errorf("failed to delete collaborators from document: %v", err)
 	}
 
-	result, err := tx.Exec("DELETE FROM documents WHERE id = $1", documentId)
+	result, err := tx.ExecContext(ctx, "DELETE FROM documents WHERE id = $1", documentId)
 	if err != nil {
 		return fmt.Errorf("failed to delete document: %v", err)
 	}
@@ -141,11 +324,51 @@ func (ds *DocumentService) DeleteDocument(documentId int) error {
 		return fmt.Errorf("error committing transaction: %v", err)
 	}
 
+	ds.Cache.InvalidateDocument(ctx, documentId)
+	ds.Cache.InvalidateAccess(ctx, documentId)
+	return nil
+}
+
+// TransferOwnership makes another user the owner of a document. The new
+// owner stops being a collaborator; the previous owner loses access unless
+// added back as one.
+func (ds *DocumentService) TransferOwnership(ctx context.Context, documentId, newOwnerId int) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE documents SET owner_id = $1 WHERE id = $2", newOwnerId, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to transfer document: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM document_collaborators WHERE document_id = $1 AND user_id = $2", documentId, newOwnerId)
+	if err != nil {
+		return fmt.Errorf("failed to remove new owner from collaborators: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	ds.Cache.InvalidateDocument(ctx, documentId)
+	ds.Cache.InvalidateAccess(ctx, documentId)
 	return nil
 }
 
-func (ds *DocumentService) GetDocumentEvents(documentId int, limit int) ([]Event, error) {
-	rows, err := ds.DB.Query(`
+func (ds *DocumentService) GetDocumentEvents(ctx context.Context, documentId int, limit int) ([]Event, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
 		SELECT id, document_id, user_id, event_type, payload, created_at
 		FROM events WHERE document_id = $1
 		ORDER BY created_at DESC LIMIT $2
@@ -174,14 +397,23 @@ func (ds *DocumentService) GetDocumentEvents(documentId int, limit int) ([]Event
 	return events, nil
 }
 
-func (ds *DocumentService) HasDocumentAccess(userId, documentId int) (bool, error) {
+func (ds *DocumentService) HasDocumentAccess(ctx context.Context, userId, documentId int) (bool, error) {
+	if hasAccess, ok := ds.Cache.GetAccess(ctx, userId, documentId); ok {
+		return hasAccess, nil
+	}
+
 	var hasAccess bool
-	// Check if the user is the owner or collaborator of the document
-	err := ds.DB.QueryRow(`
+	// Check if the user is the owner or collaborator of the document, or a
+	// member of the organization owning it
+	err := ds.DB.QueryRowContext(ctx, `
 		SELECT EXISTS(
 			SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2
 			UNION
 			SELECT 1 FROM document_collaborators WHERE document_id = $1 AND user_id = $2
+			UNION
+			SELECT 1 FROM documents d
+			JOIN organization_members om ON d.organization_id = om.organization_id
+			WHERE d.id = $1 AND om.user_id = $2
 		)
 	`, documentId, userId).Scan(&hasAccess)
 
@@ -189,12 +421,13 @@ func (ds *DocumentService) HasDocumentAccess(userId, documentId int) (bool, erro
 		return false, fmt.Errorf("failed to check document access: %v", err)
 	}
 
+	ds.Cache.SetAccess(ctx, userId, documentId, hasAccess)
 	return hasAccess, nil
 }
 
-func (ds *DocumentService) IsDocumentOwner(userId, documentId int) (bool, error) {
+func (ds *DocumentService) IsDocumentOwner(ctx context.Context, userId, documentId int) (bool, error) {
 	var ownerId int
-	err := ds.DB.QueryRow("SELECT owner_id FROM documents WHERE id = $1", documentId).Scan(&ownerId)
+	err := ds.DB.QueryRowContext(ctx, "SELECT owner_id FROM documents WHERE id = $1", documentId).Scan(&ownerId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil
@@ -205,12 +438,91 @@ func (ds *DocumentService) IsDocumentOwner(userId, documentId int) (bool, error)
 	return ownerId == userId, nil
 }
 
-func (ds *DocumentService) AddCollaborator(documentId, userId int, permission string) error {
+// GetPermission returns "owner" for the document's owner, the collaborator
+// permission ("view" or "edit") for collaborators, the permission their
+// role grants for other members of the organization owning the document,
+// and "" for anyone else. An explicit collaborator permission takes
+// precedence over organization membership.
+func (ds *DocumentService) GetPermission(ctx context.Context, userId, documentId int) (string, error) {
+	var ownerId int
+	err := ds.DB.QueryRowContext(ctx, "SELECT owner_id FROM documents WHERE id = $1", documentId).Scan(&ownerId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrDocumentNotFound
+		}
+		return "", fmt.Errorf("failed to get document owner: %v", err)
+	}
+
+	if ownerId == userId {
+		return "owner", nil
+	}
+
+	permission, err := ds.GetCollaboratorPermission(ctx, documentId, userId)
+	if err != nil || permission != "" {
+		return permission, err
+	}
+
+	// Owners and admins always edit; members and guests get the
+	// organization's default permission for their role
+	err = ds.DB.QueryRowContext(ctx, `
+		SELECT CASE om.role
+			WHEN 'member' THEN o.member_permission
+			WHEN 'guest' THEN o.guest_permission
+			ELSE 'edit'
+		END
+		FROM documents d
+		JOIN organizations o ON d.organization_id = o.id
+		JOIN organization_members om ON o.id = om.organization_id
+		WHERE d.id = $1 AND om.user_id = $2
+	`, documentId, userId).Scan(&permission)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to check organization membership: %v", err)
+	}
+	return permission, nil
+}
+
+func (ds *DocumentService) IsOrganizationMember(ctx context.Context, organizationId, userId int) (bool, error) {
+	var isMember bool
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM organization_members WHERE organization_id = $1 AND user_id = $2)
+	`, organizationId, userId).Scan(&isMember)
+	if err != nil {
+		return false, fmt.Errorf("failed to check organization membership: %v", err)
+	}
+	return isMember, nil
+}
+
+// SetOrganization moves a document into an organization, or back to its
+// owner alone when organizationId is nil.
+func (ds *DocumentService) SetOrganization(ctx context.Context, documentId int, organizationId *int) error {
+	result, err := ds.DB.ExecContext(ctx, "UPDATE documents SET organization_id = $1 WHERE id = $2", organizationId, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to update document organization: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	ds.Cache.InvalidateDocument(ctx, documentId)
+	ds.Cache.InvalidateAccess(ctx, documentId)
+	return nil
+}
+
+func (ds *DocumentService) AddCollaborator(ctx context.Context, documentId, userId int, permission string) error {
 	if permission != "view" && permission != "edit" {
 		return fmt.Errorf("invalid permission: must be 'view' or 'edit'")
 	}
 
-	_, err := ds.DB.Exec(`
+	_, err := ds.DB.ExecContext(ctx, `
 		INSERT INTO document_collaborators (document_id, user_id, permission)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (document_id, user_id) 
@@ -221,11 +533,12 @@ func (ds *DocumentService) AddCollaborator(documentId, userId int, permission st
 		return fmt.Errorf("failed to add collaborator: %v", err)
 	}
 
+	ds.Cache.InvalidateAccess(ctx, documentId)
 	return nil
 }
 
-func (ds *DocumentService) RemoveCollaborator(documentId, userId int) error {
-	result, err := ds.DB.Exec(`
+func (ds *DocumentService) RemoveCollaborator(ctx context.Context, documentId, userId int) error {
+	result, err := ds.DB.ExecContext(ctx, `
 		DELETE FROM document_collaborators 
 		WHERE document_id = $1 AND user_id = $2
 	`, documentId, userId)
@@ -243,12 +556,14 @@ func (ds *DocumentService) RemoveCollaborator(documentId, userId int) error {
 		return fmt.Errorf("collaborator not found")
 	}
 
+	ds.Cache.InvalidateAccess(ctx, documentId)
 	return nil
 }
 
-func (ds *DocumentService) GetCollaborators(documentId int) ([]Collaborator, error) {
-	rows, err := ds.DB.Query(`
-		SELECT dc.id, dc.document_id, dc.user_id, u.email, dc.permission, dc.created_at
+func (ds *DocumentService) GetCollaborators(ctx context.Context, documentId int) ([]Collaborator, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT dc.id, dc.document_id, dc.user_id, u.email, COALESCE(u.username, ''), COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''),
+			dc.permission, dc.created_at
 		FROM document_collaborators dc
 		JOIN users u ON dc.user_id = u.id
 		WHERE dc.document_id = $1
@@ -263,7 +578,8 @@ func (ds *DocumentService) GetCollaborators(documentId int) ([]Collaborator, err
 	var collaborators []Collaborator
 	for rows.Next() {
 		var collab Collaborator
-		if err := rows.Scan(&collab.ID, &collab.DocumentID, &collab.UserID, &collab.Email, &collab.Permission, &collab.CreatedAt); err != nil {
+		if err := rows.Scan(&collab.ID, &collab.DocumentID, &collab.UserID, &collab.Email, &collab.Username, &collab.DisplayName, &collab.AvatarURL,
+			&collab.Permission, &collab.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan collaborator: %v", err)
 		}
 		collaborators = append(collaborators, collab)
@@ -272,9 +588,9 @@ func (ds *DocumentService) GetCollaborators(documentId int) ([]Collaborator, err
 	return collaborators, nil
 }
 
-func (ds *DocumentService) GetCollaboratorPermission(documentId, userId int) (string, error) {
+func (ds *DocumentService) GetCollaboratorPermission(ctx context.Context, documentId, userId int) (string, error) {
 	var permission string
-	err := ds.DB.QueryRow(`
+	err := ds.DB.QueryRowContext(ctx, `
 		SELECT permission FROM document_collaborators 
 		WHERE document_id = $1 AND user_id = $2
 	`, documentId, userId).Scan(&permission)
@@ -288,3 +604,406 @@ func (ds *DocumentService) GetCollaboratorPermission(documentId, userId int) (st
 
 	return permission, nil
 }
+
+// RecordRead stores the latest version a user has rendered. Versions only
+// move forward, so late or out-of-order reports are ignored.
+func (ds *DocumentService) RecordRead(ctx context.Context, documentId, userId, version int) error {
+	_, err := ds.DB.ExecContext(ctx, `
+		INSERT INTO document_reads (document_id, user_id, version, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (document_id, user_id)
+		DO UPDATE SET version = GREATEST(document_reads.version, EXCLUDED.version), updated_at = NOW()
+	`, documentId, userId, version)
+
+	if err != nil {
+		return fmt.Errorf("failed to record read: %v", err)
+	}
+
+	return nil
+}
+
+func (ds *DocumentService) GetReadReceipts(ctx context.Context, documentId int) ([]ReadReceipt, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT dr.user_id, u.email, dr.version, dr.updated_at
+		FROM document_reads dr
+		JOIN users u ON dr.user_id = u.id
+		WHERE dr.document_id = $1
+		ORDER BY dr.updated_at DESC
+	`, documentId)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read receipts: %v", err)
+	}
+	defer rows.Close()
+
+	var receipts []ReadReceipt
+	for rows.Next() {
+		var receipt ReadReceipt
+		if err := rows.Scan(&receipt.UserID, &receipt.Email, &receipt.Version, &receipt.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan read receipt: %v", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
+}
+
+// maxChangeEditors caps how many editors a change summary lists.
+const maxChangeEditors = 20
+
+// Changes summarizes what happened to a document since a user last viewed
+// it.
+type Changes struct {
+	// LastVisit is when the user last reported viewing the document, or
+	// nil if they never have.
+	LastVisit      *time.Time `json:"last_visit"`
+	ViewedVersion  int        `json:"viewed_version" example:"42"`
+	CurrentVersion int        `json:"current_version" example:"45"`
+	// VersionsAdvanced is how many versions the user hasn't seen.
+	VersionsAdvanced int `json:"versions_advanced" example:"3"`
+	// Editors are the other users whose edits the user hasn't seen, most
+	// edits first.
+	Editors []ChangeEditor `json:"editors"`
+	// Events counts the other events created by other users since the last
+	// visit, by type.
+	Events map[string]int `json:"events"`
+}
+
+type ChangeEditor struct {
+	UserID       int       `json:"user_id" example:"2"`
+	Email        string    `json:"email" example:"bob@example.com"`
+	Edits        int       `json:"edits" example:"3"`
+	LastEditedAt time.Time `json:"last_edited_at"`
+}
+
+// GetChangesSinceLastVisit summarizes the edits and events on a document
+// since the version and time userId last viewed it, as recorded by
+// RecordRead.
+func (ds *DocumentService) GetChangesSinceLastVisit(ctx context.Context, documentId, userId int) (*Changes, error) {
+	changes := &Changes{Editors: []ChangeEditor{}, Events: map[string]int{}}
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT version, updated_at FROM document_reads WHERE document_id = $1 AND user_id = $2
+	`, documentId, userId).Scan(&changes.ViewedVersion, &changes.LastVisit)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get last visit: %v", err)
+	}
+
+	changes.CurrentVersion, err = ds.GetCurrentVersion(ctx, documentId)
+	if err != nil {
+		return nil, err
+	}
+	changes.VersionsAdvanced = max(changes.CurrentVersion-changes.ViewedVersion, 0)
+
+	rows, err := ds.DB.QueryContext(ctx, `
+		SELECT e.user_id, u.email, COUNT(*), MAX(e.created_at)
+		FROM events e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.document_id = $1 AND e.event_type = 'edit' AND e.version > $2 AND e.user_id <> $3
+		GROUP BY e.user_id, u.email
+		ORDER BY COUNT(*) DESC, MAX(e.created_at) DESC
+		LIMIT $4
+	`, documentId, changes.ViewedVersion, userId, maxChangeEditors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get editors: %v", err)
+	}
+	for rows.Next() {
+		var editor ChangeEditor
+		if err := rows.Scan(&editor.UserID, &editor.Email, &editor.Edits, &editor.LastEditedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan editor: %v", err)
+		}
+		changes.Editors = append(changes.Editors, editor)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get editors: %v", err)
+	}
+
+	rows, err = ds.DB.QueryContext(ctx, `
+		SELECT event_type, COUNT(*)
+		FROM events
+		WHERE document_id = $1 AND event_type <> 'edit'
+			AND ($2::timestamptz IS NULL OR created_at > $2)
+			AND user_id IS DISTINCT FROM $3
+		GROUP BY event_type
+	`, documentId, changes.LastVisit, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventType string
+		var count int
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan event count: %v", err)
+		}
+		changes.Events[eventType] = count
+	}
+	return changes, rows.Err()
+}
+
+func (ds *DocumentService) GetCurrentVersion(ctx context.Context, documentId int) (int, error) {
+	var version int
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version), 0)
+		FROM events
+		WHERE document_id = $1 AND event_type = 'edit'
+	`, documentId).Scan(&version)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current version: %v", err)
+	}
+
+	return version, nil
+}
+
+// SetPublished makes a document readable by anyone, including spectators
+// without an account, or stops sharing it. Encrypted documents can't be
+// published, since spectators couldn't read them.
+func (ds *DocumentService) SetPublished(ctx context.Context, documentId int, published bool) error {
+	result, err := ds.DB.ExecContext(ctx, "UPDATE documents SET published = $1 WHERE id = $2 AND NOT (encrypted AND $1)", published, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to update published state: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		if encrypted, _ := ds.IsEncrypted(ctx, documentId); encrypted && published {
+			return ErrEncrypted
+		}
+		return fmt.Errorf("no document with id %v has been updated", documentId)
+	}
+
+	return nil
+}
+
+// IsEncrypted reports whether a document is end-to-end encrypted.
+func (ds *DocumentService) IsEncrypted(ctx context.Context, documentId int) (bool, error) {
+	var encrypted bool
+	err := ds.DB.QueryRowContext(ctx, "SELECT encrypted FROM documents WHERE id = $1", documentId).Scan(&encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrDocumentNotFound
+		}
+		return false, fmt.Errorf("failed to check encryption: %v", err)
+	}
+	return encrypted, nil
+}
+
+func (ds *DocumentService) IsPublished(ctx context.Context, documentId int) (bool, error) {
+	var published bool
+	err := ds.DB.QueryRowContext(ctx, "SELECT published FROM documents WHERE id = $1", documentId).Scan(&published)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check published state: %v", err)
+	}
+
+	return published, nil
+}
+
+// DueDate is when a document is due and how long before then everyone with
+// access to it is reminded.
+type DueDate struct {
+	DueDate *time.Time `json:"due_date" example:"2025-10-01T17:00:00Z"`
+	// RemindBeforeMinutes is null when there is no reminder for everyone;
+	// users can still set their own.
+	RemindBeforeMinutes *int `json:"remind_before_minutes" example:"1440"`
+}
+
+func (ds *DocumentService) GetDueDate(ctx context.Context, documentId int) (*DueDate, error) {
+	var due DueDate
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT due_date, remind_before FROM documents WHERE id = $1
+	`, documentId).Scan(&due.DueDate, &due.RemindBeforeMinutes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get due date: %v", err)
+	}
+	return &due, nil
+}
+
+// SetDueDate replaces a document's due date and reminder; nil clears them.
+func (ds *DocumentService) SetDueDate(ctx context.Context, documentId int, due DueDate) error {
+	result, err := ds.DB.ExecContext(ctx, `
+		UPDATE documents SET due_date = $2, remind_before = $3 WHERE id = $1
+	`, documentId, due.DueDate, due.RemindBeforeMinutes)
+	if err != nil {
+		return fmt.Errorf("failed to update due date: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	ds.Cache.InvalidateDocument(ctx, documentId)
+	return nil
+}
+
+// EditorLimit caps how many users can edit a document over WebSocket at
+// once. MaxEditors is null when the deployment's cap applies.
+type EditorLimit struct {
+	MaxEditors *int `json:"max_editors" binding:"omitempty,min=1,max=1000" example:"5"`
+}
+
+func (ds *DocumentService) GetEditorLimit(ctx context.Context, documentId int) (*EditorLimit, error) {
+	var limit EditorLimit
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT max_editors FROM documents WHERE id = $1
+	`, documentId).Scan(&limit.MaxEditors)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get editor limit: %v", err)
+	}
+	return &limit, nil
+}
+
+// SetEditorLimit replaces a document's editor cap; nil clears it. It
+// applies to connections opened from then on.
+func (ds *DocumentService) SetEditorLimit(ctx context.Context, documentId int, limit EditorLimit) error {
+	result, err := ds.DB.ExecContext(ctx, `
+		UPDATE documents SET max_editors = $2 WHERE id = $1
+	`, documentId, limit.MaxEditors)
+	if err != nil {
+		return fmt.Errorf("failed to update editor limit: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// Unarchive brings an archived document back into its users' lists, or
+// keeps a document the owner was warned about from being archived. Either
+// way the inactivity period starts over.
+func (ds *DocumentService) Unarchive(ctx context.Context, documentId int) error {
+	result, err := ds.DB.ExecContext(ctx, `
+		UPDATE documents SET archived_at = NULL, archive_warned_at = NULL, unarchived_at = now() WHERE id = $1
+	`, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive document: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	ds.Cache.InvalidateDocument(ctx, documentId)
+	return nil
+}
+
+// maxScheduledChanges caps how many schedules of each kind one
+// RunPublishSchedule applies, so a backlog is worked through over several
+// runs.
+const maxScheduledChanges = 500
+
+// PublishSchedule is a document's published state and the times it is next
+// published or unpublished.
+type PublishSchedule struct {
+	Published   bool       `json:"published" example:"false"`
+	PublishAt   *time.Time `json:"publish_at" example:"2025-10-01T09:00:00Z"`
+	UnpublishAt *time.Time `json:"unpublish_at" example:"2025-10-08T09:00:00Z"`
+}
+
+// ScheduledChange is a change to a document's published state made by
+// RunPublishSchedule.
+type ScheduledChange struct {
+	DocumentId int
+	OwnerId    int
+	Published  bool
+}
+
+func (ds *DocumentService) GetPublishSchedule(ctx context.Context, documentId int) (*PublishSchedule, error) {
+	var schedule PublishSchedule
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT published, publish_at, unpublish_at FROM documents WHERE id = $1
+	`, documentId).Scan(&schedule.Published, &schedule.PublishAt, &schedule.UnpublishAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get publish schedule: %v", err)
+	}
+	return &schedule, nil
+}
+
+// SetPublishSchedule replaces the times a document is next published and
+// unpublished; a nil time clears it. Encrypted documents can't be scheduled
+// for publishing.
+func (ds *DocumentService) SetPublishSchedule(ctx context.Context, documentId int, publishAt, unpublishAt *time.Time) (*PublishSchedule, error) {
+	var schedule PublishSchedule
+	err := ds.DB.QueryRowContext(ctx, `
+		UPDATE documents SET publish_at = $2, unpublish_at = $3
+		WHERE id = $1 AND NOT (encrypted AND $2::timestamptz IS NOT NULL)
+		RETURNING published, publish_at, unpublish_at
+	`, documentId, publishAt, unpublishAt).Scan(&schedule.Published, &schedule.PublishAt, &schedule.UnpublishAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		if encrypted, _ := ds.IsEncrypted(ctx, documentId); encrypted {
+			return nil, ErrEncrypted
+		}
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update publish schedule: %v", err)
+	}
+	return &schedule, nil
+}
+
+// RunPublishSchedule publishes and then unpublishes the documents whose
+// schedule is due, clearing the schedule, and returns the documents whose
+// published state changed. Documents already in the scheduled state are
+// left as they are. Rows locked by another instance running the schedule
+// are skipped.
+func (ds *DocumentService) RunPublishSchedule(ctx context.Context) ([]ScheduledChange, error) {
+	published, err := ds.applySchedule(ctx, "publish_at", true)
+	if err != nil {
+		return nil, err
+	}
+	unpublished, err := ds.applySchedule(ctx, "unpublish_at", false)
+	if err != nil {
+		return published, err
+	}
+	return append(published, unpublished...), nil
+}
+
+// applySchedule sets published on the documents whose column is due. column
+// is publish_at or unpublish_at.
+func (ds *DocumentService) applySchedule(ctx context.Context, column string, published bool) ([]ScheduledChange, error) {
+	rows, err := ds.DB.QueryContext(ctx, `
+		WITH due AS (
+			SELECT id, published FROM documents
+			WHERE `+column+` <= now()
+			ORDER BY `+column+`
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE documents d SET published = $1 AND NOT d.encrypted, `+column+` = NULL
+		FROM due
+		WHERE d.id = due.id
+		RETURNING d.id, d.owner_id, d.published, due.published
+	`, published, maxScheduledChanges)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %v", column, err)
+	}
+	defer rows.Close()
+
+	var changes []ScheduledChange
+	for rows.Next() {
+		var change ScheduledChange
+		var was bool
+		if err := rows.Scan(&change.DocumentId, &change.OwnerId, &change.Published, &was); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled change: %v", err)
+		}
+		if change.Published != was {
+			changes = append(changes, change)
+		}
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,124 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// snippetLength is the most characters of content a link preview shows.
+const snippetLength = 200
+
+// Unfurl is what chat tools and the frontend show in a link preview of a
+// document.
+type Unfurl struct {
+	DocumentId int    `json:"document_id" example:"1"`
+	Title      string `json:"title" example:"Quarterly plan"`
+	// Description is the start of the content as plain text. It is empty
+	// for encrypted documents.
+	Description string `json:"description" example:"Goals for the quarter: ship offline editing and..."`
+	// Owner is left out of previews of published documents fetched
+	// without access, so they don't reveal the owner's email.
+	Owner     *UnfurlOwner `json:"owner,omitempty"`
+	Published bool         `json:"published" example:"true"`
+	UpdatedAt time.Time    `json:"updated_at" example:"2024-01-15T10:30:00Z"`
+}
+
+type UnfurlOwner struct {
+	ID    int    `json:"id" example:"1"`
+	Email string `json:"email" example:"ada@example.com"`
+}
+
+// GetUnfurl returns the link preview of a document.
+func (ds *DocumentService) GetUnfurl(ctx context.Context, documentId int) (*Unfurl, error) {
+	var unfurl Unfurl
+	var owner UnfurlOwner
+	var content, contentType string
+	var encrypted bool
+	err := ds.DB.QueryRowContext(ctx, `
+		SELECT d.id, d.title, left(COALESCE(d.content, ''), 4096), d.content_type, d.encrypted, d.published,
+			COALESCE(d.updated_at, d.created_at), u.id, u.email
+		FROM documents d
+		JOIN users u ON u.id = d.owner_id
+		WHERE d.id = $1
+	`, documentId).Scan(&unfurl.DocumentId, &unfurl.Title, &content, &contentType, &encrypted, &unfurl.Published,
+		&unfurl.UpdatedAt, &owner.ID, &owner.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get link preview: %v", err)
+	}
+
+	unfurl.Owner = &owner
+	if !encrypted {
+		unfurl.Description = snippet(content, contentType)
+	}
+	return &unfurl, nil
+}
+
+// markdownSyntax matches the Markdown markup left out of snippets:
+// headings, quotes, list markers, emphasis, and code fences.
+var markdownSyntax = regexp.MustCompile("(?m)^\\s{0,3}(#{1,6}|>|[-*+]|\\d+\\.)\\s+|[*_`~]+")
+
+// markdownLink matches links and images, whose text is kept.
+var markdownLink = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+
+// snippet returns the start of content as plain text on one line, cut at
+// a word boundary.
+func snippet(content, contentType string) string {
+	switch contentType {
+	case "text/html":
+		content = htmlText(content)
+	case "text/markdown":
+		content = markdownLink.ReplaceAllString(content, "$1")
+		content = markdownSyntax.ReplaceAllString(content, "")
+	}
+
+	text := strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(text) <= snippetLength {
+		return text
+	}
+
+	runes := []rune(text)[:snippetLength]
+	cut := string(runes)
+	if i := strings.LastIndexByte(cut, ' '); i > snippetLength/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " .,;:") + "..."
+}
+
+// htmlText returns the text of an HTML fragment, leaving out scripts and
+// styles.
+func htmlText(content string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupKeyTest(t *testing.T) (*KeyService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &KeyService{DocumentService: &documents.DocumentService{DB: db}}, mock
+}
+
+func expectEncrypted(mock sqlmock.Sqlmock, encrypted bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(encrypted))
+}
+
+func TestGetKeys_ListsOwnKeysAndPendingMembers(t *testing.T) {
+	service, mock := setupKeyTest(t)
+
+	expectEncrypted(mock, true)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id, key_version, wrapped_key, wrapped_by, created_at")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "key_version", "wrapped_key", "wrapped_by", "created_at"}).
+			AddRow(2, 2, "wrapped-v2", 1, time.Now()).
+			AddRow(2, 1, "wrapped-v1", nil, time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(key_version), 0) FROM document_keys WHERE document_id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT m.user_id FROM (")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+
+	keys, err := service.GetKeys(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatalf("GetKeys failed: %v", err)
+	}
+	if len(keys.Keys) != 2 || keys.Keys[0].KeyVersion != 2 || keys.Keys[0].WrappedBy == nil || keys.Keys[1].WrappedBy != nil {
+		t.Errorf("Unexpected keys %+v", keys.Keys)
+	}
+	if keys.LatestVersion != 2 || len(keys.Pending) != 1 || keys.Pending[0] != 3 {
+		t.Errorf("Expected member 3 pending key version 2, got %+v", keys)
+	}
+
+	// plaintext documents have no keys
+	expectEncrypted(mock, false)
+	if _, err := service.GetKeys(context.Background(), 1, 2); err != ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestShareKeys_RequiresRecipientAccess(t *testing.T) {
+	service, mock := setupKeyTest(t)
+
+	keys := []WrappedKey{{UserId: 3, KeyVersion: 1, WrappedKey: "wrapped"}}
+
+	expectEncrypted(mock, true)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if err := service.ShareKeys(context.Background(), 1, 2, keys); err != ErrNoAccess {
+		t.Errorf("Expected ErrNoAccess, got %v", err)
+	}
+
+	expectEncrypted(mock, true)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO document_keys (document_id, user_id, key_version, wrapped_key, wrapped_by)")).
+		WithArgs(1, 3, 1, "wrapped", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := service.ShareKeys(context.Background(), 1, 2, keys); err != nil {
+		t.Errorf("ShareKeys failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSaveSnapshot_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	service, mock := setupKeyTest(t)
+
+	r := gin.New()
+	r.PUT("/documents/:id/snapshot", func(c *gin.Context) {
+		c.Set("userId", 2)
+		c.Set("documentId", 1)
+	}, (&KeyHandler{KeyService: service}).SaveSnapshot)
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/documents/1/snapshot", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	expectPermission := func(permission string) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+			WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow(permission))
+	}
+	expectVersion := func(version int) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+	update := regexp.QuoteMeta("UPDATE documents SET content = $1, snapshot_version = $2, updated_at = NOW()")
+
+	expectPermission("edit")
+	expectVersion(5)
+	mock.ExpectExec(update).
+		WithArgs("AAEC", 5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if w := serve(`{"ciphertext": "AAEC", "version": 5}`); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// a newer snapshot is already stored
+	expectPermission("edit")
+	expectVersion(5)
+	mock.ExpectExec(update).
+		WithArgs("AAEC", 4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectEncrypted(mock, true)
+	if w := serve(`{"ciphertext": "AAEC", "version": 4}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a stale snapshot, got %d", http.StatusConflict, w.Code)
+	}
+
+	expectPermission("edit")
+	expectVersion(5)
+	if w := serve(`{"ciphertext": "AAEC", "version": 6}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a future version, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	expectPermission("view")
+	if w := serve(`{"ciphertext": "AAEC", "version": 5}`); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "edit permission") {
+		t.Errorf("Expected status %d for a viewer, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,260 @@
+package encryption
+
+import (
+	"errors"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type KeyHandler struct {
+	KeyService *KeyService
+}
+
+// SetPublicKey godoc
+// @Summary Set my public key
+// @Description Publish the authenticated user's public key, which other members wrap encrypted documents' keys with. Replacing it doesn't re-wrap existing keys.
+// @Tags encryption
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body SetPublicKeyRequest true "Public key"
+// @Success 200 {object} MessageResponse "Public key updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/public-key [put]
+func (h *KeyHandler) SetPublicKey(c *gin.Context) {
+	var req SetPublicKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.KeyService.SetPublicKey(c.Request.Context(), c.GetInt("userId"), req.PublicKey, req.Algorithm); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update public key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Public key updated"})
+}
+
+// GetPublicKey godoc
+// @Summary Get a user's public key
+// @Description Get the public key a user published, to wrap a document key for them.
+// @Tags encryption
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} PublicKey
+// @Failure 400 {object} ErrorResponse "Invalid user ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "The user has no public key"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/users/{id}/public-key [get]
+func (h *KeyHandler) GetPublicKey(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	key, err := h.KeyService.GetPublicKey(c.Request.Context(), userId)
+	if errors.Is(err, ErrNoPublicKey) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The user has no public key"})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get public key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// GetKeys godoc
+// @Summary Get my document keys
+// @Description Get the document keys of an encrypted document wrapped with the authenticated user's public key, and the members who don't have the latest key yet.
+// @Tags encryption
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} DocumentKeys
+// @Failure 400 {object} ErrorResponse "The document is not encrypted"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/keys [get]
+func (h *KeyHandler) GetKeys(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	keys, err := h.KeyService.GetKeys(c.Request.Context(), documentId, c.GetInt("userId"))
+	if errors.Is(err, ErrNotEncrypted) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document is not encrypted"})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// ShareKeys godoc
+// @Summary Share document keys
+// @Description Share an encrypted document's key with members, wrapped with each one's public key. A new key version rotates the key, e.g. after removing a collaborator; edits are then encrypted with the new key. Requires edit permission.
+// @Tags encryption
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body ShareKeysRequest true "Wrapped keys"
+// @Success 201 {object} MessageResponse "Keys shared"
+// @Failure 400 {object} ErrorResponse "Invalid input data, the document is not encrypted, or a recipient has no access"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/keys [post]
+func (h *KeyHandler) ShareKeys(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+	userId := c.GetInt("userId")
+
+	if !h.canEdit(c, userId, documentId) {
+		return
+	}
+
+	var req ShareKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.KeyService.ShareKeys(c.Request.Context(), documentId, userId, req.Keys)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, gin.H{"message": "Keys shared"})
+	case errors.Is(err, ErrNotEncrypted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document is not encrypted"})
+	case errors.Is(err, ErrNoAccess):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keys can only be shared with users who have access to the document"})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share keys"})
+	}
+}
+
+// GetSnapshot godoc
+// @Summary Get an encrypted snapshot
+// @Description Get the stored ciphertext of an encrypted document and the version it was taken at. Clients decrypt it and apply the edits made since.
+// @Tags encryption
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Snapshot
+// @Failure 400 {object} ErrorResponse "The document is not encrypted"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/snapshot [get]
+func (h *KeyHandler) GetSnapshot(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	snapshot, err := h.KeyService.GetSnapshot(c.Request.Context(), documentId)
+	if errors.Is(err, ErrNotEncrypted) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document is not encrypted"})
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// SaveSnapshot godoc
+// @Summary Save an encrypted snapshot
+// @Description Replace the stored ciphertext of an encrypted document with a client's snapshot as of a version, so new clients don't have to replay every edit. Requires edit permission.
+// @Tags encryption
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body Snapshot true "Snapshot"
+// @Success 200 {object} MessageResponse "Snapshot saved"
+// @Failure 400 {object} ErrorResponse "Invalid input data, or the document is not encrypted or hasn't reached the version"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 409 {object} ErrorResponse "A newer snapshot is already stored"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/snapshot [put]
+func (h *KeyHandler) SaveSnapshot(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	if !h.canEdit(c, c.GetInt("userId"), documentId) {
+		return
+	}
+
+	var req Snapshot
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.KeyService.SaveSnapshot(c.Request.Context(), documentId, req)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "Snapshot saved"})
+	case errors.Is(err, ErrNotEncrypted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document is not encrypted"})
+	case errors.Is(err, ErrUnknownVersion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document hasn't reached that version"})
+	case errors.Is(err, ErrStaleSnapshot):
+		c.JSON(http.StatusConflict, gin.H{"error": "A newer snapshot is already stored"})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot"})
+	}
+}
+
+// canEdit writes a response and returns false unless the user may edit the
+// document.
+func (h *KeyHandler) canEdit(c *gin.Context, userId, documentId int) bool {
+	permission, err := h.KeyService.DocumentService.GetPermission(c.Request.Context(), userId, documentId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission"})
+		return false
+	}
+	if permission != "owner" && permission != "edit" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You need edit permission to modify this document"})
+		return false
+	}
+	return true
+}
+
+// swagger models for encryption
+
+type SetPublicKeyRequest struct {
+	PublicKey string `json:"public_key" binding:"required,max=4096" example:"MCowBQYDK2VuAyEA..."`
+	Algorithm string `json:"algorithm" binding:"required,max=50" example:"X25519"`
+}
+
+type ShareKeysRequest struct {
+	Keys []WrappedKey `json:"keys" binding:"required,min=1,max=1000,dive"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Keys shared"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"The document is not encrypted"`
+}
@@ -0,0 +1,262 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"time"
+)
+
+// Encrypted documents are encrypted by their clients with a symmetric
+// document key. Each member publishes a public key, and the document key
+// is shared by wrapping (encrypting) it with each member's public key. The
+// server stores and hands out public keys, wrapped keys, and ciphertext,
+// but never sees a plaintext key or document.
+
+var (
+	ErrNoPublicKey    = errors.New("user has no public key")
+	ErrNotEncrypted   = errors.New("document is not encrypted")
+	ErrNoAccess       = errors.New("recipient has no access to the document")
+	ErrStaleSnapshot  = errors.New("a newer snapshot is already stored")
+	ErrUnknownVersion = errors.New("the document hasn't reached that version")
+)
+
+type PublicKey struct {
+	UserId int `json:"user_id" example:"2"`
+	// PublicKey is the encoded key, opaque to the server.
+	PublicKey string `json:"public_key" example:"MCowBQYDK2VuAyEA..."`
+	// Algorithm names the key type so clients know how to wrap with it.
+	Algorithm string    `json:"algorithm" example:"X25519"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WrappedKey is a document key encrypted with one member's public key.
+type WrappedKey struct {
+	UserId int `json:"user_id" binding:"required" example:"2"`
+	// KeyVersion increases each time the document key is rotated. Edits
+	// name the version they were encrypted with.
+	KeyVersion int    `json:"key_version" binding:"required,min=1" example:"1"`
+	WrappedKey string `json:"wrapped_key" binding:"required" example:"hF0c9..."`
+	// WrappedBy is the member who shared the key, or nil when they were
+	// deleted.
+	WrappedBy *int      `json:"wrapped_by,omitempty" example:"1"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DocumentKeys is what a member needs to read an encrypted document.
+type DocumentKeys struct {
+	// Keys are the caller's wrapped keys, newest version first.
+	Keys []WrappedKey `json:"keys"`
+	// LatestVersion is the newest key version shared with anyone.
+	LatestVersion int `json:"latest_version" example:"1"`
+	// Pending lists members without the latest key, for a member who
+	// holds it to share it with.
+	Pending []int `json:"pending"`
+}
+
+// Snapshot is the encrypted content of a document as of Version.
+type Snapshot struct {
+	Ciphertext string `json:"ciphertext" binding:"required" example:"AAECAwQF..."`
+	Version    int    `json:"version" binding:"min=0" example:"42"`
+}
+
+type KeyService struct {
+	DocumentService *documents.DocumentService
+}
+
+func (s *KeyService) SetPublicKey(ctx context.Context, userId int, publicKey, algorithm string) error {
+	_, err := s.DocumentService.DB.ExecContext(ctx, `
+		INSERT INTO user_public_keys (user_id, public_key, algorithm)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET public_key = EXCLUDED.public_key, algorithm = EXCLUDED.algorithm, updated_at = now()
+	`, userId, publicKey, algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to set public key: %v", err)
+	}
+	return nil
+}
+
+func (s *KeyService) GetPublicKey(ctx context.Context, userId int) (*PublicKey, error) {
+	key := &PublicKey{UserId: userId}
+	err := s.DocumentService.DB.QueryRowContext(ctx,
+		"SELECT public_key, algorithm, updated_at FROM user_public_keys WHERE user_id = $1", userId,
+	).Scan(&key.PublicKey, &key.Algorithm, &key.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoPublicKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %v", err)
+	}
+	return key, nil
+}
+
+// GetKeys returns a member's wrapped keys for a document, along with the
+// members still waiting for the latest key.
+func (s *KeyService) GetKeys(ctx context.Context, documentId, userId int) (*DocumentKeys, error) {
+	if err := s.checkEncrypted(ctx, documentId); err != nil {
+		return nil, err
+	}
+
+	db := s.DocumentService.DB
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, key_version, wrapped_key, wrapped_by, created_at
+		FROM document_keys
+		WHERE document_id = $1 AND user_id = $2
+		ORDER BY key_version DESC
+	`, documentId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := &DocumentKeys{Keys: []WrappedKey{}, Pending: []int{}}
+	for rows.Next() {
+		var key WrappedKey
+		var wrappedBy sql.NullInt64
+		if err := rows.Scan(&key.UserId, &key.KeyVersion, &key.WrappedKey, &wrappedBy, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan document key: %v", err)
+		}
+		if wrappedBy.Valid {
+			id := int(wrappedBy.Int64)
+			key.WrappedBy = &id
+		}
+		keys.Keys = append(keys.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get document keys: %v", err)
+	}
+
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(key_version), 0) FROM document_keys WHERE document_id = $1", documentId).
+		Scan(&keys.LatestVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest key version: %v", err)
+	}
+
+	// Members are the owner, collaborators, and organization members
+	pending, err := db.QueryContext(ctx, `
+		SELECT m.user_id FROM (
+			SELECT owner_id AS user_id FROM documents WHERE id = $1
+			UNION SELECT user_id FROM document_collaborators WHERE document_id = $1
+			UNION SELECT om.user_id FROM organization_members om JOIN documents d ON d.organization_id = om.organization_id WHERE d.id = $1
+		) m
+		WHERE NOT EXISTS (
+			SELECT 1 FROM document_keys k
+			WHERE k.document_id = $1 AND k.user_id = m.user_id AND k.key_version = $2
+		)
+		ORDER BY m.user_id
+	`, documentId, keys.LatestVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members without the latest key: %v", err)
+	}
+	defer pending.Close()
+	for pending.Next() {
+		var memberId int
+		if err := pending.Scan(&memberId); err != nil {
+			return nil, fmt.Errorf("failed to scan member: %v", err)
+		}
+		keys.Pending = append(keys.Pending, memberId)
+	}
+	return keys, pending.Err()
+}
+
+// ShareKeys stores document keys wrapped by sharedBy for other members.
+// Every recipient must have access to the document; sharing a version
+// again with the same member replaces their wrapped key.
+func (s *KeyService) ShareKeys(ctx context.Context, documentId, sharedBy int, keys []WrappedKey) error {
+	if err := s.checkEncrypted(ctx, documentId); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		hasAccess, err := s.DocumentService.HasDocumentAccess(ctx, key.UserId, documentId)
+		if err != nil {
+			return err
+		}
+		if !hasAccess {
+			return ErrNoAccess
+		}
+	}
+
+	tx, err := s.DocumentService.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO document_keys (document_id, user_id, key_version, wrapped_key, wrapped_by)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (document_id, user_id, key_version) DO UPDATE SET wrapped_key = EXCLUDED.wrapped_key, wrapped_by = EXCLUDED.wrapped_by, created_at = now()
+		`, documentId, key.UserId, key.KeyVersion, key.WrappedKey, sharedBy)
+		if err != nil {
+			return fmt.Errorf("failed to share document key: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *KeyService) GetSnapshot(ctx context.Context, documentId int) (*Snapshot, error) {
+	var encrypted bool
+	snapshot := &Snapshot{}
+	err := s.DocumentService.DB.QueryRowContext(ctx,
+		"SELECT encrypted, COALESCE(content, ''), snapshot_version FROM documents WHERE id = $1", documentId,
+	).Scan(&encrypted, &snapshot.Ciphertext, &snapshot.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, documents.ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot: %v", err)
+	}
+	if !encrypted {
+		return nil, ErrNotEncrypted
+	}
+	return snapshot, nil
+}
+
+// SaveSnapshot replaces the stored ciphertext with a snapshot taken by a
+// client, unless one of a later version is already stored. Clients joining
+// the document load the snapshot and apply the edits made since.
+func (s *KeyService) SaveSnapshot(ctx context.Context, documentId int, snapshot Snapshot) error {
+	current, err := s.DocumentService.GetCurrentVersion(ctx, documentId)
+	if err != nil {
+		return err
+	}
+	if snapshot.Version > current {
+		return ErrUnknownVersion
+	}
+
+	result, err := s.DocumentService.DB.ExecContext(ctx, `
+		UPDATE documents SET content = $1, snapshot_version = $2, updated_at = NOW()
+		WHERE id = $3 AND encrypted AND snapshot_version <= $2
+	`, snapshot.Ciphertext, snapshot.Version, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		if err := s.checkEncrypted(ctx, documentId); err != nil {
+			return err
+		}
+		return ErrStaleSnapshot
+	}
+
+	s.DocumentService.Cache.InvalidateDocument(ctx, documentId)
+	return nil
+}
+
+func (s *KeyService) checkEncrypted(ctx context.Context, documentId int) error {
+	encrypted, err := s.DocumentService.IsEncrypted(ctx, documentId)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		return ErrNotEncrypted
+	}
+	return nil
+}
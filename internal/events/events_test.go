@@ -0,0 +1,214 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupEventTest(t *testing.T) (*EventHandler, sqlmock.Sqlmock, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload)"))
+	mock.ExpectPrepare(regexp.QuoteMeta("SELECT id, document_id, user_id, event_type, payload, created_at, updated_at"))
+	eventService, err := NewEventService(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Error creating event service: %v", err)
+	}
+	t.Cleanup(func() { eventService.Close() })
+
+	handler := &EventHandler{
+		EventService:    eventService,
+		DocumentService: &documents.DocumentService{DB: db},
+		AuthService:     &auth.AuthService{DB: db, JWTSecret: "test-secret"},
+	}
+
+	r := gin.New()
+	r.POST("/documents/:id/events", handler.CreateDocumentEvent)
+	r.GET("/documents/:id/events", handler.GetDocumentEvents)
+	r.GET("/admin/events/search", handler.SearchEvents)
+	r.GET("/documents/:id/sessions", func(c *gin.Context) { c.Set("documentId", 1) }, handler.GetSessions)
+	return handler, mock, r
+}
+
+func TestCreateDocumentEvent_Success(t *testing.T) {
+	_, mock, r := setupEventTest(t)
+	token, _ := auth.GenerateJWT(2, "test-secret")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("edit"))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload)")).
+		WithArgs(1, 2, "text_insert", `{"position":0,"text":"Hi"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	payload := []byte(`{"event_type": "text_insert", "payload": "{\"position\":0,\"text\":\"Hi\"}"}`)
+	req, _ := http.NewRequest("POST", "/documents/1/events", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["event_id"] != float64(7) {
+		t.Errorf("Expected event_id 7, got %v", response["event_id"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateDocumentEvent_ViewOnly(t *testing.T) {
+	_, mock, r := setupEventTest(t)
+	token, _ := auth.GenerateJWT(2, "test-secret")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("view"))
+
+	payload := []byte(`{"event_type": "text_insert", "payload": "{}"}`)
+	req, _ := http.NewRequest("POST", "/documents/1/events", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestGetDocumentEvents_Success(t *testing.T) {
+	_, mock, r := setupEventTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, document_id, user_id, event_type, payload, created_at, updated_at")).
+		WithArgs(1, time.Time{}, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "user_id", "event_type", "payload", "created_at", "updated_at"}).
+			AddRow(3, 1, 1, "text_insert", []byte(`{"position":0}`), now, now))
+
+	req, _ := http.NewRequest("GET", "/documents/1/events?limit=10&offset=20", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Events []Event `json:"events"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if len(response.Events) != 1 || response.Events[0].ID != 3 {
+		t.Errorf("Expected event 3, got %v", response.Events)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSearchEvents(t *testing.T) {
+	_, mock, r := setupEventTest(t)
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events WHERE document_id = $1 AND payload::text ILIKE $2 AND payload @> $3::jsonb ORDER BY created_at DESC, id DESC LIMIT $4 OFFSET $5")).
+		WithArgs(1, `%50\% off%`, `{"operation":"insert"}`, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "user_id", "event_type", "payload", "created_at", "updated_at"}).
+			AddRow(7, 1, 2, "edit", []byte(`{"operation":"insert","content":"50% off"}`), now, now))
+
+	req, _ := http.NewRequest("GET", `/admin/events/search?document_id=1&text=50%25+off&contains={"operation":"insert"}`, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSearchEvents_Unscoped(t *testing.T) {
+	_, _, r := setupEventTest(t)
+
+	for _, query := range []string{"text=hello", "document_id=1", "user_id=1&contains=nope"} {
+		req, _ := http.NewRequest("GET", "/admin/events/search?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestGetSessions(t *testing.T) {
+	_, mock, r := setupEventTest(t)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SUM(starts_session) OVER (PARTITION BY user_id ORDER BY created_at) AS session")).
+		WithArgs(1, since, until, 600, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "started_at", "ended_at", "events"}).
+			AddRow(2, "ada@example.com", start.Add(2*time.Hour), start.Add(2*time.Hour+20*time.Minute), 40).
+			AddRow(3, "alan@example.com", start, start.Add(45*time.Minute), 90).
+			AddRow(2, "ada@example.com", start, start.Add(time.Hour), 120))
+
+	req, _ := http.NewRequest("GET", "/documents/1/sessions?since=2024-01-01T00:00:00Z&until=2024-02-01T00:00:00Z&gap_minutes=10", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var report SessionReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if len(report.Sessions) != 3 || report.Sessions[1].DurationSeconds != 45*60 {
+		t.Errorf("Unexpected sessions: %+v", report.Sessions)
+	}
+	if len(report.Users) != 2 || report.Users[0].UserId != 2 || report.Users[0].Sessions != 2 ||
+		report.Users[0].DurationSeconds != 80*60 || report.Users[0].Events != 160 {
+		t.Errorf("Unexpected user totals: %+v", report.Users)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package events
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"live-collab-api/internal/webhooks"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,8 +15,26 @@ import (
 )
 
 type EventHandler struct {
-	DB          *sql.DB
-	AuthService *auth.AuthService
+	EventService    *EventService
+	DocumentService *documents.DocumentService
+	AuthService     *auth.AuthService
+	// Webhooks, when set, delivers created events to the document owner's
+	// webhooks.
+	Webhooks *webhooks.Dispatcher
+}
+
+var validEventTypes = map[string]bool{
+	"text_insert":   true,
+	"text_delete":   true,
+	"text_replace":  true,
+	"cursor_move":   true,
+	"selection":     true,
+	"document_save": true,
+}
+
+// ValidEventType reports whether clients may create events of this type.
+func ValidEventType(eventType string) bool {
+	return validEventTypes[eventType]
 }
 
 type Event struct {
@@ -22,7 +42,7 @@ type Event struct {
 	DocumentId int             `json:"document_id"`
 	UserId     int             `json:"user_id"`
 	EventType  string          `json:"event_type"`
-	Payload    json.RawMessage `json:"payload"`
+	Payload    json.RawMessage `json:"payload" swaggertype:"object"`
 	CreatedAt  time.Time       `json:"created_at"`
 	UpdatedAt  time.Time       `json:"updated_at"`
 }
@@ -36,11 +56,14 @@ type Event struct {
 // @Security BearerAuth
 // @Param id path int true "Document ID"
 // @Param request body CreateEventRequest true "Event data with type and payload"
+// @Param Idempotency-Key header string false "Key identifying retries of this request; a retry gets the first response back"
 // @Success 201 {object} CreateEventResponse "Event created successfully"
 // @Failure 400 {object} ErrorResponse "Invalid input data or event type"
 // @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
 // @Failure 403 {object} ErrorResponse "Access denied - you don't own this document"
 // @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 409 {object} ErrorResponse "A request with the same Idempotency-Key is still being processed"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key was already used for a different request"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /documents/{id}/events [post]
 func (h *EventHandler) CreateDocumentEvent(c *gin.Context) {
@@ -58,8 +81,7 @@ func (h *EventHandler) CreateDocumentEvent(c *gin.Context) {
 	}
 
 	var req CreateEventRequest
-	if err = c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !validation.BindJSON(c, &req) {
 		return
 	}
 
@@ -69,57 +91,43 @@ func (h *EventHandler) CreateDocumentEvent(c *gin.Context) {
 		return
 	}
 
-	var ownerId int
-	err = h.DB.QueryRow("SELECT owner_id FROM documents WHERE id = $1", documentId).Scan(&ownerId)
+	permission, err := h.DocumentService.GetPermission(c.Request.Context(), userId, documentId)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Document does not exist"})
 		} else {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		}
 		return
 	}
 
-	hasEditPermission := false
-	if ownerId == userId {
-		hasEditPermission = true
-	} else {
-		var permission string
-		err = h.DB.QueryRow(`
-			SELECT permission FROM document_collaborators 
-			WHERE document_id = $1 AND user_id = $2
-		`, documentId, userId).Scan(&permission)
-
-		if err == nil && permission == "edit" {
-			hasEditPermission = true
-		}
-	}
-
-	if !hasEditPermission {
+	if permission != "owner" && permission != "edit" {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You need edit permission to create events for this document"})
 		return
 	}
 
-	validEventTypes := map[string]bool{
-		"text_insert":   true,
-		"text_delete":   true,
-		"text_replace":  true,
-		"cursor_move":   true,
-		"selection":     true,
-		"document_save": true,
-	}
-
-	if !validEventTypes[req.EventType] {
+	if !ValidEventType(req.EventType) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event type"})
 		return
 	}
 
-	var eventId int
-	err = h.DB.QueryRow("INSERT INTO events (document_id, user_id, event_type, payload) VALUES ($1,$2,$3,$4) RETURNING id",
-		documentId, userId, req.EventType, req.Payload).Scan(&eventId)
-
+	eventId, err := h.EventService.CreateEvent(c.Request.Context(), documentId, userId, req.EventType, req.Payload)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+
+	if h.Webhooks != nil {
+		if document, err := h.DocumentService.GetDocument(c.Request.Context(), documentId); err == nil {
+			h.Webhooks.Dispatch(c.Request.Context(), document.OwnerId, webhooks.EventEventCreated, gin.H{
+				"event_id":    eventId,
+				"event_type":  req.EventType,
+				"document_id": documentId,
+				"user_id":     userId,
+			})
+		}
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -138,8 +146,9 @@ func (h *EventHandler) CreateDocumentEvent(c *gin.Context) {
 // @Produce json
 // @Security BearerAuth
 // @Param id path int true "Document ID"
-// @Param limit query int false "Number of events to return (default 50, max 1000)" default(50)
+// @Param limit query int false "Number of events to return (1-1000)" default(50)
 // @Param offset query int false "Number of events to skip (default 0)" default(0)
+// @Param since query string false "Only events created at or after this time (RFC 3339). Recent bounds are answered faster."
 // @Success 200 {object} EventListResponse "List of events with pagination info"
 // @Failure 400 {object} ErrorResponse "Invalid document ID or parameters"
 // @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
@@ -161,16 +170,9 @@ func (h *EventHandler) GetDocumentEvents(c *gin.Context) {
 		return
 	}
 
-	var hasAccess bool
-	err = h.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2
-			UNION
-			SELECT 1 FROM document_collaborators WHERE document_id = $1 AND user_id = $2
-		)
-	`, documentId, userId).Scan(&hasAccess)
-
+	hasAccess, err := h.DocumentService.HasDocumentAccess(c.Request.Context(), userId, documentId)
 	if err != nil {
+		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
@@ -180,44 +182,182 @@ func (h *EventHandler) GetDocumentEvents(c *gin.Context) {
 		return
 	}
 
-	limitParam := c.DefaultQuery("limit", "50")
-	offsetParam := c.DefaultQuery("offset", "0")
+	var req ListEventsQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
 
-	limit, err := strconv.Atoi(limitParam)
-	if err != nil || limit <= 0 || limit > 1000 {
-		limit = 50
+	var since time.Time
+	if req.Since != "" {
+		if since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "since", Message: "must be an RFC 3339 time"}}})
+			return
+		}
 	}
 
-	offset, err := strconv.Atoi(offsetParam)
-	if err != nil || offset < 0 {
-		offset = 0
+	events, err := h.EventService.ListEvents(c.Request.Context(), documentId, since, req.Limit, req.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query error"})
+		return
 	}
 
-	rows, err := h.DB.Query(
-		"SELECT id, document_id, user_id, event_type, payload, created_at, updated_at FROM events WHERE document_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3",
-		documentId, limit, offset)
+	c.JSON(http.StatusOK, gin.H{"events": events, "limit": req.Limit, "offset": req.Offset})
+}
+
+// defaultSessionWindow is how far back sessions are listed without since.
+const defaultSessionWindow = 30 * 24 * time.Hour
+
+// GetSessions godoc
+// @Summary Get a document's editing sessions
+// @Description Group a document's events into each user's editing sessions, splitting them wherever the user was idle for longer than gap_minutes, to review who worked on it, when, and for how long. Sessions are listed most recent first, with each user's totals over the listed sessions. Without since, covers the last 30 days.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param since query string false "Only events created at or after this time (RFC 3339)"
+// @Param until query string false "Only events created before this time (RFC 3339)"
+// @Param gap_minutes query int false "Idle minutes that end a session (1-1440)" default(30)
+// @Param limit query int false "Number of sessions to return (1-1000)" default(100)
+// @Success 200 {object} SessionReport "Editing sessions"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/sessions [get]
+func (h *EventHandler) GetSessions(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	var req SessionsQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+
+	until := time.Now().UTC()
+	var err error
+	if req.Until != "" {
+		if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "until", Message: "must be an RFC 3339 time"}}})
+			return
+		}
+	}
+	since := until.Add(-defaultSessionWindow)
+	if req.Since != "" {
+		if since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "since", Message: "must be an RFC 3339 time"}}})
+			return
+		}
+	}
+	if !since.Before(until) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be before until"})
+		return
+	}
+
+	gap := time.Duration(req.GapMinutes) * time.Minute
+	sessions, err := h.EventService.ListSessions(c.Request.Context(), documentId, since, until, gap, req.Limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query error", "detail": err.Error()})
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SessionReport{
+		Since:      since,
+		Until:      until,
+		GapMinutes: req.GapMinutes,
+		Sessions:   sessions,
+		Users:      userTotals(sessions),
+	})
+}
+
+// userTotals adds up each user's sessions, in order of first appearance.
+func userTotals(sessions []Session) []UserSessions {
+	totals := []UserSessions{}
+	index := make(map[int]int)
+	for _, session := range sessions {
+		i, ok := index[session.UserId]
+		if !ok {
+			i = len(totals)
+			index[session.UserId] = i
+			totals = append(totals, UserSessions{UserId: session.UserId, Email: session.Email})
+		}
+		totals[i].Sessions++
+		totals[i].DurationSeconds += session.DurationSeconds
+		totals[i].Events += session.Events
+	}
+	return totals
+}
+
+// SearchEvents godoc
+// @Summary Search event payloads
+// @Description Find a document's or a user's events whose payload contains some text or JSON, newest first, such as to tell when a piece of text entered or left a document. text matches the payload's JSON text case-insensitively; contains is a JSON value the payload must contain, e.g. {"text":"Hello"}. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param document_id query int false "Only this document's events; document_id or user_id is required"
+// @Param user_id query int false "Only this user's events"
+// @Param text query string false "Text to find in the payload, at least 3 characters"
+// @Param contains query string false "JSON the payload must contain"
+// @Param event_type query string false "Only events of this type"
+// @Param since query string false "Only events created at or after this time (RFC 3339)"
+// @Param until query string false "Only events created before this time (RFC 3339)"
+// @Param limit query int false "Number of events to return (1-1000)" default(50)
+// @Param offset query int false "Number of events to skip (default 0)" default(0)
+// @Success 200 {object} EventListResponse "Matching events"
+// @Failure 400 {object} ErrorResponse "Invalid search"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/events/search [get]
+func (h *EventHandler) SearchEvents(c *gin.Context) {
+	var req SearchEventsQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+
+	// a search always has an index to start from and something to match
+	if req.DocumentId == 0 && req.UserId == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document_id or user_id is required"})
+		return
+	}
+	if req.Text == "" && req.Contains == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text or contains is required"})
+		return
+	}
+	if req.Contains != "" && !json.Valid([]byte(req.Contains)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "contains", Message: "must be JSON"}}})
 		return
 	}
-	defer rows.Close()
-
-	var events []Event
-	for rows.Next() {
-		var event Event
-		err := rows.Scan(&event.ID, &event.DocumentId, &event.UserId, &event.EventType, &event.Payload, &event.CreatedAt, &event.UpdatedAt)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database scan error", "detail": err.Error()})
+
+	filter := SearchFilter{
+		DocumentId: req.DocumentId,
+		UserId:     req.UserId,
+		EventType:  req.EventType,
+		Text:       req.Text,
+		Contains:   req.Contains,
+	}
+	var err error
+	if req.Since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "since", Message: "must be an RFC 3339 time"}}})
+			return
+		}
+	}
+	if req.Until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "fields": []validation.FieldError{{Field: "until", Message: "must be an RFC 3339 time"}}})
 			return
 		}
-		events = append(events, event)
 	}
 
-	if events == nil {
-		events = []Event{}
+	events, err := h.EventService.SearchEvents(c.Request.Context(), filter, req.Limit, req.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search events"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"events": events, "limit": limit, "offset": offset})
+	c.JSON(http.StatusOK, gin.H{"events": events, "limit": req.Limit, "offset": req.Offset})
 }
 
 // swagger models for events
@@ -240,8 +380,51 @@ type EventListResponse struct {
 }
 
 type CreateEventRequest struct {
-	EventType string `json:"event_type" binding:"required" example:"text_insert" enums:"text_insert,text_delete,text_replace,cursor_move,selection,document_save,document_open,user_join,user_leave"`
-	Payload   string `json:"payload" binding:"required" example:"{\"position\":10,\"text\":\"Hello World\",\"timestamp\":\"2024-01-15T10:30:00Z\"}"`
+	EventType string `json:"event_type" binding:"required,max=50" example:"text_insert" enums:"text_insert,text_delete,text_replace,cursor_move,selection,document_save,document_open,user_join,user_leave"`
+	Payload   string `json:"payload" binding:"required,max=65536" example:"{\"position\":10,\"text\":\"Hello World\",\"timestamp\":\"2024-01-15T10:30:00Z\"}"`
+}
+
+type ListEventsQuery struct {
+	Limit  int    `form:"limit,default=50" binding:"min=1,max=1000"`
+	Offset int    `form:"offset,default=0" binding:"min=0"`
+	Since  string `form:"since"`
+}
+
+type SearchEventsQuery struct {
+	DocumentId int    `form:"document_id" binding:"omitempty,min=1"`
+	UserId     int    `form:"user_id" binding:"omitempty,min=1"`
+	Text       string `form:"text" binding:"omitempty,min=3,max=200"`
+	Contains   string `form:"contains" binding:"max=2000"`
+	EventType  string `form:"event_type" binding:"max=50"`
+	Since      string `form:"since"`
+	Until      string `form:"until"`
+	Limit      int    `form:"limit,default=50" binding:"min=1,max=1000"`
+	Offset     int    `form:"offset,default=0" binding:"min=0"`
+}
+
+type SessionsQuery struct {
+	Since      string `form:"since"`
+	Until      string `form:"until"`
+	GapMinutes int    `form:"gap_minutes,default=30" binding:"min=1,max=1440"`
+	Limit      int    `form:"limit,default=100" binding:"min=1,max=1000"`
+}
+
+// SessionReport lists a document's editing sessions between Since and
+// Until, and each user's totals over them.
+type SessionReport struct {
+	Since      time.Time      `json:"since" example:"2024-01-01T00:00:00Z"`
+	Until      time.Time      `json:"until" example:"2024-01-31T00:00:00Z"`
+	GapMinutes int            `json:"gap_minutes" example:"30"`
+	Sessions   []Session      `json:"sessions"`
+	Users      []UserSessions `json:"users"`
+}
+
+type UserSessions struct {
+	UserId          int    `json:"user_id" example:"2"`
+	Email           string `json:"email" example:"ada@example.com"`
+	Sessions        int    `json:"sessions" example:"4"`
+	DurationSeconds int64  `json:"duration_seconds" example:"9000"`
+	Events          int    `json:"events" example:"1250"`
 }
 
 type CreateEventResponse struct {
@@ -254,4 +437,6 @@ type CreateEventResponse struct {
 
 type ErrorResponse struct {
 	Error string `json:"error" example:"Error message"`
+	// Fields lists the invalid fields of a rejected request.
+	Fields []validation.FieldError `json:"fields,omitempty"`
 }
@@ -0,0 +1,304 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/usage"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// eventColumns are the columns scanned into an Event.
+const eventColumns = "id, document_id, user_id, event_type, payload, created_at, updated_at"
+
+// EventService stores and lists document events. Its queries run on every
+// edit, so they are prepared once up front rather than parsed per call.
+type EventService struct {
+	DB *sql.DB
+	// Pool is the pgx pool DB draws from. CopyEvents requires it.
+	Pool *pgxpool.Pool
+	// Meter, when set, counts every created event.
+	Meter *usage.Meter
+
+	insertStmt *sql.Stmt
+	listStmt   *sql.Stmt
+}
+
+func NewEventService(ctx context.Context, db *sql.DB) (*EventService, error) {
+	insertStmt, err := db.PrepareContext(ctx, `
+		INSERT INTO events (document_id, user_id, event_type, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare event insert: %v", err)
+	}
+
+	listStmt, err := db.PrepareContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events WHERE document_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC LIMIT $3 OFFSET $4`)
+	if err != nil {
+		insertStmt.Close()
+		return nil, fmt.Errorf("failed to prepare event list: %v", err)
+	}
+
+	return &EventService{
+		DB:         db,
+		insertStmt: insertStmt,
+		listStmt:   listStmt,
+	}, nil
+}
+
+// Close releases the prepared statements.
+func (s *EventService) Close() error {
+	s.listStmt.Close()
+	return s.insertStmt.Close()
+}
+
+// CreateEvent stores an event and returns its ID. payload must be JSON.
+func (s *EventService) CreateEvent(ctx context.Context, documentId, userId int, eventType, payload string) (int, error) {
+	var eventId int
+	err := s.insertStmt.QueryRowContext(ctx, documentId, userId, eventType, payload).Scan(&eventId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create event: %v", err)
+	}
+
+	s.Meter.Add(documentId, userId, usage.MetricEvents, 1)
+	return eventId, nil
+}
+
+// NewEvent is an event to store with CopyEvents. Payload must be JSON.
+type NewEvent struct {
+	DocumentId int
+	UserId     int
+	EventType  string
+	Payload    string
+	// Version is the document version an edit produced. It is left NULL
+	// when zero.
+	Version int
+}
+
+// CopyEvents stores events in bulk with COPY, which is much faster than
+// inserting them one at a time, and returns how many were stored. Either
+// all are stored or none are.
+func (s *EventService) CopyEvents(ctx context.Context, events []NewEvent) (int64, error) {
+	if s.Pool == nil {
+		return 0, errors.New("copying events requires a connection pool")
+	}
+
+	rows := pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+		event := events[i]
+		var version any
+		if event.Version != 0 {
+			version = event.Version
+		}
+		return []any{event.DocumentId, event.UserId, event.EventType, event.Payload, version}, nil
+	})
+	count, err := s.Pool.CopyFrom(ctx, pgx.Identifier{"events"}, []string{"document_id", "user_id", "event_type", "payload", "version"}, rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy events: %v", err)
+	}
+
+	for _, event := range events {
+		s.Meter.Add(event.DocumentId, event.UserId, usage.MetricEvents, 1)
+	}
+	return count, nil
+}
+
+// ListEvents returns a page of a document's events created at or after
+// since, newest first. events is partitioned by month, so a recent since
+// only reads the latest partitions; the zero time lists every event.
+func (s *EventService) ListEvents(ctx context.Context, documentId int, since time.Time, limit, offset int) ([]Event, error) {
+	rows, err := s.listStmt.QueryContext(ctx, documentId, since, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.DocumentId, &event.UserId, &event.EventType, &event.Payload, &event.CreatedAt, &event.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %v", err)
+	}
+	return events, nil
+}
+
+// CreatePartitions creates the monthly partitions of the events table from
+// the current month through monthsAhead months later, and returns how many
+// were missing. Inserts fail for months without a partition, so this runs
+// periodically well ahead of time.
+func (s *EventService) CreatePartitions(ctx context.Context, monthsAhead int) (int, error) {
+	var created int
+	if err := s.DB.QueryRowContext(ctx, "SELECT create_events_partitions($1)", monthsAhead).Scan(&created); err != nil {
+		return 0, fmt.Errorf("failed to create event partitions: %v", err)
+	}
+	return created, nil
+}
+
+// ListEventsAfter returns up to limit of a document's events with IDs
+// greater than afterId, oldest first.
+func (s *EventService) ListEventsAfter(ctx context.Context, documentId, afterId, limit int) ([]Event, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events WHERE document_id = $1 AND id > $2
+		ORDER BY id LIMIT $3`, documentId, afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.DocumentId, &event.UserId, &event.EventType, &event.Payload, &event.CreatedAt, &event.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %v", err)
+	}
+	return events, nil
+}
+
+// SearchFilter narrows an event search to a document or a user's events,
+// matching Text anywhere in the payload or payloads containing Contains.
+type SearchFilter struct {
+	DocumentId int
+	UserId     int
+	EventType  string
+	// Text matches the payload's JSON text case-insensitively, so characters
+	// JSON escapes, such as quotes, must be searched for escaped.
+	Text string
+	// Contains is a JSON value the payload must contain, as with @>.
+	Contains     string
+	Since, Until time.Time
+}
+
+// likeEscaper escapes the LIKE wildcards in search text.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchEvents returns a page of the events matching filter, newest first.
+func (s *EventService) SearchEvents(ctx context.Context, filter SearchFilter, limit, offset int) ([]Event, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.DocumentId != 0 {
+		add("document_id = $%d", filter.DocumentId)
+	}
+	if filter.UserId != 0 {
+		add("user_id = $%d", filter.UserId)
+	}
+	if filter.EventType != "" {
+		add("event_type = $%d", filter.EventType)
+	}
+	if filter.Text != "" {
+		add("payload::text ILIKE $%d", "%"+likeEscaper.Replace(filter.Text)+"%")
+	}
+	if filter.Contains != "" {
+		add("payload @> $%d::jsonb", filter.Contains)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+
+	query := "SELECT " + eventColumns + " FROM events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.DocumentId, &event.UserId, &event.EventType, &event.Payload, &event.CreatedAt, &event.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search events: %v", err)
+	}
+	return events, nil
+}
+
+// Session is a stretch of a user's activity on a document: events with no
+// gap between them longer than the session gap.
+type Session struct {
+	UserId    int       `json:"user_id" example:"2"`
+	Email     string    `json:"email" example:"ada@example.com"`
+	StartedAt time.Time `json:"started_at" example:"2024-01-15T10:30:00Z"`
+	EndedAt   time.Time `json:"ended_at" example:"2024-01-15T11:05:00Z"`
+	// DurationSeconds is the time from the session's first event to its
+	// last, so a single event makes a session of zero seconds.
+	DurationSeconds int64 `json:"duration_seconds" example:"2100"`
+	Events          int   `json:"events" example:"312"`
+}
+
+// ListSessions groups a document's events created in [since, until) into
+// each user's sessions, splitting them wherever a user was idle for longer
+// than gap, and returns up to limit sessions, most recent first.
+func (s *EventService) ListSessions(ctx context.Context, documentId int, since, until time.Time, gap time.Duration, limit int) ([]Session, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH marked AS (
+			SELECT user_id, created_at,
+				CASE WHEN created_at - LAG(created_at) OVER (PARTITION BY user_id ORDER BY created_at) <= $4 * interval '1 second'
+					THEN 0 ELSE 1 END AS starts_session
+			FROM events
+			WHERE document_id = $1 AND created_at >= $2 AND created_at < $3 AND user_id IS NOT NULL
+		), numbered AS (
+			SELECT user_id, created_at,
+				SUM(starts_session) OVER (PARTITION BY user_id ORDER BY created_at) AS session
+			FROM marked
+		)
+		SELECT n.user_id, u.email, MIN(n.created_at), MAX(n.created_at), COUNT(*)
+		FROM numbered n
+		JOIN users u ON u.id = n.user_id
+		GROUP BY n.user_id, u.email, n.session
+		ORDER BY MIN(n.created_at) DESC
+		LIMIT $5
+	`, documentId, since, until, int(gap.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.UserId, &session.Email, &session.StartedAt, &session.EndedAt, &session.Events); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %v", err)
+		}
+		session.DurationSeconds = int64(session.EndedAt.Sub(session.StartedAt).Seconds())
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,133 @@
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestWriteArchive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, encrypted FROM documents WHERE owner_id = $1")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "encrypted"}).
+			AddRow(1, "Plans: Q3/Q4", false).
+			AddRow(2, "Notes", false).
+			AddRow(3, "Gone", false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, '') FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow("# Plans"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, '') FROM documents WHERE id = $1")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow("a < b"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, '') FROM documents WHERE id = $1")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}))
+
+	service := &ExportService{DB: db}
+	var buf bytes.Buffer
+	count, err := service.writeArchive(context.Background(), &buf, 7, FormatMarkdown, map[int]string{2: FormatHTML})
+	if err != nil {
+		t.Fatalf("Error writing archive: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 documents, got %d", count)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Error reading archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Error opening %s: %v", f.Name, err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(body)
+	}
+
+	if got := files["documents/Plans_ Q3_Q4-1.md"]; got != "# Plans" {
+		t.Errorf("Expected the Markdown document as it is, got files %v", files)
+	}
+	if got := files["documents/Notes-2.html"]; !strings.Contains(got, "<pre>a &lt; b</pre>") {
+		t.Errorf("Expected escaped content in the HTML page, got %q", got)
+	}
+
+	var m manifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &m); err != nil {
+		t.Fatalf("Error decoding manifest: %v", err)
+	}
+	if len(m.Documents) != 2 || m.Documents[1].Format != FormatHTML || m.Documents[1].File != "documents/Notes-2.html" {
+		t.Errorf("Unexpected manifest: %+v", m.Documents)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := map[string]string{
+		"Meeting notes":          "Meeting notes",
+		"a/b\\c:d":               "a_b_c_d",
+		"  ..hidden.. ":          "hidden",
+		"":                       "untitled",
+		"line\nbreak":            "line_break",
+		"café <draft>?":          "café _draft__",
+		strings.Repeat("é", 150): strings.Repeat("é", maxFileNameLength),
+	}
+	for title, expected := range tests {
+		if got := fileName(title); got != expected {
+			t.Errorf("fileName(%q) = %q, expected %q", title, got, expected)
+		}
+	}
+}
+
+func TestRequestExport_StorageDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &ExportHandler{ExportService: &ExportService{}}
+	router := gin.New()
+	router.POST("/api/exports", handler.RequestExport)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/exports", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
+
+func TestRequestExport_InvalidFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &ExportHandler{ExportService: &ExportService{}}
+	router := gin.New()
+	router.POST("/api/exports", handler.RequestExport)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/exports", strings.NewReader(`{"format":"pdf"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
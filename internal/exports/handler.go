@@ -0,0 +1,129 @@
+package exports
+
+import (
+	"errors"
+	"live-collab-api/internal/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportHandler struct {
+	ExportService *ExportService
+}
+
+// RequestExport godoc
+// @Summary Export all my documents
+// @Description Queue a ZIP archive of every document you own, built in the background. Each document is written in format (markdown by default) unless formats names another for its ID: markdown and text keep the content as it is, html wraps it in a standalone page, and json is the full archive with collaborators and history. Encrypted documents are always written as json. The archive also holds a manifest.json listing the documents. Poll GET /api/exports/{id} for a download link, which expires after a while. Only one export is built at a time.
+// @Tags exports
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body RequestExportRequest false "Formats"
+// @Param Idempotency-Key header string false "Key identifying retries of this request; a retry gets the first response back"
+// @Success 202 {object} Export "Export queued"
+// @Failure 400 {object} ErrorResponse "Invalid format"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 409 {object} ErrorResponse "An export is already in progress"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Failure 503 {object} ErrorResponse "Object storage is not configured"
+// @Router /api/exports [post]
+func (h *ExportHandler) RequestExport(c *gin.Context) {
+	var req RequestExportRequest
+	if c.Request.ContentLength != 0 && !validation.BindJSON(c, &req) {
+		return
+	}
+	if req.Format == "" {
+		req.Format = FormatMarkdown
+	}
+
+	export, err := h.ExportService.RequestExport(c.Request.Context(), c.GetInt("userId"), req.Format, req.Formats)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrStorageDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exports are not available"})
+		case errors.Is(err, ErrExportInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "An export is already in progress"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request export"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+// GetExports godoc
+// @Summary List my exports
+// @Description List your 50 most recent exports, newest first, with download links for completed ones that haven't expired.
+// @Tags exports
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ExportListResponse "List of exports"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/exports [get]
+func (h *ExportHandler) GetExports(c *gin.Context) {
+	exports, err := h.ExportService.GetExports(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// GetExport godoc
+// @Summary Get an export
+// @Description Get an export's status, and once it is completed a download link valid until the export expires.
+// @Tags exports
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Export ID"
+// @Success 200 {object} Export "Export"
+// @Failure 400 {object} ErrorResponse "Invalid export ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "Export not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/exports/{id} [get]
+func (h *ExportHandler) GetExport(c *gin.Context) {
+	exportId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, err := h.ExportService.GetExport(c.Request.Context(), c.GetInt("userId"), exportId)
+	if err != nil {
+		if errors.Is(err, ErrExportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get export"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// swagger models for exports
+
+type RequestExportRequest struct {
+	// Format is markdown (the default), text, html, or json.
+	Format string `json:"format" binding:"omitempty,oneof=markdown text html json" example:"markdown"`
+	// Formats overrides Format for single documents, by document ID.
+	Formats map[int]string `json:"formats" binding:"max=10000,dive,oneof=markdown text html json"`
+}
+
+type ExportListResponse struct {
+	Exports []Export `json:"exports"`
+}
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
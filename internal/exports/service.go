@@ -0,0 +1,482 @@
+package exports
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"live-collab-api/internal/admin"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/jobs"
+	"live-collab-api/internal/storage"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// JobBuild is the job type that builds an export's archive.
+const JobBuild = "exports.build"
+
+// Export statuses.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusExpired   = "expired"
+)
+
+// Document formats. Markdown and text write the content as it is, HTML
+// wraps it in a standalone page, and JSON writes the same archive as the
+// admin export, with collaborators and history, which can be imported
+// again.
+const (
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+)
+
+var extensions = map[string]string{
+	FormatMarkdown: ".md",
+	FormatText:     ".txt",
+	FormatHTML:     ".html",
+	FormatJSON:     ".json",
+}
+
+// maxFileNameLength caps the title part of file names in the archive.
+const maxFileNameLength = 100
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	// ErrExportInProgress is returned when the user already has an export
+	// being built.
+	ErrExportInProgress = errors.New("an export is already in progress")
+	// ErrStorageDisabled is returned when object storage isn't configured.
+	ErrStorageDisabled = errors.New("object storage is not configured")
+)
+
+type ExportService struct {
+	DB *sql.DB
+	// Store keeps the archives. When nil exports are disabled.
+	Store *storage.Store
+	// Archives exports documents in the JSON format.
+	Archives *admin.AdminService
+	Jobs     *jobs.Runner
+	// TTL is how long a completed archive can be downloaded.
+	TTL time.Duration
+}
+
+type Export struct {
+	ID     int    `json:"id"`
+	Status string `json:"status" example:"completed"`
+	Format string `json:"format" example:"markdown"`
+	// Formats overrides Format for single documents, by document ID.
+	Formats   map[int]string `json:"formats,omitempty"`
+	Documents *int           `json:"documents,omitempty" example:"12"`
+	Size      *int64         `json:"size,omitempty" example:"48213"`
+	Error     *string        `json:"error,omitempty"`
+	// DownloadURL is set for completed exports until they expire.
+	DownloadURL string  `json:"download_url,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at,omitempty"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+
+	objectKey sql.NullString
+	expiresAt sql.NullTime
+}
+
+// exportColumns are the columns scanned by scanExport.
+const exportColumns = "id, status, format, formats, document_count, size, error, created_at, completed_at, expires_at, object_key"
+
+func scanExport(row interface{ Scan(dest ...any) error }) (*Export, error) {
+	var export Export
+	var formats []byte
+	var completedAt sql.NullTime
+	err := row.Scan(&export.ID, &export.Status, &export.Format, &formats, &export.Documents, &export.Size, &export.Error,
+		&export.CreatedAt, &completedAt, &export.expiresAt, &export.objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(formats, &export.Formats); err != nil {
+		return nil, fmt.Errorf("failed to decode formats: %v", err)
+	}
+	if completedAt.Valid {
+		completed := completedAt.Time.UTC().Format(time.RFC3339)
+		export.CompletedAt = &completed
+	}
+	if export.expiresAt.Valid {
+		expires := export.expiresAt.Time.UTC().Format(time.RFC3339)
+		export.ExpiresAt = &expires
+	}
+	return &export, nil
+}
+
+// withDownloadURL signs a download URL for a completed export, valid until
+// it expires.
+func (s *ExportService) withDownloadURL(export *Export) *Export {
+	if s.Store != nil && export.Status == StatusCompleted && export.objectKey.Valid && export.expiresAt.Valid {
+		if remaining := time.Until(export.expiresAt.Time); remaining > 0 {
+			export.DownloadURL = s.Store.URL(export.objectKey.String, remaining)
+		}
+	}
+	return export
+}
+
+// RequestExport queues an export of every document the user owns, each in
+// format unless formats names another for it.
+func (s *ExportService) RequestExport(ctx context.Context, userId int, format string, formats map[int]string) (*Export, error) {
+	if s.Store == nil {
+		return nil, ErrStorageDisabled
+	}
+	if formats == nil {
+		formats = map[int]string{}
+	}
+	encoded, err := json.Marshal(formats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode formats: %v", err)
+	}
+
+	// only one export per user is built at a time
+	export, err := scanExport(s.DB.QueryRowContext(ctx, `
+		INSERT INTO exports (user_id, format, formats)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM exports WHERE user_id = $1 AND status = 'pending')
+		RETURNING `+exportColumns, userId, format, string(encoded)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExportInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export: %v", err)
+	}
+
+	job := jobs.Job{
+		Type:      JobBuild,
+		Payload:   BuildJob{ExportId: export.ID},
+		UniqueKey: fmt.Sprintf("%s:%d", JobBuild, export.ID),
+	}
+	if err := s.Jobs.Enqueue(ctx, job); err != nil {
+		s.fail(ctx, export.ID, err)
+		return nil, err
+	}
+	return export, nil
+}
+
+// GetExports returns the user's exports, newest first.
+func (s *ExportService) GetExports(ctx context.Context, userId int) ([]Export, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+exportColumns+` FROM exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 50
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exports: %v", err)
+	}
+	defer rows.Close()
+
+	exports := []Export{}
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan export: %v", err)
+		}
+		exports = append(exports, *s.withDownloadURL(export))
+	}
+	return exports, rows.Err()
+}
+
+// GetExport returns one of the user's exports.
+func (s *ExportService) GetExport(ctx context.Context, userId, exportId int) (*Export, error) {
+	export, err := scanExport(s.DB.QueryRowContext(ctx, `
+		SELECT `+exportColumns+` FROM exports WHERE id = $1 AND user_id = $2
+	`, exportId, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export: %v", err)
+	}
+	return s.withDownloadURL(export), nil
+}
+
+// BuildJob is the payload of an exports.build job.
+type BuildJob struct {
+	ExportId int `json:"export_id"`
+}
+
+// Build writes a pending export's archive to a temporary file, uploads it,
+// and marks the export completed. A failed build marks the export failed
+// rather than retrying, as the user can simply request another.
+func (s *ExportService) Build(ctx context.Context, exportId int) error {
+	var userId int
+	var format string
+	var encoded []byte
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT user_id, format, formats FROM exports WHERE id = $1 AND status = 'pending'
+	`, exportId).Scan(&userId, &format, &encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		// already built, or the user was deleted
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get export: %v", err)
+	}
+
+	var formats map[int]string
+	if err := json.Unmarshal(encoded, &formats); err != nil {
+		s.fail(ctx, exportId, err)
+		return jobs.Permanent(err)
+	}
+
+	if err := s.build(ctx, exportId, userId, format, formats); err != nil {
+		s.fail(ctx, exportId, err)
+		return jobs.Permanent(err)
+	}
+	return nil
+}
+
+func (s *ExportService) build(ctx context.Context, exportId, userId int, format string, formats map[int]string) error {
+	file, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	count, err := s.writeArchive(ctx, file, userId, format, formats)
+	if err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to size archive: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind archive: %v", err)
+	}
+
+	key := storage.NewKey(storage.PrefixExports, "export.zip")
+	if err := s.Store.Put(ctx, userId, key, file, size, "application/zip"); err != nil {
+		return fmt.Errorf("failed to upload archive: %v", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE exports
+		SET status = 'completed', object_key = $2, size = $3, document_count = $4,
+			completed_at = now(), expires_at = now() + $5 * interval '1 second'
+		WHERE id = $1
+	`, exportId, key, size, count, int(s.TTL.Seconds()))
+	if err != nil {
+		// the export is marked failed, so expiry would never delete the
+		// archive
+		s.Store.Delete(ctx, key)
+		return fmt.Errorf("failed to complete export: %v", err)
+	}
+	return nil
+}
+
+func (s *ExportService) fail(ctx context.Context, exportId int, cause error) {
+	slog.WarnContext(ctx, "Export failed", "export_id", exportId, "error", cause)
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE exports SET status = 'failed', error = $2, completed_at = now() WHERE id = $1
+	`, exportId, cause.Error())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record failed export", "export_id", exportId, "error", err)
+	}
+}
+
+// ManifestEntry describes a file in an export archive.
+type ManifestEntry struct {
+	DocumentId int    `json:"document_id"`
+	Title      string `json:"title"`
+	File       string `json:"file"`
+	Format     string `json:"format"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+}
+
+type manifest struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	Documents  []ManifestEntry `json:"documents"`
+}
+
+type ownedDocument struct {
+	id        int
+	title     string
+	encrypted bool
+}
+
+// writeArchive writes a ZIP of the user's documents and a manifest.json
+// listing them to w, and returns how many documents it holds. Encrypted
+// documents are always written as JSON archives, as their content is
+// ciphertext that can't be converted.
+func (s *ExportService) writeArchive(ctx context.Context, w io.Writer, userId int, format string, formats map[int]string) (int, error) {
+	owned, err := s.ownedDocuments(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	archive := zip.NewWriter(w)
+	m := manifest{ExportedAt: time.Now().UTC(), Documents: []ManifestEntry{}}
+	for _, doc := range owned {
+		docFormat := format
+		if override, ok := formats[doc.id]; ok {
+			docFormat = override
+		}
+		if doc.encrypted {
+			docFormat = FormatJSON
+		}
+
+		name := fmt.Sprintf("documents/%s-%d%s", fileName(doc.title), doc.id, extensions[docFormat])
+		body, err := s.render(ctx, doc, docFormat)
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			// deleted while the export was running
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		file, err := archive.Create(name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add %s: %v", name, err)
+		}
+		if _, err := file.Write(body); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		m.Documents = append(m.Documents, ManifestEntry{DocumentId: doc.id, Title: doc.title, File: name, Format: docFormat, Encrypted: doc.encrypted})
+	}
+
+	file, err := archive.Create("manifest.json")
+	if err != nil {
+		return 0, fmt.Errorf("failed to add manifest: %v", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return 0, fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return 0, fmt.Errorf("failed to finish archive: %v", err)
+	}
+	return len(m.Documents), nil
+}
+
+func (s *ExportService) ownedDocuments(ctx context.Context, userId int) ([]ownedDocument, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, title, encrypted FROM documents WHERE owner_id = $1 ORDER BY id
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get documents: %v", err)
+	}
+	defer rows.Close()
+
+	var owned []ownedDocument
+	for rows.Next() {
+		var doc ownedDocument
+		if err := rows.Scan(&doc.id, &doc.title, &doc.encrypted); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+		owned = append(owned, doc)
+	}
+	return owned, rows.Err()
+}
+
+// render reads one document in the given format. Content is read per
+// document so large workspaces aren't held in memory at once.
+func (s *ExportService) render(ctx context.Context, doc ownedDocument, format string) ([]byte, error) {
+	if format == FormatJSON {
+		archive, err := s.Archives.ExportDocument(ctx, doc.id)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(archive, "", "  ")
+	}
+
+	var content string
+	err := s.DB.QueryRowContext(ctx, "SELECT COALESCE(content, '') FROM documents WHERE id = $1", doc.id).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, documents.ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document %d: %v", doc.id, err)
+	}
+
+	if format == FormatHTML {
+		title := html.EscapeString(doc.title)
+		return []byte("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + title + "</title>\n</head>\n<body>\n<h1>" +
+			title + "</h1>\n<pre>" + html.EscapeString(content) + "</pre>\n</body>\n</html>\n"), nil
+	}
+	return []byte(content), nil
+}
+
+// fileName turns a title into a file name that is safe on common file
+// systems.
+func fileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		name = string([]rune(name)[:maxFileNameLength])
+	}
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
+
+// ExpireExports deletes the archives of completed exports past their
+// expiry and returns how many were expired.
+func (s *ExportService) ExpireExports(ctx context.Context) (int, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, object_key FROM exports
+		WHERE status = 'completed' AND expires_at < now()
+		ORDER BY expires_at
+		LIMIT 500
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired exports: %v", err)
+	}
+	type expired struct {
+		id  int
+		key string
+	}
+	var due []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan export: %v", err)
+		}
+		due = append(due, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get expired exports: %v", err)
+	}
+
+	for i, e := range due {
+		if err := s.Store.Delete(ctx, e.key); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return i, err
+		}
+		_, err := s.DB.ExecContext(ctx, `
+			UPDATE exports SET status = 'expired', object_key = NULL WHERE id = $1
+		`, e.id)
+		if err != nil {
+			return i, fmt.Errorf("failed to expire export: %v", err)
+		}
+	}
+	return len(due), nil
+}
@@ -0,0 +1,126 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"live-collab-api/internal/auth"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys read by the interceptors.
+const (
+	authorizationKey = "authorization"
+	apiKeyKey        = "x-api-key"
+	userIdKey        = "x-user-id"
+)
+
+type contextKey struct{}
+
+// Authenticator identifies the user a call acts for, either from a JWT in
+// the authorization metadata or from a service API key in x-api-key with
+// the user's ID in x-user-id.
+type Authenticator struct {
+	AuthService *auth.AuthService
+	// APIKeys are the keys internal services authenticate with. API key
+	// calls may act for any user.
+	APIKeys []string
+}
+
+// ParseAPIKeys splits a comma-separated list of API keys.
+func ParseAPIKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// UnaryInterceptor rejects unauthenticated unary calls.
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor rejects unauthenticated streaming calls.
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns ctx carrying the ID of the user the call acts for.
+func (a *Authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if key := first(md, apiKeyKey); key != "" {
+		if !a.validAPIKey(key) {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		userId, err := strconv.Atoi(first(md, userIdKey))
+		if err != nil || userId <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "x-user-id is required with an API key")
+		}
+		return context.WithValue(ctx, contextKey{}, userId), nil
+	}
+
+	userId, err := a.AuthService.GetUserIDFromAuthHeader(ctx, first(md, authorizationKey))
+	if errors.Is(err, auth.ErrSessionUnavailable) {
+		return nil, status.Error(codes.Unavailable, "session could not be checked")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing authentication token")
+	}
+	return context.WithValue(ctx, contextKey{}, userId), nil
+}
+
+func (a *Authenticator) validAPIKey(key string) bool {
+	valid := false
+	for _, apiKey := range a.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// UserID returns the user an authenticated call acts for.
+func UserID(ctx context.Context) int {
+	userId, _ := ctx.Value(contextKey{}).(int)
+	return userId
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authenticatedStream overrides the stream's context with the
+// authenticated one.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,191 @@
+package grpcapi
+
+import (
+	"context"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/grpcapi/collabpb"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	testSecret = "test-secret"
+	testAPIKey = "internal-service-key"
+)
+
+// setupGRPCTest serves the API over an in-memory connection and returns a
+// client connection to it.
+func setupGRPCTest(t *testing.T) (*grpc.ClientConn, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	documentService := &documents.DocumentService{DB: db}
+	server := NewServer(
+		&Authenticator{AuthService: &auth.AuthService{DB: db, JWTSecret: testSecret}, APIKeys: []string{testAPIKey}},
+		&DocumentServer{DocumentService: documentService},
+		&EventServer{EventService: &events.EventService{DB: db}, DocumentService: documentService},
+	)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Error dialing server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, mock
+}
+
+func bearerContext(t *testing.T, userId int) context.Context {
+	token, err := auth.GenerateJWT(userId, testSecret)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func expectAccess(mock sqlmock.Sqlmock, documentId, userId int, hasAccess bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(")).
+		WithArgs(documentId, userId).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(hasAccess))
+}
+
+func TestAuthentication(t *testing.T) {
+	conn, _ := setupGRPCTest(t)
+	client := collabpb.NewDocumentServiceClient(conn)
+
+	tests := []struct {
+		name     string
+		md       []string
+		expected codes.Code
+	}{
+		{"no credentials", nil, codes.Unauthenticated},
+		{"invalid token", []string{"authorization", "Bearer nope"}, codes.Unauthenticated},
+		{"wrong API key", []string{"x-api-key", "nope", "x-user-id", "1"}, codes.Unauthenticated},
+		{"API key without user", []string{"x-api-key", testAPIKey}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), tt.md...)
+		_, err := client.ListDocuments(ctx, &collabpb.ListDocumentsRequest{})
+		if status.Code(err) != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestGetDocument_APIKeyActsForUser(t *testing.T) {
+	conn, mock := setupGRPCTest(t)
+	client := collabpb.NewDocumentServiceClient(conn)
+
+	expectAccess(mock, 5, 3, true)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM documents WHERE id = $1")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(5, "Plan", "hello", "text/plain", 1, "2024-01-01T00:00:00Z", 2, false, nil))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", testAPIKey, "x-user-id", "3")
+	document, err := client.GetDocument(ctx, &collabpb.GetDocumentRequest{Id: 5})
+	if err != nil {
+		t.Fatalf("GetDocument failed: %v", err)
+	}
+
+	if document.GetTitle() != "Plan" || document.GetOwnerId() != 1 || document.GetOrganizationId() != 2 {
+		t.Errorf("Unexpected document %v", document)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetDocument_AccessDenied(t *testing.T) {
+	conn, mock := setupGRPCTest(t)
+	client := collabpb.NewDocumentServiceClient(conn)
+
+	expectAccess(mock, 5, 3, false)
+
+	_, err := client.GetDocument(bearerContext(t, 3), &collabpb.GetDocumentRequest{Id: 5})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestAppendEvent_ViewerDenied(t *testing.T) {
+	conn, mock := setupGRPCTest(t)
+	client := collabpb.NewEventServiceClient(conn)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("view"))
+
+	_, err := client.AppendEvent(bearerContext(t, 3), &collabpb.AppendEventRequest{
+		DocumentId: 5,
+		EventType:  "text_insert",
+		Payload:    `{"position":0,"text":"hi"}`,
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+
+	_, err = client.AppendEvent(bearerContext(t, 3), &collabpb.AppendEventRequest{DocumentId: 5, EventType: "bogus", Payload: "{}"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for unknown event type, got %v", err)
+	}
+}
+
+func TestStreamEvents_SendsBacklog(t *testing.T) {
+	conn, mock := setupGRPCTest(t)
+	client := collabpb.NewEventServiceClient(conn)
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expectAccess(mock, 5, 3, true)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events WHERE document_id = $1 AND id > $2")).
+		WithArgs(5, 10, streamBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "user_id", "event_type", "payload", "created_at", "updated_at"}).
+			AddRow(11, 5, 1, "text_insert", []byte(`{"text":"a"}`), createdAt, createdAt).
+			AddRow(12, 5, 1, "text_delete", []byte(`{"length":1}`), createdAt, createdAt))
+
+	ctx, cancel := context.WithCancel(bearerContext(t, 3))
+	defer cancel()
+
+	stream, err := client.StreamEvents(ctx, &collabpb.StreamEventsRequest{DocumentId: 5, AfterId: 10})
+	if err != nil {
+		t.Fatalf("StreamEvents failed: %v", err)
+	}
+
+	for _, expected := range []int64{11, 12} {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if event.GetId() != expected {
+			t.Errorf("Expected event %d, got %d", expected, event.GetId())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package grpcapi
+
+import (
+	"context"
+	"live-collab-api/internal/grpcapi/collabpb"
+	"live-collab-api/internal/maintenance"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeMethods are the calls rejected while the API is in maintenance.
+var writeMethods = map[string]bool{
+	collabpb.DocumentService_CreateDocument_FullMethodName: true,
+	collabpb.DocumentService_UpdateDocument_FullMethodName: true,
+	collabpb.DocumentService_DeleteDocument_FullMethodName: true,
+	collabpb.EventService_AppendEvent_FullMethodName:       true,
+}
+
+// MaintenanceInterceptor rejects writes with Unavailable while the API is
+// in maintenance or read-only, setting retry-after in the response metadata.
+func MaintenanceInterceptor(mode *maintenance.Mode) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if writeMethods[info.FullMethod] {
+			state := mode.State()
+			if state.Enabled {
+				grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(state.RetryAfter)))
+				return nil, status.Error(codes.Unavailable, "the service is in maintenance")
+			}
+			if state.ReadOnly {
+				grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(maintenance.ReadOnlyRetryAfter.Seconds()))))
+				return nil, status.Error(codes.Unavailable, "the database is unavailable, so the service is read-only")
+			}
+		}
+		return handler(ctx, req)
+	}
+}
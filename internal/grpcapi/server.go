@@ -0,0 +1,351 @@
+// Package grpcapi serves documents and their event log over gRPC for
+// internal services. The services are defined in proto/collab/v1.
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/grpcapi/collabpb"
+	"live-collab-api/internal/webhooks"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// streamPollInterval is how often StreamEvents checks for new events.
+	streamPollInterval = time.Second
+	// streamBatchSize is the most events StreamEvents reads at once.
+	streamBatchSize = 500
+)
+
+// NewServer returns a gRPC server with the document and event services
+// registered behind the authenticator.
+func NewServer(authenticator *Authenticator, documentServer *DocumentServer, eventServer *EventServer, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+	)
+	server := grpc.NewServer(opts...)
+	collabpb.RegisterDocumentServiceServer(server, documentServer)
+	collabpb.RegisterEventServiceServer(server, eventServer)
+	return server
+}
+
+// DocumentServer implements the DocumentService with the same access rules
+// as the HTTP API.
+type DocumentServer struct {
+	collabpb.UnimplementedDocumentServiceServer
+
+	DocumentService *documents.DocumentService
+	// Webhooks, when set, delivers document changes to the owner's
+	// webhooks.
+	Webhooks *webhooks.Dispatcher
+}
+
+func (s *DocumentServer) CreateDocument(ctx context.Context, req *collabpb.CreateDocumentRequest) (*collabpb.Document, error) {
+	userId := UserID(ctx)
+
+	title := strings.TrimSpace(req.GetTitle())
+	if title == "" {
+		return nil, status.Error(codes.InvalidArgument, "title is required")
+	}
+
+	var organizationId *int
+	if req.GetOrganizationId() != 0 {
+		id := int(req.GetOrganizationId())
+		isMember, err := s.DocumentService.IsOrganizationMember(ctx, id, userId)
+		if err != nil {
+			return nil, internalError(ctx, "failed to verify organization membership", err)
+		}
+		if !isMember {
+			return nil, status.Error(codes.PermissionDenied, "not a member of this organization")
+		}
+		organizationId = &id
+	}
+
+	document, err := s.DocumentService.CreateDocument(ctx, title, userId, req.GetContent(), organizationId)
+	if err != nil {
+		return nil, internalError(ctx, "failed to create document", err)
+	}
+
+	s.Webhooks.Dispatch(ctx, userId, webhooks.EventDocumentCreated, map[string]interface{}{
+		"document_id": document.ID,
+		"title":       document.Title,
+		"user_id":     userId,
+	})
+
+	return documentToProto(document), nil
+}
+
+func (s *DocumentServer) GetDocument(ctx context.Context, req *collabpb.GetDocumentRequest) (*collabpb.Document, error) {
+	documentId := int(req.GetId())
+	if err := checkAccess(ctx, s.DocumentService, documentId); err != nil {
+		return nil, err
+	}
+
+	document, err := s.getDocument(ctx, documentId)
+	if err != nil {
+		return nil, err
+	}
+	return documentToProto(document), nil
+}
+
+func (s *DocumentServer) ListDocuments(ctx context.Context, req *collabpb.ListDocumentsRequest) (*collabpb.ListDocumentsResponse, error) {
+	documents, err := s.DocumentService.GetUserDocuments(ctx, UserID(ctx))
+	if err != nil {
+		return nil, internalError(ctx, "failed to get documents", err)
+	}
+
+	resp := &collabpb.ListDocumentsResponse{Documents: make([]*collabpb.Document, 0, len(documents))}
+	for i := range documents {
+		resp.Documents = append(resp.Documents, documentToProto(&documents[i]))
+	}
+	return resp, nil
+}
+
+func (s *DocumentServer) UpdateDocument(ctx context.Context, req *collabpb.UpdateDocumentRequest) (*collabpb.Document, error) {
+	documentId := int(req.GetId())
+	title := strings.TrimSpace(req.GetTitle())
+	if title == "" {
+		return nil, status.Error(codes.InvalidArgument, "title is required")
+	}
+
+	if err := checkAccess(ctx, s.DocumentService, documentId); err != nil {
+		return nil, err
+	}
+
+	if err := s.DocumentService.UpdateDocumentTitle(ctx, documentId, title); err != nil {
+		return nil, internalError(ctx, "failed to update document", err)
+	}
+
+	document, err := s.getDocument(ctx, documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Webhooks.Dispatch(ctx, document.OwnerId, webhooks.EventDocumentUpdated, map[string]interface{}{
+		"document_id": documentId,
+		"title":       title,
+		"user_id":     UserID(ctx),
+	})
+
+	return documentToProto(document), nil
+}
+
+func (s *DocumentServer) DeleteDocument(ctx context.Context, req *collabpb.DeleteDocumentRequest) (*collabpb.DeleteDocumentResponse, error) {
+	documentId := int(req.GetId())
+	if err := checkAccess(ctx, s.DocumentService, documentId); err != nil {
+		return nil, err
+	}
+
+	// The owner can't be looked up once the document is gone
+	document, err := s.getDocument(ctx, documentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.DocumentService.DeleteDocument(ctx, documentId); err != nil {
+		return nil, internalError(ctx, "failed to delete document", err)
+	}
+
+	s.Webhooks.Dispatch(ctx, document.OwnerId, webhooks.EventDocumentDeleted, map[string]interface{}{
+		"document_id": documentId,
+		"user_id":     UserID(ctx),
+	})
+
+	return &collabpb.DeleteDocumentResponse{}, nil
+}
+
+// getDocument loads a document the caller was checked to have access to.
+func (s *DocumentServer) getDocument(ctx context.Context, documentId int) (*documents.Document, error) {
+	document, err := s.DocumentService.GetDocument(ctx, documentId)
+	if err != nil {
+		return nil, internalError(ctx, "failed to get document", err)
+	}
+	return document, nil
+}
+
+// EventServer implements the EventService with the same access rules as
+// the HTTP API.
+type EventServer struct {
+	collabpb.UnimplementedEventServiceServer
+
+	EventService    *events.EventService
+	DocumentService *documents.DocumentService
+	// Webhooks, when set, delivers appended events to the document owner's
+	// webhooks.
+	Webhooks *webhooks.Dispatcher
+}
+
+func (s *EventServer) AppendEvent(ctx context.Context, req *collabpb.AppendEventRequest) (*collabpb.Event, error) {
+	userId := UserID(ctx)
+	documentId := int(req.GetDocumentId())
+
+	if !events.ValidEventType(req.GetEventType()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid event type")
+	}
+	if !json.Valid([]byte(req.GetPayload())) {
+		return nil, status.Error(codes.InvalidArgument, "payload must be JSON")
+	}
+
+	permission, err := s.DocumentService.GetPermission(ctx, userId, documentId)
+	if err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			return nil, status.Error(codes.NotFound, "document not found")
+		}
+		return nil, internalError(ctx, "failed to check document permission", err)
+	}
+	if permission != "owner" && permission != "edit" {
+		return nil, status.Error(codes.PermissionDenied, "edit permission required")
+	}
+
+	eventId, err := s.EventService.CreateEvent(ctx, documentId, userId, req.GetEventType(), req.GetPayload())
+	if err != nil {
+		return nil, internalError(ctx, "failed to create event", err)
+	}
+
+	if s.Webhooks != nil {
+		if document, err := s.DocumentService.GetDocument(ctx, documentId); err == nil {
+			s.Webhooks.Dispatch(ctx, document.OwnerId, webhooks.EventEventCreated, map[string]interface{}{
+				"event_id":    eventId,
+				"event_type":  req.GetEventType(),
+				"document_id": documentId,
+				"user_id":     userId,
+			})
+		}
+	}
+
+	return &collabpb.Event{
+		Id:         int64(eventId),
+		DocumentId: int64(documentId),
+		UserId:     int64(userId),
+		EventType:  req.GetEventType(),
+		Payload:    req.GetPayload(),
+		CreatedAt:  timestamppb.Now(),
+	}, nil
+}
+
+func (s *EventServer) ListEvents(ctx context.Context, req *collabpb.ListEventsRequest) (*collabpb.ListEventsResponse, error) {
+	documentId := int(req.GetDocumentId())
+	if err := checkAccess(ctx, s.DocumentService, documentId); err != nil {
+		return nil, err
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	offset := int(req.GetOffset())
+	if offset < 0 {
+		offset = 0
+	}
+
+	events, err := s.EventService.ListEvents(ctx, documentId, time.Time{}, limit, offset)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list events", err)
+	}
+
+	resp := &collabpb.ListEventsResponse{Events: make([]*collabpb.Event, 0, len(events))}
+	for i := range events {
+		resp.Events = append(resp.Events, eventToProto(&events[i]))
+	}
+	return resp, nil
+}
+
+// StreamEvents polls for new events until the client goes away or loses
+// access to the document.
+func (s *EventServer) StreamEvents(req *collabpb.StreamEventsRequest, stream collabpb.EventService_StreamEventsServer) error {
+	ctx := stream.Context()
+	documentId := int(req.GetDocumentId())
+	afterId := int(req.GetAfterId())
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := checkAccess(ctx, s.DocumentService, documentId); err != nil {
+			return err
+		}
+
+		events, err := s.EventService.ListEventsAfter(ctx, documentId, afterId, streamBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			return internalError(ctx, "failed to list events", err)
+		}
+
+		for i := range events {
+			if err := stream.Send(eventToProto(&events[i])); err != nil {
+				return err
+			}
+			afterId = events[i].ID
+		}
+
+		// Catch up without waiting while there's a backlog
+		if len(events) == streamBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAccess returns a PermissionDenied error unless the calling user can
+// access the document.
+func checkAccess(ctx context.Context, documentService *documents.DocumentService, documentId int) error {
+	hasAccess, err := documentService.HasDocumentAccess(ctx, UserID(ctx), documentId)
+	if err != nil {
+		return internalError(ctx, "failed to check document access", err)
+	}
+	if !hasAccess {
+		return status.Error(codes.PermissionDenied, "access denied")
+	}
+	return nil
+}
+
+// internalError logs err and returns an Internal error that doesn't
+// expose it.
+func internalError(ctx context.Context, message string, err error) error {
+	slog.ErrorContext(ctx, message, "error", err)
+	return status.Error(codes.Internal, message)
+}
+
+func documentToProto(document *documents.Document) *collabpb.Document {
+	pb := &collabpb.Document{
+		Id:          int64(document.ID),
+		Title:       document.Title,
+		Content:     document.Content,
+		ContentType: document.ContentType,
+		OwnerId:     int64(document.OwnerId),
+		CreatedAt:   document.CreatedAt,
+	}
+	if document.OrganizationId != nil {
+		pb.OrganizationId = int64(*document.OrganizationId)
+	}
+	return pb
+}
+
+func eventToProto(event *events.Event) *collabpb.Event {
+	return &collabpb.Event{
+		Id:         int64(event.ID),
+		DocumentId: int64(event.DocumentId),
+		UserId:     int64(event.UserId),
+		EventType:  event.EventType,
+		Payload:    string(event.Payload),
+		CreatedAt:  timestamppb.New(event.CreatedAt),
+	}
+}
@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check reports whether a dependency is usable. It should return promptly
+// once ctx is done.
+type Check func(ctx context.Context) error
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	// StatusSkipped marks optional dependencies that aren't configured.
+	StatusSkipped = "skipped"
+	// StatusDraining means the instance is shutting down and shouldn't
+	// receive new traffic.
+	StatusDraining = "draining"
+	// StatusDegraded means a dependency the instance keeps serving without,
+	// in a reduced mode, is unavailable. The instance stays ready.
+	StatusDegraded = "degraded"
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+// Handler serves the liveness and readiness probes.
+type Handler struct {
+	// Timeout bounds each readiness check. Defaults to 2 seconds.
+	Timeout time.Duration
+
+	mutex    sync.Mutex
+	checks   map[string]Check
+	soft     map[string]bool
+	draining atomic.Bool
+}
+
+type CheckResult struct {
+	Status    string  `json:"status" example:"ok"`
+	LatencyMs float64 `json:"latency_ms" example:"1.2"`
+	Error     string  `json:"error,omitempty" example:"context deadline exceeded"`
+}
+
+type LivenessResponse struct {
+	Status string `json:"status" example:"ok"`
+}
+
+type ReadinessResponse struct {
+	Status string                 `json:"status" example:"ok"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// AddCheck registers a dependency that must be healthy for the instance to
+// be ready. A nil check is reported as skipped and doesn't affect
+// readiness.
+func (h *Handler) AddCheck(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.checks == nil {
+		h.checks = make(map[string]Check)
+	}
+	h.checks[name] = check
+}
+
+// AddSoftCheck registers a dependency the instance keeps serving without,
+// such as the database while the API is read-only. When it fails it is
+// reported as degraded and the instance stays ready.
+func (h *Handler) AddSoftCheck(name string, check Check) {
+	h.AddCheck(name, check)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.soft == nil {
+		h.soft = make(map[string]bool)
+	}
+	h.soft[name] = true
+}
+
+// Drain makes the readiness probe fail from now on, so load balancers stop
+// routing new requests and connections to the instance while it shuts
+// down. Liveness is unaffected.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// Liveness godoc
+// @Summary Liveness probe
+// @Description Reports that the process is running. Dependencies aren't checked.
+// @Tags health
+// @Produce json
+// @Success 200 {object} LivenessResponse
+// @Router /healthz [get]
+func (h *Handler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, LivenessResponse{Status: StatusOK})
+}
+
+// Readiness godoc
+// @Summary Readiness probe
+// @Description Checks every dependency (database, Redis, schema migrations) and reports each one's status and latency. Returns 503 if any required dependency is unavailable, or with status draining while the instance shuts down, so traffic is routed elsewhere. With read-only mode on, an unreachable database is reported as degraded and the instance stays ready to serve reads.
+// @Tags health
+// @Produce json
+// @Success 200 {object} ReadinessResponse "All dependencies are available"
+// @Failure 503 {object} ReadinessResponse "A dependency is unavailable"
+// @Router /readyz [get]
+func (h *Handler) Readiness(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: StatusDraining, Checks: map[string]CheckResult{}})
+		return
+	}
+
+	response := h.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if response.Status == StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
+
+// Check runs every registered check concurrently.
+func (h *Handler) Check(ctx context.Context) ReadinessResponse {
+	h.mutex.Lock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Check, len(names))
+	soft := make([]bool, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+		soft[i] = h.soft[name]
+	}
+	h.mutex.Unlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	response := ReadinessResponse{Status: StatusOK, Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		if results[i].Status == StatusUnavailable && soft[i] {
+			results[i].Status = StatusDegraded
+		}
+		response.Checks[name] = results[i]
+		switch {
+		case results[i].Status == StatusUnavailable:
+			response.Status = StatusUnavailable
+		case results[i].Status == StatusDegraded && response.Status == StatusOK:
+			response.Status = StatusDegraded
+		}
+	}
+	return response
+}
+
+func (h *Handler) run(ctx context.Context, check Check) CheckResult {
+	if check == nil {
+		return CheckResult{Status: StatusSkipped}
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveReadiness(t *testing.T, h *Handler) (int, ReadinessResponse) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", h.Readiness)
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response ReadinessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	return w.Code, response
+}
+
+func TestReadiness_AllHealthy(t *testing.T) {
+	h := &Handler{}
+	h.AddCheck("database", func(context.Context) error { return nil })
+	h.AddCheck("redis", nil)
+
+	code, response := serveReadiness(t, h)
+
+	if code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if response.Status != StatusOK {
+		t.Errorf("Expected status %s, got %s", StatusOK, response.Status)
+	}
+	if response.Checks["database"].Status != StatusOK {
+		t.Errorf("Expected database %s, got %s", StatusOK, response.Checks["database"].Status)
+	}
+	if response.Checks["redis"].Status != StatusSkipped {
+		t.Errorf("Expected redis %s, got %s", StatusSkipped, response.Checks["redis"].Status)
+	}
+}
+
+func TestReadiness_FailingAndSlowDependencies(t *testing.T) {
+	h := &Handler{Timeout: 50 * time.Millisecond}
+	h.AddCheck("database", func(context.Context) error { return errors.New("connection refused") })
+	h.AddCheck("redis", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	h.AddCheck("migrations", func(context.Context) error { return nil })
+
+	code, response := serveReadiness(t, h)
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if response.Status != StatusUnavailable {
+		t.Errorf("Expected status %s, got %s", StatusUnavailable, response.Status)
+	}
+	if got := response.Checks["database"]; got.Status != StatusUnavailable || got.Error != "connection refused" {
+		t.Errorf("Expected database to be unavailable with its error, got %+v", got)
+	}
+	if got := response.Checks["redis"]; got.Status != StatusUnavailable || got.LatencyMs < 50 {
+		t.Errorf("Expected redis to time out after 50ms, got %+v", got)
+	}
+	if got := response.Checks["migrations"]; got.Status != StatusOK {
+		t.Errorf("Expected migrations %s, got %+v", StatusOK, got)
+	}
+}
+
+func TestReadiness_SoftCheckDegrades(t *testing.T) {
+	h := &Handler{}
+	h.AddSoftCheck("database", func(context.Context) error { return errors.New("connection refused") })
+	h.AddCheck("redis", func(context.Context) error { return nil })
+
+	code, response := serveReadiness(t, h)
+
+	if code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if response.Status != StatusDegraded {
+		t.Errorf("Expected status %s, got %s", StatusDegraded, response.Status)
+	}
+	if check := response.Checks["database"]; check.Status != StatusDegraded || check.Error == "" {
+		t.Errorf("Expected database %s with an error, got %+v", StatusDegraded, check)
+	}
+}
+
+func TestReadiness_Draining(t *testing.T) {
+	h := &Handler{}
+	h.AddCheck("database", func(context.Context) error { return nil })
+	h.Drain()
+
+	code, response := serveReadiness(t, h)
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if response.Status != StatusDraining {
+		t.Errorf("Expected status %s, got %s", StatusDraining, response.Status)
+	}
+}
@@ -0,0 +1,161 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header is the request header carrying the client's key.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses returned from the store.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// maxKeyLength bounds the keys clients may send.
+	maxKeyLength = 255
+	// lockTTL is how long a key stays reserved for a request that is still
+	// running, so a crashed instance doesn't block retries for the whole TTL.
+	lockTTL = time.Minute
+)
+
+// Response is a stored response.
+type Response struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+// Record is what is kept for a key.
+type Record struct {
+	// Fingerprint identifies the request the key was first used for.
+	Fingerprint string `json:"fingerprint"`
+	// Response is nil while the first request is still running.
+	Response *Response `json:"response,omitempty"`
+}
+
+// Guard replays the stored response when a client retries a request with
+// the same Idempotency-Key, so retries don't create duplicates. Keys are
+// scoped to the authenticated user.
+type Guard struct {
+	Store Store
+	// TTL is how long responses are kept.
+	TTL time.Duration
+}
+
+// Middleware runs the first request for a key and stores its response;
+// later requests with the key get that response back with an
+// Idempotent-Replayed header. A retry while the first request is running
+// gets 409, and reusing a key for a different request gets 422. Responses
+// with a 5xx status aren't stored so they can be retried. Requests without
+// the header, or without an authenticated user, pass through, as do all
+// requests when the store fails. It must run after the auth middleware.
+func (g *Guard) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		userId := c.GetInt("userId")
+		if key == "" || userId == 0 {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		fingerprint, err := fingerprintRequest(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		storeKey := strconv.Itoa(userId) + ":" + key
+		existing, err := g.Store.Reserve(ctx, storeKey, Record{Fingerprint: fingerprint}, lockTTL)
+		if err != nil {
+			slog.WarnContext(ctx, "Idempotency check failed, running request", "error", err)
+			c.Next()
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case existing.Response == nil:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			default:
+				c.Header(ReplayedHeader, "true")
+				c.Data(existing.Response.Status, existing.Response.ContentType, existing.Response.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &recorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError {
+			if err := g.Store.Release(ctx, storeKey); err != nil {
+				slog.WarnContext(ctx, "Failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		record := Record{
+			Fingerprint: fingerprint,
+			Response: &Response{
+				Status:      status,
+				ContentType: c.Writer.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			},
+		}
+		if err := g.Store.Save(ctx, storeKey, record, g.TTL); err != nil {
+			slog.WarnContext(ctx, "Failed to save idempotent response", "error", err)
+		}
+	}
+}
+
+// fingerprintRequest hashes the method, path, and body of the request,
+// leaving the body readable for the handler.
+func fingerprintRequest(c *gin.Context) (string, error) {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recorder keeps a copy of the response body.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
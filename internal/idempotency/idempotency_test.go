@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupGuardTest serves POST /documents behind the guard, counting how many
+// times the handler runs.
+func setupGuardTest(status *int) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+
+	guard := &Guard{Store: NewMemoryStore(), TTL: time.Hour}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", 1)
+		c.Next()
+	})
+	r.POST("/documents", guard.Middleware(), func(c *gin.Context) {
+		calls++
+		c.JSON(*status, gin.H{"id": calls})
+	})
+	return r, &calls
+}
+
+func post(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/documents", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGuard_ReplaysResponse(t *testing.T) {
+	status := http.StatusCreated
+	r, calls := setupGuardTest(&status)
+
+	first := post(r, "abc", `{"title":"Plan"}`)
+	second := post(r, "abc", `{"title":"Plan"}`)
+
+	if *calls != 1 {
+		t.Errorf("Expected handler to run once, ran %d times", *calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("Expected replayed 201 %s, got %d %s", first.Body.String(), second.Code, second.Body.String())
+	}
+	if second.Header().Get(ReplayedHeader) != "true" {
+		t.Error("Expected replayed response to be marked")
+	}
+	if first.Header().Get(ReplayedHeader) != "" {
+		t.Error("Expected first response not to be marked")
+	}
+
+	post(r, "", `{"title":"Plan"}`)
+	post(r, "", `{"title":"Plan"}`)
+	if *calls != 3 {
+		t.Errorf("Expected requests without a key to run, ran %d times", *calls)
+	}
+}
+
+func TestGuard_RejectsReuseForDifferentRequest(t *testing.T) {
+	status := http.StatusCreated
+	r, _ := setupGuardTest(&status)
+
+	post(r, "abc", `{"title":"Plan"}`)
+	w := post(r, "abc", `{"title":"Other"}`)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestGuard_DoesNotStoreServerErrors(t *testing.T) {
+	status := http.StatusInternalServerError
+	r, calls := setupGuardTest(&status)
+
+	post(r, "abc", `{"title":"Plan"}`)
+	status = http.StatusCreated
+	w := post(r, "abc", `{"title":"Plan"}`)
+
+	if *calls != 2 || w.Code != http.StatusCreated {
+		t.Errorf("Expected retry after a server error to run, got %d calls and status %d", *calls, w.Code)
+	}
+}
+
+func TestGuard_InFlightConflict(t *testing.T) {
+	status := http.StatusCreated
+	r, _ := setupGuardTest(&status)
+	store := NewMemoryStore()
+	guard := &Guard{Store: store, TTL: time.Hour}
+	r.POST("/slow", func(c *gin.Context) {
+		c.Set("userId", 1)
+		c.Next()
+	}, guard.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	// a request with this key is already running
+	req, _ := http.NewRequest("POST", "/slow", nil)
+	fingerprint, _ := fingerprintRequest(&gin.Context{Request: req})
+	store.Reserve(context.Background(), "1:abc", Record{Fingerprint: fingerprint}, time.Minute)
+
+	req.Header.Set(Header, "abc")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	if existing, _ := store.Reserve(context.Background(), "k", Record{Fingerprint: "a"}, time.Minute); existing != nil {
+		t.Fatal("Expected a new key to be reserved")
+	}
+	if existing, _ := store.Reserve(context.Background(), "k", Record{Fingerprint: "b"}, time.Minute); existing == nil || existing.Fingerprint != "a" {
+		t.Fatalf("Expected the first record, got %+v", existing)
+	}
+
+	now = now.Add(time.Minute)
+	if existing, _ := store.Reserve(context.Background(), "k", Record{Fingerprint: "b"}, time.Minute); existing != nil {
+		t.Errorf("Expected an expired key to be reserved again, got %+v", existing)
+	}
+}
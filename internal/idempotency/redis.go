@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps records in Redis, so retries are recognized by every
+// instance.
+type RedisStore struct {
+	Client *redis.Client
+	// Prefix namespaces the keys. Defaults to "idempotency:".
+	Prefix string
+}
+
+func (s *RedisStore) key(key string) string {
+	if s.Prefix == "" {
+		return "idempotency:" + key
+	}
+	return s.Prefix + key
+}
+
+func (s *RedisStore) Reserve(ctx context.Context, key string, record Record, ttl time.Duration) (*Record, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %v", err)
+	}
+
+	// the existing record may expire between SETNX and GET, so try twice
+	for attempt := 0; attempt < 2; attempt++ {
+		reserved, err := s.Client.SetNX(ctx, s.key(key), data, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+		}
+		if reserved {
+			return nil, nil
+		}
+
+		stored, err := s.Client.Get(ctx, s.key(key)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load idempotency record: %v", err)
+		}
+
+		var existing Record
+		if err := json.Unmarshal(stored, &existing); err != nil {
+			return nil, fmt.Errorf("failed to decode idempotency record: %v", err)
+		}
+		return &existing, nil
+	}
+	return nil, errors.New("failed to reserve idempotency key: key keeps expiring")
+}
+
+func (s *RedisStore) Save(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %v", err)
+	}
+	if err := s.Client.Set(ctx, s.key(key), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save idempotency record: %v", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	if err := s.Client.Del(ctx, s.key(key)).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
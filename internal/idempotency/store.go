@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps records by key.
+type Store interface {
+	// Reserve stores record under key for ttl unless the key is taken, in
+	// which case it returns the existing record.
+	Reserve(ctx context.Context, key string, record Record, ttl time.Duration) (*Record, error)
+	// Save replaces the record under key, keeping it for ttl.
+	Save(ctx context.Context, key string, record Record, ttl time.Duration) error
+	// Release deletes the record under key.
+	Release(ctx context.Context, key string) error
+}
+
+type entry struct {
+	record  Record
+	expires time.Time
+}
+
+// MemoryStore keeps records in process memory, so retries are only
+// recognized by the instance that served the first request. It is the
+// fallback when Redis isn't available.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, key string, record Record, ttl time.Duration) (*Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if e, exists := s.entries[key]; exists && now.Before(e.expires) {
+		existing := e.record
+		return &existing, nil
+	}
+	s.entries[key] = entry{record: record, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, key string, record Record, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries[key] = entry{record: record, expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops expired records, at most once a minute.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
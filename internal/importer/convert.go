@@ -0,0 +1,532 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Resource formats. HTML is converted to Markdown; the others are kept as
+// they are.
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatText     = "text"
+)
+
+// maxTitleLength matches the limit on document titles.
+const maxTitleLength = 200
+
+// formatOf picks the format of a resource from its media type, falling
+// back to the file extension for Markdown served as plain text, as raw
+// file hosts usually do. It returns "" for unsupported types.
+func formatOf(mediaType string, u *url.URL) string {
+	switch mediaType {
+	case "text/markdown", "text/x-markdown":
+		return FormatMarkdown
+	case "text/html", "application/xhtml+xml":
+		return FormatHTML
+	case "text/plain":
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".md", ".markdown", ".mdown":
+			return FormatMarkdown
+		}
+		return FormatText
+	}
+	return ""
+}
+
+// Convert turns a fetched resource into a document title and Markdown or
+// plain text content.
+func Convert(res *Resource) (title, content string, err error) {
+	reader, err := charset.NewReaderLabel(res.Charset, bytes.NewReader(res.Body))
+	if res.Charset == "" || err != nil {
+		reader, err = charset.NewReader(bytes.NewReader(res.Body), res.ContentType)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to decode resource: %v", err)
+		}
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode resource: %v", err)
+	}
+	text := strings.ToValidUTF8(strings.ReplaceAll(string(decoded), "\r\n", "\n"), "�")
+
+	switch formatOf(res.ContentType, res.URL) {
+	case FormatHTML:
+		title, content, err = convertHTML(text, res.URL)
+		if err != nil {
+			return "", "", err
+		}
+	case FormatMarkdown:
+		title, content = markdownTitle(text), strings.TrimSpace(text)
+	case FormatText:
+		content = strings.TrimSpace(text)
+	default:
+		return "", "", ErrUnsupportedType
+	}
+
+	if title == "" {
+		title = urlTitle(res.URL)
+	}
+	return truncate(title, maxTitleLength), content, nil
+}
+
+// markdownTitle returns the text of the first top-level ATX heading.
+func markdownTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[2:]), "#"))
+		}
+	}
+	return ""
+}
+
+// urlTitle names a document after the last segment of its URL's path, or
+// its host.
+func urlTitle(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == "" {
+		return u.Hostname()
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
+
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit])
+}
+
+// convertHTML converts the main content of an HTML page to Markdown, taking
+// the title from its <title> or first <h1>.
+func convertHTML(text string, base *url.URL) (string, string, error) {
+	doc, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse HTML: %v", err)
+	}
+
+	title := ""
+	if node := find(doc, atom.Title); node != nil {
+		title = collapse(textContent(node))
+	}
+	if node := find(doc, atom.H1); title == "" && node != nil {
+		title = collapse(textContent(node))
+	}
+
+	root := doc
+	for _, a := range []atom.Atom{atom.Main, atom.Article, atom.Body} {
+		if node := find(doc, a); node != nil {
+			root = node
+			break
+		}
+	}
+
+	w := &markdownWriter{base: base}
+	w.children(root)
+	return title, strings.TrimSpace(w.b.String()), nil
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := find(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// skipped elements hold page chrome or nothing readable.
+var skipped = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Iframe: true, atom.Object: true,
+	atom.Canvas: true, atom.Nav: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Button: true, atom.Select: true,
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`)
+
+// markdownWriter renders HTML as Markdown. Blocks are separated by blank
+// lines, and prefix starts each line inside blockquotes and list items.
+type markdownWriter struct {
+	b    strings.Builder
+	base *url.URL
+
+	prefix string
+	// linePrefix is the prefix the current line was started with.
+	linePrefix string
+	// newlines are owed before the next text, and space between words.
+	newlines  int
+	space     bool
+	lineStart bool
+	started   bool
+	pre       bool
+	lists     int
+	// item is set right after a list marker, so the item's first block
+	// starts on the marker's line.
+	item bool
+}
+
+func (w *markdownWriter) block() {
+	if w.started && !w.item {
+		w.newlines = 2
+	}
+}
+
+func (w *markdownWriter) line() {
+	if w.started && !w.item && w.newlines < 1 {
+		w.newlines = 1
+	}
+}
+
+func (w *markdownWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.newlines > 0 {
+		// blank lines stay inside the blockquotes both neighbours are in
+		blank := strings.TrimRight(commonPrefix(w.linePrefix, w.prefix), " ")
+		for i := 0; i < w.newlines; i++ {
+			if i > 0 {
+				w.b.WriteString(blank)
+			}
+			w.b.WriteString("\n")
+		}
+		w.newlines = 0
+		w.lineStart = true
+		w.space = false
+	}
+	if w.lineStart {
+		w.b.WriteString(w.prefix)
+		w.linePrefix = w.prefix
+		w.lineStart = false
+		w.space = false
+	}
+	if w.space && !strings.HasSuffix(w.b.String(), " ") {
+		w.b.WriteString(" ")
+	}
+	w.space = false
+	w.b.WriteString(s)
+	w.started = true
+	w.item = false
+}
+
+func commonPrefix(a, b string) string {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:min(len(a), len(b))]
+}
+
+// inline writes a run of inline text, keeping a single space where the
+// source had whitespace at either end.
+func (w *markdownWriter) inline(raw, rendered string) {
+	if rendered == "" {
+		if raw != "" && strings.TrimSpace(raw) == "" && w.started {
+			w.space = true
+		}
+		return
+	}
+	if raw != strings.TrimLeft(raw, " \t\n\r\f") && w.started {
+		w.space = true
+	}
+	w.write(rendered)
+	if raw != strings.TrimRight(raw, " \t\n\r\f") {
+		w.space = true
+	}
+}
+
+// render writes the children of n into a fresh writer and returns them
+// on a single line, for wrapping in emphasis or a link.
+func (w *markdownWriter) render(n *html.Node) string {
+	inner := &markdownWriter{base: w.base}
+	inner.children(n)
+	return collapse(inner.b.String())
+}
+
+func (w *markdownWriter) resolve(ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	if w.base != nil {
+		u = w.base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto" {
+		return ""
+	}
+	return u.String()
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.node(child)
+	}
+}
+
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if w.pre {
+			lines := strings.Split(n.Data, "\n")
+			for i, line := range lines {
+				if i > 0 {
+					w.b.WriteString("\n" + w.prefix)
+				}
+				w.write(line)
+			}
+			return
+		}
+		w.inline(n.Data, markdownEscaper.Replace(collapse(n.Data)))
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	if skipped[n.DataAtom] {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if text := w.render(n); text != "" {
+			w.block()
+			w.write(strings.Repeat("#", int(n.Data[1]-'0')) + " " + text)
+			w.block()
+		}
+	case atom.P, atom.Section, atom.Article, atom.Main, atom.Figure, atom.Header, atom.Dl:
+		w.block()
+		w.children(n)
+		w.block()
+	case atom.Div, atom.Dt, atom.Dd, atom.Figcaption:
+		w.line()
+		w.children(n)
+		w.line()
+	case atom.Br:
+		if w.pre {
+			w.b.WriteString("\n" + w.prefix)
+		} else if w.started && w.newlines == 0 {
+			// two trailing spaces make a hard line break
+			w.b.WriteString("  ")
+			w.line()
+		}
+	case atom.Hr:
+		w.block()
+		w.write("---")
+		w.block()
+	case atom.Strong, atom.B:
+		w.wrap(n, "**", "**")
+	case atom.Em, atom.I:
+		w.wrap(n, "*", "*")
+	case atom.S, atom.Del, atom.Strike:
+		w.wrap(n, "~~", "~~")
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		if w.pre {
+			w.children(n)
+			return
+		}
+		code := collapse(textContent(n))
+		fence := "`"
+		if strings.Contains(code, "`") {
+			fence = "`` "
+		}
+		w.inline(textContent(n), fence+code+reverse(fence))
+	case atom.A:
+		text := w.render(n)
+		href := w.resolve(attr(n, "href"))
+		if href == "" || text == "" {
+			w.inline(textContent(n), text)
+			return
+		}
+		w.inline(textContent(n), "["+text+"]("+href+")")
+	case atom.Img:
+		if src := w.resolve(attr(n, "src")); src != "" {
+			w.inline("", "!["+markdownEscaper.Replace(collapse(attr(n, "alt")))+"]("+src+")")
+		}
+	case atom.Pre:
+		w.block()
+		w.write("```" + language(n))
+		w.newlines = 1
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.newlines = 1
+		w.write("```")
+		w.block()
+	case atom.Blockquote:
+		w.block()
+		prefix := w.prefix
+		w.prefix += "> "
+		w.children(n)
+		w.prefix = prefix
+		w.block()
+	case atom.Ul, atom.Ol:
+		w.list(n)
+	case atom.Table:
+		w.table(n)
+	default:
+		w.children(n)
+	}
+}
+
+func (w *markdownWriter) wrap(n *html.Node, open, close string) {
+	text := w.render(n)
+	if text == "" {
+		w.inline(textContent(n), "")
+		return
+	}
+	w.inline(textContent(n), open+text+close)
+}
+
+func reverse(fence string) string {
+	if fence == "`` " {
+		return " ``"
+	}
+	return fence
+}
+
+// language reads a code block's language from a "language-" class on the
+// <pre> or the <code> inside it.
+func language(pre *html.Node) string {
+	for _, n := range []*html.Node{pre, pre.FirstChild} {
+		if n == nil || n.Type != html.ElementNode {
+			continue
+		}
+		for _, class := range strings.Fields(attr(n, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func (w *markdownWriter) list(n *html.Node) {
+	if w.lists > 0 {
+		w.line()
+	} else {
+		w.block()
+	}
+	w.lists++
+
+	number := 1
+	if start := attr(n, "start"); start != "" {
+		fmt.Sscanf(start, "%d", &number)
+	}
+	for item := n.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != html.ElementNode || item.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+
+		w.line()
+		w.write(marker)
+		w.item = true
+		prefix := w.prefix
+		w.prefix += strings.Repeat(" ", len(marker))
+		w.children(item)
+		w.prefix = prefix
+		w.item = false
+	}
+
+	w.lists--
+	if w.lists > 0 {
+		w.line()
+	} else {
+		w.block()
+	}
+}
+
+// table renders a table as a GFM table with its first row as the header.
+func (w *markdownWriter) table(n *html.Node) {
+	var rows [][]string
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom != atom.Tr {
+				collect(child)
+				continue
+			}
+			var cells []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+					cells = append(cells, strings.ReplaceAll(w.render(cell), "|", `\|`))
+				}
+			}
+			rows = append(rows, cells)
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	w.block()
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		w.line()
+		w.write("| " + strings.Join(row, " | ") + " |")
+		if i == 0 {
+			w.line()
+			w.write(strings.TrimSuffix(strings.Repeat("| --- ", columns), " ") + " |")
+		}
+	}
+	w.block()
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"live-collab-api/internal/ipfilter"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is how many redirects a fetch follows.
+const maxRedirects = 5
+
+var (
+	// ErrInvalidURL is returned for URLs that aren't absolute http or
+	// https URLs.
+	ErrInvalidURL = errors.New("URL must be an absolute http or https URL")
+	// ErrForbiddenAddress is returned when the URL, or a redirect it
+	// leads to, resolves to a loopback, private, or otherwise internal
+	// address.
+	ErrForbiddenAddress = errors.New("URL resolves to a non-public address")
+	ErrTooLarge         = errors.New("resource is too large")
+	ErrUnsupportedType  = errors.New("resource is not Markdown, HTML, or plain text")
+)
+
+// FetchError is returned when the remote server can't be reached or
+// responds with an error.
+type FetchError struct {
+	Status int
+	Err    error
+}
+
+func (e *FetchError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("remote server responded with %d", e.Status)
+	}
+	return fmt.Sprintf("failed to fetch: %v", e.Err)
+}
+
+// Resource is a fetched document before conversion.
+type Resource struct {
+	// URL is where the body was fetched from after redirects.
+	URL         *url.URL
+	ContentType string
+	Charset     string
+	Body        []byte
+}
+
+// Fetcher downloads resources from public addresses only. Every address
+// it connects to is checked after DNS resolution, which also covers
+// redirects and hostnames that resolve differently on each lookup.
+type Fetcher struct {
+	Client *http.Client
+	// MaxSize caps the size of a fetched resource, in bytes.
+	MaxSize int64
+
+	// allowPrivate lets tests fetch from local servers.
+	allowPrivate bool
+}
+
+// NewFetcher returns a Fetcher whose requests, including redirects, time
+// out after timeout.
+func NewFetcher(timeout time.Duration, maxSize int64) *Fetcher {
+	f := &Fetcher{MaxSize: maxSize}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: f.checkAddress}
+	f.Client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// a proxy would be dialed instead of the target, bypassing
+			// the address check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// checkAddress runs before each connection is made, with the resolved IP
+// address being dialed.
+func (f *Fetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return ErrForbiddenAddress
+	}
+	if !ipfilter.IsPublic(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// Fetch downloads the resource at rawURL. Resources over MaxSize, and ones
+// that aren't Markdown, HTML, or plain text, are rejected.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Resource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil, ErrInvalidURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "text/markdown, text/html;q=0.9, text/plain;q=0.8")
+	req.Header.Set("User-Agent", "live-collab-api-importer")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		for _, known := range []error{ErrForbiddenAddress, ErrInvalidURL} {
+			if errors.Is(err, known) {
+				return nil, known
+			}
+		}
+		return nil, &FetchError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &FetchError{Status: resp.StatusCode}
+	}
+	if resp.ContentLength > f.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		// servers without a content type mostly serve plain files
+		mediaType = "text/plain"
+	}
+	if formatOf(mediaType, resp.Request.URL) == "" {
+		return nil, ErrUnsupportedType
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize+1))
+	if err != nil {
+		return nil, &FetchError{Err: err}
+	}
+	if int64(len(body)) > f.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	return &Resource{URL: resp.Request.URL, ContentType: mediaType, Charset: params["charset"], Body: body}, nil
+}
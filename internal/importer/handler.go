@@ -0,0 +1,129 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"live-collab-api/internal/webhooks"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxContentLength matches the limit on the content of new documents.
+const maxContentLength = 1000000
+
+type ImportHandler struct {
+	DocumentService *documents.DocumentService
+	Fetcher         *Fetcher
+	// Webhooks, when set, delivers document.created for imported
+	// documents.
+	Webhooks *webhooks.Dispatcher
+}
+
+// ImportURL godoc
+// @Summary Import a document from a URL
+// @Description Fetch a Markdown, HTML, or plain text resource and create a document from it. HTML is converted to Markdown, keeping the page's main content. The title defaults to the page title, the first Markdown heading, or the file name. Only public addresses can be fetched, and resources are limited in size.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ImportURLRequest true "Resource to import"
+// @Param Idempotency-Key header string false "Key identifying retries of this request; a retry gets the first response back"
+// @Success 201 {object} documents.DocumentResponse "Document created successfully"
+// @Failure 400 {object} ErrorResponse "Invalid or non-public URL"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not a member of the organization"
+// @Failure 413 {object} ErrorResponse "Resource is too large"
+// @Failure 415 {object} ErrorResponse "Resource is not Markdown, HTML, or plain text"
+// @Failure 502 {object} ErrorResponse "Resource couldn't be fetched"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/import-url [post]
+func (h *ImportHandler) ImportURL(c *gin.Context) {
+	userId := c.GetInt("userId")
+
+	var req ImportURLRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if req.OrganizationID != nil {
+		isMember, err := h.DocumentService.IsOrganizationMember(c.Request.Context(), *req.OrganizationID, userId)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify organization membership"})
+			return
+		}
+		if !isMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+			return
+		}
+	}
+
+	resource, err := h.Fetcher.Fetch(c.Request.Context(), req.URL)
+	if err != nil {
+		var fetchErr *FetchError
+		switch {
+		case errors.Is(err, ErrInvalidURL):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+		case errors.Is(err, ErrForbiddenAddress):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must point to a public address"})
+		case errors.Is(err, ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Resource must be at most %d bytes", h.Fetcher.MaxSize)})
+		case errors.Is(err, ErrUnsupportedType):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Resource must be Markdown, HTML, or plain text"})
+		case errors.As(err, &fetchErr):
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch URL: " + fetchErr.Error()})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch URL"})
+		}
+		return
+	}
+
+	title, content, err := Convert(resource)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert resource"})
+		return
+	}
+	if len(content) > maxContentLength {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Converted content must be at most %d bytes", maxContentLength)})
+		return
+	}
+	if req.Title != "" {
+		title = req.Title
+	}
+
+	document, err := h.DocumentService.CreateDocument(c.Request.Context(), title, userId, content, req.OrganizationID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create document"})
+		return
+	}
+
+	h.Webhooks.Dispatch(c.Request.Context(), userId, webhooks.EventDocumentCreated, gin.H{
+		"document_id": document.ID,
+		"title":       document.Title,
+		"user_id":     userId,
+	})
+
+	c.JSON(http.StatusCreated, document)
+}
+
+// swagger models for imports
+
+type ImportURLRequest struct {
+	URL string `json:"url" binding:"required,max=2048" example:"https://raw.githubusercontent.com/octocat/Hello-World/master/README.md"`
+	// Title overrides the title taken from the resource.
+	Title string `json:"title" binding:"max=200" example:"Team wiki"`
+	// OrganizationID, when set, makes the document editable by every member
+	// of that organization. The creator must be a member.
+	OrganizationID *int `json:"organization_id" example:"1"`
+}
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,321 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/db"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/events"
+	"live-collab-api/internal/idempotency"
+	"live-collab-api/internal/websocket"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// server is the API wired the way cmd/server wires it, minus the optional
+// features the flows under test don't touch.
+type server struct {
+	*httptest.Server
+	db *sql.DB
+	// closeStore flushes and stops the document store. It is safe to call
+	// more than once.
+	closeStore func()
+}
+
+func TestMain(m *testing.M) {
+	// migrations are looked up relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func setupServer(t *testing.T) *server {
+	databaseURL, redisURL := os.Getenv("TEST_DATABASE_URL"), os.Getenv("TEST_REDIS_URL")
+	if databaseURL == "" || redisURL == "" {
+		t.Skip("TEST_DATABASE_URL and TEST_REDIS_URL must be set, see docker-compose.test.yml")
+	}
+	gin.SetMode(gin.TestMode)
+
+	pool, database := db.Connect(databaseURL, db.Timeouts{Statement: 10 * time.Second})
+	t.Cleanup(pool.Close)
+	t.Cleanup(func() { database.Close() })
+	if err := db.Migrate(database); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	authService := &auth.AuthService{DB: database, JWTSecret: "integration-test-secret"}
+	documentService := &documents.DocumentService{DB: database}
+
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	redisService, err := websocket.NewRedisService(redisURL, hub)
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { redisService.Close() })
+	hub.Relay = redisService
+	go redisService.StartSubscription()
+
+	cache := &documents.Cache{Client: redisService.Client(), TTL: time.Minute}
+	documentService.Cache = cache
+
+	eventService, err := events.NewEventService(context.Background(), database)
+	if err != nil {
+		t.Fatalf("Failed to set up event service: %v", err)
+	}
+	eventService.Pool = pool
+
+	documentStore := websocket.NewDocumentStore(database, time.Second, time.Minute, time.Minute)
+	documentStore.Cache = cache
+	hub.Store = documentStore
+
+	documentsHandler := &documents.DocumentHandler{
+		DocumentService: documentService,
+		AuthService:     authService,
+		Notifier:        hub,
+	}
+	eventsHandler := &events.EventHandler{
+		EventService:    eventService,
+		DocumentService: documentService,
+		AuthService:     authService,
+	}
+	wsService := &websocket.WebSocketHandler{
+		Hub:         hub,
+		DB:          database,
+		AuthService: authService,
+		Store:       documentStore,
+		Pool:        pool,
+	}
+	idempotent := (&idempotency.Guard{
+		Store: &idempotency.RedisStore{Client: redisService.Client(), Prefix: fmt.Sprintf("idempotency:test:%d:", time.Now().UnixNano())},
+		TTL:   time.Minute,
+	}).Middleware()
+
+	router := gin.New()
+	router.POST("/register", authService.Register)
+	router.POST("/login", authService.Login)
+
+	protected := router.Group("/api")
+	protected.Use(authService.AuthMiddleware())
+	protected.POST("/documents", idempotent, documentsHandler.CreateDocument)
+
+	docAccess := protected.Group("")
+	docAccess.Use(documents.DocumentAccessMiddleware(authService, documentService))
+	docAccess.GET("/documents/:id", documentsHandler.GetDocument)
+	docAccess.GET("/documents/:id/events", eventsHandler.GetDocumentEvents)
+	docAccess.POST("/documents/:id/collaborators", idempotent, documentsHandler.AddCollaborator)
+
+	router.GET("/ws/:document_id", wsService.HandleWebSocket)
+
+	s := &server{Server: httptest.NewServer(router), db: database, closeStore: sync.OnceFunc(documentStore.Close)}
+	t.Cleanup(s.Close)
+	t.Cleanup(s.closeStore)
+	return s
+}
+
+// do sends a JSON request and decodes the JSON response into out when it
+// isn't nil.
+func (s *server) do(t *testing.T, method, path, token string, body, out interface{}, header ...string) int {
+	t.Helper()
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+
+	req, _ := http.NewRequest(method, s.URL+path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// signUp registers a user with a unique email and logs them in.
+func (s *server) signUp(t *testing.T, name string) (token string, userId int) {
+	t.Helper()
+
+	credentials := gin.H{
+		"email":    fmt.Sprintf("%s-%d@example.com", name, time.Now().UnixNano()),
+		"password": "password123",
+	}
+	if status := s.do(t, "POST", "/register", "", credentials, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status %d registering %s, got %d", http.StatusCreated, name, status)
+	}
+
+	var login struct {
+		Token  string `json:"token"`
+		UserId int    `json:"user_id"`
+	}
+	if status := s.do(t, "POST", "/login", "", credentials, &login); status != http.StatusOK {
+		t.Fatalf("Expected status %d logging in %s, got %d", http.StatusOK, name, status)
+	}
+	return login.Token, login.UserId
+}
+
+// dial opens a WebSocket connection to a document.
+func (s *server) dial(t *testing.T, documentId int, token string) *gorilla.Conn {
+	t.Helper()
+
+	url := fmt.Sprintf("ws%s/ws/%d", strings.TrimPrefix(s.URL, "http"), documentId)
+	conn, resp, err := gorilla.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("WebSocket dial failed with status %d: %v", status, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads messages from conn until one of type messageType
+// arrives. Several messages can share a frame, one per line.
+func readUntil(t *testing.T, conn *gorilla.Conn, messageType string) websocket.Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed waiting for a %s message: %v", messageType, err)
+		}
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var message websocket.Message
+			if json.Unmarshal(line, &message) == nil && message.Type == messageType {
+				return message
+			}
+		}
+	}
+}
+
+func TestCollaborativeEditing(t *testing.T) {
+	s := setupServer(t)
+
+	ownerToken, _ := s.signUp(t, "owner")
+	editorToken, editorId := s.signUp(t, "editor")
+
+	// create a document, retrying once to go through the Redis idempotency store
+	var document struct {
+		ID int `json:"id"`
+	}
+	key := fmt.Sprintf("create-%d", time.Now().UnixNano())
+	body := gin.H{"title": "Integration", "content": "Hello"}
+	if status := s.do(t, "POST", "/api/documents", ownerToken, body, &document, idempotency.Header, key); status != http.StatusCreated {
+		t.Fatalf("Expected status %d creating the document, got %d", http.StatusCreated, status)
+	}
+	var replayed struct {
+		ID int `json:"id"`
+	}
+	s.do(t, "POST", "/api/documents", ownerToken, body, &replayed, idempotency.Header, key)
+	if replayed.ID != document.ID {
+		t.Errorf("Expected the retried request to return document %d, got %d", document.ID, replayed.ID)
+	}
+
+	collaborator := gin.H{"user_id": editorId, "permission": "edit"}
+	if status := s.do(t, "POST", fmt.Sprintf("/api/documents/%d/collaborators", document.ID), ownerToken, collaborator, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status %d adding the collaborator, got %d", http.StatusCreated, status)
+	}
+
+	ownerConn := s.dial(t, document.ID, ownerToken)
+	editorConn := s.dial(t, document.ID, editorToken)
+
+	edit := gin.H{
+		"type":    "edit",
+		"payload": gin.H{"operation": "insert", "position": len("Hello"), "content": ", world"},
+	}
+	if err := editorConn.WriteJSON(edit); err != nil {
+		t.Fatalf("Failed to send edit: %v", err)
+	}
+
+	received := readUntil(t, ownerConn, "edit")
+	if received.UserId != editorId {
+		t.Errorf("Expected the edit to come from user %d, got %d", editorId, received.UserId)
+	}
+
+	var history struct {
+		Events []events.Event `json:"events"`
+	}
+	if status := s.do(t, "GET", fmt.Sprintf("/api/documents/%d/events", document.ID), ownerToken, nil, &history); status != http.StatusOK {
+		t.Fatalf("Expected status %d listing events, got %d", http.StatusOK, status)
+	}
+	found := false
+	for _, event := range history.Events {
+		if event.EventType == "edit" && event.UserId == editorId {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the edit in the event history, got %+v", history.Events)
+	}
+
+	// closing the store flushes the edited content to the database
+	ownerConn.Close()
+	editorConn.Close()
+	s.closeStore()
+
+	var content string
+	if err := s.db.QueryRow("SELECT content FROM documents WHERE id = $1", document.ID).Scan(&content); err != nil {
+		t.Fatalf("Failed to read document content: %v", err)
+	}
+	if content != "Hello, world" {
+		t.Errorf("Expected content %q, got %q", "Hello, world", content)
+	}
+}
+
+func TestDocumentAccessIsEnforced(t *testing.T) {
+	s := setupServer(t)
+
+	ownerToken, _ := s.signUp(t, "owner")
+	strangerToken, _ := s.signUp(t, "stranger")
+
+	var document struct {
+		ID int `json:"id"`
+	}
+	if status := s.do(t, "POST", "/api/documents", ownerToken, gin.H{"title": "Private"}, &document); status != http.StatusCreated {
+		t.Fatalf("Expected status %d creating the document, got %d", http.StatusCreated, status)
+	}
+
+	if status := s.do(t, "GET", fmt.Sprintf("/api/documents/%d", document.ID), strangerToken, nil, nil); status != http.StatusForbidden && status != http.StatusNotFound {
+		t.Errorf("Expected a stranger to be refused the document, got status %d", status)
+	}
+
+	url := fmt.Sprintf("ws%s/ws/%d", strings.TrimPrefix(s.URL, "http"), document.ID)
+	_, resp, err := gorilla.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + strangerToken}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a stranger's WebSocket connection to be refused with %d", http.StatusForbidden)
+	}
+}
@@ -0,0 +1,158 @@
+package ipfilter
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rules allow or deny requests by client IP. Deny wins over Allow, and an
+// empty Allow lets in every address that isn't denied. A nil *Rules lets
+// everything through.
+type Rules struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParsePrefixes splits a comma-separated list of CIDR ranges such as
+// "10.0.0.0/8,2001:db8::/32". Single addresses are accepted as ranges of
+// one.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// NewRules parses comma-separated allow and deny lists. It returns nil when
+// both are empty.
+func NewRules(allow, deny string) (*Rules, error) {
+	allowed, err := ParsePrefixes(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := ParsePrefixes(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	return &Rules{Allow: allowed, Deny: denied}, nil
+}
+
+// Allowed reports whether requests from addr are let through.
+func (r *Rules) Allowed(addr netip.Addr) bool {
+	if r == nil {
+		return true
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range r.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(r.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range r.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests from client IPs the rules don't allow with
+// 403. The client IP honors X-Forwarded-For only from the router's trusted
+// proxies.
+func (r *Rules) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r.check(c) {
+			c.Next()
+		}
+	}
+}
+
+// PathMiddleware is like Middleware but only applies to requests for path
+// and the paths below it, so it can run before routing and authentication.
+func (r *Rules) PathMiddleware(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestPath := c.Request.URL.Path
+		if requestPath != path && !strings.HasPrefix(requestPath, path+"/") {
+			c.Next()
+			return
+		}
+		if r.check(c) {
+			c.Next()
+		}
+	}
+}
+
+// check aborts the request with 403 unless its client IP is allowed.
+func (r *Rules) check(c *gin.Context) bool {
+	if r == nil {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(c.ClientIP())
+	if err == nil && r.Allowed(addr) {
+		return true
+	}
+
+	slog.WarnContext(c.Request.Context(), "Request from disallowed IP", "client_ip", c.ClientIP())
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access from your network is not allowed"})
+	return false
+}
+
+// nonPublicPrefixes are ranges netip doesn't classify that still aren't
+// reachable on the public internet, or that map onto ranges that aren't.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// IsPublic reports whether addr is a globally routable unicast address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
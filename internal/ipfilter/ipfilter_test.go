@@ -0,0 +1,111 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRules_Allowed(t *testing.T) {
+	rules, err := NewRules("10.0.0.0/8, 192.168.1.7, 2001:db8::/32", "10.6.0.0/16")
+	if err != nil {
+		t.Fatalf("NewRules failed: %v", err)
+	}
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"10.6.0.1", false},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"::ffff:10.1.2.3", true},
+		{"2001:db8::1", true},
+		{"203.0.113.7", false},
+	}
+
+	for _, tt := range tests {
+		if got := rules.Allowed(netip.MustParseAddr(tt.addr)); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, expected %v", tt.addr, got, tt.allowed)
+		}
+	}
+
+	denyOnly, _ := NewRules("", "203.0.113.0/24")
+	if !denyOnly.Allowed(netip.MustParseAddr("10.1.2.3")) || denyOnly.Allowed(netip.MustParseAddr("203.0.113.7")) {
+		t.Error("Expected a denylist alone to only reject listed addresses")
+	}
+
+	if rules, _ := NewRules("", ""); rules != nil {
+		t.Error("Expected no rules for empty lists")
+	}
+	if _, err := NewRules("10.0.0.0/33", ""); err == nil {
+		t.Error("Expected an invalid range to be rejected")
+	}
+}
+
+func TestRules_PathMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rules, _ := NewRules("10.0.0.0/8", "")
+	r := gin.New()
+	r.Use(rules.PathMiddleware("/api/admin"))
+	for _, path := range []string{"/api/admin/users", "/api/administrators", "/api/documents"} {
+		r.GET(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{"/api/admin/users", "10.1.2.3:5000", http.StatusOK},
+		{"/api/admin/users", "203.0.113.7:5000", http.StatusForbidden},
+		{"/api/administrators", "203.0.113.7:5000", http.StatusOK},
+		{"/api/documents", "203.0.113.7:5000", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.expected {
+			t.Errorf("%s from %s: expected status %d, got %d", tt.path, tt.remoteAddr, tt.expected, w.Code)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":         true,
+		"2606:2800:220:1::248":  true,
+		"127.0.0.1":             false,
+		"10.1.2.3":              false,
+		"172.16.0.1":            false,
+		"192.168.1.1":           false,
+		"169.254.169.254":       false,
+		"100.64.0.1":            false,
+		"0.0.0.0":               false,
+		"::1":                   false,
+		"fd00::1":               false,
+		"fe80::1":               false,
+		"::ffff:127.0.0.1":      false,
+		"64:ff9b::a9fe:a9fe":    false,
+		"2002:7f00:1::":         false,
+		"224.0.0.1":             false,
+		"255.255.255.255":       false,
+		"2001:db8::1":           false,
+		"::ffff:93.184.216.34":  true,
+		"2a00:1450:4001:81c::e": true,
+	}
+	for address, expected := range tests {
+		if got := IsPublic(netip.MustParseAddr(address)); got != expected {
+			t.Errorf("IsPublic(%s) = %v, expected %v", address, got, expected)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"errors"
+	"live-collab-api/internal/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	Runner *Runner
+}
+
+// ListJobs godoc
+// @Summary List queued jobs
+// @Description List background jobs waiting to run or running, next to run first. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Number of jobs to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of jobs to skip (default 0)" default(0)
+// @Success 200 {object} JobListResponse "List of jobs"
+// @Failure 400 {object} ErrorResponse "Invalid limit or offset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/jobs [get]
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	var page validation.Page
+	if !validation.BindQuery(c, &page) {
+		return
+	}
+
+	jobs, err := h.Runner.ListJobs(c.Request.Context(), page.Limit, page.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs, "limit": page.Limit, "offset": page.Offset})
+}
+
+// ListDeadJobs godoc
+// @Summary List failed jobs
+// @Description List background jobs that ran out of attempts, with their last error, most recent first. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Number of jobs to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of jobs to skip (default 0)" default(0)
+// @Success 200 {object} DeadJobListResponse "List of failed jobs"
+// @Failure 400 {object} ErrorResponse "Invalid limit or offset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/jobs/dead [get]
+func (h *JobHandler) ListDeadJobs(c *gin.Context) {
+	var page validation.Page
+	if !validation.BindQuery(c, &page) {
+		return
+	}
+
+	jobs, err := h.Runner.ListDeadJobs(c.Request.Context(), page.Limit, page.Offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list failed jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs, "limit": page.Limit, "offset": page.Offset})
+}
+
+// RequeueDeadJob godoc
+// @Summary Requeue a failed job
+// @Description Move a failed job back to the queue to run again right away with a fresh set of attempts. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Job ID"
+// @Success 202 {object} MessageResponse "Job requeued"
+// @Failure 400 {object} ErrorResponse "Invalid job ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 404 {object} ErrorResponse "Job not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/jobs/dead/{id}/requeue [post]
+func (h *JobHandler) RequeueDeadJob(c *gin.Context) {
+	jobId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	if err := h.Runner.Requeue(c.Request.Context(), jobId); err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue job"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job requeued"})
+}
+
+// swagger models for jobs
+
+type JobListResponse struct {
+	Jobs   []QueuedJob `json:"jobs"`
+	Limit  int         `json:"limit" example:"50"`
+	Offset int         `json:"offset" example:"0"`
+}
+
+type DeadJobListResponse struct {
+	Jobs   []DeadJob `json:"jobs"`
+	Limit  int       `json:"limit" example:"50"`
+	Offset int       `json:"offset" example:"0"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Job requeued"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Admin access required"`
+}
@@ -0,0 +1,164 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupRunnerTest(t *testing.T) (*Runner, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewRunner(db), mock
+}
+
+func expectClaim(mock sqlmock.Sqlmock, jobType string, attempts int) {
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE jobs")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "payload", "attempts", "unique_key"}).
+			AddRow(int64(9), jobType, []byte(`{"document_id":1}`), attempts, nil))
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		4: 8 * time.Second,
+		9: 10 * time.Second,
+	}
+	for attempts, expected := range tests {
+		if got := policy.Delay(attempts); got != expected {
+			t.Errorf("Delay(%d) = %v, expected %v", attempts, got, expected)
+		}
+	}
+}
+
+func TestRunNext_Success(t *testing.T) {
+	runner, mock := setupRunnerTest(t)
+
+	var documentId int
+	runner.Register("snapshot", func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			DocumentID int `json:"document_id"`
+		}
+		json.Unmarshal(payload, &p)
+		documentId = p.DocumentID
+		return nil
+	}, RetryPolicy{})
+
+	expectClaim(mock, "snapshot", 1)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM jobs WHERE id = $1")).
+		WithArgs(int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ran, err := runner.runNext(context.Background())
+	if err != nil || !ran {
+		t.Fatalf("Expected a job to run, got %v, %v", ran, err)
+	}
+
+	if documentId != 1 {
+		t.Errorf("Expected payload document_id 1, got %d", documentId)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunNext_RetriesFailure(t *testing.T) {
+	runner, mock := setupRunnerTest(t)
+	runner.Register("export", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("storage unavailable")
+	}, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute})
+
+	expectClaim(mock, "export", 2)
+	mock.ExpectExec(regexp.QuoteMeta("SET locked_until = NULL, last_error = $2")).
+		WithArgs(int64(9), "storage unavailable", 120).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	runner.runNext(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunNext_DeadLettersPanics(t *testing.T) {
+	runner, mock := setupRunnerTest(t)
+	runner.Register("email", func(ctx context.Context, payload json.RawMessage) error {
+		panic("template missing")
+	}, RetryPolicy{MaxAttempts: 1})
+
+	expectClaim(mock, "email", 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO dead_jobs")).
+		WithArgs(int64(9), "job panicked: template missing").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM jobs WHERE id = $1")).
+		WithArgs(int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	runner.runNext(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRequeueDeadJob_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runner, mock := setupRunnerTest(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO jobs (id, type, payload, created_at)")).
+		WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	handler := &JobHandler{Runner: runner}
+	r := gin.New()
+	r.POST("/jobs/dead/:id/requeue", handler.RequeueDeadJob)
+
+	req, _ := http.NewRequest("POST", "/jobs/dead/42/requeue", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListJobs_InvalidPaging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runner, _ := setupRunnerTest(t)
+
+	handler := &JobHandler{Runner: runner}
+	r := gin.New()
+	r.GET("/jobs", handler.ListJobs)
+
+	for _, query := range []string{"limit=0", "limit=5000", "offset=-1", "limit=all"} {
+		req, _ := http.NewRequest("GET", "/jobs?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
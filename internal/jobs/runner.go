@@ -0,0 +1,344 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often idle workers look for due jobs.
+	pollInterval = 5 * time.Second
+	// jobLease is how long a claimed job is hidden from other workers. A
+	// job whose worker dies is retried once the lease runs out.
+	jobLease = 10 * time.Minute
+)
+
+// Handler runs a job. Returning an error retries the job according to its
+// RetryPolicy; wrap the error with Permanent to dead-letter it right away.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// RetryPolicy controls how failed jobs of a type are retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a job runs before it is moved to the
+	// dead-letter table. Defaults to 5.
+	MaxAttempts int
+	// BaseDelay is the delay after the first failure, doubling with each
+	// attempt. Defaults to 30s.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay. Defaults to an hour.
+	MaxDelay time.Duration
+}
+
+// Delay returns how long to wait before running a job again after the
+// given number of attempts.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	if max <= 0 {
+		max = time.Hour
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return 5
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a job error as not worth retrying.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Job is a unit of work to enqueue.
+type Job struct {
+	Type    string
+	Payload interface{}
+	// RunAt delays the job. The zero value runs it as soon as possible.
+	RunAt time.Time
+	// UniqueKey, when set, skips enqueuing while another job with the same
+	// key is queued.
+	UniqueKey string
+}
+
+type registration struct {
+	handler Handler
+	policy  RetryPolicy
+	// every, when set, enqueues the next run of a periodic job once a run
+	// finishes.
+	every time.Duration
+}
+
+// Runner is a Postgres-backed job queue. Jobs are rows in the jobs table,
+// so they survive restarts and are shared between instances; workers claim
+// due jobs of the types registered on this instance. Jobs that run out of
+// attempts are moved to dead_jobs, where admins can inspect and requeue
+// them.
+type Runner struct {
+	DB *sql.DB
+
+	handlers map[string]registration
+	wake     chan struct{}
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewRunner(db *sql.DB) *Runner {
+	return &Runner{
+		DB:       db,
+		handlers: make(map[string]registration),
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Register sets the handler for a job type. It must be called before Start.
+func (r *Runner) Register(jobType string, handler Handler, policy RetryPolicy) {
+	r.handlers[jobType] = registration{handler: handler, policy: policy}
+}
+
+// RegisterPeriodic sets the handler for a job type that runs every
+// interval, starting when the runner starts. Only one run is queued at a
+// time across instances.
+func (r *Runner) RegisterPeriodic(jobType string, every time.Duration, handler Handler, policy RetryPolicy) {
+	r.handlers[jobType] = registration{handler: handler, policy: policy, every: every}
+}
+
+// Start schedules periodic jobs and starts the workers.
+func (r *Runner) Start(ctx context.Context, workers int) {
+	for jobType, reg := range r.handlers {
+		if reg.every > 0 {
+			if err := r.Enqueue(ctx, Job{Type: jobType, UniqueKey: jobType}); err != nil {
+				slog.ErrorContext(ctx, "Failed to schedule periodic job", "type", jobType, "error", err)
+			}
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+}
+
+// Close stops the workers once their current jobs finish.
+func (r *Runner) Close() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+// Enqueue adds a job to the queue.
+func (r *Runner) Enqueue(ctx context.Context, job Job) error {
+	payload := []byte("{}")
+	if job.Payload != nil {
+		var err error
+		if payload, err = json.Marshal(job.Payload); err != nil {
+			return fmt.Errorf("failed to encode job payload: %v", err)
+		}
+	}
+
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	var uniqueKey sql.NullString
+	if job.UniqueKey != "" {
+		uniqueKey = sql.NullString{String: job.UniqueKey, Valid: true}
+	}
+
+	_, err := r.DB.ExecContext(ctx, `
+		INSERT INTO jobs (type, payload, run_at, unique_key)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL DO NOTHING
+	`, job.Type, string(payload), runAt, uniqueKey)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %v", err)
+	}
+
+	if !runAt.After(time.Now()) {
+		r.notify()
+	}
+	return nil
+}
+
+// notify has an idle worker check for due jobs right away.
+func (r *Runner) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			select {
+			case <-r.quit:
+				return
+			default:
+			}
+
+			ran, err := r.runNext(context.Background())
+			if err != nil {
+				slog.Warn("Failed to claim job", "error", err)
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+
+		select {
+		case <-r.quit:
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+type claimedJob struct {
+	id        int64
+	jobType   string
+	payload   []byte
+	attempts  int
+	uniqueKey sql.NullString
+}
+
+// runNext claims the oldest due job this instance can handle and runs it.
+// It reports whether there was one.
+func (r *Runner) runNext(ctx context.Context) (bool, error) {
+	types := make([]string, 0, len(r.handlers))
+	for jobType := range r.handlers {
+		types = append(types, jobType)
+	}
+
+	var job claimedJob
+	err := r.DB.QueryRowContext(ctx, `
+		UPDATE jobs
+		SET attempts = attempts + 1, locked_until = now() + $2 * interval '1 second'
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE run_at <= now()
+				AND (locked_until IS NULL OR locked_until < now())
+				AND type = ANY(string_to_array($1, ','))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, type, payload, attempts, unique_key
+	`, strings.Join(types, ","), int(jobLease.Seconds())).Scan(&job.id, &job.jobType, &job.payload, &job.attempts, &job.uniqueKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	reg := r.handlers[job.jobType]
+	jobCtx, cancel := context.WithTimeout(ctx, jobLease)
+	err = r.call(jobCtx, reg.handler, job.payload)
+	cancel()
+
+	r.finish(ctx, &job, reg, err)
+	return true, nil
+}
+
+// call runs the handler, turning a panic into an error.
+func (r *Runner) call(ctx context.Context, handler Handler, payload []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return handler(ctx, payload)
+}
+
+// finish deletes a job that succeeded, schedules a retry for one that
+// failed, or moves it to dead_jobs once it runs out of attempts.
+func (r *Runner) finish(ctx context.Context, job *claimedJob, reg registration, runErr error) {
+	var err error
+	var permanent permanentError
+	switch {
+	case runErr == nil:
+		_, err = r.DB.ExecContext(ctx, "DELETE FROM jobs WHERE id = $1", job.id)
+	case errors.As(runErr, &permanent) || job.attempts >= reg.policy.maxAttempts():
+		slog.Warn("Job failed permanently", "job_id", job.id, "type", job.jobType, "attempts", job.attempts, "error", runErr)
+		err = r.deadLetter(ctx, job.id, runErr.Error())
+	default:
+		slog.Info("Job failed, retrying", "job_id", job.id, "type", job.jobType, "attempts", job.attempts, "error", runErr)
+		_, err = r.DB.ExecContext(ctx, `
+			UPDATE jobs
+			SET locked_until = NULL, last_error = $2, run_at = now() + $3 * interval '1 second'
+			WHERE id = $1
+		`, job.id, runErr.Error(), int(reg.policy.Delay(job.attempts).Seconds()))
+		if err == nil {
+			return
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to record job result", "job_id", job.id, "error", err)
+		return
+	}
+
+	if reg.every > 0 {
+		next := Job{Type: job.jobType, RunAt: time.Now().Add(reg.every), UniqueKey: job.uniqueKey.String}
+		if err := r.Enqueue(ctx, next); err != nil {
+			slog.Error("Failed to schedule periodic job", "type", job.jobType, "error", err)
+		}
+	}
+}
+
+func (r *Runner) deadLetter(ctx context.Context, jobId int64, lastError string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO dead_jobs (id, type, payload, attempts, last_error, created_at)
+		SELECT id, type, payload, attempts, $2, created_at FROM jobs WHERE id = $1
+	`, jobId, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter job: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM jobs WHERE id = $1", jobId); err != nil {
+		return fmt.Errorf("failed to delete job: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
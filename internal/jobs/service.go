@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type QueuedJob struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload" swaggertype:"object"`
+	Attempts  int             `json:"attempts"`
+	RunAt     string          `json:"run_at"`
+	Running   bool            `json:"running"`
+	LastError *string         `json:"last_error,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type DeadJob struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload" swaggertype:"object"`
+	Attempts  int             `json:"attempts"`
+	LastError *string         `json:"last_error,omitempty"`
+	CreatedAt string          `json:"created_at"`
+	FailedAt  string          `json:"failed_at"`
+}
+
+// ListJobs returns a page of queued jobs, next to run first.
+func (r *Runner) ListJobs(ctx context.Context, limit, offset int) ([]QueuedJob, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT id, type, payload, attempts, run_at, COALESCE(locked_until > now(), false), last_error, created_at
+		FROM jobs
+		ORDER BY run_at
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []QueuedJob{}
+	for rows.Next() {
+		var job QueuedJob
+		var payload []byte
+		if err := rows.Scan(&job.ID, &job.Type, &payload, &job.Attempts, &job.RunAt, &job.Running, &job.LastError, &job.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %v", err)
+		}
+		job.Payload = payload
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// ListDeadJobs returns a page of dead-lettered jobs, most recently failed
+// first.
+func (r *Runner) ListDeadJobs(ctx context.Context, limit, offset int) ([]DeadJob, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT id, type, payload, attempts, last_error, created_at, failed_at
+		FROM dead_jobs
+		ORDER BY failed_at DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []DeadJob{}
+	for rows.Next() {
+		var job DeadJob
+		var payload []byte
+		if err := rows.Scan(&job.ID, &job.Type, &payload, &job.Attempts, &job.LastError, &job.CreatedAt, &job.FailedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead job: %v", err)
+		}
+		job.Payload = payload
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Requeue moves a dead-lettered job back to the queue with a fresh set of
+// attempts.
+func (r *Runner) Requeue(ctx context.Context, jobId int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO jobs (id, type, payload, created_at)
+		SELECT id, type, payload, created_at FROM dead_jobs WHERE id = $1
+	`, jobId)
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrJobNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM dead_jobs WHERE id = $1", jobId); err != nil {
+		return fmt.Errorf("failed to delete dead job: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	r.notify()
+	return nil
+}
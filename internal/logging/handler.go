@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LevelHandler struct{}
+
+// GetLogLevel godoc
+// @Summary Get the log level
+// @Description Report the log level of the instance serving the request, and when a temporary level expires. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} LevelState "Current log level"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Router /api/admin/log-level [get]
+func (h *LevelHandler) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, CurrentLevel())
+}
+
+// SetLogLevel godoc
+// @Summary Change the log level
+// @Description Change the log level of the instance serving the request without restarting it. With a duration the previous level is restored afterwards; otherwise the level holds until the configuration is reloaded or the server restarts. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body SetLogLevelRequest true "Log level"
+// @Success 200 {object} LevelState "New log level"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Router /api/admin/log-level [put]
+func (h *LevelHandler) SetLogLevel(c *gin.Context) {
+	var req SetLogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(req.Level)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Level must be debug, info, warn, or error"})
+		return
+	}
+
+	if req.Duration > 0 {
+		SetLevelFor(l, time.Duration(req.Duration)*time.Second)
+	} else {
+		SetLevel(req.Level)
+	}
+	slog.InfoContext(c.Request.Context(), "Log level changed", "level", l.String(), "duration_seconds", req.Duration)
+
+	c.JSON(http.StatusOK, CurrentLevel())
+}
+
+// swagger models for the log level
+
+type SetLogLevelRequest struct {
+	Level string `json:"level" binding:"required" example:"debug"`
+	// Duration is the number of seconds after which the previous level is
+	// restored. 0 keeps the new level.
+	Duration int `json:"duration" binding:"min=0,max=86400" example:"900"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Admin access required"`
+}
@@ -0,0 +1,175 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type attrsKey struct{}
+
+// level is the level of the logger installed by Setup.
+var level slog.LevelVar
+
+// override is a temporary level change made with SetLevelFor.
+var override struct {
+	sync.Mutex
+	timer     *time.Timer
+	previous  slog.Level
+	expiresAt time.Time
+}
+
+// LevelState describes the level of the logger installed by Setup.
+type LevelState struct {
+	Level string `json:"level" example:"DEBUG"`
+	// ExpiresAt is when a temporary level reverts to the previous one.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Setup installs the default slog logger, writing JSON (or text, for
+// format "text") to stderr at the given level. Output of the standard log
+// package is routed through it as well.
+func Setup(logLevel, format string) {
+	level.Set(ParseLevel(logLevel))
+	slog.SetDefault(newLogger(os.Stderr, &level, format))
+}
+
+// SetLevel changes the level of the logger installed by Setup, cancelling
+// any temporary level set with SetLevelFor.
+func SetLevel(logLevel string) {
+	override.Lock()
+	defer override.Unlock()
+
+	stopOverride()
+	level.Set(ParseLevel(logLevel))
+}
+
+// SetLevelFor changes the level of the logger installed by Setup to l, and
+// back after d. Changing it again before then extends or shortens the
+// change, and still reverts to the level before the first one.
+func SetLevelFor(l slog.Level, d time.Duration) {
+	override.Lock()
+	defer override.Unlock()
+
+	if override.timer == nil {
+		override.previous = level.Level()
+	}
+	stopOverride()
+
+	previous := override.previous
+	override.expiresAt = time.Now().Add(d).UTC()
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		override.Lock()
+		defer override.Unlock()
+
+		// a later change replaced this one while it was waiting for the lock
+		if override.timer != timer {
+			return
+		}
+		override.timer = nil
+		level.Set(previous)
+		slog.Info("Temporary log level expired", "level", previous.String())
+	})
+	override.timer = timer
+	level.Set(l)
+}
+
+// stopOverride cancels the pending revert of SetLevelFor. The caller holds
+// the override lock.
+func stopOverride() {
+	if override.timer != nil {
+		override.timer.Stop()
+		override.timer = nil
+	}
+}
+
+// CurrentLevel reports the level of the logger installed by Setup.
+func CurrentLevel() LevelState {
+	override.Lock()
+	defer override.Unlock()
+
+	state := LevelState{Level: level.Level().String()}
+	if override.timer != nil {
+		expiresAt := override.expiresAt
+		state.ExpiresAt = &expiresAt
+	}
+	return state
+}
+
+// New returns a logger writing to w. Attributes attached to a context with
+// With are added to every record logged with that context.
+func New(w io.Writer, level, format string) *slog.Logger {
+	return newLogger(w, ParseLevel(level), format)
+}
+
+func newLogger(w io.Writer, level slog.Leveler, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// ParseLevel maps debug, info, warn, and error to their slog level.
+// Anything else is info.
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		slog.Warn("Invalid log level, using info", "value", level)
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// With returns a context carrying args, as key-value pairs or slog.Attr,
+// in addition to those already attached to ctx.
+func With(ctx context.Context, args ...any) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	record := slog.Record{}
+	record.Add(args...)
+
+	attrs := make([]slog.Attr, len(existing), len(existing)+record.NumAttrs())
+	copy(attrs, existing)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// contextHandler adds the attributes attached with With, and the trace ID
+// of the active span, to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
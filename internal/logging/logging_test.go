@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware_CorrelatesRequestLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "debug", "json"))
+	defer slog.SetDefault(previous)
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/documents/:id", func(c *gin.Context) {
+		AddToRequest(c, "user_id", 7, "document_id", 42)
+		slog.InfoContext(c.Request.Context(), "Loading document")
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/documents/42", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get(RequestIDHeader); got != "req-123" {
+		t.Errorf("Expected request ID header req-123, got %q", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d: %s", len(lines), buf.String())
+	}
+
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected JSON log line, got %q", line)
+		}
+		if record["request_id"] != "req-123" {
+			t.Errorf("Expected request_id req-123, got %v", record["request_id"])
+		}
+		if record["user_id"] != float64(7) || record["document_id"] != float64(42) {
+			t.Errorf("Expected user_id 7 and document_id 42, got %v and %v", record["user_id"], record["document_id"])
+		}
+	}
+
+	var access map[string]interface{}
+	json.Unmarshal([]byte(lines[1]), &access)
+	if access["msg"] != "request" || access["status"] != float64(http.StatusOK) {
+		t.Errorf("Expected request log with status 200, got %v", access)
+	}
+}
+
+func TestMiddleware_GeneratesRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "info", "json"))
+	defer slog.SetDefault(previous)
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	req.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	requestId := w.Header().Get(RequestIDHeader)
+	if requestId == "" || len(requestId) > maxRequestIDLength {
+		t.Errorf("Expected a generated request ID, got %q", requestId)
+	}
+}
+
+func TestMiddleware_AddsRequestIDToErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := slog.Default()
+	slog.SetDefault(New(&bytes.Buffer{}, "info", "json"))
+	defer slog.SetDefault(previous)
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/documents/:id", func(c *gin.Context) {
+		if got := RequestID(c.Request.Context()); got != "req-456" {
+			t.Errorf("Expected request ID req-456 in context, got %q", got)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	})
+	r.GET("/documents", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"documents": []string{}})
+	})
+
+	req, _ := http.NewRequest("GET", "/documents/42", nil)
+	req.Header.Set(RequestIDHeader, "req-456")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON error body, got %q", w.Body.String())
+	}
+	if body["error"] != "Document not found" || body["request_id"] != "req-456" {
+		t.Errorf("Expected error with request_id req-456, got %v", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/documents", nil)
+	req.Header.Set(RequestIDHeader, "req-456")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if strings.Contains(w.Body.String(), "request_id") {
+		t.Errorf("Expected successful response to be unchanged, got %q", w.Body.String())
+	}
+}
+
+func TestMiddleware_RejectsUnsafeRequestIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := slog.Default()
+	slog.SetDefault(New(&bytes.Buffer{}, "info", "json"))
+	defer slog.SetDefault(previous)
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, requestId := range []string{"abc'*/; DROP TABLE users", "id with spaces", "naïve"} {
+		req, _ := http.NewRequest("GET", "/health", nil)
+		req.Header.Set(RequestIDHeader, requestId)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get(RequestIDHeader); got == requestId || got == "" {
+			t.Errorf("Expected %q to be replaced with a generated ID, got %q", requestId, got)
+		}
+	}
+}
+
+func TestSetLogLevel_RevertsAfterDuration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := slog.Default()
+	slog.SetDefault(New(&bytes.Buffer{}, "info", "json"))
+	defer slog.SetDefault(previous)
+	SetLevel("info")
+	defer SetLevel("info")
+
+	r := gin.New()
+	handler := &LevelHandler{}
+	r.PUT("/log-level", handler.SetLogLevel)
+
+	req, _ := http.NewRequest("PUT", "/log-level", strings.NewReader(`{"level": "verbose"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown level, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	req, _ = http.NewRequest("PUT", "/log-level", strings.NewReader(`{"level": "debug", "duration": 60}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var state LevelState
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if state.Level != "DEBUG" || state.ExpiresAt == nil {
+		t.Errorf("Expected a temporary DEBUG level, got %+v", state)
+	}
+
+	// a second temporary change still reverts to the original level
+	SetLevelFor(slog.LevelWarn, 10*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for CurrentLevel().ExpiresAt != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state := CurrentLevel(); state.Level != "INFO" || state.ExpiresAt != nil {
+		t.Errorf("Expected the level to revert to INFO, got %+v", state)
+	}
+}
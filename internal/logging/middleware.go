@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID. A valid ID sent by the client or
+// a proxy is kept so logs can be correlated across services.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside a
+// request.
+func RequestID(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIDKey{}).(string)
+	return requestId
+}
+
+// validRequestID reports whether id is safe to echo in headers, logs, and
+// SQL comments: at most maxRequestIDLength letters, digits, '.', '_', or
+// '-'.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware assigns every request an ID, attaches it to the request
+// context for logging, adds it to JSON error responses, and logs the
+// request once it has been handled.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestId := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestId) {
+			requestId = uuid.New().String()
+		}
+		c.Set("requestId", requestId)
+		c.Header(RequestIDHeader, requestId)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, requestId))
+		AddToRequest(c, "request_id", requestId)
+		c.Writer = &errorWriter{ResponseWriter: c.Writer, requestId: requestId}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		args := []any{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, slog.Any("errors", c.Errors.Errors()))
+		}
+		slog.Log(c.Request.Context(), level, "request", args...)
+	}
+}
+
+// AddToRequest attaches args to the request's context, so they are
+// included in the request log and in anything logged with that context.
+func AddToRequest(c *gin.Context, args ...any) {
+	c.Request = c.Request.WithContext(With(c.Request.Context(), args...))
+}
+
+// errorWriter adds a request_id field to JSON object bodies of error
+// responses, so clients can quote it in bug reports.
+type errorWriter struct {
+	gin.ResponseWriter
+	requestId string
+}
+
+func (w *errorWriter) Write(data []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(data)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil || body == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, exists := body["request_id"]; exists {
+		return w.ResponseWriter.Write(data)
+	}
+	body["request_id"] = w.requestId
+
+	annotated, err := json.Marshal(body)
+	if err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, err := w.ResponseWriter.Write(annotated); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *errorWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestCompose(t *testing.T) {
+	from := &mail.Address{Name: "Live Collab", Address: "noreply@example.com"}
+	to := &mail.Address{Address: "alice@example.com"}
+
+	data := string(compose(from, to, Message{
+		To:      "alice@example.com",
+		Subject: "Digest\r\nBcc: mallory@example.com",
+		Body:    "line one\nline two\n",
+	}))
+
+	headers, body, _ := strings.Cut(data, "\r\n\r\n")
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("Expected the subject not to inject headers, got %q", headers)
+	}
+	if !strings.Contains(headers, `From: "Live Collab" <noreply@example.com>`) {
+		t.Errorf("Expected the sender in the headers, got %q", headers)
+	}
+	if body != "line one\r\nline two\r\n" {
+		t.Errorf("Expected CRLF line endings, got %q", body)
+	}
+}
@@ -0,0 +1,76 @@
+package maintenance
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MaintenanceHandler struct {
+	Mode *Mode
+}
+
+// GetMaintenance godoc
+// @Summary Get maintenance mode
+// @Description Report whether the API is in maintenance. Requires the admin role.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} State "Maintenance state"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Router /api/admin/maintenance [get]
+func (h *MaintenanceHandler) GetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Mode.State())
+}
+
+// SetMaintenance godoc
+// @Summary Turn maintenance mode on or off
+// @Description While maintenance is on, requests that change data are rejected with 503 and a Retry-After header, reads keep working, and connected WebSocket clients are sent a maintenance notice. Requires the admin role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body SetMaintenanceRequest true "Maintenance settings"
+// @Success 200 {object} State "New maintenance state"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing token"
+// @Failure 403 {object} ErrorResponse "Admin access required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/admin/maintenance [put]
+func (h *MaintenanceHandler) SetMaintenance(c *gin.Context) {
+	var req SetMaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var err error
+	if *req.Enabled {
+		err = h.Mode.Enable(c.Request.Context(), req.Message, time.Duration(req.RetryAfter)*time.Second)
+	} else {
+		err = h.Mode.Disable(c.Request.Context())
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change maintenance mode"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.Mode.State())
+}
+
+// swagger models for maintenance
+
+type SetMaintenanceRequest struct {
+	Enabled *bool  `json:"enabled" binding:"required" example:"true"`
+	Message string `json:"message" binding:"max=500" example:"Upgrading the database, back in 10 minutes"`
+	// RetryAfter is the number of seconds clients should wait before
+	// retrying. Defaults to 300.
+	RetryAfter int `json:"retry_after" binding:"min=0,max=86400" example:"600"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Admin access required"`
+}
@@ -0,0 +1,243 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisKey holds the current state so instances starting up during
+	// maintenance pick it up.
+	redisKey = "maintenance"
+	// redisChannel announces changes to the other instances.
+	redisChannel = "maintenance"
+
+	// DefaultRetryAfter is suggested to clients when no estimate is given.
+	DefaultRetryAfter = 5 * time.Minute
+	// ReadOnlyRetryAfter is suggested to clients while the database is
+	// unreachable, which is usually over within a failover.
+	ReadOnlyRetryAfter = 30 * time.Second
+)
+
+// State describes whether the API is in maintenance.
+type State struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// RetryAfter is how many seconds clients should wait before retrying
+	// writes.
+	RetryAfter int        `json:"retry_after,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	// ReadOnly is set while this instance can't reach the database. Writes
+	// are rejected until it can, whether or not maintenance is enabled.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// Notifier tells live connections about maintenance.
+type Notifier interface {
+	MaintenanceNotice(state State)
+}
+
+// Mode is the maintenance switch. While it is on, write requests are
+// rejected with 503 and reads keep working. With Redis the switch is shared
+// by all instances; otherwise it only affects this one. Read-only mode,
+// entered when the database is unreachable, is always local. A nil *Mode is
+// never in maintenance.
+type Mode struct {
+	// Notifier, when set, is told whenever maintenance starts or ends.
+	Notifier Notifier
+
+	state      atomic.Pointer[State]
+	readOnly   atomic.Bool
+	redis      *redis.Client
+	instanceId string
+}
+
+// envelope carries a state change between instances.
+type envelope struct {
+	Origin string `json:"origin"`
+	State  State  `json:"state"`
+}
+
+func NewMode() *Mode {
+	m := &Mode{instanceId: uuid.New().String()}
+	m.state.Store(&State{})
+	return m
+}
+
+// Load returns the state shared by the instances using client, which is off
+// until maintenance has been enabled.
+func Load(ctx context.Context, client *redis.Client) (State, error) {
+	var state State
+	data, err := client.Get(ctx, redisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to load maintenance state: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to decode maintenance state: %v", err)
+	}
+	return state, nil
+}
+
+// UseRedis shares the switch with other instances through client, loading
+// the current state and following changes until ctx is done.
+func (m *Mode) UseRedis(ctx context.Context, client *redis.Client) {
+	m.redis = client
+
+	if state, err := Load(ctx, client); err != nil {
+		slog.WarnContext(ctx, "Failed to load maintenance state", "error", err)
+	} else {
+		m.apply(state)
+	}
+
+	pubsub := client.Subscribe(ctx, redisChannel)
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			var env envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				slog.Warn("Received invalid maintenance update", "error", err)
+				continue
+			}
+			if env.Origin != m.instanceId {
+				m.apply(env.State)
+			}
+		}
+	}()
+}
+
+// State returns the current state.
+func (m *Mode) State() State {
+	if m == nil {
+		return State{}
+	}
+	state := *m.state.Load()
+	state.ReadOnly = m.readOnly.Load()
+	return state
+}
+
+// Enabled reports whether the API is in maintenance.
+func (m *Mode) Enabled() bool {
+	return m.State().Enabled
+}
+
+// Enable turns maintenance on for every instance.
+func (m *Mode) Enable(ctx context.Context, message string, retryAfter time.Duration) error {
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+	now := time.Now().UTC()
+	return m.set(ctx, State{Enabled: true, Message: message, RetryAfter: int(retryAfter.Seconds()), Since: &now})
+}
+
+// Disable turns maintenance off for every instance.
+func (m *Mode) Disable(ctx context.Context) error {
+	return m.set(ctx, State{})
+}
+
+// ReadOnly reports whether writes are rejected because the database is
+// unreachable.
+func (m *Mode) ReadOnly() bool {
+	return m != nil && m.readOnly.Load()
+}
+
+// SetReadOnly switches this instance to read-only while the database is
+// unreachable, and back once it recovers. It is separate from maintenance
+// admins enable, so recovering doesn't end a maintenance window.
+func (m *Mode) SetReadOnly(readOnly bool) {
+	if m.readOnly.Swap(readOnly) == readOnly {
+		return
+	}
+
+	slog.Warn("Read-only mode changed", "read_only", readOnly)
+	if m.Notifier != nil {
+		m.Notifier.MaintenanceNotice(m.State())
+	}
+}
+
+// EnableLocal turns maintenance on for this instance only, such as while it
+// shuts down.
+func (m *Mode) EnableLocal(message string, retryAfter time.Duration) {
+	now := time.Now().UTC()
+	m.apply(State{Enabled: true, Message: message, RetryAfter: int(retryAfter.Seconds()), Since: &now})
+}
+
+func (m *Mode) set(ctx context.Context, state State) error {
+	if m.redis != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode maintenance state: %v", err)
+		}
+		if err := m.redis.Set(ctx, redisKey, data, 0).Err(); err != nil {
+			return fmt.Errorf("failed to save maintenance state: %v", err)
+		}
+
+		payload, _ := json.Marshal(envelope{Origin: m.instanceId, State: state})
+		if err := m.redis.Publish(ctx, redisChannel, payload).Err(); err != nil {
+			return fmt.Errorf("failed to publish maintenance state: %v", err)
+		}
+	}
+
+	m.apply(state)
+	return nil
+}
+
+// apply switches to state and tells live connections about it.
+func (m *Mode) apply(state State) {
+	previous := m.state.Swap(&state)
+	if previous.Enabled == state.Enabled && previous.Message == state.Message {
+		return
+	}
+
+	slog.Info("Maintenance mode changed", "enabled", state.Enabled, "message", state.Message)
+	if m.Notifier != nil {
+		m.Notifier.MaintenanceNotice(m.State())
+	}
+}
+
+// Middleware rejects requests other than GET, HEAD and OPTIONS with 503
+// while the API is in maintenance or read-only.
+func (m *Mode) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		state := m.State()
+		if !state.Enabled {
+			if state.ReadOnly {
+				c.Header("Retry-After", strconv.Itoa(int(ReadOnlyRetryAfter.Seconds())))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"error":       "The database is unavailable, so the API is read-only until it recovers",
+					"read_only":   true,
+					"retry_after": int(ReadOnlyRetryAfter.Seconds()),
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(state.RetryAfter))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       "The service is in maintenance; changes can't be saved right now",
+			"message":     state.Message,
+			"retry_after": state.RetryAfter,
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recordingNotifier struct {
+	notices []State
+}
+
+func (n *recordingNotifier) MaintenanceNotice(state State) {
+	n.notices = append(n.notices, state)
+}
+
+func setupMaintenanceTest() (*Mode, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	mode := NewMode()
+
+	r := gin.New()
+	r.Use(mode.Middleware())
+	r.GET("/documents", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/documents", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	return mode, r
+}
+
+func serve(r *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_RejectsWritesDuringMaintenance(t *testing.T) {
+	mode, r := setupMaintenanceTest()
+
+	if w := serve(r, "POST", "/documents"); w.Code != http.StatusCreated {
+		t.Errorf("Expected writes to pass outside maintenance, got %d", w.Code)
+	}
+
+	if err := mode.Enable(context.Background(), "Upgrading", 2*time.Minute); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+
+	w := serve(r, "POST", "/documents")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Expected Retry-After 120, got %q", got)
+	}
+
+	if w := serve(r, "GET", "/documents"); w.Code != http.StatusOK {
+		t.Errorf("Expected reads to pass during maintenance, got %d", w.Code)
+	}
+
+	mode.Disable(context.Background())
+	if w := serve(r, "POST", "/documents"); w.Code != http.StatusCreated {
+		t.Errorf("Expected writes to pass after maintenance, got %d", w.Code)
+	}
+}
+
+func TestMiddleware_RejectsWritesWhileReadOnly(t *testing.T) {
+	mode, r := setupMaintenanceTest()
+	notifier := &recordingNotifier{}
+	mode.Notifier = notifier
+
+	mode.SetReadOnly(true)
+	mode.SetReadOnly(true)
+
+	w := serve(r, "POST", "/documents")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w := serve(r, "GET", "/documents"); w.Code != http.StatusOK {
+		t.Errorf("Expected reads to pass while read-only, got %d", w.Code)
+	}
+
+	// recovering doesn't end maintenance an admin started meanwhile
+	mode.Enable(context.Background(), "Upgrading", 0)
+	mode.SetReadOnly(false)
+	if !mode.Enabled() || mode.ReadOnly() {
+		t.Errorf("Expected maintenance without read-only, got %+v", mode.State())
+	}
+
+	if len(notifier.notices) != 3 || !notifier.notices[0].ReadOnly || notifier.notices[2].ReadOnly {
+		t.Errorf("Unexpected notices: %+v", notifier.notices)
+	}
+}
+
+func TestMode_NotifiesChanges(t *testing.T) {
+	mode := NewMode()
+	notifier := &recordingNotifier{}
+	mode.Notifier = notifier
+
+	mode.Enable(context.Background(), "Upgrading", 0)
+	mode.Enable(context.Background(), "Upgrading", 0)
+	mode.Disable(context.Background())
+
+	if len(notifier.notices) != 2 {
+		t.Fatalf("Expected 2 notices, got %d", len(notifier.notices))
+	}
+	first := notifier.notices[0]
+	if !first.Enabled || first.Message != "Upgrading" || first.RetryAfter != int(DefaultRetryAfter.Seconds()) || first.Since == nil {
+		t.Errorf("Unexpected first notice %+v", first)
+	}
+	if notifier.notices[1].Enabled {
+		t.Errorf("Expected second notice to end maintenance")
+	}
+}
+
+func TestNilMode(t *testing.T) {
+	var mode *Mode
+	if mode.Enabled() {
+		t.Errorf("Expected a nil mode never to be in maintenance")
+	}
+}
+
+func TestSetMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &MaintenanceHandler{Mode: NewMode()}
+
+	r := gin.New()
+	r.PUT("/maintenance", handler.SetMaintenance)
+
+	req, _ := http.NewRequest("PUT", "/maintenance", bytes.NewBufferString(`{"enabled":true,"message":"Back soon","retry_after":60}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	state := handler.Mode.State()
+	if !state.Enabled || state.Message != "Back soon" || state.RetryAfter != 60 {
+		t.Errorf("Unexpected state %+v", state)
+	}
+
+	req, _ = http.NewRequest("PUT", "/maintenance", bytes.NewBufferString(`{"message":"missing enabled"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package notifications
+
+import (
+	"live-collab-api/internal/validation"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreferenceHandler struct {
+	PreferenceService *PreferenceService
+}
+
+// GetPreferences godoc
+// @Summary Get my notification preferences
+// @Description Get which notifications the authenticated user receives, by class (mentions, comments, shares, digests) and channel (email, in_app, webhook). Everything is on unless turned off.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} Preferences "Notification preferences"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/notifications [get]
+func (h *PreferenceHandler) GetPreferences(c *gin.Context) {
+	prefs, err := h.PreferenceService.GetPreferences(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdatePreferences godoc
+// @Summary Update my notification preferences
+// @Description Turn notifications on or off by class and channel, such as {"shares": {"webhook": false}}. Classes and channels left out keep their setting. Returns all preferences.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body Preferences true "Notification preferences to change"
+// @Success 200 {object} Preferences "Notification preferences"
+// @Failure 400 {object} ErrorResponse "Unknown class or channel"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/notifications [patch]
+func (h *PreferenceHandler) UpdatePreferences(c *gin.Context) {
+	var req Preferences
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId := c.GetInt("userId")
+	if err := h.PreferenceService.SetPreferences(c.Request.Context(), userId, req); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	prefs, err := h.PreferenceService.GetPreferences(c.Request.Context(), userId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// swagger models for notifications
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"unknown notification channel \"sms\"; expected one of email, in_app, webhook"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,120 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupPreferenceTest(t *testing.T) (*gin.Engine, *PreferenceService, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	service := &PreferenceService{DB: db}
+	handler := &PreferenceHandler{PreferenceService: service}
+
+	router := gin.New()
+	setUser := func(c *gin.Context) { c.Set("userId", 1) }
+	router.GET("/api/me/notifications", setUser, handler.GetPreferences)
+	router.PATCH("/api/me/notifications", setUser, handler.UpdatePreferences)
+	return router, service, mock
+}
+
+func TestGetPreferences_DefaultsOn(t *testing.T) {
+	router, _, mock := setupPreferenceTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT class, channel, enabled FROM notification_preferences WHERE user_id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"class", "channel", "enabled"}).
+			AddRow(ClassShares, ChannelEmail, false).
+			// rows for classes that no longer exist are ignored
+			AddRow("retired", ChannelEmail, false))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/me/notifications", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var prefs Preferences
+	json.Unmarshal(w.Body.Bytes(), &prefs)
+	if len(prefs) != len(classes) || prefs[ClassShares][ChannelEmail] || !prefs[ClassShares][ChannelWebhook] || !prefs[ClassMentions][ChannelInApp] {
+		t.Errorf("Unexpected preferences: %v", prefs)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	router, _, mock := setupPreferenceTest(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO notification_preferences (user_id, class, channel, enabled)")).
+		WithArgs(1, ClassDigests, ChannelEmail, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO notification_preferences (user_id, class, channel, enabled)")).
+		WithArgs(1, ClassShares, ChannelWebhook, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT class, channel, enabled FROM notification_preferences")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"class", "channel", "enabled"}).
+			AddRow(ClassDigests, ChannelEmail, false).
+			AddRow(ClassShares, ChannelWebhook, false))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/api/me/notifications",
+		bytes.NewBufferString(`{"shares": {"webhook": false}, "digests": {"email": false}}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdatePreferences_UnknownChannel(t *testing.T) {
+	router, _, _ := setupPreferenceTest(t)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/api/me/notifications", bytes.NewBufferString(`{"shares": {"sms": true}}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	_, service, mock := setupPreferenceTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT enabled FROM notification_preferences")).
+		WithArgs(1, ClassMentions, ChannelEmail).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+
+	allowed, err := service.Allowed(context.Background(), 1, ClassMentions, ChannelEmail)
+	if err != nil {
+		t.Fatalf("Allowed failed: %v", err)
+	}
+	if !allowed {
+		t.Error("Expected mentions by email to be allowed")
+	}
+}
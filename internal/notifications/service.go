@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Classes of notifications.
+const (
+	ClassMentions = "mentions"
+	ClassComments = "comments"
+	ClassShares   = "shares"
+	ClassDigests  = "digests"
+)
+
+// Channels notifications are delivered on.
+const (
+	ChannelEmail   = "email"
+	ChannelInApp   = "in_app"
+	ChannelWebhook = "webhook"
+)
+
+var (
+	classes  = []string{ClassMentions, ClassComments, ClassShares, ClassDigests}
+	channels = []string{ChannelEmail, ChannelInApp, ChannelWebhook}
+)
+
+// Preferences maps each notification class to whether the user receives
+// it on each channel.
+type Preferences map[string]map[string]bool
+
+// Validate returns an error naming the first unknown class or channel.
+func (p Preferences) Validate() error {
+	for class, byChannel := range p {
+		if !contains(classes, class) {
+			return fmt.Errorf("unknown notification class %q; expected one of %s", class, strings.Join(classes, ", "))
+		}
+		for channel := range byChannel {
+			if !contains(channels, channel) {
+				return fmt.Errorf("unknown notification channel %q; expected one of %s", channel, strings.Join(channels, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// defaults returns preferences with every notification on.
+func defaults() Preferences {
+	prefs := Preferences{}
+	for _, class := range classes {
+		prefs[class] = map[string]bool{}
+		for _, channel := range channels {
+			prefs[class][channel] = true
+		}
+	}
+	return prefs
+}
+
+// PreferenceService keeps which notifications users receive. Senders check
+// it with Allowed, or by excluding the users with a matching row in
+// notification_preferences where enabled is false.
+type PreferenceService struct {
+	DB *sql.DB
+}
+
+// GetPreferences returns every class and channel, on unless the user
+// turned it off.
+func (s *PreferenceService) GetPreferences(ctx context.Context, userId int) (Preferences, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT class, channel, enabled FROM notification_preferences WHERE user_id = $1
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification preferences: %v", err)
+	}
+	defer rows.Close()
+
+	prefs := defaults()
+	for rows.Next() {
+		var class, channel string
+		var enabled bool
+		if err := rows.Scan(&class, &channel, &enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %v", err)
+		}
+		if byChannel, ok := prefs[class]; ok {
+			if _, ok := byChannel[channel]; ok {
+				byChannel[channel] = enabled
+			}
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// SetPreferences changes the classes and channels in prefs, leaving the
+// others as they are.
+func (s *PreferenceService) SetPreferences(ctx context.Context, userId int, prefs Preferences) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// a stable order keeps concurrent updates from deadlocking
+	var keys [][2]string
+	for class, byChannel := range prefs {
+		for channel := range byChannel {
+			keys = append(keys, [2]string{class, channel})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_preferences (user_id, class, channel, enabled)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, class, channel) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = now()
+		`, userId, key[0], key[1], prefs[key[0]][key[1]])
+		if err != nil {
+			return fmt.Errorf("failed to set notification preference: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit notification preferences: %v", err)
+	}
+	return nil
+}
+
+// Allowed reports whether the user receives notifications of class on
+// channel.
+func (s *PreferenceService) Allowed(ctx context.Context, userId int, class, channel string) (bool, error) {
+	var enabled bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COALESCE((
+			SELECT enabled FROM notification_preferences
+			WHERE user_id = $1 AND class = $2 AND channel = $3
+		), true)
+	`, userId, class, channel).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check notification preference: %v", err)
+	}
+	return enabled, nil
+}
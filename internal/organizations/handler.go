@@ -0,0 +1,394 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/logging"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationHandler struct {
+	OrganizationService *OrganizationService
+	DocumentService     *documents.DocumentService
+	AuthService         *auth.AuthService
+	// Notifier, when set, has membership and permission changes applied to
+	// open connections to the organization's documents.
+	Notifier AccessNotifier
+}
+
+// AccessNotifier applies permission changes to live connections.
+type AccessNotifier interface {
+	// PermissionChanged applies the new permission to the user's open
+	// connections to the document.
+	PermissionChanged(documentId, userId int, permission string)
+	// RevokeAccess disconnects the user from the document.
+	RevokeAccess(documentId, userId int)
+}
+
+// MembershipMiddleware rejects users who aren't members of the organization
+// in the :id parameter, and stores their role as "organizationRole".
+func (h *OrganizationHandler) MembershipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		organizationId, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			c.Abort()
+			return
+		}
+
+		role, err := h.OrganizationService.GetMemberRole(c.Request.Context(), organizationId, c.GetInt("userId"))
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check organization membership"})
+			c.Abort()
+			return
+		}
+
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied - you are not a member of this organization"})
+			c.Abort()
+			return
+		}
+
+		c.Set("organizationId", organizationId)
+		c.Set("organizationRole", role)
+		logging.AddToRequest(c, "organization_id", organizationId)
+
+		c.Next()
+	}
+}
+
+// CreateOrganization godoc
+// @Summary Create an organization
+// @Description Create an organization with the authenticated user as its owner
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateOrganizationRequest true "Organization data"
+// @Success 201 {object} Organization "Organization created successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations [post]
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var req CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	org, err := h.OrganizationService.CreateOrganization(c.Request.Context(), req.Name, c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// GetUserOrganizations godoc
+// @Summary List my organizations
+// @Description List the organizations the authenticated user belongs to, with their role in each
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} OrganizationListResponse "List of organizations"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations [get]
+func (h *OrganizationHandler) GetUserOrganizations(c *gin.Context) {
+	organizations, err := h.OrganizationService.GetUserOrganizations(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organizations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": organizations})
+}
+
+// GetMembers godoc
+// @Summary List organization members
+// @Description List the members of an organization and their roles. Only members can see this.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Success 200 {object} MemberListResponse "List of members"
+// @Failure 400 {object} ErrorResponse "Invalid organization ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - not a member"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations/{id}/members [get]
+func (h *OrganizationHandler) GetMembers(c *gin.Context) {
+	members, err := h.OrganizationService.GetMembers(c.Request.Context(), c.GetInt("organizationId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// SetMember godoc
+// @Summary Add or update an organization member
+// @Description Add a user to an organization or change their role. Owners and admins can manage members; only owners can grant the owner role or change an owner's role.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param request body SetMemberRequest true "Member data"
+// @Success 200 {object} MessageResponse "Member updated successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data or last owner"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - insufficient role"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations/{id}/members [post]
+func (h *OrganizationHandler) SetMember(c *gin.Context) {
+	organizationId := c.GetInt("organizationId")
+	role := c.GetString("organizationRole")
+
+	var req SetMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'owner', 'admin', 'member', or 'guest'"})
+		return
+	}
+
+	currentRole, err := h.OrganizationService.GetMemberRole(c.Request.Context(), organizationId, req.UserID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get member role"})
+		return
+	}
+
+	if !canManage(role, currentRole, req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to grant this role"})
+		return
+	}
+
+	if currentRole == "" {
+		userExists, err := h.AuthService.UserExists(c.Request.Context(), req.UserID)
+		if err != nil || !userExists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	}
+
+	if err := h.OrganizationService.SetMember(c.Request.Context(), organizationId, req.UserID, req.Role); err != nil {
+		if errors.Is(err, ErrLastOwner) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The organization must keep at least one owner"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	if currentRole != req.Role {
+		h.refreshAccess(c.Request.Context(), organizationId, req.UserID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveMember godoc
+// @Summary Remove an organization member
+// @Description Remove a user from an organization. Owners and admins can remove members; only owners can remove owners. Any member can remove themselves.
+// @Tags organizations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param user_id path int true "User ID"
+// @Success 200 {object} MessageResponse "Member removed successfully"
+// @Failure 400 {object} ErrorResponse "Invalid user ID or last owner"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - insufficient role"
+// @Failure 404 {object} ErrorResponse "Member not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations/{id}/members/{user_id} [delete]
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	organizationId := c.GetInt("organizationId")
+	role := c.GetString("organizationRole")
+
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	currentRole, err := h.OrganizationService.GetMemberRole(c.Request.Context(), organizationId, userId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get member role"})
+		return
+	}
+
+	if currentRole == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	if userId != c.GetInt("userId") && !canManage(role, currentRole, "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to remove this member"})
+		return
+	}
+
+	if err := h.OrganizationService.RemoveMember(c.Request.Context(), organizationId, userId); err != nil {
+		if errors.Is(err, ErrLastOwner) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The organization must keep at least one owner"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	h.refreshAccess(c.Request.Context(), organizationId, userId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// SetPermissions godoc
+// @Summary Set default document permissions
+// @Description Set the permission members and guests get on the organization's documents. Owners and admins always edit. Explicit collaborator permissions take precedence. Only owners and admins can change this.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Organization ID"
+// @Param request body SetPermissionsRequest true "Default permissions"
+// @Success 200 {object} MessageResponse "Permissions updated successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - insufficient role"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/organizations/{id}/permissions [put]
+func (h *OrganizationHandler) SetPermissions(c *gin.Context) {
+	organizationId := c.GetInt("organizationId")
+	role := c.GetString("organizationRole")
+
+	if role != RoleOwner && role != RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change permissions"})
+		return
+	}
+
+	var req SetPermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.OrganizationService.SetPermissions(c.Request.Context(), organizationId, req.MemberPermission, req.GuestPermission); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update permissions"})
+		return
+	}
+
+	members, err := h.OrganizationService.GetMembers(c.Request.Context(), organizationId)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to list members for permission refresh", "organization_id", organizationId, "error", err)
+	}
+	var userIds []int
+	for _, member := range members {
+		if member.Role == RoleMember || member.Role == RoleGuest {
+			userIds = append(userIds, member.UserID)
+		}
+	}
+	h.refreshAccess(c.Request.Context(), organizationId, userIds...)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permissions updated successfully"})
+}
+
+// refreshAccess recomputes the users' permissions on each of the
+// organization's documents and applies them to open connections, so role
+// changes take effect without reconnecting.
+func (h *OrganizationHandler) refreshAccess(ctx context.Context, organizationId int, userIds ...int) {
+	if h.Notifier == nil || len(userIds) == 0 {
+		return
+	}
+
+	documentIds, err := h.OrganizationService.GetDocumentIds(ctx, organizationId)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list documents for permission refresh", "organization_id", organizationId, "error", err)
+		return
+	}
+
+	for _, documentId := range documentIds {
+		for _, userId := range userIds {
+			permission, err := h.DocumentService.GetPermission(ctx, userId, documentId)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to refresh document permission", "document_id", documentId, "user_id", userId, "error", err)
+				continue
+			}
+
+			switch permission {
+			case "":
+				h.Notifier.RevokeAccess(documentId, userId)
+			case "owner":
+			default:
+				h.Notifier.PermissionChanged(documentId, userId, permission)
+			}
+		}
+	}
+}
+
+// canManage reports whether a member with role may change another member's
+// role from currentRole ("" for non-members) to newRole ("" for removal).
+func canManage(role, currentRole, newRole string) bool {
+	switch role {
+	case RoleOwner:
+		return true
+	case RoleAdmin:
+		return currentRole != RoleOwner && newRole != RoleOwner
+	default:
+		return false
+	}
+}
+
+// swagger models for organizations
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required" example:"Acme Inc"`
+}
+
+type SetMemberRequest struct {
+	UserID int    `json:"user_id" binding:"required" example:"2"`
+	Role   string `json:"role" binding:"required" example:"member" enums:"owner,admin,member,guest"`
+}
+
+type SetPermissionsRequest struct {
+	MemberPermission string `json:"member_permission" binding:"required,oneof=view edit" example:"edit"`
+	GuestPermission  string `json:"guest_permission" binding:"required,oneof=view edit" example:"view"`
+}
+
+type OrganizationListResponse struct {
+	Organizations []Organization `json:"organizations"`
+}
+
+type MemberListResponse struct {
+	Members []Member `json:"members"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Member updated successfully"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Error message"`
+}
@@ -0,0 +1,227 @@
+package organizations
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"live-collab-api/internal/auth"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupOrganizationTest(t *testing.T) (sqlmock.Sqlmock, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	authService := &auth.AuthService{DB: db, JWTSecret: "test-secret"}
+	handler := &OrganizationHandler{
+		OrganizationService: &OrganizationService{DB: db},
+		AuthService:         authService,
+	}
+
+	r := gin.New()
+	orgRoutes := r.Group("/api/organizations/:id")
+	orgRoutes.Use(authService.AuthMiddleware(), handler.MembershipMiddleware())
+	orgRoutes.GET("/members", handler.GetMembers)
+	orgRoutes.POST("/members", handler.SetMember)
+	orgRoutes.DELETE("/members/:user_id", handler.RemoveMember)
+	orgRoutes.PUT("/permissions", handler.SetPermissions)
+	return mock, r
+}
+
+func expectMemberRole(mock sqlmock.Sqlmock, organizationId, userId int, role string) {
+	query := mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM organization_members")).
+		WithArgs(organizationId, userId)
+	if role == "" {
+		query.WillReturnError(sql.ErrNoRows)
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(role))
+}
+
+func TestMembershipMiddleware_RejectsNonMembers(t *testing.T) {
+	mock, r := setupOrganizationTest(t)
+	token, _ := auth.GenerateJWT(3, "test-secret")
+	expectMemberRole(mock, 1, 3, "")
+
+	req, _ := http.NewRequest("GET", "/api/organizations/1/members", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetMember_AdminCannotGrantOwner(t *testing.T) {
+	mock, r := setupOrganizationTest(t)
+	token, _ := auth.GenerateJWT(2, "test-secret")
+	expectMemberRole(mock, 1, 2, RoleAdmin)
+	expectMemberRole(mock, 1, 3, RoleMember)
+
+	payload := []byte(`{"user_id": 3, "role": "owner"}`)
+	req, _ := http.NewRequest("POST", "/api/organizations/1/members", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRemoveMember_LastOwner(t *testing.T) {
+	mock, r := setupOrganizationTest(t)
+	token, _ := auth.GenerateJWT(1, "test-secret")
+	expectMemberRole(mock, 1, 1, RoleOwner)
+	expectMemberRole(mock, 1, 1, RoleOwner)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) = 1 AND BOOL_OR(user_id = $2)")).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"last_owner"}).AddRow(true))
+
+	req, _ := http.NewRequest("DELETE", "/api/organizations/1/members/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetPermissions_MemberForbidden(t *testing.T) {
+	mock, r := setupOrganizationTest(t)
+	token, _ := auth.GenerateJWT(2, "test-secret")
+	expectMemberRole(mock, 1, 2, RoleMember)
+
+	payload := []byte(`{"member_permission": "view", "guest_permission": "view"}`)
+	req, _ := http.NewRequest("PUT", "/api/organizations/1/permissions", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+type recordingNotifier struct {
+	changed map[int]string
+	revoked []int
+}
+
+func (n *recordingNotifier) PermissionChanged(documentId, userId int, permission string) {
+	n.changed[userId] = permission
+}
+
+func (n *recordingNotifier) RevokeAccess(documentId, userId int) {
+	n.revoked = append(n.revoked, userId)
+}
+
+func TestRefreshAccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	notifier := &recordingNotifier{changed: make(map[int]string)}
+	handler := &OrganizationHandler{
+		OrganizationService: &OrganizationService{DB: db},
+		DocumentService:     &documents.DocumentService{DB: db},
+		Notifier:            notifier,
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM documents WHERE organization_id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+
+	// User 2 was demoted to guest
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WithArgs(10, 2).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("WHEN 'guest' THEN o.guest_permission")).
+		WithArgs(10, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("view"))
+
+	// User 3 was removed
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+		WithArgs(10, 3).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("WHEN 'guest' THEN o.guest_permission")).
+		WithArgs(10, 3).
+		WillReturnError(sql.ErrNoRows)
+
+	handler.refreshAccess(context.Background(), 1, 2, 3)
+
+	if notifier.changed[2] != "view" {
+		t.Errorf("Expected user 2 to get 'view', got '%s'", notifier.changed[2])
+	}
+
+	if len(notifier.revoked) != 1 || notifier.revoked[0] != 3 {
+		t.Errorf("Expected user 3 to be revoked, got %v", notifier.revoked)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	tests := []struct {
+		role, currentRole, newRole string
+		expected                   bool
+	}{
+		{RoleOwner, RoleOwner, RoleMember, true},
+		{RoleAdmin, RoleMember, RoleAdmin, true},
+		{RoleAdmin, "", RoleMember, true},
+		{RoleAdmin, RoleOwner, "", false},
+		{RoleAdmin, RoleMember, RoleOwner, false},
+		{RoleMember, RoleMember, "", false},
+		{RoleAdmin, RoleGuest, RoleMember, true},
+		{RoleGuest, RoleGuest, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := canManage(tt.role, tt.currentRole, tt.newRole); got != tt.expected {
+			t.Errorf("canManage(%q, %q, %q) = %v, expected %v", tt.role, tt.currentRole, tt.newRole, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package organizations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"log/slog"
+)
+
+// Member roles. Owners and admins manage membership; only owners can make
+// other members owners. Owners and admins can edit the organization's
+// documents, while members and guests get the organization's
+// MemberPermission and GuestPermission.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+)
+
+// ErrLastOwner is returned when removing or demoting the only owner of an
+// organization.
+var ErrLastOwner = errors.New("organization must keep at least one owner")
+
+type OrganizationService struct {
+	DB *sql.DB
+	// Cache, when set, has the cached access checks of the organization's
+	// documents dropped whenever membership changes.
+	Cache *documents.Cache
+}
+
+type Organization struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	Role             string `json:"role,omitempty"`
+	MemberPermission string `json:"member_permission"`
+	GuestPermission  string `json:"guest_permission"`
+	CreatedAt        string `json:"created_at"`
+}
+
+type Member struct {
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleAdmin || role == RoleMember || role == RoleGuest
+}
+
+// CreateOrganization creates an organization with ownerId as its owner.
+func (s *OrganizationService) CreateOrganization(ctx context.Context, name string, ownerId int) (*Organization, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	org := Organization{Name: name, Role: RoleOwner}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO organizations (name) VALUES ($1)
+		RETURNING id, member_permission, guest_permission, created_at
+	`, name).Scan(&org.ID, &org.MemberPermission, &org.GuestPermission, &org.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating organization: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+	`, org.ID, ownerId, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add organization owner: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return &org, nil
+}
+
+// GetUserOrganizations returns the organizations a user belongs to, with
+// the user's role in each.
+func (s *OrganizationService) GetUserOrganizations(ctx context.Context, userId int) ([]Organization, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT o.id, o.name, om.role, o.member_permission, o.guest_permission, o.created_at
+		FROM organizations o
+		JOIN organization_members om ON o.id = om.organization_id
+		WHERE om.user_id = $1
+		ORDER BY o.name
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organizations: %v", err)
+	}
+	defer rows.Close()
+
+	organizations := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Role, &org.MemberPermission, &org.GuestPermission, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %v", err)
+		}
+		organizations = append(organizations, org)
+	}
+	return organizations, nil
+}
+
+// GetMemberRole returns a user's role in an organization, or "" if the
+// user isn't a member.
+func (s *OrganizationService) GetMemberRole(ctx context.Context, organizationId, userId int) (string, error) {
+	var role string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT role FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`, organizationId, userId).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get member role: %v", err)
+	}
+	return role, nil
+}
+
+func (s *OrganizationService) GetMembers(ctx context.Context, organizationId int) ([]Member, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT om.user_id, u.email, om.role, om.created_at
+		FROM organization_members om
+		JOIN users u ON om.user_id = u.id
+		WHERE om.organization_id = $1
+		ORDER BY om.created_at
+	`, organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan member: %v", err)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// SetMember adds a user to an organization or changes their role.
+func (s *OrganizationService) SetMember(ctx context.Context, organizationId, userId int, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role: must be 'owner', 'admin', 'member', or 'guest'")
+	}
+
+	if role != RoleOwner {
+		if err := s.checkNotLastOwner(ctx, organizationId, userId); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id)
+		DO UPDATE SET role = $3
+	`, organizationId, userId, role)
+	if err != nil {
+		return fmt.Errorf("failed to add member: %v", err)
+	}
+
+	s.invalidateAccess(ctx, organizationId)
+	return nil
+}
+
+func (s *OrganizationService) RemoveMember(ctx context.Context, organizationId, userId int) error {
+	if err := s.checkNotLastOwner(ctx, organizationId, userId); err != nil {
+		return err
+	}
+
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`, organizationId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("member not found")
+	}
+
+	s.invalidateAccess(ctx, organizationId)
+	return nil
+}
+
+// SetPermissions changes the document permission the organization's
+// members and guests get.
+func (s *OrganizationService) SetPermissions(ctx context.Context, organizationId int, memberPermission, guestPermission string) error {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE organizations SET member_permission = $2, guest_permission = $3
+		WHERE id = $1
+	`, organizationId, memberPermission, guestPermission)
+	if err != nil {
+		return fmt.Errorf("failed to update organization permissions: %v", err)
+	}
+
+	s.invalidateAccess(ctx, organizationId)
+	return nil
+}
+
+// GetDocumentIds returns the IDs of the documents the organization owns.
+func (s *OrganizationService) GetDocumentIds(ctx context.Context, organizationId int) ([]int, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id FROM documents WHERE organization_id = $1", organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization documents: %v", err)
+	}
+	defer rows.Close()
+
+	var documentIds []int
+	for rows.Next() {
+		var documentId int
+		if err := rows.Scan(&documentId); err != nil {
+			return nil, fmt.Errorf("failed to scan document id: %v", err)
+		}
+		documentIds = append(documentIds, documentId)
+	}
+	return documentIds, nil
+}
+
+// checkNotLastOwner returns ErrLastOwner if userId is the organization's
+// only owner.
+func (s *OrganizationService) checkNotLastOwner(ctx context.Context, organizationId, userId int) error {
+	var lastOwner bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) = 1 AND BOOL_OR(user_id = $2)
+		FROM organization_members
+		WHERE organization_id = $1 AND role = 'owner'
+	`, organizationId, userId).Scan(&lastOwner)
+	if err != nil {
+		return fmt.Errorf("failed to count owners: %v", err)
+	}
+	if lastOwner {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// invalidateAccess drops the cached access checks of every document the
+// organization owns.
+func (s *OrganizationService) invalidateAccess(ctx context.Context, organizationId int) {
+	if s.Cache == nil {
+		return
+	}
+
+	documentIds, err := s.GetDocumentIds(ctx, organizationId)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list organization documents for cache invalidation", "organization_id", organizationId, "error", err)
+		return
+	}
+
+	for _, documentId := range documentIds {
+		s.Cache.InvalidateAccess(ctx, documentId)
+	}
+}
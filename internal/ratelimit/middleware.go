@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter applies a Limit to requests grouped by a key. A Limit of zero
+// requests lets every request through.
+type Limiter struct {
+	Store Store
+	Limit Limit
+	// Name distinguishes the buckets of limiters sharing a store.
+	Name string
+	// Key returns the bucket a request counts against. Requests without
+	// a key aren't limited.
+	Key func(c *gin.Context) (string, bool)
+
+	// updated replaces Limit once SetLimit is called.
+	updated atomic.Pointer[Limit]
+}
+
+// SetLimit changes the limit applied to later requests, such as when the
+// configuration is reloaded.
+func (l *Limiter) SetLimit(limit Limit) {
+	l.updated.Store(&limit)
+}
+
+func (l *Limiter) limit() Limit {
+	if limit := l.updated.Load(); limit != nil {
+		return *limit
+	}
+	return l.Limit
+}
+
+// ByIP groups requests by client IP.
+func ByIP(c *gin.Context) (string, bool) {
+	return c.ClientIP(), true
+}
+
+// ByUser groups requests by the authenticated user. It must run after the
+// auth middleware.
+func ByUser(c *gin.Context) (string, bool) {
+	userId := c.GetInt("userId")
+	if userId == 0 {
+		return "", false
+	}
+	return strconv.Itoa(userId), true
+}
+
+// Middleware rejects requests over the limit with 429. Every response
+// carries the RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset
+// headers, and rejections carry Retry-After. If the store fails, requests
+// are let through.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := l.limit()
+		key, ok := l.Key(c)
+		if !ok || limit.Requests == 0 {
+			c.Next()
+			return
+		}
+
+		res, err := l.Store.Take(c.Request.Context(), l.Name+":"+key, limit)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Rate limit check failed, allowing request", "limiter", l.Name, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please retry later"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryStore_TokenBucket(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+
+	for i := 0; i < 3; i++ {
+		res, _ := store.Take(context.Background(), "ip:1.2.3.4", limit)
+		if !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("Expected %d remaining, got %d", 2-i, res.Remaining)
+		}
+	}
+
+	res, _ := store.Take(context.Background(), "ip:1.2.3.4", limit)
+	if res.Allowed {
+		t.Fatal("Expected request over the burst to be rejected")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("Expected retry after 1s, got %v", res.RetryAfter)
+	}
+
+	res, _ = store.Take(context.Background(), "ip:5.6.7.8", limit)
+	if !res.Allowed {
+		t.Error("Expected a different key to have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	res, _ = store.Take(context.Background(), "ip:1.2.3.4", limit)
+	if !res.Allowed {
+		t.Error("Expected a token to be refilled after 1s")
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := &Limiter{
+		Store: NewMemoryStore(),
+		Limit: Limit{Requests: 2, Per: time.Minute},
+		Name:  "auth",
+		Key:   ByIP,
+	}
+
+	r := gin.New()
+	r.POST("/login", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if i < 2 && w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to succeed, got %d", i+1, w.Code)
+		}
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("Expected RateLimit-Limit 2, got %q", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("Expected RateLimit-Remaining 0, got %q", got)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", got)
+	}
+}
+
+func TestLimiter_SkipsRequestsWithoutKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := &Limiter{
+		Store: NewMemoryStore(),
+		Limit: Limit{Requests: 1, Per: time.Minute},
+		Name:  "user",
+		Key:   ByUser,
+	}
+
+	r := gin.New()
+	r.GET("/me", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/me", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected anonymous request %d to pass, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestLimiter_SetLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := &Limiter{
+		Store: NewMemoryStore(),
+		Limit: Limit{Requests: 1, Per: time.Minute},
+		Name:  "ip",
+		Key:   ByIP,
+	}
+
+	r := gin.New()
+	r.GET("/documents", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/documents", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	get()
+	if w := get(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected second request to be limited, got %d", w.Code)
+	}
+
+	limiter.SetLimit(Limit{})
+	if w := get(); w.Code != http.StatusOK {
+		t.Errorf("Expected a zero limit to let requests through, got %d", w.Code)
+	}
+
+	limiter.SetLimit(Limit{Requests: 5, Per: time.Minute})
+	if w := get(); w.Header().Get("RateLimit-Limit") != "5" {
+		t.Errorf("Expected the new limit to apply, got RateLimit-Limit %q", w.Header().Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,111 @@
+package reminders
+
+import (
+	"errors"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReminderHandler struct {
+	ReminderService *ReminderService
+}
+
+// GetReminder godoc
+// @Summary Get my reminder for a document
+// @Description Get the document's due date and how long before it you are reminded, either the document's reminder or your own.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Settings "Reminder settings"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 404 {object} ErrorResponse "Document not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/reminder [get]
+func (h *ReminderHandler) GetReminder(c *gin.Context) {
+	h.respond(c)
+}
+
+// SetReminder godoc
+// @Summary Set my reminder for a document
+// @Description Replace the document's reminder for yourself: remind_before_minutes before the due date you are reminded by email and over WebSocket, or never when it is null.
+// @Tags documents
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body SetReminderRequest true "Reminder"
+// @Success 200 {object} Settings "Reminder updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/reminder [put]
+func (h *ReminderHandler) SetReminder(c *gin.Context) {
+	var req SetReminderRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	if err := h.ReminderService.SetReminder(c.Request.Context(), c.GetInt("documentId"), c.GetInt("userId"), req.RemindBeforeMinutes); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set reminder"})
+		return
+	}
+
+	h.respond(c)
+}
+
+// ResetReminder godoc
+// @Summary Reset my reminder for a document
+// @Description Go back to the reminder set on the document for everyone.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} Settings "Reminder reset"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/reminder [delete]
+func (h *ReminderHandler) ResetReminder(c *gin.Context) {
+	if err := h.ReminderService.ResetReminder(c.Request.Context(), c.GetInt("documentId"), c.GetInt("userId")); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset reminder"})
+		return
+	}
+
+	h.respond(c)
+}
+
+// respond writes the user's current reminder settings.
+func (h *ReminderHandler) respond(c *gin.Context) {
+	settings, err := h.ReminderService.GetSettings(c.Request.Context(), c.GetInt("documentId"), c.GetInt("userId"))
+	if err != nil {
+		if errors.Is(err, documents.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reminder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// swagger models for reminders
+
+type SetReminderRequest struct {
+	// RemindBeforeMinutes is null to turn reminders off.
+	RemindBeforeMinutes *int `json:"remind_before_minutes" binding:"omitempty,min=1,max=43200" example:"60"`
+}
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,149 @@
+package reminders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"live-collab-api/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+type fakeNotifier struct {
+	reminded []int
+}
+
+func (n *fakeNotifier) Remind(documentId, userId int, title string, dueDate time.Time) {
+	n.reminded = append(n.reminded, userId)
+}
+
+func setupReminderTest(t *testing.T) (*ReminderService, *fakeMailer, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mail := &fakeMailer{}
+	return &ReminderService{DB: db, Mailer: mail, FrontendUrl: "https://collab.example.com"}, mail, mock
+}
+
+func TestClaim_NotifiesEachUser(t *testing.T) {
+	service, _, mock := setupReminderTest(t)
+	notifier := &fakeNotifier{}
+	service.Notifier = notifier
+	dueDate := time.Date(2025, 10, 1, 17, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO reminder_deliveries (document_id, user_id, due_date)")).
+		WithArgs(maxClaimed).
+		WillReturnRows(sqlmock.NewRows([]string{"document_id", "user_id", "title", "due_date"}).
+			AddRow(3, 1, "Release notes", dueDate).
+			AddRow(3, 2, "Release notes", dueDate))
+
+	due, err := service.Claim(context.Background())
+	if err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if len(due) != 2 || due[1].UserId != 2 || due[1].Title != "Release notes" || !due[1].DueDate.Equal(dueDate) {
+		t.Fatalf("Unexpected reminders: %+v", due)
+	}
+	for _, reminder := range due {
+		service.Notify(reminder)
+	}
+	if len(notifier.reminded) != 2 {
+		t.Errorf("Expected 2 users to be notified, got %v", notifier.reminded)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	service, mail, mock := setupReminderTest(t)
+	reminder := Reminder{DocumentId: 3, UserId: 1, Title: "Release notes", DueDate: time.Date(2025, 10, 1, 17, 0, 0, 0, time.UTC)}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT u.email FROM users u, documents d")).
+		WithArgs(1, 3, reminder.DueDate).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("alice@example.com"))
+
+	if err := service.Send(context.Background(), reminder); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(mail.sent) != 1 {
+		t.Fatalf("Expected one email, got %d", len(mail.sent))
+	}
+	if msg := mail.sent[0]; msg.To != "alice@example.com" || !strings.Contains(msg.Subject, "Release notes is due Wed, 1 Oct 17:00 UTC") {
+		t.Errorf("Unexpected email: %+v", msg)
+	}
+
+	// the due date moved since the reminder was claimed
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT u.email FROM users u, documents d")).
+		WithArgs(1, 3, reminder.DueDate).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	if err := service.Send(context.Background(), reminder); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(mail.sent) != 1 {
+		t.Errorf("Expected no email for a moved due date, got %d", len(mail.sent))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetReminder_TurnsOff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, _, mock := setupReminderTest(t)
+	handler := &ReminderHandler{ReminderService: service}
+
+	router := gin.New()
+	router.PUT("/api/documents/:id/reminder", func(c *gin.Context) {
+		c.Set("userId", 1)
+		c.Set("documentId", 3)
+	}, handler.SetReminder)
+
+	dueDate := time.Date(2025, 10, 1, 17, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO document_reminders (document_id, user_id, remind_before)")).
+		WithArgs(3, 1, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN document_reminders r")).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"due_date", "remind_before", "custom"}).AddRow(dueDate, nil, true))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/documents/3/reminder", bytes.NewBufferString(`{"remind_before_minutes": null}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var settings Settings
+	json.Unmarshal(w.Body.Bytes(), &settings)
+	if settings.RemindBeforeMinutes != nil || !settings.Custom {
+		t.Errorf("Expected reminders to be off, got %+v", settings)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,191 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/mailer"
+	"time"
+)
+
+// JobSend is the job type that emails one reminder.
+const JobSend = "reminders.send"
+
+// maxClaimed caps how many reminders one Claim returns, so a backlog is
+// worked through over several runs.
+const maxClaimed = 500
+
+// Settings is when a user is reminded about a document's due date.
+type Settings struct {
+	DueDate *time.Time `json:"due_date" example:"2025-10-01T17:00:00Z"`
+	// RemindBeforeMinutes is how long before the due date the user is
+	// reminded, or null when they aren't.
+	RemindBeforeMinutes *int `json:"remind_before_minutes" example:"60"`
+	// Custom is set when the user replaced the document's reminder with
+	// their own.
+	Custom bool `json:"custom" example:"true"`
+}
+
+// Reminder is a reminder that came due for a user. It is the payload of a
+// reminders.send job.
+type Reminder struct {
+	DocumentId int       `json:"document_id"`
+	UserId     int       `json:"user_id"`
+	Title      string    `json:"title"`
+	DueDate    time.Time `json:"due_date"`
+}
+
+// Notifier tells a user's open connections to a document about a reminder.
+type Notifier interface {
+	Remind(documentId, userId int, title string, dueDate time.Time)
+}
+
+// ReminderService keeps users' reminder settings and finds the reminders
+// that have come due.
+type ReminderService struct {
+	DB *sql.DB
+	// Mailer emails reminders. When nil reminders are only sent over
+	// WebSocket.
+	Mailer mailer.Mailer
+	// Notifier, when set, delivers reminders to open WebSocket connections.
+	Notifier Notifier
+	// FrontendUrl is linked from reminder emails.
+	FrontendUrl string
+}
+
+func (s *ReminderService) GetSettings(ctx context.Context, documentId, userId int) (*Settings, error) {
+	var settings Settings
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT d.due_date, CASE WHEN r.user_id IS NULL THEN d.remind_before ELSE r.remind_before END, r.user_id IS NOT NULL
+		FROM documents d
+		LEFT JOIN document_reminders r ON r.document_id = d.id AND r.user_id = $2
+		WHERE d.id = $1
+	`, documentId, userId).Scan(&settings.DueDate, &settings.RemindBeforeMinutes, &settings.Custom)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, documents.ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminder settings: %v", err)
+	}
+	return &settings, nil
+}
+
+// SetReminder replaces the document's reminder for one user. A nil
+// remindBefore turns their reminders for the document off.
+func (s *ReminderService) SetReminder(ctx context.Context, documentId, userId int, remindBefore *int) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO document_reminders (document_id, user_id, remind_before)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (document_id, user_id) DO UPDATE SET remind_before = EXCLUDED.remind_before, updated_at = now()
+	`, documentId, userId, remindBefore)
+	if err != nil {
+		return fmt.Errorf("failed to set reminder: %v", err)
+	}
+	return nil
+}
+
+// ResetReminder goes back to the document's reminder for one user.
+func (s *ReminderService) ResetReminder(ctx context.Context, documentId, userId int) error {
+	_, err := s.DB.ExecContext(ctx, `
+		DELETE FROM document_reminders WHERE document_id = $1 AND user_id = $2
+	`, documentId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to reset reminder: %v", err)
+	}
+	return nil
+}
+
+// Claim records and returns the reminders that have come due: for every
+// user with access to a document whose due date hasn't passed yet, once
+// their reminder time is reached. Each user is reminded once per due date,
+// even when several instances claim at the same time.
+func (s *ReminderService) Claim(ctx context.Context) ([]Reminder, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH due AS (
+			SELECT d.id AS document_id, a.user_id, d.due_date
+			FROM documents d
+			CROSS JOIN LATERAL (
+				SELECT d.owner_id AS user_id
+				UNION
+				SELECT dc.user_id FROM document_collaborators dc WHERE dc.document_id = d.id
+				UNION
+				SELECT om.user_id FROM organization_members om WHERE om.organization_id = d.organization_id
+			) a
+			LEFT JOIN document_reminders r ON r.document_id = d.id AND r.user_id = a.user_id
+			WHERE d.due_date > now()
+				AND d.due_date - CASE WHEN r.user_id IS NULL THEN d.remind_before ELSE r.remind_before END * interval '1 minute' <= now()
+				AND NOT EXISTS (
+					SELECT 1 FROM reminder_deliveries rd
+					WHERE rd.document_id = d.id AND rd.user_id = a.user_id AND rd.due_date = d.due_date
+				)
+			ORDER BY d.due_date
+			LIMIT $1
+		), claimed AS (
+			INSERT INTO reminder_deliveries (document_id, user_id, due_date)
+			SELECT document_id, user_id, due_date FROM due
+			ON CONFLICT DO NOTHING
+			RETURNING document_id, user_id, due_date
+		)
+		SELECT c.document_id, c.user_id, d.title, c.due_date
+		FROM claimed c
+		JOIN documents d ON d.id = c.document_id
+	`, maxClaimed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim reminders: %v", err)
+	}
+	defer rows.Close()
+
+	var due []Reminder
+	for rows.Next() {
+		var r Reminder
+		if err := rows.Scan(&r.DocumentId, &r.UserId, &r.Title, &r.DueDate); err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %v", err)
+		}
+		due = append(due, r)
+	}
+	return due, rows.Err()
+}
+
+// Notify delivers a reminder to the user's open WebSocket connections.
+func (s *ReminderService) Notify(r Reminder) {
+	if s.Notifier != nil {
+		s.Notifier.Remind(r.DocumentId, r.UserId, r.Title, r.DueDate)
+	}
+}
+
+// Send emails a reminder, unless the due date was changed or the user
+// deleted since it was claimed.
+func (s *ReminderService) Send(ctx context.Context, r Reminder) error {
+	if s.Mailer == nil {
+		return errors.New("email is not configured")
+	}
+
+	var email string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT u.email FROM users u, documents d
+		WHERE u.id = $1 AND d.id = $2 AND d.due_date = $3
+	`, r.UserId, r.DocumentId, r.DueDate).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %v", err)
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("Reminder: %s is due %s", r.Title, r.DueDate.UTC().Format("Mon, 2 Jan 15:04 MST")),
+		Body:    render(r, s.FrontendUrl),
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send reminder: %v", err)
+	}
+	return nil
+}
+
+func render(r Reminder, frontendUrl string) string {
+	return fmt.Sprintf("%s is due on %s.\n\nOpen it at %s. To change or turn off this reminder, update it on the document.\n",
+		r.Title, r.DueDate.UTC().Format("Mon, 2 Jan 2006 15:04 MST"), frontendUrl)
+}
@@ -0,0 +1,257 @@
+// Package sanitize removes markup that can run script from HTML document
+// content, so content one collaborator writes can't attack the others.
+package sanitize
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ContentTypeHTML is the content type of documents whose content is HTML.
+const ContentTypeHTML = "text/html"
+
+// IsHTML reports whether content of contentType is HTML.
+func IsHTML(contentType string) bool {
+	return contentType == ContentTypeHTML
+}
+
+// allowedTags are the elements HTML keeps. Other elements are removed and
+// their text kept, except for droppedTags.
+var allowedTags = set(
+	"a", "abbr", "b", "blockquote", "br", "caption", "code", "col", "colgroup",
+	"dd", "del", "details", "div", "dl", "dt", "em", "figcaption", "figure",
+	"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "ins", "kbd", "li",
+	"mark", "ol", "p", "pre", "q", "s", "small", "span", "strong", "sub",
+	"summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "tr",
+	"u", "ul",
+)
+
+// globalAttributes are allowed on every allowed element.
+var globalAttributes = set("class", "dir", "lang", "title")
+
+// allowedAttributes are the further attributes allowed per element.
+var allowedAttributes = map[string]map[string]bool{
+	"a":          set("href", "rel", "target"),
+	"blockquote": set("cite"),
+	"col":        set("span"),
+	"colgroup":   set("span"),
+	"del":        set("cite", "datetime"),
+	"img":        set("alt", "height", "src", "width"),
+	"ins":        set("cite", "datetime"),
+	"ol":         set("reversed", "start", "type"),
+	"q":          set("cite"),
+	"td":         set("colspan", "rowspan"),
+	"th":         set("colspan", "rowspan", "scope"),
+}
+
+// droppedTags are removed along with everything inside them.
+var droppedTags = set(
+	"applet", "base", "embed", "frame", "frameset", "iframe", "link", "math",
+	"meta", "noembed", "noframes", "noscript", "object", "plaintext", "script",
+	"select", "style", "svg", "template", "textarea", "title", "xmp",
+)
+
+// activeTags are the elements Unsafe reports: those that run script or
+// load active content, and those that change how the rest of the page is
+// parsed or resolved.
+var activeTags = set(
+	"applet", "base", "embed", "frame", "frameset", "iframe", "link", "math",
+	"meta", "noscript", "object", "plaintext", "script", "style", "svg",
+	"template", "xmp",
+)
+
+// urlAttributes hold URLs, which must use an allowed scheme.
+var urlAttributes = set("action", "background", "cite", "data", "formaction", "href", "poster", "src", "xlink:href")
+
+// allowedSchemes are the URL schemes allowed in urlAttributes. URLs
+// without a scheme are relative and allowed.
+var allowedSchemes = set("http", "https", "mailto", "tel")
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
+
+// HTML returns content with only allowed elements and attributes, and
+// URLs with allowed schemes. Removed elements keep their text, except for
+// scripts, styles, and embedded content, which are removed entirely.
+// Comments are removed. Everything kept is copied byte for byte, so
+// content that is already clean comes back unchanged.
+func HTML(content string) string {
+	var out strings.Builder
+	out.Grow(len(content))
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	// dropping is the element being removed with its content, and depth
+	// counts elements of the same name nested in it
+	var dropping string
+	var depth int
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				// A tag cut off at the end is dropped by browsers, but
+				// would be completed by markup following the content
+				if rest := z.Raw(); len(rest) > 0 && dropping == "" && safeTag(string(rest)+">") {
+					out.Write(rest)
+				}
+			}
+			return out.String()
+		}
+
+		name, _ := z.TagName()
+		tag := string(name)
+		if dropping != "" {
+			switch {
+			case tt == html.StartTagToken && tag == dropping:
+				depth++
+			case tt == html.EndTagToken && tag == dropping:
+				if depth == 0 {
+					dropping = ""
+				} else {
+					depth--
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.Write(z.Raw())
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[tag] {
+				if tt == html.StartTagToken && !voidTag(tag) {
+					dropping, depth = tag, 0
+				}
+				continue
+			}
+			if !allowedTags[tag] {
+				continue
+			}
+			raw := string(z.Raw())
+			kept, changed := allowedAttrs(z, tag)
+			if !changed {
+				out.WriteString(raw)
+				continue
+			}
+			out.WriteString("<" + tag)
+			for _, attr := range kept {
+				out.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			if tt == html.SelfClosingTagToken {
+				out.WriteString("/")
+			}
+			out.WriteString(">")
+
+		case html.EndTagToken:
+			if allowedTags[tag] {
+				out.Write(z.Raw())
+			}
+		}
+	}
+}
+
+// allowedAttrs returns the attributes of the current tag that are allowed,
+// and whether any were left out.
+func allowedAttrs(z *html.Tokenizer, tag string) ([]html.Attribute, bool) {
+	var kept []html.Attribute
+	changed := false
+	for {
+		key, val, more := z.TagAttr()
+		if len(key) > 0 {
+			attr := html.Attribute{Key: string(key), Val: string(val)}
+			if (globalAttributes[attr.Key] || allowedAttributes[tag][attr.Key]) && (!urlAttributes[attr.Key] || allowedURL(attr.Val)) {
+				kept = append(kept, attr)
+			} else {
+				changed = true
+			}
+		}
+		if !more {
+			return kept, changed
+		}
+	}
+}
+
+// safeTag reports whether raw, a single tag, is kept unchanged by HTML.
+func safeTag(raw string) bool {
+	return HTML(raw) == raw
+}
+
+func voidTag(tag string) bool {
+	switch tag {
+	case "base", "embed", "frame", "link", "meta":
+		return true
+	}
+	return false
+}
+
+// allowedURL reports whether url is relative or uses an allowed scheme.
+// Whitespace and control characters are ignored, as browsers do.
+func allowedURL(url string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, url)
+
+	i := strings.IndexAny(cleaned, ":/?#")
+	if i < 0 || cleaned[i] != ':' {
+		return true
+	}
+	return allowedSchemes[strings.ToLower(cleaned[:i])]
+}
+
+// Unsafe reports whether content has markup that can run script: an
+// active element such as a script or iframe, an event handler attribute,
+// a style attribute, or a URL with a scheme other than http, https,
+// mailto, or tel. Unlike HTML, it allows unknown elements and attributes,
+// so edits typing markup a character at a time pass through states such
+// as "<span cla" that HTML would change.
+func Unsafe(content string) bool {
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				if rest := z.Raw(); len(rest) > 0 && !bytes.HasPrefix(rest, []byte("</")) {
+					return Unsafe(string(rest) + ">")
+				}
+			}
+			return false
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if activeTags[string(name)] {
+				return true
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if unsafeAttr(string(key), string(val)) {
+					return true
+				}
+			}
+		}
+	}
+}
+
+func unsafeAttr(key, val string) bool {
+	switch {
+	case len(key) > 2 && strings.HasPrefix(key, "on"):
+		return true
+	case key == "style", key == "srcdoc":
+		return true
+	case urlAttributes[key]:
+		return !allowedURL(val)
+	}
+	return false
+}
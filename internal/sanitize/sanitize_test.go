@@ -0,0 +1,51 @@
+package sanitize
+
+import "testing"
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		// clean content is kept byte for byte
+		{`<p class=intro>Fish &amp; chips<br/></p>`, `<p class=intro>Fish &amp; chips<br/></p>`},
+		{`<a href="https://example.com" target=_blank>x</a>`, `<a href="https://example.com" target=_blank>x</a>`},
+		{`<p>Hi<script>alert(1)</script>!</p>`, `<p>Hi!</p>`},
+		{`<img src=x onerror="alert(1)" alt=pic>`, `<img src="x" alt="pic">`},
+		{`<a href=" jav&#x61;script:alert(1)">x</a>`, `<a>x</a>`},
+		{`<custom>kept text</custom>`, `kept text`},
+		{`<svg><svg></svg><script>alert(1)</script></svg>after`, `after`},
+		{`<p>x</p><!-- note -->`, `<p>x</p>`},
+		{`<p style="color: red">x</p>`, `<p>x</p>`},
+		// a tag cut off at the end is kept only if it would be kept whole
+		{`x<img src=x onerror=alert(1)`, `x`},
+		{`x<p`, `x<p`},
+	}
+	for _, tt := range tests {
+		if got := HTML(tt.content); got != tt.want {
+			t.Errorf("HTML(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+		if got := HTML(tt.want); got != tt.want {
+			t.Errorf("HTML(%q) changed clean content to %q", tt.want, got)
+		}
+	}
+}
+
+func TestUnsafe(t *testing.T) {
+	for content, want := range map[string]bool{
+		`<p>Hello <strong>world</strong></p>`:  false,
+		`<span cla<p>typing in progress</p>`:   false,
+		`<p>a < b and 1<2</p>`:                 false,
+		`<a href="mailto:me@example.com">`:     false,
+		`<script>alert(1)</script>`:            true,
+		`<IFRAME src=https://example.com>`:     true,
+		`<img src=x onerror=alert(1)>`:         true,
+		`<a href="javascript:alert(1)">x</a>`:  true,
+		`<a href="java	script:alert(1)">x</a>`: true,
+		`<div style="position: fixed">`:        true,
+		`<img src=x onerror=alert(1)`:          true,
+	} {
+		if got := Unsafe(content); got != want {
+			t.Errorf("Unsafe(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
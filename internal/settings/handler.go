@@ -0,0 +1,81 @@
+package settings
+
+import (
+	"live-collab-api/internal/validation"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SettingsHandler struct {
+	SettingsService *SettingsService
+}
+
+// GetSettings godoc
+// @Summary Get my settings
+// @Description Get the client settings the authenticated user syncs across devices: default content type, timezone, locale, and editor settings. Settings never set are left out.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} Settings "Settings"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/settings [get]
+func (h *SettingsHandler) GetSettings(c *gin.Context) {
+	settings, err := h.SettingsService.GetSettings(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings godoc
+// @Summary Update my settings
+// @Description Change some settings, leaving the ones not in the request as they are. Set a text setting to "" or a number or boolean to null to clear it. Returns all settings.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body Settings true "Settings to change"
+// @Success 200 {object} Settings "Settings"
+// @Failure 400 {object} ErrorResponse "Invalid settings"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/settings [patch]
+func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
+	userId := c.GetInt("userId")
+	settings, err := h.SettingsService.GetSettings(c.Request.Context(), userId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+
+	// the request is decoded over the current settings, so only the
+	// fields it has change, and the result is validated as a whole
+	if !validation.BindJSON(c, settings) {
+		return
+	}
+	if err := settings.Check(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.SettingsService.SetSettings(c.Request.Context(), userId, settings); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// swagger models for settings
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Invalid input data"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
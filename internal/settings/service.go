@@ -0,0 +1,98 @@
+package settings
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	// timezones are checked against the embedded database, so settings
+	// validate the same on hosts without zoneinfo
+	_ "time/tzdata"
+)
+
+// localePattern matches BCP 47 language tags such as "en", "pt-BR", or
+// "zh-Hant-TW".
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// ValidLocale reports whether locale is a BCP 47 language tag.
+func ValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// Settings are the client settings a user syncs across devices. Unset
+// fields are left out, and clients use their own defaults for them.
+type Settings struct {
+	// DefaultContentType is the content type the user's clients create
+	// documents with.
+	DefaultContentType string `json:"default_content_type,omitempty" binding:"omitempty,oneof=text/plain text/markdown text/html" example:"text/markdown"`
+	// Timezone is an IANA time zone name.
+	Timezone string `json:"timezone,omitempty" binding:"omitempty,max=64" example:"Europe/Berlin"`
+	// Locale is a BCP 47 language tag.
+	Locale string         `json:"locale,omitempty" binding:"omitempty,max=35" example:"de-DE"`
+	Editor EditorSettings `json:"editor"`
+}
+
+type EditorSettings struct {
+	Theme       string `json:"theme,omitempty" binding:"omitempty,oneof=light dark system" example:"dark"`
+	Keymap      string `json:"keymap,omitempty" binding:"omitempty,oneof=default vim emacs" example:"vim"`
+	FontSize    *int   `json:"font_size,omitempty" binding:"omitempty,min=8,max=72" example:"14"`
+	TabSize     *int   `json:"tab_size,omitempty" binding:"omitempty,min=1,max=8" example:"4"`
+	LineNumbers *bool  `json:"line_numbers,omitempty" example:"true"`
+	WordWrap    *bool  `json:"word_wrap,omitempty" example:"true"`
+}
+
+// Check returns an error describing the first setting the binding rules
+// can't express that is invalid.
+func (s *Settings) Check() error {
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "Local" {
+			return fmt.Errorf("timezone %q is not an IANA time zone such as Europe/Berlin", s.Timezone)
+		}
+	}
+	if s.Locale != "" && !ValidLocale(s.Locale) {
+		return fmt.Errorf("locale %q is not a language tag such as en or pt-BR", s.Locale)
+	}
+	return nil
+}
+
+type SettingsService struct {
+	DB *sql.DB
+}
+
+func (s *SettingsService) GetSettings(ctx context.Context, userId int) (*Settings, error) {
+	var raw []byte
+	err := s.DB.QueryRowContext(ctx, "SELECT settings FROM user_preferences WHERE user_id = $1", userId).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Settings{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings: %v", err)
+	}
+
+	var settings Settings
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode settings: %v", err)
+	}
+	return &settings, nil
+}
+
+func (s *SettingsService) SetSettings(ctx context.Context, userId int, settings *Settings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode settings: %v", err)
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, settings)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = now()
+	`, userId, string(raw))
+	if err != nil {
+		return fmt.Errorf("failed to set settings: %v", err)
+	}
+	return nil
+}
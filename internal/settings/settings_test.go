@@ -0,0 +1,79 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupSettingsTest(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	handler := &SettingsHandler{SettingsService: &SettingsService{DB: db}}
+	router := gin.New()
+	router.PATCH("/api/me/settings", func(c *gin.Context) { c.Set("userId", 1) }, handler.UpdateSettings)
+	return router, mock
+}
+
+func patch(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/api/me/settings", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateSettings_MergesWithCurrent(t *testing.T) {
+	router, mock := setupSettingsTest(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT settings FROM user_preferences WHERE user_id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"settings"}).AddRow([]byte(`{"timezone": "Europe/Berlin", "editor": {"theme": "dark", "font_size": 14}}`)))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_preferences (user_id, settings)")).
+		WithArgs(1, `{"timezone":"Europe/Berlin","locale":"de-DE","editor":{"theme":"dark","tab_size":2}}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := patch(router, `{"locale": "de-DE", "editor": {"tab_size": 2, "font_size": null}}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var settings Settings
+	json.Unmarshal(w.Body.Bytes(), &settings)
+	if settings.Timezone != "Europe/Berlin" || settings.Editor.Theme != "dark" || settings.Editor.FontSize != nil {
+		t.Errorf("Unexpected settings: %+v", settings)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateSettings_Invalid(t *testing.T) {
+	for _, body := range []string{
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"locale": "english please"}`,
+		`{"default_content_type": "application/pdf"}`,
+		`{"editor": {"font_size": 200}}`,
+	} {
+		router, mock := setupSettingsTest(t)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT settings FROM user_preferences WHERE user_id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"settings"}))
+
+		if w := patch(router, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+}
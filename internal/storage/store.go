@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Key prefixes for each kind of stored object. Lifecycle cleanup only
+// looks under these, so the bucket can be shared with other data.
+const (
+	PrefixAttachments = "attachments/"
+	PrefixExports     = "exports/"
+	PrefixAvatars     = "avatars/"
+)
+
+var prefixes = []string{PrefixAttachments, PrefixExports, PrefixAvatars}
+
+// Store keeps files in object storage and tracks them in the
+// storage_objects table. Objects in the bucket without a row, such as
+// direct uploads that were never recorded or objects whose delete failed,
+// are orphans and are removed by CleanupOrphans.
+type Store struct {
+	Client *Client
+	DB     *sql.DB
+}
+
+// NewKey returns a new unique key under prefix, keeping the extension of
+// filename.
+func NewKey(prefix, filename string) string {
+	// Only keep plain extensions so keys never need escaping
+	ext := strings.ToLower(path.Ext(filename))
+	if strings.Trim(ext, ".abcdefghijklmnopqrstuvwxyz0123456789") != "" || len(ext) > 16 {
+		ext = ""
+	}
+	return prefix + uuid.NewString() + ext
+}
+
+// Put uploads an object and records it for userId.
+func (s *Store) Put(ctx context.Context, userId int, key string, body io.Reader, size int64, contentType string) error {
+	if err := s.Client.PutObject(ctx, key, body, size, contentType); err != nil {
+		return err
+	}
+	return s.Track(ctx, userId, key, size, contentType)
+}
+
+// Track records an object that was uploaded directly with an UploadURL.
+func (s *Store) Track(ctx context.Context, userId int, key string, size int64, contentType string) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO storage_objects (key, user_id, size, content_type)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET size = EXCLUDED.size, content_type = EXCLUDED.content_type
+	`, key, userId, size, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to record object: %v", err)
+	}
+	return nil
+}
+
+// Get returns the contents of an object. The caller must close it.
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.Client.GetObject(ctx, key)
+}
+
+// Delete forgets an object and deletes it from the bucket. If the bucket
+// delete fails the object is left for CleanupOrphans.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM storage_objects WHERE key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to delete object record: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrObjectNotFound
+	}
+
+	if err := s.Client.DeleteObject(ctx, key); err != nil {
+		slog.WarnContext(ctx, "Failed to delete object, leaving it for cleanup", "key", key, "error", err)
+	}
+	return nil
+}
+
+// URL returns a signed URL to download an object until it expires.
+func (s *Store) URL(key string, expires time.Duration) string {
+	return s.Client.PresignedURL(http.MethodGet, key, expires)
+}
+
+// UploadURL returns a signed URL a client can PUT an object to until it
+// expires. The upload must then be recorded with Track, or it is removed
+// as an orphan.
+func (s *Store) UploadURL(key string, expires time.Duration) string {
+	return s.Client.PresignedURL(http.MethodPut, key, expires)
+}
+
+// CleanupOrphans deletes objects older than grace that have no
+// storage_objects row, and returns how many were deleted. The grace period
+// leaves time for direct uploads to be recorded.
+func (s *Store) CleanupOrphans(ctx context.Context, grace time.Duration) (int, error) {
+	cutoff := time.Now().Add(-grace)
+	deleted := 0
+
+	for _, prefix := range prefixes {
+		err := s.Client.ListObjects(ctx, prefix, func(objects []Object) error {
+			var keys []string
+			for _, object := range objects {
+				if object.LastModified.Before(cutoff) && !strings.Contains(object.Key, ",") {
+					keys = append(keys, object.Key)
+				}
+			}
+			if len(keys) == 0 {
+				return nil
+			}
+
+			tracked, err := s.trackedKeys(ctx, keys)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				if tracked[key] {
+					continue
+				}
+				if err := s.Client.DeleteObject(ctx, key); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// trackedKeys returns which of keys have a storage_objects row.
+func (s *Store) trackedKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT key FROM storage_objects WHERE key = ANY(string_to_array($1, ','))
+	`, strings.Join(keys, ","))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check objects: %v", err)
+	}
+	defer rows.Close()
+
+	tracked := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan object key: %v", err)
+		}
+		tracked[key] = true
+	}
+	return tracked, rows.Err()
+}
@@ -0,0 +1,70 @@
+package summaries
+
+import (
+	"context"
+	"errors"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SummaryHandler struct {
+	SummaryService *SummaryService
+}
+
+// GetSummary godoc
+// @Summary Get a document summary
+// @Description Get an AI-generated summary of the current version of a document, or with since, a digest of what changed since that version. Summaries are generated on first request and cached per version, which can take a while; a request that times out can be retried to get the result once it is ready.
+// @Tags documents
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param since query int false "Version to describe the changes since"
+// @Success 200 {object} Summary "Summary"
+// @Failure 400 {object} ErrorResponse "Invalid version, or the document is encrypted"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - you don't have access to this document"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Failure 502 {object} ErrorResponse "The summary endpoint failed"
+// @Failure 503 {object} ErrorResponse "Summaries are not configured"
+// @Failure 504 {object} ErrorResponse "The summary is still being generated"
+// @Router /api/documents/{id}/summary [get]
+func (h *SummaryHandler) GetSummary(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	since := 0
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = strconv.Atoi(value); err != nil || since < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a version number"})
+			return
+		}
+	}
+
+	summary, err := h.SummaryService.GetSummary(c.Request.Context(), documentId, since)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, summary)
+	case errors.Is(err, ErrNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Summaries are not enabled"})
+	case errors.Is(err, documents.ErrEncrypted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted documents can't be summarized"})
+	case errors.Is(err, ErrUnknownVersion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The document hasn't reached that version"})
+	case errors.Is(err, ErrGenerationFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to generate summary"})
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "The summary is still being generated, try again shortly"})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get summary"})
+	}
+}
+
+// swagger models for summaries
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Summaries are not enabled"`
+}
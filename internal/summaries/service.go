@@ -0,0 +1,226 @@
+package summaries
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultTimeout = 2 * time.Minute
+	// maxPromptContent caps how much of a document is sent to the model.
+	maxPromptContent = 60000
+	// maxDigestEdits caps how many edits a digest describes; older ones
+	// are left out.
+	maxDigestEdits = 2000
+	// maxEditContent caps the inserted text quoted per edit.
+	maxEditContent = 200
+)
+
+const (
+	summaryInstructions = "You summarize collaborative documents. Reply with a concise summary of at most five sentences, " +
+		"in the language of the document. Only state what the document says."
+	digestInstructions = "You describe how a collaborative document changed. You get the current document and the edits " +
+		"made since an earlier version, in order. Reply with at most five short bullet points about what changed, " +
+		"in the language of the document. Describe changes to the content, not individual keystrokes."
+)
+
+var (
+	// ErrNotConfigured is returned when no summary endpoint is configured.
+	ErrNotConfigured = errors.New("summaries are not configured")
+	// ErrUnknownVersion is returned for digests since a version the
+	// document hasn't reached.
+	ErrUnknownVersion = errors.New("the document has no such version")
+	// ErrGenerationFailed is returned when the summary endpoint fails. The
+	// cause is logged.
+	ErrGenerationFailed = errors.New("failed to generate summary")
+)
+
+// Summary is a generated summary of a document at a version, or, when
+// SinceVersion is set, a digest of what changed between SinceVersion and
+// Version.
+type Summary struct {
+	DocumentId   int       `json:"document_id" example:"1"`
+	Version      int       `json:"version" example:"42"`
+	SinceVersion int       `json:"since_version,omitempty" example:"30"`
+	Summary      string    `json:"summary" example:"A project plan for Q3 covering hiring and the launch timeline."`
+	Model        string    `json:"model,omitempty" example:"gpt-4o-mini"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SummaryService generates document summaries with an LLM and caches them
+// per version, so each version is only summarized once.
+type SummaryService struct {
+	DB              *sql.DB
+	DocumentService *documents.DocumentService
+	// Client generates summaries. When nil summaries are disabled.
+	Client *Client
+	// Timeout bounds generating a summary. Generation carries on after
+	// the request that started it gives up, so a retry finds the result
+	// cached. Defaults to 2 minutes.
+	Timeout time.Duration
+
+	group singleflight.Group
+}
+
+// GetSummary returns the summary of the current version of a document, or
+// with since above 0, the digest of what changed since that version. It is
+// generated on the first request for a version and cached after that;
+// concurrent requests for the same summary share one generation.
+func (s *SummaryService) GetSummary(ctx context.Context, documentId, since int) (*Summary, error) {
+	if s.Client == nil {
+		return nil, ErrNotConfigured
+	}
+
+	version, err := s.DocumentService.GetCurrentVersion(ctx, documentId)
+	if err != nil {
+		return nil, err
+	}
+	if since > version {
+		return nil, ErrUnknownVersion
+	}
+	if since > 0 && since == version {
+		return &Summary{DocumentId: documentId, Version: version, SinceVersion: since, Summary: "No changes.", CreatedAt: time.Now().UTC()}, nil
+	}
+
+	summary := &Summary{DocumentId: documentId, Version: version, SinceVersion: since}
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT summary, model, created_at FROM document_summaries
+		WHERE document_id = $1 AND version = $2 AND since_version = $3
+	`, documentId, version, since).Scan(&summary.Summary, &summary.Model, &summary.CreatedAt)
+	if err == nil {
+		return summary, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get cached summary: %v", err)
+	}
+
+	key := fmt.Sprintf("%d:%d:%d", documentId, version, since)
+	result := s.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout())
+		defer cancel()
+		return s.generate(ctx, summary)
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Summary), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// generate asks the model for a summary and caches it, replacing the
+// summaries of older versions.
+func (s *SummaryService) generate(ctx context.Context, summary *Summary) (*Summary, error) {
+	doc, err := s.DocumentService.GetDocument(ctx, summary.DocumentId)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Encrypted {
+		// the server only holds ciphertext
+		return nil, documents.ErrEncrypted
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Title: %s\n\n%s\n", doc.Title, truncate(doc.Content, maxPromptContent))
+	instructions := summaryInstructions
+	if summary.SinceVersion > 0 {
+		instructions = digestInstructions
+		fmt.Fprintf(&b, "\nEdits since version %d:\n", summary.SinceVersion)
+		if err := s.writeEdits(ctx, &b, summary); err != nil {
+			return nil, err
+		}
+	}
+
+	text, err := s.Client.Complete(ctx, instructions, b.String())
+	if err != nil {
+		slog.WarnContext(ctx, "Summary endpoint failed", "document_id", summary.DocumentId, "version", summary.Version, "error", err)
+		return nil, ErrGenerationFailed
+	}
+
+	generated := &Summary{
+		DocumentId:   summary.DocumentId,
+		Version:      summary.Version,
+		SinceVersion: summary.SinceVersion,
+		Summary:      text,
+		Model:        s.Client.Model,
+	}
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO document_summaries (document_id, version, since_version, summary, model)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (document_id, version, since_version) DO UPDATE SET summary = EXCLUDED.summary
+		RETURNING created_at
+	`, generated.DocumentId, generated.Version, generated.SinceVersion, generated.Summary, generated.Model).Scan(&generated.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache summary: %v", err)
+	}
+
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM document_summaries WHERE document_id = $1 AND version < $2", generated.DocumentId, generated.Version); err != nil {
+		return nil, fmt.Errorf("failed to prune summaries: %v", err)
+	}
+	return generated, nil
+}
+
+// writeEdits describes the edits between the summary's versions, one per
+// line, keeping the latest maxDigestEdits.
+func (s *SummaryService) writeEdits(ctx context.Context, b *strings.Builder, summary *Summary) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT version, user_id, payload FROM (
+			SELECT version, COALESCE(user_id, 0) AS user_id, payload FROM events
+			WHERE document_id = $1 AND event_type = 'edit' AND version > $2 AND version <= $3
+			ORDER BY version DESC
+			LIMIT $4
+		) recent
+		ORDER BY version
+	`, summary.DocumentId, summary.SinceVersion, summary.Version, maxDigestEdits)
+	if err != nil {
+		return fmt.Errorf("failed to get edits: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version, userId int
+		var payload []byte
+		if err := rows.Scan(&version, &userId, &payload); err != nil {
+			return fmt.Errorf("failed to scan edit: %v", err)
+		}
+		var edit struct {
+			Operation string `json:"operation"`
+			Position  int    `json:"position"`
+			Content   string `json:"content"`
+			Length    int    `json:"length"`
+		}
+		if json.Unmarshal(payload, &edit) != nil {
+			continue
+		}
+
+		switch edit.Operation {
+		case "insert":
+			fmt.Fprintf(b, "- v%d, user %d inserted %q at %d\n", version, userId, truncate(edit.Content, maxEditContent), edit.Position)
+		case "delete":
+			fmt.Fprintf(b, "- v%d, user %d deleted %d characters at %d\n", version, userId, edit.Length, edit.Position)
+		default:
+			fmt.Fprintf(b, "- v%d, user %d made a %s edit at %d\n", version, userId, edit.Operation, edit.Position)
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SummaryService) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,139 @@
+package summaries
+
+import (
+	"context"
+	"encoding/json"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func setupSummaryTest(t *testing.T, handler http.HandlerFunc) (*SummaryService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &SummaryService{
+		DB:              db,
+		DocumentService: &documents.DocumentService{DB: db},
+		Client:          &Client{URL: server.URL, APIKey: "key", Model: "test-model", HTTP: server.Client()},
+	}, mock
+}
+
+func expectVersion(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+}
+
+func TestGetSummary_GeneratesDigestAndCachesIt(t *testing.T) {
+	var request chatRequest
+	service, mock := setupSummaryTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("Expected the API key as bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": " - Added a budget section \n"}}]}`))
+	})
+
+	expectVersion(mock, 5)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT summary, model, created_at FROM document_summaries")).
+		WithArgs(1, 5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"summary", "model", "created_at"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "content_type", "owner_id", "created_at", "organization_id", "encrypted", "due_date"}).
+			AddRow(1, "Plan", "Budget: 10k", "text/plain", 1, "2025-01-01", nil, false, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, user_id, payload FROM (")).
+		WithArgs(1, 3, 5, maxDigestEdits).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "user_id", "payload"}).
+			AddRow(4, 2, []byte(`{"operation": "insert", "position": 0, "content": "Budget: 10k"}`)).
+			AddRow(5, 2, []byte(`{"operation": "delete", "position": 11, "length": 3}`)))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO document_summaries")).
+		WithArgs(1, 5, 3, "- Added a budget section", "test-model").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM document_summaries WHERE document_id = $1 AND version < $2")).
+		WithArgs(1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	summary, err := service.GetSummary(context.Background(), 1, 3)
+	if err != nil {
+		t.Fatalf("GetSummary failed: %v", err)
+	}
+	if summary.Summary != "- Added a budget section" || summary.Version != 5 || summary.SinceVersion != 3 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+
+	if len(request.Messages) != 2 || request.Model != "test-model" {
+		t.Fatalf("Expected a system and a user message for test-model, got %+v", request)
+	}
+	prompt := request.Messages[1].Content
+	for _, expected := range []string{"Title: Plan", "Edits since version 3:", `user 2 inserted "Budget: 10k" at 0`, "user 2 deleted 3 characters at 11"} {
+		if !strings.Contains(prompt, expected) {
+			t.Errorf("Expected the prompt to contain %q, got %q", expected, prompt)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSummary_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	service, mock := setupSummaryTest(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the summary endpoint not to be called")
+	})
+
+	r := gin.New()
+	r.GET("/documents/:id/summary", func(c *gin.Context) {
+		c.Set("documentId", 1)
+	}, (&SummaryHandler{SummaryService: service}).GetSummary)
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/documents/1/summary"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// the latest version is summarized once and then served from the cache
+	expectVersion(mock, 5)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT summary, model, created_at FROM document_summaries")).
+		WithArgs(1, 5, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"summary", "model", "created_at"}).AddRow("A plan.", "test-model", time.Now()))
+	if w := serve(""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "A plan.") {
+		t.Errorf("Expected the cached summary, got %d: %s", w.Code, w.Body.String())
+	}
+
+	expectVersion(mock, 5)
+	if w := serve("?since=9"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a future version, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if w := serve("?since=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid version, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	service.Client = nil
+	if w := serve(""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without an endpoint, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
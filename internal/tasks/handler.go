@@ -0,0 +1,215 @@
+package tasks
+
+import (
+	"errors"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notifier tells everyone connected to a document about changes to its
+// tasks.
+type Notifier interface {
+	TaskChanged(documentId, userId int, messageType string, payload map[string]interface{})
+}
+
+type TaskHandler struct {
+	TaskService *TaskService
+	// Notifier, when set, sends task_created, task_updated, and
+	// task_deleted messages to the document's open WebSocket connections.
+	Notifier Notifier
+}
+
+// ListTasks godoc
+// @Summary List a document's tasks
+// @Description List the task items on a document in the order they appear in it, followed by the tasks not tied to a place in it.
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param assignee_id query int false "Only tasks assigned to this user"
+// @Param done query bool false "Only done (true) or open (false) tasks"
+// @Success 200 {object} TaskListResponse "List of tasks"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/tasks [get]
+func (h *TaskHandler) ListTasks(c *gin.Context) {
+	var req ListTasksQuery
+	if !validation.BindQuery(c, &req) {
+		return
+	}
+
+	tasks, err := h.TaskService.ListTasks(c.Request.Context(), c.GetInt("documentId"), Filter{AssigneeId: req.AssigneeId, Done: req.Done})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tasks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tasks": tasks})
+}
+
+// CreateTask godoc
+// @Summary Add a task to a document
+// @Description Add a task item to a document, optionally assigned to a user with access to it and anchored at a character offset in its content. Requires edit permission.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body TaskRequest true "Task"
+// @Success 201 {object} Task "Task created"
+// @Failure 400 {object} ErrorResponse "Invalid input data or the assignee can't access the document"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/tasks [post]
+func (h *TaskHandler) CreateTask(c *gin.Context) {
+	var req TaskRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	documentId, userId := c.GetInt("documentId"), c.GetInt("userId")
+	task, err := h.TaskService.CreateTask(c.Request.Context(), userId, documentId, req.input())
+	if err != nil {
+		fail(c, err, "Failed to create task")
+		return
+	}
+
+	h.notify(documentId, userId, "task_created", gin.H{"task": task})
+	c.JSON(http.StatusCreated, task)
+}
+
+// UpdateTask godoc
+// @Summary Update a task
+// @Description Replace a task's title, assignee, done state, and anchor. Requires edit permission.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param task_id path int true "Task ID"
+// @Param request body TaskRequest true "Task"
+// @Success 200 {object} Task "Task updated"
+// @Failure 400 {object} ErrorResponse "Invalid input data or the assignee can't access the document"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 404 {object} ErrorResponse "Task not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/tasks/{task_id} [put]
+func (h *TaskHandler) UpdateTask(c *gin.Context) {
+	taskId, err := strconv.Atoi(c.Param("task_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	var req TaskRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	documentId, userId := c.GetInt("documentId"), c.GetInt("userId")
+	task, err := h.TaskService.UpdateTask(c.Request.Context(), userId, documentId, taskId, req.input())
+	if err != nil {
+		fail(c, err, "Failed to update task")
+		return
+	}
+
+	h.notify(documentId, userId, "task_updated", gin.H{"task": task})
+	c.JSON(http.StatusOK, task)
+}
+
+// DeleteTask godoc
+// @Summary Delete a task
+// @Description Delete a task item from a document. Requires edit permission.
+// @Tags tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param task_id path int true "Task ID"
+// @Success 200 {object} MessageResponse "Task deleted"
+// @Failure 400 {object} ErrorResponse "Invalid task ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Edit permission required"
+// @Failure 404 {object} ErrorResponse "Task not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/tasks/{task_id} [delete]
+func (h *TaskHandler) DeleteTask(c *gin.Context) {
+	taskId, err := strconv.Atoi(c.Param("task_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	documentId, userId := c.GetInt("documentId"), c.GetInt("userId")
+	if err := h.TaskService.DeleteTask(c.Request.Context(), userId, documentId, taskId); err != nil {
+		fail(c, err, "Failed to delete task")
+		return
+	}
+
+	h.notify(documentId, userId, "task_deleted", gin.H{"task_id": taskId})
+	c.JSON(http.StatusOK, gin.H{"message": "Task deleted successfully"})
+}
+
+func (h *TaskHandler) notify(documentId, userId int, messageType string, payload map[string]interface{}) {
+	if h.Notifier != nil {
+		h.Notifier.TaskChanged(documentId, userId, messageType, payload)
+	}
+}
+
+// fail writes the response for an error from the task service.
+func fail(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Edit permission required to change tasks"})
+	case errors.Is(err, ErrAssigneeNoAccess):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The assignee can't access this document"})
+	case errors.Is(err, ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+	case errors.Is(err, documents.ErrDocumentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	default:
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// swagger models for tasks
+
+type ListTasksQuery struct {
+	AssigneeId *int  `form:"assignee_id" binding:"omitempty,min=1"`
+	Done       *bool `form:"done"`
+}
+
+type TaskRequest struct {
+	Title string `json:"title" binding:"required,max=500" example:"Draft the release notes"`
+	// AssigneeId is null for an unassigned task.
+	AssigneeId *int `json:"assignee_id" binding:"omitempty,min=1" example:"2"`
+	Done       bool `json:"done" example:"false"`
+	// Anchor is null for a task not tied to a place in the content.
+	Anchor *int `json:"anchor" binding:"omitempty,min=0" example:"120"`
+}
+
+func (r TaskRequest) input() TaskInput {
+	return TaskInput{Title: r.Title, AssigneeId: r.AssigneeId, Done: r.Done, Anchor: r.Anchor}
+}
+
+type TaskListResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Task deleted successfully"`
+}
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
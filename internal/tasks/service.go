@@ -0,0 +1,173 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"time"
+)
+
+var (
+	// ErrTaskNotFound is returned for tasks that don't exist on the
+	// document.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrReadOnly is returned when a user who can only view a document
+	// changes its tasks.
+	ErrReadOnly = errors.New("changing tasks requires edit permission")
+	// ErrAssigneeNoAccess is returned when a task is assigned to a user who
+	// can't access the document.
+	ErrAssigneeNoAccess = errors.New("the assignee can't access the document")
+)
+
+const taskColumns = "id, document_id, title, assignee_id, done, done_at, anchor, created_by, created_at, updated_at"
+
+// Task is a task item on a document.
+type Task struct {
+	ID         int    `json:"id" example:"1"`
+	DocumentId int    `json:"document_id" example:"3"`
+	Title      string `json:"title" example:"Draft the release notes"`
+	// AssigneeId is the user the task is assigned to, if any.
+	AssigneeId *int       `json:"assignee_id" example:"2"`
+	Done       bool       `json:"done" example:"false"`
+	DoneAt     *time.Time `json:"done_at,omitempty"`
+	// Anchor is the character offset in the document's content the task
+	// is attached to, or null for a task not tied to a place in it.
+	Anchor    *int      `json:"anchor" example:"120"`
+	CreatedBy *int      `json:"created_by,omitempty" example:"1"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaskInput is the editable part of a task.
+type TaskInput struct {
+	Title      string
+	AssigneeId *int
+	Done       bool
+	Anchor     *int
+}
+
+// Filter narrows the tasks listed. Nil fields don't filter.
+type Filter struct {
+	AssigneeId *int
+	Done       *bool
+}
+
+type TaskService struct {
+	DB        *sql.DB
+	Documents *documents.DocumentService
+}
+
+func scanTask(row interface{ Scan(dest ...any) error }) (*Task, error) {
+	var task Task
+	err := row.Scan(&task.ID, &task.DocumentId, &task.Title, &task.AssigneeId, &task.Done, &task.DoneAt,
+		&task.Anchor, &task.CreatedBy, &task.CreatedAt, &task.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListTasks returns a document's tasks in the order they appear in it,
+// followed by the tasks without an anchor, oldest first.
+func (s *TaskService) ListTasks(ctx context.Context, documentId int, filter Filter) ([]Task, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+taskColumns+` FROM document_tasks
+		WHERE document_id = $1
+			AND ($2::int IS NULL OR assignee_id = $2)
+			AND ($3::boolean IS NULL OR done = $3)
+		ORDER BY anchor NULLS LAST, id
+	`, documentId, filter.AssigneeId, filter.Done)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %v", err)
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %v", err)
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *TaskService) CreateTask(ctx context.Context, userId, documentId int, input TaskInput) (*Task, error) {
+	if err := s.checkChange(ctx, userId, documentId, input.AssigneeId); err != nil {
+		return nil, err
+	}
+
+	task, err := scanTask(s.DB.QueryRowContext(ctx, `
+		INSERT INTO document_tasks (document_id, title, assignee_id, done, done_at, anchor, created_by)
+		VALUES ($1, $2, $3, $4, CASE WHEN $4 THEN now() END, $5, $6)
+		RETURNING `+taskColumns,
+		documentId, input.Title, input.AssigneeId, input.Done, input.Anchor, userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create task: %v", err)
+	}
+	return task, nil
+}
+
+// UpdateTask replaces the editable part of a task. done_at is kept while
+// the task stays done.
+func (s *TaskService) UpdateTask(ctx context.Context, userId, documentId, taskId int, input TaskInput) (*Task, error) {
+	if err := s.checkChange(ctx, userId, documentId, input.AssigneeId); err != nil {
+		return nil, err
+	}
+
+	task, err := scanTask(s.DB.QueryRowContext(ctx, `
+		UPDATE document_tasks
+		SET title = $3, assignee_id = $4, done = $5, anchor = $6, updated_at = now(),
+			done_at = CASE WHEN NOT $5 THEN NULL WHEN done THEN done_at ELSE now() END
+		WHERE id = $1 AND document_id = $2
+		RETURNING `+taskColumns,
+		taskId, documentId, input.Title, input.AssigneeId, input.Done, input.Anchor))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update task: %v", err)
+	}
+	return task, nil
+}
+
+func (s *TaskService) DeleteTask(ctx context.Context, userId, documentId, taskId int) error {
+	if err := s.checkChange(ctx, userId, documentId, nil); err != nil {
+		return err
+	}
+
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM document_tasks WHERE id = $1 AND document_id = $2", taskId, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+// checkChange returns an error unless userId can edit the document and
+// assigneeId, when set, can access it.
+func (s *TaskService) checkChange(ctx context.Context, userId, documentId int, assigneeId *int) error {
+	permission, err := s.Documents.GetPermission(ctx, userId, documentId)
+	if err != nil {
+		return err
+	}
+	if permission != "owner" && permission != "edit" {
+		return ErrReadOnly
+	}
+
+	if assigneeId != nil && *assigneeId != userId {
+		hasAccess, err := s.Documents.HasDocumentAccess(ctx, *assigneeId, documentId)
+		if err != nil {
+			return err
+		}
+		if !hasAccess {
+			return ErrAssigneeNoAccess
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+type notification struct {
+	messageType string
+	payload     map[string]interface{}
+}
+
+type fakeNotifier struct {
+	sent []notification
+}
+
+func (n *fakeNotifier) TaskChanged(documentId, userId int, messageType string, payload map[string]interface{}) {
+	n.sent = append(n.sent, notification{messageType, payload})
+}
+
+func setupTaskTest(t *testing.T) (*gin.Engine, *fakeNotifier, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	notifier := &fakeNotifier{}
+	handler := &TaskHandler{
+		TaskService: &TaskService{DB: db, Documents: &documents.DocumentService{DB: db}},
+		Notifier:    notifier,
+	}
+
+	router := gin.New()
+	setContext := func(c *gin.Context) {
+		c.Set("userId", 1)
+		c.Set("documentId", 3)
+	}
+	router.POST("/api/documents/:id/tasks", setContext, handler.CreateTask)
+	router.PUT("/api/documents/:id/tasks/:task_id", setContext, handler.UpdateTask)
+	router.DELETE("/api/documents/:id/tasks/:task_id", setContext, handler.DeleteTask)
+	return router, notifier, mock
+}
+
+func expectPermission(mock sqlmock.Sqlmock, ownerId int, permission string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(ownerId))
+	if ownerId != 1 {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT permission FROM document_collaborators")).
+			WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow(permission))
+	}
+}
+
+func TestUpdateTask_MarksDone(t *testing.T) {
+	router, notifier, mock := setupTaskTest(t)
+	now := time.Now()
+
+	expectPermission(mock, 9, "edit")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE document_tasks")).
+		WithArgs(7, 3, "Draft the release notes", 2, true, 120).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "document_id", "title", "assignee_id", "done", "done_at", "anchor", "created_by", "created_at", "updated_at"}).
+			AddRow(7, 3, "Draft the release notes", 2, true, now, 120, 9, now, now))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/documents/3/tasks/7",
+		bytes.NewBufferString(`{"title": "Draft the release notes", "assignee_id": 2, "done": true, "anchor": 120}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var task Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	if !task.Done || task.DoneAt == nil || task.AssigneeId == nil || *task.AssigneeId != 2 {
+		t.Errorf("Unexpected task: %+v", task)
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].messageType != "task_updated" {
+		t.Errorf("Expected a task_updated message, got %+v", notifier.sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateTask_AssigneeWithoutAccess(t *testing.T) {
+	router, notifier, mock := setupTaskTest(t)
+
+	expectPermission(mock, 1, "")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).
+		WithArgs(3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/documents/3/tasks", bytes.NewBufferString(`{"title": "Review", "assignee_id": 5}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(notifier.sent) != 0 {
+		t.Errorf("Expected no messages, got %+v", notifier.sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteTask_ViewOnly(t *testing.T) {
+	router, _, mock := setupTaskTest(t)
+
+	expectPermission(mock, 9, "view")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/documents/3/tasks/7", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+package usage
+
+import (
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageHandler struct {
+	UsageService    *UsageService
+	DocumentService *documents.DocumentService
+}
+
+// GetUsage godoc
+// @Summary Get usage
+// @Description Get the authenticated user's usage: documents owned, their storage, and this month's events and WebSocket minutes. With organization_id, get the organization's usage instead; only members can see it.
+// @Tags usage
+// @Produce json
+// @Security BearerAuth
+// @Param organization_id query int false "Organization ID"
+// @Success 200 {object} Usage "Usage for the current month"
+// @Failure 400 {object} ErrorResponse "Invalid organization ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Access denied - not a member"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/usage [get]
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	userId := c.GetInt("userId")
+
+	organizationIdStr := c.Query("organization_id")
+	if organizationIdStr == "" {
+		usage, err := h.UsageService.GetUserUsage(c.Request.Context(), userId)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+			return
+		}
+
+		c.JSON(http.StatusOK, usage)
+		return
+	}
+
+	organizationId, err := strconv.Atoi(organizationIdStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	isMember, err := h.DocumentService.IsOrganizationMember(c.Request.Context(), organizationId, userId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check organization membership"})
+		return
+	}
+
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied - you are not a member of this organization"})
+		return
+	}
+
+	usage, err := h.UsageService.GetOrganizationUsage(c.Request.Context(), organizationId)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+type APIUsageQuery struct {
+	Days int `form:"days,default=30" binding:"min=1,max=90"`
+}
+
+// GetAPIUsage godoc
+// @Summary Get my API usage
+// @Description Get how many API requests and WebSocket messages the authenticated user made, and how many requests were rate limited, for each of the last days UTC days including today. Counts can lag by up to the usage flush interval.
+// @Tags usage
+// @Produce json
+// @Security BearerAuth
+// @Param days query int false "Number of days, up to 90" default(30)
+// @Success 200 {object} APIUsage "Daily API usage"
+// @Failure 400 {object} ErrorResponse "Invalid query parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/me/api-usage [get]
+func (h *UsageHandler) GetAPIUsage(c *gin.Context) {
+	var query APIUsageQuery
+	if !validation.BindQuery(c, &query) {
+		return
+	}
+
+	usage, err := h.UsageService.GetAPIUsage(c.Request.Context(), c.GetInt("userId"), query.Days)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// swagger models for usage
+
+type ErrorResponse struct {
+	Error  string                  `json:"error" example:"Error message"`
+	Fields []validation.FieldError `json:"fields,omitempty"`
+}
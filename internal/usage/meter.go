@@ -0,0 +1,177 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Metrics counted per user and per organization each calendar month.
+const (
+	MetricEvents           = "events"
+	MetricWebSocketSeconds = "websocket_seconds"
+)
+
+// Metrics counted per user each UTC day, for integrators to see their own
+// API consumption.
+const (
+	MetricAPIRequests       = "api_requests"
+	MetricWebSocketMessages = "websocket_messages"
+	MetricRateLimited       = "rate_limited"
+)
+
+type counterKey struct {
+	documentId int
+	userId     int
+	metric     string
+}
+
+type dailyKey struct {
+	userId int
+	// day is the UTC date the count was made on, so counts flushed after
+	// midnight still land on their day.
+	day    string
+	metric string
+}
+
+// Meter buffers usage increments in memory and adds them to the monthly
+// counters of the user and of the organization owning the document on
+// every flush, so metering costs no query on the edit path. It also keeps
+// users' daily API counters. A nil *Meter records nothing.
+type Meter struct {
+	DB *sql.DB
+
+	pending map[counterKey]int64
+	daily   map[dailyKey]int64
+	mutex   sync.Mutex
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// NewMeter returns a Meter that flushes every flushInterval until Close.
+func NewMeter(db *sql.DB, flushInterval time.Duration) *Meter {
+	m := &Meter{
+		DB:      db,
+		pending: make(map[counterKey]int64),
+		daily:   make(map[dailyKey]int64),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go m.run(flushInterval)
+	return m
+}
+
+// Add counts amount of metric against the user and the document's
+// organization, if any.
+func (m *Meter) Add(documentId, userId int, metric string, amount int64) {
+	if m == nil || userId == 0 || amount <= 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	m.pending[counterKey{documentId, userId, metric}] += amount
+	m.mutex.Unlock()
+}
+
+// Count adds one to the user's daily counter of metric.
+func (m *Meter) Count(userId int, metric string) {
+	if m == nil || userId == 0 {
+		return
+	}
+
+	key := dailyKey{userId, time.Now().UTC().Format(time.DateOnly), metric}
+	m.mutex.Lock()
+	if m.daily == nil {
+		m.daily = make(map[dailyKey]int64)
+	}
+	m.daily[key]++
+	m.mutex.Unlock()
+}
+
+// Close stops the periodic flush and writes any buffered counts.
+func (m *Meter) Close() {
+	if m == nil {
+		return
+	}
+	close(m.quit)
+	<-m.done
+}
+
+func (m *Meter) run(flushInterval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Flush(context.Background())
+		case <-m.quit:
+			m.Flush(context.Background())
+			return
+		}
+	}
+}
+
+// Flush writes the buffered counts to the database. Counts that fail to
+// write are kept for the next flush.
+func (m *Meter) Flush(ctx context.Context) {
+	m.mutex.Lock()
+	pending, daily := m.pending, m.daily
+	m.pending = make(map[counterKey]int64)
+	m.daily = make(map[dailyKey]int64)
+	m.mutex.Unlock()
+
+	for key, amount := range pending {
+		if err := m.increment(ctx, key, amount); err != nil {
+			slog.WarnContext(ctx, "Failed to record usage", "metric", key.metric, "user_id", key.userId, "document_id", key.documentId, "error", err)
+
+			m.mutex.Lock()
+			m.pending[key] += amount
+			m.mutex.Unlock()
+		}
+	}
+
+	for key, amount := range daily {
+		if err := m.incrementDaily(ctx, key, amount); err != nil {
+			slog.WarnContext(ctx, "Failed to record API usage", "metric", key.metric, "user_id", key.userId, "day", key.day, "error", err)
+
+			m.mutex.Lock()
+			m.daily[key] += amount
+			m.mutex.Unlock()
+		}
+	}
+}
+
+func (m *Meter) increment(ctx context.Context, key counterKey, amount int64) error {
+	_, err := m.DB.ExecContext(ctx, `
+		INSERT INTO usage_counters (scope, scope_id, period, metric, value)
+		SELECT 'user', $1::int, date_trunc('month', now())::date, $3::text, $4::bigint
+		UNION ALL
+		SELECT 'organization', organization_id, date_trunc('month', now())::date, $3::text, $4::bigint
+		FROM documents WHERE id = $2 AND organization_id IS NOT NULL
+		ON CONFLICT (scope, scope_id, period, metric)
+		DO UPDATE SET value = usage_counters.value + EXCLUDED.value
+	`, key.userId, key.documentId, key.metric, amount)
+	if err != nil {
+		return fmt.Errorf("failed to increment usage counter: %v", err)
+	}
+	return nil
+}
+
+func (m *Meter) incrementDaily(ctx context.Context, key dailyKey, amount int64) error {
+	_, err := m.DB.ExecContext(ctx, `
+		INSERT INTO api_usage_daily (user_id, day, metric, value)
+		VALUES ($1, $2::date, $3, $4)
+		ON CONFLICT (user_id, day, metric)
+		DO UPDATE SET value = api_usage_daily.value + EXCLUDED.value
+	`, key.userId, key.day, key.metric, amount)
+	if err != nil {
+		return fmt.Errorf("failed to increment API usage counter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package usage
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware counts every authenticated request toward the user's API
+// usage, and the ones rejected with 429 as rate limited. It must run after
+// authentication and before the rate limit.
+func (m *Meter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.GetInt("userId")
+		m.Count(userId, MetricAPIRequests)
+
+		c.Next()
+
+		if c.Writer.Status() == http.StatusTooManyRequests {
+			m.Count(userId, MetricRateLimited)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// APIUsageRetention is how long daily API usage counters are kept, and so
+// the furthest back GET /api/me/api-usage reaches.
+const APIUsageRetention = 90 * 24 * time.Hour
+
+type UsageService struct {
+	DB *sql.DB
+}
+
+// Usage is the resource consumption of a user or organization. Documents
+// and StorageBytes are current totals; the other counters cover the
+// calendar month starting at Period.
+type Usage struct {
+	Period       string `json:"period"`
+	Documents    int    `json:"documents"`
+	StorageBytes int64  `json:"storage_bytes"`
+	Events       int64  `json:"events"`
+	// WebSocketMinutes is the time spent connected to documents, rounded
+	// down to whole minutes.
+	WebSocketMinutes int64 `json:"websocket_minutes"`
+}
+
+// GetUserUsage returns the usage of the documents a user owns and of the
+// user's activity this month.
+func (s *UsageService) GetUserUsage(ctx context.Context, userId int) (*Usage, error) {
+	return s.getUsage(ctx, "user", userId, "owner_id")
+}
+
+// GetOrganizationUsage returns the usage of an organization's documents and
+// of its documents' activity this month.
+func (s *UsageService) GetOrganizationUsage(ctx context.Context, organizationId int) (*Usage, error) {
+	return s.getUsage(ctx, "organization", organizationId, "organization_id")
+}
+
+// getUsage combines the live document totals matching column with the
+// counters recorded for the scope this month.
+func (s *UsageService) getUsage(ctx context.Context, scope string, scopeId int, column string) (*Usage, error) {
+	var usage Usage
+	var websocketSeconds int64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			to_char(date_trunc('month', now()), 'YYYY-MM-DD'),
+			(SELECT COUNT(*) FROM documents WHERE `+column+` = $2),
+			(SELECT COALESCE(SUM(OCTET_LENGTH(content)), 0) FROM documents WHERE `+column+` = $2),
+			COALESCE(SUM(value) FILTER (WHERE metric = $3), 0),
+			COALESCE(SUM(value) FILTER (WHERE metric = $4), 0)
+		FROM usage_counters
+		WHERE scope = $1 AND scope_id = $2 AND period = date_trunc('month', now())::date
+	`, scope, scopeId, MetricEvents, MetricWebSocketSeconds).Scan(
+		&usage.Period, &usage.Documents, &usage.StorageBytes, &usage.Events, &websocketSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %v", err)
+	}
+
+	usage.WebSocketMinutes = websocketSeconds / 60
+	return &usage, nil
+}
+
+// APIUsageDay is a user's API usage on one UTC day.
+type APIUsageDay struct {
+	Day               string `json:"day" example:"2024-05-01"`
+	Requests          int64  `json:"requests"`
+	WebSocketMessages int64  `json:"websocket_messages"`
+	RateLimited       int64  `json:"rate_limited"`
+}
+
+// APIUsage is a user's API usage over the last days, oldest day first,
+// with totals across them.
+type APIUsage struct {
+	Days              []APIUsageDay `json:"days"`
+	Requests          int64         `json:"requests"`
+	WebSocketMessages int64         `json:"websocket_messages"`
+	RateLimited       int64         `json:"rate_limited"`
+}
+
+// GetAPIUsage returns a user's API usage for each of the last days UTC
+// days, including today. Days without usage are reported as zeros. Counts
+// from the last flush interval may not be recorded yet.
+func (s *UsageService) GetAPIUsage(ctx context.Context, userId, days int) (*APIUsage, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT
+			to_char(d.day, 'YYYY-MM-DD'),
+			COALESCE(SUM(u.value) FILTER (WHERE u.metric = $3), 0),
+			COALESCE(SUM(u.value) FILTER (WHERE u.metric = $4), 0),
+			COALESCE(SUM(u.value) FILTER (WHERE u.metric = $5), 0)
+		FROM generate_series(
+			(now() AT TIME ZONE 'UTC')::date - ($2 - 1),
+			(now() AT TIME ZONE 'UTC')::date,
+			interval '1 day'
+		) AS d(day)
+		LEFT JOIN api_usage_daily u ON u.user_id = $1 AND u.day = d.day
+		GROUP BY d.day
+		ORDER BY d.day
+	`, userId, days, MetricAPIRequests, MetricWebSocketMessages, MetricRateLimited)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API usage: %v", err)
+	}
+	defer rows.Close()
+
+	usage := &APIUsage{Days: []APIUsageDay{}}
+	for rows.Next() {
+		var day APIUsageDay
+		if err := rows.Scan(&day.Day, &day.Requests, &day.WebSocketMessages, &day.RateLimited); err != nil {
+			return nil, fmt.Errorf("failed to scan API usage: %v", err)
+		}
+		usage.Days = append(usage.Days, day)
+		usage.Requests += day.Requests
+		usage.WebSocketMessages += day.WebSocketMessages
+		usage.RateLimited += day.RateLimited
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get API usage: %v", err)
+	}
+	return usage, nil
+}
+
+// PruneAPIUsage deletes daily API usage counters older than maxAge and
+// returns how many were deleted.
+func (s *UsageService) PruneAPIUsage(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM api_usage_daily
+		WHERE day < (now() AT TIME ZONE 'UTC')::date - $1
+	`, int(maxAge.Hours()/24))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune API usage: %v", err)
+	}
+	return result.RowsAffected()
+}
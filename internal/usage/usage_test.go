@@ -0,0 +1,215 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"live-collab-api/internal/documents"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMeter_FlushAggregates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	meter := &Meter{DB: db, pending: make(map[counterKey]int64)}
+	meter.Add(1, 2, MetricEvents, 1)
+	meter.Add(1, 2, MetricEvents, 1)
+	meter.Add(1, 0, MetricEvents, 1)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO usage_counters")).
+		WithArgs(2, 1, MetricEvents, int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	meter.Flush(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMeter_FlushKeepsFailedCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	meter := &Meter{DB: db, pending: make(map[counterKey]int64)}
+	meter.Add(1, 2, MetricWebSocketSeconds, 90)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO usage_counters")).
+		WillReturnError(errors.New("connection refused"))
+
+	meter.Flush(context.Background())
+
+	if got := meter.pending[counterKey{1, 2, MetricWebSocketSeconds}]; got != 90 {
+		t.Errorf("Expected 90 seconds to be kept, got %d", got)
+	}
+}
+
+func TestMeter_NilIsNoop(t *testing.T) {
+	var meter *Meter
+	meter.Add(1, 2, MetricEvents, 1)
+	meter.Count(2, MetricAPIRequests)
+	meter.Close()
+}
+
+func TestMeter_MiddlewareCountsRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	meter := &Meter{DB: db, pending: make(map[counterKey]int64)}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", 2)
+		c.Next()
+	}, meter.Middleware())
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/limited", func(c *gin.Context) { c.AbortWithStatus(http.StatusTooManyRequests) })
+
+	for _, path := range []string{"/ok", "/ok", "/limited"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_usage_daily")).
+		WithArgs(2, sqlmock.AnyArg(), MetricAPIRequests, int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_usage_daily")).
+		WithArgs(2, sqlmock.AnyArg(), MetricRateLimited, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	meter.Flush(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func setupUsageTest(t *testing.T, userId int) (sqlmock.Sqlmock, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	handler := &UsageHandler{
+		UsageService:    &UsageService{DB: db},
+		DocumentService: &documents.DocumentService{DB: db},
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userId", userId)
+		c.Next()
+	})
+	r.GET("/usage", handler.GetUsage)
+	r.GET("/api-usage", handler.GetAPIUsage)
+	return mock, r
+}
+
+func TestGetUsage_User(t *testing.T) {
+	mock, r := setupUsageTest(t, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM usage_counters")).
+		WithArgs("user", 1, MetricEvents, MetricWebSocketSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"period", "documents", "storage_bytes", "events", "websocket_seconds"}).
+			AddRow("2026-10-01", 3, 1024, 57, 600))
+
+	req, _ := http.NewRequest("GET", "/usage", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var usage Usage
+	json.Unmarshal(w.Body.Bytes(), &usage)
+
+	if usage.Documents != 3 || usage.StorageBytes != 1024 || usage.Events != 57 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+
+	if usage.WebSocketMinutes != 10 {
+		t.Errorf("Expected 10 WebSocket minutes, got %d", usage.WebSocketMinutes)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUsage_OrganizationNotMember(t *testing.T) {
+	mock, r := setupUsageTest(t, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM organization_members")).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req, _ := http.NewRequest("GET", "/usage?organization_id=5", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAPIUsage(t *testing.T) {
+	mock, r := setupUsageTest(t, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN api_usage_daily u ON u.user_id = $1 AND u.day = d.day")).
+		WithArgs(1, 2, MetricAPIRequests, MetricWebSocketMessages, MetricRateLimited).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "requests", "websocket_messages", "rate_limited"}).
+			AddRow("2026-10-15", 0, 0, 0).
+			AddRow("2026-10-16", 120, 40, 3))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api-usage?days=2", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var usage APIUsage
+	json.Unmarshal(w.Body.Bytes(), &usage)
+	if len(usage.Days) != 2 || usage.Requests != 120 || usage.WebSocketMessages != 40 || usage.RateLimited != 3 {
+		t.Errorf("Unexpected API usage: %+v", usage)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAPIUsage_TooManyDays(t *testing.T) {
+	_, r := setupUsageTest(t, 1)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api-usage?days=365", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
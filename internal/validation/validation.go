@@ -0,0 +1,185 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Requests are checked against the constraints declared in the binding
+// tags of their structs, e.g. `binding:"required,max=200"`. Every invalid
+// field is reported by the name the client sent it under.
+
+// FieldError describes a single invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field" example:"title"`
+	Message string `json:"message" example:"must be at most 200 characters"`
+}
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(fieldName)
+	}
+}
+
+// fieldName names struct fields by their json or form tag.
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// BindJSON decodes the request body into req and validates it. When that
+// fails it responds with 400, or 413 for a body over MaxBodySize, and
+// returns false.
+func BindJSON(c *gin.Context, req interface{}) bool {
+	err := c.ShouldBindWith(req, binding.JSON)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit)})
+		return false
+	}
+	respond(c, "Invalid request body", err)
+	return false
+}
+
+// BindQuery decodes the query string into req using its form tags and
+// validates it, responding with 400 and returning false when that fails.
+func BindQuery(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindQuery(req); err != nil {
+		respond(c, "Invalid query parameters", err)
+		return false
+	}
+	return true
+}
+
+// Page holds the limit and offset query parameters of a listing,
+// defaulting to 50 and 0. Bind it with BindQuery.
+type Page struct {
+	Limit  int `form:"limit,default=50" binding:"min=1,max=1000"`
+	Offset int `form:"offset,default=0" binding:"min=0"`
+}
+
+func respond(c *gin.Context, message string, err error) {
+	fields := Fields(err)
+	if len(fields) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": fields})
+}
+
+// Fields lists the invalid fields behind a binding error, or nothing when
+// the request couldn't be decoded at all.
+func Fields(err error) []FieldError {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]FieldError, 0, len(validationErrors))
+		for _, fe := range validationErrors {
+			fields = append(fields, FieldError{Field: path(fe), Message: describe(fe)})
+		}
+		return fields
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) && typeError.Field != "" {
+		return []FieldError{{Field: typeError.Field, Message: "must be " + article(typeError.Type.Kind())}}
+	}
+	if errors.Is(err, io.EOF) {
+		return []FieldError{{Field: "body", Message: "is required"}}
+	}
+	return nil
+}
+
+// path drops the struct name from a field's namespace, leaving e.g.
+// keys[0].user_id.
+func path(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func describe(fe validator.FieldError) string {
+	kind := fe.Kind()
+	if kind == reflect.Ptr {
+		kind = fe.Type().Elem().Kind()
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "min", "gte":
+		return bound("at least", fe.Param(), kind)
+	case "max", "lte":
+		return bound("at most", fe.Param(), kind)
+	}
+	return "is invalid"
+}
+
+func bound(comparison, limit string, kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return fmt.Sprintf("must be %s %s characters", comparison, limit)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("must have %s %s items", comparison, limit)
+	}
+	return fmt.Sprintf("must be %s %s", comparison, limit)
+}
+
+func article(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// MaxBodySize rejects request bodies over limit bytes. Bodies are read
+// lazily, so the limit is reported by BindJSON once it is reached.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body must be at most %d bytes", limit)})
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,125 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testRequest struct {
+	Title string   `json:"title" binding:"required,max=5"`
+	Role  string   `json:"role" binding:"omitempty,oneof=view edit"`
+	Tags  []string `json:"tags" binding:"max=2"`
+	Count int      `json:"count" binding:"min=0"`
+}
+
+type testQuery struct {
+	Limit int `form:"limit,default=20" binding:"min=1,max=100"`
+}
+
+type errorBody struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+func setupRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/body", MaxBodySize(128), func(c *gin.Context) {
+		var req testRequest
+		if !BindJSON(c, &req) {
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+	r.GET("/query", func(c *gin.Context) {
+		var req testQuery
+		if !BindQuery(c, &req) {
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+	return r
+}
+
+func serve(r *gin.Engine, method, path, body string) (int, errorBody) {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response errorBody
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func fieldList(fields []FieldError) string {
+	var parts []string
+	for _, fe := range fields {
+		parts = append(parts, fe.Field+" "+fe.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestBindJSON_ReportsEveryInvalidField(t *testing.T) {
+	r := setupRouter()
+
+	code, response := serve(r, "POST", "/body", `{"title": "too long", "role": "admin", "tags": ["a", "b", "c"], "count": -1}`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+	expected := "title must be at most 5 characters; role must be one of: view, edit; tags must have at most 2 items; count must be at least 0"
+	if got := fieldList(response.Fields); got != expected {
+		t.Errorf("Expected fields %q, got %q", expected, got)
+	}
+
+	code, response = serve(r, "POST", "/body", `{"title": 5}`)
+	if code != http.StatusBadRequest || fieldList(response.Fields) != "title must be a string" {
+		t.Errorf("Expected a type error on title, got %d: %+v", code, response)
+	}
+
+	code, response = serve(r, "POST", "/body", ``)
+	if code != http.StatusBadRequest || fieldList(response.Fields) != "body is required" {
+		t.Errorf("Expected a missing body error, got %d: %+v", code, response)
+	}
+
+	if code, _ := serve(r, "POST", "/body", `{"title": "ok"}`); code != http.StatusOK {
+		t.Errorf("Expected status %d for a valid request, got %d", http.StatusOK, code)
+	}
+}
+
+func TestBindQuery_EnforcesBounds(t *testing.T) {
+	r := setupRouter()
+
+	code, response := serve(r, "GET", "/query?limit=500", "")
+	if code != http.StatusBadRequest || fieldList(response.Fields) != "limit must be at most 100" {
+		t.Errorf("Expected a bound error on limit, got %d: %+v", code, response)
+	}
+
+	if code, _ := serve(r, "GET", "/query", ""); code != http.StatusOK {
+		t.Errorf("Expected the default limit to be accepted, got %d", code)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	r := setupRouter()
+
+	code, _ := serve(r, "POST", "/body", `{"title": "`+strings.Repeat("x", 200)+`"}`)
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+
+	// a body without a declared length is cut off while it is read
+	req, _ := http.NewRequest("POST", "/body", strings.NewReader(`{"title": "`+strings.Repeat("x", 200)+`"}`))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d for a streamed body, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
@@ -0,0 +1,357 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"live-collab-api/internal/ipfilter"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultMaxAttempts is how many times a delivery is tried before it
+	// is marked failed.
+	defaultMaxAttempts = 6
+	// pollInterval is how often idle workers look for deliveries due for
+	// retry.
+	pollInterval = 5 * time.Second
+	// deliveryLease is how long a claimed delivery is hidden from other
+	// workers. A worker that dies mid-delivery leaves it to be retried
+	// once the lease runs out.
+	deliveryLease = 5 * time.Minute
+	// maxBackoff caps the delay between attempts.
+	maxBackoff = time.Hour
+)
+
+// Dispatcher delivers webhook events in the background. Events are stored
+// as pending deliveries, so they survive restarts and are shared between
+// instances, and a pool of workers claims and sends them, retrying
+// failures with exponential backoff. A nil *Dispatcher dispatches nothing.
+type Dispatcher struct {
+	DB     *sql.DB
+	Client *http.Client
+	// MaxAttempts defaults to 6.
+	MaxAttempts int
+
+	wake chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewDispatcher starts a dispatcher with the given number of workers.
+func NewDispatcher(db *sql.DB, workers int) *Dispatcher {
+	d := &Dispatcher{
+		DB:     db,
+		Client: newClient(),
+		wake:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// ErrForbiddenAddress is returned for deliveries to URLs that resolve to a
+// loopback, private, or otherwise internal address.
+var ErrForbiddenAddress = errors.New("webhook URL resolves to a non-public address")
+
+// newClient returns the client deliveries are sent with. Anyone can
+// register a webhook and read back the status it responded with, so it
+// only connects to public addresses, checked after DNS resolution, and
+// doesn't follow redirects, which could lead anywhere.
+func newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: checkAddress}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// a proxy would be dialed instead of the target, bypassing
+			// the address check
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAddress runs before each connection is made, with the resolved IP
+// address being dialed.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !ipfilter.IsPublic(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// Dispatch queues a delivery of event to each active webhook subscribed to
+// it: the user's own webhooks, and, when data has a "document_id", the
+// webhooks following that document or its organization. data becomes the
+// "data" field of the payload. Notifications such as shares skip webhooks
+// whose owner turned them off.
+func (d *Dispatcher) Dispatch(ctx context.Context, userId int, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"data":      data,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook payload", "event", event, "error", err)
+		return
+	}
+
+	var envelope struct {
+		Data eventData `json:"data"`
+	}
+	json.Unmarshal(payload, &envelope)
+
+	result, err := d.DB.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2::text, $3::jsonb FROM webhooks
+		WHERE active AND $2::text = ANY(string_to_array(events, ','))
+			AND CASE
+				WHEN document_id IS NOT NULL THEN document_id = $4
+				WHEN organization_id IS NOT NULL THEN organization_id = (SELECT organization_id FROM documents WHERE id = $4)
+				ELSE user_id = $1
+			END
+			AND NOT EXISTS (
+				SELECT 1 FROM notification_preferences np
+				WHERE np.user_id = webhooks.user_id AND np.class = $5 AND np.channel = 'webhook' AND NOT np.enabled
+			)
+	`, userId, event, string(payload), envelope.Data.DocumentId, eventClasses[event])
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to queue webhook deliveries", "event", event, "user_id", userId, "error", err)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		d.Wake()
+	}
+}
+
+// Wake has an idle worker check for due deliveries right away.
+func (d *Dispatcher) Wake() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the workers once their current deliveries finish. Pending
+// deliveries are picked up again on the next start.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	close(d.quit)
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			select {
+			case <-d.quit:
+				return
+			default:
+			}
+
+			claimed, err := d.deliverNext(context.Background())
+			if err != nil {
+				slog.Warn("Failed to claim webhook delivery", "error", err)
+				break
+			}
+			if !claimed {
+				break
+			}
+		}
+
+		select {
+		case <-d.quit:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+type claimedDelivery struct {
+	id       int
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+	format   string
+}
+
+// deliverNext claims the oldest due delivery and sends it. It reports
+// whether there was one.
+func (d *Dispatcher) deliverNext(ctx context.Context) (bool, error) {
+	var delivery claimedDelivery
+	err := d.DB.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = now() + $1 * interval '1 second'
+		FROM webhooks w
+		WHERE d.id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) AND w.id = d.webhook_id
+		RETURNING d.id, d.event, d.payload, d.attempts, w.url, w.secret, w.format
+	`, int(deliveryLease.Seconds())).Scan(&delivery.id, &delivery.event, &delivery.payload, &delivery.attempts, &delivery.url, &delivery.secret, &delivery.format)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// Let another worker pick up the next one in parallel
+	d.Wake()
+
+	responseStatus, err := d.send(ctx, &delivery)
+	d.record(ctx, &delivery, responseStatus, err)
+	return true, nil
+}
+
+// send POSTs the payload, or the chat message describing it for Slack and
+// Discord webhooks, and returns the response status. Non-2xx responses are
+// errors.
+func (d *Dispatcher) send(ctx context.Context, delivery *claimedDelivery) (int, error) {
+	body := delivery.payload
+	if delivery.format == FormatSlack || delivery.format == FormatDiscord {
+		var err error
+		if body, err = d.chatPayload(ctx, delivery.format, delivery.payload); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "live-collab-api-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.id))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(delivery.secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, scheduling a retry or marking
+// the delivery failed once it runs out of attempts.
+func (d *Dispatcher) record(ctx context.Context, delivery *claimedDelivery, responseStatus int, sendErr error) {
+	var status sql.NullInt64
+	if responseStatus != 0 {
+		status = sql.NullInt64{Int64: int64(responseStatus), Valid: true}
+	}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = d.DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'delivered', response_status = $2, last_error = NULL, delivered_at = now()
+			WHERE id = $1
+		`, delivery.id, status)
+	case delivery.attempts >= d.maxAttempts():
+		slog.Warn("Webhook delivery failed", "delivery_id", delivery.id, "attempts", delivery.attempts, "error", sendErr)
+		_, err = d.DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'failed', response_status = $2, last_error = $3
+			WHERE id = $1
+		`, delivery.id, status, sendErr.Error())
+	default:
+		_, err = d.DB.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET response_status = $2, last_error = $3, next_attempt_at = now() + $4 * interval '1 second'
+			WHERE id = $1
+		`, delivery.id, status, sendErr.Error(), int(Backoff(delivery.attempts).Seconds()))
+	}
+	if err != nil {
+		slog.Error("Failed to record webhook delivery", "delivery_id", delivery.id, "error", err)
+	}
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// Backoff returns the delay before retrying after the given number of
+// attempts: 30s, 1m, 2m, and so on, capped at an hour.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 8 {
+		return maxBackoff
+	}
+	delay := 30 * time.Second << (attempts - 1)
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// Sign returns the hex HMAC-SHA256 of "timestamp.body" keyed with the
+// webhook's secret. Receivers recompute it to verify the
+// X-Webhook-Signature header, and reject old timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
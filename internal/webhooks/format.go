@@ -0,0 +1,119 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// eventData holds the fields of event data that chat messages mention.
+// Every event carries a document_id and the user_id of the user it is about.
+type eventData struct {
+	DocumentId int    `json:"document_id"`
+	UserId     int    `json:"user_id"`
+	Title      string `json:"title"`
+	Permission string `json:"permission"`
+	EventType  string `json:"event_type"`
+	Published  *bool  `json:"published"`
+	// Scheduled is set on document.published events made by a publish
+	// schedule rather than by the user.
+	Scheduled bool `json:"scheduled"`
+}
+
+// chatPayload turns a stored event payload into the body of a Slack or
+// Discord incoming webhook request. The document title and user email are
+// looked up, as the event data only carries their IDs.
+func (d *Dispatcher) chatPayload(ctx context.Context, format string, payload []byte) ([]byte, error) {
+	var envelope struct {
+		Event string    `json:"event"`
+		Data  eventData `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %v", err)
+	}
+
+	var title, email sql.NullString
+	err := d.DB.QueryRowContext(ctx, `
+		SELECT (SELECT title FROM documents WHERE id = $1), (SELECT email FROM users WHERE id = $2)
+	`, envelope.Data.DocumentId, envelope.Data.UserId).Scan(&title, &email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up event details: %v", err)
+	}
+
+	data := envelope.Data
+	if data.Title == "" {
+		data.Title = title.String
+	}
+	user := email.String
+	if user == "" {
+		user = fmt.Sprintf("User #%d", data.UserId)
+	}
+
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]interface{}{
+			"text": chatMessage(envelope.Event, data, user, slackBold),
+		})
+	case FormatDiscord:
+		return json.Marshal(map[string]interface{}{
+			"content": chatMessage(envelope.Event, data, user, discordBold),
+			// never ping @everyone or anyone else named in a title
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		})
+	}
+	return nil, fmt.Errorf("unknown webhook format %q", format)
+}
+
+// chatMessage describes an event in one sentence, with the user and
+// document highlighted by bold.
+func chatMessage(event string, data eventData, user string, bold func(string) string) string {
+	document := data.Title
+	if document == "" {
+		document = fmt.Sprintf("document #%d", data.DocumentId)
+	}
+	user, document = bold(user), bold(document)
+
+	switch event {
+	case EventDocumentCreated:
+		return fmt.Sprintf("%s created %s", user, document)
+	case EventDocumentUpdated:
+		return fmt.Sprintf("%s updated %s", user, document)
+	case EventDocumentDeleted:
+		return fmt.Sprintf("%s deleted %s", user, document)
+	case EventDocumentPublished:
+		if data.Scheduled {
+			if data.Published != nil && !*data.Published {
+				return fmt.Sprintf("%s was unpublished as scheduled by %s", document, user)
+			}
+			return fmt.Sprintf("%s was published as scheduled by %s", document, user)
+		}
+		if data.Published != nil && !*data.Published {
+			return fmt.Sprintf("%s unpublished %s", user, document)
+		}
+		return fmt.Sprintf("%s published %s", user, document)
+	case EventCollaboratorAdded:
+		return fmt.Sprintf("%s can now %s %s", user, data.Permission, document)
+	case EventCollaboratorRemoved:
+		return fmt.Sprintf("%s no longer has access to %s", user, document)
+	case EventEventCreated:
+		return fmt.Sprintf("%s added a %s event to %s", user, data.EventType, document)
+	case EventTest:
+		return fmt.Sprintf("%s sent a test delivery for %s", user, document)
+	}
+	return fmt.Sprintf("%s: %s by %s", event, document, user)
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackBold uses Slack's mrkdwn, in which only &, <, and > need escaping.
+func slackBold(text string) string {
+	return "*" + slackEscaper.Replace(text) + "*"
+}
+
+var discordEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`)
+
+func discordBold(text string) string {
+	return "**" + discordEscaper.Replace(text) + "**"
+}
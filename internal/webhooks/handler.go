@@ -0,0 +1,414 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	WebhookService *WebhookService
+	// Dispatcher, when set, is woken to send redeliveries right away.
+	Dispatcher *Dispatcher
+}
+
+// CreateWebhook godoc
+// @Summary Create a webhook
+// @Description Register a URL to receive events for documents you own, for a single document you own (document_id), or for all documents of an organization you administer (organization_id). Deliveries are POSTed as JSON and signed: X-Webhook-Signature is "sha256=" followed by the hex HMAC-SHA256 of "{X-Webhook-Timestamp}.{body}" keyed with the secret. The secret is only returned here. With format "slack" or "discord" the URL is an incoming webhook of that service and receives a chat message describing each event instead.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateWebhookRequest true "Webhook data"
+// @Success 201 {object} Webhook "Webhook created successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not the document owner or an organization admin"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkTarget(c, &req.WebhookTarget) {
+		return
+	}
+
+	if req.DocumentId != nil && req.OrganizationId != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A webhook can follow a document or an organization, not both"})
+		return
+	}
+
+	webhook, err := h.WebhookService.CreateWebhook(c.Request.Context(), c.GetInt("userId"), req.URL, req.Events, req.Format, req.Scope)
+	if err != nil {
+		if errors.Is(err, ErrScopeForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the document owner or an organization owner or admin can add this webhook"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// checkTarget validates the URL, events, and format of a new webhook,
+// defaulting the format to json. It responds with 400 and returns false
+// when any is invalid.
+func checkTarget(c *gin.Context, target *WebhookTarget) bool {
+	if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+		return false
+	}
+
+	for _, event := range target.Events {
+		if !ValidEvent(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event: " + event})
+			return false
+		}
+	}
+
+	if target.Format == "" {
+		target.Format = FormatJSON
+	}
+	if !ValidFormat(target.Format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json, slack, or discord"})
+		return false
+	}
+	return true
+}
+
+// GetUserWebhooks godoc
+// @Summary List my webhooks
+// @Description List the webhooks registered by the authenticated user. Secrets are not included.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} WebhookListResponse "List of webhooks"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/webhooks [get]
+func (h *WebhookHandler) GetUserWebhooks(c *gin.Context) {
+	webhooks, err := h.WebhookService.GetUserWebhooks(c.Request.Context(), c.GetInt("userId"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Delete a webhook along with its delivery log.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} MessageResponse "Webhook deleted successfully"
+// @Failure 400 {object} ErrorResponse "Invalid webhook ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "Webhook not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	if err := h.WebhookService.DeleteWebhook(c.Request.Context(), c.GetInt("userId"), webhookId); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// GetDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List a webhook's deliveries with their status, attempts, and last response, newest first.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param limit query int false "Number of deliveries to return (default 50, max 1000)" default(50)
+// @Param offset query int false "Number of deliveries to skip (default 0)" default(0)
+// @Success 200 {object} DeliveryListResponse "List of deliveries"
+// @Failure 400 {object} ErrorResponse "Invalid webhook ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "Webhook not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	deliveries, err := h.WebhookService.GetDeliveries(c.Request.Context(), c.GetInt("userId"), webhookId, limit, offset)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "limit": limit, "offset": offset})
+}
+
+// Redeliver godoc
+// @Summary Redeliver a webhook delivery
+// @Description Queue a delivery to be sent again right away with a fresh set of retries.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Success 202 {object} MessageResponse "Delivery queued"
+// @Failure 400 {object} ErrorResponse "Invalid ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 404 {object} ErrorResponse "Delivery not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	deliveryId, err := strconv.Atoi(c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	if err := h.WebhookService.Redeliver(c.Request.Context(), c.GetInt("userId"), webhookId, deliveryId); err != nil {
+		if errors.Is(err, ErrDeliveryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver"})
+		return
+	}
+
+	h.Dispatcher.Wake()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
+
+// CreateDocumentWebhook godoc
+// @Summary Add a webhook to a document
+// @Description Register a URL to receive the given events for this document. Only the document owner can add one. Deliveries are signed as for POST /api/webhooks, and the secret is only returned here.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body WebhookTarget true "Webhook data"
+// @Success 201 {object} Webhook "Webhook created successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input data"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not the document owner"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/webhooks [post]
+func (h *WebhookHandler) CreateDocumentWebhook(c *gin.Context) {
+	var req WebhookTarget
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !checkTarget(c, &req) {
+		return
+	}
+
+	documentId := c.GetInt("documentId")
+	webhook, err := h.WebhookService.CreateWebhook(c.Request.Context(), c.GetInt("userId"), req.URL, req.Events, req.Format, Scope{DocumentId: &documentId})
+	if err != nil {
+		if errors.Is(err, ErrScopeForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the document owner can manage its webhooks"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// GetDocumentWebhooks godoc
+// @Summary List a document's webhooks
+// @Description List the webhooks following this document. Only the document owner can list them. Secrets are not included.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} WebhookListResponse "List of webhooks"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not the document owner"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/webhooks [get]
+func (h *WebhookHandler) GetDocumentWebhooks(c *gin.Context) {
+	webhooks, err := h.WebhookService.GetDocumentWebhooks(c.Request.Context(), c.GetInt("userId"), c.GetInt("documentId"))
+	if err != nil {
+		if errors.Is(err, ErrNotDocumentOwner) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the document owner can manage its webhooks"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// DeleteDocumentWebhook godoc
+// @Summary Delete a document's webhook
+// @Description Delete a webhook following this document, whoever added it, along with its delivery log. Only the document owner can delete it.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param webhook_id path int true "Webhook ID"
+// @Success 200 {object} MessageResponse "Webhook deleted successfully"
+// @Failure 400 {object} ErrorResponse "Invalid webhook ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not the document owner"
+// @Failure 404 {object} ErrorResponse "Webhook not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/webhooks/{webhook_id} [delete]
+func (h *WebhookHandler) DeleteDocumentWebhook(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	if err := h.WebhookService.DeleteDocumentWebhook(c.Request.Context(), c.GetInt("userId"), c.GetInt("documentId"), webhookId); err != nil {
+		switch {
+		case errors.Is(err, ErrNotDocumentOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the document owner can manage its webhooks"})
+		case errors.Is(err, ErrWebhookNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// TestDocumentWebhook godoc
+// @Summary Send a test delivery
+// @Description Queue a webhook.test delivery to a webhook following this document, regardless of the events it subscribes to. Its outcome is listed with the webhook's deliveries. Only the document owner can send one.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param webhook_id path int true "Webhook ID"
+// @Success 202 {object} TestDeliveryResponse "Test delivery queued"
+// @Failure 400 {object} ErrorResponse "Invalid webhook ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} ErrorResponse "Not the document owner"
+// @Failure 404 {object} ErrorResponse "Webhook not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/webhooks/{webhook_id}/test [post]
+func (h *WebhookHandler) TestDocumentWebhook(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	deliveryId, err := h.WebhookService.TestDocumentWebhook(c.Request.Context(), c.GetInt("userId"), c.GetInt("documentId"), webhookId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotDocumentOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the document owner can manage its webhooks"})
+		case errors.Is(err, ErrWebhookNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		default:
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send test delivery"})
+		}
+		return
+	}
+
+	h.Dispatcher.Wake()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Test delivery queued", "delivery_id": deliveryId})
+}
+
+// swagger models for webhooks
+
+type CreateWebhookRequest struct {
+	WebhookTarget
+	Scope
+}
+
+// WebhookTarget is where a webhook delivers to and which events it
+// receives.
+type WebhookTarget struct {
+	URL    string   `json:"url" binding:"required" example:"https://example.com/hooks/collab"`
+	Events []string `json:"events" binding:"required,min=1" example:"document.created,collaborator.added"`
+	// Format is json (the default), slack, or discord.
+	Format string `json:"format" example:"slack"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type DeliveryListResponse struct {
+	Deliveries []Delivery `json:"deliveries"`
+	Limit      int        `json:"limit" example:"50"`
+	Offset     int        `json:"offset" example:"0"`
+}
+
+type TestDeliveryResponse struct {
+	Message    string `json:"message" example:"Test delivery queued"`
+	DeliveryId int    `json:"delivery_id" example:"42"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message" example:"Webhook deleted successfully"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error" example:"Error message"`
+}
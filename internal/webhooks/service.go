@@ -0,0 +1,376 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/notifications"
+	"strings"
+	"time"
+)
+
+// Events webhooks can subscribe to.
+const (
+	EventDocumentCreated     = "document.created"
+	EventDocumentUpdated     = "document.updated"
+	EventDocumentDeleted     = "document.deleted"
+	EventDocumentPublished   = "document.published"
+	EventCollaboratorAdded   = "collaborator.added"
+	EventCollaboratorRemoved = "collaborator.removed"
+	EventEventCreated        = "event.created"
+	// EventTest is only sent by test deliveries and can't be subscribed to.
+	EventTest = "webhook.test"
+)
+
+var validEvents = map[string]bool{
+	EventDocumentCreated:     true,
+	EventDocumentUpdated:     true,
+	EventDocumentDeleted:     true,
+	EventDocumentPublished:   true,
+	EventCollaboratorAdded:   true,
+	EventCollaboratorRemoved: true,
+	EventEventCreated:        true,
+}
+
+// eventClasses maps the events that are notifications to their class, so
+// webhook owners who turned the class off for webhooks don't get them.
+var eventClasses = map[string]string{
+	EventCollaboratorAdded:   notifications.ClassShares,
+	EventCollaboratorRemoved: notifications.ClassShares,
+}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Payload formats. FormatJSON POSTs the event itself; the others post a
+// chat message describing it to a Slack or Discord incoming webhook.
+const (
+	FormatJSON    = "json"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrScopeForbidden is returned when a webhook is scoped to a document
+	// the user doesn't own or an organization they don't administer.
+	ErrScopeForbidden = errors.New("not allowed to add webhooks for this document or organization")
+	// ErrNotDocumentOwner is returned when managing a document's webhooks
+	// as anyone but its owner.
+	ErrNotDocumentOwner = errors.New("only the document owner can manage its webhooks")
+)
+
+type WebhookService struct {
+	DB *sql.DB
+}
+
+// Scope chooses the documents a webhook receives events for: a single
+// document, all documents of an organization, or, when both are nil, the
+// documents its user owns.
+type Scope struct {
+	DocumentId     *int `json:"document_id,omitempty" example:"12"`
+	OrganizationId *int `json:"organization_id,omitempty"`
+}
+
+type Webhook struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Format string   `json:"format"`
+	Scope
+	// Secret signs deliveries. It is only returned when the webhook is
+	// created.
+	Secret    string `json:"secret,omitempty"`
+	Active    bool   `json:"active"`
+	CreatedAt string `json:"created_at"`
+}
+
+type Delivery struct {
+	ID             int     `json:"id"`
+	Event          string  `json:"event"`
+	Payload        string  `json:"payload"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	ResponseStatus *int    `json:"response_status,omitempty"`
+	LastError      *string `json:"last_error,omitempty"`
+	NextAttemptAt  string  `json:"next_attempt_at"`
+	CreatedAt      string  `json:"created_at"`
+	DeliveredAt    *string `json:"delivered_at,omitempty"`
+}
+
+func ValidEvent(event string) bool {
+	return validEvents[event]
+}
+
+func ValidFormat(format string) bool {
+	return format == FormatJSON || format == FormatSlack || format == FormatDiscord
+}
+
+// CreateWebhook registers a URL to receive the given events for the
+// documents in scope, formatted as format, and generates its signing
+// secret. Only the owner of a document, or an owner or admin of an
+// organization, can scope a webhook to it.
+func (s *WebhookService) CreateWebhook(ctx context.Context, userId int, url string, events []string, format string, scope Scope) (*Webhook, error) {
+	if err := s.checkScope(ctx, userId, scope); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+
+	webhook := Webhook{URL: url, Events: events, Format: format, Scope: scope, Secret: hex.EncodeToString(secret), Active: true}
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO webhooks (user_id, url, secret, events, format, document_id, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, userId, url, webhook.Secret, strings.Join(events, ","), format, scope.DocumentId, scope.OrganizationId).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %v", err)
+	}
+	return &webhook, nil
+}
+
+func (s *WebhookService) checkScope(ctx context.Context, userId int, scope Scope) error {
+	var allowed bool
+	var err error
+	switch {
+	case scope.DocumentId != nil:
+		err = s.DB.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)
+		`, *scope.DocumentId, userId).Scan(&allowed)
+	case scope.OrganizationId != nil:
+		err = s.DB.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM organization_members
+				WHERE organization_id = $1 AND user_id = $2 AND role IN ('owner', 'admin')
+			)
+		`, *scope.OrganizationId, userId).Scan(&allowed)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check webhook scope: %v", err)
+	}
+	if !allowed {
+		return ErrScopeForbidden
+	}
+	return nil
+}
+
+func (s *WebhookService) GetUserWebhooks(ctx context.Context, userId int) ([]Webhook, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, url, events, format, document_id, organization_id, active, created_at
+		FROM webhooks WHERE user_id = $1
+		ORDER BY id
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhooks(rows)
+}
+
+// GetDocumentWebhooks returns the webhooks following a document, whoever
+// added them. Only the document's owner can list them.
+func (s *WebhookService) GetDocumentWebhooks(ctx context.Context, userId, documentId int) ([]Webhook, error) {
+	if err := s.checkOwner(ctx, userId, documentId); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, url, events, format, document_id, organization_id, active, created_at
+		FROM webhooks WHERE document_id = $1
+		ORDER BY id
+	`, documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhooks(rows)
+}
+
+func scanWebhooks(rows *sql.Rows) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		var events string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Format, &webhook.DocumentId, &webhook.OrganizationId, &webhook.Active, &webhook.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %v", err)
+		}
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// checkOwner returns ErrNotDocumentOwner unless the user owns the document.
+func (s *WebhookService) checkOwner(ctx context.Context, userId, documentId int) error {
+	if err := s.checkScope(ctx, userId, Scope{DocumentId: &documentId}); err != nil {
+		if errors.Is(err, ErrScopeForbidden) {
+			return ErrNotDocumentOwner
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, userId, webhookId int) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND user_id = $2", webhookId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteDocumentWebhook deletes a webhook following a document. Only the
+// document's owner can delete it.
+func (s *WebhookService) DeleteDocumentWebhook(ctx context.Context, userId, documentId, webhookId int) error {
+	if err := s.checkOwner(ctx, userId, documentId); err != nil {
+		return err
+	}
+
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND document_id = $2", webhookId, documentId)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// TestDocumentWebhook queues a webhook.test delivery to a webhook following
+// a document, whatever events it subscribes to and even while inactive,
+// and returns the delivery's ID. Only the document's owner can send one.
+func (s *WebhookService) TestDocumentWebhook(ctx context.Context, userId, documentId, webhookId int) (int, error) {
+	if err := s.checkOwner(ctx, userId, documentId); err != nil {
+		return 0, err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     EventTest,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"data": map[string]interface{}{
+			"document_id": documentId,
+			"user_id":     userId,
+			"webhook_id":  webhookId,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode test payload: %v", err)
+	}
+
+	var deliveryId int
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $3, $4::jsonb FROM webhooks WHERE id = $1 AND document_id = $2
+		RETURNING id
+	`, webhookId, documentId, EventTest, string(payload)).Scan(&deliveryId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrWebhookNotFound
+		}
+		return 0, fmt.Errorf("failed to queue test delivery: %v", err)
+	}
+	return deliveryId, nil
+}
+
+// GetDeliveries returns a page of a webhook's deliveries, newest first.
+func (s *WebhookService) GetDeliveries(ctx context.Context, userId, webhookId, limit, offset int) ([]Delivery, error) {
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)
+	`, webhookId, userId).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check webhook: %v", err)
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, event, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, webhookId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// PruneDeliveries deletes finished deliveries older than maxAge and returns
+// how many were deleted.
+func (s *WebhookService) PruneDeliveries(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND created_at < now() - $1 * interval '1 second'
+	`, int(maxAge.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune deliveries: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// Redeliver queues a delivery to be sent again right away, with a fresh
+// set of attempts.
+func (s *WebhookService) Redeliver(ctx context.Context, userId, webhookId, deliveryId int) error {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE webhook_deliveries d
+		SET status = 'pending', attempts = 0, next_attempt_at = now()
+		FROM webhooks w
+		WHERE d.id = $1 AND d.webhook_id = $2 AND w.id = d.webhook_id AND w.user_id = $3
+	`, deliveryId, webhookId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"event":"document.created"}' | openssl dgst -sha256 -hmac secret
+	expected := "758f2ed0e4246adee918edccbcc08156a0553e6fa495fed2f3d7f80f81524c45"
+	if got := Sign("secret", "1700000000", []byte(`{"event":"document.created"}`)); got != expected {
+		t.Errorf("Expected signature %s, got %s", expected, got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		20: time.Hour,
+	}
+	for attempts, expected := range tests {
+		if got := Backoff(attempts); got != expected {
+			t.Errorf("Backoff(%d) = %v, expected %v", attempts, got, expected)
+		}
+	}
+}
+
+func expectClaim(mock sqlmock.Sqlmock, url string, attempts int) {
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE webhook_deliveries d")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "attempts", "url", "secret", "format"}).
+			AddRow(7, EventDocumentCreated, []byte(`{"event":"document.created"}`), attempts, url, "secret", FormatJSON))
+}
+
+func TestDeliverNext_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	var signatureValid bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		expected := "sha256=" + Sign("secret", r.Header.Get("X-Webhook-Timestamp"), body)
+		signatureValid = r.Header.Get("X-Webhook-Signature") == expected
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dispatcher := &Dispatcher{DB: db, Client: server.Client(), wake: make(chan struct{}, 1)}
+	expectClaim(mock, server.URL, 1)
+	mock.ExpectExec(regexp.QuoteMeta("SET status = 'delivered'")).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	claimed, err := dispatcher.deliverNext(context.Background())
+	if err != nil || !claimed {
+		t.Fatalf("Expected a delivery to be claimed, got %v, %v", claimed, err)
+	}
+
+	if !signatureValid {
+		t.Error("Expected a valid signature")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSend_RejectsNonPublicAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dispatcher := &Dispatcher{Client: newClient()}
+	for _, url := range []string{server.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:9/"} {
+		delivery := &claimedDelivery{id: 7, event: "document.created", payload: []byte("{}"), url: url, secret: "secret", format: FormatJSON}
+		if status, err := dispatcher.send(context.Background(), delivery); !errors.Is(err, ErrForbiddenAddress) || status != 0 {
+			t.Errorf("Expected ErrForbiddenAddress for %s, got %d, %v", url, status, err)
+		}
+	}
+}
+
+func TestDeliverNext_RetriesThenFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dispatcher := &Dispatcher{DB: db, Client: server.Client(), MaxAttempts: 2, wake: make(chan struct{}, 1)}
+
+	expectClaim(mock, server.URL, 1)
+	mock.ExpectExec(regexp.QuoteMeta("SET response_status = $2, last_error = $3, next_attempt_at")).
+		WithArgs(7, sqlmock.AnyArg(), "endpoint responded with 500", 30).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expectClaim(mock, server.URL, 2)
+	mock.ExpectExec(regexp.QuoteMeta("SET status = 'failed'")).
+		WithArgs(7, sqlmock.AnyArg(), "endpoint responded with 500").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	dispatcher.deliverNext(context.Background())
+	dispatcher.deliverNext(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateWebhook_InvalidEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	handler := &WebhookHandler{WebhookService: &WebhookService{DB: db}}
+	r := gin.New()
+	r.POST("/webhooks", handler.CreateWebhook)
+
+	payload := []byte(`{"url": "https://example.com/hook", "events": ["document.exploded"]}`)
+	req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDeliverNext_SlackMessage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	var message map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&message)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dispatcher := &Dispatcher{DB: db, Client: server.Client(), wake: make(chan struct{}, 1)}
+	payload := []byte(`{"event":"collaborator.added","data":{"document_id":12,"user_id":3,"permission":"edit"}}`)
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE webhook_deliveries d")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "attempts", "url", "secret", "format"}).
+			AddRow(7, EventCollaboratorAdded, payload, 1, server.URL, "secret", FormatSlack))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT (SELECT title FROM documents WHERE id = $1), (SELECT email FROM users WHERE id = $2)")).
+		WithArgs(12, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "email"}).AddRow("Q3 <plan>", "alice@example.com"))
+	mock.ExpectExec(regexp.QuoteMeta("SET status = 'delivered'")).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if claimed, err := dispatcher.deliverNext(context.Background()); err != nil || !claimed {
+		t.Fatalf("Expected a delivery to be claimed, got %v, %v", claimed, err)
+	}
+
+	expected := "*alice@example.com* can now edit *Q3 &lt;plan&gt;*"
+	if message["text"] != expected {
+		t.Errorf("Expected text %q, got %q", expected, message["text"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatMessage_Discord(t *testing.T) {
+	published := false
+	data := eventData{DocumentId: 12, Title: "draft_v2 *final*", Published: &published}
+
+	expected := `**bob@example.com** unpublished **draft\_v2 \*final\***`
+	if got := chatMessage(EventDocumentPublished, data, "bob@example.com", discordBold); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	data.Scheduled = true
+	expected = `**draft\_v2 \*final\*** was unpublished as scheduled by **bob@example.com**`
+	if got := chatMessage(EventDocumentPublished, data, "bob@example.com", discordBold); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func setupDocumentRouter(handler *WebhookHandler, userId int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	document := r.Group("/documents/:id")
+	document.Use(func(c *gin.Context) {
+		c.Set("userId", userId)
+		c.Set("documentId", 12)
+	})
+	document.GET("/webhooks", handler.GetDocumentWebhooks)
+	document.POST("/webhooks/:webhook_id/test", handler.TestDocumentWebhook)
+	return r
+}
+
+func TestDocumentWebhooks_OwnerOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	r := setupDocumentRouter(&WebhookHandler{WebhookService: &WebhookService{DB: db}}, 3)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)")).
+		WithArgs(12, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	req, _ := http.NewRequest("GET", "/documents/12/webhooks", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a collaborator, got %d", http.StatusForbidden, w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTestDocumentWebhook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	r := setupDocumentRouter(&WebhookHandler{WebhookService: &WebhookService{DB: db}}, 1)
+	for _, test := range []struct {
+		webhookId int
+		rows      *sqlmock.Rows
+		expected  int
+	}{
+		{5, sqlmock.NewRows([]string{"id"}).AddRow(42), http.StatusAccepted},
+		// a webhook following another document
+		{6, sqlmock.NewRows([]string{"id"}), http.StatusNotFound},
+	} {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM documents WHERE id = $1 AND owner_id = $2)")).
+			WithArgs(12, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO webhook_deliveries (webhook_id, event, payload)")).
+			WithArgs(test.webhookId, 12, EventTest, sqlmock.AnyArg()).
+			WillReturnRows(test.rows)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/documents/12/webhooks/%d/test", test.webhookId), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.expected {
+			t.Errorf("Webhook %d: expected status %d, got %d", test.webhookId, test.expected, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
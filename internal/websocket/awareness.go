@@ -0,0 +1,108 @@
+package websocket
+
+import "log/slog"
+
+// Yjs message types, the first byte of every binary frame sent by
+// y-websocket clients. Only awareness is relayed; document sync is not
+// supported over binary frames.
+const (
+	yjsMessageSync           = 0
+	yjsMessageAwareness      = 1
+	yjsMessageQueryAwareness = 3
+)
+
+// ErrCodeUnsupportedBinary is sent for binary frames that aren't Yjs
+// awareness messages.
+const ErrCodeUnsupportedBinary = "unsupported_binary_message"
+
+// awarenessBufferSize is how many awareness frames are queued per client.
+// Awareness updates supersede each other, so the oldest are dropped first.
+const awarenessBufferSize = 16
+
+// handleBinaryMessage relays Yjs awareness updates (cursors, selections,
+// user info) to the other clients of the document as opaque frames, so
+// Yjs-based frontends work without translating to cursor messages.
+func (ws *WebSocketHandler) handleBinaryMessage(c *Client, data []byte) *MessageError {
+	if len(data) == 0 {
+		return newMessageError(ErrCodeInvalidMessage, "Empty binary frame")
+	}
+
+	switch data[0] {
+	case yjsMessageAwareness:
+		if c.isSpectator() {
+			return newMessageError(ErrCodePermissionDenied, "Spectators can't send awareness updates")
+		}
+
+		c.stateMutex.Lock()
+		c.awareness = data
+		c.stateMutex.Unlock()
+
+		ws.Hub.BroadcastAwareness(c.DocumentId, data, c.ID)
+		if ws.Hub.Relay != nil {
+			if err := ws.Hub.Relay.PublishAwareness(c.DocumentId, data); err != nil {
+				slog.ErrorContext(c.logContext(), "Failed to publish awareness", "error", err)
+			}
+		}
+		return nil
+
+	case yjsMessageQueryAwareness:
+		for _, other := range ws.Hub.GetDocumentClients(c.DocumentId) {
+			if other.ID == c.ID {
+				continue
+			}
+			other.stateMutex.RLock()
+			state := other.awareness
+			other.stateMutex.RUnlock()
+			if state != nil {
+				c.queueBinary(state)
+			}
+		}
+		return nil
+
+	default:
+		return newMessageError(ErrCodeUnsupportedBinary, "Binary message type %d is not supported; only Yjs awareness is relayed", data[0])
+	}
+}
+
+// BroadcastAwareness sends a Yjs awareness frame to every client of the
+// document except the sender.
+func (h *Hub) BroadcastAwareness(documentId int, data []byte, exceptClientId string) {
+	h.inRoom(documentId, func() {
+		for _, client := range h.GetDocumentClients(documentId) {
+			if client.ID != exceptClientId {
+				client.queueBinary(data)
+			}
+		}
+	})
+}
+
+// queueBinary queues a binary frame, dropping the oldest queued frame if
+// the client has fallen behind.
+func (c *Client) queueBinary(data []byte) {
+	if c.binary == nil {
+		return
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return
+	}
+
+	for {
+		select {
+		case c.binary <- data:
+			return
+		default:
+		}
+
+		select {
+		case <-c.binary:
+			if c.Hub != nil {
+				c.Hub.slowClients.droppedMessages.Add(1)
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowClientPolicy decides what happens when a client can't keep up with
+// the messages sent to it.
+type SlowClientPolicy string
+
+const (
+	// SlowClientClose closes the connection as soon as the send buffer is
+	// full.
+	SlowClientClose SlowClientPolicy = "close"
+	// SlowClientDropOldest queues ephemeral messages (cursors, presence,
+	// pings) separately and drops the oldest of them when that queue is
+	// full. Only a full buffer of document messages closes the connection.
+	SlowClientDropOldest SlowClientPolicy = "drop_oldest"
+)
+
+const (
+	defaultSendBufferSize = 256
+	ephemeralBufferSize   = 32
+	slowClientCloseReason = "client too slow"
+)
+
+// ephemeralTypes are messages that are superseded by the next message of the
+// same kind, so losing one under backpressure is harmless.
+var ephemeralTypes = map[string]bool{
+	"cursor":          true,
+	"presence_update": true,
+	"viewed":          true,
+	"ping":            true,
+	"pong":            true,
+	"latency":         true,
+}
+
+func isEphemeral(messageType string) bool {
+	return ephemeralTypes[messageType]
+}
+
+// SlowClientStats counts how often clients fell behind.
+type SlowClientStats struct {
+	Policy          SlowClientPolicy `json:"policy" example:"close"`
+	Disconnects     int64            `json:"disconnects" example:"3"`
+	DroppedMessages int64            `json:"dropped_messages" example:"120"`
+}
+
+type slowClientCounters struct {
+	disconnects     atomic.Int64
+	droppedMessages atomic.Int64
+}
+
+func (h *Hub) SlowClientStats() SlowClientStats {
+	return SlowClientStats{
+		Policy:          h.slowClientPolicy(),
+		Disconnects:     h.slowClients.disconnects.Load(),
+		DroppedMessages: h.slowClients.droppedMessages.Load(),
+	}
+}
+
+func (h *Hub) slowClientPolicy() SlowClientPolicy {
+	if h.SlowClientPolicy == SlowClientDropOldest {
+		return SlowClientDropOldest
+	}
+	return SlowClientClose
+}
+
+// deliver queues data for the client according to the hub's slow-client
+// policy. It returns false if the client can't keep up and must be closed.
+func (c *Client) deliver(data []byte, ephemeral bool) bool {
+	if ephemeral && c.ephemeral != nil && c.Hub != nil && c.Hub.slowClientPolicy() == SlowClientDropOldest {
+		if c.queueEphemeral(data) {
+			c.Hub.slowClients.droppedMessages.Add(1)
+		}
+		return true
+	}
+	return c.trySend(data)
+}
+
+// queueEphemeral adds data to the ephemeral queue, discarding the oldest
+// queued message if it is full. It reports whether a message was discarded.
+func (c *Client) queueEphemeral(data []byte) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+
+	dropped := false
+	for {
+		select {
+		case c.ephemeral <- data:
+			return dropped
+		default:
+		}
+
+		select {
+		case <-c.ephemeral:
+			dropped = true
+		default:
+		}
+	}
+}
+
+// closeSlow closes a client that fell behind, telling it why so it can
+// reconnect and resync instead of treating the close as an error.
+func (c *Client) closeSlow() {
+	if !c.closeWith(websocket.CloseTryAgainLater, slowClientCloseReason) {
+		return
+	}
+
+	if c.Hub != nil {
+		c.Hub.slowClients.disconnects.Add(1)
+	}
+	slog.WarnContext(c.logContext(), "Closing slow client")
+}
+
+// closeMessage is the close frame sent once the send channel is closed.
+func (c *Client) closeMessage() []byte {
+	if c.closeCode == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
@@ -0,0 +1,131 @@
+package websocket
+
+// relayAccessRevoked and relaySessionRevoked relay RevokeAccess and
+// SessionRevoked to the other instances. They are only applied there,
+// never sent to clients.
+const (
+	relayAccessRevoked  = "relay_access_revoked"
+	relaySessionRevoked = "relay_session_revoked"
+)
+
+// CollaboratorAdded tells everyone editing the document that a user has been
+// given access.
+func (h *Hub) CollaboratorAdded(documentId, userId int, permission string) {
+	h.BroadcastMessage(collaboratorMessage("collaborator_added", documentId, userId, permission))
+}
+
+// PermissionChanged updates the permission of the user's open connections,
+// here and on the other instances, so a downgrade to view takes effect
+// without reconnecting, and tells everyone editing the document about the
+// change.
+func (h *Hub) PermissionChanged(documentId, userId int, permission string) {
+	message := collaboratorMessage("permission_changed", documentId, userId, permission)
+	h.permissionChanged(message)
+	h.publish(message)
+}
+
+func (h *Hub) permissionChanged(message *Message) {
+	payload, _ := message.Payload.(map[string]interface{})
+	permission, _ := payload["permission"].(string)
+	h.inRoom(message.DocumentId, func() {
+		for _, client := range h.GetDocumentClients(message.DocumentId) {
+			if client.UserId == message.UserId && permission != "" {
+				client.setPermission(permission)
+			}
+		}
+		h.broadcastToDocument(message)
+	})
+}
+
+// CollaboratorRemoved tells everyone editing the document that a user lost
+// access, then disconnects that user's connections, here and on the other
+// instances.
+func (h *Hub) CollaboratorRemoved(documentId, userId int) {
+	message := collaboratorMessage("collaborator_removed", documentId, userId, "")
+	h.collaboratorRemoved(message)
+	h.publish(message)
+}
+
+func (h *Hub) collaboratorRemoved(message *Message) {
+	h.inRoom(message.DocumentId, func() {
+		h.broadcastToDocument(message)
+		h.revokeAccess(message.DocumentId, message.UserId)
+	})
+}
+
+// RevokeAccess disconnects every connection the user has open to the
+// document, here and on the other instances, telling each client why
+// before closing it.
+func (h *Hub) RevokeAccess(documentId, userId int) {
+	h.inRoom(documentId, func() {
+		h.revokeAccess(documentId, userId)
+	})
+	h.publish(&Message{Type: relayAccessRevoked, DocumentId: documentId, UserId: userId})
+}
+
+func (h *Hub) revokeAccess(documentId, userId int) {
+	for _, client := range h.GetDocumentClients(documentId) {
+		if client.UserId != userId {
+			continue
+		}
+
+		h.sendToClient(client, &Message{
+			Type:       "access_revoked",
+			DocumentId: documentId,
+			UserId:     userId,
+			Payload: map[string]interface{}{
+				"reason": "Your access to this document has been removed",
+			},
+		})
+		h.unregisterClient(client)
+	}
+}
+
+// SessionRevoked disconnects every connection opened with a token of the
+// session, here and on the other instances, telling each client why before
+// closing it.
+func (h *Hub) SessionRevoked(sessionId int) {
+	h.sessionRevoked(sessionId)
+	h.publish(&Message{
+		Type:    relaySessionRevoked,
+		Payload: map[string]interface{}{"session_id": sessionId},
+	})
+}
+
+func (h *Hub) sessionRevoked(sessionId int) {
+	for _, documentId := range h.documentIds() {
+		h.inRoom(documentId, func() {
+			for _, client := range h.GetDocumentClients(documentId) {
+				if client.currentSessionId() != sessionId {
+					continue
+				}
+
+				h.sendToClient(client, &Message{
+					Type:       "access_revoked",
+					DocumentId: documentId,
+					UserId:     client.UserId,
+					Payload: map[string]interface{}{
+						"reason": "Your session has been logged out",
+					},
+				})
+				h.unregisterClient(client)
+			}
+		})
+	}
+}
+
+func collaboratorMessage(messageType string, documentId, userId int, permission string) *Message {
+	payload := map[string]interface{}{
+		"user_id": userId,
+	}
+	if permission != "" {
+		payload["permission"] = permission
+	}
+
+	return &Message{
+		Type:       messageType,
+		DocumentId: documentId,
+		UserId:     userId,
+		Payload:    payload,
+	}
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxReconnectDelay spreads out the reconnects of drained clients so
+	// they don't all hit the remaining instances at once.
+	maxReconnectDelay = 5 * time.Second
+
+	drainCloseReason = "server restarting"
+)
+
+// StartDrain marks the instance as shutting down. New WebSocket connections
+// are refused with 503 from then on, so clients retry against another
+// instance.
+func (h *Hub) StartDrain() {
+	h.draining.Store(true)
+}
+
+// Draining reports whether StartDrain has been called.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// documentIds returns the documents with connected clients.
+func (h *Hub) documentIds() []int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	documentIds := make([]int, 0, len(h.clients))
+	for documentId := range h.clients {
+		documentIds = append(documentIds, documentId)
+	}
+	return documentIds
+}
+
+// Drain moves every connected client to another instance. For each
+// document the content held in memory is flushed first, so the instance
+// clients reconnect to loads the latest version, then its clients are sent
+// a "reconnect" message with a randomized delay and closed with code 1012
+// (service restart). Edits should already be rejected, e.g. by maintenance
+// mode, so nothing changes after the flush. The relay keeps delivering
+// messages from other instances until each client has left.
+//
+// Drain returns once every client has disconnected or ctx is done, and
+// reports how many clients were asked to reconnect.
+func (ws *WebSocketHandler) Drain(ctx context.Context) int {
+	ws.Hub.StartDrain()
+
+	drained := 0
+	for _, documentId := range ws.Hub.documentIds() {
+		ws.Store.Flush(documentId)
+
+		for _, client := range ws.Hub.GetDocumentClients(documentId) {
+			delay := rand.N(maxReconnectDelay)
+			client.sendJSON(&Message{
+				Type:       "reconnect",
+				DocumentId: documentId,
+				UserId:     client.UserId,
+				Payload: map[string]interface{}{
+					"reason":   drainCloseReason,
+					"delay_ms": delay.Milliseconds(),
+				},
+				Timestamp: time.Now().Unix(),
+			})
+			client.closeWith(websocket.CloseServiceRestart, drainCloseReason)
+			drained++
+		}
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		clients, _ := ws.Hub.GetClientCount()
+		if clients == 0 {
+			return drained
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Warn("Clients still connected after draining", "clients", clients)
+			return drained
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"live-collab-api/internal/documents"
+)
+
+// editorLimit returns how many users can edit the document at once: the
+// lower of the document's own cap and the handler's MaxEditors, or zero
+// when neither is set.
+func (ws *WebSocketHandler) editorLimit(ctx context.Context, documentId int) (int, error) {
+	docService := &documents.DocumentService{DB: ws.DB}
+	limit, err := docService.GetEditorLimit(ctx, documentId)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit.MaxEditors == nil || (ws.MaxEditors > 0 && ws.MaxEditors < *limit.MaxEditors) {
+		return ws.MaxEditors, nil
+	}
+	return *limit.MaxEditors, nil
+}
+
+// capEditors admits the client as a viewer instead of an editor if its
+// document already has as many users editing as it allows. Connections of
+// a user who is already editing, and owners, are never capped. It reports
+// whether the client was capped, and must be called with h.mutex held,
+// before the client is added.
+func (h *Hub) capEditors(client *Client) bool {
+	if client.maxEditors <= 0 || client.CurrentPermission() != "edit" {
+		return false
+	}
+
+	editors := make(map[int]bool)
+	for _, other := range h.clients[client.DocumentId] {
+		if other.canEdit() {
+			editors[other.UserId] = true
+		}
+	}
+	if editors[client.UserId] || len(editors) < client.maxEditors {
+		return false
+	}
+
+	client.setPermission("view")
+	return true
+}
+
+// editorLimitMessage tells a capped client why it joined as a viewer.
+func editorLimitMessage(client *Client) *Message {
+	return &Message{
+		Type:       "editor_limit_reached",
+		DocumentId: client.DocumentId,
+		UserId:     client.UserId,
+		Payload: map[string]interface{}{
+			"max_editors": client.maxEditors,
+			"permission":  "view",
+			"message": fmt.Sprintf("This document already has %d people editing, so you joined as a viewer. Reconnect later to edit.",
+				client.maxEditors),
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"context"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/telemetry"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Edits to end-to-end encrypted documents carry ciphertext the server
+// can't read, encrypted with the document key of key_version. The server
+// only numbers, persists, and relays them; clients apply them, and upload
+// encrypted snapshots of the content over HTTP.
+var encryptedEditSchema = messageSchema{
+	fields: []fieldRule{
+		{name: "ciphertext", kind: kindString, required: true, maxLen: maxMessageSize},
+		{name: "key_version", kind: kindInteger, required: true, min: 1, max: 1 << 31},
+	},
+}
+
+func (ws *WebSocketHandler) isEncrypted(ctx context.Context, documentId int) (bool, error) {
+	docService := &documents.DocumentService{DB: ws.DB}
+	return docService.IsEncrypted(ctx, documentId)
+}
+
+// handleEncryptedEdit assigns the next version to an edit of an encrypted
+// document, persists it, and broadcasts it unchanged.
+func (ws *WebSocketHandler) handleEncryptedEdit(ctx context.Context, message *Message) *MessageError {
+	ctx, span := telemetry.Tracer().Start(ctx, "document apply")
+	err := ws.Store.Apply(message, nil, func(message *Message) error {
+		return ws.persistEvent(ctx, message)
+	})
+	span.SetAttributes(attribute.Int("document.version", message.Version))
+	telemetry.End(span, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to persist encrypted edit", "error", err)
+		return newMessageError(ErrCodePersistenceFailed, "Edit could not be saved, please retry")
+	}
+
+	ws.Hub.BroadcastMessage(message)
+	slog.DebugContext(ctx, "Relayed encrypted edit", "version", message.Version)
+	return nil
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+)
+
+// Error codes sent to clients in "error" frames.
+const (
+	ErrCodeInvalidMessage    = "invalid_message"
+	ErrCodeUnknownType       = "unknown_type"
+	ErrCodePermissionDenied  = "permission_denied"
+	ErrCodeInvalidPayload    = "invalid_payload"
+	ErrCodePersistenceFailed = "persistence_failed"
+	ErrCodeInvalidToken      = "invalid_token"
+	ErrCodeInternal          = "internal_error"
+	// ErrCodeUnsafeContent is sent for edits that would add markup able to
+	// run script, such as a script element or an event handler, to an HTML
+	// document.
+	ErrCodeUnsafeContent = "unsafe_content"
+)
+
+// MessageError describes why a client message could not be processed. It is
+// sent back to the originating client as the payload of an "error" frame,
+// with Ref set to the message_id of the offending message, if it had one.
+type MessageError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Ref     string `json:"ref,omitempty"`
+	// Fields lists the invalid fields of a message that failed validation.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newMessageError(code, format string, args ...interface{}) *MessageError {
+	return &MessageError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// sendError sends a structured error frame referring to the client message
+// identified by ref.
+func (c *Client) sendError(err *MessageError, ref string) {
+	payload := *err
+	payload.Ref = ref
+
+	c.sendJSON(&Message{
+		Type:       "error",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		MessageId:  ref,
+		Payload:    payload,
+		Timestamp:  time.Now().Unix(),
+	})
+}
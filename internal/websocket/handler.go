@@ -1,113 +1,261 @@
 package websocket
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"live-collab-api/internal/auth"
-	"log"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/logging"
+	"live-collab-api/internal/maintenance"
+	"live-collab-api/internal/telemetry"
+	"live-collab-api/internal/usage"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var allowedOrigins = strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",")
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			return true
-		}
-
-		for _, allowedOrigin := range allowedOrigins {
-			if allowedOrigin == strings.TrimSpace(allowedOrigin) {
-				return true
-			}
-		}
-		return false
-	},
-}
-
 type WebSocketHandler struct {
 	Hub         *Hub
 	DB          *sql.DB
 	AuthService *auth.AuthService
+	// Store holds the in-memory state of documents with connected clients
+	// and applies every edit to them. It must be set.
+	Store *DocumentStore
+	// SendBufferSize is the number of outgoing messages queued per client
+	// before the hub's slow-client policy applies. Defaults to 256.
+	SendBufferSize int
+	// Origins lists the browser origins allowed to connect. When nil only
+	// clients that aren't browsers can connect.
+	Origins *OriginPolicy
+	// Limiter caps open connections in total and per IP. When nil there is
+	// no limit.
+	Limiter *ConnectionLimiter
+	// Meter, when set, counts persisted events, connection time, and
+	// messages received.
+	Meter *usage.Meter
+	// Maintenance rejects edits while the API is in maintenance.
+	Maintenance *maintenance.Mode
+	// Pool, when set, persists the edits of an offline sync in one batch
+	// instead of one insert per edit.
+	Pool *pgxpool.Pool
+	// MaxEditors caps how many users can edit a document at once on this
+	// instance. Documents can set a lower cap. Zero means no cap.
+	MaxEditors int
 }
 
 func (ws *WebSocketHandler) HandleWebSocket(c *gin.Context) {
-	documentIdStr := c.Param("document_id")
+	// A draining instance sends clients to the others
+	if ws.Hub.Draining() {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is restarting"})
+		return
+	}
+
+	// Limits are enforced before any other work so a flood of connection
+	// attempts can't tie up the database either
+	ip := c.ClientIP()
+	if ws.Limiter != nil {
+		if !ws.Limiter.Acquire(ip) {
+			c.Header("Retry-After", "30")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many connections"})
+			return
+		}
+	}
+	upgraded := false
+	defer func() {
+		// Once upgraded, the slot is released when the connection closes
+		if ws.Limiter != nil && !upgraded {
+			ws.Limiter.Release(ip)
+		}
+	}()
+
+	documentIdStr := c.Param("id")
+	if documentIdStr == "" {
+		documentIdStr = c.Param("document_id")
+	}
 	documentId, err := strconv.Atoi(documentIdStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
 		return
 	}
 
-	userId, err := ws.AuthService.GetUserIDFromGinContext(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+	// Connections without a token may only watch published documents
+	var userId, sessionId int
+	var tokenExpiry time.Time
+	var hasAccess bool
+	var permission string
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, err := auth.TokenFromAuthHeader(header)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		userId, sessionId, tokenExpiry, err = ws.AuthService.GetSessionFromToken(c.Request.Context(), token)
+		if errors.Is(err, auth.ErrSessionUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session could not be checked, please retry"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		hasAccess, permission = ws.hasDocumentAccess(c.Request.Context(), userId, documentId)
 	}
 
-	hasAccess, permission := ws.hasDocumentAccess(userId, documentId)
 	if !hasAccess {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		if !ws.isPublished(c.Request.Context(), documentId) {
+			if userId == 0 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			} else {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			}
+			return
+		}
+		permission = PermissionSpectator
+	}
+
+	// Published documents are never encrypted
+	encrypted := false
+	if permission != PermissionSpectator {
+		if encrypted, err = ws.isEncrypted(c.Request.Context(), documentId); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to check document encryption", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+			return
+		}
+	}
+
+	// Spectators aren't listed in presence, so only users need a name. A
+	// user whose username can't be loaded is shown by ID alone.
+	var username, avatarURL string
+	if permission != PermissionSpectator {
+		if username, avatarURL, err = ws.AuthService.GetIdentity(c.Request.Context(), userId); err != nil {
+			slog.WarnContext(c.Request.Context(), "Failed to get username", "error", err)
+		}
+	}
+
+	// Owners can always edit, so only editors are capped
+	var maxEditors int
+	if permission == "edit" {
+		if maxEditors, err = ws.editorLimit(c.Request.Context(), documentId); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to get editor limit", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+			return
+		}
+	}
+
+	if err := ws.Store.Acquire(documentId); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load document", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
 		return
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := ws.Origins.upgrader().Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("WebSocket Upgrade Error: %v\n", err)
+		slog.WarnContext(c.Request.Context(), "WebSocket upgrade failed", "error", err)
+		ws.Store.Release(documentId)
 		return
 	}
 
+	clientId := uuid.New().String()
 	client := &Client{
-		ID:         uuid.New().String(),
+		ID:         clientId,
 		DocumentId: documentId,
 		UserId:     userId,
+		Username:   username,
+		AvatarURL:  avatarURL,
 		Permission: permission,
+		Encrypted:  encrypted,
+		maxEditors: maxEditors,
 		Conn:       conn,
-		Send:       make(chan []byte, 256),
+		Send:       make(chan []byte, ws.sendBufferSize()),
 		Hub:        ws.Hub,
+		ephemeral:  make(chan []byte, ephemeralBufferSize),
+		binary:     make(chan []byte, awarenessBufferSize),
+
+		TokenExpiry:  tokenExpiry,
+		tokenRefresh: make(chan time.Time, 1),
+		sessionId:    sessionId,
+
+		Info:        clientInfoFromUserAgent(c.Request.UserAgent()),
+		ConnectedAt: time.Now(),
+		IP:          ip,
+		// The request context is canceled once the handler returns
+		ctx: logging.With(context.WithoutCancel(c.Request.Context()),
+			"client_id", clientId, "user_id", userId, "document_id", documentId),
 	}
+	upgraded = true
 
-	ws.Hub.register <- client
+	ws.Hub.Register(client)
 
 	go client.writePump()
 	go client.readPump(ws)
 }
 
+// maxMessageSize bounds inbound frames. Yjs awareness frames carry user
+// info alongside the cursor, so this is larger than any JSON message needs.
+const maxMessageSize = 8 << 10
+
+func (ws *WebSocketHandler) sendBufferSize() int {
+	if ws.SendBufferSize > 0 {
+		return ws.SendBufferSize
+	}
+	return defaultSendBufferSize
+}
+
 func (c *Client) readPump(ws *WebSocketHandler) {
 	defer func() {
-		c.Hub.unregister <- c
+		c.Hub.Unregister(c)
 		c.Conn.Close()
+		ws.Store.Release(c.DocumentId)
+		if ws.Limiter != nil {
+			ws.Limiter.Release(c.IP)
+		}
+		ws.Meter.Add(c.DocumentId, c.UserId, usage.MetricWebSocketSeconds, int64(time.Since(c.ConnectedAt).Seconds()))
 	}()
 
-	c.Conn.SetReadLimit(512)
+	c.Conn.SetReadLimit(maxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.touch()
 		return nil
 	})
 
 	for {
-		_, messageData, err := c.Conn.ReadMessage()
+		messageType, messageData, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				slog.WarnContext(c.logContext(), "WebSocket read failed", "error", err)
 			}
 			break
 		}
+		c.touch()
+		ws.Meter.Count(c.UserId, usage.MetricWebSocketMessages)
+
+		if messageType == websocket.BinaryMessage {
+			if msgErr := ws.handleBinaryMessage(c, messageData); msgErr != nil {
+				c.sendError(msgErr, "")
+			}
+			continue
+		}
 
 		var message Message
 		if err := json.Unmarshal(messageData, &message); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
+			slog.WarnContext(c.logContext(), "Received invalid JSON message", "error", err)
+			c.sendError(newMessageError(ErrCodeInvalidMessage, "Message is not valid JSON: %v", err), "")
 			continue
 		}
 
@@ -115,33 +263,97 @@ func (c *Client) readPump(ws *WebSocketHandler) {
 		message.UserId = c.UserId
 		message.DocumentId = c.DocumentId
 
-		switch message.Type {
-		case "edit":
-			if c.Permission != "edit" && c.Permission != "owner" {
-				log.Printf("User %d attempted to edit document %d with %s permission", c.UserId, c.DocumentId, c.Permission)
-				errorMsg := map[string]string{
-					"type":  "error",
-					"error": "You need edit permission to modify this document",
-				}
-				if data, err := json.Marshal(errorMsg); err == nil {
-					c.Send <- data
-				}
-				continue
-			}
-			ws.handleEditMessage(&message)
-		case "cursor":
-			// Cursor updates are allowed for all users with access
-			ws.handleCursorMessage(&message)
-		default:
-			log.Printf("Unknown message type: %v", message.Type)
+		ctx, span := c.startMessageSpan(&message)
+		msgErr := ws.handleMessage(ctx, c, &message)
+		if msgErr != nil {
+			slog.WarnContext(ctx, "Failed to handle message", "type", message.Type, "code", msgErr.Code, "error", msgErr.Message)
+			c.sendError(msgErr, message.MessageId)
+			telemetry.End(span, msgErr)
+			continue
 		}
+		span.End()
+	}
+}
+
+// startMessageSpan starts the span for an inbound message. Each message is
+// its own trace, linked to the request that opened the connection.
+// Ephemeral messages such as cursors aren't traced.
+func (c *Client) startMessageSpan(message *Message) (context.Context, trace.Span) {
+	ctx := c.logContext()
+	if isEphemeral(message.Type) {
+		return ctx, trace.SpanFromContext(context.Background())
 	}
+
+	return telemetry.Tracer().Start(ctx, "websocket "+message.Type,
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.Int("document.id", c.DocumentId),
+			attribute.Int("user.id", c.UserId),
+			attribute.String("websocket.message_type", message.Type),
+		),
+	)
+}
+
+func (ws *WebSocketHandler) handleMessage(ctx context.Context, c *Client, message *Message) *MessageError {
+	if c.isSpectator() && message.Type != "ping" && message.Type != "pong" {
+		return newMessageError(ErrCodePermissionDenied, "Spectators can't send %s messages", message.Type)
+	}
+
+	if message.Type == "edit" && !c.canEdit() {
+		return newMessageError(ErrCodePermissionDenied, "You need edit permission to modify this document")
+	}
+
+	if message.Type == "edit" && ws.Maintenance.Enabled() {
+		return newMessageError(ErrCodeMaintenance, "The service is in maintenance; edits can't be saved right now")
+	}
+
+	if message.Type == "edit" && ws.Maintenance.ReadOnly() {
+		return newMessageError(ErrCodeReadOnly, "The database is unavailable, so the document is read-only until it recovers")
+	}
+
+	if message.Type == "edit" && c.Encrypted {
+		if err := encryptedEditSchema.validate(message); err != nil {
+			return err
+		}
+		return ws.handleEncryptedEdit(ctx, message)
+	}
+
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+
+	switch message.Type {
+	case "edit":
+		return ws.handleEditMessage(ctx, message)
+	case "cursor":
+		// Cursor updates are allowed for all users with access
+		ws.handleCursorMessage(message)
+	case "ping":
+		c.handlePing(message)
+	case "pong":
+		c.handlePong(message)
+	case "viewed":
+		return ws.handleViewedMessage(ctx, c, message)
+	case "hello":
+		return ws.handleHello(c, message)
+	case "token_refresh":
+		return c.handleTokenRefresh(ctx, ws.AuthService, message)
+	default:
+		return newMessageError(ErrCodeUnknownType, "Unknown message type: %q", message.Type)
+	}
+	return nil
 }
 
 func (c *Client) writePump() {
 	ticker := time.NewTicker(time.Second * 54)
+	latencyTicker := time.NewTicker(latencyPingInterval)
+	expiry := newTokenTimers(c.TokenExpiry)
 	defer func() {
 		ticker.Stop()
+		latencyTicker.Stop()
+		expiry.stop()
 		c.Conn.Close()
 	}()
 
@@ -150,7 +362,7 @@ func (c *Client) writePump() {
 		case message, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
 				return
 			}
 
@@ -170,132 +382,181 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case message := <-c.ephemeral:
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case frame := <-c.binary:
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.Conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				return
+			}
+
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-latencyTicker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.Conn.WriteJSON(latencyPingMessage(c)); err != nil {
+				return
+			}
+
+		case <-expiry.warnC():
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.Conn.WriteJSON(reauthRequiredMessage(c, expiry.expiresAt)); err != nil {
+				return
+			}
+
+		case <-expiry.expireC():
+			slog.InfoContext(c.logContext(), "Closing connection: token expired")
+			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"))
+			return
+
+		case expiresAt := <-c.tokenRefresh:
+			expiry.reset(expiresAt)
 		}
 	}
 }
 
-func (ws *WebSocketHandler) handleEditMessage(message *Message) {
+func (ws *WebSocketHandler) handleEditMessage(ctx context.Context, message *Message) *MessageError {
 	payloadBytes, err := json.Marshal(message.Payload)
 	if err != nil {
-		log.Printf("Error marshaling payload: %v", err)
-		return
+		return newMessageError(ErrCodeInvalidPayload, "Edit payload could not be read")
 	}
 
 	var editEvent EditEvent
 	if err := json.Unmarshal(payloadBytes, &editEvent); err != nil {
-		log.Printf("Error unmarshaling payload: %v", err)
-		return
+		return newMessageError(ErrCodeInvalidPayload, "Edit payload is malformed: %v", err)
 	}
 
-	currentVersion, err := ws.getCurrentDocumentVersion(message.DocumentId)
-	if err != nil {
-		log.Printf("Error getting current document version: %v", err)
-		return
-	}
-
-	message.Version = currentVersion + 1
-
-	if err := ws.persistEvent(message); err != nil {
-		log.Printf("Error persisting event: %v", err)
-		return
+	ctx, span := telemetry.Tracer().Start(ctx, "document apply")
+	err = ws.Store.Apply(message, &editEvent, func(message *Message) error {
+		return ws.persistEvent(ctx, message)
+	})
+	span.SetAttributes(attribute.Int("document.version", message.Version))
+	telemetry.End(span, err)
+	if errors.Is(err, errUnsafeContent) {
+		return newMessageError(ErrCodeUnsafeContent, "Edit adds markup that can run script, such as a script element, an event handler, or a javascript: URL")
 	}
-
-	if err := ws.applyEditToDocument(message.DocumentId, &editEvent); err != nil {
-		log.Printf("Error applying edit to document: %v", err)
-		return
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to apply edit", "error", err)
+		return newMessageError(ErrCodePersistenceFailed, "Edit could not be saved, please retry")
 	}
 
 	ws.Hub.BroadcastMessage(message)
-
-	log.Printf("Processed edit event for document %d, version %d by user %d", message.DocumentId, message.Version, message.UserId)
+	slog.DebugContext(ctx, "Processed edit", "version", message.Version)
+	return nil
 }
 
 func (ws *WebSocketHandler) handleCursorMessage(message *Message) {
 	ws.Hub.BroadcastMessage(message)
 }
 
-func (ws *WebSocketHandler) hasDocumentAccess(userId, documentId int) (bool, string) {
-	var ownerId int
-	err := ws.DB.QueryRow("SELECT owner_id FROM documents WHERE id = $1", documentId).Scan(&ownerId)
+func (ws *WebSocketHandler) hasDocumentAccess(ctx context.Context, userId, documentId int) (bool, string) {
+	docService := &documents.DocumentService{DB: ws.DB}
+	permission, err := docService.GetPermission(ctx, userId, documentId)
 	if err != nil {
-		return false, ""
-	}
-
-	if ownerId == userId {
-		return true, "owner"
-	}
-
-	var permission string
-	err = ws.DB.QueryRow(`
-		SELECT permission FROM document_collaborators 
-		WHERE document_id = $1 AND user_id = $2
-	`, documentId, userId).Scan(&permission)
-
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false, ""
+		if !errors.Is(err, documents.ErrDocumentNotFound) {
+			slog.ErrorContext(ctx, "Failed to check document access", "document_id", documentId, "user_id", userId, "error", err)
 		}
-		log.Printf("Error checking collaborator access: %v", err)
 		return false, ""
 	}
 
-	return true, permission
+	return permission != "", permission
 }
 
-func (ws *WebSocketHandler) getCurrentDocumentVersion(documentID int) (int, error) {
-	var version int
-	err := ws.DB.QueryRow(`
-		SELECT COALESCE(MAX(CAST(payload->>'version' AS INTEGER)), 0) 
-		FROM events 
-		WHERE document_id = $1 AND event_type = 'edit'
-	`, documentID).Scan(&version)
-
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return 0, err
-	}
-
-	return version, nil
+func (ws *WebSocketHandler) getCurrentDocumentVersion(ctx context.Context, documentID int) (int, error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "SELECT", "events")
+	version, err := currentDocumentVersion(ctx, ws.DB, documentID)
+	telemetry.End(span, err)
+	return version, err
 }
 
-func (ws *WebSocketHandler) persistEvent(message *Message) error {
-	payloadJSON, err := json.Marshal(map[string]interface{}{
+// insertEventQuery stores an edit, claiming its version for the document
+// in the same statement, so it fails with editVersionTaken if another
+// instance already stored that version.
+const insertEventQuery = `
+		INSERT INTO events (document_id, user_id, event_type, payload, version, created_at)
+		VALUES ($1, $2, $3, $4, claim_edit_version($1, $5), NOW())
+	`
+
+func eventPayload(message *Message) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
 		"type":      message.Type,
 		"version":   message.Version,
 		"timestamp": message.Timestamp,
 		"payload":   message.Payload,
 	})
+}
+
+func (ws *WebSocketHandler) persistEvent(ctx context.Context, message *Message) error {
+	payloadJSON, err := eventPayload(message)
 	if err != nil {
 		return err
 	}
 
-	_, err = ws.DB.Exec(`
-		INSERT INTO events (document_id, user_id, event_type, payload, created_at) 
-		VALUES ($1, $2, $3, $4, NOW())
-	`, message.DocumentId, message.UserId, message.Type, payloadJSON)
+	ctx, span := telemetry.StartDBSpan(ctx, "INSERT", "events")
+	_, err = ws.DB.ExecContext(ctx, insertEventQuery, message.DocumentId, message.UserId, message.Type, payloadJSON, message.Version)
+	telemetry.End(span, err)
+	if err != nil {
+		return err
+	}
 
-	return err
+	ws.Meter.Add(message.DocumentId, message.UserId, usage.MetricEvents, 1)
+	return nil
 }
 
-func (ws *WebSocketHandler) applyEditToDocument(documentId int, edit *EditEvent) error {
-	var content string
-	err := ws.DB.QueryRow("SELECT COALESCE(content, '') FROM documents WHERE id = $1", documentId).Scan(&content)
-	if err != nil {
-		return fmt.Errorf("failed to get document content: %v", err)
+// persistEvents stores the events of several messages and returns how many
+// were stored. With a Pool they are sent as one batch, which Postgres runs
+// as a single transaction, so either all are stored or none are. Without
+// one they are inserted one by one up to the first failure.
+func (ws *WebSocketHandler) persistEvents(ctx context.Context, messages []*Message) (int, error) {
+	if ws.Pool == nil {
+		for i, message := range messages {
+			if err := ws.persistEvent(ctx, message); err != nil {
+				return i, err
+			}
+		}
+		return len(messages), nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, message := range messages {
+		payloadJSON, err := eventPayload(message)
+		if err != nil {
+			return 0, err
+		}
+		batch.Queue(insertEventQuery, message.DocumentId, message.UserId, message.Type, payloadJSON, message.Version)
 	}
 
-	newContent := ws.applyEdit(content, edit)
+	ctx, span := telemetry.StartDBSpan(ctx, "INSERT", "events")
+	span.SetAttributes(attribute.Int("db.batch.size", len(messages)))
+	err := ws.Pool.SendBatch(ctx, batch).Close()
+	telemetry.End(span, err)
+	if err != nil {
+		return 0, err
+	}
 
-	_, err = ws.DB.Exec("UPDATE documents SET content = $1, updated_at = NOW() WHERE id = $2", newContent, documentId)
-	return err
+	for _, message := range messages {
+		ws.Meter.Add(message.DocumentId, message.UserId, usage.MetricEvents, 1)
+	}
+	return len(messages), nil
 }
 
-func (ws *WebSocketHandler) applyEdit(content string, edit *EditEvent) string {
+// applyEdit applies an insert or delete to content. Positions past the end
+// of the content are clamped to it, and a delete never removes more than
+// what follows its position.
+func applyEdit(content string, edit *EditEvent) string {
 	runes := []rune(content)
+	if edit.Position < 0 {
+		edit.Position = 0
+	}
 
 	switch edit.Operation {
 	case "insert":
@@ -310,14 +571,11 @@ func (ws *WebSocketHandler) applyEdit(content string, edit *EditEvent) string {
 		return string(result)
 
 	case "delete":
-		if edit.Position >= len(runes) {
+		if edit.Position >= len(runes) || edit.Length <= 0 {
 			return content
 		}
-		endPosition := edit.Position + edit.Length
-		if endPosition > len(runes) {
-			endPosition = len(runes)
-		}
-		result := make([]rune, 0, len(runes)-edit.Length)
+		endPosition := edit.Position + min(edit.Length, len(runes)-edit.Position)
+		result := make([]rune, 0, len(runes)-(endPosition-edit.Position))
 		result = append(result, runes[:edit.Position]...)
 		result = append(result, runes[endPosition:]...)
 		return string(result)
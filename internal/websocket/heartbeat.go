@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"log/slog"
+	"time"
+)
+
+// touch records activity from the client: any inbound message or pong.
+func (c *Client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// LastSeen returns when the client was last heard from, falling back to the
+// connection time if it hasn't sent anything yet.
+func (c *Client) LastSeen() time.Time {
+	if seen := c.lastSeen.Load(); seen != 0 {
+		return time.Unix(0, seen)
+	}
+	return c.ConnectedAt
+}
+
+// reapStaleClients disconnects every client that hasn't been heard from
+// within StaleTimeout. Connections behind a broken NAT path can stay open
+// long after the peer is gone, leaving ghost users in presence lists.
+func (h *Hub) reapStaleClients() {
+	cutoff := time.Now().Add(-h.StaleTimeout)
+
+	var stale []*Client
+	h.mutex.RLock()
+	for _, clients := range h.clients {
+		for _, client := range clients {
+			lastSeen := client.LastSeen()
+			if !lastSeen.IsZero() && lastSeen.Before(cutoff) {
+				stale = append(stale, client)
+			}
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range stale {
+		slog.InfoContext(client.logContext(), "Reaping stale client", "idle", time.Since(client.LastSeen()).Round(time.Second).String())
+		h.staleClientsReaped.Add(1)
+		h.Unregister(client)
+		if client.Conn != nil {
+			client.Conn.Close()
+		}
+	}
+}
+
+func (h *Hub) StaleClientsReaped() int64 {
+	return h.staleClientsReaped.Load()
+}
+
+func (h *Hub) reapInterval() time.Duration {
+	interval := h.StaleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
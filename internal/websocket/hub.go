@@ -1,9 +1,12 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,14 +15,156 @@ type Client struct {
 	ID         string
 	DocumentId int
 	UserId     int
+	// Username is shown in presence; empty if the user hasn't set one.
+	Username string
+	// AvatarURL is shown in presence; empty if the user hasn't set one.
+	AvatarURL  string
 	Permission string
+	// Encrypted is set for end-to-end encrypted documents, whose edits
+	// are relayed without being applied.
+	Encrypted bool
+	// maxEditors caps how many users can edit the document at once when
+	// the client joins; past it, the client joins as a viewer. Zero means
+	// no cap.
+	maxEditors int
 	Conn       *websocket.Conn
 	Send       chan []byte
 	Hub        *Hub
+
+	// TokenExpiry is when the token the connection authenticated with
+	// expires. The connection is closed at that point unless the client
+	// sends a fresh token first. A zero value disables the check.
+	TokenExpiry  time.Time
+	tokenRefresh chan time.Time
+	// sessionId is the session of the token the connection last
+	// authenticated with, 0 if it isn't tied to one. It is guarded by
+	// stateMutex.
+	sessionId int
+
+	// Info describes the connecting application, e.g. "web" on "iPad".
+	Info        ClientInfo
+	ConnectedAt time.Time
+	// IP is the address the connection was opened from.
+	IP string
+	// ctx carries the request ID, trace, and client, user, and document IDs
+	// of the request that opened the connection, for logging and tracing.
+	ctx context.Context
+	// RTT is the most recently measured application round-trip time.
+	RTT time.Duration
+	// protocolVersion is the version negotiated in the client's hello
+	// message. Zero means the client hasn't negotiated.
+	protocolVersion int
+	// lastSeen is the time of the last inbound message or pong, in Unix
+	// nanoseconds.
+	lastSeen atomic.Int64
+
+	// ephemeral holds droppable messages when the hub uses the
+	// SlowClientDropOldest policy.
+	ephemeral chan []byte
+	// binary holds Yjs awareness frames, written as binary messages.
+	binary chan []byte
+	// awareness is the client's latest Yjs awareness frame, replayed to
+	// clients that query awareness.
+	awareness []byte
+
+	stateMutex  sync.RWMutex
+	sendMutex   sync.Mutex
+	sendClosed  bool
+	closeCode   int
+	closeReason string
+}
+
+// logContext returns the context to log with on behalf of the client.
+func (c *Client) logContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// trySend queues data for the client without blocking. It returns false if
+// the send buffer is full or the client has already been closed.
+func (c *Client) trySend(data []byte) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeSend closes the send channel, which makes the write pump close the
+// connection. It is safe to call more than once.
+func (c *Client) closeSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.Send)
+	}
+}
+
+// closeWith closes the send channel like closeSend, after which the write
+// pump sends the queued messages and a close frame with code and reason. It
+// returns false if the channel was already closed.
+func (c *Client) closeWith(code int, reason string) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+	c.closeCode = code
+	c.closeReason = reason
+	c.sendClosed = true
+	close(c.Send)
+	return true
+}
+
+// CurrentPermission returns the client's permission, which may change while
+// the connection is open.
+func (c *Client) CurrentPermission() string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.Permission
+}
+
+func (c *Client) currentSessionId() int {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.sessionId
+}
+
+func (c *Client) setSessionId(sessionId int) {
+	c.stateMutex.Lock()
+	c.sessionId = sessionId
+	c.stateMutex.Unlock()
+}
+
+func (c *Client) setPermission(permission string) {
+	c.stateMutex.Lock()
+	c.Permission = permission
+	c.stateMutex.Unlock()
+}
+
+func (c *Client) canEdit() bool {
+	permission := c.CurrentPermission()
+	return permission == "edit" || permission == "owner"
 }
 
 type Message struct {
-	Type       string      `json:"type"`
+	Type string `json:"type"`
+	// MessageId is an optional client-chosen identifier echoed back in
+	// broadcasts and in error frames about the message.
+	MessageId  string      `json:"message_id,omitempty"`
 	DocumentId int         `json:"document_id"`
 	UserId     int         `json:"user_id"`
 	Version    int         `json:"version"`
@@ -34,38 +179,200 @@ type EditEvent struct {
 	Length    int    `json:"length,omitempty"`
 }
 
+// Hub tracks connected clients per document. Every document with connected
+// clients is served by its own room goroutine, so a busy document only
+// delays its own collaborators.
 type Hub struct {
 	clients    map[int]map[string]*Client
+	rooms      map[int]*room
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan *Message
 	mutex      sync.RWMutex
+
+	// SlowClientPolicy decides how clients with a full send buffer are
+	// handled. It must be set before the hub is used.
+	SlowClientPolicy SlowClientPolicy
+	slowClients      slowClientCounters
+
+	// StaleTimeout disconnects clients that haven't been heard from for
+	// this long. Zero disables reaping. It must be set before Run.
+	StaleTimeout       time.Duration
+	staleClientsReaped atomic.Int64
+
+	// draining is set once the instance starts shutting down. New
+	// connections are refused from then on.
+	draining atomic.Bool
+
+	// Relay shares broadcast messages, presence changes, access changes,
+	// and Yjs awareness updates with the other instances serving the same
+	// documents. When nil they only reach clients connected to this
+	// instance. It must be set before the hub is used.
+	Relay Relay
+	// Store, when set, is kept current with the edits relayed from other
+	// instances. It must be set before the hub is used.
+	Store *DocumentStore
+}
+
+// room serializes registration, unregistration and broadcasts for a single
+// document. It exits as soon as its last client leaves.
+type room struct {
+	documentId int
+	hub        *Hub
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan *Message
+	exec       chan func()
+	done       chan struct{}
 }
 
 func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[int]map[string]*Client),
+		rooms:      make(map[int]*room),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan *Message),
+
+		SlowClientPolicy: SlowClientClose,
 	}
 }
 
+// Run forwards requests sent on the hub's channels to the owning rooms and
+// periodically reaps stale clients.
 func (h *Hub) Run() {
+	var reap <-chan time.Time
+	if h.StaleTimeout > 0 {
+		ticker := time.NewTicker(h.reapInterval())
+		defer ticker.Stop()
+		reap = ticker.C
+	}
+
 	for {
 		select {
 		case client := <-h.register:
-			h.registerClient(client)
+			h.Register(client)
 
 		case client := <-h.unregister:
-			h.unregisterClient(client)
+			h.Unregister(client)
 
 		case message := <-h.broadcast:
-			h.broadcastToDocument(message)
+			h.BroadcastMessage(message)
+
+		case <-reap:
+			h.reapStaleClients()
+		}
+	}
+}
+
+// Register adds a client to its document's room, starting the room if needed.
+func (h *Hub) Register(client *Client) {
+	for {
+		r := h.room(client.DocumentId, true)
+		select {
+		case r.register <- client:
+			return
+		case <-r.done:
+			// The room emptied out while we were sending; start a new one
 		}
 	}
 }
 
+// Unregister removes a client from its document's room.
+func (h *Hub) Unregister(client *Client) {
+	if r := h.room(client.DocumentId, false); r != nil {
+		select {
+		case r.unregister <- client:
+		case <-r.done:
+		}
+	}
+}
+
+// inRoom runs fn on the document's room goroutine. It does nothing if the
+// document has no connected clients.
+func (h *Hub) inRoom(documentId int, fn func()) {
+	if r := h.room(documentId, false); r != nil {
+		select {
+		case r.exec <- fn:
+		case <-r.done:
+		}
+	}
+}
+
+func (h *Hub) sendToClient(client *Client, message *Message) {
+	data, err := encodeMessage(message, client.ProtocolVersion())
+	if err != nil {
+		slog.Error("Failed to encode message", "type", message.Type, "error", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	if !client.deliver(data, isEphemeral(message.Type)) {
+		h.slowClients.droppedMessages.Add(1)
+		slog.WarnContext(client.logContext(), "Dropping message for slow client", "type", message.Type)
+	}
+}
+
+func (h *Hub) room(documentId int, create bool) *room {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	r, exists := h.rooms[documentId]
+	if !exists && create {
+		r = &room{
+			documentId: documentId,
+			hub:        h,
+			register:   make(chan *Client),
+			unregister: make(chan *Client),
+			broadcast:  make(chan *Message),
+			exec:       make(chan func()),
+			done:       make(chan struct{}),
+		}
+		h.rooms[documentId] = r
+		go r.run()
+	}
+	return r
+}
+
+func (r *room) run() {
+	for {
+		select {
+		case client := <-r.register:
+			r.hub.registerClient(client)
+
+		case client := <-r.unregister:
+			r.hub.unregisterClient(client)
+
+		case message := <-r.broadcast:
+			r.hub.broadcastToDocument(message)
+
+		case fn := <-r.exec:
+			fn()
+		}
+
+		if r.closeIfEmpty() {
+			return
+		}
+	}
+}
+
+func (r *room) closeIfEmpty() bool {
+	h := r.hub
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.clients[r.documentId]) > 0 {
+		return false
+	}
+
+	delete(h.clients, r.documentId)
+	delete(h.rooms, r.documentId)
+	close(r.done)
+	return true
+}
+
 func (h *Hub) registerClient(client *Client) {
 	h.mutex.Lock()
 
@@ -73,25 +380,19 @@ func (h *Hub) registerClient(client *Client) {
 		h.clients[client.DocumentId] = make(map[string]*Client)
 	}
 
+	capped := h.capEditors(client)
 	h.clients[client.DocumentId][client.ID] = client
+	activeUsers := len(h.clients[client.DocumentId])
 
-	log.Printf("Client %s (user %d, permission: %s) connected to document %d. Total clients: %d\n",
-		client.ID, client.UserId, client.Permission, client.DocumentId, len(h.clients[client.DocumentId]))
+	slog.InfoContext(client.logContext(), "Client connected",
+		"permission", client.Permission, "document_clients", activeUsers)
 
 	h.mutex.Unlock()
-	// Notify all clients about the new user
-	userJoinMsg := &Message{
-		Type:       "user_join",
-		DocumentId: client.DocumentId,
-		UserId:     client.UserId,
-		Payload: map[string]interface{}{
-			"user_id":    client.UserId,
-			"permission": client.Permission,
-		},
-	}
 
-	// Send join notification to all other clients
-	h.broadcastToDocumentExcept(userJoinMsg, client.ID)
+	// Spectators join silently; they show up as a viewer count in presence
+	if !client.isSpectator() {
+		h.announceJoin(client)
+	}
 
 	// Send connection confirmation to the new client
 	confirmMsg := &Message{
@@ -99,19 +400,45 @@ func (h *Hub) registerClient(client *Client) {
 		DocumentId: client.DocumentId,
 		UserId:     client.UserId,
 		Payload: map[string]interface{}{
-			"client_id":    client.ID,
-			"permission":   client.Permission,
-			"active_users": len(h.clients[client.DocumentId]),
+			"client_id":         client.ID,
+			"permission":        client.Permission,
+			"active_users":      activeUsers,
+			"protocol_version":  client.ProtocolVersion(),
+			"protocol_versions": supportedProtocolVersions,
 		},
 	}
 
 	if data, err := json.Marshal(confirmMsg); err == nil {
-		select {
-		case client.Send <- data:
-		default:
-			close(client.Send)
+		if !client.trySend(data) {
+			client.closeSlow()
 		}
 	}
+
+	if capped {
+		h.sendToClient(client, editorLimitMessage(client))
+	}
+}
+
+// announceJoin tells everyone else in the document about a new user.
+func (h *Hub) announceJoin(client *Client) {
+	entry := client.presenceEntry()
+	userJoinMsg := &Message{
+		Type:       "user_join",
+		DocumentId: client.DocumentId,
+		UserId:     client.UserId,
+		Payload: map[string]interface{}{
+			"user_id":     client.UserId,
+			"username":    client.Username,
+			"avatar_url":  client.AvatarURL,
+			"client_id":   client.ID,
+			"permission":  entry.Permission,
+			"client_name": entry.ClientName,
+			"platform":    entry.Platform,
+		},
+	}
+
+	h.broadcastToDocumentExcept(userJoinMsg, client.ID)
+	h.publish(userJoinMsg)
 }
 
 func (h *Hub) unregisterClient(client *Client) {
@@ -120,12 +447,11 @@ func (h *Hub) unregisterClient(client *Client) {
 	if clients, exists := h.clients[client.DocumentId]; exists {
 		if _, exists := clients[client.ID]; exists {
 			delete(clients, client.ID)
-			close(client.Send)
+			client.closeSend()
 
 			remainingClients := len(clients)
 
-			log.Printf("Client %s (user %d) disconnected from document %d. Remaining clients: %d",
-				client.ID, client.UserId, client.DocumentId, remainingClients)
+			slog.InfoContext(client.logContext(), "Client disconnected", "document_clients", remainingClients)
 
 			if remainingClients == 0 {
 				delete(h.clients, client.DocumentId)
@@ -133,6 +459,10 @@ func (h *Hub) unregisterClient(client *Client) {
 
 			h.mutex.Unlock()
 
+			if client.isSpectator() {
+				return
+			}
+
 			// Notify other clients about user leaving
 			userLeaveMsg := &Message{
 				Type:       "user_leave",
@@ -143,6 +473,7 @@ func (h *Hub) unregisterClient(client *Client) {
 				},
 			}
 			h.broadcastToDocumentExcept(userLeaveMsg, client.ID)
+			h.publish(userLeaveMsg)
 			return
 		}
 	}
@@ -150,61 +481,51 @@ func (h *Hub) unregisterClient(client *Client) {
 }
 
 func (h *Hub) broadcastToDocument(message *Message) {
-	h.mutex.RLock()
-	clients := h.clients[message.DocumentId]
-	h.mutex.RUnlock()
-
-	if clients == nil {
-		return
-	}
+	h.broadcastToDocumentExcept(message, "")
+}
 
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshalling message: %v", err)
+func (h *Hub) broadcastToDocumentExcept(message *Message, exceptClientId string) {
+	clients := h.GetDocumentClients(message.DocumentId)
+	if len(clients) == 0 {
 		return
 	}
 
-	for clientId, client := range clients {
-		if client == nil {
+	encoder := newMessageEncoder(message)
+	ephemeral := isEphemeral(message.Type)
+	var dropped []*Client
+	for _, client := range clients {
+		if client == nil || client.ID == exceptClientId {
 			continue
 		}
 
-		select {
-		case client.Send <- data:
-		default:
-			close(client.Send)
-			delete(clients, clientId)
+		data, err := encoder.encode(client.ProtocolVersion())
+		if err != nil {
+			slog.Error("Failed to encode message", "type", message.Type, "error", err)
+			return
+		}
+		if data == nil {
+			continue
 		}
-	}
-}
-
-func (h *Hub) broadcastToDocumentExcept(message *Message, exceptClientId string) {
-	h.mutex.RLock()
-	clients := h.clients[message.DocumentId]
-	h.mutex.RUnlock()
 
-	if clients == nil {
-		return
+		if !client.deliver(data, ephemeral) {
+			dropped = append(dropped, client)
+		}
 	}
 
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshalling message: %v", err)
+	if len(dropped) == 0 {
 		return
 	}
 
-	for clientId, client := range clients {
-		if client == nil || clientId == exceptClientId {
-			continue
-		}
-
-		select {
-		case client.Send <- data:
-		default:
-			close(client.Send)
-			delete(clients, clientId)
+	h.mutex.Lock()
+	for _, client := range dropped {
+		if docClients, exists := h.clients[client.DocumentId]; exists {
+			if _, exists := docClients[client.ID]; exists {
+				delete(docClients, client.ID)
+				client.closeSlow()
+			}
 		}
 	}
+	h.mutex.Unlock()
 }
 
 func (h *Hub) GetDocumentClientCount(documentId int) int {
@@ -217,6 +538,18 @@ func (h *Hub) GetDocumentClientCount(documentId int) int {
 	return 0
 }
 
+// GetClientCount returns the number of connected clients and of documents
+// they are connected to.
+func (h *Hub) GetClientCount() (clients, documents int) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, docClients := range h.clients {
+		clients += len(docClients)
+	}
+	return clients, len(h.clients)
+}
+
 func (h *Hub) GetDocumentClients(documentId int) []*Client {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -230,6 +563,65 @@ func (h *Hub) GetDocumentClients(documentId int) []*Client {
 	return clients
 }
 
+// BroadcastMessage delivers a message to every client of its document, here
+// and, through the Relay, on the other instances.
 func (h *Hub) BroadcastMessage(message *Message) {
-	h.broadcast <- message
+	h.deliver(message)
+	h.publish(message)
+}
+
+// deliverRelayed delivers a message another instance broadcast. Edits are
+// applied to the Store first, so the next local edit builds on them.
+// Access changes are applied to this instance's connections the way the
+// instance that made them applied them to its own.
+func (h *Hub) deliverRelayed(message *Message) {
+	switch message.Type {
+	case "edit":
+		if h.Store != nil {
+			h.Store.ApplyRelayed(message)
+		}
+	case "permission_changed":
+		h.permissionChanged(message)
+		return
+	case "collaborator_removed":
+		h.collaboratorRemoved(message)
+		return
+	case relayAccessRevoked:
+		h.inRoom(message.DocumentId, func() {
+			h.revokeAccess(message.DocumentId, message.UserId)
+		})
+		return
+	case relayDocumentUnpublished:
+		h.documentUnpublished(message.DocumentId)
+		return
+	case relaySessionRevoked:
+		payload, _ := message.Payload.(map[string]interface{})
+		// connections without a session have id 0, which must never match
+		if sessionId, ok := payload["session_id"].(float64); ok && sessionId > 0 {
+			h.sessionRevoked(int(sessionId))
+		}
+		return
+	}
+	h.deliver(message)
+}
+
+// publish shares a message with the other instances.
+func (h *Hub) publish(message *Message) {
+	if h.Relay == nil {
+		return
+	}
+	if err := h.Relay.PublishMessage(message); err != nil {
+		slog.Error("Failed to relay message", "type", message.Type, "document_id", message.DocumentId, "error", err)
+	}
+}
+
+// deliver delivers a message to every client of its document on this
+// instance. It is a no-op for documents without connected clients.
+func (h *Hub) deliver(message *Message) {
+	if r := h.room(message.DocumentId, false); r != nil {
+		select {
+		case r.broadcast <- message:
+		case <-r.done:
+		}
+	}
 }
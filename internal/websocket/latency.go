@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyPingInterval is how often the server sends an application-level
+// ping to measure round-trip time. Protocol-level pings are not visible to
+// browser clients, so they can't be used for this.
+const latencyPingInterval = 15 * time.Second
+
+func latencyPingMessage(c *Client) *Message {
+	return &Message{
+		Type:       "ping",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"sent_at": time.Now().UnixMilli(),
+		},
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// handlePong records the round trip of a server ping echoed back by the
+// client and tells the client the measured latency.
+func (c *Client) handlePong(message *Message) {
+	payload, _ := message.Payload.(map[string]interface{})
+	sentAt, ok := payload["sent_at"].(float64)
+	if !ok {
+		return
+	}
+
+	rtt := time.Since(time.UnixMilli(int64(sentAt)))
+	if rtt < 0 {
+		return
+	}
+
+	c.stateMutex.Lock()
+	c.RTT = rtt
+	c.stateMutex.Unlock()
+
+	c.sendJSON(&Message{
+		Type:       "latency",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"rtt_ms": rtt.Milliseconds(),
+		},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// handlePing answers a client-initiated ping, echoing its timestamp so the
+// client can measure latency from its side.
+func (c *Client) handlePing(message *Message) {
+	payload, _ := message.Payload.(map[string]interface{})
+
+	c.sendJSON(&Message{
+		Type:       "pong",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"client_time": payload["client_time"],
+			"server_time": time.Now().UnixMilli(),
+		},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// LatencyStats summarizes the measured round-trip times of every connected
+// client.
+type LatencyStats struct {
+	Clients  int     `json:"clients" example:"12"`
+	Measured int     `json:"measured" example:"10"`
+	AvgMs    float64 `json:"avg_ms" example:"42.5"`
+	MaxMs    int64   `json:"max_ms" example:"180"`
+}
+
+func (h *Hub) LatencyStats() LatencyStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var stats LatencyStats
+	var total time.Duration
+	var max time.Duration
+	for _, clients := range h.clients {
+		for _, client := range clients {
+			stats.Clients++
+
+			client.stateMutex.RLock()
+			rtt := client.RTT
+			client.stateMutex.RUnlock()
+
+			if rtt == 0 {
+				continue
+			}
+			stats.Measured++
+			total += rtt
+			if rtt > max {
+				max = rtt
+			}
+		}
+	}
+
+	if stats.Measured > 0 {
+		stats.AvgMs = float64(total.Milliseconds()) / float64(stats.Measured)
+	}
+	stats.MaxMs = max.Milliseconds()
+	return stats
+}
+
+// StatsResponse reports aggregate WebSocket metrics for operators.
+type StatsResponse struct {
+	ActiveConnections int             `json:"active_connections" example:"12"`
+	ActiveDocuments   int             `json:"active_documents" example:"4"`
+	Latency           LatencyStats    `json:"latency"`
+	SlowClients       SlowClientStats `json:"slow_clients"`
+	// StaleClientsReaped counts clients disconnected for missing heartbeats.
+	StaleClientsReaped int64 `json:"stale_clients_reaped" example:"2"`
+	// ConnectionLimits is omitted when connections aren't limited.
+	ConnectionLimits *ConnectionLimitStats `json:"connection_limits,omitempty"`
+}
+
+// GetStats godoc
+// @Summary WebSocket metrics
+// @Description Aggregate WebSocket metrics: open connections, documents being edited, and round-trip latency across connected clients, and how often slow clients were disconnected or had messages dropped.
+// @Tags health
+// @Produce json
+// @Success 200 {object} StatsResponse
+// @Router /metrics/websocket [get]
+func (ws *WebSocketHandler) GetStats(c *gin.Context) {
+	latency := ws.Hub.LatencyStats()
+
+	ws.Hub.mutex.RLock()
+	documents := len(ws.Hub.clients)
+	ws.Hub.mutex.RUnlock()
+
+	response := StatsResponse{
+		ActiveConnections: latency.Clients,
+		ActiveDocuments:   documents,
+		Latency:           latency,
+		SlowClients:       ws.Hub.SlowClientStats(),
+
+		StaleClientsReaped: ws.Hub.StaleClientsReaped(),
+	}
+	if ws.Limiter != nil {
+		limits := ws.Limiter.Stats()
+		response.ConnectionLimits = &limits
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConnectionLimiter caps the number of open WebSocket connections, in total
+// and per client IP, so a single misbehaving network can't exhaust the
+// server's file descriptors. A zero limit means unlimited.
+type ConnectionLimiter struct {
+	MaxConnections      int
+	MaxConnectionsPerIP int
+
+	total    int
+	perIP    map[string]int
+	mutex    sync.Mutex
+	rejected atomic.Int64
+}
+
+func NewConnectionLimiter(maxConnections, maxConnectionsPerIP int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		MaxConnections:      maxConnections,
+		MaxConnectionsPerIP: maxConnectionsPerIP,
+		perIP:               make(map[string]int),
+	}
+}
+
+// Acquire reserves a connection slot for ip. It returns false if either
+// limit has been reached; otherwise the slot must be freed with Release.
+func (l *ConnectionLimiter) Acquire(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if (l.MaxConnections > 0 && l.total >= l.MaxConnections) ||
+		(l.MaxConnectionsPerIP > 0 && l.perIP[ip] >= l.MaxConnectionsPerIP) {
+		l.rejected.Add(1)
+		return false
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+// Release frees a slot taken by Acquire.
+func (l *ConnectionLimiter) Release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.perIP[ip] == 0 {
+		return
+	}
+
+	l.total--
+	l.perIP[ip]--
+	if l.perIP[ip] == 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// ConnectionLimitStats reports connection limit usage.
+type ConnectionLimitStats struct {
+	MaxConnections      int   `json:"max_connections" example:"10000"`
+	MaxConnectionsPerIP int   `json:"max_connections_per_ip" example:"50"`
+	Open                int   `json:"open" example:"12"`
+	Rejected            int64 `json:"rejected" example:"3"`
+}
+
+func (l *ConnectionLimiter) Stats() ConnectionLimitStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return ConnectionLimitStats{
+		MaxConnections:      l.MaxConnections,
+		MaxConnectionsPerIP: l.MaxConnectionsPerIP,
+		Open:                l.total,
+		Rejected:            l.rejected.Load(),
+	}
+}
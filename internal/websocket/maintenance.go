@@ -0,0 +1,37 @@
+package websocket
+
+import (
+	"live-collab-api/internal/maintenance"
+	"time"
+)
+
+// ErrCodeMaintenance is sent for edits made while the API is in
+// maintenance.
+const ErrCodeMaintenance = "maintenance"
+
+// ErrCodeReadOnly is sent for edits made while the database is unreachable.
+const ErrCodeReadOnly = "read_only"
+
+// MaintenanceNotice sends a "maintenance" message with the new state to
+// every connected client, so editors can warn users before edits start
+// being rejected or the server restarts, and switch to read-only while the
+// database is unreachable.
+func (h *Hub) MaintenanceNotice(state maintenance.State) {
+	h.mutex.RLock()
+	documentIds := make([]int, 0, len(h.clients))
+	for documentId := range h.clients {
+		documentIds = append(documentIds, documentId)
+	}
+	h.mutex.RUnlock()
+
+	now := time.Now().Unix()
+	for _, documentId := range documentIds {
+		// every instance announces the change to its own clients
+		h.deliver(&Message{
+			Type:       "maintenance",
+			DocumentId: documentId,
+			Payload:    state,
+			Timestamp:  now,
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// notifyChannel is the Postgres channel instances relay on.
+const notifyChannel = "live_collab_relay"
+
+// maxNotifyPayload is the size Postgres requires NOTIFY payloads to stay
+// under.
+const maxNotifyPayload = 8000
+
+// notifyPublishTimeout bounds a single NOTIFY.
+const notifyPublishTimeout = 5 * time.Second
+
+// NotifyRelay relays messages between instances over Postgres
+// LISTEN/NOTIFY, for deployments without Redis. It holds one connection
+// taken out of the pool for as long as it listens.
+type NotifyRelay struct {
+	pool *pgxpool.Pool
+	hub  *Hub
+	ctx  context.Context
+	stop context.CancelFunc
+	// instanceId tags published notifications so an instance ignores its
+	// own.
+	instanceId string
+}
+
+// notifyEnvelope carries either a message or an opaque Yjs awareness frame.
+type notifyEnvelope struct {
+	Origin     string   `json:"origin"`
+	Message    *Message `json:"message,omitempty"`
+	DocumentId int      `json:"document_id,omitempty"`
+	Awareness  []byte   `json:"awareness,omitempty"`
+}
+
+func NewNotifyRelay(pool *pgxpool.Pool, hub *Hub) *NotifyRelay {
+	ctx, stop := context.WithCancel(context.Background())
+	return &NotifyRelay{
+		pool:       pool,
+		hub:        hub,
+		ctx:        ctx,
+		stop:       stop,
+		instanceId: uuid.New().String(),
+	}
+}
+
+// StartListening delivers notifications from other instances until Close,
+// reconnecting with backoff when the connection is lost.
+func (r *NotifyRelay) StartListening() {
+	backoff := time.Second
+	for {
+		err := r.listen()
+		if r.ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Postgres relay connection lost, reconnecting", "error", err, "retry_in", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-r.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (r *NotifyRelay) listen() error {
+	pooled, err := r.pool.Acquire(r.ctx)
+	if err != nil {
+		return err
+	}
+	// a listening connection can't go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(r.ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	slog.Info("Postgres relay listening", "channel", notifyChannel)
+
+	for {
+		notification, err := conn.WaitForNotification(r.ctx)
+		if err != nil {
+			return err
+		}
+		r.handleNotification([]byte(notification.Payload))
+	}
+}
+
+func (r *NotifyRelay) handleNotification(payload []byte) {
+	var envelope notifyEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		slog.Warn("Received invalid Postgres relay notification", "error", err)
+		return
+	}
+
+	if envelope.Origin == r.instanceId {
+		return
+	}
+	switch {
+	case envelope.Message != nil:
+		r.hub.deliverRelayed(envelope.Message)
+	case envelope.Awareness != nil:
+		r.hub.BroadcastAwareness(envelope.DocumentId, envelope.Awareness, "")
+	}
+}
+
+func (r *NotifyRelay) PublishMessage(message *Message) error {
+	return r.publish(notifyEnvelope{Origin: r.instanceId, Message: message})
+}
+
+// PublishAwareness shares a Yjs awareness frame with the other instances
+// serving the document.
+func (r *NotifyRelay) PublishAwareness(documentId int, data []byte) error {
+	return r.publish(notifyEnvelope{Origin: r.instanceId, DocumentId: documentId, Awareness: data})
+}
+
+func (r *NotifyRelay) publish(envelope notifyEnvelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	if len(payload) >= maxNotifyPayload {
+		return fmt.Errorf("notification of %d bytes is too large for NOTIFY", len(payload))
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, notifyPublishTimeout)
+	defer cancel()
+	_, err = r.pool.Exec(ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	return err
+}
+
+// Close stops listening and releases the listening connection.
+func (r *NotifyRelay) Close() {
+	r.stop()
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// OriginPolicy decides which browser origins may open WebSocket connections.
+// Entries are full origins such as "https://app.example.com", or wildcard
+// subdomain patterns such as "https://*.example.com". An entry without a
+// scheme matches any scheme.
+type OriginPolicy struct {
+	AllowedOrigins []string
+	// AllowAll accepts every origin. It is meant for local development only.
+	AllowAll bool
+
+	mutex sync.RWMutex
+}
+
+// Update replaces the allowed origins, such as when the configuration is
+// reloaded.
+func (p *OriginPolicy) Update(allowedOrigins []string, allowAll bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.AllowedOrigins = allowedOrigins
+	p.AllowAll = allowAll
+}
+
+// ParseOrigins splits a comma-separated list of origins, dropping empty
+// entries.
+func ParseOrigins(list string) []string {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = normalizeOrigin(origin)
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// Allowed reports whether a request from origin may be upgraded. Requests
+// without an Origin header don't come from browsers and are always allowed.
+// A nil policy rejects every browser origin.
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.AllowAll {
+		return true
+	}
+
+	origin = normalizeOrigin(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if matchOrigin(normalizeOrigin(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *OriginPolicy) checkOrigin(r *http.Request) bool {
+	return p.Allowed(r.Header.Get("Origin"))
+}
+
+func matchOrigin(pattern, origin string) bool {
+	if pattern == origin {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	host := pattern
+	if scheme, rest, found := strings.Cut(pattern, "://"); found {
+		if scheme != u.Scheme {
+			return false
+		}
+		host = rest
+	}
+
+	if host == u.Host {
+		return true
+	}
+
+	// "*.example.com" matches any subdomain, but not example.com itself
+	if suffix, found := strings.CutPrefix(host, "*."); found {
+		return strings.HasSuffix(u.Host, "."+suffix)
+	}
+	return false
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+func (p *OriginPolicy) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{CheckOrigin: p.checkOrigin}
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import "unicode/utf8"
+
+// Operational transformation for insert/delete edits. Positions and lengths
+// are in runes, matching applyEdit.
+
+// transformEdits rebases ops over others, where both lists start from the
+// same document state and each list is applied in order. It returns ops
+// rewritten to apply after others, and others rewritten to apply after ops.
+// When both insert at the same position, others' text goes first unless
+// opsWin is set.
+func transformEdits(ops, others []EditEvent, opsWin bool) ([]EditEvent, []EditEvent) {
+	if len(ops) == 0 || len(others) == 0 {
+		return ops, others
+	}
+
+	if len(ops) > 1 {
+		first, othersAfterFirst := transformEdits(ops[:1], others, opsWin)
+		rest, othersAfterAll := transformEdits(ops[1:], othersAfterFirst, opsWin)
+		return append(first, rest...), othersAfterAll
+	}
+
+	if len(others) > 1 {
+		op, first := transformEdits(ops, others[:1], opsWin)
+		op, rest := transformEdits(op, others[1:], opsWin)
+		return op, append(first, rest...)
+	}
+
+	return transformEdit(ops[0], others[0], opsWin), transformEdit(others[0], ops[0], !opsWin)
+}
+
+// transformEdit rewrites a so that it applies after b. A delete that spans
+// b's insert is split in two so the inserted text survives.
+func transformEdit(a, b EditEvent, aWins bool) []EditEvent {
+	switch {
+	case a.Operation == "insert" && b.Operation == "insert":
+		if b.Position < a.Position || (b.Position == a.Position && !aWins) {
+			a.Position += runeLen(b.Content)
+		}
+		return []EditEvent{a}
+
+	case a.Operation == "insert" && b.Operation == "delete":
+		switch {
+		case a.Position <= b.Position:
+		case a.Position >= b.Position+b.Length:
+			a.Position -= b.Length
+		default:
+			a.Position = b.Position
+		}
+		return []EditEvent{a}
+
+	case a.Operation == "delete" && b.Operation == "insert":
+		inserted := runeLen(b.Content)
+		switch {
+		case b.Position <= a.Position:
+			a.Position += inserted
+			return []EditEvent{a}
+		case b.Position >= a.Position+a.Length:
+			return []EditEvent{a}
+		default:
+			before := EditEvent{Operation: "delete", Position: a.Position, Length: b.Position - a.Position}
+			after := EditEvent{Operation: "delete", Position: a.Position + inserted, Length: a.Length - before.Length}
+			return []EditEvent{before, after}
+		}
+
+	case a.Operation == "delete" && b.Operation == "delete":
+		aEnd, bEnd := a.Position+a.Length, b.Position+b.Length
+		switch {
+		case aEnd <= b.Position:
+			return []EditEvent{a}
+		case a.Position >= bEnd:
+			a.Position -= b.Length
+			return []EditEvent{a}
+		}
+
+		overlap := min(aEnd, bEnd) - max(a.Position, b.Position)
+		a.Position = min(a.Position, b.Position)
+		a.Length -= overlap
+		if a.Length == 0 {
+			return nil
+		}
+		return []EditEvent{a}
+	}
+
+	return []EditEvent{a}
+}
+
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
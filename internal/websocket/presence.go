@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"live-collab-api/internal/documents"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxClientInfoLength = 64
+
+// ClientInfo identifies the application a user is connected from so
+// collaborators can tell "Alice (iPad)" from "Alice (web)".
+type ClientInfo struct {
+	Name     string `json:"client_name,omitempty" example:"web"`
+	Platform string `json:"platform,omitempty" example:"iPad"`
+}
+
+// PresenceEntry describes a single open connection to a document.
+type PresenceEntry struct {
+	ClientID    string    `json:"client_id" example:"5f0c6a8e-2d7b-4c3e-9a41-8b7f2e1d0c9a"`
+	UserID      int       `json:"user_id" example:"1"`
+	Username    string    `json:"username,omitempty" example:"alice"`
+	AvatarURL   string    `json:"avatar_url,omitempty" example:"https://api.example.com/api/public/avatars/0b9e3f52-7c1d-4a8e-b6f2-3d5c9e1a7f40.png"`
+	Permission  string    `json:"permission" example:"edit"`
+	ClientName  string    `json:"client_name,omitempty" example:"web"`
+	Platform    string    `json:"platform,omitempty" example:"iPad"`
+	ConnectedAt time.Time `json:"connected_at" example:"2025-09-19T10:30:00Z"`
+	RTTMs       *int64    `json:"rtt_ms,omitempty" example:"42"`
+}
+
+// PresenceResponse lists the connections currently open to a document.
+type PresenceResponse struct {
+	DocumentID  int `json:"document_id" example:"1"`
+	ActiveUsers int `json:"active_users" example:"2"`
+	// Viewers counts spectators watching a published document. They are
+	// not listed in Clients.
+	Viewers int             `json:"viewers" example:"5"`
+	Clients []PresenceEntry `json:"clients"`
+}
+
+// GetPresence godoc
+// @Summary Get document presence
+// @Description List the clients currently connected to a document over WebSocket, including the application and platform each one connected from. Spectators watching a published document are only counted as viewers.
+// @Tags collaboration
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Success 200 {object} PresenceResponse "Connected clients"
+// @Failure 400 {object} documents.ErrorResponse "Invalid document ID"
+// @Failure 401 {object} documents.ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} documents.ErrorResponse "Access denied - you don't have access to this document"
+// @Router /api/documents/{id}/presence [get]
+func (ws *WebSocketHandler) GetPresence(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+
+	clients := ws.Hub.GetDocumentClients(documentId)
+	users := make(map[int]bool)
+	entries := make([]PresenceEntry, 0, len(clients))
+	viewers := 0
+	for _, client := range clients {
+		if client.isSpectator() {
+			viewers++
+			continue
+		}
+		users[client.UserId] = true
+		entries = append(entries, client.presenceEntry())
+	}
+
+	c.JSON(http.StatusOK, PresenceResponse{
+		DocumentID:  documentId,
+		ActiveUsers: len(users),
+		Viewers:     viewers,
+		Clients:     entries,
+	})
+}
+
+func (c *Client) presenceEntry() PresenceEntry {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+
+	entry := PresenceEntry{
+		ClientID:    c.ID,
+		UserID:      c.UserId,
+		Username:    c.Username,
+		AvatarURL:   c.AvatarURL,
+		Permission:  c.Permission,
+		ClientName:  c.Info.Name,
+		Platform:    c.Info.Platform,
+		ConnectedAt: c.ConnectedAt,
+	}
+	if c.RTT > 0 {
+		rtt := c.RTT.Milliseconds()
+		entry.RTTMs = &rtt
+	}
+	return entry
+}
+
+// handleHello negotiates the protocol version and records the client name
+// and platform a client reports about itself, letting everyone else in the
+// document know.
+func (ws *WebSocketHandler) handleHello(c *Client, message *Message) *MessageError {
+	payload, _ := message.Payload.(map[string]interface{})
+
+	if versions := protocolVersionsFromPayload(payload); versions != nil {
+		version := negotiateProtocol(versions)
+		if version == 0 {
+			return newMessageError(ErrCodeUnsupportedProtocol, "None of the protocol versions %v are supported; server supports %v", versions, supportedProtocolVersions)
+		}
+		c.setProtocolVersion(version)
+		c.sendJSON(&Message{
+			Type:       "protocol",
+			DocumentId: c.DocumentId,
+			UserId:     c.UserId,
+			Payload: map[string]interface{}{
+				"protocol_version": version,
+			},
+			Timestamp: time.Now().Unix(),
+		})
+	}
+
+	name, _ := payload["client_name"].(string)
+	platform, _ := payload["platform"].(string)
+
+	c.stateMutex.Lock()
+	if name = sanitizeClientInfo(name); name != "" {
+		c.Info.Name = name
+	}
+	if platform = sanitizeClientInfo(platform); platform != "" {
+		c.Info.Platform = platform
+	}
+	info := c.Info
+	c.stateMutex.Unlock()
+
+	ws.Hub.BroadcastMessage(&Message{
+		Type:       "presence_update",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"user_id":     c.UserId,
+			"username":    c.Username,
+			"avatar_url":  c.AvatarURL,
+			"client_id":   c.ID,
+			"client_name": info.Name,
+			"platform":    info.Platform,
+		},
+	})
+	return nil
+}
+
+func sanitizeClientInfo(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxClientInfoLength {
+		value = value[:maxClientInfoLength]
+	}
+	return value
+}
+
+// clientInfoFromUserAgent makes a best-effort guess at the client from the
+// User-Agent sent with the upgrade request. A hello message overrides it.
+func clientInfoFromUserAgent(userAgent string) ClientInfo {
+	var info ClientInfo
+
+	platforms := []struct{ token, name string }{
+		{"iPad", "iPad"},
+		{"iPhone", "iPhone"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "macOS"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.token) {
+			info.Platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case userAgent == "":
+	case strings.HasPrefix(userAgent, "Mozilla/"):
+		info.Name = "web"
+	default:
+		product, _, _ := strings.Cut(userAgent, "/")
+		info.Name = sanitizeClientInfo(product)
+	}
+
+	return info
+}
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"context"
+	"live-collab-api/internal/auth"
+	"log/slog"
+	"time"
+)
+
+// reauthWarning is how long before a token expires the client is asked to
+// send a fresh one.
+const reauthWarning = 5 * time.Minute
+
+// tokenTimers fires once when a connection's token is about to expire and
+// again when it has expired. Both channels are nil when there is no expiry.
+type tokenTimers struct {
+	expiresAt time.Time
+	warn      *time.Timer
+	expire    *time.Timer
+}
+
+func newTokenTimers(expiresAt time.Time) *tokenTimers {
+	t := &tokenTimers{}
+	t.reset(expiresAt)
+	return t
+}
+
+func (t *tokenTimers) reset(expiresAt time.Time) {
+	t.stop()
+	t.expiresAt = expiresAt
+	if expiresAt.IsZero() {
+		t.warn, t.expire = nil, nil
+		return
+	}
+
+	t.warn = time.NewTimer(time.Until(expiresAt.Add(-reauthWarning)))
+	t.expire = time.NewTimer(time.Until(expiresAt))
+}
+
+func (t *tokenTimers) stop() {
+	if t.warn != nil {
+		t.warn.Stop()
+	}
+	if t.expire != nil {
+		t.expire.Stop()
+	}
+}
+
+func (t *tokenTimers) warnC() <-chan time.Time {
+	if t.warn == nil {
+		return nil
+	}
+	return t.warn.C
+}
+
+func (t *tokenTimers) expireC() <-chan time.Time {
+	if t.expire == nil {
+		return nil
+	}
+	return t.expire.C
+}
+
+func reauthRequiredMessage(c *Client, expiresAt time.Time) *Message {
+	return &Message{
+		Type:       "reauth_required",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"expires_at": expiresAt.Unix(),
+		},
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// handleTokenRefresh validates a token sent by the client and, if it belongs
+// to the same user, extends the connection's lifetime to the new expiry and
+// ties it to the token's session.
+func (c *Client) handleTokenRefresh(ctx context.Context, authService *auth.AuthService, message *Message) *MessageError {
+	var token string
+	if payload, ok := message.Payload.(map[string]interface{}); ok {
+		token, _ = payload["token"].(string)
+	}
+
+	userId, sessionId, expiresAt, err := authService.GetSessionFromToken(ctx, token)
+	if err != nil || userId != c.UserId {
+		return newMessageError(ErrCodeInvalidToken, "Token is invalid or belongs to another user")
+	}
+	c.setSessionId(sessionId)
+
+	if c.tokenRefresh == nil {
+		return nil
+	}
+
+	// Replace any refresh the write pump has not picked up yet
+	select {
+	case <-c.tokenRefresh:
+	default:
+	}
+	c.tokenRefresh <- expiresAt
+
+	c.sendJSON(&Message{
+		Type:       "token_refreshed",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Payload: map[string]interface{}{
+			"expires_at": expiresAt.Unix(),
+		},
+		Timestamp: time.Now().Unix(),
+	})
+	return nil
+}
+
+func (c *Client) sendJSON(v *Message) {
+	data, err := encodeMessage(v, c.ProtocolVersion())
+	if err != nil {
+		slog.Error("Failed to encode message", "type", v.Type, "error", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	if !c.deliver(data, isEphemeral(v.Type)) {
+		if c.Hub != nil {
+			c.Hub.slowClients.droppedMessages.Add(1)
+		}
+		slog.WarnContext(c.logContext(), "Dropping message for slow client", "type", v.Type)
+	}
+}
@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// RebuildContent replays the edit history of a document from the content
+// it was created with and returns the result along with the version of the
+// last edit. For documents whose initial content isn't known, such as ones
+// edited before it was recorded, the replay starts from empty content and
+// seeded is false. Edits persisted while it runs may or may not be included.
+func RebuildContent(ctx context.Context, db *sql.DB, documentId int) (content string, version int, seeded bool, err error) {
+	var initialContent sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT initial_content FROM documents WHERE id = $1", documentId).Scan(&initialContent); err != nil {
+		return "", 0, false, fmt.Errorf("failed to get initial content: %v", err)
+	}
+
+	version, err = currentDocumentVersion(ctx, db, documentId)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to get document version: %v", err)
+	}
+
+	edits, err := queryEditsSince(ctx, db, documentId, 0)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to load edits: %v", err)
+	}
+
+	content = initialContent.String
+	for i := range edits {
+		content = applyEdit(content, &edits[i])
+	}
+	return content, version, initialContent.Valid, nil
+}
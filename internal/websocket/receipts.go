@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"context"
+	"live-collab-api/internal/documents"
+	"log/slog"
+)
+
+// handleViewedMessage records the document version a client has rendered
+// and shares it with everyone else in the document for "seen by" indicators.
+func (ws *WebSocketHandler) handleViewedMessage(ctx context.Context, c *Client, message *Message) *MessageError {
+	payload, _ := message.Payload.(map[string]interface{})
+	version, ok := payload["version"].(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return newMessageError(ErrCodeInvalidPayload, "viewed requires a non-negative integer version")
+	}
+
+	docService := &documents.DocumentService{DB: ws.DB}
+	if err := docService.RecordRead(ctx, c.DocumentId, c.UserId, int(version)); err != nil {
+		slog.ErrorContext(ctx, "Failed to record read", "error", err)
+		return newMessageError(ErrCodePersistenceFailed, "Viewed version could not be saved")
+	}
+
+	ws.Hub.BroadcastMessage(&Message{
+		Type:       "viewed",
+		DocumentId: c.DocumentId,
+		UserId:     c.UserId,
+		Version:    int(version),
+		Payload: map[string]interface{}{
+			"user_id": c.UserId,
+			"version": int(version),
+		},
+		Timestamp: message.Timestamp,
+	})
+	return nil
+}
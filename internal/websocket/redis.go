@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -13,9 +15,25 @@ type RedisService struct {
 	client *redis.Client
 	hub    *Hub
 	ctx    context.Context
+	// instanceId tags published messages and awareness updates so an
+	// instance ignores its own.
+	instanceId string
 }
 
-func newRedisService(redisURL string, hub *Hub) (*RedisService, error) {
+// messageEnvelope carries a broadcast message between instances.
+type messageEnvelope struct {
+	Origin  string   `json:"origin"`
+	Message *Message `json:"message"`
+}
+
+// awarenessEnvelope carries an opaque Yjs awareness frame between instances.
+type awarenessEnvelope struct {
+	Origin     string `json:"origin"`
+	DocumentId int    `json:"document_id"`
+	Data       []byte `json:"data"`
+}
+
+func NewRedisService(redisURL string, hub *Hub) (*RedisService, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("redis url parse error: %v", err)
@@ -29,20 +47,25 @@ func newRedisService(redisURL string, hub *Hub) (*RedisService, error) {
 	}
 
 	return &RedisService{
-		client: client,
-		hub:    hub,
-		ctx:    ctx,
+		client:     client,
+		hub:        hub,
+		ctx:        ctx,
+		instanceId: uuid.New().String(),
 	}, nil
 }
 
 func (r *RedisService) StartSubscription() {
-	pubsub := r.client.PSubscribe(r.ctx, "doc:*")
+	pubsub := r.client.PSubscribe(r.ctx, "doc:*", "awareness:*")
 	defer pubsub.Close()
 
-	log.Println("Redis subscription started")
+	slog.Info("Redis subscription started")
 
 	ch := pubsub.Channel()
 	for msg := range ch {
+		if strings.HasPrefix(msg.Channel, "awareness:") {
+			r.handleAwarenessMessage(msg)
+			continue
+		}
 		r.handleRedisMessage(msg)
 	}
 }
@@ -50,7 +73,7 @@ func (r *RedisService) StartSubscription() {
 func (r *RedisService) PublishMessage(message *Message) error {
 	channel := fmt.Sprintf("doc:%d", message.DocumentId)
 
-	data, err := json.Marshal(message)
+	data, err := json.Marshal(messageEnvelope{Origin: r.instanceId, Message: message})
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
@@ -59,17 +82,55 @@ func (r *RedisService) PublishMessage(message *Message) error {
 }
 
 func (r *RedisService) handleRedisMessage(msg *redis.Message) {
-	var message Message
-	if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
-		log.Printf("Error unmarshaling Redis message: %v", err)
+	var envelope messageEnvelope
+	if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil || envelope.Message == nil {
+		slog.Warn("Received invalid Redis message", "channel", msg.Channel, "error", err)
+		return
+	}
+
+	if envelope.Origin == r.instanceId {
+		return
+	}
+	r.hub.deliverRelayed(envelope.Message)
+}
+
+// PublishAwareness shares a Yjs awareness frame with the other instances
+// serving the document.
+func (r *RedisService) PublishAwareness(documentId int, data []byte) error {
+	channel := fmt.Sprintf("awareness:%d", documentId)
+
+	payload, err := json.Marshal(awarenessEnvelope{Origin: r.instanceId, DocumentId: documentId, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal awareness update: %v", err)
+	}
+
+	return r.client.Publish(r.ctx, channel, payload).Err()
+}
+
+func (r *RedisService) handleAwarenessMessage(msg *redis.Message) {
+	var envelope awarenessEnvelope
+	if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+		slog.Warn("Received invalid Redis awareness update", "channel", msg.Channel, "error", err)
 		return
 	}
 
-	if r.hub.GetDocumentClientCount(message.DocumentId) > 0 {
-		r.hub.BroadcastMessage(&message)
+	if envelope.Origin == r.instanceId {
+		return
 	}
+	r.hub.BroadcastAwareness(envelope.DocumentId, envelope.Data, "")
 }
 
 func (r *RedisService) Close() error {
 	return r.client.Close()
 }
+
+// Ping checks that Redis is reachable.
+func (r *RedisService) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Client returns the underlying Redis client, for other features sharing
+// the connection.
+func (r *RedisService) Client() *redis.Client {
+	return r.client
+}
@@ -0,0 +1,15 @@
+package websocket
+
+// Relay shares messages and Yjs awareness updates with the other instances
+// serving the same documents, which deliver them to their own clients.
+// RedisService relays over Redis pub/sub and NotifyRelay over Postgres
+// LISTEN/NOTIFY.
+type Relay interface {
+	PublishMessage(message *Message) error
+	PublishAwareness(documentId int, data []byte) error
+}
+
+var (
+	_ Relay = (*RedisService)(nil)
+	_ Relay = (*NotifyRelay)(nil)
+)
@@ -0,0 +1,25 @@
+package websocket
+
+import "time"
+
+// Remind tells the user's open connections to a document that it is due.
+// Only connections to this instance are reached; reminders are also
+// emailed when email is configured.
+func (h *Hub) Remind(documentId, userId int, title string, dueDate time.Time) {
+	h.inRoom(documentId, func() {
+		for _, client := range h.GetDocumentClients(documentId) {
+			if client.UserId != userId {
+				continue
+			}
+			h.sendToClient(client, &Message{
+				Type:       "reminder",
+				DocumentId: documentId,
+				UserId:     userId,
+				Payload: map[string]interface{}{
+					"title":    title,
+					"due_date": dueDate.UTC().Format(time.RFC3339),
+				},
+			})
+		}
+	})
+}
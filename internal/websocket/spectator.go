@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"context"
+	"live-collab-api/internal/documents"
+	"log/slog"
+)
+
+// PermissionSpectator is given to connections watching a published document
+// they have no access to, including connections without a token. They
+// receive broadcasts but can't send anything except heartbeats, and are
+// counted as viewers rather than users in presence.
+const PermissionSpectator = "spectator"
+
+func (c *Client) isSpectator() bool {
+	return c.CurrentPermission() == PermissionSpectator
+}
+
+// relayDocumentUnpublished relays DocumentUnpublished to the other
+// instances. It is only applied there, never sent to clients.
+const relayDocumentUnpublished = "relay_document_unpublished"
+
+// DocumentUnpublished disconnects every spectator of the document, here and
+// on the other instances, telling each why before closing it.
+// Collaborators stay connected.
+func (h *Hub) DocumentUnpublished(documentId int) {
+	h.documentUnpublished(documentId)
+	h.publish(&Message{Type: relayDocumentUnpublished, DocumentId: documentId})
+}
+
+func (h *Hub) documentUnpublished(documentId int) {
+	h.inRoom(documentId, func() {
+		for _, client := range h.GetDocumentClients(documentId) {
+			if !client.isSpectator() {
+				continue
+			}
+
+			h.sendToClient(client, &Message{
+				Type:       "access_revoked",
+				DocumentId: documentId,
+				UserId:     client.UserId,
+				Payload: map[string]interface{}{
+					"reason": "This document is no longer published",
+				},
+			})
+			h.unregisterClient(client)
+		}
+	})
+}
+
+func (ws *WebSocketHandler) isPublished(ctx context.Context, documentId int) bool {
+	docService := &documents.DocumentService{DB: ws.DB}
+	published, err := docService.IsPublished(ctx, documentId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check whether document is published", "error", err)
+		return false
+	}
+	return published
+}
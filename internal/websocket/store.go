@@ -0,0 +1,548 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/sanitize"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errStoreClosed = errors.New("document store is closed")
+
+// errUnsafeContent is returned for edits that would add markup able to run
+// script to an HTML document.
+var errUnsafeContent = errors.New("edit adds unsafe markup")
+
+// editVersionTaken is the error claim_edit_version raises for an edit
+// whose version another edit of the document already took.
+const editVersionTaken = "edit version taken"
+
+// versionTaken reports whether err is an edit failing to persist because
+// another instance already stored an edit with its version.
+func versionTaken(err error) bool {
+	return err != nil && strings.Contains(err.Error(), editVersionTaken)
+}
+
+// storeQueryTimeout bounds the queries the store runs outside of any
+// request, so a stuck query can't block a document's goroutine forever.
+const storeQueryTimeout = 10 * time.Second
+
+// DocumentStore keeps the authoritative content and version of actively
+// edited documents in memory. Each document is owned by a single goroutine
+// that applies edits in order, persists every edit event, and writes the
+// content back to Postgres in batches. This replaces the per-edit
+// read-modify-write against the documents table.
+//
+// Several instances can hold the same document. Edits relayed from the
+// others are applied through ApplyRelayed. Should a copy still go stale,
+// e.g. when a relayed edit is lost, each edit claims its version from a
+// per-document counter in the database, so the instance fails to persist
+// its next edit, and only content newer than what is stored is written
+// back. Either way the instance reloads the document, which loses
+// nothing: every edit is persisted before it is applied.
+type DocumentStore struct {
+	DB *sql.DB
+	// Cache, when set, is updated with the content on every flush.
+	Cache *documents.Cache
+	// FlushInterval caps how long edits may stay unpersisted while a
+	// document is being actively edited.
+	FlushInterval time.Duration
+	// IdleTimeout flushes a document once no edits have arrived for this long.
+	IdleTimeout time.Duration
+	// EvictTimeout unloads a document once it has had no connected clients
+	// for this long.
+	EvictTimeout time.Duration
+
+	docs   map[int]*documentState
+	mutex  sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+type documentState struct {
+	id    int
+	store *DocumentStore
+
+	// Owned by the actor goroutine
+	content      string
+	html         bool
+	version      int
+	revision     int
+	flushed      int
+	firstPending time.Time
+	lastEdit     time.Time
+
+	// Guarded by store.mutex
+	refs        int
+	lastRelease time.Time
+
+	loadOnce sync.Once
+	loadErr  error
+	ops      chan func()
+	quit     chan struct{}
+}
+
+func NewDocumentStore(db *sql.DB, flushInterval, idleTimeout, evictTimeout time.Duration) *DocumentStore {
+	return &DocumentStore{
+		DB:            db,
+		FlushInterval: flushInterval,
+		IdleTimeout:   idleTimeout,
+		EvictTimeout:  evictTimeout,
+		docs:          make(map[int]*documentState),
+	}
+}
+
+// Acquire loads a document into memory if needed and pins it until the
+// matching Release. Connected clients hold a reference for their lifetime.
+func (s *DocumentStore) Acquire(documentId int) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return errStoreClosed
+	}
+
+	doc, exists := s.docs[documentId]
+	if !exists {
+		doc = &documentState{
+			id:    documentId,
+			store: s,
+			ops:   make(chan func()),
+			quit:  make(chan struct{}),
+		}
+		s.docs[documentId] = doc
+		s.wg.Add(1)
+		go doc.run()
+	}
+	doc.refs++
+	s.mutex.Unlock()
+
+	if err := doc.load(); err != nil {
+		s.mutex.Lock()
+		doc.refs--
+		// Drop the failed state so the next caller retries the load
+		if doc.refs == 0 && s.docs[documentId] == doc {
+			delete(s.docs, documentId)
+			close(doc.quit)
+		}
+		s.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Release drops a reference taken by Acquire. The document stays loaded
+// until it has been unreferenced for EvictTimeout.
+func (s *DocumentStore) Release(documentId int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if doc, exists := s.docs[documentId]; exists && doc.refs > 0 {
+		doc.refs--
+		if doc.refs == 0 {
+			doc.lastRelease = time.Now()
+		}
+	}
+}
+
+// Apply assigns the next version to an edit, persists it through persist,
+// and applies it to the in-memory content. Edits to the same document are
+// applied strictly one at a time. A nil edit, as sent for encrypted
+// documents, only takes the next version. Edits that would make an HTML
+// document unsafe are rejected with errUnsafeContent before persisting.
+func (s *DocumentStore) Apply(message *Message, edit *EditEvent, persist func(*Message) error) error {
+	if err := s.Acquire(message.DocumentId); err != nil {
+		return err
+	}
+	defer s.Release(message.DocumentId)
+
+	s.mutex.Lock()
+	doc := s.docs[message.DocumentId]
+	s.mutex.Unlock()
+
+	result := make(chan error, 1)
+	ok := doc.do(func() {
+		err := doc.apply(message, edit, persist)
+		if versionTaken(err) {
+			if err = doc.reload(); err == nil {
+				err = doc.apply(message, edit, persist)
+			}
+		}
+		result <- err
+	})
+	if !ok {
+		return errStoreClosed
+	}
+	return <-result
+}
+
+// apply numbers, persists, and applies an edit on the document's goroutine.
+func (d *documentState) apply(message *Message, edit *EditEvent, persist func(*Message) error) error {
+	content := d.content
+	if edit != nil {
+		// applyEdit clamps the edit, which must stay as sent for a retry
+		edit := *edit
+		content = applyEdit(d.content, &edit)
+		if d.makesUnsafe(content) {
+			return errUnsafeContent
+		}
+	}
+
+	message.Version = d.version + 1
+	if err := persist(message); err != nil {
+		return fmt.Errorf("failed to persist event: %v", err)
+	}
+
+	d.version = message.Version
+	if edit != nil {
+		now := time.Now()
+		if d.revision == d.flushed {
+			d.firstPending = now
+		}
+		d.content = content
+		d.revision++
+		d.lastEdit = now
+	}
+	return nil
+}
+
+// Rebase applies edits a client made against baseVersion, transforming
+// them over every edit applied since. Edits applied since are read through
+// since, and the rebased edits are numbered with the following versions
+// and persisted through persist, which returns how many it stored. Only
+// those are applied. Nothing else is applied to the document in between.
+// Edits from the first one that would make an HTML document unsafe on are
+// dropped, and errUnsafeContent is returned.
+func (s *DocumentStore) Rebase(documentId, baseVersion int, edits []EditEvent, since func(baseVersion int) ([]EditEvent, error), persist func([]*Message) (int, error), newMessage func(EditEvent) *Message) ([]*Message, error) {
+	if err := s.Acquire(documentId); err != nil {
+		return nil, err
+	}
+	defer s.Release(documentId)
+
+	s.mutex.Lock()
+	doc := s.docs[documentId]
+	s.mutex.Unlock()
+
+	type rebaseResult struct {
+		messages []*Message
+		err      error
+	}
+	result := make(chan rebaseResult, 1)
+	ok := doc.do(func() {
+		checked := func(messages []*Message) (int, error) {
+			content := doc.content
+			for i, message := range messages {
+				edit, _ := message.Payload.(EditEvent)
+				content = applyEdit(content, &edit)
+				if !doc.makesUnsafe(content) {
+					continue
+				}
+				if i == 0 {
+					return 0, errUnsafeContent
+				}
+				n, err := persist(messages[:i])
+				if err == nil {
+					err = errUnsafeContent
+				}
+				return n, err
+			}
+			return persist(messages)
+		}
+		rebase := func() ([]*Message, error) {
+			return rebaseEdits(doc.version, baseVersion, edits, since, checked, func(message *Message, edit *EditEvent) error {
+				now := time.Now()
+				if doc.revision == doc.flushed {
+					doc.firstPending = now
+				}
+				doc.content = applyEdit(doc.content, edit)
+				doc.version = message.Version
+				doc.revision++
+				doc.lastEdit = now
+				return nil
+			}, newMessage)
+		}
+		messages, err := rebase()
+		if len(messages) == 0 && versionTaken(err) {
+			if err = doc.reload(); err == nil {
+				messages, err = rebase()
+			}
+		}
+		result <- rebaseResult{messages, err}
+	})
+	if !ok {
+		return nil, errStoreClosed
+	}
+	res := <-result
+	return res.messages, res.err
+}
+
+// ApplyRelayed applies an edit another instance persisted and broadcast,
+// so the in-memory copy stays current without waiting for a failed insert
+// or flush to reveal it is stale. Edits already applied are ignored, and
+// the document is reloaded if some were missed. The instance that made the
+// edit writes it back, so it isn't counted as unflushed here. Documents
+// that aren't loaded are left alone; they are read fresh when loaded.
+func (s *DocumentStore) ApplyRelayed(message *Message) {
+	s.mutex.Lock()
+	doc, exists := s.docs[message.DocumentId]
+	s.mutex.Unlock()
+	if !exists || doc.load() != nil {
+		return
+	}
+
+	var edit EditEvent
+	if data, err := json.Marshal(message.Payload); err == nil {
+		// encrypted edits decode to no operation and only take the version
+		json.Unmarshal(data, &edit)
+	}
+
+	done := make(chan struct{})
+	ok := doc.do(func() {
+		defer close(done)
+		switch {
+		case message.Version <= doc.version:
+		case message.Version == doc.version+1:
+			doc.content = applyEdit(doc.content, &edit)
+			doc.version = message.Version
+		default:
+			doc.reload()
+		}
+	})
+	if ok {
+		<-done
+	}
+}
+
+// Snapshot returns the in-memory content and version of a loaded document.
+func (s *DocumentStore) Snapshot(documentId int) (string, int, bool) {
+	s.mutex.Lock()
+	doc, exists := s.docs[documentId]
+	s.mutex.Unlock()
+	if !exists || doc.load() != nil {
+		return "", 0, false
+	}
+
+	type snapshot struct {
+		content string
+		version int
+	}
+	result := make(chan snapshot, 1)
+	if !doc.do(func() { result <- snapshot{doc.content, doc.version} }) {
+		return "", 0, false
+	}
+	snap := <-result
+	return snap.content, snap.version, true
+}
+
+// Flush writes the content of a loaded document to the database now, if
+// it has unflushed edits.
+func (s *DocumentStore) Flush(documentId int) {
+	s.mutex.Lock()
+	doc, exists := s.docs[documentId]
+	s.mutex.Unlock()
+	if !exists {
+		return
+	}
+
+	done := make(chan struct{})
+	if doc.do(func() { doc.flush(true); close(done) }) {
+		<-done
+	}
+}
+
+// Close flushes every loaded document and stops their goroutines.
+func (s *DocumentStore) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	for _, doc := range s.docs {
+		close(doc.quit)
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (d *documentState) load() error {
+	d.loadOnce.Do(func() {
+		result := make(chan error, 1)
+		if !d.do(func() { result <- d.fetch() }) {
+			d.loadErr = errStoreClosed
+			return
+		}
+		d.loadErr = <-result
+	})
+	return d.loadErr
+}
+
+// fetch reads the document from the database: the stored content with the
+// edits persisted since it was written replayed on top, which is the
+// latest state whichever instance made them. Edits to encrypted documents
+// can't be applied, so their content is left as the stored snapshot.
+func (d *documentState) fetch() error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeQueryTimeout)
+	defer cancel()
+
+	var content, contentType string
+	var contentVersion int
+	var encrypted bool
+	err := d.store.DB.QueryRowContext(ctx, "SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1", d.id).Scan(&content, &contentType, &contentVersion, &encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to get document content: %v", err)
+	}
+
+	version, err := currentDocumentVersion(ctx, d.store.DB, d.id)
+	if err != nil {
+		return fmt.Errorf("failed to get document version: %v", err)
+	}
+	if version > contentVersion && !encrypted {
+		edits, err := queryEditsSince(ctx, d.store.DB, d.id, contentVersion)
+		if err != nil {
+			return fmt.Errorf("failed to get unflushed edits: %v", err)
+		}
+		for i := range edits {
+			content = applyEdit(content, &edits[i])
+		}
+	}
+
+	d.content = content
+	d.version = version
+	d.html = sanitize.IsHTML(contentType)
+	// anything unflushed was persisted as edits and is replayed above
+	d.flushed = d.revision
+	return nil
+}
+
+// reload replaces the document with its latest state, after another
+// instance was found to have edited it.
+func (d *documentState) reload() error {
+	slog.Info("Document changed on another instance, reloading", "document_id", d.id)
+	if err := d.fetch(); err != nil {
+		slog.Error("Failed to reload document", "document_id", d.id, "error", err)
+		return err
+	}
+	return nil
+}
+
+// makesUnsafe reports whether replacing the content of an HTML document
+// with content adds markup that can run script. Content that was already
+// unsafe, such as from before sanitizing, can still be edited, so it can
+// be cleaned up.
+func (d *documentState) makesUnsafe(content string) bool {
+	return d.html && sanitize.Unsafe(content) && !sanitize.Unsafe(d.content)
+}
+
+// do runs op on the document's goroutine. It returns false if the document
+// has already been shut down.
+func (d *documentState) do(op func()) bool {
+	select {
+	case d.ops <- op:
+		return true
+	case <-d.quit:
+		return false
+	}
+}
+
+func (d *documentState) run() {
+	defer d.store.wg.Done()
+
+	ticker := time.NewTicker(d.store.tickInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case op := <-d.ops:
+			op()
+
+		case <-ticker.C:
+			d.flush(false)
+			if d.evictIfIdle() {
+				return
+			}
+
+		case <-d.quit:
+			d.flush(true)
+			return
+		}
+	}
+}
+
+func (d *documentState) flush(force bool) {
+	if d.revision == d.flushed {
+		return
+	}
+
+	now := time.Now()
+	if !force && now.Sub(d.lastEdit) < d.store.IdleTimeout && now.Sub(d.firstPending) < d.store.FlushInterval {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeQueryTimeout)
+	defer cancel()
+
+	// only content newer than what is stored is written, so an instance
+	// holding a stale copy can't overwrite another's edits
+	result, err := d.store.DB.ExecContext(ctx, "UPDATE documents SET content = $1, content_version = $3, updated_at = NOW() WHERE id = $2 AND content_version < $3", d.content, d.id, d.version)
+	if err != nil {
+		slog.Error("Failed to flush document content", "document_id", d.id, "error", err)
+		return
+	}
+	d.flushed = d.revision
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		d.reload()
+		return
+	}
+	d.store.Cache.SetContent(ctx, d.id, d.content)
+}
+
+// evictIfIdle removes the document from the store when nobody references it,
+// everything has been flushed, and it has been idle for EvictTimeout.
+func (d *documentState) evictIfIdle() bool {
+	if d.revision != d.flushed {
+		return false
+	}
+
+	s := d.store
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if d.refs > 0 || s.closed || time.Since(d.lastRelease) < s.EvictTimeout {
+		return false
+	}
+
+	delete(s.docs, d.id)
+	close(d.quit)
+	return true
+}
+
+func (s *DocumentStore) tickInterval() time.Duration {
+	tick := s.IdleTimeout
+	if s.FlushInterval < tick {
+		tick = s.FlushInterval
+	}
+	tick /= 2
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	return tick
+}
+
+func currentDocumentVersion(ctx context.Context, db *sql.DB, documentId int) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version), 0)
+		FROM events
+		WHERE document_id = $1 AND event_type = 'edit'
+	`, documentId).Scan(&version)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,238 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"live-collab-api/internal/documents"
+	"live-collab-api/internal/telemetry"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSyncOperations bounds the number of offline edits accepted at once.
+const maxSyncOperations = 1000
+
+var errBaseVersionAhead = errors.New("base version is ahead of the document")
+
+type SyncRequest struct {
+	BaseVersion int                      `json:"base_version" example:"12"`
+	Operations  []map[string]interface{} `json:"operations" binding:"required"`
+}
+
+type SyncResponse struct {
+	BaseVersion int `json:"base_version" example:"12"`
+	// Version is the document version after the operations were applied.
+	Version int `json:"version" example:"17"`
+	// RebasedOver is how many edits made by others the operations were
+	// transformed over.
+	RebasedOver int `json:"rebased_over" example:"3"`
+	// Operations are the operations as applied, after transformation. A
+	// delete may be split in two, or dropped if others already deleted
+	// the same text.
+	Operations []EditEvent `json:"operations"`
+}
+
+// SyncOfflineEdits godoc
+// @Summary Submit offline edits
+// @Description Apply a batch of edits made offline against base_version. The edits are transformed over every edit made since, applied in order, and broadcast to connected clients. Encrypted documents can't be synced this way, since the server can't transform their edits; clients send them over the WebSocket instead.
+// @Tags collaboration
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Document ID"
+// @Param request body SyncRequest true "Base version and operations"
+// @Success 200 {object} SyncResponse "Operations applied"
+// @Failure 400 {object} documents.ErrorResponse "Invalid operations"
+// @Failure 401 {object} documents.ErrorResponse "Unauthorized - invalid or missing JWT token"
+// @Failure 403 {object} documents.ErrorResponse "Edit permission required"
+// @Failure 409 {object} documents.ErrorResponse "Base version is ahead of the document, or the document is encrypted"
+// @Failure 500 {object} documents.ErrorResponse "Internal server error"
+// @Router /api/documents/{id}/sync [post]
+func (ws *WebSocketHandler) SyncOfflineEdits(c *gin.Context) {
+	documentId, _ := documents.GetDocumentID(c)
+	userId := c.GetInt("userId")
+
+	if _, permission := ws.hasDocumentAccess(c.Request.Context(), userId, documentId); permission != "edit" && permission != "owner" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You need edit permission to modify this document"})
+		return
+	}
+
+	if encrypted, err := ws.isEncrypted(c.Request.Context(), documentId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document"})
+		return
+	} else if encrypted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Edits to encrypted documents can't be transformed on the server; send them over the WebSocket"})
+		return
+	}
+
+	var req SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.BaseVersion < 0 || len(req.Operations) > maxSyncOperations {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("base_version must be non-negative and at most %d operations are allowed", maxSyncOperations)})
+		return
+	}
+
+	edits := make([]EditEvent, 0, len(req.Operations))
+	for i, op := range req.Operations {
+		if msgErr := validateMessage(&Message{Type: "edit", Payload: op}); msgErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("operations[%d]: %s", i, msgErr.Message), "fields": msgErr.Fields})
+			return
+		}
+
+		var edit EditEvent
+		data, _ := json.Marshal(op)
+		if err := json.Unmarshal(data, &edit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("operations[%d] is malformed", i)})
+			return
+		}
+		edits = append(edits, edit)
+	}
+
+	newMessage := func(edit EditEvent) *Message {
+		return &Message{
+			Type:       "edit",
+			DocumentId: documentId,
+			UserId:     userId,
+			Payload:    edit,
+			Timestamp:  time.Now().Unix(),
+		}
+	}
+	ctx := c.Request.Context()
+	since := func(baseVersion int) ([]EditEvent, error) {
+		return ws.editsSince(ctx, documentId, baseVersion)
+	}
+	persist := func(messages []*Message) (int, error) {
+		return ws.persistEvents(ctx, messages)
+	}
+
+	messages, err := ws.Store.Rebase(documentId, req.BaseVersion, edits, since, persist, newMessage)
+
+	// Edits applied before a failure are kept, so collaborators must see them
+	for _, message := range messages {
+		ws.Hub.BroadcastMessage(message)
+	}
+
+	if errors.Is(err, errBaseVersionAhead) {
+		c.JSON(http.StatusConflict, gin.H{"error": "base_version is ahead of the document"})
+		return
+	}
+	if errors.Is(err, errUnsafeContent) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("operations[%d] adds markup that can run script", len(messages)), "code": ErrCodeUnsafeContent, "applied": len(messages)})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to sync offline edits", "applied", len(messages), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply operations", "applied": len(messages)})
+		return
+	}
+
+	response := SyncResponse{
+		BaseVersion: req.BaseVersion,
+		Operations:  make([]EditEvent, 0, len(messages)),
+	}
+	for _, message := range messages {
+		response.Operations = append(response.Operations, message.Payload.(EditEvent))
+	}
+	if len(messages) > 0 {
+		response.Version = messages[len(messages)-1].Version
+		response.RebasedOver = messages[0].Version - 1 - req.BaseVersion
+	} else if response.Version, err = ws.currentVersion(ctx, documentId); err == nil {
+		response.RebasedOver = response.Version - req.BaseVersion
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// rebaseEdits transforms edits made against baseVersion over the edits
+// applied since, numbers them from currentVersion, persists them through
+// persist, and applies the ones that were persisted in order. It returns
+// the messages of the edits that were applied.
+func rebaseEdits(currentVersion, baseVersion int, edits []EditEvent, since func(int) ([]EditEvent, error), persist func([]*Message) (int, error), apply func(*Message, *EditEvent) error, newMessage func(EditEvent) *Message) ([]*Message, error) {
+	if baseVersion > currentVersion {
+		return nil, errBaseVersionAhead
+	}
+
+	if baseVersion < currentVersion {
+		intervening, err := since(baseVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load intervening edits: %v", err)
+		}
+		edits, _ = transformEdits(edits, intervening, false)
+	}
+	if len(edits) == 0 {
+		return nil, nil
+	}
+
+	messages := make([]*Message, len(edits))
+	for i, edit := range edits {
+		messages[i] = newMessage(edit)
+		messages[i].Version = currentVersion + i + 1
+	}
+
+	persisted, err := persist(messages)
+	if err != nil {
+		err = fmt.Errorf("failed to persist event: %w", err)
+	}
+	for i := 0; i < persisted; i++ {
+		if applyErr := apply(messages[i], &edits[i]); applyErr != nil {
+			return messages[:i], applyErr
+		}
+		messages[i].Payload = edits[i]
+	}
+	return messages[:persisted], err
+}
+
+func (ws *WebSocketHandler) currentVersion(ctx context.Context, documentId int) (int, error) {
+	if _, version, ok := ws.Store.Snapshot(documentId); ok {
+		return version, nil
+	}
+	return ws.getCurrentDocumentVersion(ctx, documentId)
+}
+
+// editsSince loads the edits applied after baseVersion, in version order.
+func (ws *WebSocketHandler) editsSince(ctx context.Context, documentId, baseVersion int) (edits []EditEvent, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "SELECT", "events")
+	defer func() { telemetry.End(span, err) }()
+
+	return queryEditsSince(ctx, ws.DB, documentId, baseVersion)
+}
+
+func queryEditsSince(ctx context.Context, db *sql.DB, documentId, baseVersion int) ([]EditEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT payload FROM events
+		WHERE document_id = $1 AND event_type = 'edit' AND version > $2
+		ORDER BY version
+	`, documentId, baseVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edits []EditEvent
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var event struct {
+			Payload EditEvent `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse edit event: %v", err)
+		}
+		edits = append(edits, event.Payload)
+	}
+	return edits, rows.Err()
+}
@@ -0,0 +1,15 @@
+package websocket
+
+// TaskChanged tells everyone connected to the document that userId created,
+// updated, or deleted one of its tasks. Only connections to this instance
+// are reached; other clients see the change when they next list the tasks.
+func (h *Hub) TaskChanged(documentId, userId int, messageType string, payload map[string]interface{}) {
+	h.inRoom(documentId, func() {
+		h.broadcastToDocument(&Message{
+			Type:       messageType,
+			DocumentId: documentId,
+			UserId:     userId,
+			Payload:    payload,
+		})
+	})
+}
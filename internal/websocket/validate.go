@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrCodeValidationFailed is sent when a message doesn't match the schema
+// for its type. The error's Fields list every offending field.
+const ErrCodeValidationFailed = "validation_failed"
+
+const (
+	// maxDocumentPosition bounds positions and lengths in edits and cursors.
+	maxDocumentPosition  = 1 << 24
+	maxStringFieldLength = 4096
+)
+
+// FieldError describes a single invalid field in a message payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInteger
+	kindNumber
+	kindArray
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindString:
+		return "a string"
+	case kindInteger:
+		return "an integer"
+	case kindNumber:
+		return "a number"
+	default:
+		return "an array"
+	}
+}
+
+type fieldRule struct {
+	name     string
+	kind     fieldKind
+	required bool
+	// min and max bound integers and numbers, and the length of arrays
+	min, max float64
+	// maxLen bounds strings; zero means maxStringFieldLength
+	maxLen int
+	enum   []string
+}
+
+type messageSchema struct {
+	fields []fieldRule
+	// check runs cross-field rules once every field is individually valid
+	check func(payload map[string]interface{}) []FieldError
+}
+
+var messageSchemas = map[string]messageSchema{
+	"edit": {
+		fields: []fieldRule{
+			{name: "operation", kind: kindString, required: true, enum: []string{"insert", "delete"}},
+			{name: "position", kind: kindInteger, required: true, min: 0, max: maxDocumentPosition},
+			{name: "content", kind: kindString},
+			{name: "length", kind: kindInteger, min: 0, max: maxDocumentPosition},
+		},
+		check: checkEditPayload,
+	},
+	"cursor": {
+		fields: []fieldRule{
+			{name: "position", kind: kindInteger, min: 0, max: maxDocumentPosition},
+			{name: "selection_start", kind: kindInteger, min: 0, max: maxDocumentPosition},
+			{name: "selection_end", kind: kindInteger, min: 0, max: maxDocumentPosition},
+		},
+	},
+	"ping": {
+		fields: []fieldRule{
+			{name: "client_time", kind: kindNumber, min: 0, max: 1 << 53},
+		},
+	},
+	"pong": {
+		fields: []fieldRule{
+			{name: "sent_at", kind: kindNumber, required: true, min: 0, max: 1 << 53},
+		},
+	},
+	"viewed": {
+		fields: []fieldRule{
+			{name: "version", kind: kindInteger, required: true, min: 0, max: 1 << 31},
+		},
+	},
+	"hello": {
+		fields: []fieldRule{
+			{name: "client_name", kind: kindString, maxLen: 256},
+			{name: "platform", kind: kindString, maxLen: 256},
+			{name: "protocol_versions", kind: kindArray, min: 1, max: 16},
+		},
+	},
+	"token_refresh": {
+		fields: []fieldRule{
+			{name: "token", kind: kindString, required: true},
+		},
+	},
+}
+
+// validateMessage checks a message's payload against the schema for its
+// type. Types without a schema are left to the message handlers.
+func validateMessage(message *Message) *MessageError {
+	schema, exists := messageSchemas[message.Type]
+	if !exists {
+		return nil
+	}
+	return schema.validate(message)
+}
+
+func (schema messageSchema) validate(message *Message) *MessageError {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		if message.Payload != nil || schema.hasRequiredFields() {
+			return validationError([]FieldError{{Field: "payload", Message: "must be an object"}})
+		}
+		payload = map[string]interface{}{}
+	}
+
+	var fieldErrors []FieldError
+	for _, rule := range schema.fields {
+		if msg := rule.validate(payload); msg != "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: "payload." + rule.name, Message: msg})
+		}
+	}
+
+	if len(fieldErrors) == 0 && schema.check != nil {
+		fieldErrors = schema.check(payload)
+	}
+
+	if len(fieldErrors) > 0 {
+		return validationError(fieldErrors)
+	}
+	return nil
+}
+
+func validationError(fieldErrors []FieldError) *MessageError {
+	names := make([]string, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		names[i] = fe.Field
+	}
+
+	err := newMessageError(ErrCodeValidationFailed, "Invalid fields: %s", strings.Join(names, ", "))
+	err.Fields = fieldErrors
+	return err
+}
+
+func (s messageSchema) hasRequiredFields() bool {
+	for _, rule := range s.fields {
+		if rule.required {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns a description of what is wrong with the field, or "" if
+// it is valid.
+func (r fieldRule) validate(payload map[string]interface{}) string {
+	value, present := payload[r.name]
+	if !present || value == nil {
+		if r.required {
+			return "is required"
+		}
+		return ""
+	}
+
+	switch r.kind {
+	case kindString:
+		s, ok := value.(string)
+		if !ok {
+			return "must be " + r.kind.String()
+		}
+		maxLen := r.maxLen
+		if maxLen == 0 {
+			maxLen = maxStringFieldLength
+		}
+		if len(s) > maxLen {
+			return fmt.Sprintf("must be at most %d bytes", maxLen)
+		}
+		if len(r.enum) > 0 && !contains(r.enum, s) {
+			return "must be one of " + strings.Join(r.enum, ", ")
+		}
+
+	case kindInteger, kindNumber:
+		n, ok := value.(float64)
+		if !ok || (r.kind == kindInteger && n != float64(int64(n))) {
+			return "must be " + r.kind.String()
+		}
+		if n < r.min || n > r.max {
+			return fmt.Sprintf("must be between %d and %d", int64(r.min), int64(r.max))
+		}
+
+	case kindArray:
+		a, ok := value.([]interface{})
+		if !ok {
+			return "must be " + r.kind.String()
+		}
+		if float64(len(a)) < r.min || float64(len(a)) > r.max {
+			return fmt.Sprintf("must have between %d and %d items", int64(r.min), int64(r.max))
+		}
+	}
+	return ""
+}
+
+func checkEditPayload(payload map[string]interface{}) []FieldError {
+	switch payload["operation"] {
+	case "insert":
+		if content, _ := payload["content"].(string); content == "" {
+			return []FieldError{{Field: "payload.content", Message: "is required for insert"}}
+		}
+	case "delete":
+		if length, _ := payload["length"].(float64); length < 1 {
+			return []FieldError{{Field: "payload.length", Message: "must be at least 1 for delete"}}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
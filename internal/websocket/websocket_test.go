@@ -1,9 +1,13 @@
 package websocket
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"live-collab-api/internal/auth"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -13,6 +17,7 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
@@ -36,12 +41,24 @@ func setupWebSocketTest(t *testing.T) (*WebSocketHandler, sqlmock.Sqlmock, *gin.
 		Hub:         hub,
 		DB:          db,
 		AuthService: authService,
+		Store:       NewDocumentStore(db, time.Minute, time.Minute, time.Minute),
 	}
 
 	r := gin.Default()
 	return wsHandler, mock, r, authService, hub
 }
 
+// expectDocumentLoad expects the document store to load a document with
+// content and no edits.
+func expectDocumentLoad(mock sqlmock.Sqlmock, documentId int, content string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(documentId).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow(content, "text/plain", 0, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(documentId).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+}
+
 func TestHub_NewHub(t *testing.T) {
 	hub := NewHub()
 
@@ -286,7 +303,7 @@ func TestWebSocketHandler_HasDocumentAccess_Owner(t *testing.T) {
 		WithArgs(documentID).
 		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
 
-	hasAccess, permission := wsHandler.hasDocumentAccess(userID, documentID)
+	hasAccess, permission := wsHandler.hasDocumentAccess(context.Background(), userID, documentID)
 
 	if !hasAccess {
 		t.Error("Expected owner to have access")
@@ -317,7 +334,7 @@ func TestWebSocketHandler_HasDocumentAccess_Collaborator(t *testing.T) {
 		WithArgs(documentID, userID).
 		WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("edit"))
 
-	hasAccess, permission := wsHandler.hasDocumentAccess(userID, documentID)
+	hasAccess, permission := wsHandler.hasDocumentAccess(context.Background(), userID, documentID)
 
 	if !hasAccess {
 		t.Error("Expected collaborator to have access")
@@ -348,7 +365,11 @@ func TestWebSocketHandler_HasDocumentAccess_NoAccess(t *testing.T) {
 		WithArgs(documentID, userID).
 		WillReturnError(sql.ErrNoRows)
 
-	hasAccess, permission := wsHandler.hasDocumentAccess(userID, documentID)
+	mock.ExpectQuery(regexp.QuoteMeta("JOIN organization_members om ON o.id = om.organization_id")).
+		WithArgs(documentID, userID).
+		WillReturnError(sql.ErrNoRows)
+
+	hasAccess, permission := wsHandler.hasDocumentAccess(context.Background(), userID, documentID)
 
 	if hasAccess {
 		t.Error("Expected user to not have access")
@@ -363,17 +384,45 @@ func TestWebSocketHandler_HasDocumentAccess_NoAccess(t *testing.T) {
 	}
 }
 
-func TestWebSocketHandler_ApplyEdit_Insert(t *testing.T) {
-	wsHandler, _, _, _, _ := setupWebSocketTest(t)
-	defer wsHandler.DB.Close()
+// applyThroughStore applies edit to a document holding content the way
+// edits sent over the WebSocket are applied, and returns the new content.
+func applyThroughStore(t *testing.T, content string, edit *EditEvent) string {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+	expectDocumentLoad(mock, 1, content)
+	message := &Message{Type: "edit", DocumentId: 1, UserId: 1, Payload: *edit}
+	if err := store.Apply(message, edit, func(*Message) error { return nil }); err != nil {
+		t.Fatalf("Error applying edit: %v", err)
+	}
+	result, version, _ := store.Snapshot(1)
+	if version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1")).
+		WithArgs(result, 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	store.Close()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+	return result
+}
 
+func TestDocumentStore_Apply_Insert(t *testing.T) {
 	edit := &EditEvent{
 		Operation: "insert",
 		Position:  5,
 		Content:   "World",
 	}
 
-	result := wsHandler.applyEdit("Hello", edit)
+	result := applyThroughStore(t, "Hello", edit)
 	expected := "HelloWorld"
 
 	if result != expected {
@@ -381,17 +430,14 @@ func TestWebSocketHandler_ApplyEdit_Insert(t *testing.T) {
 	}
 }
 
-func TestWebSocketHandler_ApplyEdit_InsertMiddle(t *testing.T) {
-	wsHandler, _, _, _, _ := setupWebSocketTest(t)
-	defer wsHandler.DB.Close()
-
+func TestDocumentStore_Apply_InsertMiddle(t *testing.T) {
 	edit := &EditEvent{
 		Operation: "insert",
 		Position:  5,
 		Content:   " Beautiful",
 	}
 
-	result := wsHandler.applyEdit("Hello World", edit)
+	result := applyThroughStore(t, "Hello World", edit)
 	expected := "Hello Beautiful World"
 
 	if result != expected {
@@ -399,17 +445,14 @@ func TestWebSocketHandler_ApplyEdit_InsertMiddle(t *testing.T) {
 	}
 }
 
-func TestWebSocketHandler_ApplyEdit_Delete(t *testing.T) {
-	wsHandler, _, _, _, _ := setupWebSocketTest(t)
-	defer wsHandler.DB.Close()
-
+func TestDocumentStore_Apply_Delete(t *testing.T) {
 	edit := &EditEvent{
 		Operation: "delete",
 		Position:  5,
 		Length:    6,
 	}
 
-	result := wsHandler.applyEdit("Hello World", edit)
+	result := applyThroughStore(t, "Hello World", edit)
 	expected := "Hello"
 
 	if result != expected {
@@ -434,11 +477,11 @@ func TestWebSocketHandler_PersistEvent(t *testing.T) {
 		},
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload, created_at)")).
-		WithArgs(message.DocumentId, message.UserId, message.Type, sqlmock.AnyArg()).
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload, version, created_at)")).
+		WithArgs(message.DocumentId, message.UserId, message.Type, sqlmock.AnyArg(), message.Version).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	err := wsHandler.persistEvent(message)
+	err := wsHandler.persistEvent(context.Background(), message)
 
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
@@ -461,6 +504,13 @@ func TestWebSocketHandler_FullIntegration(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
 		WithArgs(documentID).
 		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(userID))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT encrypted FROM documents WHERE id = $1")).
+		WithArgs(documentID).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(username, ''), COALESCE(avatar_url, '') FROM users WHERE id = $1")).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "avatar_url"}).AddRow("alice", "https://example.com/alice.png"))
+	expectDocumentLoad(mock, documentID, "")
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		gin.SetMode(gin.TestMode)
@@ -487,8 +537,1674 @@ func TestWebSocketHandler_FullIntegration(t *testing.T) {
 	if count != 1 {
 		t.Errorf("Expected 1 active client, got %d", count)
 	}
+	for _, client := range hub.GetDocumentClients(documentID) {
+		if entry := client.presenceEntry(); entry.Username != "alice" {
+			t.Errorf("Expected username alice in presence, got %q", entry.Username)
+		} else if entry.AvatarURL != "https://example.com/alice.png" {
+			t.Errorf("Expected avatar URL in presence, got %q", entry.AvatarURL)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRebuildContent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT initial_content FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"initial_content"}).AddRow("Hello"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT payload FROM events")).
+		WithArgs(1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).
+			AddRow([]byte(`{"type": "edit", "version": 1, "payload": {"operation": "insert", "position": 5, "content": " World"}}`)).
+			AddRow([]byte(`{"type": "edit", "version": 2, "payload": {"operation": "delete", "position": 5, "length": 6}}`)).
+			AddRow([]byte(`{"type": "edit", "version": 3, "payload": {"operation": "insert", "position": 5, "content": "!"}}`)))
+
+	content, version, seeded, err := RebuildContent(context.Background(), db, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content != "Hello!" || version != 3 || !seeded {
+		t.Errorf("Expected 'Hello!' at version 3 from the initial content, got '%s' at version %d (seeded: %v)", content, version, seeded)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDocumentStore_AppliesEditsInMemory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow("Hello", "text/plain", 3, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+
+	if err := store.Acquire(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var persisted []int
+	persist := func(m *Message) error {
+		persisted = append(persisted, m.Version)
+		return nil
+	}
+
+	edits := []*EditEvent{
+		{Operation: "insert", Position: 5, Content: " World"},
+		{Operation: "insert", Position: 11, Content: "!"},
+	}
+	for _, edit := range edits {
+		if err := store.Apply(&Message{Type: "edit", DocumentId: 1}, edit, persist); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(persisted) != 2 || persisted[0] != 4 || persisted[1] != 5 {
+		t.Errorf("Expected versions [4 5], got %v", persisted)
+	}
+
+	content, version, ok := store.Snapshot(1)
+	if !ok {
+		t.Fatal("Expected document to be loaded")
+	}
+	if content != "Hello World!" || version != 5 {
+		t.Errorf("Expected 'Hello World!' at version 5, got '%s' at version %d", content, version)
+	}
+
+	// Both edits are written back with a single update on shutdown
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1, content_version = $3, updated_at = NOW() WHERE id = $2 AND content_version < $3")).
+		WithArgs("Hello World!", 1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store.Release(1)
+	store.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDocumentStore_ReloadsWhenEditedElsewhere(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+
+	expectLoad := func(content string, contentVersion, version int) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow(content, "text/plain", contentVersion, false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+	expectLoad("Hello", 3, 3)
+	if err := store.Acquire(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Another instance persisted version 4 but hasn't flushed it yet, so
+	// it is replayed onto the stored content
+	expectLoad("Hello", 3, 4)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT payload FROM events")).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).AddRow(`{"payload":{"operation":"insert","position":5,"content":" World"}}`))
+
+	var persisted []int
+	persist := func(m *Message) error {
+		if len(persisted) == 0 && m.Version == 4 {
+			persisted = append(persisted, 0)
+			return errors.New(`ERROR: edit version taken (SQLSTATE 23505)`)
+		}
+		persisted = append(persisted, m.Version)
+		return nil
+	}
+	if err := store.Apply(&Message{Type: "edit", DocumentId: 1}, &EditEvent{Operation: "insert", Position: 11, Content: "!"}, persist); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content, version, _ := store.Snapshot(1); content != "Hello World!" || version != 5 {
+		t.Errorf("Expected 'Hello World!' at version 5 after reloading, got '%s' at version %d", content, version)
+	}
+
+	// The other instance already stored newer content, so the flush
+	// writes nothing and the document is reloaded again
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1, content_version = $3, updated_at = NOW() WHERE id = $2 AND content_version < $3")).
+		WithArgs("Hello World!", 1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLoad("Hello World!?", 6, 6)
+	store.Flush(1)
+	if content, version, _ := store.Snapshot(1); content != "Hello World!?" || version != 6 {
+		t.Errorf("Expected 'Hello World!?' at version 6 after losing the flush, got '%s' at version %d", content, version)
+	}
+
+	store.Release(1)
+	store.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDocumentStore_AppliesRelayedEdits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+	hub := NewHub()
+	hub.Store = store
+
+	expectLoad := func(content string, version int) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow(content, "text/plain", version, false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+	expectLoad("Hello", 3)
+	if err := store.Acquire(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// relayed payloads arrive decoded from JSON
+	relayed := func(version int, payload map[string]interface{}) *Message {
+		return &Message{Type: "edit", DocumentId: 1, Version: version, Payload: payload}
+	}
+	hub.deliverRelayed(relayed(4, map[string]interface{}{"operation": "insert", "position": 5, "content": " World"}))
+	hub.deliverRelayed(relayed(4, map[string]interface{}{"operation": "insert", "position": 0, "content": "Again "}))
+	if content, version, _ := store.Snapshot(1); content != "Hello World" || version != 4 {
+		t.Errorf("Expected 'Hello World' at version 4, got '%s' at version %d", content, version)
+	}
+
+	// the next local edit builds on the relayed one without a retry
+	if err := store.Apply(&Message{Type: "edit", DocumentId: 1}, &EditEvent{Operation: "insert", Position: 11, Content: "!"}, func(*Message) error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content, version, _ := store.Snapshot(1); content != "Hello World!" || version != 5 {
+		t.Errorf("Expected 'Hello World!' at version 5, got '%s' at version %d", content, version)
+	}
+
+	// an edit was missed, so the document is reloaded
+	expectLoad("Hello World!?!", 7)
+	hub.deliverRelayed(relayed(7, map[string]interface{}{"operation": "insert", "position": 13, "content": "!"}))
+	if content, version, _ := store.Snapshot(1); content != "Hello World!?!" || version != 7 {
+		t.Errorf("Expected 'Hello World!?!' at version 7 after reloading, got '%s' at version %d", content, version)
+	}
+
+	store.Release(1)
+	store.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDocumentStore_RejectsUnsafeHTML(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow("<p>Hi</p>", "text/html", 0, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+
+	if err := store.Acquire(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	persisted := 0
+	persist := func(m *Message) error {
+		persisted++
+		return nil
+	}
+
+	// A tag typed halfway is fine; adding a handler to it isn't
+	if err := store.Apply(&Message{Type: "edit", DocumentId: 1}, &EditEvent{Operation: "insert", Position: 5, Content: "<img src=x "}, persist); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = store.Apply(&Message{Type: "edit", DocumentId: 1}, &EditEvent{Operation: "insert", Position: 16, Content: "onerror=alert(1)>"}, persist)
+	if !errors.Is(err, errUnsafeContent) {
+		t.Errorf("Expected errUnsafeContent, got %v", err)
+	}
+
+	content, version, _ := store.Snapshot(1)
+	if persisted != 1 || version != 1 || content != "<p>Hi<img src=x </p>" {
+		t.Errorf("Expected only the first edit applied, got %d persisted and '%s' at version %d", persisted, content, version)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	store.Release(1)
+	store.Close()
+}
+
+func TestDocumentStore_NumbersEncryptedEditsWithoutApplying(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, time.Minute, time.Minute)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow("ciphertext", "text/plain", 7, true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(7))
+
+	if err := store.Acquire(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	message := &Message{Type: "edit", DocumentId: 1, Payload: map[string]interface{}{"ciphertext": "AAEC", "key_version": 1.0}}
+	if err := encryptedEditSchema.validate(message); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if err := store.Apply(message, nil, func(*Message) error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	content, version, _ := store.Snapshot(1)
+	if content != "ciphertext" || version != 8 {
+		t.Errorf("Expected unchanged content at version 8, got '%s' at version %d", content, version)
+	}
+
+	// Nothing was applied, so nothing is written back
+	store.Release(1)
+	store.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+
+	if err := encryptedEditSchema.validate(&Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": 0.0, "content": "x"}}); err == nil {
+		t.Error("Expected a plaintext edit to be rejected for an encrypted document")
+	}
+}
+
+func TestDocumentStore_FlushesAndEvictsWhenIdle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewDocumentStore(db, time.Minute, 20*time.Millisecond, 20*time.Millisecond)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow("", "text/plain", 0, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1, content_version = $3, updated_at = NOW() WHERE id = $2 AND content_version < $3")).
+		WithArgs("Hi", 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	message := &Message{Type: "edit", DocumentId: 1}
+	edit := &EditEvent{Operation: "insert", Position: 0, Content: "Hi"}
+	if err := store.Apply(message, edit, func(*Message) error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, _, ok := store.Snapshot(1); ok {
+		t.Error("Expected idle document to be evicted")
+	}
+
+	store.Close()
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unfulfilled expectations: %s", err)
 	}
 }
+
+func TestHub_RoomPerDocument(t *testing.T) {
+	hub := NewHub()
+
+	client1 := &Client{ID: "client-1", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub}
+	client2 := &Client{ID: "client-2", DocumentId: 2, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hub}
+
+	hub.Register(client1)
+	hub.Register(client2)
+
+	hub.mutex.RLock()
+	rooms := len(hub.rooms)
+	hub.mutex.RUnlock()
+	if rooms != 2 {
+		t.Errorf("Expected 2 rooms, got %d", rooms)
+	}
+
+	// Drain the connected messages
+	<-client1.Send
+	<-client2.Send
+
+	hub.BroadcastMessage(&Message{Type: "cursor", DocumentId: 2, UserId: 2})
+
+	select {
+	case <-client2.Send:
+	case <-time.After(time.Second):
+		t.Error("Client 2 did not receive broadcast")
+	}
+
+	select {
+	case msg := <-client1.Send:
+		t.Errorf("Client 1 received a message for another document: %s", msg)
+	default:
+	}
+
+	hub.Unregister(client1)
+	hub.Unregister(client2)
+	time.Sleep(50 * time.Millisecond)
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	if len(hub.rooms) != 0 {
+		t.Errorf("Expected empty rooms to be shut down, %d remain", len(hub.rooms))
+	}
+}
+
+func TestHub_RevokeAccess(t *testing.T) {
+	hub := NewHub()
+
+	owner := &Client{ID: "owner", DocumentId: 1, UserId: 1, Permission: "owner", Send: make(chan []byte, 256), Hub: hub}
+	collaborator := &Client{ID: "collaborator", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hub}
+
+	hub.Register(owner)
+	hub.Register(collaborator)
+	hub.RevokeAccess(1, 2)
+	time.Sleep(50 * time.Millisecond)
+
+	if count := hub.GetDocumentClientCount(1); count != 1 {
+		t.Errorf("Expected 1 remaining client, got %d", count)
+	}
+
+	var types []string
+	for msg := range collaborator.Send {
+		var receivedMsg Message
+		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
+			t.Fatalf("Error unmarshaling message: %v", err)
+		}
+		types = append(types, receivedMsg.Type)
+	}
+
+	if len(types) == 0 || types[len(types)-1] != "access_revoked" {
+		t.Errorf("Expected last message to be 'access_revoked', got %v", types)
+	}
+}
+
+func TestHub_DocumentUnpublished(t *testing.T) {
+	hub := NewHub()
+
+	owner := &Client{ID: "owner", DocumentId: 1, UserId: 1, Permission: "owner", Send: make(chan []byte, 256), Hub: hub}
+	spectator := &Client{ID: "spectator", DocumentId: 1, Permission: PermissionSpectator, Send: make(chan []byte, 256), Hub: hub}
+
+	hub.Register(owner)
+	hub.Register(spectator)
+	hub.DocumentUnpublished(1)
+	time.Sleep(50 * time.Millisecond)
+
+	if count := hub.GetDocumentClientCount(1); count != 1 {
+		t.Errorf("Expected the owner to stay connected, got %d clients", count)
+	}
+
+	var types []string
+	for msg := range spectator.Send {
+		var receivedMsg Message
+		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
+			t.Fatalf("Error unmarshaling message: %v", err)
+		}
+		types = append(types, receivedMsg.Type)
+	}
+
+	if len(types) == 0 || types[len(types)-1] != "access_revoked" {
+		t.Errorf("Expected last message to be 'access_revoked', got %v", types)
+	}
+}
+
+func TestHub_SessionRevoked(t *testing.T) {
+	hub := NewHub()
+
+	laptop := &Client{ID: "laptop", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub, sessionId: 5}
+	phone := &Client{ID: "phone", DocumentId: 2, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub, sessionId: 6}
+	other := &Client{ID: "other", DocumentId: 2, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub, sessionId: 5}
+
+	hub.Register(laptop)
+	hub.Register(phone)
+	hub.Register(other)
+	time.Sleep(50 * time.Millisecond)
+	hub.SessionRevoked(5)
+	time.Sleep(50 * time.Millisecond)
+
+	if count := hub.GetDocumentClientCount(1); count != 0 {
+		t.Errorf("Expected no clients left on document 1, got %d", count)
+	}
+	if clients := hub.GetDocumentClients(2); len(clients) != 1 || clients[0].ID != "phone" {
+		t.Errorf("Expected only the other session's client on document 2, got %d clients", len(clients))
+	}
+
+	for _, client := range []*Client{laptop, other} {
+		var last Message
+		for msg := range client.Send {
+			json.Unmarshal(msg, &last)
+		}
+		if last.Type != "access_revoked" {
+			t.Errorf("Expected %s's last message to be 'access_revoked', got '%s'", client.ID, last.Type)
+		}
+	}
+}
+
+func TestHub_PermissionChanged(t *testing.T) {
+	hub := NewHub()
+
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hub}
+	hub.Register(client)
+	<-client.Send
+
+	hub.PermissionChanged(1, 2, "view")
+
+	select {
+	case msg := <-client.Send:
+		var receivedMsg Message
+		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
+			t.Fatalf("Error unmarshaling message: %v", err)
+		}
+		if receivedMsg.Type != "permission_changed" {
+			t.Errorf("Expected 'permission_changed' message, got '%s'", receivedMsg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client did not receive permission_changed message")
+	}
+
+	if client.canEdit() {
+		t.Error("Expected client to lose edit permission")
+	}
+}
+
+func TestHub_EditorLimit(t *testing.T) {
+	hub := NewHub()
+
+	register := func(id string, userId int, permission string) *Client {
+		client := &Client{ID: id, DocumentId: 1, UserId: userId, Permission: permission, maxEditors: 2, Send: make(chan []byte, 256), Hub: hub}
+		hub.Register(client)
+		<-client.Send
+		return client
+	}
+
+	register("owner", 1, "owner")
+	register("editor", 2, "edit")
+	second := register("editor-2", 2, "edit")
+	capped := register("late", 3, "edit")
+
+	if !second.canEdit() {
+		t.Error("Expected another connection of a user already editing to keep edit permission")
+	}
+	if capped.canEdit() {
+		t.Fatal("Expected the third user to join as a viewer")
+	}
+
+	select {
+	case msg := <-capped.Send:
+		var receivedMsg Message
+		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
+			t.Fatalf("Error unmarshaling message: %v", err)
+		}
+		if receivedMsg.Type != "editor_limit_reached" {
+			t.Errorf("Expected 'editor_limit_reached' message, got '%s'", receivedMsg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client did not receive editor_limit_reached message")
+	}
+}
+
+func TestHub_SlowClientDropOldest(t *testing.T) {
+	hub := NewHub()
+	hub.SlowClientPolicy = SlowClientDropOldest
+
+	// The connected message fills the send buffer
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 1), ephemeral: make(chan []byte, 2), Hub: hub}
+	hub.Register(client)
+
+	for i := 0; i < 5; i++ {
+		hub.BroadcastMessage(&Message{Type: "cursor", DocumentId: 1, UserId: 2, Payload: i})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if count := hub.GetDocumentClientCount(1); count != 1 {
+		t.Fatalf("Expected slow client to stay connected, got %d clients", count)
+	}
+
+	var receivedMsg Message
+	if err := json.Unmarshal(<-client.ephemeral, &receivedMsg); err != nil {
+		t.Fatalf("Error unmarshaling message: %v", err)
+	}
+	if receivedMsg.Payload != float64(3) {
+		t.Errorf("Expected oldest cursor updates to be dropped, got payload %v", receivedMsg.Payload)
+	}
+
+	hub.BroadcastMessage(&Message{Type: "edit", DocumentId: 1, UserId: 2})
+	time.Sleep(50 * time.Millisecond)
+
+	if count := hub.GetDocumentClientCount(1); count != 0 {
+		t.Errorf("Expected slow client to be closed on a full send buffer, got %d clients", count)
+	}
+	if client.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("Expected close code %d, got %d", websocket.CloseTryAgainLater, client.closeCode)
+	}
+
+	stats := hub.SlowClientStats()
+	if stats.DroppedMessages != 3 {
+		t.Errorf("Expected 3 dropped messages, got %d", stats.DroppedMessages)
+	}
+	if stats.Disconnects != 1 {
+		t.Errorf("Expected 1 slow client disconnect, got %d", stats.Disconnects)
+	}
+}
+
+func TestOriginPolicy_Allowed(t *testing.T) {
+	policy := &OriginPolicy{AllowedOrigins: ParseOrigins("https://app.example.com, https://*.collab.dev,localhost:3000")}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://app.example.com", true},
+		{"HTTPS://App.Example.com/", true},
+		{"http://app.example.com", false},
+		{"https://evil.example.com", false},
+		{"https://team.collab.dev", true},
+		{"https://a.b.collab.dev", true},
+		{"https://collab.dev", false},
+		{"https://evilcollab.dev", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.origin); got != tt.allowed {
+			t.Errorf("Allowed(%q) = %v, expected %v", tt.origin, got, tt.allowed)
+		}
+	}
+
+	if (&OriginPolicy{}).Allowed("https://app.example.com") {
+		t.Error("Expected an empty allowlist to reject browser origins")
+	}
+	if !(&OriginPolicy{AllowAll: true}).Allowed("https://anything.test") {
+		t.Error("Expected AllowAll to accept any origin")
+	}
+
+	policy.Update(ParseOrigins("https://new.example.com"), false)
+	if policy.Allowed("https://app.example.com") || !policy.Allowed("https://new.example.com") {
+		t.Error("Expected Update to replace the allowlist")
+	}
+	if (*OriginPolicy)(nil).Allowed("https://app.example.com") {
+		t.Error("Expected a nil policy to reject browser origins")
+	}
+}
+
+func TestWebSocketHandler_ProtocolNegotiation(t *testing.T) {
+	ws := &WebSocketHandler{Hub: NewHub()}
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 1, Send: make(chan []byte, 256)}
+
+	if version := client.ProtocolVersion(); version != CurrentProtocolVersion {
+		t.Errorf("Expected default protocol version %d, got %d", CurrentProtocolVersion, version)
+	}
+
+	err := ws.handleHello(client, &Message{Type: "hello", Payload: map[string]interface{}{
+		"protocol_versions": []interface{}{float64(1), float64(7)},
+	}})
+	if err != nil {
+		t.Fatalf("Expected negotiation to succeed, got %v", err)
+	}
+	if version := client.ProtocolVersion(); version != ProtocolV1 {
+		t.Errorf("Expected protocol version %d, got %d", ProtocolV1, version)
+	}
+
+	var receivedMsg Message
+	if err := json.Unmarshal(<-client.Send, &receivedMsg); err != nil {
+		t.Fatalf("Error unmarshaling message: %v", err)
+	}
+	if receivedMsg.Type != "protocol" {
+		t.Errorf("Expected 'protocol' message, got '%s'", receivedMsg.Type)
+	}
+
+	// Version 1 clients don't understand error frames or message ids
+	client.sendError(newMessageError(ErrCodeInvalidPayload, "bad"), "msg-1")
+	if len(client.Send) != 0 {
+		t.Error("Expected error frame to be suppressed for protocol version 1")
+	}
+
+	data, _ := encodeMessage(&Message{Type: "edit", MessageId: "msg-1"}, ProtocolV1)
+	if strings.Contains(string(data), "message_id") {
+		t.Errorf("Expected message_id to be omitted for protocol version 1, got %s", data)
+	}
+
+	err = ws.handleHello(client, &Message{Type: "hello", Payload: map[string]interface{}{
+		"protocol_versions": []interface{}{float64(7)},
+	}})
+	if err == nil || err.Code != ErrCodeUnsupportedProtocol {
+		t.Errorf("Expected %s error, got %v", ErrCodeUnsupportedProtocol, err)
+	}
+}
+
+func TestWebSocketHandler_ConnectionLimits(t *testing.T) {
+	wsHandler, _, r, _, _ := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	wsHandler.Limiter = NewConnectionLimiter(2, 1)
+	r.GET("/ws/:document_id", wsHandler.HandleWebSocket)
+
+	if !wsHandler.Limiter.Acquire("192.0.2.1") {
+		t.Fatal("Expected first connection from IP to be allowed")
+	}
+
+	req, _ := http.NewRequest("GET", "/ws/1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// A rejected upgrade from another IP must give its slot back
+	req, _ = http.NewRequest("GET", "/ws/1", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if !wsHandler.Limiter.Acquire("198.51.100.7") {
+		t.Error("Expected slot to be released after a failed upgrade")
+	}
+	if wsHandler.Limiter.Acquire("203.0.113.9") {
+		t.Error("Expected global limit to reject a third connection")
+	}
+
+	stats := wsHandler.Limiter.Stats()
+	if stats.Open != 2 || stats.Rejected != 2 {
+		t.Errorf("Expected 2 open and 2 rejected, got %d open and %d rejected", stats.Open, stats.Rejected)
+	}
+}
+
+func TestHub_ReapStaleClients(t *testing.T) {
+	hub := NewHub()
+	hub.StaleTimeout = time.Minute
+
+	ghost := &Client{ID: "ghost", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub, ConnectedAt: time.Now().Add(-5 * time.Minute)}
+	active := &Client{ID: "active", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hub, ConnectedAt: time.Now().Add(-5 * time.Minute)}
+	active.touch()
+
+	hub.Register(ghost)
+	hub.Register(active)
+	time.Sleep(50 * time.Millisecond)
+
+	hub.reapStaleClients()
+	time.Sleep(50 * time.Millisecond)
+
+	clients := hub.GetDocumentClients(1)
+	if len(clients) != 1 || clients[0].ID != "active" {
+		t.Errorf("Expected only the active client to remain, got %d clients", len(clients))
+	}
+	if reaped := hub.StaleClientsReaped(); reaped != 1 {
+		t.Errorf("Expected 1 reaped client, got %d", reaped)
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *Message
+		fields  []string
+	}{
+		{"valid insert", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": 3.0, "content": "hi"}}, nil},
+		{"valid delete", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "delete", "position": 0.0, "length": 2.0}}, nil},
+		{"missing fields", &Message{Type: "edit", Payload: map[string]interface{}{}}, []string{"payload.operation", "payload.position"}},
+		{"wrong types", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": "3", "content": 5.0}}, []string{"payload.position", "payload.content"}},
+		{"negative position", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": -1.0, "content": "x"}}, []string{"payload.position"}},
+		{"fractional position", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": 1.5, "content": "x"}}, []string{"payload.position"}},
+		{"unknown operation", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "move", "position": 0.0}}, []string{"payload.operation"}},
+		{"empty insert", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "insert", "position": 0.0}}, []string{"payload.content"}},
+		{"zero-length delete", &Message{Type: "edit", Payload: map[string]interface{}{"operation": "delete", "position": 0.0}}, []string{"payload.length"}},
+		{"payload not an object", &Message{Type: "edit", Payload: "insert"}, []string{"payload"}},
+		{"cursor without payload", &Message{Type: "cursor"}, nil},
+		{"missing token", &Message{Type: "token_refresh"}, []string{"payload"}},
+		{"no schema", &Message{Type: "custom", Payload: 1.0}, nil},
+	}
+
+	for _, tt := range tests {
+		err := validateMessage(tt.message)
+		if tt.fields == nil {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", tt.name, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Code != ErrCodeValidationFailed {
+			t.Errorf("%s: expected %s error, got %v", tt.name, ErrCodeValidationFailed, err)
+			continue
+		}
+
+		var fields []string
+		for _, fe := range err.Fields {
+			fields = append(fields, fe.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected invalid fields %v, got %v", tt.name, tt.fields, fields)
+		}
+	}
+}
+
+func TestWebSocketHandler_RelaysYjsAwareness(t *testing.T) {
+	hub := NewHub()
+	ws := &WebSocketHandler{Hub: hub}
+
+	alice := &Client{ID: "alice", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), binary: make(chan []byte, 4), Hub: hub}
+	bob := &Client{ID: "bob", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), binary: make(chan []byte, 4), Hub: hub}
+	hub.Register(alice)
+	hub.Register(bob)
+	time.Sleep(50 * time.Millisecond)
+
+	update := []byte{yjsMessageAwareness, 0x05, 0x01, 0x02, 0x03}
+	if err := ws.handleBinaryMessage(alice, update); err != nil {
+		t.Fatalf("Expected awareness update to be relayed, got %v", err)
+	}
+
+	select {
+	case frame := <-bob.binary:
+		if string(frame) != string(update) {
+			t.Errorf("Expected relayed frame %v, got %v", update, frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bob did not receive the awareness update")
+	}
+
+	if len(alice.binary) != 0 {
+		t.Error("Expected sender not to receive its own awareness update")
+	}
+
+	// Bob asks for the current awareness states and gets Alice's
+	if err := ws.handleBinaryMessage(bob, []byte{yjsMessageQueryAwareness}); err != nil {
+		t.Fatalf("Expected awareness query to succeed, got %v", err)
+	}
+	if frame := <-bob.binary; string(frame) != string(update) {
+		t.Errorf("Expected Alice's awareness state, got %v", frame)
+	}
+
+	if err := ws.handleBinaryMessage(alice, []byte{yjsMessageSync, 0x00}); err == nil || err.Code != ErrCodeUnsupportedBinary {
+		t.Errorf("Expected %s error for sync frames, got %v", ErrCodeUnsupportedBinary, err)
+	}
+}
+
+// memoryRelay relays between hubs in the same process, standing in for
+// Redis or Postgres.
+type memoryRelay struct {
+	hub   *Hub
+	peers *[]*memoryRelay
+}
+
+func (r *memoryRelay) PublishMessage(message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	for _, peer := range *r.peers {
+		if peer != r {
+			// each instance decodes its own copy, as from Redis or Postgres
+			var relayed Message
+			json.Unmarshal(data, &relayed)
+			peer.hub.deliverRelayed(&relayed)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRelay) PublishAwareness(documentId int, data []byte) error {
+	for _, peer := range *r.peers {
+		if peer != r {
+			peer.hub.BroadcastAwareness(documentId, data, "")
+		}
+	}
+	return nil
+}
+
+func TestHub_RelaysBroadcastsBetweenInstances(t *testing.T) {
+	var peers []*memoryRelay
+	hubs := []*Hub{NewHub(), NewHub()}
+	for _, hub := range hubs {
+		relay := &memoryRelay{hub: hub, peers: &peers}
+		peers = append(peers, relay)
+		hub.Relay = relay
+	}
+
+	alice := &Client{ID: "alice", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hubs[0]}
+	bob := &Client{ID: "bob", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hubs[1]}
+	hubs[1].Register(bob)
+	<-bob.Send // connected
+
+	received := func(messageType string) *Message {
+		t.Helper()
+		select {
+		case data := <-bob.Send:
+			var message Message
+			json.Unmarshal(data, &message)
+			if message.Type != messageType {
+				t.Fatalf("Expected %s, got %s", messageType, data)
+			}
+			return &message
+		case <-time.After(time.Second):
+			t.Fatalf("Bob did not receive %s from the other instance", messageType)
+			return nil
+		}
+	}
+
+	hubs[0].Register(alice)
+	if join := received("user_join"); join.UserId != 1 {
+		t.Errorf("Expected Alice's join, got user %d", join.UserId)
+	}
+
+	hubs[0].BroadcastMessage(&Message{Type: "edit", DocumentId: 1, UserId: 1, Version: 4, Payload: EditEvent{Operation: "insert", Position: 0, Content: "Hi"}})
+	if edit := received("edit"); edit.Version != 4 {
+		t.Errorf("Expected the edit at version 4, got %d", edit.Version)
+	}
+
+	hubs[0].Unregister(alice)
+	received("user_leave")
+
+	hubs[1].Unregister(bob)
+}
+
+func TestHub_RelaysAccessChangesBetweenInstances(t *testing.T) {
+	var peers []*memoryRelay
+	hubs := []*Hub{NewHub(), NewHub()}
+	for _, hub := range hubs {
+		relay := &memoryRelay{hub: hub, peers: &peers}
+		peers = append(peers, relay)
+		hub.Relay = relay
+	}
+
+	bob := &Client{ID: "bob", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), Hub: hubs[1]}
+	hubs[1].Register(bob)
+	<-bob.Send // connected
+
+	hubs[0].PermissionChanged(1, 2, "view")
+	select {
+	case data := <-bob.Send:
+		var message Message
+		json.Unmarshal(data, &message)
+		if message.Type != "permission_changed" {
+			t.Fatalf("Expected permission_changed, got %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bob did not receive permission_changed from the other instance")
+	}
+	if bob.canEdit() {
+		t.Error("Expected Bob to lose edit permission on the other instance")
+	}
+
+	hubs[0].RevokeAccess(1, 2)
+	time.Sleep(50 * time.Millisecond)
+	if count := hubs[1].GetDocumentClientCount(1); count != 0 {
+		t.Errorf("Expected Bob to be disconnected on the other instance, %d clients remain", count)
+	}
+	var last Message
+	for data := range bob.Send {
+		json.Unmarshal(data, &last)
+	}
+	if last.Type != "access_revoked" {
+		t.Errorf("Expected Bob's last message to be 'access_revoked', got '%s'", last.Type)
+	}
+}
+
+func TestHub_RelaysSessionRevocationBetweenInstances(t *testing.T) {
+	var peers []*memoryRelay
+	hubs := []*Hub{NewHub(), NewHub()}
+	for _, hub := range hubs {
+		relay := &memoryRelay{hub: hub, peers: &peers}
+		peers = append(peers, relay)
+		hub.Relay = relay
+	}
+
+	laptop := &Client{ID: "laptop", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hubs[1], sessionId: 5}
+	spectator := &Client{ID: "spectator", DocumentId: 1, Permission: PermissionSpectator, Send: make(chan []byte, 256), Hub: hubs[1]}
+	hubs[1].Register(laptop)
+	hubs[1].Register(spectator)
+	time.Sleep(50 * time.Millisecond)
+
+	hubs[0].SessionRevoked(5)
+	time.Sleep(50 * time.Millisecond)
+
+	if clients := hubs[1].GetDocumentClients(1); len(clients) != 1 || clients[0].ID != "spectator" {
+		t.Errorf("Expected only the spectator to stay connected on the other instance, got %d clients", len(clients))
+	}
+	var last Message
+	for data := range laptop.Send {
+		json.Unmarshal(data, &last)
+	}
+	if last.Type != "access_revoked" {
+		t.Errorf("Expected the laptop's last message to be 'access_revoked', got '%s'", last.Type)
+	}
+}
+
+func TestHub_RelaysUnpublishBetweenInstances(t *testing.T) {
+	var peers []*memoryRelay
+	hubs := []*Hub{NewHub(), NewHub()}
+	for _, hub := range hubs {
+		relay := &memoryRelay{hub: hub, peers: &peers}
+		peers = append(peers, relay)
+		hub.Relay = relay
+	}
+
+	owner := &Client{ID: "owner", DocumentId: 1, UserId: 1, Permission: "owner", Send: make(chan []byte, 256), Hub: hubs[1]}
+	spectator := &Client{ID: "spectator", DocumentId: 1, Permission: PermissionSpectator, Send: make(chan []byte, 256), Hub: hubs[1]}
+	hubs[1].Register(owner)
+	hubs[1].Register(spectator)
+	time.Sleep(50 * time.Millisecond)
+
+	hubs[0].DocumentUnpublished(1)
+	time.Sleep(50 * time.Millisecond)
+
+	if clients := hubs[1].GetDocumentClients(1); len(clients) != 1 || clients[0].ID != "owner" {
+		t.Errorf("Expected only the owner to stay connected on the other instance, got %d clients", len(clients))
+	}
+	var last Message
+	for data := range spectator.Send {
+		json.Unmarshal(data, &last)
+	}
+	if last.Type != "access_revoked" {
+		t.Errorf("Expected the spectator's last message to be 'access_revoked', got '%s'", last.Type)
+	}
+}
+
+func TestNotifyRelay_DeliversOtherInstancesUpdates(t *testing.T) {
+	hub := NewHub()
+	relay := NewNotifyRelay(nil, hub)
+	other := NewNotifyRelay(nil, hub)
+
+	bob := &Client{ID: "bob", DocumentId: 1, UserId: 2, Permission: "edit", Send: make(chan []byte, 256), binary: make(chan []byte, 4), Hub: hub}
+	hub.Register(bob)
+	time.Sleep(50 * time.Millisecond)
+
+	update := []byte{yjsMessageAwareness, 0x05, 0x01}
+	own, _ := json.Marshal(notifyEnvelope{Origin: relay.instanceId, DocumentId: 1, Awareness: update})
+	relay.handleNotification(own)
+	if len(bob.binary) != 0 {
+		t.Fatal("Expected the relay to ignore its own notifications")
+	}
+
+	foreign, _ := json.Marshal(notifyEnvelope{Origin: other.instanceId, DocumentId: 1, Awareness: update})
+	relay.handleNotification(foreign)
+	select {
+	case frame := <-bob.binary:
+		if string(frame) != string(update) {
+			t.Errorf("Expected relayed frame %v, got %v", update, frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bob did not receive the awareness update from the other instance")
+	}
+
+	if err := relay.PublishAwareness(1, make([]byte, maxNotifyPayload)); err == nil {
+		t.Error("Expected an awareness frame over the NOTIFY limit to be refused")
+	}
+}
+
+func TestTransformEdits_Converges(t *testing.T) {
+	base := "Hello world"
+
+	tests := []struct {
+		name   string
+		client []EditEvent
+		server []EditEvent
+	}{
+		{"inserts at same position", []EditEvent{{Operation: "insert", Position: 5, Content: ","}}, []EditEvent{{Operation: "insert", Position: 5, Content: "!"}}},
+		{"insert inside deleted range", []EditEvent{{Operation: "insert", Position: 3, Content: "XY"}}, []EditEvent{{Operation: "delete", Position: 1, Length: 6}}},
+		{"delete spanning insert", []EditEvent{{Operation: "delete", Position: 2, Length: 6}}, []EditEvent{{Operation: "insert", Position: 4, Content: "abc"}}},
+		{"overlapping deletes", []EditEvent{{Operation: "delete", Position: 0, Length: 5}}, []EditEvent{{Operation: "delete", Position: 3, Length: 5}}},
+		{"identical deletes", []EditEvent{{Operation: "delete", Position: 6, Length: 5}}, []EditEvent{{Operation: "delete", Position: 6, Length: 5}}},
+		{"batches", []EditEvent{
+			{Operation: "insert", Position: 0, Content: ">> "},
+			{Operation: "delete", Position: 3, Length: 6},
+			{Operation: "insert", Position: 5, Content: "!"},
+		}, []EditEvent{
+			{Operation: "delete", Position: 0, Length: 6},
+			{Operation: "insert", Position: 5, Content: "s"},
+		}},
+	}
+
+	for _, tt := range tests {
+		client, server := transformEdits(tt.client, tt.server, false)
+
+		serverFirst := applyEdits(base, append(tt.server, client...))
+		clientFirst := applyEdits(base, append(tt.client, server...))
+		if serverFirst != clientFirst {
+			t.Errorf("%s: documents diverged: %q vs %q", tt.name, serverFirst, clientFirst)
+		}
+	}
+}
+
+func TestTransformEdits_ConvergesForRandomEdits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		base := randomText(rng, rng.Intn(12))
+		client := randomEdits(rng, base, 1+rng.Intn(4))
+		server := randomEdits(rng, base, 1+rng.Intn(4))
+
+		if err := checkConvergence(base, client, server, rng.Intn(2) == 0); err != "" {
+			t.Fatalf("base %q, client %+v, server %+v: %s", base, client, server, err)
+		}
+	}
+}
+
+func FuzzTransformEdits(f *testing.F) {
+	f.Add("Hello world", int64(1))
+	f.Add("", int64(2))
+	f.Add("héllo wörld 😀", int64(3))
+
+	f.Fuzz(func(t *testing.T, base string, seed int64) {
+		// edits count runes, so invalid UTF-8 is read as U+FFFD
+		base = string([]rune(base))
+		rng := rand.New(rand.NewSource(seed))
+		client := randomEdits(rng, base, 1+rng.Intn(6))
+		server := randomEdits(rng, base, 1+rng.Intn(6))
+
+		for _, opsWin := range []bool{false, true} {
+			if err := checkConvergence(base, client, server, opsWin); err != "" {
+				t.Fatalf("client %+v, server %+v, opsWin %v: %s", client, server, opsWin, err)
+			}
+		}
+	})
+}
+
+func FuzzApplyEdit(f *testing.F) {
+	f.Add("Hello", "insert", 5, "World", 0)
+	f.Add("Hello World", "delete", 5, "", 6)
+	f.Add("Hi", "delete", 0, "", 10)
+	f.Add("héllo 😀", "delete", -3, "", -2)
+	f.Add("héllo 😀", "insert", 100, "wörld", 0)
+	f.Add("\xff\xfe", "insert", 1, "\x80", 0)
+
+	f.Fuzz(func(t *testing.T, content, operation string, position int, text string, length int) {
+		original := []rune(content)
+		edit := EditEvent{Operation: operation, Position: position, Content: text, Length: length}
+		got := applyEdit(content, &edit)
+		result := []rune(got)
+
+		switch operation {
+		case "insert":
+			inserted := len([]rune(text))
+			if edit.Position < 0 || edit.Position > len(original) {
+				t.Fatalf("Insert position %d outside content of %d runes", edit.Position, len(original))
+			}
+			if len(result) != len(original)+inserted {
+				t.Fatalf("Expected %d runes after insert, got %d", len(original)+inserted, len(result))
+			}
+			restored := applyEdit(string(result), &EditEvent{Operation: "delete", Position: edit.Position, Length: inserted})
+			if restored != string(original) {
+				t.Fatalf("Deleting the inserted text gave %q, expected %q", restored, string(original))
+			}
+
+		case "delete":
+			removed := len(original) - len(result)
+			if removed < 0 || removed > max(length, 0) {
+				t.Fatalf("Delete of %d runes removed %d", length, removed)
+			}
+			if removed > 0 && string(result) != string(original[:edit.Position])+string(original[edit.Position+removed:]) {
+				t.Fatalf("Delete at %d removed the wrong runes: %q from %q", edit.Position, string(result), content)
+			}
+
+		default:
+			if got != content {
+				t.Fatalf("Unknown operation %q changed the content", operation)
+			}
+		}
+	})
+}
+
+// applyEdits applies edits to content in order.
+func applyEdits(content string, edits []EditEvent) string {
+	for _, edit := range edits {
+		content = applyEdit(content, &edit)
+	}
+	return content
+}
+
+// applyEditsInBounds is like applyEdits but fails when an edit reaches
+// past the end of the content it applies to, which applyEdit would
+// otherwise silently clamp.
+func applyEditsInBounds(content string, edits []EditEvent) (string, string) {
+	for _, edit := range edits {
+		length := runeLen(content)
+		if edit.Position < 0 || edit.Position > length || edit.Length < 0 ||
+			(edit.Operation == "delete" && edit.Position+edit.Length > length) {
+			return "", fmt.Sprintf("edit %+v out of bounds for %q", edit, content)
+		}
+		content = applyEdit(content, &edit)
+	}
+	return content, ""
+}
+
+// checkConvergence transforms two concurrent edit lists over each other and
+// reports how applying them in either order went wrong, if it did.
+func checkConvergence(base string, client, server []EditEvent, opsWin bool) string {
+	clientAfter, serverAfter := transformEdits(client, server, opsWin)
+
+	serverFirst, err := applyEditsInBounds(applyEdits(base, server), clientAfter)
+	if err != "" {
+		return err
+	}
+	clientFirst, err := applyEditsInBounds(applyEdits(base, client), serverAfter)
+	if err != "" {
+		return err
+	}
+	if serverFirst != clientFirst {
+		return fmt.Sprintf("documents diverged: %q vs %q", serverFirst, clientFirst)
+	}
+	return ""
+}
+
+// randomEdits returns n edits that each apply in bounds to content as left
+// by the ones before it.
+func randomEdits(rng *rand.Rand, content string, n int) []EditEvent {
+	length := runeLen(content)
+	edits := make([]EditEvent, 0, n)
+	for i := 0; i < n; i++ {
+		if length > 0 && rng.Intn(2) == 0 {
+			position := rng.Intn(length)
+			edit := EditEvent{Operation: "delete", Position: position, Length: 1 + rng.Intn(length-position)}
+			edits = append(edits, edit)
+			length -= edit.Length
+			continue
+		}
+
+		text := randomText(rng, 1+rng.Intn(4))
+		edits = append(edits, EditEvent{Operation: "insert", Position: rng.Intn(length + 1), Content: text})
+		length += runeLen(text)
+	}
+	return edits
+}
+
+// randomAlphabet mixes ASCII with multi-byte runes, an emoji outside the
+// BMP, and a combining accent.
+var randomAlphabet = []rune("ab \néü€世😀\u0301")
+
+func randomText(rng *rand.Rand, n int) string {
+	runes := make([]rune, n)
+	for i := range runes {
+		runes[i] = randomAlphabet[rng.Intn(len(randomAlphabet))]
+	}
+	return string(runes)
+}
+
+func TestWebSocketHandler_SyncOfflineEdits(t *testing.T) {
+	wsHandler, mock, r, _, _ := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	wsHandler.Store = NewDocumentStore(wsHandler.DB, time.Minute, time.Minute, time.Minute)
+
+	r.POST("/documents/:id/sync", func(c *gin.Context) {
+		c.Set("userId", 1)
+		c.Set("documentId", 1)
+		wsHandler.SyncOfflineEdits(c)
+	})
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(content, ''), COALESCE(content_type, ''), content_version, encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "content_type", "content_version", "encrypted"}).AddRow("Hi Hello world", "text/plain", 3, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0)")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	// Someone else inserted "Hi " while the client was offline at version 2
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT payload FROM events")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).
+			AddRow(`{"type":"edit","version":3,"payload":{"operation":"insert","position":0,"content":"Hi "}}`))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events (document_id, user_id, event_type, payload, version, created_at)")).
+		WithArgs(1, 1, "edit", sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := `{"base_version": 2, "operations": [{"operation": "insert", "position": 5, "content": ", there"}]}`
+	req, _ := http.NewRequest("POST", "/documents/1/sync", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response SyncResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshaling response: %v", err)
+	}
+
+	if response.Version != 4 || response.RebasedOver != 1 {
+		t.Errorf("Expected version 4 rebased over 1 edit, got version %d rebased over %d", response.Version, response.RebasedOver)
+	}
+	if len(response.Operations) != 1 || response.Operations[0].Position != 8 {
+		t.Errorf("Expected the insert to move to position 8, got %+v", response.Operations)
+	}
+
+	if content, _, _ := wsHandler.Store.Snapshot(1); content != "Hi Hello, there world" {
+		t.Errorf("Expected 'Hi Hello, there world', got '%s'", content)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE documents SET content = $1, content_version = $3, updated_at = NOW() WHERE id = $2 AND content_version < $3")).
+		WithArgs("Hi Hello, there world", 1, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	wsHandler.Store.Close()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRebaseEdits_AppliesOnlyPersistedEdits(t *testing.T) {
+	edits := []EditEvent{
+		{Operation: "insert", Position: 0, Content: "a"},
+		{Operation: "insert", Position: 1, Content: "b"},
+		{Operation: "insert", Position: 2, Content: "c"},
+	}
+	newMessage := func(edit EditEvent) *Message {
+		return &Message{Type: "edit", DocumentId: 1, Payload: edit}
+	}
+
+	var persistedVersions []int
+	persist := func(messages []*Message) (int, error) {
+		for _, message := range messages {
+			persistedVersions = append(persistedVersions, message.Version)
+		}
+		return 2, errors.New("connection reset")
+	}
+	content := ""
+	apply := func(message *Message, edit *EditEvent) error {
+		content = applyEdit(content, edit)
+		return nil
+	}
+
+	messages, err := rebaseEdits(4, 4, edits, nil, persist, apply, newMessage)
+	if err == nil {
+		t.Error("Expected the persistence error to be returned")
+	}
+	if len(persistedVersions) != 3 || persistedVersions[0] != 5 || persistedVersions[2] != 7 {
+		t.Errorf("Expected versions 5 to 7 to be persisted in one call, got %v", persistedVersions)
+	}
+	if len(messages) != 2 || content != "ab" {
+		t.Errorf("Expected only the 2 persisted edits to be applied, got %d messages and content %q", len(messages), content)
+	}
+}
+
+func TestClient_HandleTokenRefresh(t *testing.T) {
+	authService := &auth.AuthService{JWTSecret: "test-secret"}
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 1, Send: make(chan []byte, 256), tokenRefresh: make(chan time.Time, 1)}
+
+	token, _ := auth.GenerateJWT(1, authService.JWTSecret)
+	client.handleTokenRefresh(context.Background(), authService, &Message{Type: "token_refresh", Payload: map[string]interface{}{"token": token}})
+
+	select {
+	case expiresAt := <-client.tokenRefresh:
+		if time.Until(expiresAt) < time.Hour {
+			t.Errorf("Expected refreshed expiry in the future, got %v", expiresAt)
+		}
+	default:
+		t.Fatal("Expected refreshed expiry to be queued")
+	}
+
+	var receivedMsg Message
+	if err := json.Unmarshal(<-client.Send, &receivedMsg); err != nil {
+		t.Fatalf("Error unmarshaling message: %v", err)
+	}
+	if receivedMsg.Type != "token_refreshed" {
+		t.Errorf("Expected 'token_refreshed' message, got '%s'", receivedMsg.Type)
+	}
+
+	// A token for somebody else must not extend this connection
+	otherToken, _ := auth.GenerateJWT(2, authService.JWTSecret)
+	client.handleTokenRefresh(context.Background(), authService, &Message{Type: "token_refresh", Payload: map[string]interface{}{"token": otherToken}})
+
+	select {
+	case <-client.tokenRefresh:
+		t.Error("Expected token for another user to be rejected")
+	default:
+	}
+}
+
+func TestWebSocketHandler_ClosesOnTokenExpiry(t *testing.T) {
+	wsHandler, mock, _, authService, _ := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 1,
+		"exp":     time.Now().Add(2 * time.Second).Unix(),
+	}).SignedString([]byte(authService.JWTSecret))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT owner_id FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT encrypted FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(false))
+	expectDocumentLoad(mock, 1, "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		c.Params = gin.Params{{Key: "document_id", Value: "1"}}
+		wsHandler.HandleWebSocket(c)
+	}))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Add("Authorization", "Bearer "+token)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sawReauth := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("Expected policy violation close, got %v", err)
+			}
+			break
+		}
+		if strings.Contains(string(data), `"type":"reauth_required"`) {
+			sawReauth = true
+		}
+	}
+
+	if !sawReauth {
+		t.Error("Expected reauth_required before the connection was closed")
+	}
+}
+
+func TestWebSocketHandler_SpectatorOnPublishedDocument(t *testing.T) {
+	wsHandler, mock, _, _, hub := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT published FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"published"}).AddRow(true))
+	expectDocumentLoad(mock, 1, "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		c.Params = gin.Params{{Key: "document_id", Value: "1"}}
+		wsHandler.HandleWebSocket(c)
+	}))
+	defer server.Close()
+
+	// No Authorization header at all
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	var msg Message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+		t.Fatalf("Expected connected message, got %+v (%v)", msg, err)
+	}
+
+	conn.WriteJSON(Message{Type: "cursor", MessageId: "c-1", Payload: map[string]interface{}{"position": 1}})
+	var frame struct {
+		Type    string       `json:"type"`
+		Payload MessageError `json:"payload"`
+	}
+	if err := conn.ReadJSON(&frame); err != nil || frame.Payload.Code != ErrCodePermissionDenied {
+		t.Errorf("Expected permission_denied for spectator message, got %+v (%v)", frame, err)
+	}
+
+	hub.BroadcastMessage(&Message{Type: "edit", DocumentId: 1, UserId: 2})
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "edit" {
+		t.Errorf("Expected spectator to receive broadcasts, got %+v (%v)", msg, err)
+	}
+
+	clients := hub.GetDocumentClients(1)
+	if len(clients) != 1 || clients[0].CurrentPermission() != PermissionSpectator || clients[0].UserId != 0 {
+		t.Errorf("Expected a single anonymous spectator, got %d clients", len(clients))
+	}
+}
+
+func TestWebSocketHandler_RejectsAnonymousOnPrivateDocument(t *testing.T) {
+	wsHandler, mock, r, _, _ := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT published FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"published"}).AddRow(false))
+
+	r.GET("/ws/:document_id", wsHandler.HandleWebSocket)
+
+	req, _ := http.NewRequest("GET", "/ws/1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestClientInfoFromUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  ClientInfo
+	}{
+		{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15", ClientInfo{Name: "web", Platform: "iPad"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", ClientInfo{Name: "web", Platform: "Windows"}},
+		{"CollabDesktop/2.1 (Macintosh)", ClientInfo{Name: "CollabDesktop", Platform: "macOS"}},
+		{"", ClientInfo{}},
+	}
+
+	for _, tt := range tests {
+		if got := clientInfoFromUserAgent(tt.userAgent); got != tt.expected {
+			t.Errorf("clientInfoFromUserAgent(%q) = %+v, expected %+v", tt.userAgent, got, tt.expected)
+		}
+	}
+}
+
+func TestWebSocketHandler_GetPresence(t *testing.T) {
+	wsHandler, _, r, _, hub := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	hub.Register(&Client{ID: "client-1", DocumentId: 1, UserId: 1, Permission: "owner", Send: make(chan []byte, 256), Hub: hub, Info: ClientInfo{Name: "web", Platform: "iPad"}})
+	hub.Register(&Client{ID: "client-2", DocumentId: 1, UserId: 1, Permission: "owner", Send: make(chan []byte, 256), Hub: hub, Info: ClientInfo{Name: "web", Platform: "macOS"}})
+	time.Sleep(50 * time.Millisecond)
+
+	r.GET("/documents/:id/presence", func(c *gin.Context) {
+		c.Set("documentId", 1)
+		wsHandler.GetPresence(c)
+	})
+
+	req, _ := http.NewRequest("GET", "/documents/1/presence", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response PresenceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshaling response: %v", err)
+	}
+
+	if response.ActiveUsers != 1 || len(response.Clients) != 2 {
+		t.Errorf("Expected 1 user on 2 clients, got %d users on %d clients", response.ActiveUsers, len(response.Clients))
+	}
+}
+
+func TestClient_HandlePong(t *testing.T) {
+	hub := NewHub()
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 1, Permission: "edit", Send: make(chan []byte, 256), Hub: hub}
+	hub.Register(client)
+	<-client.Send
+
+	sentAt := time.Now().Add(-40 * time.Millisecond).UnixMilli()
+	client.handlePong(&Message{Type: "pong", Payload: map[string]interface{}{"sent_at": float64(sentAt)}})
+
+	var receivedMsg Message
+	if err := json.Unmarshal(<-client.Send, &receivedMsg); err != nil {
+		t.Fatalf("Error unmarshaling message: %v", err)
+	}
+	if receivedMsg.Type != "latency" {
+		t.Errorf("Expected 'latency' message, got '%s'", receivedMsg.Type)
+	}
+
+	entry := client.presenceEntry()
+	if entry.RTTMs == nil || *entry.RTTMs < 40 {
+		t.Errorf("Expected RTT of at least 40ms, got %v", entry.RTTMs)
+	}
+
+	stats := hub.LatencyStats()
+	if stats.Clients != 1 || stats.Measured != 1 || stats.MaxMs < 40 {
+		t.Errorf("Unexpected latency stats: %+v", stats)
+	}
+}
+
+func TestWebSocketHandler_ErrorFrames(t *testing.T) {
+	wsHandler, mock, _, _, hub := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	client := &Client{ID: "client-1", DocumentId: 1, UserId: 2, Permission: "view", Send: make(chan []byte, 256), Hub: hub}
+
+	tests := []struct {
+		message *Message
+		code    string
+	}{
+		{&Message{Type: "edit", MessageId: "m-1", Payload: map[string]interface{}{"operation": "insert"}}, ErrCodePermissionDenied},
+		{&Message{Type: "bogus", MessageId: "m-2"}, ErrCodeUnknownType},
+		{&Message{Type: "viewed", MessageId: "m-3", Payload: map[string]interface{}{"version": -1.0}}, ErrCodeValidationFailed},
+	}
+
+	for _, tt := range tests {
+		msgErr := wsHandler.handleMessage(context.Background(), client, tt.message)
+		if msgErr == nil {
+			t.Fatalf("Expected %s error for %s message", tt.code, tt.message.Type)
+		}
+		client.sendError(msgErr, tt.message.MessageId)
+
+		var frame struct {
+			Type    string       `json:"type"`
+			Payload MessageError `json:"payload"`
+		}
+		if err := json.Unmarshal(<-client.Send, &frame); err != nil {
+			t.Fatalf("Error unmarshaling error frame: %v", err)
+		}
+
+		if frame.Type != "error" || frame.Payload.Code != tt.code || frame.Payload.Ref != tt.message.MessageId {
+			t.Errorf("Expected error frame with code %s and ref %s, got %+v", tt.code, tt.message.MessageId, frame)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWebSocketHandler_Drain(t *testing.T) {
+	wsHandler, mock, _, _, hub := setupWebSocketTest(t)
+	defer wsHandler.DB.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT published FROM documents WHERE id = $1")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"published"}).AddRow(true))
+	expectDocumentLoad(mock, 1, "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		c.Params = gin.Params{{Key: "document_id", Value: "1"}}
+		wsHandler.HandleWebSocket(c)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	var msg Message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "connected" {
+		t.Fatalf("Expected connected message, got %+v (%v)", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	drained := make(chan int, 1)
+	go func() { drained <- wsHandler.Drain(ctx) }()
+
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "reconnect" {
+		t.Fatalf("Expected reconnect message, got %+v (%v)", msg, err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected service restart close, got %v", err)
+	}
+
+	if n := <-drained; n != 1 {
+		t.Errorf("Expected 1 drained client, got %d", n)
+	}
+	if clients, _ := hub.GetClientCount(); clients != 0 {
+		t.Errorf("Expected no clients after draining, got %d", clients)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected new connections to be refused with %d while draining", http.StatusServiceUnavailable)
+	}
+}